@@ -2,9 +2,16 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/salmanrf/capybara-cloud/internal/application"
+	"github.com/salmanrf/capybara-cloud/internal/runtime"
 	"github.com/salmanrf/capybara-cloud/pkg/dto"
 	"github.com/salmanrf/capybara-cloud/pkg/utils"
 )
@@ -19,8 +26,39 @@ type AppHandlers interface {
 	HandleUpdate(w http.ResponseWriter, r *http.Request)
 	HandleCreateConfig(w http.ResponseWriter, r *http.Request)
 	HandleFindOneConfig(w http.ResponseWriter, r *http.Request)
+	HandleCreateDeployment(w http.ResponseWriter, r *http.Request)
+	HandleListDeployments(w http.ResponseWriter, r *http.Request)
+	HandleFindOneDeployment(w http.ResponseWriter, r *http.Request)
+	HandleRollbackDeployment(w http.ResponseWriter, r *http.Request)
+	HandleCancelDeployment(w http.ResponseWriter, r *http.Request)
+	HandleUpdateCanary(w http.ResponseWriter, r *http.Request)
+	HandlePromoteCanary(w http.ResponseWriter, r *http.Request)
+	HandleAbortCanary(w http.ResponseWriter, r *http.Request)
+	HandleUpdateHealthCheck(w http.ResponseWriter, r *http.Request)
+	HandleUpdateResources(w http.ResponseWriter, r *http.Request)
+	HandleUpdateScale(w http.ResponseWriter, r *http.Request)
+	HandleStop(w http.ResponseWriter, r *http.Request)
+	HandleStart(w http.ResponseWriter, r *http.Request)
+	HandleRestart(w http.ResponseWriter, r *http.Request)
+	HandleFindDeploymentLogs(w http.ResponseWriter, r *http.Request)
+	HandleUpdateWebhook(w http.ResponseWriter, r *http.Request)
+	HandleFindOneWebhook(w http.ResponseWriter, r *http.Request)
+	HandleListWebhookDeliveries(w http.ResponseWriter, r *http.Request)
+	HandleReceiveWebhook(w http.ResponseWriter, r *http.Request)
+	HandleUpdateSchedule(w http.ResponseWriter, r *http.Request)
+	HandleListJobRuns(w http.ResponseWriter, r *http.Request)
+	HandleFindJobRunLogs(w http.ResponseWriter, r *http.Request)
 }
 
+// Bundles bigger than this are spooled to disk by the multipart reader
+const max_bundle_memory = 32 << 20
+
+// Room the multipart envelope and the other form fields get next to the bundle
+const max_form_overhead = 1 << 20
+
+// Comment frames sent while following logs so proxies keep idle streams open
+const log_stream_heartbeat = 15 * time.Second
+
 func NewAppHandlers(app_service application.Service) AppHandlers {
 	return &app_handler{
 		app_service,
@@ -117,6 +155,8 @@ func (h *app_handler) HandleCreate(w http.ResponseWriter, r *http.Request) {
 				nil,
 				"insufficient permission to create app on this project", 
 			)
+		} else if err.Error() == "name_taken" {
+			utils.ResponseWithError(w, http.StatusConflict, nil, "an application of this project already goes by this name")
 		} else {
 			utils.ResponseWithError(w, http.StatusInternalServerError, nil, "internal server error")
 		}
@@ -192,6 +232,15 @@ func (h *app_handler) HandleUpdate(w http.ResponseWriter, r *http.Request) {
 			)
 			return
 		}
+		if errmsg == "name_taken" {
+			utils.ResponseWithError(
+				w,
+				http.StatusConflict,
+				nil,
+				"An application of this project already goes by this name",
+			)
+			return
+		}
 		utils.ResponseWithError(
 				w,
 				http.StatusInternalServerError,
@@ -272,6 +321,7 @@ func (h *app_handler) HandleCreateConfig(w http.ResponseWriter, r *http.Request)
 		AppID: app_cfg.AppID.String(),
 		VariablesJson: string(app_cfg.VariablesJson),
 		ConfigVariables: body.Variables,
+		Stack: app_cfg.Stack.String,
 		CreatedAt: app_cfg.CreatedAt.Time,
 		UpdatedAt: app_cfg.UpdatedAt.Time,
 	}
@@ -326,4 +376,1297 @@ func (h *app_handler) HandleFindOneConfig(w http.ResponseWriter, r *http.Request
 		config,
 		"Application config retrieved successfully",
 	)
-}
\ No newline at end of file
+}
+
+// respond_too_large answers 413 when reading the request ran past its limit
+func respond_too_large(w http.ResponseWriter, err error, max_upload_size int64) bool {
+	var max_bytes_err *http.MaxBytesError
+	if !errors.As(err, &max_bytes_err) {
+		return false
+	}
+
+	utils.ResponseWithError(
+		w,
+		http.StatusRequestEntityTooLarge,
+		map[string]any{"reason": runtime.BundleReasonUploadTooLarge, "limit": max_upload_size},
+		fmt.Sprintf("invalid bundle: larger than %d bytes", max_upload_size),
+	)
+	return true
+}
+
+func (h *app_handler) HandleCreateDeployment(w http.ResponseWriter, r *http.Request) {
+	app_id := r.PathValue("app_id")
+	user_id, _ := r.Context().Value("user_id").(string)
+
+	var body dto.CreateApplicationDeploymentDto
+
+	max_upload_size := h.app_service.MaxUploadSize()
+	r.Body = http.MaxBytesReader(w, r.Body, max_upload_size+max_form_overhead)
+
+	// git deployments are plain json, bundles are uploaded as multipart
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		decoder := json.NewDecoder(r.Body)
+		if err := decoder.Decode(&body); err != nil {
+			if respond_too_large(w, err, max_upload_size) {
+				return
+			}
+			utils.ResponseWithError(
+				w,
+				http.StatusUnprocessableEntity,
+				nil,
+				"unprocessable entity",
+			)
+			return
+		}
+	} else {
+		if err := r.ParseMultipartForm(max_bundle_memory); err != nil {
+			if respond_too_large(w, err, max_upload_size) {
+				return
+			}
+			utils.ResponseWithError(
+				w,
+				http.StatusUnprocessableEntity,
+				nil,
+				"unprocessable entity, expected multipart/form-data or application/json",
+			)
+			return
+		}
+		defer r.MultipartForm.RemoveAll()
+
+		bundle, bundle_header, err := r.FormFile("bundle")
+		if err == nil {
+			defer bundle.Close()
+			body.Bundle = bundle
+			body.BundleName = bundle_header.Filename
+			body.BundleSize = bundle_header.Size
+		}
+
+		if value := r.FormValue("canary_weight"); value != "" {
+			weight, err := strconv.ParseInt(value, 10, 32)
+			if err != nil {
+				utils.ResponseWithError(
+					w,
+					http.StatusUnprocessableEntity,
+					nil,
+					"unprocessable entity, canary_weight must be a number",
+				)
+				return
+			}
+			canary_weight := int32(weight)
+			body.CanaryWeight = &canary_weight
+			body.CanarySticky, _ = strconv.ParseBool(r.FormValue("canary_sticky"))
+		}
+	}
+
+	if _, err := body.Validate(); err != nil {
+		utils.ResponseWithError(w, http.StatusBadRequest, nil, err.Error())
+		return
+	}
+
+	deployment, err := h.app_service.CreateDeployment(
+		app_id,
+		user_id,
+		body,
+	)
+	if err != nil {
+		var bundle_err *runtime.BundleError
+		if errors.As(err, &bundle_err) {
+			status := http.StatusBadRequest
+			if bundle_err.Reason == runtime.BundleReasonUploadTooLarge {
+				status = http.StatusRequestEntityTooLarge
+			}
+			utils.ResponseWithError(
+				w,
+				status,
+				bundle_err.Context(),
+				bundle_err.Error(),
+			)
+			return
+		}
+
+		errmsg := err.Error()
+		if errmsg == "permission_denied" {
+			utils.ResponseWithError(
+				w,
+				http.StatusForbidden,
+				nil,
+				"Insufficient permission to deploy application",
+			)
+			return
+		}
+		if errmsg == "not_found" {
+			utils.ResponseWithError(
+				w,
+				http.StatusNotFound,
+				nil,
+				"Not found",
+			)
+			return
+		}
+		if errmsg == "invalid_app_type" {
+			utils.ResponseWithError(
+				w,
+				http.StatusConflict,
+				nil,
+				"Only applications listening on a port can be released as canaries",
+			)
+			return
+		}
+		if errmsg == "canary_in_progress" {
+			utils.ResponseWithError(
+				w,
+				http.StatusConflict,
+				nil,
+				"Promote or abort the current canary before releasing another one",
+			)
+			return
+		}
+
+		utils.ResponseWithError(
+			w,
+			http.StatusInternalServerError,
+			nil,
+			"Internal server error",
+		)
+		return
+	}
+
+	utils.ResponseWithSuccess(
+		w,
+		http.StatusCreated,
+		dto.NewApplicationDeploymentResponse(*deployment),
+		"Deployment created successfully",
+	)
+}
+
+func (h *app_handler) HandleListDeployments(w http.ResponseWriter, r *http.Request) {
+	app_id := r.PathValue("app_id")
+	user_id, _ := r.Context().Value("user_id").(string)
+
+	deployments, err := h.app_service.FindDeployments(app_id, user_id)
+
+	if err != nil {
+		errmsg := err.Error()
+		switch errmsg {
+		case "permission_denied":
+			utils.ResponseWithError(
+				w,
+				http.StatusForbidden,
+				nil,
+				"Insufficient permission to access application deployments",
+			)
+			return
+		case "not_found":
+			utils.ResponseWithError(
+				w,
+				http.StatusNotFound,
+				nil,
+				"Application not found",
+			)
+			return
+		default:
+			utils.ResponseWithError(
+				w,
+				http.StatusInternalServerError,
+				nil,
+				"Internal server error",
+			)
+			return
+		}
+	}
+
+	response := dto.NewListApplicationDeploymentResponse(deployments)
+
+	utils.ResponseWithSuccess(
+		w,
+		http.StatusOK,
+		&response,
+		"Application deployments retrieved successfully",
+	)
+}
+
+func (h *app_handler) HandleFindOneDeployment(w http.ResponseWriter, r *http.Request) {
+	app_id := r.PathValue("app_id")
+	dp_id := r.PathValue("dp_id")
+	user_id, _ := r.Context().Value("user_id").(string)
+
+	deployment, err := h.app_service.FindOneDeployment(app_id, dp_id, user_id)
+
+	if err != nil {
+		errmsg := err.Error()
+		switch errmsg {
+		case "permission_denied":
+			utils.ResponseWithError(
+				w,
+				http.StatusForbidden,
+				nil,
+				"Insufficient permission to access application deployment",
+			)
+			return
+		case "not_found":
+			utils.ResponseWithError(
+				w,
+				http.StatusNotFound,
+				nil,
+				"Deployment not found",
+			)
+			return
+		default:
+			utils.ResponseWithError(
+				w,
+				http.StatusInternalServerError,
+				nil,
+				"Internal server error",
+			)
+			return
+		}
+	}
+
+	utils.ResponseWithSuccess(
+		w,
+		http.StatusOK,
+		deployment,
+		"Application deployment retrieved successfully",
+	)
+}
+
+func (h *app_handler) HandleRollbackDeployment(w http.ResponseWriter, r *http.Request) {
+	app_id := r.PathValue("app_id")
+	dp_id := r.PathValue("dp_id")
+	user_id, _ := r.Context().Value("user_id").(string)
+
+	deployment, err := h.app_service.RollbackDeployment(app_id, dp_id, user_id)
+
+	if err != nil {
+		errmsg := err.Error()
+		switch errmsg {
+		case "permission_denied":
+			utils.ResponseWithError(
+				w,
+				http.StatusForbidden,
+				nil,
+				"Insufficient permission to roll back application",
+			)
+			return
+		case "not_found":
+			utils.ResponseWithError(
+				w,
+				http.StatusNotFound,
+				nil,
+				"Deployment not found",
+			)
+			return
+		case "invalid_rollback_target":
+			utils.ResponseWithError(
+				w,
+				http.StatusConflict,
+				nil,
+				"Only finished deployments that ran successfully can be rolled back to",
+			)
+			return
+		case "artifacts_not_found":
+			utils.ResponseWithError(
+				w,
+				http.StatusConflict,
+				nil,
+				"Deployment artifacts are no longer available",
+			)
+			return
+		case "transition_conflict":
+			utils.ResponseWithError(
+				w,
+				http.StatusConflict,
+				nil,
+				"Deployment status changed concurrently, try again",
+			)
+			return
+		default:
+			utils.ResponseWithError(
+				w,
+				http.StatusInternalServerError,
+				nil,
+				"Internal server error",
+			)
+			return
+		}
+	}
+
+	utils.ResponseWithSuccess(
+		w,
+		http.StatusCreated,
+		dto.NewApplicationDeploymentResponse(*deployment),
+		"Rollback deployment created successfully",
+	)
+}
+
+func (h *app_handler) HandleCancelDeployment(w http.ResponseWriter, r *http.Request) {
+	app_id := r.PathValue("app_id")
+	dp_id := r.PathValue("dp_id")
+	user_id, _ := r.Context().Value("user_id").(string)
+
+	deployment, err := h.app_service.CancelDeployment(app_id, dp_id, user_id)
+
+	if err != nil {
+		errmsg := err.Error()
+		switch errmsg {
+		case "permission_denied":
+			utils.ResponseWithError(
+				w,
+				http.StatusForbidden,
+				nil,
+				"Insufficient permission to cancel deployment",
+			)
+			return
+		case "not_found":
+			utils.ResponseWithError(
+				w,
+				http.StatusNotFound,
+				nil,
+				"Deployment not found",
+			)
+			return
+		case "invalid_cancel_target", "transition_conflict":
+			utils.ResponseWithError(
+				w,
+				http.StatusConflict,
+				nil,
+				"Only queued deployments and launches in progress can be cancelled",
+			)
+			return
+		default:
+			utils.ResponseWithError(
+				w,
+				http.StatusInternalServerError,
+				nil,
+				"Internal server error",
+			)
+			return
+		}
+	}
+
+	utils.ResponseWithSuccess(
+		w,
+		http.StatusAccepted,
+		dto.NewApplicationDeploymentResponse(*deployment),
+		"Deployment cancellation requested",
+	)
+}
+
+func (h *app_handler) respond_canary_error(w http.ResponseWriter, err error, permission_message string) {
+	switch err.Error() {
+	case "permission_denied":
+		utils.ResponseWithError(
+			w,
+			http.StatusForbidden,
+			nil,
+			permission_message,
+		)
+	case "not_found":
+		utils.ResponseWithError(
+			w,
+			http.StatusNotFound,
+			nil,
+			"Deployment not found",
+		)
+	case "invalid_canary_target", "transition_conflict":
+		utils.ResponseWithError(
+			w,
+			http.StatusConflict,
+			nil,
+			"Only canaries that are serving traffic can be changed, promoted or aborted",
+		)
+	default:
+		utils.ResponseWithError(
+			w,
+			http.StatusInternalServerError,
+			nil,
+			"Internal server error",
+		)
+	}
+}
+
+func (h *app_handler) HandleUpdateCanary(w http.ResponseWriter, r *http.Request) {
+	app_id := r.PathValue("app_id")
+	dp_id := r.PathValue("dp_id")
+	user_id, _ := r.Context().Value("user_id").(string)
+
+	decoder := json.NewDecoder(r.Body)
+	var body dto.UpdateApplicationDeploymentCanaryDto
+	if err := decoder.Decode(&body); err != nil {
+		utils.ResponseWithError(
+			w,
+			http.StatusUnprocessableEntity,
+			nil,
+			err.Error(),
+		)
+		return
+	}
+	if _, err := body.Validate(); err != nil {
+		utils.ResponseWithError(
+			w,
+			http.StatusBadRequest,
+			nil,
+			err.Error(),
+		)
+		return
+	}
+
+	deployment, err := h.app_service.UpdateCanary(app_id, dp_id, user_id, body)
+	if err != nil {
+		h.respond_canary_error(w, err, "Insufficient permission to update canary")
+		return
+	}
+
+	utils.ResponseWithSuccess(
+		w,
+		http.StatusOK,
+		dto.NewApplicationDeploymentResponse(*deployment),
+		"Canary updated successfully",
+	)
+}
+
+func (h *app_handler) HandlePromoteCanary(w http.ResponseWriter, r *http.Request) {
+	app_id := r.PathValue("app_id")
+	dp_id := r.PathValue("dp_id")
+	user_id, _ := r.Context().Value("user_id").(string)
+
+	deployment, err := h.app_service.PromoteCanary(app_id, dp_id, user_id)
+	if err != nil {
+		h.respond_canary_error(w, err, "Insufficient permission to promote canary")
+		return
+	}
+
+	utils.ResponseWithSuccess(
+		w,
+		http.StatusOK,
+		dto.NewApplicationDeploymentResponse(*deployment),
+		"Canary promoted successfully",
+	)
+}
+
+func (h *app_handler) HandleAbortCanary(w http.ResponseWriter, r *http.Request) {
+	app_id := r.PathValue("app_id")
+	dp_id := r.PathValue("dp_id")
+	user_id, _ := r.Context().Value("user_id").(string)
+
+	deployment, err := h.app_service.AbortCanary(app_id, dp_id, user_id)
+	if err != nil {
+		h.respond_canary_error(w, err, "Insufficient permission to abort canary")
+		return
+	}
+
+	utils.ResponseWithSuccess(
+		w,
+		http.StatusOK,
+		dto.NewApplicationDeploymentResponse(*deployment),
+		"Canary aborted successfully",
+	)
+}
+
+func (h *app_handler) HandleUpdateHealthCheck(w http.ResponseWriter, r *http.Request) {
+	app_id := r.PathValue("app_id")
+	user_id, _ := r.Context().Value("user_id").(string)
+
+	decoder := json.NewDecoder(r.Body)
+	var body dto.UpdateApplicationHealthCheckDto
+	if err := decoder.Decode(&body); err != nil {
+		utils.ResponseWithError(
+			w,
+			http.StatusUnprocessableEntity,
+			nil,
+			err.Error(),
+		)
+		return
+	}
+	if _, err := body.Validate(); err != nil {
+		utils.ResponseWithError(
+			w,
+			http.StatusBadRequest,
+			nil,
+			err.Error(),
+		)
+		return
+	}
+
+	updated_app, err := h.app_service.UpdateHealthCheck(
+		app_id,
+		user_id,
+		body,
+	)
+
+	if err != nil {
+		errmsg := err.Error()
+		switch errmsg {
+		case "permission_denied":
+			utils.ResponseWithError(
+				w,
+				http.StatusForbidden,
+				nil,
+				"Insufficient permission to update application health check",
+			)
+			return
+		case "not_found":
+			utils.ResponseWithError(
+				w,
+				http.StatusNotFound,
+				nil,
+				"Not found",
+			)
+			return
+		case "invalid_app_type":
+			utils.ResponseWithError(
+				w,
+				http.StatusConflict,
+				nil,
+				"Applications not listening on a port only support the none health check",
+			)
+			return
+		default:
+			utils.ResponseWithError(
+				w,
+				http.StatusInternalServerError,
+				nil,
+				"Internal server error",
+			)
+			return
+		}
+	}
+
+	utils.ResponseWithSuccess(
+		w,
+		http.StatusOK,
+		updated_app,
+		"Application health check updated successfully",
+	)
+}
+
+func (h *app_handler) HandleUpdateResources(w http.ResponseWriter, r *http.Request) {
+	app_id := r.PathValue("app_id")
+	user_id, _ := r.Context().Value("user_id").(string)
+
+	decoder := json.NewDecoder(r.Body)
+	var body dto.UpdateApplicationResourcesDto
+	if err := decoder.Decode(&body); err != nil {
+		utils.ResponseWithError(
+			w,
+			http.StatusUnprocessableEntity,
+			nil,
+			err.Error(),
+		)
+		return
+	}
+	if _, err := body.Validate(); err != nil {
+		utils.ResponseWithError(
+			w,
+			http.StatusBadRequest,
+			nil,
+			err.Error(),
+		)
+		return
+	}
+
+	updated_app, err := h.app_service.UpdateResources(
+		app_id,
+		user_id,
+		body,
+	)
+
+	if err != nil {
+		errmsg := err.Error()
+		switch errmsg {
+		case "permission_denied":
+			utils.ResponseWithError(
+				w,
+				http.StatusForbidden,
+				nil,
+				"Insufficient permission to update application resources",
+			)
+			return
+		case "not_found":
+			utils.ResponseWithError(
+				w,
+				http.StatusNotFound,
+				nil,
+				"Not found",
+			)
+			return
+		default:
+			utils.ResponseWithError(
+				w,
+				http.StatusInternalServerError,
+				nil,
+				"Internal server error",
+			)
+			return
+		}
+	}
+
+	utils.ResponseWithSuccess(
+		w,
+		http.StatusOK,
+		updated_app,
+		"Application resources updated successfully",
+	)
+}
+
+func (h *app_handler) HandleUpdateScale(w http.ResponseWriter, r *http.Request) {
+	app_id := r.PathValue("app_id")
+	user_id, _ := r.Context().Value("user_id").(string)
+
+	decoder := json.NewDecoder(r.Body)
+	var body dto.UpdateApplicationScaleDto
+	if err := decoder.Decode(&body); err != nil {
+		utils.ResponseWithError(
+			w,
+			http.StatusUnprocessableEntity,
+			nil,
+			err.Error(),
+		)
+		return
+	}
+	if _, err := body.Validate(); err != nil {
+		utils.ResponseWithError(
+			w,
+			http.StatusBadRequest,
+			nil,
+			err.Error(),
+		)
+		return
+	}
+
+	scales, err := h.app_service.UpdateScale(app_id, user_id, body)
+	if err != nil {
+		switch err.Error() {
+		case "permission_denied":
+			utils.ResponseWithError(
+				w,
+				http.StatusForbidden,
+				nil,
+				"Insufficient permission to scale application",
+			)
+		case "not_found":
+			utils.ResponseWithError(
+				w,
+				http.StatusNotFound,
+				nil,
+				"Not found",
+			)
+		case "invalid_app_type":
+			utils.ResponseWithError(
+				w,
+				http.StatusConflict,
+				nil,
+				"Only applications running processes can be scaled",
+			)
+		default:
+			utils.ResponseWithError(
+				w,
+				http.StatusInternalServerError,
+				nil,
+				"Internal server error",
+			)
+		}
+		return
+	}
+
+	response := dto.NewListApplicationProcessScaleResponse(scales)
+
+	utils.ResponseWithSuccess(
+		w,
+		http.StatusOK,
+		&response,
+		"Application scale updated successfully",
+	)
+}
+
+func (h *app_handler) respond_webhook_error(w http.ResponseWriter, err error, permission_message string) {
+	switch err.Error() {
+	case "permission_denied":
+		utils.ResponseWithError(
+			w,
+			http.StatusForbidden,
+			nil,
+			permission_message,
+		)
+	case "not_found":
+		utils.ResponseWithError(
+			w,
+			http.StatusNotFound,
+			nil,
+			"Not found",
+		)
+	default:
+		utils.ResponseWithError(
+			w,
+			http.StatusInternalServerError,
+			nil,
+			"Internal server error",
+		)
+	}
+}
+
+func (h *app_handler) HandleUpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	app_id := r.PathValue("app_id")
+	user_id, _ := r.Context().Value("user_id").(string)
+
+	decoder := json.NewDecoder(r.Body)
+	var body dto.UpdateApplicationWebhookDto
+	if err := decoder.Decode(&body); err != nil {
+		utils.ResponseWithError(
+			w,
+			http.StatusUnprocessableEntity,
+			nil,
+			err.Error(),
+		)
+		return
+	}
+	if _, err := body.Validate(); err != nil {
+		utils.ResponseWithError(
+			w,
+			http.StatusBadRequest,
+			nil,
+			err.Error(),
+		)
+		return
+	}
+
+	webhook, err := h.app_service.UpdateWebhook(app_id, user_id, body)
+	if err != nil {
+		h.respond_webhook_error(w, err, "Insufficient permission to update application webhook")
+		return
+	}
+
+	utils.ResponseWithSuccess(
+		w,
+		http.StatusOK,
+		webhook,
+		"Application webhook updated successfully",
+	)
+}
+
+func (h *app_handler) HandleFindOneWebhook(w http.ResponseWriter, r *http.Request) {
+	app_id := r.PathValue("app_id")
+	user_id, _ := r.Context().Value("user_id").(string)
+
+	webhook, err := h.app_service.FindOneWebhook(app_id, user_id)
+	if err != nil {
+		h.respond_webhook_error(w, err, "Insufficient permission to access application webhook")
+		return
+	}
+
+	utils.ResponseWithSuccess(
+		w,
+		http.StatusOK,
+		webhook,
+		"Application webhook retrieved successfully",
+	)
+}
+
+func (h *app_handler) HandleListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	app_id := r.PathValue("app_id")
+	user_id, _ := r.Context().Value("user_id").(string)
+
+	deliveries, err := h.app_service.FindWebhookDeliveries(app_id, user_id)
+	if err != nil {
+		h.respond_webhook_error(w, err, "Insufficient permission to access application webhook")
+		return
+	}
+
+	response := dto.NewListApplicationWebhookDeliveryResponse(deliveries)
+
+	utils.ResponseWithSuccess(
+		w,
+		http.StatusOK,
+		&response,
+		"Webhook deliveries retrieved successfully",
+	)
+}
+
+// HandleReceiveWebhook is called by git hosts, it is authenticated by the
+// delivery's signature rather than a login.
+func (h *app_handler) HandleReceiveWebhook(w http.ResponseWriter, r *http.Request) {
+	app_id := r.PathValue("app_id")
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, dto.MaxWebhookPayloadSize))
+	if err != nil {
+		utils.ResponseWithError(
+			w,
+			http.StatusRequestEntityTooLarge,
+			nil,
+			"Webhook payload too large",
+		)
+		return
+	}
+
+	delivery, err := h.app_service.ReceiveWebhook(
+		app_id,
+		dto.ReceiveApplicationWebhookDto{Header: r.Header, Body: body},
+	)
+	if err != nil {
+		switch err.Error() {
+		case "not_found":
+			utils.ResponseWithError(
+				w,
+				http.StatusNotFound,
+				nil,
+				"Webhook not found",
+			)
+		default:
+			utils.ResponseWithError(
+				w,
+				http.StatusInternalServerError,
+				nil,
+				"Internal server error",
+			)
+		}
+		return
+	}
+
+	response := dto.NewApplicationWebhookDeliveryResponse(*delivery)
+
+	switch delivery.Result {
+	case dto.WebhookDeliveryRejected:
+		utils.ResponseWithError(
+			w,
+			http.StatusUnauthorized,
+			map[string]any{"result": response.Result, "reason": response.Message},
+			"Webhook delivery rejected",
+		)
+	case dto.WebhookDeliveryFailed:
+		utils.ResponseWithError(
+			w,
+			http.StatusInternalServerError,
+			map[string]any{"result": response.Result, "reason": response.Message},
+			"Webhook delivery failed",
+		)
+	case dto.WebhookDeliveryDeployed:
+		utils.ResponseWithSuccess(
+			w,
+			http.StatusAccepted,
+			response,
+			"Deployment created from push",
+		)
+	default:
+		utils.ResponseWithSuccess(
+			w,
+			http.StatusOK,
+			response,
+			"Webhook delivery ignored",
+		)
+	}
+}
+
+func (h *app_handler) HandleStop(w http.ResponseWriter, r *http.Request) {
+	h.handle_control(w, r, h.app_service.Stop, "Application stopped successfully")
+}
+
+func (h *app_handler) HandleStart(w http.ResponseWriter, r *http.Request) {
+	h.handle_control(w, r, h.app_service.Start, "Application started successfully")
+}
+
+func (h *app_handler) HandleRestart(w http.ResponseWriter, r *http.Request) {
+	h.handle_control(w, r, h.app_service.Restart, "Application restarted successfully")
+}
+
+// handle_control runs one of the stop, start or restart actions, they share
+// their inputs and error mapping.
+func (h *app_handler) handle_control(
+	w http.ResponseWriter,
+	r *http.Request,
+	action func(app_id string, user_id string) (*dto.ApplicationControlResponse, error),
+	message string,
+) {
+	app_id := r.PathValue("app_id")
+	user_id, _ := r.Context().Value("user_id").(string)
+
+	response, err := action(app_id, user_id)
+
+	if err != nil {
+		errmsg := err.Error()
+		switch errmsg {
+		case "permission_denied":
+			utils.ResponseWithError(
+				w,
+				http.StatusForbidden,
+				nil,
+				"Insufficient permission to control application",
+			)
+			return
+		case "not_found":
+			utils.ResponseWithError(
+				w,
+				http.StatusNotFound,
+				nil,
+				"Application not found",
+			)
+			return
+		case "no_deployment":
+			utils.ResponseWithError(
+				w,
+				http.StatusConflict,
+				nil,
+				"Application has no deployment to act on",
+			)
+			return
+		case "transition_conflict", "invalid_transition":
+			utils.ResponseWithError(
+				w,
+				http.StatusConflict,
+				nil,
+				"Deployment status changed concurrently, try again",
+			)
+			return
+		default:
+			utils.ResponseWithError(
+				w,
+				http.StatusInternalServerError,
+				nil,
+				"Internal server error",
+			)
+			return
+		}
+	}
+
+	utils.ResponseWithSuccess(
+		w,
+		http.StatusOK,
+		response,
+		message,
+	)
+}
+
+func (h *app_handler) respond_deployment_logs_error(w http.ResponseWriter, err error) {
+	switch err.Error() {
+	case "permission_denied":
+		utils.ResponseWithError(
+			w,
+			http.StatusForbidden,
+			nil,
+			"Insufficient permission to access deployment logs",
+		)
+	case "not_found":
+		utils.ResponseWithError(
+			w,
+			http.StatusNotFound,
+			nil,
+			"Deployment not found",
+		)
+	default:
+		utils.ResponseWithError(
+			w,
+			http.StatusInternalServerError,
+			nil,
+			"Internal server error",
+		)
+	}
+}
+
+func (h *app_handler) HandleFindDeploymentLogs(w http.ResponseWriter, r *http.Request) {
+	app_id := r.PathValue("app_id")
+	dp_id := r.PathValue("dp_id")
+	user_id, _ := r.Context().Value("user_id").(string)
+
+	query := r.URL.Query()
+	body := dto.FindApplicationDeploymentLogsDto{
+		Limit: dto.DefaultDeploymentLogsLimit,
+	}
+
+	if after := query.Get("after"); after != "" {
+		parsed, err := strconv.ParseInt(after, 10, 64)
+		if err != nil {
+			utils.ResponseWithError(w, http.StatusBadRequest, nil, "after must be an integer")
+			return
+		}
+		body.After = parsed
+	}
+	if limit := query.Get("limit"); limit != "" {
+		parsed, err := strconv.ParseInt(limit, 10, 32)
+		if err != nil {
+			utils.ResponseWithError(w, http.StatusBadRequest, nil, "limit must be an integer")
+			return
+		}
+		body.Limit = int32(parsed)
+	}
+	body.Process = query.Get("process")
+
+	if query.Get("follow") == "true" {
+		// reconnecting EventSource clients resume from the last id they saw
+		if last_event_id := r.Header.Get("Last-Event-ID"); last_event_id != "" {
+			if parsed, err := strconv.ParseInt(last_event_id, 10, 64); err == nil {
+				body.After = parsed
+			}
+		}
+		body.Limit = dto.MaxDeploymentLogsLimit
+	}
+
+	if _, err := body.Validate(); err != nil {
+		utils.ResponseWithError(w, http.StatusBadRequest, nil, err.Error())
+		return
+	}
+
+	if query.Get("follow") == "true" {
+		h.follow_deployment_logs(w, r, app_id, dp_id, user_id, body)
+		return
+	}
+
+	logs, err := h.app_service.FindDeploymentLogs(app_id, dp_id, user_id, body)
+	if err != nil {
+		h.respond_deployment_logs_error(w, err)
+		return
+	}
+
+	utils.ResponseWithSuccess(
+		w,
+		http.StatusOK,
+		dto.NewApplicationDeploymentLogsResponse(logs, body.After),
+		"Deployment logs retrieved successfully",
+	)
+}
+
+// follow_deployment_logs streams the stored backlog and then live lines as
+// server-sent events. It subscribes before reading the backlog so nothing
+// logged in between is missed, duplicates are skipped by id. Lines the
+// follower fell behind on, and any left when the deployment ends, are read
+// back from the stored history after the last id sent.
+func (h *app_handler) follow_deployment_logs(w http.ResponseWriter, r *http.Request, app_id string, dp_id string, user_id string, body dto.FindApplicationDeploymentLogsDto) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		utils.ResponseWithError(w, http.StatusInternalServerError, nil, "Streaming unsupported")
+		return
+	}
+
+	lines, unsubscribe, err := h.app_service.FollowDeploymentLogs(app_id, dp_id, user_id)
+	if err != nil {
+		h.respond_deployment_logs_error(w, err)
+		return
+	}
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	last_id := body.After
+	write_line := func(line dto.ApplicationDeploymentLogResponse) error {
+		if line.ID <= last_id {
+			return nil
+		}
+		data, err := json.Marshal(line)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "id: %d\nevent: log\ndata: %s\n\n", line.ID, data); err != nil {
+			return err
+		}
+		last_id = line.ID
+		return nil
+	}
+
+	catch_up := func() bool {
+		for {
+			page, err := h.app_service.FindDeploymentLogs(
+				app_id,
+				dp_id,
+				user_id,
+				dto.FindApplicationDeploymentLogsDto{After: last_id, Limit: body.Limit, Process: body.Process},
+			)
+			if err != nil {
+				fmt.Fprint(w, "event: error\ndata: {}\n\n")
+				flusher.Flush()
+				return false
+			}
+			for _, row := range page {
+				if err := write_line(*dto.NewApplicationDeploymentLogResponse(row)); err != nil {
+					return false
+				}
+			}
+			flusher.Flush()
+			if len(page) < int(body.Limit) {
+				return true
+			}
+		}
+	}
+
+	if !catch_up() {
+		return
+	}
+
+	heartbeat := time.NewTicker(log_stream_heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case row, ok := <-lines:
+			if !ok {
+				if !catch_up() {
+					return
+				}
+				fmt.Fprint(w, "event: end\ndata: {}\n\n")
+				flusher.Flush()
+				return
+			}
+			// the follower fell behind, what it missed is stored
+			if row.AppDpLogID == 0 {
+				if !catch_up() {
+					return
+				}
+				continue
+			}
+			if body.Process != "" && row.ProcessType.String != body.Process {
+				continue
+			}
+			if err := write_line(*dto.NewApplicationDeploymentLogResponse(row)); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func (h *app_handler) respond_schedule_error(w http.ResponseWriter, err error, permission_message string) {
+	switch err.Error() {
+	case "permission_denied":
+		utils.ResponseWithError(
+			w,
+			http.StatusForbidden,
+			nil,
+			permission_message,
+		)
+	case "not_found":
+		utils.ResponseWithError(
+			w,
+			http.StatusNotFound,
+			nil,
+			"Not found",
+		)
+	case "invalid_app_type":
+		utils.ResponseWithError(
+			w,
+			http.StatusConflict,
+			nil,
+			"Only cron job applications run on a schedule",
+		)
+	default:
+		utils.ResponseWithError(
+			w,
+			http.StatusInternalServerError,
+			nil,
+			"Internal server error",
+		)
+	}
+}
+
+func (h *app_handler) HandleUpdateSchedule(w http.ResponseWriter, r *http.Request) {
+	app_id := r.PathValue("app_id")
+	user_id, _ := r.Context().Value("user_id").(string)
+
+	decoder := json.NewDecoder(r.Body)
+	var body dto.UpdateApplicationScheduleDto
+	if err := decoder.Decode(&body); err != nil {
+		utils.ResponseWithError(
+			w,
+			http.StatusUnprocessableEntity,
+			nil,
+			err.Error(),
+		)
+		return
+	}
+	if _, err := body.Validate(); err != nil {
+		utils.ResponseWithError(
+			w,
+			http.StatusBadRequest,
+			nil,
+			err.Error(),
+		)
+		return
+	}
+
+	updated_app, err := h.app_service.UpdateSchedule(app_id, user_id, body)
+	if err != nil {
+		h.respond_schedule_error(w, err, "Insufficient permission to update application schedule")
+		return
+	}
+
+	utils.ResponseWithSuccess(
+		w,
+		http.StatusOK,
+		dto.NewApplicationScheduleResponse(*updated_app),
+		"Application schedule updated successfully",
+	)
+}
+
+func (h *app_handler) HandleListJobRuns(w http.ResponseWriter, r *http.Request) {
+	app_id := r.PathValue("app_id")
+	user_id, _ := r.Context().Value("user_id").(string)
+
+	runs, err := h.app_service.FindJobRuns(app_id, user_id)
+	if err != nil {
+		h.respond_schedule_error(w, err, "Insufficient permission to access application runs")
+		return
+	}
+
+	response := dto.NewListApplicationJobRunResponse(runs)
+
+	utils.ResponseWithSuccess(
+		w,
+		http.StatusOK,
+		&response,
+		"Application runs retrieved successfully",
+	)
+}
+
+func (h *app_handler) HandleFindJobRunLogs(w http.ResponseWriter, r *http.Request) {
+	app_id := r.PathValue("app_id")
+	run_id := r.PathValue("run_id")
+	user_id, _ := r.Context().Value("user_id").(string)
+
+	query := r.URL.Query()
+	body := dto.FindApplicationDeploymentLogsDto{
+		Limit: dto.DefaultDeploymentLogsLimit,
+	}
+
+	if after := query.Get("after"); after != "" {
+		parsed, err := strconv.ParseInt(after, 10, 64)
+		if err != nil {
+			utils.ResponseWithError(w, http.StatusBadRequest, nil, "after must be an integer")
+			return
+		}
+		body.After = parsed
+	}
+	if limit := query.Get("limit"); limit != "" {
+		parsed, err := strconv.ParseInt(limit, 10, 32)
+		if err != nil {
+			utils.ResponseWithError(w, http.StatusBadRequest, nil, "limit must be an integer")
+			return
+		}
+		body.Limit = int32(parsed)
+	}
+
+	if _, err := body.Validate(); err != nil {
+		utils.ResponseWithError(w, http.StatusBadRequest, nil, err.Error())
+		return
+	}
+
+	logs, err := h.app_service.FindJobRunLogs(app_id, run_id, user_id, body)
+	if err != nil {
+		h.respond_schedule_error(w, err, "Insufficient permission to access run logs")
+		return
+	}
+
+	utils.ResponseWithSuccess(
+		w,
+		http.StatusOK,
+		dto.NewApplicationJobRunLogsResponse(logs, body.After),
+		"Run logs retrieved successfully",
+	)
+}
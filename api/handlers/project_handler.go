@@ -58,7 +58,7 @@ func (h *project_handler) HandleCreate(w http.ResponseWriter, r *http.Request) {
 		errmsg := err.Error()
 		fmt.Println("CreateProject failed", errmsg)
 		if strings.Contains(errmsg, "duplicate key") {
-			utils.ResponseWithError(w, http.StatusBadRequest, nil, "Project with this name already exists")
+			utils.ResponseWithError(w, http.StatusBadRequest, nil, "Project with this name or address already exists")
 		} else {
 			utils.ResponseWithError(w, http.StatusInternalServerError, nil, "Internal server error")
 		}
@@ -217,6 +217,10 @@ func (h *project_handler) HandleUpdate(w http.ResponseWriter, r *http.Request) {
 
 	new_project, err := h.project_service.UpdateOne(project)
 
+	if err != nil && strings.Contains(err.Error(), "duplicate key") {
+		utils.ResponseWithError(w, http.StatusBadRequest, nil, "Project with this name or address already exists")
+		return
+	}
 	if err != nil {
 		fmt.Println("Update one project failed, ", err)
 		utils.ResponseWithSuccess[any](
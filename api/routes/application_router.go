@@ -30,6 +30,36 @@ func SetupApplicationRouter(application_service application.Service, jwt_validat
 		http.HandlerFunc(app_handlers.HandleUpdate),
 	))
 
+	r.Put("/{app_id}/health-check", middleware.LoginGuard(
+		jwt_validator,
+		http.HandlerFunc(app_handlers.HandleUpdateHealthCheck),
+	))
+
+	r.Put("/{app_id}/resources", middleware.LoginGuard(
+		jwt_validator,
+		http.HandlerFunc(app_handlers.HandleUpdateResources),
+	))
+
+	r.Put("/{app_id}/scale", middleware.LoginGuard(
+		jwt_validator,
+		http.HandlerFunc(app_handlers.HandleUpdateScale),
+	))
+
+	r.Post("/{app_id}/stop", middleware.LoginGuard(
+		jwt_validator,
+		http.HandlerFunc(app_handlers.HandleStop),
+	))
+
+	r.Post("/{app_id}/start", middleware.LoginGuard(
+		jwt_validator,
+		http.HandlerFunc(app_handlers.HandleStart),
+	))
+
+	r.Post("/{app_id}/restart", middleware.LoginGuard(
+		jwt_validator,
+		http.HandlerFunc(app_handlers.HandleRestart),
+	))
+
 	r.Get("/{app_id}/configs", middleware.LoginGuard(
 		jwt_validator,
 		http.HandlerFunc(app_handlers.HandleFindOneConfig),
@@ -40,5 +70,83 @@ func SetupApplicationRouter(application_service application.Service, jwt_validat
 		http.HandlerFunc(app_handlers.HandleCreateConfig),
 	))
 
+	r.Get("/{app_id}/deployments", middleware.LoginGuard(
+		jwt_validator,
+		http.HandlerFunc(app_handlers.HandleListDeployments),
+	))
+
+	r.Post("/{app_id}/deployments", middleware.LoginGuard(
+		jwt_validator,
+		http.HandlerFunc(app_handlers.HandleCreateDeployment),
+	))
+
+	r.Get("/{app_id}/deployments/{dp_id}", middleware.LoginGuard(
+		jwt_validator,
+		http.HandlerFunc(app_handlers.HandleFindOneDeployment),
+	))
+
+	r.Post("/{app_id}/deployments/{dp_id}/rollback", middleware.LoginGuard(
+		jwt_validator,
+		http.HandlerFunc(app_handlers.HandleRollbackDeployment),
+	))
+
+	r.Post("/{app_id}/deployments/{dp_id}/cancel", middleware.LoginGuard(
+		jwt_validator,
+		http.HandlerFunc(app_handlers.HandleCancelDeployment),
+	))
+
+	r.Put("/{app_id}/deployments/{dp_id}/canary", middleware.LoginGuard(
+		jwt_validator,
+		http.HandlerFunc(app_handlers.HandleUpdateCanary),
+	))
+
+	r.Post("/{app_id}/deployments/{dp_id}/canary/promote", middleware.LoginGuard(
+		jwt_validator,
+		http.HandlerFunc(app_handlers.HandlePromoteCanary),
+	))
+
+	r.Post("/{app_id}/deployments/{dp_id}/canary/abort", middleware.LoginGuard(
+		jwt_validator,
+		http.HandlerFunc(app_handlers.HandleAbortCanary),
+	))
+
+	r.Get("/{app_id}/deployments/{dp_id}/logs", middleware.LoginGuard(
+		jwt_validator,
+		http.HandlerFunc(app_handlers.HandleFindDeploymentLogs),
+	))
+
+	r.Get("/{app_id}/webhook", middleware.LoginGuard(
+		jwt_validator,
+		http.HandlerFunc(app_handlers.HandleFindOneWebhook),
+	))
+
+	r.Put("/{app_id}/webhook", middleware.LoginGuard(
+		jwt_validator,
+		http.HandlerFunc(app_handlers.HandleUpdateWebhook),
+	))
+
+	r.Get("/{app_id}/webhook/deliveries", middleware.LoginGuard(
+		jwt_validator,
+		http.HandlerFunc(app_handlers.HandleListWebhookDeliveries),
+	))
+
+	r.Put("/{app_id}/schedule", middleware.LoginGuard(
+		jwt_validator,
+		http.HandlerFunc(app_handlers.HandleUpdateSchedule),
+	))
+
+	r.Get("/{app_id}/runs", middleware.LoginGuard(
+		jwt_validator,
+		http.HandlerFunc(app_handlers.HandleListJobRuns),
+	))
+
+	r.Get("/{app_id}/runs/{run_id}/logs", middleware.LoginGuard(
+		jwt_validator,
+		http.HandlerFunc(app_handlers.HandleFindJobRunLogs),
+	))
+
+	// git hosts can't log in, deliveries are verified by their signature
+	r.Post("/{app_id}/webhook", http.HandlerFunc(app_handlers.HandleReceiveWebhook))
+
 	return r
 }
\ No newline at end of file
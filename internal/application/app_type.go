@@ -0,0 +1,47 @@
+package application
+
+import (
+	"github.com/salmanrf/capybara-cloud/internal/database"
+	"github.com/salmanrf/capybara-cloud/pkg/dto"
+)
+
+func is_cron_job(app_type string) bool {
+	return app_type == dto.AppTypeCronJob
+}
+
+func is_static_site(app_type string) bool {
+	return app_type == dto.AppTypeStaticSite
+}
+
+// has_process tells whether running deployments of an application type keep
+// a process alive. Cron jobs only start one per run and static sites are
+// served by the ingress from the built files.
+func has_process(app_type string) bool {
+	return !is_cron_job(app_type) && !is_static_site(app_type)
+}
+
+// builds_only is the opposite of has_process for a loaded application, the
+// newest running deployment is just the build runs start from or the files
+// that are served.
+func builds_only(app *database.Application) bool {
+	return app != nil && !has_process(app.Type)
+}
+
+// listens_on_port tells whether deployments of an application type lease a
+// port, get proxied to by the ingress and are health checked through it.
+// Workers and cron jobs are only kept alive by their process, static sites
+// have none.
+func listens_on_port(app_type string) bool {
+	switch app_type {
+	case dto.AppTypeBackgroundWorker, dto.AppTypeCronJob, dto.AppTypeStaticSite:
+		return false
+	default:
+		return true
+	}
+}
+
+// app_listens_on_port is listens_on_port for a loaded application,
+// deployments whose application can't be found are taken for web apps.
+func app_listens_on_port(app *database.Application) bool {
+	return app == nil || listens_on_port(app.Type)
+}
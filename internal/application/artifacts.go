@@ -0,0 +1,289 @@
+package application
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/salmanrf/capybara-cloud/internal/artifacts"
+	"github.com/salmanrf/capybara-cloud/internal/database"
+	"github.com/salmanrf/capybara-cloud/internal/runtime"
+)
+
+const git_fetch_timeout = 10 * time.Minute
+
+// new_deployment_uuid generates the deployment id up front so the bundle can
+// be stored before the row is inserted.
+func new_deployment_uuid() (pgtype.UUID, error) {
+	id := pgtype.UUID{}
+
+	if _, err := rand.Read(id.Bytes[:]); err != nil {
+		return id, err
+	}
+
+	// RFC 4122 version 4, variant 1
+	id.Bytes[6] = (id.Bytes[6] & 0x0f) | 0x40
+	id.Bytes[8] = (id.Bytes[8] & 0x3f) | 0x80
+	id.Valid = true
+
+	return id, nil
+}
+
+// bundle_file_name keeps the upload's extension for readability, extraction
+// goes by content.
+func bundle_file_name(bundle_name string) string {
+	lower := strings.ToLower(bundle_name)
+	for _, ext := range []string{".tgz", ".zip", ".tar"} {
+		if strings.HasSuffix(lower, ext) {
+			return "bundle" + ext
+		}
+	}
+
+	return "bundle.tar.gz"
+}
+
+// bundle_key is where a deployment's bundle lives in the artifact store
+func bundle_key(app_id string, dp_id string, bundle_name string) string {
+	return app_id + "/" + dp_id + "/" + bundle_file_name(bundle_name)
+}
+
+// store_bundle validates an upload before it reaches the artifact store, so a
+// bad bundle fails the request instead of the deployment.
+func (s *service) store_bundle(key string, bundle io.Reader) (*artifacts.Object, error) {
+	spool, err := os.CreateTemp("", "bundle-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	// one byte past the limit tells a bundle that is too large from one that fits
+	limit := s.bundle_limits.UploadLimit()
+	n, err := io.Copy(spool, io.LimitReader(bundle, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if n > limit {
+		return nil, &runtime.BundleError{
+			Reason: runtime.BundleReasonUploadTooLarge,
+			Limit: limit,
+			Message: fmt.Sprintf("larger than %d bytes", limit),
+		}
+	}
+	if err := runtime.InspectBundle(spool.Name(), s.bundle_limits); err != nil {
+		return nil, err
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	object, err := s.artifact_store.Put(key, spool)
+	if err != nil {
+		return nil, transient(err)
+	}
+
+	return object, nil
+}
+
+// local_artifacts_path is the per-deployment directory on this host the
+// runtime extracts and builds in.
+func local_artifacts_path(artifacts_dir string, app_id string, dp_id string) (string, error) {
+	return filepath.Abs(filepath.Join(artifacts_dir, app_id, dp_id))
+}
+
+// fetch_bundle downloads the deployment's bundle from the artifact store into
+// its local artifacts directory and checks it against the recorded hash. A
+// matching local copy is reused. Deployments created before the store existed
+// have no key, their bundle is already on disk.
+func (s *service) fetch_bundle(deployment *database.ApplicationDeployment) error {
+	if !deployment.BundleKey.Valid {
+		return nil
+	}
+
+	local_path := filepath.Join(deployment.ArtifactsPath, path.Base(deployment.BundleKey.String))
+	if sum, err := file_sha256(local_path); err == nil && sum == deployment.BundleSha256.String {
+		return nil
+	}
+
+	if err := os.MkdirAll(deployment.ArtifactsPath, 0o750); err != nil {
+		return err
+	}
+
+	body, _, err := s.artifact_store.Get(deployment.BundleKey.String)
+	if err != nil {
+		if err.Error() == "not_found" {
+			return errors.New("artifacts_not_found")
+		}
+		return transient(err)
+	}
+	defer body.Close()
+
+	tmp, err := os.CreateTemp(deployment.ArtifactsPath, ".bundle-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, hash), body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if hex.EncodeToString(hash.Sum(nil)) != deployment.BundleSha256.String {
+		return errors.New("artifact_hash_mismatch")
+	}
+
+	return os.Rename(tmp.Name(), local_path)
+}
+
+// fetch_git_source turns a git deployment into a bundle deployment: the ref
+// is fetched and archived into the local artifacts directory, stored like an
+// upload and recorded on the row with the resolved commit. Deployments that
+// already have a bundle, e.g. rollbacks, are left alone.
+func (s *service) fetch_git_source(ctx context.Context, deployment *database.ApplicationDeployment) error {
+	if !deployment.GitUrl.Valid || deployment.BundleKey.Valid {
+		return nil
+	}
+
+	if err := os.MkdirAll(deployment.ArtifactsPath, 0o750); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, git_fetch_timeout)
+	defer cancel()
+
+	app_dp_id := deployment.AppDpID
+	spec := runtime.Spec{
+		DeploymentID: app_dp_id.String(),
+		AppID: deployment.AppID.String(),
+		OnLog: func(line runtime.LogLine) {
+			s.record_log(app_dp_id, line)
+		},
+	}
+
+	key := bundle_key(deployment.AppID.String(), app_dp_id.String(), "bundle.tar.gz")
+	local_path := filepath.Join(deployment.ArtifactsPath, path.Base(key))
+	sha, err := runtime.FetchGitBundle(
+		ctx,
+		spec,
+		runtime.GitSource{URL: deployment.GitUrl.String, Ref: deployment.GitRef.String},
+		filepath.Join(deployment.ArtifactsPath, "git"),
+		local_path,
+	)
+	if err != nil {
+		// the git host may be unreachable for a moment, unknown refs stay unknown
+		if strings.HasPrefix(err.Error(), "git_fetch_") {
+			return transient(err)
+		}
+		return err
+	}
+
+	bundle, err := os.Open(local_path)
+	if err != nil {
+		return err
+	}
+	defer bundle.Close()
+
+	object, err := s.store_bundle(key, bundle)
+	if err != nil {
+		return err
+	}
+
+	params := database.UpdateApplicationDeploymentSourceParams{
+		AppDpID: app_dp_id,
+		GitCommitSha: pgtype.Text{String: sha, Valid: true},
+		BundleKey: pgtype.Text{String: object.Key, Valid: true},
+		BundleSha256: pgtype.Text{String: object.SHA256, Valid: true},
+		BundleSize: pgtype.Int8{Int64: object.Size, Valid: true},
+	}
+	if _, err := s.repository.UpdateDeploymentSource(params); err != nil {
+		s.artifact_store.Delete(key)
+		return err
+	}
+
+	deployment.GitCommitSha = params.GitCommitSha
+	deployment.BundleKey = params.BundleKey
+	deployment.BundleSha256 = params.BundleSha256
+	deployment.BundleSize = params.BundleSize
+
+	return nil
+}
+
+// stat_bundle checks that a deployment's bundle can still be launched
+func (s *service) stat_bundle(deployment *database.ApplicationDeployment) error {
+	if !deployment.BundleKey.Valid {
+		if _, err := runtime.FindBundle(deployment.ArtifactsPath); err != nil {
+			return errors.New("artifacts_not_found")
+		}
+		return nil
+	}
+
+	if _, err := s.artifact_store.Stat(deployment.BundleKey.String); err != nil {
+		if err.Error() == "not_found" {
+			return errors.New("artifacts_not_found")
+		}
+		return err
+	}
+
+	return nil
+}
+
+// copy_local_bundle copies the on-disk bundle of a deployment without a
+// stored one into artifacts_path.
+func copy_local_bundle(source *database.ApplicationDeployment, artifacts_path string) error {
+	bundle_path, err := runtime.FindBundle(source.ArtifactsPath)
+	if err != nil {
+		return errors.New("artifacts_not_found")
+	}
+
+	if err := os.MkdirAll(artifacts_path, 0o750); err != nil {
+		return err
+	}
+
+	src, err := os.Open(bundle_path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(filepath.Join(artifacts_path, filepath.Base(bundle_path)))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+
+	return dst.Close()
+}
+
+func file_sha256(file_path string) (string, error) {
+	file, err := os.Open(file_path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
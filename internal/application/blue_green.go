@@ -0,0 +1,128 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/salmanrf/capybara-cloud/internal/database"
+	"github.com/salmanrf/capybara-cloud/internal/runtime"
+)
+
+// switch_deployment starts a launched deployment next to the ones serving the
+// application. It stays in starting, so the ingress keeps routing to them,
+// until it passes its health check. Then it goes running, which flips the
+// route, and the previous deployments are stopped after the drain grace. A
+// deployment failing its check is failed and the previous ones keep serving.
+// Canaries only get their share of the route, the previous deployments keep
+// serving until the canary is promoted.
+func (s *service) switch_deployment(ctx context.Context, deployment *database.ApplicationDeployment, spec runtime.Spec, previous []database.ApplicationDeployment, can_retry bool) (*database.ApplicationDeployment, error) {
+	dp_id := deployment.AppDpID.String()
+
+	instance, err := s.runtime.Start(spec)
+	if err != nil {
+		return s.fail_deployment(deployment, err)
+	}
+
+	if err := s.record_process(deployment, instance); err != nil {
+		s.runtime.Stop(dp_id)
+		return nil, err
+	}
+	current := deployment
+
+	if err := s.await_healthy(ctx, current, spec); err != nil {
+		if err := s.runtime.Stop(dp_id); err != nil && err.Error() != "not_found" {
+			fmt.Println("Error at application_service.switch_deployment - stopping: ", err.Error())
+		}
+		if ctx.Err() != nil {
+			return s.abort_launch(ctx, current, err, can_retry)
+		}
+		return s.fail_deployment(current, err)
+	}
+
+	reason := "passed health checks, switching traffic"
+	if deployment.CanaryWeight.Valid {
+		reason = fmt.Sprintf("passed health checks, receiving %d%% of the traffic as a canary", deployment.CanaryWeight.Int32)
+	}
+
+	running, err := s.transition_deployment(current, DeploymentRunning, reason)
+	if err != nil {
+		return nil, err
+	}
+	s.watch_deployment(running)
+
+	if deployment.CanaryWeight.Valid {
+		return running, nil
+	}
+
+	s.drain_deployments(ctx, previous, running)
+
+	return running, nil
+}
+
+// await_healthy probes a started deployment until it passes its health check.
+// It gives up when the process exits or no check passed within
+// health_gate_timeout. Deployments without a probe, like those of workers,
+// only need to stay up.
+func (s *service) await_healthy(ctx context.Context, deployment *database.ApplicationDeployment, spec runtime.Spec) error {
+	app, err := s.repository.FindOne(deployment.AppID)
+	if err != nil {
+		return err
+	}
+
+	var probe *runtime.Probe
+	if app != nil && listens_on_port(app.Type) {
+		timeout := time.Duration(max(app.HealthCheckTimeoutSeconds, 1)) * time.Second
+		probe = runtime.NewProbe(app.HealthCheckType, app.HealthCheckPath, timeout, spec)
+	} else {
+		app = &database.Application{}
+	}
+
+	deadline := time.Now().Add(s.supervision.health_gate_timeout)
+	for {
+		status, err := s.runtime.Status(deployment.AppDpID.String())
+		switch {
+		case err != nil:
+		case status.State != runtime.StateRunning:
+			return errors.New(exit_reason(status, app))
+		case probe == nil:
+			return nil
+		default:
+			err = probe.Check(ctx)
+			if err == nil {
+				return nil
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("no passing health check within %s: %w", s.supervision.health_gate_timeout, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		case <-time.After(s.supervision.health_gate_interval):
+		}
+	}
+}
+
+// drain_deployments stops the deployments replaced by current once requests
+// they were serving had drain_grace to finish.
+func (s *service) drain_deployments(ctx context.Context, previous []database.ApplicationDeployment, current *database.ApplicationDeployment) {
+	if len(previous) == 0 {
+		return
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(s.supervision.drain_grace):
+	}
+
+	for i := range previous {
+		_, err := s.stop_deployment(&previous[i], DeploymentSuperseded, "superseded by "+current.AppDpID.String())
+		if err != nil {
+			fmt.Println("Error at application_service.drain_deployments: ", err.Error())
+		}
+	}
+}
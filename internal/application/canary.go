@@ -0,0 +1,187 @@
+package application
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/salmanrf/capybara-cloud/internal/database"
+	"github.com/salmanrf/capybara-cloud/pkg/dto"
+)
+
+// Kinds of application_events rows recorded for canary releases
+const (
+	EventCanaryUpdated = "canary_updated"
+	EventCanaryPromoted = "canary_promoted"
+	EventCanaryAborted = "canary_aborted"
+)
+
+// A canary is a deployment launched with a canary weight. Once healthy it
+// serves that percentage of the traffic next to the stable deployment, the
+// newest serving one without a weight, until it is promoted or aborted.
+func is_canary(deployment *database.ApplicationDeployment) bool {
+	return deployment.CanaryWeight.Valid && IsDeploymentActive(deployment.Status)
+}
+
+// find_canary returns the application's canary that is launching or serving,
+// nil when there is none.
+func (s *service) find_canary(app_id pgtype.UUID) (*database.ApplicationDeployment, error) {
+	deployments, err := s.repository.FindDeployments(app_id)
+	if err != nil && !strings.Contains(err.Error(), "no rows") {
+		return nil, err
+	}
+
+	for i := range deployments {
+		if is_canary(&deployments[i]) {
+			return &deployments[i], nil
+		}
+	}
+
+	return nil, nil
+}
+
+// find_member_canary loads a serving canary of an application user_id can
+// access.
+func (s *service) find_member_canary(app_id string, dp_id string, user_id string) (*database.ApplicationDeployment, error) {
+	deployment, err := s.find_member_deployment(app_id, dp_id, user_id)
+	if err != nil {
+		return nil, err
+	}
+
+	if !is_canary(deployment) || !slices.Contains(GetServingDeploymentStatuses(), deployment.Status) {
+		return nil, errors.New("invalid_canary_target")
+	}
+
+	return deployment, nil
+}
+
+func (s *service) record_canary_event(deployment *database.ApplicationDeployment, user_id string, kind string, message string) {
+	user_uuid := pgtype.UUID{}
+	user_uuid.Scan(user_id)
+
+	_, err := s.repository.CreateEvent(
+		database.CreateApplicationEventParams{
+			AppID: deployment.AppID,
+			AppDpID: deployment.AppDpID,
+			Kind: kind,
+			ActorUserID: user_uuid,
+			Message: pgtype.Text{String: message, Valid: true},
+		},
+	)
+	if err != nil {
+		fmt.Println("Error at application_service.record_canary_event: ", err.Error())
+	}
+}
+
+// UpdateCanary changes the share of traffic a serving canary gets
+func (s *service) UpdateCanary(app_id string, dp_id string, user_id string, dto dto.UpdateApplicationDeploymentCanaryDto) (*database.ApplicationDeployment, error) {
+	canary, err := s.find_member_canary(app_id, dp_id, user_id)
+	if err != nil {
+		return nil, err
+	}
+
+	updated, err := s.repository.UpdateDeploymentCanary(
+		database.UpdateApplicationDeploymentCanaryParams{
+			AppDpID: canary.AppDpID,
+			CanaryWeight: pgtype.Int4{Int32: dto.CanaryWeight, Valid: true},
+			CanarySticky: dto.CanarySticky,
+		},
+	)
+	if err != nil {
+		if strings.Contains(err.Error(), "no rows") {
+			return nil, errors.New("invalid_canary_target")
+		}
+		return nil, err
+	}
+	s.changes.notify()
+
+	s.record_canary_event(updated, user_id, EventCanaryUpdated, fmt.Sprintf("canary receives %d%% of the traffic", dto.CanaryWeight))
+
+	return updated, nil
+}
+
+// PromoteCanary sends all the traffic to a serving canary. The deployments
+// it was released next to are stopped after the drain grace.
+func (s *service) PromoteCanary(app_id string, dp_id string, user_id string) (*database.ApplicationDeployment, error) {
+	canary, err := s.find_member_canary(app_id, dp_id, user_id)
+	if err != nil {
+		return nil, err
+	}
+
+	serving, err := s.find_serving_deployments(canary.AppID)
+	if err != nil {
+		return nil, err
+	}
+	previous := slices.DeleteFunc(serving, func(deployment database.ApplicationDeployment) bool {
+		return deployment.AppDpID == canary.AppDpID
+	})
+
+	promoted, err := s.repository.PromoteDeploymentCanary(canary.AppDpID)
+	if err != nil {
+		if strings.Contains(err.Error(), "no rows") {
+			return nil, errors.New("invalid_canary_target")
+		}
+		return nil, err
+	}
+	s.changes.notify()
+
+	s.record_canary_event(promoted, user_id, EventCanaryPromoted, "canary promoted to all of the traffic")
+
+	s.drains.Add(1)
+	go func() {
+		defer s.drains.Done()
+		s.drain_deployments(s.ctx, previous, promoted)
+	}()
+
+	return promoted, nil
+}
+
+// AbortCanary stops a serving canary, the stable deployment gets all the
+// traffic back.
+func (s *service) AbortCanary(app_id string, dp_id string, user_id string) (*database.ApplicationDeployment, error) {
+	canary, err := s.find_member_canary(app_id, dp_id, user_id)
+	if err != nil {
+		return nil, err
+	}
+
+	aborted, err := s.stop_deployment(canary, DeploymentSuperseded, "canary aborted by user "+user_id)
+	if err != nil {
+		return nil, err
+	}
+
+	s.record_canary_event(aborted, user_id, EventCanaryAborted, "canary aborted, the stable deployment serves all of the traffic")
+
+	return aborted, nil
+}
+
+// RecordCanaryTraffic adds the requests the ingress counted to the canaries
+// they were split for.
+func (s *service) RecordCanaryTraffic(traffic []dto.CanaryTraffic) error {
+	var record_errors error = nil
+
+	for _, counted := range traffic {
+		dp_uuid := pgtype.UUID{}
+		if err := dp_uuid.Scan(counted.DeploymentID); err != nil {
+			record_errors = errors.Join(record_errors, err)
+			continue
+		}
+
+		err := s.repository.AddDeploymentCanaryTraffic(
+			database.AddApplicationDeploymentCanaryTrafficParams{
+				AppDpID: dp_uuid,
+				CanaryRequests: counted.CanaryRequests,
+				CanaryErrors: counted.CanaryErrors,
+				StableRequests: counted.StableRequests,
+				StableErrors: counted.StableErrors,
+			},
+		)
+		if err != nil {
+			record_errors = errors.Join(record_errors, err)
+		}
+	}
+
+	return record_errors
+}
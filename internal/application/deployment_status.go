@@ -0,0 +1,91 @@
+package application
+
+import (
+	"slices"
+)
+
+const (
+	DeploymentQueued = "queued"
+	DeploymentExtracting = "extracting"
+	DeploymentBuilding = "building"
+	DeploymentStarting = "starting"
+	DeploymentRunning = "running"
+	DeploymentCrashLooping = "crash_looping"
+	DeploymentFailed = "failed"
+	DeploymentStopped = "stopped"
+	DeploymentSuperseded = "superseded"
+	DeploymentCancelled = "cancelled"
+)
+
+// deployment_transitions lists, for every status, the statuses a deployment
+// is allowed to move to next. Failed, superseded and cancelled deployments are
+// final, stopped ones can be started again from their already built
+// artifacts. Launches go back to queued when they are retried.
+var deployment_transitions = map[string][]string{
+	DeploymentQueued: {DeploymentExtracting, DeploymentFailed, DeploymentStopped, DeploymentCancelled},
+	DeploymentExtracting: {DeploymentBuilding, DeploymentFailed, DeploymentStopped, DeploymentQueued, DeploymentCancelled},
+	DeploymentBuilding: {DeploymentStarting, DeploymentFailed, DeploymentStopped, DeploymentQueued, DeploymentCancelled},
+	DeploymentStarting: {DeploymentRunning, DeploymentFailed, DeploymentStopped, DeploymentQueued, DeploymentCancelled},
+	DeploymentRunning: {DeploymentCrashLooping, DeploymentStopped, DeploymentFailed, DeploymentSuperseded},
+	DeploymentCrashLooping: {DeploymentRunning, DeploymentStopped, DeploymentFailed, DeploymentSuperseded},
+	DeploymentStopped: {DeploymentStarting, DeploymentSuperseded},
+	DeploymentFailed: {},
+	DeploymentSuperseded: {},
+	DeploymentCancelled: {},
+}
+
+func GetDeploymentStatuses() []string {
+	return []string{
+		DeploymentQueued,
+		DeploymentExtracting,
+		DeploymentBuilding,
+		DeploymentStarting,
+		DeploymentRunning,
+		DeploymentCrashLooping,
+		DeploymentFailed,
+		DeploymentStopped,
+		DeploymentSuperseded,
+		DeploymentCancelled,
+	}
+}
+
+func CanTransitionDeployment(from string, to string) bool {
+	next, ok := deployment_transitions[from]
+	if !ok {
+		return false
+	}
+
+	return slices.Contains(next, to)
+}
+
+// IsDeploymentActive reports whether a deployment in status can still
+// produce build or run output.
+func IsDeploymentActive(status string) bool {
+	switch status {
+	case DeploymentFailed, DeploymentStopped, DeploymentSuperseded, DeploymentCancelled:
+		return false
+	}
+
+	return true
+}
+
+// GetServingDeploymentStatuses lists the statuses of a deployment that owns
+// the application's process, crash looping ones are still being restarted.
+func GetServingDeploymentStatuses() []string {
+	return []string{
+		DeploymentRunning,
+		DeploymentCrashLooping,
+	}
+}
+
+// GetActiveDeploymentStatuses lists the statuses IsDeploymentActive accepts
+func GetActiveDeploymentStatuses() []string {
+	return []string{
+		DeploymentQueued,
+		DeploymentExtracting,
+		DeploymentBuilding,
+		DeploymentStarting,
+		DeploymentRunning,
+		DeploymentCrashLooping,
+	}
+}
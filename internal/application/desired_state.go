@@ -0,0 +1,13 @@
+package application
+
+const (
+	DesiredStateRunning = "running"
+	DesiredStateStopped = "stopped"
+)
+
+// Kinds of application_events rows
+const (
+	EventStopRequested = "stop_requested"
+	EventStartRequested = "start_requested"
+	EventRestartRequested = "restart_requested"
+)
@@ -0,0 +1,158 @@
+package application
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/salmanrf/capybara-cloud/internal/runtime"
+	"github.com/salmanrf/capybara-cloud/pkg/dto"
+)
+
+// change_notifier wakes route watchers whenever a deployment changes status.
+// Every watcher holds at most one pending wake up, bursts of changes collapse
+// into a single refresh.
+type change_notifier struct {
+	mu sync.Mutex
+	watchers map[chan struct{}]struct{}
+}
+
+func new_change_notifier() *change_notifier {
+	return &change_notifier{
+		watchers: make(map[chan struct{}]struct{}),
+	}
+}
+
+func (n *change_notifier) watch() (<-chan struct{}, func()) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	changes := make(chan struct{}, 1)
+	n.watchers[changes] = struct{}{}
+
+	unwatch := func() {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+
+		if _, ok := n.watchers[changes]; !ok {
+			return
+		}
+		delete(n.watchers, changes)
+		close(changes)
+	}
+
+	return changes, unwatch
+}
+
+func (n *change_notifier) notify() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for changes := range n.watchers {
+		select {
+		case changes <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// FindIngressRoutes routes every application to its newest serving deployment
+// with the port it leased, deployments without a lease aren't routed. Scaled
+// deployments are routed to every running web instance.
+// Serving canaries get their weight of the route, or all of it when there is
+// no other deployment serving. Static sites are routed to the files of their
+// newest one.
+func (s *service) FindIngressRoutes() ([]dto.IngressRoute, error) {
+	rows, err := s.repository.FindIngressRoutes(GetServingDeploymentStatuses())
+	if err != nil {
+		return nil, err
+	}
+
+	routes := []dto.IngressRoute{}
+	routed := map[string]int{}
+	canaries := []dto.IngressRoute{}
+	for _, row := range rows {
+		if is_static_site(row.AppType) {
+			if _, ok := routed[row.AppID.String()]; ok {
+				continue
+			}
+			static_root, err := runtime.StaticRoot(runtime.SourceDir(row.ArtifactsPath))
+			if err != nil {
+				fmt.Println("Error at application_service.FindIngressRoutes: ", err.Error())
+				continue
+			}
+			routed[row.AppID.String()] = len(routes)
+			routes = append(routes, dto.IngressRoute{
+				AppID: row.AppID.String(),
+				AppName: row.AppName,
+				ProjectName: row.ProjectName,
+				AppSlug: row.AppSlug,
+				ProjectSlug: row.ProjectSlug,
+				DeploymentID: row.AppDpID.String(),
+				StaticRoot: static_root,
+			})
+			continue
+		}
+		// workers and cron jobs don't take requests
+		if !listens_on_port(row.AppType) {
+			continue
+		}
+
+		// a PORT variable is the user's to set, only a lease says the port is ours
+		if !row.LeasedPort.Valid {
+			continue
+		}
+		port := format_port(row.LeasedPort.Int32)
+		ports := []string{port}
+		for _, instance_port := range row.WebInstancePorts {
+			ports = append(ports, format_port(instance_port))
+		}
+
+		route := dto.IngressRoute{
+			AppID: row.AppID.String(),
+			AppName: row.AppName,
+			ProjectName: row.ProjectName,
+			AppSlug: row.AppSlug,
+			ProjectSlug: row.ProjectSlug,
+			DeploymentID: row.AppDpID.String(),
+			Port: port,
+			Ports: ports,
+		}
+
+		// rows come newest first for every application
+		if row.CanaryWeight.Valid {
+			route.Canary = &dto.IngressCanary{
+				DeploymentID: route.DeploymentID,
+				Port: port,
+				Ports: ports,
+				Weight: row.CanaryWeight.Int32,
+				Sticky: row.CanarySticky,
+			}
+			canaries = append(canaries, route)
+			continue
+		}
+		if _, ok := routed[route.AppID]; ok {
+			continue
+		}
+		routed[route.AppID] = len(routes)
+		routes = append(routes, route)
+	}
+
+	for _, canary := range canaries {
+		i, ok := routed[canary.AppID]
+		if !ok {
+			canary.Canary = nil
+			routed[canary.AppID] = len(routes)
+			routes = append(routes, canary)
+			continue
+		}
+		if routes[i].Canary == nil {
+			routes[i].Canary = canary.Canary
+		}
+	}
+
+	return routes, nil
+}
+
+func (s *service) WatchDeploymentChanges() (<-chan struct{}, func()) {
+	return s.changes.watch()
+}
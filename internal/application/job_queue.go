@@ -0,0 +1,371 @@
+package application
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/salmanrf/capybara-cloud/internal/database"
+)
+
+const (
+	JobQueued = "queued"
+	JobRunning = "running"
+	JobSucceeded = "succeeded"
+	JobFailed = "failed"
+	JobCancelled = "cancelled"
+)
+
+// QueueOptions configures the workers launching queued deployments. The
+// concurrency caps count the running jobs of every instance sharing the
+// database. Jobs whose worker hasn't checked in for LockTimeout are picked up
+// again by another one.
+type QueueOptions struct {
+	Workers int
+	OrgConcurrency int32
+	AppConcurrency int32
+	MaxAttempts int32
+	PollInterval time.Duration
+	RetryBackoff time.Duration
+	MaxRetryBackoff time.Duration
+	LockTimeout time.Duration
+}
+
+func DefaultQueueOptions() QueueOptions {
+	return QueueOptions{
+		Workers: 4,
+		OrgConcurrency: 2,
+		AppConcurrency: 1,
+		MaxAttempts: 3,
+		PollInterval: 2 * time.Second,
+		RetryBackoff: 15 * time.Second,
+		MaxRetryBackoff: 5 * time.Minute,
+		LockTimeout: time.Minute,
+	}
+}
+
+// launch_cancelled is the cause of job contexts cancelled on request
+var launch_cancelled = errors.New("cancelled")
+
+// transient_error marks launch failures worth another attempt, like the git
+// host or the artifact store being unreachable for a moment.
+type transient_error struct {
+	err error
+}
+
+func (e *transient_error) Error() string {
+	return e.err.Error()
+}
+
+func (e *transient_error) Unwrap() error {
+	return e.err
+}
+
+func transient(err error) error {
+	return &transient_error{err}
+}
+
+func is_transient(err error) bool {
+	var target *transient_error
+	return errors.As(err, &target)
+}
+
+// job_queue holds what the workers of this instance share: who they claim
+// jobs as, a wake up signal for jobs enqueued here and the cancel funcs of
+// the launches they run.
+type job_queue struct {
+	options QueueOptions
+	worker_id string
+	wake chan struct{}
+	mu sync.Mutex
+	running map[pgtype.UUID]context.CancelCauseFunc
+}
+
+func new_job_queue(options QueueOptions) *job_queue {
+	hostname, _ := os.Hostname()
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+
+	return &job_queue{
+		options: options,
+		worker_id: fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), hex.EncodeToString(suffix)),
+		wake: make(chan struct{}, 1),
+		running: make(map[pgtype.UUID]context.CancelCauseFunc),
+	}
+}
+
+// notify wakes an idle worker instead of letting it wait for the next poll
+func (q *job_queue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *job_queue) track(app_dp_id pgtype.UUID, cancel context.CancelCauseFunc) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.running[app_dp_id] = cancel
+}
+
+func (q *job_queue) untrack(app_dp_id pgtype.UUID) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.running, app_dp_id)
+}
+
+// cancel stops the launch of app_dp_id if one of this instance's workers runs
+// it, others find out on their next heartbeat.
+func (q *job_queue) cancel(app_dp_id pgtype.UUID) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if cancel, ok := q.running[app_dp_id]; ok {
+		cancel(launch_cancelled)
+	}
+}
+
+// backoff doubles the delay before every retry, up to MaxRetryBackoff
+func (q *job_queue) backoff(attempts int32) time.Duration {
+	delay := q.options.RetryBackoff
+	for i := int32(1); i < attempts && delay < q.options.MaxRetryBackoff; i++ {
+		delay *= 2
+	}
+
+	return min(delay, q.options.MaxRetryBackoff)
+}
+
+// RunDeploymentWorkers launches queued deployments with the configured number
+// of workers until ctx is done.
+func (s *service) RunDeploymentWorkers(ctx context.Context) {
+	var workers sync.WaitGroup
+
+	for range s.jobs.options.Workers {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			s.work(ctx)
+		}()
+	}
+
+	workers.Wait()
+}
+
+func (s *service) work(ctx context.Context) {
+	for {
+		job, err := s.claim_job()
+		if err == nil {
+			s.run_job(ctx, job)
+			continue
+		}
+		if !strings.Contains(err.Error(), "no rows") {
+			fmt.Println("Error at application_service.work - claiming: ", err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.jobs.wake:
+		case <-time.After(s.jobs.options.PollInterval):
+		}
+	}
+}
+
+func (s *service) claim_job() (*database.ApplicationDeploymentJob, error) {
+	return s.repository.ClaimDeploymentJob(
+		database.ClaimApplicationDeploymentJobParams{
+			LockedBy: s.jobs.worker_id,
+			LockTimeoutSeconds: int32(s.jobs.options.LockTimeout / time.Second),
+			OrgConcurrency: s.jobs.options.OrgConcurrency,
+			AppConcurrency: s.jobs.options.AppConcurrency,
+		},
+	)
+}
+
+// run_job launches the deployment of a claimed job and settles the job with
+// the outcome. Launches that should be retried put the job back in the queue
+// with a backoff until it runs out of attempts.
+func (s *service) run_job(parent context.Context, job *database.ApplicationDeploymentJob) *database.ApplicationDeployment {
+	ctx, cancel := context.WithCancelCause(parent)
+	defer cancel(nil)
+	if job.CancelRequested {
+		cancel(launch_cancelled)
+	}
+
+	s.jobs.track(job.AppDpID, cancel)
+	defer s.jobs.untrack(job.AppDpID)
+	defer s.heartbeat_job(job, cancel)()
+
+	can_retry := job.Attempts < s.jobs.options.MaxAttempts
+	deployment, err := s.launch_job(ctx, job, can_retry)
+
+	switch {
+	case err != nil && can_retry:
+		fmt.Println("Error at application_service.run_job - retrying: ", err.Error())
+		s.retry_job(job, err)
+	case err != nil:
+		fmt.Println("Error at application_service.run_job - giving up: ", err.Error())
+		s.finish_job(job, JobFailed, err.Error())
+		s.give_up_launch(job, err)
+	case deployment.Status == DeploymentFailed:
+		s.finish_job(job, JobFailed, deployment.FailureReason.String)
+	case deployment.Status == DeploymentCancelled:
+		s.finish_job(job, JobCancelled, "")
+	default:
+		s.finish_job(job, JobSucceeded, "")
+	}
+
+	return deployment
+}
+
+// launch_job loads the job's deployment and launches it. Deployments left
+// mid-launch by an interrupted attempt, e.g. their worker went away, are put
+// back to queued first. Deployments settled while waiting are left alone.
+func (s *service) launch_job(ctx context.Context, job *database.ApplicationDeploymentJob, can_retry bool) (*database.ApplicationDeployment, error) {
+	deployment, err := s.repository.FindOneDeployment(
+		database.FindOneApplicationDeploymentParams{
+			AppDpID: job.AppDpID,
+			AppID: job.AppID,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	switch deployment.Status {
+	case DeploymentQueued:
+	case DeploymentExtracting, DeploymentBuilding, DeploymentStarting:
+		deployment, err = s.transition_deployment(deployment, DeploymentQueued, "requeued after an interrupted attempt")
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return deployment, nil
+	}
+
+	return s.launch_deployment(ctx, deployment, can_retry)
+}
+
+// heartbeat_job keeps the job's lock fresh while it runs and picks up
+// cancellations requested through other instances. The returned func stops
+// it.
+func (s *service) heartbeat_job(job *database.ApplicationDeploymentJob, cancel context.CancelCauseFunc) func() {
+	done := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(s.jobs.options.LockTimeout / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			current, err := s.repository.HeartbeatDeploymentJob(
+				database.HeartbeatApplicationDeploymentJobParams{
+					AppDpJobID: job.AppDpJobID,
+					LockedBy: s.jobs.worker_id,
+				},
+			)
+			if err != nil {
+				fmt.Println("Error at application_service.heartbeat_job: ", err.Error())
+				continue
+			}
+			if current.CancelRequested {
+				cancel(launch_cancelled)
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+func (s *service) retry_job(job *database.ApplicationDeploymentJob, cause error) {
+	_, err := s.repository.RetryDeploymentJob(
+		database.RetryApplicationDeploymentJobParams{
+			AppDpJobID: job.AppDpJobID,
+			LockedBy: s.jobs.worker_id,
+			RunAfter: pgtype.Timestamp{Time: time.Now().Add(s.jobs.backoff(job.Attempts)), Valid: true},
+			LastError: pgtype.Text{String: cause.Error(), Valid: true},
+		},
+	)
+	if err != nil {
+		fmt.Println("Error at application_service.retry_job: ", err.Error())
+	}
+}
+
+func (s *service) finish_job(job *database.ApplicationDeploymentJob, status string, last_error string) {
+	_, err := s.repository.FinishDeploymentJob(
+		database.FinishApplicationDeploymentJobParams{
+			AppDpJobID: job.AppDpJobID,
+			LockedBy: s.jobs.worker_id,
+			Status: status,
+			LastError: pgtype.Text{String: last_error, Valid: last_error != ""},
+		},
+	)
+	if err != nil {
+		fmt.Println("Error at application_service.finish_job: ", err.Error())
+	}
+}
+
+// give_up_launch fails a deployment whose last attempt ended without settling
+// it, so it doesn't stay queued or mid-launch forever.
+func (s *service) give_up_launch(job *database.ApplicationDeploymentJob, cause error) {
+	deployment, err := s.repository.FindOneDeployment(
+		database.FindOneApplicationDeploymentParams{
+			AppDpID: job.AppDpID,
+			AppID: job.AppID,
+		},
+	)
+	if err != nil {
+		fmt.Println("Error at application_service.give_up_launch: ", err.Error())
+		return
+	}
+
+	switch deployment.Status {
+	case DeploymentQueued, DeploymentExtracting, DeploymentBuilding, DeploymentStarting:
+		if _, err := s.fail_deployment(deployment, cause); err != nil {
+			fmt.Println("Error at application_service.give_up_launch: ", err.Error())
+		}
+	}
+}
+
+// CancelDeployment cancels a deployment that isn't running yet. Queued ones
+// are cancelled right away, launches in progress are stopped by their worker
+// and end up cancelled shortly after.
+func (s *service) CancelDeployment(app_id string, dp_id string, user_id string) (*database.ApplicationDeployment, error) {
+	deployment, err := s.find_member_deployment(app_id, dp_id, user_id)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.repository.CancelQueuedDeploymentJob(deployment.AppDpID)
+	if err == nil {
+		return s.transition_deployment(deployment, DeploymentCancelled, "cancelled by user "+user_id)
+	}
+	if !strings.Contains(err.Error(), "no rows") {
+		return nil, err
+	}
+
+	if _, err := s.repository.RequestDeploymentJobCancel(deployment.AppDpID); err != nil {
+		if strings.Contains(err.Error(), "no rows") {
+			return nil, errors.New("invalid_cancel_target")
+		}
+		return nil, err
+	}
+	s.jobs.cancel(deployment.AppDpID)
+
+	return deployment, nil
+}
@@ -0,0 +1,401 @@
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/salmanrf/capybara-cloud/internal/database"
+	"github.com/salmanrf/capybara-cloud/internal/runtime"
+)
+
+// variables_env flattens stored config variables into environment strings
+func variables_env(variables_json []byte) (map[string]string, error) {
+	env := make(map[string]string)
+	if len(variables_json) == 0 {
+		return env, nil
+	}
+
+	var variables map[string]any
+	if err := json.Unmarshal(variables_json, &variables); err != nil {
+		return nil, err
+	}
+
+	for key, val := range variables {
+		env[key] = fmt.Sprint(val)
+	}
+
+	return env, nil
+}
+
+func deployment_spec(deployment *database.ApplicationDeployment) (runtime.Spec, error) {
+	variables, err := variables_env(deployment.VariablesSnapshotJson)
+	if err != nil {
+		return runtime.Spec{}, err
+	}
+
+	return runtime.Spec{
+		DeploymentID: deployment.AppDpID.String(),
+		AppID: deployment.AppID.String(),
+		ArtifactsPath: deployment.ArtifactsPath,
+		Variables: variables,
+		Stack: deployment.Stack.String,
+	}, nil
+}
+
+// transition_deployment validates and persists a status change. Reason is
+// stored on the transition and, when failing or crash looping, as the
+// failure reason.
+func (s *service) transition_deployment(deployment *database.ApplicationDeployment, to string, reason string) (*database.ApplicationDeployment, error) {
+	if !CanTransitionDeployment(deployment.Status, to) {
+		return nil, errors.New("invalid_transition")
+	}
+
+	failure_reason := pgtype.Text{}
+	if to == DeploymentFailed || to == DeploymentCrashLooping {
+		failure_reason = pgtype.Text{String: reason, Valid: true}
+	}
+
+	updated, err := s.repository.TransitionDeployment(
+		database.UpdateApplicationDeploymentStatusParams{
+			AppDpID: deployment.AppDpID,
+			FromStatus: deployment.Status,
+			ToStatus: to,
+			FailureReason: failure_reason,
+		},
+		pgtype.Text{String: reason, Valid: reason != ""},
+	)
+	if err != nil {
+		if strings.Contains(err.Error(), "no rows") {
+			return nil, errors.New("transition_conflict")
+		}
+		return nil, err
+	}
+
+	if !IsDeploymentActive(updated.Status) {
+		s.supervisor.unwatch(updated.AppDpID.String())
+		s.logs.close(updated.AppDpID.String())
+		s.release_port(updated.AppDpID)
+		s.stop_processes(updated.AppDpID)
+	}
+	s.changes.notify()
+
+	return updated, nil
+}
+
+// record_log persists a runtime output line and hands it to live followers.
+// Lines that fail to persist are dropped rather than stalling the process.
+func (s *service) record_log(app_dp_id pgtype.UUID, line runtime.LogLine) {
+	stored, err := s.repository.CreateDeploymentLog(
+		database.CreateApplicationDeploymentLogParams{
+			AppDpID: app_dp_id,
+			Phase: line.Phase,
+			Stream: line.Stream,
+			Line: line.Line,
+			LoggedAt: pgtype.Timestamp{Time: line.Time, Valid: true},
+			ProcessType: pgtype.Text{String: line.Process, Valid: line.Process != ""},
+		},
+	)
+	if err != nil {
+		fmt.Println("Error at application_service.record_log: ", err.Error())
+		return
+	}
+
+	s.logs.publish(app_dp_id.String(), *stored)
+}
+
+// find_serving_deployments returns the deployments of the application that
+// own a process, newest first.
+func (s *service) find_serving_deployments(app_id pgtype.UUID) ([]database.ApplicationDeployment, error) {
+	serving := []database.ApplicationDeployment{}
+
+	for _, status := range GetServingDeploymentStatuses() {
+		deployments, err := s.repository.FindDeploymentsByStatus(
+			database.FindApplicationDeploymentsByStatusParams{
+				AppID: app_id,
+				Status: status,
+			},
+		)
+		if err != nil && !strings.Contains(err.Error(), "no rows") {
+			return nil, err
+		}
+		serving = append(serving, deployments...)
+	}
+
+	slices.SortStableFunc(serving, func(a, b database.ApplicationDeployment) int {
+		return b.CreatedAt.Time.Compare(a.CreatedAt.Time)
+	})
+
+	return serving, nil
+}
+
+// stop_deployment stops the process of a serving deployment and moves it to
+// status. Deployments the runtime no longer knows, e.g. after a server
+// restart, are only moved.
+func (s *service) stop_deployment(deployment *database.ApplicationDeployment, status string, reason string) (*database.ApplicationDeployment, error) {
+	// unwatch first so the supervisor doesn't mistake the stop for a crash
+	s.supervisor.unwatch(deployment.AppDpID.String())
+
+	if err := s.runtime.Stop(deployment.AppDpID.String()); err != nil && err.Error() != "not_found" {
+		return nil, err
+	}
+
+	return s.transition_deployment(deployment, status, reason)
+}
+
+// fail_deployment records a runtime error as the failure reason. Runtime
+// errors are not returned, only errors persisting the status are.
+func (s *service) fail_deployment(deployment *database.ApplicationDeployment, cause error) (*database.ApplicationDeployment, error) {
+	fmt.Println("Deployment failed", deployment.AppDpID.String(), deployment.Status, cause.Error())
+	return s.transition_deployment(deployment, DeploymentFailed, cause.Error())
+}
+
+// record_stack stores the stack a deployment is built as, it is only shown
+// so failing to store it doesn't fail the launch.
+func (s *service) record_stack(app_dp_id pgtype.UUID, stack string) {
+	_, err := s.repository.UpdateDeploymentStack(
+		database.UpdateApplicationDeploymentStackParams{
+			AppDpID: app_dp_id,
+			Stack: pgtype.Text{String: stack, Valid: true},
+		},
+	)
+	if err != nil {
+		fmt.Println("Error at application_service.record_stack: ", err.Error())
+	}
+}
+
+// runtime_spec builds the runtime spec of a deployment with its output
+// wired to the deployment logs.
+func (s *service) runtime_spec(deployment *database.ApplicationDeployment) (runtime.Spec, error) {
+	spec, err := deployment_spec(deployment)
+	if err != nil {
+		return runtime.Spec{}, err
+	}
+
+	// limits are read per spec, changed ones apply once the app is restarted
+	app, err := s.repository.FindOne(deployment.AppID)
+	if err != nil {
+		return runtime.Spec{}, err
+	}
+	if app != nil {
+		spec.Resources = runtime.Resources{CPUMillis: app.CpuMillis, MemoryMB: app.MemoryMb}
+	}
+
+	if app != nil && is_cron_job(app.Type) {
+		// built with the command its runs start
+		spec.Command = app.CronCommand.String
+	}
+	spec.Static = app != nil && is_static_site(app.Type)
+	spec.Unbound = !app_listens_on_port(app)
+
+	if app_listens_on_port(app) {
+		// deployments share the host, a PORT from the config would collide
+		port, err := s.lease_port(deployment.AppDpID, 0)
+		if err != nil {
+			return runtime.Spec{}, err
+		}
+		spec.Variables["PORT"] = format_port(port)
+	}
+
+	spec.BundleLimits = s.bundle_limits
+
+	app_dp_id := deployment.AppDpID
+	spec.OnLog = func(line runtime.LogLine) {
+		s.record_log(app_dp_id, line)
+	}
+
+	return spec, nil
+}
+
+// launch_deployment walks a queued deployment through extracting, building
+// and starting until it runs, replacing a serving deployment through
+// switch_deployment. Builds of cron_job and static_site applications are
+// activated without a process, the release command of others runs before
+// they start. Runtime errors settle the deployment through
+// abort_launch. Errors are only returned when the launch should be retried:
+// a transient failure requeued it or its status couldn't be persisted.
+func (s *service) launch_deployment(ctx context.Context, deployment *database.ApplicationDeployment, can_retry bool) (*database.ApplicationDeployment, error) {
+	current := deployment
+
+	advance := func(to string) error {
+		next, err := s.transition_deployment(current, to, "")
+		if err != nil {
+			return err
+		}
+		current = next
+		return nil
+	}
+	abort := func(cause error) (*database.ApplicationDeployment, error) {
+		return s.abort_launch(ctx, current, cause, can_retry)
+	}
+
+	if ctx.Err() != nil {
+		return abort(context.Cause(ctx))
+	}
+	if err := advance(DeploymentExtracting); err != nil {
+		return nil, err
+	}
+
+	if err := s.fetch_git_source(ctx, deployment); err != nil {
+		return abort(err)
+	}
+	if err := s.fetch_bundle(deployment); err != nil {
+		return abort(err)
+	}
+
+	spec, err := s.runtime_spec(deployment)
+	if err != nil {
+		return abort(err)
+	}
+	spec.Context = ctx
+
+	var phase_err error
+	spec.OnPhase = func(phase string) {
+		if phase == runtime.PhaseBuilding && current.Status == DeploymentExtracting {
+			phase_err = advance(DeploymentBuilding)
+		}
+	}
+	// starts go on with the stack the build was made for
+	spec.OnStack = func(stack string) {
+		spec.Stack = stack
+		s.record_stack(deployment.AppDpID, stack)
+	}
+
+	err = s.runtime.Prepare(spec)
+	if phase_err != nil {
+		return nil, phase_err
+	}
+	if err != nil {
+		return abort(err)
+	}
+
+	if current.Status == DeploymentExtracting {
+		if err := advance(DeploymentBuilding); err != nil {
+			return nil, err
+		}
+	}
+	if err := advance(DeploymentStarting); err != nil {
+		return nil, err
+	}
+	if ctx.Err() != nil {
+		return abort(context.Cause(ctx))
+	}
+
+	previous, err := s.find_serving_deployments(current.AppID)
+	if err != nil {
+		return abort(err)
+	}
+	app, err := s.repository.FindOne(current.AppID)
+	if err != nil {
+		return abort(err)
+	}
+	if builds_only(app) {
+		return s.activate_build(current, app, previous)
+	}
+	if err := s.release_deployment(ctx, current, app, spec); err != nil {
+		return abort(err)
+	}
+	if len(previous) > 0 {
+		return s.switch_deployment(ctx, current, spec, previous, can_retry)
+	}
+
+	return s.run_deployment(current, spec)
+}
+
+// activate_build makes a built deployment of an application without a
+// process the running one, the build cron runs start from or the files the
+// ingress serves. There is no process to switch traffic to, the previous
+// deployments are superseded right away while runs they started carry on.
+func (s *service) activate_build(deployment *database.ApplicationDeployment, app *database.Application, previous []database.ApplicationDeployment) (*database.ApplicationDeployment, error) {
+	reason := "built, runs on schedule"
+	if is_static_site(app.Type) {
+		reason = "built, served by the ingress"
+	}
+
+	running, err := s.transition_deployment(deployment, DeploymentRunning, reason)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range previous {
+		_, err := s.stop_deployment(&previous[i], DeploymentSuperseded, "superseded by "+running.AppDpID.String())
+		if err != nil {
+			fmt.Println("Error at application_service.activate_build: ", err.Error())
+		}
+	}
+
+	return running, nil
+}
+
+// abort_launch settles a launch that won't reach running. Cancelled launches
+// end cancelled, transient failures go back to the queue while the job has
+// attempts left and everything else fails the deployment.
+func (s *service) abort_launch(ctx context.Context, deployment *database.ApplicationDeployment, cause error, can_retry bool) (*database.ApplicationDeployment, error) {
+	if errors.Is(context.Cause(ctx), launch_cancelled) {
+		return s.transition_deployment(deployment, DeploymentCancelled, "launch cancelled")
+	}
+	if ctx.Err() != nil {
+		// the server is shutting down, the job is picked up again later
+		cause = transient(cause)
+	}
+
+	if can_retry && is_transient(cause) {
+		requeued, err := s.transition_deployment(deployment, DeploymentQueued, "retrying after "+cause.Error())
+		if err != nil {
+			return nil, err
+		}
+		return requeued, cause
+	}
+
+	return s.fail_deployment(deployment, cause)
+}
+
+// resume_deployment starts a stopped deployment again from the artifacts
+// Prepare left behind, with the variables snapshot it was created with.
+func (s *service) resume_deployment(deployment *database.ApplicationDeployment, reason string) (*database.ApplicationDeployment, error) {
+	current, err := s.transition_deployment(deployment, DeploymentStarting, reason)
+	if err != nil {
+		return nil, err
+	}
+
+	app, err := s.repository.FindOne(deployment.AppID)
+	if err != nil {
+		return s.fail_deployment(current, err)
+	}
+	if builds_only(app) {
+		return s.activate_build(current, app, nil)
+	}
+
+	spec, err := s.runtime_spec(deployment)
+	if err != nil {
+		return s.fail_deployment(current, err)
+	}
+
+	return s.run_deployment(current, spec)
+}
+
+// run_deployment starts a deployment in the starting status and records what
+// the runtime launched.
+func (s *service) run_deployment(deployment *database.ApplicationDeployment, spec runtime.Spec) (*database.ApplicationDeployment, error) {
+	instance, err := s.runtime.Start(spec)
+	if err != nil {
+		return s.fail_deployment(deployment, err)
+	}
+
+	if err := s.record_process(deployment, instance); err != nil {
+		return nil, err
+	}
+
+	running, err := s.transition_deployment(deployment, DeploymentRunning, "")
+	if err != nil {
+		return nil, err
+	}
+	s.watch_deployment(running)
+
+	return running, nil
+}
@@ -0,0 +1,94 @@
+package application
+
+import (
+	"sync"
+
+	"github.com/salmanrf/capybara-cloud/internal/database"
+)
+
+// Followers that fall this far behind miss live lines, they get a
+// missed_log_lines marker instead and catch up from the stored history.
+const log_subscriber_buffer = 256
+
+// missed_log_lines has no id, no stored line has one of zero
+var missed_log_lines = database.ApplicationDeploymentLog{}
+
+type log_subscriber struct {
+	lines chan database.ApplicationDeploymentLog
+	// set once the marker is queued, until the follower has room again
+	missed bool
+}
+
+// log_broker fans persisted deployment log lines out to live followers.
+type log_broker struct {
+	mu sync.Mutex
+	subscribers map[string]map[*log_subscriber]struct{}
+}
+
+func new_log_broker() *log_broker {
+	return &log_broker{
+		subscribers: make(map[string]map[*log_subscriber]struct{}),
+	}
+}
+
+func (b *log_broker) subscribe(dp_id string) (<-chan database.ApplicationDeploymentLog, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// the extra slot always has room for the marker
+	sub := &log_subscriber{
+		lines: make(chan database.ApplicationDeploymentLog, log_subscriber_buffer+1),
+	}
+	if b.subscribers[dp_id] == nil {
+		b.subscribers[dp_id] = make(map[*log_subscriber]struct{})
+	}
+	b.subscribers[dp_id][sub] = struct{}{}
+
+	unsubscribe := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if _, ok := b.subscribers[dp_id][sub]; !ok {
+			return
+		}
+		delete(b.subscribers[dp_id], sub)
+		if len(b.subscribers[dp_id]) == 0 {
+			delete(b.subscribers, dp_id)
+		}
+		close(sub.lines)
+	}
+
+	return sub.lines, unsubscribe
+}
+
+// publish never blocks the runtime, a full follower misses the line and is
+// told so once. Only publish sends, under mu, so a full buffer stays full
+// until the follower reads.
+func (b *log_broker) publish(dp_id string, line database.ApplicationDeploymentLog) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subscribers[dp_id] {
+		if len(sub.lines) < log_subscriber_buffer {
+			sub.lines <- line
+			sub.missed = false
+			continue
+		}
+		if !sub.missed {
+			sub.lines <- missed_log_lines
+			sub.missed = true
+		}
+	}
+}
+
+// close ends every follow of the deployment, used once it can no longer
+// produce output.
+func (b *log_broker) close(dp_id string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subscribers[dp_id] {
+		close(sub.lines)
+	}
+	delete(b.subscribers, dp_id)
+}
@@ -0,0 +1,76 @@
+package application
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/salmanrf/capybara-cloud/internal/database"
+)
+
+// PortRange bounds the host ports deployments are leased, both inclusive
+type PortRange struct {
+	Start int32
+	End int32
+}
+
+func DefaultPortRange() PortRange {
+	return PortRange{Start: 20000, End: 29999}
+}
+
+// concurrent launches can pick the same free port, the losing insert finds
+// no row and tries the next one
+const port_lease_attempts = 5
+
+// lease_port returns the port held by an instance of the deployment, leasing
+// the lowest free one of the range on its first start. Instance 0 is the
+// deployment's own process, the others are its scaled web instances.
+func (s *service) lease_port(app_dp_id pgtype.UUID, instance_index int32) (int32, error) {
+	lease, err := s.repository.FindDeploymentPort(
+		database.FindApplicationDeploymentPortParams{
+			AppDpID: app_dp_id,
+			InstanceIndex: instance_index,
+		},
+	)
+	if err == nil {
+		return lease.Port, nil
+	}
+	if !strings.Contains(err.Error(), "no rows") {
+		return 0, err
+	}
+
+	for range port_lease_attempts {
+		lease, err = s.repository.LeaseDeploymentPort(
+			database.LeaseApplicationDeploymentPortParams{
+				AppDpID: app_dp_id,
+				RangeStart: s.ports.Start,
+				RangeEnd: s.ports.End,
+				InstanceIndex: instance_index,
+			},
+		)
+		if err == nil {
+			return lease.Port, nil
+		}
+		if !strings.Contains(err.Error(), "no rows") {
+			return 0, err
+		}
+	}
+
+	// ports free up as deployments stop, the launch can wait for one
+	return 0, transient(errors.New("no_ports_available"))
+}
+
+// release_port gives the ports of all instances of the deployment back to
+// the range, deployments that never leased one are fine.
+func (s *service) release_port(app_dp_id pgtype.UUID) {
+	if err := s.repository.ReleaseDeploymentPort(app_dp_id); err != nil {
+		fmt.Println("Error at application_service.release_port: ", err.Error())
+	}
+}
+
+func format_port(port int32) string {
+	return strconv.Itoa(int(port))
+}
@@ -0,0 +1,514 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/salmanrf/capybara-cloud/internal/database"
+	"github.com/salmanrf/capybara-cloud/internal/runtime"
+)
+
+// Statuses of application_deployment_processes rows
+const (
+	ProcessRunning = "running"
+	ProcessCrashLooping = "crash_looping"
+	ProcessStopped = "stopped"
+	ProcessSucceeded = "succeeded"
+	ProcessFailed = "failed"
+)
+
+// Kind of application_events rows recorded when an instance of a process
+// type other than the main one is restarted
+const EventProcessRestarted = "process_restarted"
+
+// instances of a process type run when the application has no scale for it
+const default_process_scale = 1
+
+func is_process_up(status string) bool {
+	return status == ProcessRunning || status == ProcessCrashLooping
+}
+
+// process_runtime_id is the id the runtime knows an instance of one of the
+// deployment's other process types by, or a scaled instance of its main one.
+// The first instance of the main process runs under the id of the deployment
+// itself.
+func process_runtime_id(dp_id string, process_type string, index int32) string {
+	return fmt.Sprintf("%s.%s.%d", dp_id, process_type, index)
+}
+
+// runtime_deployment_id is the id of the deployment a process the runtime
+// lists belongs to
+func runtime_deployment_id(runtime_id string) string {
+	dp_id, _, _ := strings.Cut(runtime_id, ".")
+	return dp_id
+}
+
+// deployment_plan reads the process types of a built deployment from its
+// sources. Deployments whose sources are gone only run their main process.
+func deployment_plan(spec runtime.Spec) (runtime.StackPlan, error) {
+	plan, err := runtime.PlanDeployment(spec)
+	if errors.Is(err, fs.ErrNotExist) {
+		return runtime.StackPlan{}, nil
+	}
+
+	return plan, err
+}
+
+// process_spec runs an instance of a process type on the build of the
+// deployment spec is for, under an id of its own. Only the main process is
+// bound to the port the ingress proxies to.
+func process_spec(spec runtime.Spec, process runtime.ProcfileProcess, index int32, leased_port bool) runtime.Spec {
+	instance := spec
+	instance.DeploymentID = process_runtime_id(spec.DeploymentID, process.Name, index)
+	instance.BuildID = spec.DeploymentID
+	instance.Process = process.Name
+	instance.Command = process.Command
+	instance.Variables = maps.Clone(spec.Variables)
+	if leased_port {
+		delete(instance.Variables, "PORT")
+	}
+
+	return instance
+}
+
+// record_process stores the main process the runtime started for a
+// deployment.
+func (s *service) record_process(deployment *database.ApplicationDeployment, instance *runtime.Instance) error {
+	process_type := instance.Process
+	if process_type == "" {
+		process_type = runtime.ProcessWeb
+	}
+	status := ProcessRunning
+	if deployment.Status == DeploymentCrashLooping {
+		status = ProcessCrashLooping
+	}
+
+	_, err := s.repository.UpsertDeploymentProcess(
+		database.UpsertApplicationDeploymentProcessParams{
+			AppDpID: deployment.AppDpID,
+			ProcessType: process_type,
+			InstanceIndex: 0,
+			RuntimeID: deployment.AppDpID.String(),
+			ProcessName: instance.ProcessName,
+			ContainerName: instance.ContainerName,
+			Status: status,
+			RestartCount: deployment.RestartCount,
+		},
+	)
+
+	return err
+}
+
+func (s *service) finish_process(process *database.ApplicationDeploymentProcess, status string, exit_code pgtype.Int4) {
+	_, err := s.repository.UpdateDeploymentProcessStatus(
+		database.UpdateApplicationDeploymentProcessStatusParams{
+			AppDpProcID: process.AppDpProcID,
+			Status: status,
+			ExitCode: exit_code,
+			RestartCount: process.RestartCount,
+		},
+	)
+	if err != nil {
+		fmt.Println("Error at application_service.finish_process: ", err.Error())
+	}
+}
+
+// release_deployment runs the release command of the deployment's Procfile,
+// e.g. its migrations, once before the deployment starts. A release that
+// doesn't succeed fails the launch.
+func (s *service) release_deployment(ctx context.Context, deployment *database.ApplicationDeployment, app *database.Application, spec runtime.Spec) error {
+	plan, err := deployment_plan(spec)
+	if err != nil {
+		return err
+	}
+	if plan.ReleaseCommand == "" {
+		return nil
+	}
+
+	release := process_spec(spec, runtime.ProcfileProcess{Name: runtime.ProcessRelease, Command: plan.ReleaseCommand}, 0, app_listens_on_port(app))
+
+	instance, err := s.runtime.Start(release)
+	if err != nil {
+		return err
+	}
+	process, err := s.repository.UpsertDeploymentProcess(
+		database.UpsertApplicationDeploymentProcessParams{
+			AppDpID: deployment.AppDpID,
+			ProcessType: runtime.ProcessRelease,
+			InstanceIndex: 0,
+			RuntimeID: release.DeploymentID,
+			ProcessName: instance.ProcessName,
+			ContainerName: instance.ContainerName,
+			Status: ProcessRunning,
+		},
+	)
+	if err != nil {
+		s.runtime.Stop(release.DeploymentID)
+		return err
+	}
+
+	status, err := s.await_exit(ctx, release.DeploymentID)
+	// exited processes are kept by the runtime until they are stopped
+	if err := s.runtime.Stop(release.DeploymentID); err != nil && err.Error() != "not_found" {
+		fmt.Println("Error at application_service.release_deployment - stopping: ", err.Error())
+	}
+	if err != nil {
+		s.finish_process(process, ProcessFailed, pgtype.Int4{})
+		return err
+	}
+
+	exit_code := pgtype.Int4{Int32: int32(status.ExitCode), Valid: true}
+	if status.OOMKilled || status.ExitCode != 0 {
+		s.finish_process(process, ProcessFailed, exit_code)
+		return fmt.Errorf("release command failed, %s", exit_reason(status, app))
+	}
+	s.finish_process(process, ProcessSucceeded, exit_code)
+
+	return nil
+}
+
+// await_exit polls a process until it exits or ctx is done
+func (s *service) await_exit(ctx context.Context, runtime_id string) (*runtime.Status, error) {
+	for {
+		status, err := s.runtime.Status(runtime_id)
+		if err != nil {
+			return nil, err
+		}
+		switch status.State {
+		case runtime.StateExited:
+			return status, nil
+		case runtime.StateNotFound:
+			return nil, errors.New("process is gone")
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, context.Cause(ctx)
+		case <-time.After(s.supervision.run_poll_interval):
+		}
+	}
+}
+
+func (s *service) process_scales(app_id pgtype.UUID) (map[string]int32, error) {
+	rows, err := s.repository.FindProcessScales(app_id)
+	if err != nil && !strings.Contains(err.Error(), "no rows") {
+		return nil, err
+	}
+
+	scales := map[string]int32{}
+	for _, row := range rows {
+		scales[row.ProcessType] = row.Scale
+	}
+
+	return scales, nil
+}
+
+// run_processes runs as many instances of the deployment's other process
+// types as the application is scaled to, each supervised on its own. The
+// main process scales next to the deployment's own process, its instances
+// get ports of their own the ingress balances requests across. Instances
+// still running, e.g. since before the server restarted, are only watched
+// again and those past the scale are stopped.
+func (s *service) run_processes(deployment *database.ApplicationDeployment, app *database.Application, spec runtime.Spec) {
+	plan, err := deployment_plan(spec)
+	if err != nil {
+		fmt.Println("Error at application_service.run_processes: ", err.Error())
+		return
+	}
+	scales, err := s.process_scales(deployment.AppID)
+	if err != nil {
+		fmt.Println("Error at application_service.run_processes: ", err.Error())
+		return
+	}
+	existing, err := s.repository.FindDeploymentProcesses(deployment.AppDpID)
+	if err != nil && !strings.Contains(err.Error(), "no rows") {
+		fmt.Println("Error at application_service.run_processes: ", err.Error())
+		return
+	}
+
+	wanted := map[string]bool{deployment.AppDpID.String(): true}
+	if plan.StartProcess != "" {
+		main := runtime.ProcfileProcess{Name: plan.StartProcess, Command: plan.StartCommand}
+		for index := int32(1); index < scales[main.Name]; index++ {
+			instance := process_spec(spec, main, index, app_listens_on_port(app))
+			if app_listens_on_port(app) {
+				port, err := s.lease_port(deployment.AppDpID, index)
+				if err != nil {
+					fmt.Println("Error at application_service.run_processes - leasing port: ", err.Error())
+					continue
+				}
+				instance.Variables["PORT"] = format_port(port)
+			}
+			wanted[instance.DeploymentID] = true
+			s.run_process(deployment, app, instance, index, existing)
+		}
+	}
+	for _, process := range plan.Processes {
+		scale, ok := scales[process.Name]
+		if !ok {
+			scale = default_process_scale
+		}
+
+		for index := range scale {
+			instance := process_spec(spec, process, index, app_listens_on_port(app))
+			wanted[instance.DeploymentID] = true
+			s.run_process(deployment, app, instance, index, existing)
+		}
+	}
+
+	for i := range existing {
+		if wanted[existing[i].RuntimeID] || !is_process_up(existing[i].Status) {
+			continue
+		}
+		s.stop_process(&existing[i])
+	}
+}
+
+// run_process starts an instance of a process type unless it is running
+// already, and watches it.
+func (s *service) run_process(deployment *database.ApplicationDeployment, app *database.Application, spec runtime.Spec, index int32, existing []database.ApplicationDeploymentProcess) {
+	if s.supervisor.watching(spec.DeploymentID) {
+		return
+	}
+
+	var process *database.ApplicationDeploymentProcess
+	for i := range existing {
+		if existing[i].RuntimeID == spec.DeploymentID && is_process_up(existing[i].Status) {
+			process = &existing[i]
+		}
+	}
+
+	status, err := s.runtime.Status(spec.DeploymentID)
+	if err != nil {
+		fmt.Println("Error at application_service.run_process - status: ", err.Error())
+		return
+	}
+
+	if process == nil || status.State != runtime.StateRunning {
+		// clears whatever is left of a previous instance
+		if err := s.runtime.Stop(spec.DeploymentID); err != nil && err.Error() != "not_found" {
+			fmt.Println("Error at application_service.run_process - stopping: ", err.Error())
+		}
+
+		instance, err := s.runtime.Start(spec)
+		if err != nil {
+			// the watch restarts it like a crashed one
+			fmt.Println("Error at application_service.run_process - starting: ", err.Error())
+			instance = &runtime.Instance{}
+		}
+
+		process, err = s.repository.UpsertDeploymentProcess(
+			database.UpsertApplicationDeploymentProcessParams{
+				AppDpID: deployment.AppDpID,
+				ProcessType: spec.Process,
+				InstanceIndex: index,
+				RuntimeID: spec.DeploymentID,
+				ProcessName: instance.ProcessName,
+				ContainerName: instance.ContainerName,
+				Status: ProcessRunning,
+			},
+		)
+		if err != nil {
+			fmt.Println("Error at application_service.run_process: ", err.Error())
+			s.runtime.Stop(spec.DeploymentID)
+			return
+		}
+		// scaled web instances are routed once they run
+		s.changes.notify()
+	}
+
+	w := &process_watch{
+		deployment: deployment,
+		app: app,
+		process: process,
+		spec: spec,
+		up_since: time.Now(),
+	}
+	s.supervisor.watch(spec.DeploymentID, func(ctx context.Context) {
+		s.supervise_process(ctx, w)
+	})
+}
+
+// process_watch is what the supervisor keeps for an instance of a process
+// type other than the main one
+type process_watch struct {
+	deployment *database.ApplicationDeployment
+	app *database.Application
+	process *database.ApplicationDeploymentProcess
+	spec runtime.Spec
+	up_since time.Time
+}
+
+// supervise_process restarts an instance whenever it isn't running, backing
+// off like restart_supervised. Crash looping instances are only marked as
+// such, the deployment keeps its status.
+func (s *service) supervise_process(ctx context.Context, w *process_watch) {
+	interval := time.Duration(max(w.app.HealthCheckIntervalSeconds, 1)) * time.Second
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		status, err := s.runtime.Status(w.spec.DeploymentID)
+		if err != nil {
+			fmt.Println("Error at application_service.supervise_process - status: ", err.Error())
+			continue
+		}
+		if status.State == runtime.StateRunning {
+			if w.process.RestartCount > 0 && time.Since(w.up_since) >= s.supervision.stable_after {
+				s.settle_process(w)
+			}
+			continue
+		}
+
+		if !s.restart_process(ctx, w, exit_reason(status, w.app)) {
+			return
+		}
+	}
+}
+
+// settle_process forgets past restarts once the instance stayed up
+func (s *service) settle_process(w *process_watch) {
+	settled, err := s.repository.UpdateDeploymentProcessStatus(
+		database.UpdateApplicationDeploymentProcessStatusParams{
+			AppDpProcID: w.process.AppDpProcID,
+			Status: ProcessRunning,
+			RestartCount: 0,
+		},
+	)
+	if err != nil {
+		fmt.Println("Error at application_service.settle_process: ", err.Error())
+		return
+	}
+	w.process = settled
+}
+
+// restart_process restarts an instance after the supervisor's backoff. It
+// returns false once the watch is cancelled.
+func (s *service) restart_process(ctx context.Context, w *process_watch, reason string) bool {
+	runtime_id := w.spec.DeploymentID
+	restarts := w.process.RestartCount + 1
+
+	s.repository.CreateEvent(
+		database.CreateApplicationEventParams{
+			AppID: w.deployment.AppID,
+			AppDpID: w.deployment.AppDpID,
+			Kind: EventProcessRestarted,
+			Message: pgtype.Text{String: fmt.Sprintf("%s.%d restart %d: %s", w.process.ProcessType, w.process.InstanceIndex, restarts, reason), Valid: true},
+		},
+	)
+
+	if err := s.runtime.Stop(runtime_id); err != nil && err.Error() != "not_found" {
+		fmt.Println("Error at application_service.restart_process - stopping: ", err.Error())
+	}
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(s.supervision.backoff(restarts)):
+	}
+
+	w.up_since = time.Now()
+	instance, err := s.runtime.Start(w.spec)
+	if err != nil {
+		// counted as another crash on the next check
+		fmt.Println("Error at application_service.restart_process - starting: ", err.Error())
+		instance = &runtime.Instance{}
+	}
+	if ctx.Err() != nil {
+		s.runtime.Stop(runtime_id)
+		return false
+	}
+
+	status := ProcessRunning
+	if restarts >= s.supervision.crash_loop_threshold {
+		status = ProcessCrashLooping
+	}
+	restarted, err := s.repository.UpsertDeploymentProcess(
+		database.UpsertApplicationDeploymentProcessParams{
+			AppDpID: w.process.AppDpID,
+			ProcessType: w.process.ProcessType,
+			InstanceIndex: w.process.InstanceIndex,
+			RuntimeID: runtime_id,
+			ProcessName: instance.ProcessName,
+			ContainerName: instance.ContainerName,
+			Status: status,
+			RestartCount: restarts,
+		},
+	)
+	if err != nil {
+		fmt.Println("Error at application_service.restart_process: ", err.Error())
+		w.process.RestartCount = restarts
+		return true
+	}
+	w.process = restarted
+
+	return true
+}
+
+// stop_process stops an instance of a process type other than the main one
+func (s *service) stop_process(process *database.ApplicationDeploymentProcess) {
+	// unwatch first so the supervisor doesn't mistake the stop for a crash
+	s.supervisor.unwatch(process.RuntimeID)
+
+	if err := s.runtime.Stop(process.RuntimeID); err != nil && err.Error() != "not_found" {
+		fmt.Println("Error at application_service.stop_process: ", err.Error())
+		return
+	}
+
+	s.finish_process(process, ProcessStopped, pgtype.Int4{})
+	s.changes.notify()
+}
+
+// stop_processes stops the other processes of a deployment that is no longer
+// active. Its main process was stopped with the deployment and is only
+// marked stopped.
+func (s *service) stop_processes(app_dp_id pgtype.UUID) {
+	processes, err := s.repository.FindDeploymentProcesses(app_dp_id)
+	if err != nil && !strings.Contains(err.Error(), "no rows") {
+		fmt.Println("Error at application_service.stop_processes: ", err.Error())
+		return
+	}
+
+	for i := range processes {
+		if !is_process_up(processes[i].Status) {
+			continue
+		}
+		if processes[i].RuntimeID == app_dp_id.String() {
+			s.finish_process(&processes[i], ProcessStopped, pgtype.Int4{})
+			continue
+		}
+		s.stop_process(&processes[i])
+	}
+}
+
+// scale_processes applies a changed scale to a serving deployment
+func (s *service) scale_processes(deployment *database.ApplicationDeployment) error {
+	app, err := s.repository.FindOne(deployment.AppID)
+	if err != nil {
+		return err
+	}
+	if app == nil {
+		app = &database.Application{}
+	}
+	spec, err := s.runtime_spec(deployment)
+	if err != nil {
+		return err
+	}
+
+	s.run_processes(deployment, app, spec)
+
+	return nil
+}
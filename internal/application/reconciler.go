@@ -0,0 +1,219 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/salmanrf/capybara-cloud/internal/database"
+	"github.com/salmanrf/capybara-cloud/internal/runtime"
+)
+
+// Kinds of application_events rows recorded for corrections of the reconciler
+const (
+	EventReconcileRestarted = "reconcile_restarted"
+	EventReconcileStopped = "reconcile_stopped"
+	EventReconcileKilled = "reconcile_killed"
+)
+
+// RunReconciler reconciles right away, e.g. after the server restarted, and
+// then every interval until ctx is done.
+func (s *service) RunReconciler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.reconcile(); err != nil {
+			fmt.Println("Error at application_service.RunReconciler: ", err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// reconcile compares the deployments Postgres says should have a process
+// with what the runtime runs and corrects the difference. Serving deployments
+// nobody supervises, e.g. since the server restarted, are watched again or
+// started again when their process is gone, unless their application is meant
+// to be stopped or a newer deployment replaced them. Processes of deployments
+// that aren't active are killed. Runs of cron jobs nothing awaits anymore are
+// failed.
+// Launches in progress are left to the job queue.
+func (s *service) reconcile() error {
+	// listed first so a launch finishing in between doesn't look orphaned
+	listed, err := s.runtime.List()
+	if err != nil {
+		return err
+	}
+
+	rows, err := s.repository.FindDeploymentsWithDesiredState(GetActiveDeploymentStatuses())
+	if err != nil && !strings.Contains(err.Error(), "no rows") {
+		return err
+	}
+
+	active := map[string]bool{}
+	// newest serving deployment of every application, rows come newest first
+	serving := map[pgtype.UUID]*database.ApplicationDeployment{}
+	for i := range rows {
+		deployment := &rows[i].ApplicationDeployment
+		active[deployment.AppDpID.String()] = true
+
+		if !slices.Contains(GetServingDeploymentStatuses(), deployment.Status) {
+			continue
+		}
+		// canaries serve next to the stable deployment, they don't replace it
+		newer, replaced := serving[deployment.AppID]
+		if deployment.CanaryWeight.Valid {
+			replaced = false
+		} else if !replaced {
+			serving[deployment.AppID] = deployment
+		}
+		if s.supervisor.watching(deployment.AppDpID.String()) {
+			continue
+		}
+
+		// deployments made after the app was stopped were deployed on purpose
+		stopped_at := rows[i].AppDesiredStateUpdatedAt
+		if rows[i].AppDesiredState == DesiredStateStopped && stopped_at.Valid && deployment.CreatedAt.Time.Before(stopped_at.Time) {
+			s.reconcile_stop(deployment, DeploymentStopped, "application is meant to be stopped")
+			continue
+		}
+
+		// left over by a switch the server didn't get to finish
+		if replaced {
+			s.reconcile_stop(deployment, DeploymentSuperseded, "replaced by "+newer.AppDpID.String())
+			continue
+		}
+		if !has_process(rows[i].AppType) {
+			continue
+		}
+
+		s.reconcile_serving(deployment)
+	}
+
+	// instances of the other process types run under ids of their own
+	processes, err := s.repository.FindDeploymentProcessesByStatus(
+		database.FindApplicationDeploymentProcessesByStatusParams{
+			Statuses: []string{ProcessRunning, ProcessCrashLooping},
+			DeploymentStatuses: GetActiveDeploymentStatuses(),
+		},
+	)
+	if err != nil && !strings.Contains(err.Error(), "no rows") {
+		return err
+	}
+	for i := range processes {
+		active[processes[i].RuntimeID] = true
+	}
+
+	runs, err := s.repository.FindJobRunsByStatus(JobRunRunning)
+	if err != nil && !strings.Contains(err.Error(), "no rows") {
+		return err
+	}
+	for i := range runs {
+		run_id := runs[i].AppRunID.String()
+		active[run_id] = true
+		if !s.runs.tracking(run_id) {
+			s.reconcile_job_run(&runs[i])
+		}
+	}
+
+	for _, dp_id := range listed {
+		if !active[dp_id] {
+			s.reconcile_orphan(dp_id)
+		}
+	}
+
+	return nil
+}
+
+func (s *service) record_reconcile_event(deployment *database.ApplicationDeployment, kind string, message string) {
+	_, err := s.repository.CreateEvent(
+		database.CreateApplicationEventParams{
+			AppID: deployment.AppID,
+			AppDpID: deployment.AppDpID,
+			Kind: kind,
+			Message: pgtype.Text{String: message, Valid: true},
+		},
+	)
+	if err != nil {
+		fmt.Println("Error at application_service.record_reconcile_event: ", err.Error())
+	}
+}
+
+func (s *service) reconcile_stop(deployment *database.ApplicationDeployment, status string, reason string) {
+	s.record_reconcile_event(deployment, EventReconcileStopped, reason)
+
+	if _, err := s.stop_deployment(deployment, status, "stopped by the reconciler, "+reason); err != nil {
+		fmt.Println("Error at application_service.reconcile_stop: ", err.Error())
+	}
+}
+
+// reconcile_serving supervises a serving deployment again, starting its
+// process first when the runtime doesn't run it anymore.
+func (s *service) reconcile_serving(deployment *database.ApplicationDeployment) {
+	dp_id := deployment.AppDpID.String()
+
+	status, err := s.runtime.Status(dp_id)
+	if err != nil {
+		fmt.Println("Error at application_service.reconcile_serving - status: ", err.Error())
+		return
+	}
+	if status.State == runtime.StateRunning {
+		s.watch_deployment(deployment)
+		return
+	}
+
+	s.record_reconcile_event(deployment, EventReconcileRestarted, fmt.Sprintf("process %s, starting it again", status.State))
+
+	// clears whatever is left of the previous process
+	if err := s.runtime.Stop(dp_id); err != nil && err.Error() != "not_found" {
+		fmt.Println("Error at application_service.reconcile_serving - stopping: ", err.Error())
+	}
+
+	spec, err := s.runtime_spec(deployment)
+	if err != nil {
+		s.fail_deployment(deployment, err)
+		return
+	}
+	instance, err := s.runtime.Start(spec)
+	if err != nil {
+		s.fail_deployment(deployment, err)
+		return
+	}
+
+	if err := s.record_process(deployment, instance); err != nil {
+		fmt.Println("Error at application_service.reconcile_serving: ", err.Error())
+	}
+
+	s.watch_deployment(deployment)
+}
+
+// reconcile_orphan kills a process the runtime runs for a deployment that
+// isn't active, e.g. one stopped while the server was down.
+func (s *service) reconcile_orphan(dp_id string) {
+	if err := s.runtime.Stop(dp_id); err != nil && err.Error() != "not_found" {
+		fmt.Println("Error at application_service.reconcile_orphan: ", err.Error())
+		return
+	}
+
+	dp_uuid := pgtype.UUID{}
+	if err := dp_uuid.Scan(runtime_deployment_id(dp_id)); err != nil {
+		fmt.Println("Killed process of unknown deployment", dp_id)
+		return
+	}
+	deployment, err := s.repository.FindOneDeploymentById(dp_uuid)
+	if err != nil {
+		fmt.Println("Killed process of unknown deployment", dp_id, err.Error())
+		return
+	}
+
+	s.record_reconcile_event(deployment, EventReconcileKilled, fmt.Sprintf("killed the process of a %s deployment", deployment.Status))
+}
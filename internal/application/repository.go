@@ -3,11 +3,14 @@ package application
 import (
 	"context"
 
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/salmanrf/capybara-cloud/internal/database"
 )
 
 type repository struct {
 	ctx context.Context
+	conn *pgxpool.Pool
 	queries *database.Queries
 }
 
@@ -16,11 +19,69 @@ type ApplicationRepository interface {
 	UpsertConfig(database.CreateApplicationConfigParams) (*database.ApplicationConfig, error)
 	CreateApplication(database.CreateApplicationParams) (*database.Application, error)
 	UpdateOneApplication(database.UpdateOneApplicationParams) (*database.Application, error)
+	FindOne(app_id pgtype.UUID) (*database.Application, error)
+	UpdateHealthCheck(database.UpdateApplicationHealthCheckParams) (*database.Application, error)
+	UpdateResources(database.UpdateApplicationResourcesParams) (*database.Application, error)
+	UpdateSchedule(database.UpdateApplicationScheduleParams) (*database.Application, error)
+	FindDueSchedules(now pgtype.Timestamp) ([]database.Application, error)
+	ClaimScheduleRun(database.ClaimApplicationScheduleRunParams) (*database.Application, error)
+	UpdateDeploymentHealth(database.UpdateApplicationDeploymentHealthParams) (*database.ApplicationDeployment, error)
+	UpdateDeploymentRestarts(database.UpdateApplicationDeploymentRestartsParams) (*database.ApplicationDeployment, error)
+	UpdateDesiredState(params database.UpdateApplicationDesiredStateParams, event database.CreateApplicationEventParams) (*database.Application, error)
+	CreateEvent(database.CreateApplicationEventParams) (*database.ApplicationEvent, error)
+	CreateDeployment(params database.CreateApplicationDeploymentParams, reason pgtype.Text) (*database.ApplicationDeployment, error)
+	FindDeployments(app_id pgtype.UUID) ([]database.ApplicationDeployment, error)
+	FindDeploymentsByStatus(database.FindApplicationDeploymentsByStatusParams) ([]database.ApplicationDeployment, error)
+	FindOneDeployment(database.FindOneApplicationDeploymentParams) (*database.ApplicationDeployment, error)
+	FindOneDeploymentById(app_dp_id pgtype.UUID) (*database.ApplicationDeployment, error)
+	FindDeploymentsWithDesiredState(statuses []string) ([]database.FindApplicationDeploymentsWithDesiredStateRow, error)
+	UpsertDeploymentProcess(database.UpsertApplicationDeploymentProcessParams) (*database.ApplicationDeploymentProcess, error)
+	UpdateDeploymentProcessStatus(database.UpdateApplicationDeploymentProcessStatusParams) (*database.ApplicationDeploymentProcess, error)
+	FindDeploymentProcesses(app_dp_id pgtype.UUID) ([]database.ApplicationDeploymentProcess, error)
+	FindDeploymentProcessesByStatus(database.FindApplicationDeploymentProcessesByStatusParams) ([]database.ApplicationDeploymentProcess, error)
+	UpsertProcessScale(database.UpsertApplicationProcessScaleParams) (*database.ApplicationProcessScale, error)
+	FindProcessScales(app_id pgtype.UUID) ([]database.ApplicationProcessScale, error)
+	UpdateDeploymentSource(database.UpdateApplicationDeploymentSourceParams) (*database.ApplicationDeployment, error)
+	UpdateDeploymentStack(database.UpdateApplicationDeploymentStackParams) (*database.ApplicationDeployment, error)
+	UpdateDeploymentCanary(database.UpdateApplicationDeploymentCanaryParams) (*database.ApplicationDeployment, error)
+	PromoteDeploymentCanary(app_dp_id pgtype.UUID) (*database.ApplicationDeployment, error)
+	AddDeploymentCanaryTraffic(database.AddApplicationDeploymentCanaryTrafficParams) error
+	TransitionDeployment(params database.UpdateApplicationDeploymentStatusParams, reason pgtype.Text) (*database.ApplicationDeployment, error)
+	FindDeploymentTransitions(app_dp_id pgtype.UUID) ([]database.ApplicationDeploymentTransition, error)
+	CreateDeploymentLog(database.CreateApplicationDeploymentLogParams) (*database.ApplicationDeploymentLog, error)
+	FindDeploymentLogs(database.FindApplicationDeploymentLogsParams) ([]database.ApplicationDeploymentLog, error)
+	FindIngressRoutes(statuses []string) ([]database.FindIngressRoutesRow, error)
+	FindDeploymentPort(params database.FindApplicationDeploymentPortParams) (*database.ApplicationDeploymentPort, error)
+	LeaseDeploymentPort(database.LeaseApplicationDeploymentPortParams) (*database.ApplicationDeploymentPort, error)
+	ReleaseDeploymentPort(app_dp_id pgtype.UUID) error
+	FindConfig(app_id pgtype.UUID) (*database.ApplicationConfig, error)
+	UpsertWebhook(database.UpsertApplicationWebhookParams) (*database.ApplicationWebhook, error)
+	FindOneWebhook(app_id pgtype.UUID) (*database.ApplicationWebhook, error)
+	CreateWebhookDelivery(database.CreateApplicationWebhookDeliveryParams) (*database.ApplicationWebhookDelivery, error)
+	FindWebhookDeliveries(database.FindApplicationWebhookDeliveriesParams) ([]database.ApplicationWebhookDelivery, error)
+	ClaimDeploymentJob(database.ClaimApplicationDeploymentJobParams) (*database.ApplicationDeploymentJob, error)
+	HeartbeatDeploymentJob(database.HeartbeatApplicationDeploymentJobParams) (*database.ApplicationDeploymentJob, error)
+	RetryDeploymentJob(database.RetryApplicationDeploymentJobParams) (*database.ApplicationDeploymentJob, error)
+	FinishDeploymentJob(database.FinishApplicationDeploymentJobParams) (*database.ApplicationDeploymentJob, error)
+	CancelQueuedDeploymentJob(app_dp_id pgtype.UUID) (*database.ApplicationDeploymentJob, error)
+	RequestDeploymentJobCancel(app_dp_id pgtype.UUID) (*database.ApplicationDeploymentJob, error)
+	StartJobRun(database.StartApplicationJobRunParams) (*database.ApplicationJobRun, error)
+	CreateJobRun(database.CreateApplicationJobRunParams) (*database.ApplicationJobRun, error)
+	FinishJobRun(database.FinishApplicationJobRunParams) (*database.ApplicationJobRun, error)
+	FindJobRuns(database.FindApplicationJobRunsParams) ([]database.ApplicationJobRun, error)
+	FindJobRunsByStatus(status string) ([]database.ApplicationJobRun, error)
+	FindOneJobRun(database.FindOneApplicationJobRunParams) (*database.ApplicationJobRun, error)
+	CreateJobRunLog(database.CreateApplicationJobRunLogParams) (*database.ApplicationJobRunLog, error)
+	FindJobRunLogs(database.FindApplicationJobRunLogsParams) ([]database.ApplicationJobRunLog, error)
 }
 
-func NewRepository(ctx context.Context, queries *database.Queries) ApplicationRepository {
+// deployment_job_claims_lock is the advisory lock key serializing job claims
+const deployment_job_claims_lock = 720016
+
+func NewRepository(ctx context.Context, conn *pgxpool.Pool, queries *database.Queries) ApplicationRepository {
 	return &repository{
 		ctx: ctx,
+		conn: conn,
 		queries: queries,
 	}
 }
@@ -59,4 +120,598 @@ func (r *repository) UpdateOneApplication(params database.UpdateOneApplicationPa
 	)
 
 	return &app, err
-}
\ No newline at end of file
+}
+
+// UpdateDesiredState records whether the application is meant to be up and
+// the event explaining who asked for it, in one transaction.
+func (r *repository) UpdateDesiredState(params database.UpdateApplicationDesiredStateParams, event database.CreateApplicationEventParams) (*database.Application, error) {
+	trx, err := r.conn.Begin(r.ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer trx.Rollback(r.ctx)
+	q := r.queries.WithTx(trx)
+
+	app, err := q.UpdateApplicationDesiredState(
+		r.ctx,
+		params,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = q.CreateApplicationEvent(
+		r.ctx,
+		event,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = trx.Commit(r.ctx)
+
+	return &app, err
+}
+
+func (r *repository) CreateEvent(params database.CreateApplicationEventParams) (*database.ApplicationEvent, error) {
+	event, err := r.queries.CreateApplicationEvent(
+		r.ctx,
+		params,
+	)
+
+	return &event, err
+}
+
+// CreateDeployment inserts the queued deployment together with its first
+// transition and the job that launches it, reason says why it was created.
+func (r *repository) CreateDeployment(params database.CreateApplicationDeploymentParams, reason pgtype.Text) (*database.ApplicationDeployment, error) {
+	trx, err := r.conn.Begin(r.ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer trx.Rollback(r.ctx)
+	q := r.queries.WithTx(trx)
+
+	deployment, err := q.CreateApplicationDeployment(
+		r.ctx,
+		params,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = q.CreateApplicationDeploymentTransition(
+		r.ctx,
+		database.CreateApplicationDeploymentTransitionParams{
+			AppDpID: deployment.AppDpID,
+			ToStatus: deployment.Status,
+			Reason: reason,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = q.CreateApplicationDeploymentJob(
+		r.ctx,
+		database.CreateApplicationDeploymentJobParams{
+			AppDpID: deployment.AppDpID,
+			AppID: deployment.AppID,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = trx.Commit(r.ctx)
+
+	return &deployment, err
+}
+
+func (r *repository) FindDeployments(app_id pgtype.UUID) ([]database.ApplicationDeployment, error) {
+	deployments, err := r.queries.FindApplicationDeploymentsByAppId(
+		r.ctx,
+		app_id,
+	)
+
+	return deployments, err
+}
+
+func (r *repository) FindDeploymentsByStatus(params database.FindApplicationDeploymentsByStatusParams) ([]database.ApplicationDeployment, error) {
+	deployments, err := r.queries.FindApplicationDeploymentsByStatus(
+		r.ctx,
+		params,
+	)
+
+	return deployments, err
+}
+
+func (r *repository) FindOneDeployment(params database.FindOneApplicationDeploymentParams) (*database.ApplicationDeployment, error) {
+	deployment, err := r.queries.FindOneApplicationDeployment(
+		r.ctx,
+		params,
+	)
+
+	return &deployment, err
+}
+
+func (r *repository) FindOneDeploymentById(app_dp_id pgtype.UUID) (*database.ApplicationDeployment, error) {
+	deployment, err := r.queries.FindOneApplicationDeploymentById(
+		r.ctx,
+		app_dp_id,
+	)
+
+	return &deployment, err
+}
+
+func (r *repository) FindDeploymentsWithDesiredState(statuses []string) ([]database.FindApplicationDeploymentsWithDesiredStateRow, error) {
+	rows, err := r.queries.FindApplicationDeploymentsWithDesiredState(
+		r.ctx,
+		statuses,
+	)
+
+	return rows, err
+}
+
+// UpsertDeploymentProcess records an instance the runtime started for a
+// deployment, one row per process type and instance index.
+func (r *repository) UpsertDeploymentProcess(params database.UpsertApplicationDeploymentProcessParams) (*database.ApplicationDeploymentProcess, error) {
+	process, err := r.queries.UpsertApplicationDeploymentProcess(
+		r.ctx,
+		params,
+	)
+
+	return &process, err
+}
+
+func (r *repository) UpdateDeploymentProcessStatus(params database.UpdateApplicationDeploymentProcessStatusParams) (*database.ApplicationDeploymentProcess, error) {
+	process, err := r.queries.UpdateApplicationDeploymentProcessStatus(
+		r.ctx,
+		params,
+	)
+
+	return &process, err
+}
+
+func (r *repository) FindDeploymentProcesses(app_dp_id pgtype.UUID) ([]database.ApplicationDeploymentProcess, error) {
+	processes, err := r.queries.FindApplicationDeploymentProcesses(
+		r.ctx,
+		app_dp_id,
+	)
+
+	return processes, err
+}
+
+func (r *repository) FindDeploymentProcessesByStatus(params database.FindApplicationDeploymentProcessesByStatusParams) ([]database.ApplicationDeploymentProcess, error) {
+	processes, err := r.queries.FindApplicationDeploymentProcessesByStatus(
+		r.ctx,
+		params,
+	)
+
+	return processes, err
+}
+
+func (r *repository) UpsertProcessScale(params database.UpsertApplicationProcessScaleParams) (*database.ApplicationProcessScale, error) {
+	scale, err := r.queries.UpsertApplicationProcessScale(
+		r.ctx,
+		params,
+	)
+
+	return &scale, err
+}
+
+func (r *repository) FindProcessScales(app_id pgtype.UUID) ([]database.ApplicationProcessScale, error) {
+	scales, err := r.queries.FindApplicationProcessScales(
+		r.ctx,
+		app_id,
+	)
+
+	return scales, err
+}
+
+func (r *repository) UpdateDeploymentStack(params database.UpdateApplicationDeploymentStackParams) (*database.ApplicationDeployment, error) {
+	deployment, err := r.queries.UpdateApplicationDeploymentStack(
+		r.ctx,
+		params,
+	)
+
+	return &deployment, err
+}
+
+func (r *repository) UpdateDeploymentSource(params database.UpdateApplicationDeploymentSourceParams) (*database.ApplicationDeployment, error) {
+	deployment, err := r.queries.UpdateApplicationDeploymentSource(
+		r.ctx,
+		params,
+	)
+
+	return &deployment, err
+}
+
+func (r *repository) UpdateDeploymentCanary(params database.UpdateApplicationDeploymentCanaryParams) (*database.ApplicationDeployment, error) {
+	deployment, err := r.queries.UpdateApplicationDeploymentCanary(
+		r.ctx,
+		params,
+	)
+
+	return &deployment, err
+}
+
+func (r *repository) PromoteDeploymentCanary(app_dp_id pgtype.UUID) (*database.ApplicationDeployment, error) {
+	deployment, err := r.queries.PromoteApplicationDeploymentCanary(
+		r.ctx,
+		app_dp_id,
+	)
+
+	return &deployment, err
+}
+
+func (r *repository) AddDeploymentCanaryTraffic(params database.AddApplicationDeploymentCanaryTrafficParams) error {
+	return r.queries.AddApplicationDeploymentCanaryTraffic(
+		r.ctx,
+		params,
+	)
+}
+
+// TransitionDeployment moves the deployment from params.FromStatus to
+// params.ToStatus and records the transition in the same transaction.
+// It fails with pgx.ErrNoRows when the deployment is no longer in FromStatus.
+func (r *repository) TransitionDeployment(params database.UpdateApplicationDeploymentStatusParams, reason pgtype.Text) (*database.ApplicationDeployment, error) {
+	trx, err := r.conn.Begin(r.ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer trx.Rollback(r.ctx)
+	q := r.queries.WithTx(trx)
+
+	deployment, err := q.UpdateApplicationDeploymentStatus(
+		r.ctx,
+		params,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = q.CreateApplicationDeploymentTransition(
+		r.ctx,
+		database.CreateApplicationDeploymentTransitionParams{
+			AppDpID: deployment.AppDpID,
+			FromStatus: pgtype.Text{String: params.FromStatus, Valid: true},
+			ToStatus: params.ToStatus,
+			Reason: reason,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = trx.Commit(r.ctx)
+
+	return &deployment, err
+}
+
+func (r *repository) FindDeploymentTransitions(app_dp_id pgtype.UUID) ([]database.ApplicationDeploymentTransition, error) {
+	transitions, err := r.queries.FindApplicationDeploymentTransitions(
+		r.ctx,
+		app_dp_id,
+	)
+
+	return transitions, err
+}
+func (r *repository) CreateDeploymentLog(params database.CreateApplicationDeploymentLogParams) (*database.ApplicationDeploymentLog, error) {
+	log, err := r.queries.CreateApplicationDeploymentLog(
+		r.ctx,
+		params,
+	)
+
+	return &log, err
+}
+
+func (r *repository) FindDeploymentLogs(params database.FindApplicationDeploymentLogsParams) ([]database.ApplicationDeploymentLog, error) {
+	logs, err := r.queries.FindApplicationDeploymentLogs(
+		r.ctx,
+		params,
+	)
+
+	return logs, err
+}
+
+func (r *repository) FindOne(app_id pgtype.UUID) (*database.Application, error) {
+	app, err := r.queries.FindOneApplication(
+		r.ctx,
+		app_id,
+	)
+
+	return &app, err
+}
+
+func (r *repository) UpdateHealthCheck(params database.UpdateApplicationHealthCheckParams) (*database.Application, error) {
+	app, err := r.queries.UpdateApplicationHealthCheck(
+		r.ctx,
+		params,
+	)
+
+	return &app, err
+}
+
+func (r *repository) UpdateResources(params database.UpdateApplicationResourcesParams) (*database.Application, error) {
+	app, err := r.queries.UpdateApplicationResources(
+		r.ctx,
+		params,
+	)
+
+	return &app, err
+}
+
+func (r *repository) UpdateSchedule(params database.UpdateApplicationScheduleParams) (*database.Application, error) {
+	app, err := r.queries.UpdateApplicationSchedule(
+		r.ctx,
+		params,
+	)
+
+	return &app, err
+}
+
+func (r *repository) FindDueSchedules(now pgtype.Timestamp) ([]database.Application, error) {
+	apps, err := r.queries.FindDueApplicationSchedules(
+		r.ctx,
+		now,
+	)
+
+	return apps, err
+}
+
+// ClaimScheduleRun moves the schedule on to its next run, only one instance
+// claims every scheduled time.
+func (r *repository) ClaimScheduleRun(params database.ClaimApplicationScheduleRunParams) (*database.Application, error) {
+	app, err := r.queries.ClaimApplicationScheduleRun(
+		r.ctx,
+		params,
+	)
+
+	return &app, err
+}
+
+func (r *repository) UpdateDeploymentHealth(params database.UpdateApplicationDeploymentHealthParams) (*database.ApplicationDeployment, error) {
+	deployment, err := r.queries.UpdateApplicationDeploymentHealth(
+		r.ctx,
+		params,
+	)
+
+	return &deployment, err
+}
+
+func (r *repository) UpdateDeploymentRestarts(params database.UpdateApplicationDeploymentRestartsParams) (*database.ApplicationDeployment, error) {
+	deployment, err := r.queries.UpdateApplicationDeploymentRestarts(
+		r.ctx,
+		params,
+	)
+
+	return &deployment, err
+}
+
+func (r *repository) FindIngressRoutes(statuses []string) ([]database.FindIngressRoutesRow, error) {
+	routes, err := r.queries.FindIngressRoutes(
+		r.ctx,
+		statuses,
+	)
+
+	return routes, err
+}
+
+func (r *repository) FindDeploymentPort(params database.FindApplicationDeploymentPortParams) (*database.ApplicationDeploymentPort, error) {
+	lease, err := r.queries.FindApplicationDeploymentPort(
+		r.ctx,
+		params,
+	)
+
+	return &lease, err
+}
+
+func (r *repository) LeaseDeploymentPort(params database.LeaseApplicationDeploymentPortParams) (*database.ApplicationDeploymentPort, error) {
+	lease, err := r.queries.LeaseApplicationDeploymentPort(
+		r.ctx,
+		params,
+	)
+
+	return &lease, err
+}
+
+func (r *repository) ReleaseDeploymentPort(app_dp_id pgtype.UUID) error {
+	return r.queries.ReleaseApplicationDeploymentPort(
+		r.ctx,
+		app_dp_id,
+	)
+}
+
+func (r *repository) FindConfig(app_id pgtype.UUID) (*database.ApplicationConfig, error) {
+	config, err := r.queries.FindOneApplicationConfig(
+		r.ctx,
+		app_id,
+	)
+
+	return &config, err
+}
+
+func (r *repository) UpsertWebhook(params database.UpsertApplicationWebhookParams) (*database.ApplicationWebhook, error) {
+	webhook, err := r.queries.UpsertApplicationWebhook(
+		r.ctx,
+		params,
+	)
+
+	return &webhook, err
+}
+
+func (r *repository) FindOneWebhook(app_id pgtype.UUID) (*database.ApplicationWebhook, error) {
+	webhook, err := r.queries.FindOneApplicationWebhook(
+		r.ctx,
+		app_id,
+	)
+
+	return &webhook, err
+}
+
+func (r *repository) CreateWebhookDelivery(params database.CreateApplicationWebhookDeliveryParams) (*database.ApplicationWebhookDelivery, error) {
+	delivery, err := r.queries.CreateApplicationWebhookDelivery(
+		r.ctx,
+		params,
+	)
+
+	return &delivery, err
+}
+
+func (r *repository) FindWebhookDeliveries(params database.FindApplicationWebhookDeliveriesParams) ([]database.ApplicationWebhookDelivery, error) {
+	deliveries, err := r.queries.FindApplicationWebhookDeliveries(
+		r.ctx,
+		params,
+	)
+
+	return deliveries, err
+}
+
+// ClaimDeploymentJob locks the next job that is due and fits under the
+// concurrency caps for this worker. Claims take an advisory lock so two
+// workers can't both see room under a cap, SKIP LOCKED keeps them from
+// waiting on jobs locked by cancellations.
+func (r *repository) ClaimDeploymentJob(params database.ClaimApplicationDeploymentJobParams) (*database.ApplicationDeploymentJob, error) {
+	trx, err := r.conn.Begin(r.ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer trx.Rollback(r.ctx)
+	q := r.queries.WithTx(trx)
+
+	if err := q.LockApplicationDeploymentJobClaims(r.ctx, deployment_job_claims_lock); err != nil {
+		return nil, err
+	}
+
+	job, err := q.ClaimApplicationDeploymentJob(
+		r.ctx,
+		params,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = trx.Commit(r.ctx)
+
+	return &job, err
+}
+
+func (r *repository) HeartbeatDeploymentJob(params database.HeartbeatApplicationDeploymentJobParams) (*database.ApplicationDeploymentJob, error) {
+	job, err := r.queries.HeartbeatApplicationDeploymentJob(
+		r.ctx,
+		params,
+	)
+
+	return &job, err
+}
+
+func (r *repository) RetryDeploymentJob(params database.RetryApplicationDeploymentJobParams) (*database.ApplicationDeploymentJob, error) {
+	job, err := r.queries.RetryApplicationDeploymentJob(
+		r.ctx,
+		params,
+	)
+
+	return &job, err
+}
+
+func (r *repository) FinishDeploymentJob(params database.FinishApplicationDeploymentJobParams) (*database.ApplicationDeploymentJob, error) {
+	job, err := r.queries.FinishApplicationDeploymentJob(
+		r.ctx,
+		params,
+	)
+
+	return &job, err
+}
+
+func (r *repository) CancelQueuedDeploymentJob(app_dp_id pgtype.UUID) (*database.ApplicationDeploymentJob, error) {
+	job, err := r.queries.CancelQueuedApplicationDeploymentJob(
+		r.ctx,
+		app_dp_id,
+	)
+
+	return &job, err
+}
+
+func (r *repository) RequestDeploymentJobCancel(app_dp_id pgtype.UUID) (*database.ApplicationDeploymentJob, error) {
+	job, err := r.queries.RequestApplicationDeploymentJobCancel(
+		r.ctx,
+		app_dp_id,
+	)
+
+	return &job, err
+}
+
+// StartJobRun records a run as running unless the application has one running
+// already and doesn't allow overlapping runs, then no rows are returned.
+func (r *repository) StartJobRun(params database.StartApplicationJobRunParams) (*database.ApplicationJobRun, error) {
+	run, err := r.queries.StartApplicationJobRun(
+		r.ctx,
+		params,
+	)
+
+	return &run, err
+}
+
+func (r *repository) CreateJobRun(params database.CreateApplicationJobRunParams) (*database.ApplicationJobRun, error) {
+	run, err := r.queries.CreateApplicationJobRun(
+		r.ctx,
+		params,
+	)
+
+	return &run, err
+}
+
+func (r *repository) FinishJobRun(params database.FinishApplicationJobRunParams) (*database.ApplicationJobRun, error) {
+	run, err := r.queries.FinishApplicationJobRun(
+		r.ctx,
+		params,
+	)
+
+	return &run, err
+}
+
+func (r *repository) FindJobRuns(params database.FindApplicationJobRunsParams) ([]database.ApplicationJobRun, error) {
+	runs, err := r.queries.FindApplicationJobRuns(
+		r.ctx,
+		params,
+	)
+
+	return runs, err
+}
+
+func (r *repository) FindJobRunsByStatus(status string) ([]database.ApplicationJobRun, error) {
+	runs, err := r.queries.FindApplicationJobRunsByStatus(
+		r.ctx,
+		status,
+	)
+
+	return runs, err
+}
+
+func (r *repository) FindOneJobRun(params database.FindOneApplicationJobRunParams) (*database.ApplicationJobRun, error) {
+	run, err := r.queries.FindOneApplicationJobRun(
+		r.ctx,
+		params,
+	)
+
+	return &run, err
+}
+
+func (r *repository) CreateJobRunLog(params database.CreateApplicationJobRunLogParams) (*database.ApplicationJobRunLog, error) {
+	log, err := r.queries.CreateApplicationJobRunLog(
+		r.ctx,
+		params,
+	)
+
+	return &log, err
+}
+
+func (r *repository) FindJobRunLogs(params database.FindApplicationJobRunLogsParams) ([]database.ApplicationJobRunLog, error) {
+	logs, err := r.queries.FindApplicationJobRunLogs(
+		r.ctx,
+		params,
+	)
+
+	return logs, err
+}
@@ -0,0 +1,390 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/salmanrf/capybara-cloud/internal/cron"
+	"github.com/salmanrf/capybara-cloud/internal/database"
+	"github.com/salmanrf/capybara-cloud/internal/runtime"
+	"github.com/salmanrf/capybara-cloud/pkg/dto"
+)
+
+// Statuses of the runs of cron_job applications
+const (
+	JobRunRunning = "running"
+	JobRunSucceeded = "succeeded"
+	JobRunFailed = "failed"
+	JobRunSkipped = "skipped"
+)
+
+// job_runs tracks the runs started by this instance until they finish
+type job_runs struct {
+	mu sync.Mutex
+	running map[string]bool
+	wg sync.WaitGroup
+}
+
+func new_job_runs() *job_runs {
+	return &job_runs{
+		running: make(map[string]bool),
+	}
+}
+
+func (r *job_runs) tracking(run_id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.running[run_id]
+}
+
+func (r *job_runs) untrack(run_id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.running, run_id)
+}
+
+// UpdateSchedule sets when a cron_job application runs and what, the next run
+// is the first time the expression fires from now on.
+func (s *service) UpdateSchedule(app_id string, user_id string, dto dto.UpdateApplicationScheduleDto) (*database.Application, error) {
+	app_with_pm, err := s.find_member_app(app_id, user_id)
+	if err != nil {
+		return nil, err
+	}
+
+	if !is_cron_job(app_with_pm.Type) {
+		return nil, errors.New("invalid_app_type")
+	}
+
+	return s.store_schedule(app_with_pm.AppID, dto)
+}
+
+func (s *service) store_schedule(app_id pgtype.UUID, dto dto.UpdateApplicationScheduleDto) (*database.Application, error) {
+	schedule, err := cron.Parse(dto.CronExpression)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.repository.UpdateSchedule(
+		database.UpdateApplicationScheduleParams{
+			AppID: app_id,
+			CronExpression: pgtype.Text{String: dto.CronExpression, Valid: true},
+			CronCommand: pgtype.Text{String: dto.Command, Valid: true},
+			CronAllowOverlap: dto.AllowOverlap,
+			CronNextRunAt: pgtype.Timestamp{Time: schedule.Next(time.Now().UTC()), Valid: true},
+		},
+	)
+}
+
+func (s *service) FindJobRuns(app_id string, user_id string) ([]database.ApplicationJobRun, error) {
+	app_with_pm, err := s.find_member_app(app_id, user_id)
+	if err != nil {
+		return nil, err
+	}
+
+	runs, err := s.repository.FindJobRuns(
+		database.FindApplicationJobRunsParams{
+			AppID: app_with_pm.AppID,
+			Limit: dto.DefaultJobRunsLimit,
+		},
+	)
+	if err != nil {
+		if strings.Contains(err.Error(), "no rows") {
+			return []database.ApplicationJobRun{}, nil
+		}
+		return nil, err
+	}
+
+	return runs, nil
+}
+
+func (s *service) FindJobRunLogs(app_id string, run_id string, user_id string, dto dto.FindApplicationDeploymentLogsDto) ([]database.ApplicationJobRunLog, error) {
+	app_with_pm, err := s.find_member_app(app_id, user_id)
+	if err != nil {
+		return nil, err
+	}
+
+	run_uuid := pgtype.UUID{}
+	run_uuid.Scan(run_id)
+
+	run, err := s.repository.FindOneJobRun(
+		database.FindOneApplicationJobRunParams{
+			AppRunID: run_uuid,
+			AppID: app_with_pm.AppID,
+		},
+	)
+	if err != nil {
+		if strings.Contains(err.Error(), "no rows") {
+			return nil, errors.New("not_found")
+		}
+		return nil, err
+	}
+	if run == nil || !run.AppRunID.Valid {
+		return nil, errors.New("not_found")
+	}
+
+	logs, err := s.repository.FindJobRunLogs(
+		database.FindApplicationJobRunLogsParams{
+			AppRunID: run.AppRunID,
+			AppRunLogID: dto.After,
+			Limit: dto.Limit,
+		},
+	)
+	if err != nil {
+		if strings.Contains(err.Error(), "no rows") {
+			return []database.ApplicationJobRunLog{}, nil
+		}
+		return nil, err
+	}
+
+	return logs, nil
+}
+
+// RunScheduler starts the runs of cron_job applications as they come due,
+// checking right away and then every interval until ctx is done. Runs in
+// progress are waited for before it returns.
+func (s *service) RunScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	defer s.runs.wg.Wait()
+
+	for {
+		if err := s.schedule_runs(ctx, time.Now().UTC()); err != nil {
+			fmt.Println("Error at application_service.RunScheduler: ", err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *service) schedule_runs(ctx context.Context, now time.Time) error {
+	due, err := s.repository.FindDueSchedules(pgtype.Timestamp{Time: now, Valid: true})
+	if err != nil && !strings.Contains(err.Error(), "no rows") {
+		return err
+	}
+
+	for i := range due {
+		s.fire_schedule(ctx, &due[i], now)
+	}
+
+	return nil
+}
+
+// fire_schedule claims the due run of an application and starts it. Of the
+// runs missed while no instance was up only the latest one is started.
+func (s *service) fire_schedule(ctx context.Context, app *database.Application, now time.Time) {
+	schedule, err := cron.Parse(app.CronExpression.String)
+	if err != nil {
+		fmt.Println("Error at application_service.fire_schedule: ", app.AppID.String(), err.Error())
+		return
+	}
+
+	// other instances sharing the database see it claimed and move on
+	claimed, err := s.repository.ClaimScheduleRun(
+		database.ClaimApplicationScheduleRunParams{
+			NextRunAt: pgtype.Timestamp{Time: schedule.Next(now), Valid: true},
+			AppID: app.AppID,
+			ScheduledAt: app.CronNextRunAt,
+		},
+	)
+	if err != nil {
+		if !strings.Contains(err.Error(), "no rows") {
+			fmt.Println("Error at application_service.fire_schedule - claiming: ", err.Error())
+		}
+		return
+	}
+
+	// stopped applications keep their schedule without running
+	if claimed.DesiredState == DesiredStateStopped {
+		return
+	}
+
+	if _, err := s.start_job_run(ctx, claimed, app.CronNextRunAt); err != nil {
+		fmt.Println("Error at application_service.fire_schedule - starting: ", err.Error())
+	}
+}
+
+// start_job_run runs the command of a scheduled application on its newest
+// running deployment. Runs that can't start are recorded as failed, or as
+// skipped while a previous run of an application not allowing overlapping
+// runs is still running.
+func (s *service) start_job_run(ctx context.Context, app *database.Application, scheduled_at pgtype.Timestamp) (*database.ApplicationJobRun, error) {
+	serving, err := s.find_serving_deployments(app.AppID)
+	if err != nil {
+		return nil, err
+	}
+	if len(serving) == 0 {
+		return s.repository.CreateJobRun(
+			database.CreateApplicationJobRunParams{
+				AppID: app.AppID,
+				Status: JobRunFailed,
+				Command: app.CronCommand.String,
+				ScheduledAt: scheduled_at,
+				FailureReason: pgtype.Text{String: "no running deployment to run", Valid: true},
+			},
+		)
+	}
+	deployment := &serving[0]
+
+	// tracked before the reconciler can see the run as running
+	s.runs.mu.Lock()
+	run, err := s.repository.StartJobRun(
+		database.StartApplicationJobRunParams{
+			AppID: app.AppID,
+			AppDpID: deployment.AppDpID,
+			Command: app.CronCommand.String,
+			ScheduledAt: scheduled_at,
+			AllowOverlap: app.CronAllowOverlap,
+		},
+	)
+	if err == nil {
+		s.runs.running[run.AppRunID.String()] = true
+	}
+	s.runs.mu.Unlock()
+
+	if err != nil {
+		if !strings.Contains(err.Error(), "no rows") {
+			return nil, err
+		}
+		return s.repository.CreateJobRun(
+			database.CreateApplicationJobRunParams{
+				AppID: app.AppID,
+				AppDpID: deployment.AppDpID,
+				Status: JobRunSkipped,
+				Command: app.CronCommand.String,
+				ScheduledAt: scheduled_at,
+				FailureReason: pgtype.Text{String: "previous run still running", Valid: true},
+			},
+		)
+	}
+
+	spec, err := s.job_run_spec(app, deployment, run)
+	if err == nil {
+		_, err = s.runtime.Start(spec)
+	}
+	if err != nil {
+		s.runs.untrack(run.AppRunID.String())
+		return s.finish_job_run(run, JobRunFailed, pgtype.Int4{}, err.Error())
+	}
+
+	s.runs.wg.Add(1)
+	go func() {
+		defer s.runs.wg.Done()
+		s.await_job_run(ctx, app, run)
+	}()
+
+	return run, nil
+}
+
+// job_run_spec runs the command on the build of deployment, under the id of
+// the run so it gets a process of its own.
+func (s *service) job_run_spec(app *database.Application, deployment *database.ApplicationDeployment, run *database.ApplicationJobRun) (runtime.Spec, error) {
+	spec, err := deployment_spec(deployment)
+	if err != nil {
+		return runtime.Spec{}, err
+	}
+
+	spec.DeploymentID = run.AppRunID.String()
+	spec.BuildID = deployment.AppDpID.String()
+	spec.Command = run.Command
+	spec.Resources = runtime.Resources{CPUMillis: app.CpuMillis, MemoryMB: app.MemoryMb}
+	spec.BundleLimits = s.bundle_limits
+
+	app_run_id := run.AppRunID
+	spec.OnLog = func(line runtime.LogLine) {
+		s.record_job_run_log(app_run_id, line)
+	}
+
+	return spec, nil
+}
+
+func (s *service) record_job_run_log(app_run_id pgtype.UUID, line runtime.LogLine) {
+	_, err := s.repository.CreateJobRunLog(
+		database.CreateApplicationJobRunLogParams{
+			AppRunID: app_run_id,
+			Stream: line.Stream,
+			Line: line.Line,
+			LoggedAt: pgtype.Timestamp{Time: line.Time, Valid: true},
+		},
+	)
+	if err != nil {
+		fmt.Println("Error at application_service.record_job_run_log: ", err.Error())
+	}
+}
+
+// await_job_run polls a started run until its process exits and records how
+// it ended. Runs still going when ctx is done are left to the reconciler.
+func (s *service) await_job_run(ctx context.Context, app *database.Application, run *database.ApplicationJobRun) {
+	run_id := run.AppRunID.String()
+	defer s.runs.untrack(run_id)
+
+	for {
+		status, err := s.runtime.Status(run_id)
+		switch {
+		case err == nil && status.State == runtime.StateNotFound:
+			s.finish_job_run(run, JobRunFailed, pgtype.Int4{}, "process of the run is gone")
+			return
+		case err != nil:
+			fmt.Println("Error at application_service.await_job_run: ", err.Error())
+		case status.State != runtime.StateRunning:
+			exit_code := pgtype.Int4{Int32: int32(status.ExitCode), Valid: true}
+			if status.OOMKilled || status.ExitCode != 0 {
+				s.finish_job_run(run, JobRunFailed, exit_code, exit_reason(status, app))
+			} else {
+				s.finish_job_run(run, JobRunSucceeded, exit_code, "")
+			}
+
+			// the runtime keeps exited processes around until they are stopped
+			if err := s.runtime.Stop(run_id); err != nil && err.Error() != "not_found" {
+				fmt.Println("Error at application_service.await_job_run - stopping: ", err.Error())
+			}
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.supervision.run_poll_interval):
+		}
+	}
+}
+
+func (s *service) finish_job_run(run *database.ApplicationJobRun, status string, exit_code pgtype.Int4, reason string) (*database.ApplicationJobRun, error) {
+	finished, err := s.repository.FinishJobRun(
+		database.FinishApplicationJobRunParams{
+			Status: status,
+			ExitCode: exit_code,
+			FailureReason: pgtype.Text{String: reason, Valid: reason != ""},
+			AppRunID: run.AppRunID,
+		},
+	)
+	if err != nil {
+		fmt.Println("Error at application_service.finish_job_run: ", err.Error())
+		return nil, err
+	}
+
+	return finished, nil
+}
+
+
+// reconcile_job_run fails a run recorded as running that no instance tracks,
+// e.g. one the server restarted during, and stops what is left of it.
+func (s *service) reconcile_job_run(run *database.ApplicationJobRun) {
+	if err := s.runtime.Stop(run.AppRunID.String()); err != nil && err.Error() != "not_found" {
+		fmt.Println("Error at application_service.reconcile_job_run: ", err.Error())
+		return
+	}
+
+	s.finish_job_run(run, JobRunFailed, pgtype.Int4{}, "interrupted, the server restarted during the run")
+}
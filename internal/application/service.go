@@ -5,14 +5,22 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/salmanrf/capybara-cloud/internal/artifacts"
 	"github.com/salmanrf/capybara-cloud/internal/database"
 	"github.com/salmanrf/capybara-cloud/internal/project"
+	"github.com/salmanrf/capybara-cloud/internal/runtime"
 	"github.com/salmanrf/capybara-cloud/pkg/dto"
+	"github.com/salmanrf/capybara-cloud/pkg/utils"
 )
 
 type Service interface {
@@ -21,6 +29,36 @@ type Service interface {
 	FindOne(app_id string, user_id string) (*database.FindOneApplicationWithProjectMemberRow, error)
 	CreateConfig(app_id string, user_id string, dto dto.CreateApplicationConfigDto) (*database.ApplicationConfig, error)
 	FindOneConfig(app_id string, user_id string) (*dto.ApplicationConfigResponse, error)
+	CreateDeployment(app_id string, user_id string, dto dto.CreateApplicationDeploymentDto) (*database.ApplicationDeployment, error)
+	MaxUploadSize() int64
+	FindDeployments(app_id string, user_id string) ([]database.ApplicationDeployment, error)
+	FindOneDeployment(app_id string, dp_id string, user_id string) (*dto.ApplicationDeploymentResponse, error)
+	RollbackDeployment(app_id string, dp_id string, user_id string) (*database.ApplicationDeployment, error)
+	UpdateHealthCheck(app_id string, user_id string, dto dto.UpdateApplicationHealthCheckDto) (*database.Application, error)
+	UpdateResources(app_id string, user_id string, dto dto.UpdateApplicationResourcesDto) (*database.Application, error)
+	UpdateScale(app_id string, user_id string, dto dto.UpdateApplicationScaleDto) ([]database.ApplicationProcessScale, error)
+	Stop(app_id string, user_id string) (*dto.ApplicationControlResponse, error)
+	Start(app_id string, user_id string) (*dto.ApplicationControlResponse, error)
+	Restart(app_id string, user_id string) (*dto.ApplicationControlResponse, error)
+	FindDeploymentLogs(app_id string, dp_id string, user_id string, dto dto.FindApplicationDeploymentLogsDto) ([]database.ApplicationDeploymentLog, error)
+	FollowDeploymentLogs(app_id string, dp_id string, user_id string) (<-chan database.ApplicationDeploymentLog, func(), error)
+	FindIngressRoutes() ([]dto.IngressRoute, error)
+	WatchDeploymentChanges() (<-chan struct{}, func())
+	UpdateWebhook(app_id string, user_id string, dto dto.UpdateApplicationWebhookDto) (*dto.ApplicationWebhookResponse, error)
+	FindOneWebhook(app_id string, user_id string) (*dto.ApplicationWebhookResponse, error)
+	FindWebhookDeliveries(app_id string, user_id string) ([]database.ApplicationWebhookDelivery, error)
+	ReceiveWebhook(app_id string, dto dto.ReceiveApplicationWebhookDto) (*database.ApplicationWebhookDelivery, error)
+	CancelDeployment(app_id string, dp_id string, user_id string) (*database.ApplicationDeployment, error)
+	UpdateCanary(app_id string, dp_id string, user_id string, dto dto.UpdateApplicationDeploymentCanaryDto) (*database.ApplicationDeployment, error)
+	PromoteCanary(app_id string, dp_id string, user_id string) (*database.ApplicationDeployment, error)
+	AbortCanary(app_id string, dp_id string, user_id string) (*database.ApplicationDeployment, error)
+	RecordCanaryTraffic(traffic []dto.CanaryTraffic) error
+	RunDeploymentWorkers(ctx context.Context)
+	RunReconciler(ctx context.Context, interval time.Duration)
+	UpdateSchedule(app_id string, user_id string, dto dto.UpdateApplicationScheduleDto) (*database.Application, error)
+	FindJobRuns(app_id string, user_id string) ([]database.ApplicationJobRun, error)
+	FindJobRunLogs(app_id string, run_id string, user_id string, dto dto.FindApplicationDeploymentLogsDto) ([]database.ApplicationJobRunLog, error)
+	RunScheduler(ctx context.Context, interval time.Duration)
 }
 
 type service struct {
@@ -28,6 +66,19 @@ type service struct {
 	conn *pgxpool.Pool
 	repository ApplicationRepository
 	project_service project.Service
+	artifacts_dir string
+	artifact_store artifacts.ArtifactStore
+	runtime runtime.Runtime
+	logs *log_broker
+	supervisor *supervisor
+	supervision supervision_options
+	changes *change_notifier
+	ports PortRange
+	bundle_limits runtime.ExtractLimits
+	jobs *job_queue
+	// drains of promoted canaries, they outlive the request promoting them
+	drains *sync.WaitGroup
+	runs *job_runs
 }
 
 func NewService(
@@ -35,12 +86,30 @@ func NewService(
 	conn *pgxpool.Pool, 
 	repository ApplicationRepository,
 	project_service project.Service,
+	artifacts_dir string,
+	artifact_store artifacts.ArtifactStore,
+	runtime runtime.Runtime,
+	ports PortRange,
+	bundle_limits runtime.ExtractLimits,
+	queue QueueOptions,
 ) Service {
 	return &service{
 		ctx,
 		conn,
 		repository,
 		project_service,
+		artifacts_dir,
+		artifact_store,
+		runtime,
+		new_log_broker(),
+		new_supervisor(ctx),
+		default_supervision_options(),
+		new_change_notifier(),
+		ports,
+		bundle_limits,
+		new_job_queue(queue),
+		&sync.WaitGroup{},
+		new_job_runs(),
 	}
 }
 
@@ -63,13 +132,22 @@ func (s *service) Create(user_id string, dto dto.CreateApplicationDto) (*databas
 		Type: dto.Type,
 		ProjectID: project_uuid,
 		Name: dto.Name,
+		Slug: utils.Slug(dto.Name),
 	}
 
 	new_application, err := s.repository.CreateApplication(params)
 	if err != nil {
+		// the application's part of its host is unique within the project
+		if strings.Contains(err.Error(), "duplicate key") {
+			return nil, errors.New("name_taken")
+		}
 		return nil, err
 	}
 
+	if dto.Schedule != nil && is_cron_job(new_application.Type) {
+		return s.store_schedule(new_application.AppID, *dto.Schedule)
+	}
+
 	return new_application, nil
 }
 
@@ -116,6 +194,7 @@ func (s *service) Update(app_id string, user_id string, dto dto.UpdateApplicatio
 		database.UpdateOneApplicationParams{
 			AppID: app_with_pm.AppID,
 			Name: dto.Name,
+			Slug: utils.Slug(dto.Name),
 			UpdatedAt: pgtype.Timestamp{
 				Time: time.Now(),
 				Valid: true,
@@ -124,6 +203,9 @@ func (s *service) Update(app_id string, user_id string, dto dto.UpdateApplicatio
 	)
 
 	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key") {
+			return nil, errors.New("name_taken")
+		}
 		return nil, err
 	}
 
@@ -153,17 +235,23 @@ func (s *service) CreateConfig(app_id string, user_id string, dto dto.CreateAppl
 		return nil, errors.New("permission_denied")
 	}
 
-	variables_json := bytes.NewBuffer([]byte{})
-	encoder := json.NewEncoder(variables_json)
-	if err := encoder.Encode(dto.Variables); err != nil {
-		return nil, err
-	}
-
 	params := database.CreateApplicationConfigParams{
 		AppID: app_uuid,
-		VariablesJson: variables_json.Bytes(),
+		Stack: pgtype.Text{String: dto.Stack, Valid: dto.Stack != ""},
 	} 
+	// variables that aren't sent are kept, like the stack
+	if dto.Variables != nil {
+		variables_json := bytes.NewBuffer([]byte{})
+		encoder := json.NewEncoder(variables_json)
+		if err := encoder.Encode(dto.Variables); err != nil {
+			return nil, err
+		}
+		params.VariablesJson = variables_json.Bytes()
+	}
 	app_cfg, err := s.repository.UpsertConfig(params)
+	if err != nil {
+		return nil, err
+	}
 
 	return app_cfg, nil
 }
@@ -208,9 +296,630 @@ func (s *service) FindOneConfig(app_id string, user_id string) (*dto.Application
 		AppID:           app_with_pm.ApplicationConfig.AppID.String(),
 		VariablesJson:   string(app_with_pm.ApplicationConfig.VariablesJson),
 		ConfigVariables: configVariables,
+		Stack:           app_with_pm.ApplicationConfig.Stack.String,
 		CreatedAt:       app_with_pm.CreatedAt.Time,
 		UpdatedAt:       app_with_pm.UpdatedAt.Time,
 	}
 
 	return response, nil
-}
\ No newline at end of file
+}
+
+// find_member_app loads the application and makes sure user_id is a member
+// of the project it belongs to.
+func (s *service) find_member_app(app_id string, user_id string) (*database.FindOneApplicationWithProjectMemberRow, error) {
+	app_uuid := pgtype.UUID{}
+	app_uuid.Scan(app_id)
+	user_uuid := pgtype.UUID{}
+	user_uuid.Scan(user_id)
+
+	app_with_pm, err := s.repository.FindOneWithProjectMember(
+		database.FindOneApplicationWithProjectMemberParams{
+			AppID: app_uuid,
+			UserID: user_uuid,
+		},
+	)
+
+	if err != nil {
+		if strings.Contains(err.Error(), "no rows") {
+			return nil, errors.New("not_found")
+		}
+		return nil, err
+	}
+	if app_with_pm == nil || !app_with_pm.AppID.Valid {
+		return nil, errors.New("not_found")
+	}
+	if !app_with_pm.PmProjectID.Valid {
+		return nil, errors.New("permission_denied")
+	}
+
+	return app_with_pm, nil
+}
+
+func (s *service) CreateDeployment(app_id string, user_id string, dto dto.CreateApplicationDeploymentDto) (*database.ApplicationDeployment, error) {
+	app_with_pm, err := s.find_member_app(app_id, user_id)
+	if err != nil {
+		return nil, err
+	}
+
+	// only applications listening on a port have traffic to split
+	if dto.CanaryWeight != nil && !listens_on_port(app_with_pm.Type) {
+		return nil, errors.New("invalid_app_type")
+	}
+
+	// one canary at a time, so it is clear what its error rate is compared to
+	if dto.CanaryWeight != nil {
+		canary, err := s.find_canary(app_with_pm.AppID)
+		if err != nil {
+			return nil, err
+		}
+		if canary != nil {
+			return nil, errors.New("canary_in_progress")
+		}
+	}
+
+	variables_snapshot := []byte("{}")
+	if app_with_pm.ApplicationConfig.AppCfgID.Valid && len(app_with_pm.ApplicationConfig.VariablesJson) > 0 {
+		variables_snapshot = app_with_pm.ApplicationConfig.VariablesJson
+	}
+
+	deployment, err := s.create_deployment(app_with_pm.AppID, variables_snapshot, app_with_pm.ApplicationConfig.Stack, dto, "deployment created")
+	if err != nil {
+		return nil, err
+	}
+
+	s.jobs.notify()
+
+	return deployment, nil
+}
+
+// MaxUploadSize is the largest bundle CreateDeployment accepts
+func (s *service) MaxUploadSize() int64 {
+	return s.bundle_limits.UploadLimit()
+}
+
+// create_deployment stores the uploaded bundle, if any, and inserts the
+// queued deployment row with the given variables snapshot. Stack is the one
+// the config pins, the detected one is stored once it is built.
+func (s *service) create_deployment(app_id pgtype.UUID, variables_snapshot []byte, stack pgtype.Text, dto dto.CreateApplicationDeploymentDto, reason string) (*database.ApplicationDeployment, error) {
+	dp_uuid, err := new_deployment_uuid()
+	if err != nil {
+		return nil, err
+	}
+
+	artifacts_path, err := local_artifacts_path(s.artifacts_dir, app_id.String(), dp_uuid.String())
+	if err != nil {
+		return nil, err
+	}
+
+	params := database.CreateApplicationDeploymentParams{
+		AppDpID: dp_uuid,
+		AppID: app_id,
+		ArtifactsPath: artifacts_path,
+		VariablesSnapshotJson: variables_snapshot,
+		Stack: stack,
+	}
+	if dto.CanaryWeight != nil {
+		params.CanaryWeight = pgtype.Int4{Int32: *dto.CanaryWeight, Valid: true}
+		params.CanarySticky = dto.CanarySticky
+		reason = fmt.Sprintf("%s as a canary for %d%% of the traffic", reason, *dto.CanaryWeight)
+	}
+
+	// git deployments are fetched when launched, the bundle is stored then
+	key := ""
+	if dto.GitURL != "" {
+		params.GitUrl = pgtype.Text{String: dto.GitURL, Valid: true}
+		params.GitRef = pgtype.Text{String: dto.GitRef, Valid: dto.GitRef != ""}
+		reason = reason + " from " + dto.GitURL
+	} else {
+		key = bundle_key(app_id.String(), dp_uuid.String(), dto.BundleName)
+		bundle, err := s.store_bundle(key, dto.Bundle)
+		if err != nil {
+			fmt.Println("Error at application_service.create_deployment - storing bundle: ", err.Error())
+			return nil, err
+		}
+
+		params.BundleKey = pgtype.Text{String: bundle.Key, Valid: true}
+		params.BundleSha256 = pgtype.Text{String: bundle.SHA256, Valid: true}
+		params.BundleSize = pgtype.Int8{Int64: bundle.Size, Valid: true}
+	}
+
+	deployment, err := s.repository.CreateDeployment(
+		params,
+		pgtype.Text{String: reason, Valid: true},
+	)
+	if err != nil {
+		if key != "" {
+			s.artifact_store.Delete(key)
+		}
+		return nil, err
+	}
+
+	return deployment, nil
+}
+
+func (s *service) FindDeployments(app_id string, user_id string) ([]database.ApplicationDeployment, error) {
+	app_with_pm, err := s.find_member_app(app_id, user_id)
+	if err != nil {
+		return nil, err
+	}
+
+	deployments, err := s.repository.FindDeployments(app_with_pm.AppID)
+	if err != nil {
+		if strings.Contains(err.Error(), "no rows") {
+			return []database.ApplicationDeployment{}, nil
+		}
+		return nil, err
+	}
+
+	return deployments, nil
+}
+
+// find_member_deployment loads a deployment of an application user_id can
+// access.
+func (s *service) find_member_deployment(app_id string, dp_id string, user_id string) (*database.ApplicationDeployment, error) {
+	app_with_pm, err := s.find_member_app(app_id, user_id)
+	if err != nil {
+		return nil, err
+	}
+
+	dp_uuid := pgtype.UUID{}
+	dp_uuid.Scan(dp_id)
+
+	deployment, err := s.repository.FindOneDeployment(
+		database.FindOneApplicationDeploymentParams{
+			AppDpID: dp_uuid,
+			AppID: app_with_pm.AppID,
+		},
+	)
+	if err != nil {
+		if strings.Contains(err.Error(), "no rows") {
+			return nil, errors.New("not_found")
+		}
+		return nil, err
+	}
+	if deployment == nil || !deployment.AppDpID.Valid {
+		return nil, errors.New("not_found")
+	}
+
+	return deployment, nil
+}
+
+func (s *service) FindOneDeployment(app_id string, dp_id string, user_id string) (*dto.ApplicationDeploymentResponse, error) {
+	deployment, err := s.find_member_deployment(app_id, dp_id, user_id)
+	if err != nil {
+		return nil, err
+	}
+
+	transitions, err := s.repository.FindDeploymentTransitions(deployment.AppDpID)
+	if err != nil && !strings.Contains(err.Error(), "no rows") {
+		return nil, err
+	}
+
+	processes, err := s.repository.FindDeploymentProcesses(deployment.AppDpID)
+	if err != nil && !strings.Contains(err.Error(), "no rows") {
+		return nil, err
+	}
+
+	return dto.NewApplicationDeploymentDetailResponse(*deployment, transitions, processes), nil
+}
+
+// RollbackDeployment relaunches the stored artifacts of a deployment that ran
+// before, with the variables snapshot it ran with, as a new deployment. The
+// running deployment keeps serving until the new one passes its health check.
+// The rollback gets its own artifacts directory, the bundle is fetched into it
+// when launched, so the source's files are left alone while it drains.
+func (s *service) RollbackDeployment(app_id string, dp_id string, user_id string) (*database.ApplicationDeployment, error) {
+	source, err := s.find_member_deployment(app_id, dp_id, user_id)
+	if err != nil {
+		return nil, err
+	}
+	if IsDeploymentActive(source.Status) {
+		return nil, errors.New("invalid_rollback_target")
+	}
+
+	transitions, err := s.repository.FindDeploymentTransitions(source.AppDpID)
+	if err != nil && !strings.Contains(err.Error(), "no rows") {
+		return nil, err
+	}
+	ran := false
+	for _, transition := range transitions {
+		if transition.ToStatus == DeploymentRunning {
+			ran = true
+		}
+	}
+	if !ran {
+		return nil, errors.New("invalid_rollback_target")
+	}
+
+	if err := s.stat_bundle(source); err != nil {
+		return nil, err
+	}
+
+	dp_uuid, err := new_deployment_uuid()
+	if err != nil {
+		return nil, err
+	}
+
+	artifacts_path, err := local_artifacts_path(s.artifacts_dir, source.AppID.String(), dp_uuid.String())
+	if err != nil {
+		return nil, err
+	}
+	// bundles from before the artifact store only exist in the source's directory
+	if !source.BundleKey.Valid {
+		if err := copy_local_bundle(source, artifacts_path); err != nil {
+			return nil, err
+		}
+	}
+
+	deployment, err := s.repository.CreateDeployment(
+		database.CreateApplicationDeploymentParams{
+			AppDpID: dp_uuid,
+			AppID: source.AppID,
+			ArtifactsPath: artifacts_path,
+			VariablesSnapshotJson: source.VariablesSnapshotJson,
+			RolledBackFrom: source.AppDpID,
+			BundleKey: source.BundleKey,
+			BundleSha256: source.BundleSha256,
+			BundleSize: source.BundleSize,
+			GitUrl: source.GitUrl,
+			GitRef: source.GitRef,
+			GitCommitSha: source.GitCommitSha,
+			Stack: source.Stack,
+		},
+		pgtype.Text{String: "rollback of " + source.AppDpID.String(), Valid: true},
+	)
+	if err != nil {
+		os.RemoveAll(artifacts_path)
+		return nil, err
+	}
+
+	s.jobs.notify()
+
+	return deployment, nil
+}
+
+// find_active_deployment returns the deployment currently serving the
+// application, or the stopped one that would serve it once started. It
+// returns nil when the application has neither.
+func (s *service) find_active_deployment(app_id pgtype.UUID) (*database.ApplicationDeployment, error) {
+	serving, err := s.find_serving_deployments(app_id)
+	if err != nil {
+		return nil, err
+	}
+	for i := range serving {
+		if !is_canary(&serving[i]) {
+			return &serving[i], nil
+		}
+	}
+	if len(serving) > 0 {
+		return &serving[0], nil
+	}
+
+	stopped, err := s.repository.FindDeploymentsByStatus(
+		database.FindApplicationDeploymentsByStatusParams{
+			AppID: app_id,
+			Status: DeploymentStopped,
+		},
+	)
+	if err != nil && !strings.Contains(err.Error(), "no rows") {
+		return nil, err
+	}
+	if len(stopped) > 0 {
+		return &stopped[0], nil
+	}
+
+	return nil, nil
+}
+
+func (s *service) update_desired_state(app_id pgtype.UUID, user_id string, desired_state string, kind string, deployment *database.ApplicationDeployment) (*database.Application, error) {
+	user_uuid := pgtype.UUID{}
+	user_uuid.Scan(user_id)
+
+	event := database.CreateApplicationEventParams{
+		AppID: app_id,
+		Kind: kind,
+		ActorUserID: user_uuid,
+	}
+	if deployment != nil {
+		event.AppDpID = deployment.AppDpID
+		event.Message = pgtype.Text{String: "deployment " + deployment.AppDpID.String() + " is " + deployment.Status, Valid: true}
+	}
+
+	return s.repository.UpdateDesiredState(
+		database.UpdateApplicationDesiredStateParams{
+			AppID: app_id,
+			DesiredState: desired_state,
+			DesiredStateUpdatedBy: user_uuid,
+		},
+		event,
+	)
+}
+
+// UpdateHealthCheck changes how the application's deployments are probed,
+// serving deployments are watched again with the new settings right away.
+func (s *service) UpdateHealthCheck(app_id string, user_id string, dto dto.UpdateApplicationHealthCheckDto) (*database.Application, error) {
+	app_with_pm, err := s.find_member_app(app_id, user_id)
+	if err != nil {
+		return nil, err
+	}
+
+	// there is no port to probe, the process staying up is the health check
+	if !listens_on_port(app_with_pm.Type) && dto.Type != runtime.ProbeNone {
+		return nil, errors.New("invalid_app_type")
+	}
+
+	app, err := s.repository.UpdateHealthCheck(
+		database.UpdateApplicationHealthCheckParams{
+			AppID: app_with_pm.AppID,
+			HealthCheckType: dto.Type,
+			HealthCheckPath: dto.Path,
+			HealthCheckIntervalSeconds: dto.IntervalSeconds,
+			HealthCheckTimeoutSeconds: dto.TimeoutSeconds,
+			HealthCheckFailureThreshold: dto.FailureThreshold,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	serving, err := s.find_serving_deployments(app_with_pm.AppID)
+	if err != nil {
+		fmt.Println("Error at application_service.UpdateHealthCheck - rewatching: ", err.Error())
+		return app, nil
+	}
+	for i := range serving {
+		s.watch_deployment(&serving[i])
+	}
+
+	return app, nil
+}
+
+// UpdateResources changes the CPU and memory limits of the application's
+// deployments. Running deployments get them once the app is restarted.
+func (s *service) UpdateResources(app_id string, user_id string, dto dto.UpdateApplicationResourcesDto) (*database.Application, error) {
+	app_with_pm, err := s.find_member_app(app_id, user_id)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.repository.UpdateResources(
+		database.UpdateApplicationResourcesParams{
+			AppID: app_with_pm.AppID,
+			CpuMillis: dto.CPUMillis,
+			MemoryMb: dto.MemoryMB,
+		},
+	)
+}
+
+// UpdateScale sets how many instances of its process types the application
+// runs, serving deployments are scaled right away.
+func (s *service) UpdateScale(app_id string, user_id string, dto dto.UpdateApplicationScaleDto) ([]database.ApplicationProcessScale, error) {
+	app_with_pm, err := s.find_member_app(app_id, user_id)
+	if err != nil {
+		return nil, err
+	}
+
+	if !has_process(app_with_pm.Type) {
+		return nil, errors.New("invalid_app_type")
+	}
+
+	for _, process_type := range slices.Sorted(maps.Keys(dto.Processes)) {
+		_, err := s.repository.UpsertProcessScale(
+			database.UpsertApplicationProcessScaleParams{
+				AppID: app_with_pm.AppID,
+				ProcessType: process_type,
+				Scale: dto.Processes[process_type],
+			},
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	serving, err := s.find_serving_deployments(app_with_pm.AppID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range serving {
+		if err := s.scale_processes(&serving[i]); err != nil {
+			fmt.Println("Error at application_service.UpdateScale: ", err.Error())
+		}
+	}
+
+	return s.repository.FindProcessScales(app_with_pm.AppID)
+}
+
+// Stop stops every running deployment of the application and records that it
+// is meant to stay down.
+func (s *service) Stop(app_id string, user_id string) (*dto.ApplicationControlResponse, error) {
+	app_with_pm, err := s.find_member_app(app_id, user_id)
+	if err != nil {
+		return nil, err
+	}
+
+	serving, err := s.find_serving_deployments(app_with_pm.AppID)
+	if err != nil {
+		return nil, err
+	}
+
+	var stopped *database.ApplicationDeployment
+	for i := range serving {
+		// canaries aren't started again with the application
+		if is_canary(&serving[i]) {
+			if _, err := s.stop_deployment(&serving[i], DeploymentSuperseded, "canary aborted, application stopped by user "+user_id); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		deployment, err := s.stop_deployment(&serving[i], DeploymentStopped, "stopped by user "+user_id)
+		if err != nil {
+			return nil, err
+		}
+		if stopped == nil {
+			stopped = deployment
+		}
+	}
+
+	app, err := s.update_desired_state(app_with_pm.AppID, user_id, DesiredStateStopped, EventStopRequested, stopped)
+	if err != nil {
+		return nil, err
+	}
+
+	return dto.NewApplicationControlResponse(app, stopped), nil
+}
+
+// Start starts the application's stopped deployment again. Starting an
+// application that is already running only records the desired state.
+func (s *service) Start(app_id string, user_id string) (*dto.ApplicationControlResponse, error) {
+	app_with_pm, err := s.find_member_app(app_id, user_id)
+	if err != nil {
+		return nil, err
+	}
+
+	deployment, err := s.find_active_deployment(app_with_pm.AppID)
+	if err != nil {
+		return nil, err
+	}
+	if deployment == nil {
+		return nil, errors.New("no_deployment")
+	}
+
+	if deployment.Status == DeploymentStopped {
+		deployment, err = s.resume_deployment(deployment, "started by user "+user_id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	app, err := s.update_desired_state(app_with_pm.AppID, user_id, DesiredStateRunning, EventStartRequested, deployment)
+	if err != nil {
+		return nil, err
+	}
+
+	return dto.NewApplicationControlResponse(app, deployment), nil
+}
+
+// Restart replaces the active deployment with a new one built from the same
+// artifacts but the latest config variables. Snapshots of past deployments
+// are never rewritten so they stay valid rollback targets. Like rollbacks, the
+// restart gets its own artifacts directory so the active deployment keeps its
+// files while it is replaced.
+func (s *service) Restart(app_id string, user_id string) (*dto.ApplicationControlResponse, error) {
+	app_with_pm, err := s.find_member_app(app_id, user_id)
+	if err != nil {
+		return nil, err
+	}
+
+	active, err := s.find_active_deployment(app_with_pm.AppID)
+	if err != nil {
+		return nil, err
+	}
+	if active == nil {
+		return nil, errors.New("no_deployment")
+	}
+
+	dp_uuid, err := new_deployment_uuid()
+	if err != nil {
+		return nil, err
+	}
+
+	variables_snapshot := []byte("{}")
+	if app_with_pm.ApplicationConfig.AppCfgID.Valid && len(app_with_pm.ApplicationConfig.VariablesJson) > 0 {
+		variables_snapshot = app_with_pm.ApplicationConfig.VariablesJson
+	}
+
+	artifacts_path, err := local_artifacts_path(s.artifacts_dir, app_with_pm.AppID.String(), dp_uuid.String())
+	if err != nil {
+		return nil, err
+	}
+	// bundles from before the artifact store only exist in the active one's directory
+	if !active.BundleKey.Valid {
+		if err := copy_local_bundle(active, artifacts_path); err != nil {
+			return nil, err
+		}
+	}
+
+	deployment, err := s.repository.CreateDeployment(
+		database.CreateApplicationDeploymentParams{
+			AppDpID: dp_uuid,
+			AppID: app_with_pm.AppID,
+			ArtifactsPath: artifacts_path,
+			VariablesSnapshotJson: variables_snapshot,
+			BundleKey: active.BundleKey,
+			BundleSha256: active.BundleSha256,
+			BundleSize: active.BundleSize,
+			GitUrl: active.GitUrl,
+			GitRef: active.GitRef,
+			GitCommitSha: active.GitCommitSha,
+			Stack: app_with_pm.ApplicationConfig.Stack,
+		},
+		pgtype.Text{String: "restart of " + active.AppDpID.String() + " by user " + user_id, Valid: true},
+	)
+	if err != nil {
+		os.RemoveAll(artifacts_path)
+		return nil, err
+	}
+
+	// serving deployments are replaced once the new one is healthy
+	if active.Status == DeploymentStopped {
+		_, err = s.transition_deployment(active, DeploymentSuperseded, "superseded by restart "+dp_uuid.String())
+		if err != nil {
+			fmt.Println("Error at application_service.Restart - superseding: ", err.Error())
+			s.transition_deployment(deployment, DeploymentFailed, err.Error())
+			return nil, err
+		}
+	}
+
+	s.jobs.notify()
+
+	app, err := s.update_desired_state(app_with_pm.AppID, user_id, DesiredStateRunning, EventRestartRequested, deployment)
+	if err != nil {
+		return nil, err
+	}
+
+	return dto.NewApplicationControlResponse(app, deployment), nil
+}
+
+func (s *service) FindDeploymentLogs(app_id string, dp_id string, user_id string, dto dto.FindApplicationDeploymentLogsDto) ([]database.ApplicationDeploymentLog, error) {
+	deployment, err := s.find_member_deployment(app_id, dp_id, user_id)
+	if err != nil {
+		return nil, err
+	}
+
+	logs, err := s.repository.FindDeploymentLogs(
+		database.FindApplicationDeploymentLogsParams{
+			AppDpID: deployment.AppDpID,
+			AppDpLogID: dto.After,
+			ProcessType: pgtype.Text{String: dto.Process, Valid: dto.Process != ""},
+			LimitCount: dto.Limit,
+		},
+	)
+	if err != nil {
+		if strings.Contains(err.Error(), "no rows") {
+			return []database.ApplicationDeploymentLog{}, nil
+		}
+		return nil, err
+	}
+
+	return logs, nil
+}
+
+// FollowDeploymentLogs subscribes to lines logged from now on. A line without
+// an id stands for lines the follower fell too far behind to get, they are in
+// FindDeploymentLogs. The channel is closed once the deployment stops
+// producing output, or right away when it already has. Callers must call the
+// returned func when done.
+func (s *service) FollowDeploymentLogs(app_id string, dp_id string, user_id string) (<-chan database.ApplicationDeploymentLog, func(), error) {
+	deployment, err := s.find_member_deployment(app_id, dp_id, user_id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lines, unsubscribe := s.logs.subscribe(deployment.AppDpID.String())
+	if !IsDeploymentActive(deployment.Status) {
+		unsubscribe()
+	}
+
+	return lines, unsubscribe, nil
+}
@@ -1,17 +1,80 @@
 package application
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"context"
 	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
 
+	"github.com/salmanrf/capybara-cloud/internal/artifacts"
 	"github.com/salmanrf/capybara-cloud/internal/database"
+	"github.com/salmanrf/capybara-cloud/internal/runtime"
+	"github.com/salmanrf/capybara-cloud/internal/webhooks"
 	"github.com/salmanrf/capybara-cloud/pkg/dto"
 	"github.com/salmanrf/capybara-cloud/tests"
 )
 
+func new_test_artifact_store(t *testing.T) artifacts.ArtifactStore {
+	t.Helper()
+
+	store, err := artifacts.NewFileSystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("got error creating the artifact store %v, want nil", err)
+	}
+
+	return store
+}
+
+// run_next_job claims the next queued job and runs it like a worker would
+func run_next_job(t *testing.T, application_service *service) *database.ApplicationDeployment {
+	t.Helper()
+
+	job, err := application_service.claim_job()
+	if err != nil {
+		t.Fatalf("got error claiming a job %v, want the queued one", err)
+	}
+
+	return application_service.run_job(context.Background(), job)
+}
+
+func new_test_bundle(t *testing.T) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	content := []byte(`{"scripts":{"start":"node server.js"}}`)
+	tw.WriteHeader(&tar.Header{Name: "package.json", Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+	tw.Write(content)
+
+	if err := tw.Close(); err != nil {
+		t.Fatalf("got error writing the test bundle %v, want nil", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("got error compressing the test bundle %v, want nil", err)
+	}
+
+	return buf.Bytes()
+}
+
 func TestApplicationService(t *testing.T) {
 	ctx := context.Background()
 	pgxpool := &pgxpool.Pool{}
@@ -23,6 +86,12 @@ func TestApplicationService(t *testing.T) {
 		pgxpool,
 		application_repository,
 		&project_service,
+		t.TempDir(),
+		new_test_artifact_store(t),
+		&StubRuntime{},
+		DefaultPortRange(),
+		runtime.ExtractLimits{},
+		DefaultQueueOptions(),
 	)
 
 	t.Run("should return error not_found when app_with_pm returns nil", func (t *testing.T) {
@@ -71,4 +140,3750 @@ func TestApplicationService(t *testing.T) {
 			t.Errorf("got error %v, want %v", got_error, want_error)
 		}
 	})
+
+	t.Run("should leave the fields a config doesn't send to keep their value", func (t *testing.T) {
+		defer application_repository.Clear()
+
+		app_id := "a7e4e583-471c-4b51-bcdd-7fb57291c5cb"
+		user_id := "3ad11d5d-5a7e-433d-ac51-fba7a645f3d4"
+
+		mock_app_with_pm := &database.FindOneApplicationWithProjectMemberRow{}
+		mock_app_with_pm.AppID.Scan(app_id)
+		mock_app_with_pm.PmProjectID.Valid = true
+		application_repository.find_one_with_project_member_return = mock_app_with_pm
+
+		if _, err := application_service.CreateConfig(app_id, user_id, dto.CreateApplicationConfigDto{Stack: "python"}); err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if _, err := application_service.CreateConfig(app_id, user_id, dto.CreateApplicationConfigDto{Variables: map[string]any{"NAME": "web"}}); err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		got := application_repository.upsert_config_call_args
+		if got[0].VariablesJson != nil || got[0].Stack.String != "python" {
+			t.Errorf("got params %+v, want the stack pinned and the variables left NULL", got[0])
+		}
+		if got[1].Stack.Valid || strings.TrimSpace(string(got[1].VariablesJson)) != `{"NAME":"web"}` {
+			t.Errorf("got params %+v, want the variables set and the stack left NULL", got[1])
+		}
+	})
+
+	t.Run("should update the slug with the name and return name_taken when the project has it", func (t *testing.T) {
+		defer application_repository.Clear()
+
+		app_id := "a7e4e583-471c-4b51-bcdd-7fb57291c5cb"
+		user_id := "3ad11d5d-5a7e-433d-ac51-fba7a645f3d4"
+
+		mock_app_with_pm := &database.FindOneApplicationWithProjectMemberRow{}
+		mock_app_with_pm.AppID.Scan(app_id)
+		mock_app_with_pm.PmProjectID.Valid = true
+		application_repository.find_one_with_project_member_return = mock_app_with_pm
+		application_repository.update_one_application_error = errors.New(`ERROR: duplicate key value violates unique constraint "applications_project_id_slug_key" (SQLSTATE 23505)`)
+
+		_, err := application_service.Update(app_id, user_id, dto.UpdateApplicationDto{Name: "Ada Hardware 2"})
+
+		got_error := err
+		want_error := errors.New("name_taken")
+
+		if err == nil || got_error.Error() != want_error.Error() {
+			t.Errorf("got error %v, want %v", got_error, want_error)
+		}
+
+		got_slug := application_repository.update_one_application_call_args[0].Slug
+		want_slug := "ada-hardware-2"
+
+		if got_slug != want_slug {
+			t.Errorf("got slug %s, want %s", got_slug, want_slug)
+		}
+	})
+}
+
+func TestUpsertConfig(t *testing.T) {
+	postgres_uri := os.Getenv("TEST_POSTGRES_URI")
+	if postgres_uri == "" {
+		t.Skip("TEST_POSTGRES_URI is not set")
+	}
+
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, postgres_uri)
+	if err != nil {
+		t.Fatalf("unable to connect: %v", err)
+	}
+	t.Cleanup(pool.Close)
+
+	application_repository := NewRepository(ctx, pool, database.New(pool))
+
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 10)
+	var org_id, project_id, app_id string
+	if err := pool.QueryRow(ctx, `INSERT INTO organizations (name) VALUES ($1) RETURNING org_id::text`, "configs-"+suffix).Scan(&org_id); err != nil {
+		t.Fatalf("unable to insert organization: %v", err)
+	}
+	if err := pool.QueryRow(ctx, `INSERT INTO projects (org_id, name, slug) VALUES ($1, $2, $2) RETURNING project_id::text`, org_id, "configs-"+suffix).Scan(&project_id); err != nil {
+		t.Fatalf("unable to insert project: %v", err)
+	}
+	if err := pool.QueryRow(ctx, `INSERT INTO applications (project_id, type, name, slug) VALUES ($1, 'web_app_container', 'configs', 'configs') RETURNING app_id::text`, project_id).Scan(&app_id); err != nil {
+		t.Fatalf("unable to insert application: %v", err)
+	}
+	t.Cleanup(func() {
+		pool.Exec(ctx, `DELETE FROM application_configs WHERE app_id = $1`, app_id)
+		pool.Exec(ctx, `DELETE FROM applications WHERE app_id = $1`, app_id)
+		pool.Exec(ctx, `DELETE FROM projects WHERE project_id = $1`, project_id)
+		pool.Exec(ctx, `DELETE FROM organizations WHERE org_id = $1`, org_id)
+	})
+	app_uuid := pgtype.UUID{}
+	app_uuid.Scan(app_id)
+
+	t.Run("should keep the variables when only the stack is pinned and the other way around", func (t *testing.T) {
+		_, err := application_repository.UpsertConfig(database.CreateApplicationConfigParams{AppID: app_uuid, VariablesJson: []byte(`{"NAME": "web"}`)})
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		config, err := application_repository.UpsertConfig(database.CreateApplicationConfigParams{AppID: app_uuid, Stack: pgtype.Text{String: "python", Valid: true}})
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if config.Stack.String != "python" || !strings.Contains(string(config.VariablesJson), `"NAME": "web"`) {
+			t.Errorf("got config %s with stack %v, want the variables kept next to the pinned stack", config.VariablesJson, config.Stack)
+		}
+
+		config, err = application_repository.UpsertConfig(database.CreateApplicationConfigParams{AppID: app_uuid, VariablesJson: []byte(`{"NAME": "api"}`)})
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if config.Stack.String != "python" || !strings.Contains(string(config.VariablesJson), `"NAME": "api"`) {
+			t.Errorf("got config %s with stack %v, want the stack kept next to the new variables", config.VariablesJson, config.Stack)
+		}
+	})
+}
+
+func TestApplicationDeploymentService(t *testing.T) {
+	ctx := context.Background()
+	pgxpool := &pgxpool.Pool{}
+	application_repository := &StubApplicationRepository{}
+	project_service := tests.StubProjectService{}
+	artifacts_dir := t.TempDir()
+	artifact_store := new_test_artifact_store(t)
+	deployment_runtime := &StubRuntime{}
+
+	application_service := NewService(
+		ctx,
+		pgxpool,
+		application_repository,
+		&project_service,
+		artifacts_dir,
+		artifact_store,
+		deployment_runtime,
+		DefaultPortRange(),
+		runtime.ExtractLimits{},
+		DefaultQueueOptions(),
+	).(*service)
+	application_service.supervision.health_gate_interval = time.Millisecond
+	application_service.supervision.drain_grace = 0
+
+	app_id := "a7e4e583-471c-4b51-bcdd-7fb57291c5cb"
+	user_id := "3ad11d5d-5a7e-433d-ac51-fba7a645f3d4"
+
+	t.Run("should return error permission_denied when there's no matching project member", func (t *testing.T) {
+		defer application_repository.Clear()
+
+		mock_app_with_pm := &database.FindOneApplicationWithProjectMemberRow{}
+		mock_app_with_pm.AppID.Valid = true
+		mock_app_with_pm.PmProjectID.Valid = false
+		application_repository.find_one_with_project_member_return = mock_app_with_pm
+
+		_, err := application_service.CreateDeployment(
+			app_id,
+			user_id,
+			dto.CreateApplicationDeploymentDto{
+				BundleName: "app.tar.gz",
+				Bundle: strings.NewReader("bundle"),
+			},
+		)
+
+		want_error := errors.New("permission_denied")
+		if err == nil || err.Error() != want_error.Error() {
+			t.Errorf("got error %v, want %v", err, want_error)
+		}
+
+		if application_repository.create_deployment_n_calls != 0 {
+			t.Errorf("got create deployment called %d times, want 0", application_repository.create_deployment_n_calls)
+		}
+	})
+
+	t.Run("should reject bundles that aren't safe archives before storing them", func (t *testing.T) {
+		defer application_repository.Clear()
+
+		mock_app_with_pm := &database.FindOneApplicationWithProjectMemberRow{}
+		mock_app_with_pm.AppID.Scan(app_id)
+		mock_app_with_pm.PmProjectID.Valid = true
+		application_repository.find_one_with_project_member_return = mock_app_with_pm
+
+		_, err := application_service.CreateDeployment(
+			app_id,
+			user_id,
+			dto.CreateApplicationDeploymentDto{
+				BundleName: "app.tar.gz",
+				Bundle: strings.NewReader("bundle contents"),
+			},
+		)
+
+		var bundle_err *runtime.BundleError
+		if !errors.As(err, &bundle_err) || bundle_err.Reason != runtime.BundleReasonUnsupportedFormat {
+			t.Fatalf("got error %v, want an unsupported_format bundle error", err)
+		}
+
+		if application_repository.create_deployment_n_calls != 0 {
+			t.Errorf("got create deployment called %d times, want 0", application_repository.create_deployment_n_calls)
+		}
+	})
+
+	t.Run("should reject bundles past the upload limit before inspecting them", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer func() {
+			application_service.bundle_limits = runtime.ExtractLimits{}
+		}()
+
+		mock_app_with_pm := &database.FindOneApplicationWithProjectMemberRow{}
+		mock_app_with_pm.AppID.Scan(app_id)
+		mock_app_with_pm.PmProjectID.Valid = true
+		application_repository.find_one_with_project_member_return = mock_app_with_pm
+		application_service.bundle_limits = runtime.ExtractLimits{MaxUploadSize: 8}
+
+		_, err := application_service.CreateDeployment(
+			app_id,
+			user_id,
+			dto.CreateApplicationDeploymentDto{
+				BundleName: "app.tar.gz",
+				Bundle: strings.NewReader("bundle contents"),
+			},
+		)
+
+		var bundle_err *runtime.BundleError
+		if !errors.As(err, &bundle_err) || bundle_err.Reason != runtime.BundleReasonUploadTooLarge || bundle_err.Limit != 8 {
+			t.Fatalf("got error %v, want an upload_too_large bundle error", err)
+		}
+
+		if application_repository.create_deployment_n_calls != 0 {
+			t.Errorf("got create deployment called %d times, want 0", application_repository.create_deployment_n_calls)
+		}
+	})
+
+	t.Run("should store the bundle and snapshot the config variables", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+
+		mock_app_with_pm := &database.FindOneApplicationWithProjectMemberRow{}
+		mock_app_with_pm.AppID.Scan(app_id)
+		mock_app_with_pm.PmProjectID.Valid = true
+		mock_app_with_pm.ApplicationConfig.AppCfgID.Valid = true
+		mock_app_with_pm.ApplicationConfig.VariablesJson = []byte(`{"PORT":"3000"}`)
+		application_repository.find_one_with_project_member_return = mock_app_with_pm
+		application_repository.create_deployment_return = &database.ApplicationDeployment{Status: DeploymentQueued}
+
+		bundle := new_test_bundle(t)
+
+		_, err := application_service.CreateDeployment(
+			app_id,
+			user_id,
+			dto.CreateApplicationDeploymentDto{
+				BundleName: "app.tar.gz",
+				Bundle: bytes.NewReader(bundle),
+			},
+		)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		if application_repository.create_deployment_n_calls != 1 {
+			t.Fatalf("got create deployment called %d times, want 1", application_repository.create_deployment_n_calls)
+		}
+
+		params := application_repository.create_deployment_call_args[0]
+
+		if !params.AppDpID.Valid {
+			t.Errorf("got invalid deployment id, want a generated uuid")
+		}
+
+		want_path := filepath.Join(artifacts_dir, app_id, params.AppDpID.String())
+		if params.ArtifactsPath != want_path {
+			t.Errorf("got artifacts path %s, want %s", params.ArtifactsPath, want_path)
+		}
+
+		want_key := app_id + "/" + params.AppDpID.String() + "/bundle.tar.gz"
+		if params.BundleKey.String != want_key || params.BundleSize.Int64 != int64(len(bundle)) {
+			t.Errorf("got bundle %s of %d bytes, want %s of %d", params.BundleKey.String, params.BundleSize.Int64, want_key, len(bundle))
+		}
+
+		body, object, err := artifact_store.Get(want_key)
+		if err != nil {
+			t.Fatalf("got error reading the stored bundle %v, want nil", err)
+		}
+		defer body.Close()
+		got_bundle, _ := io.ReadAll(body)
+		if !bytes.Equal(got_bundle, bundle) || object.SHA256 != params.BundleSha256.String {
+			t.Errorf("got stored bundle of %d bytes (%s), want the uploaded %d bytes with the recorded hash", len(got_bundle), object.SHA256, len(bundle))
+		}
+
+		if string(params.VariablesSnapshotJson) != `{"PORT":"3000"}` {
+			t.Errorf("got variables snapshot %s, want %s", params.VariablesSnapshotJson, `{"PORT":"3000"}`)
+		}
+
+		if len(application_repository.jobs) != 1 || application_repository.jobs[0].job.AppDpID != params.AppDpID {
+			t.Errorf("got jobs %+v, want the deployment queued", application_repository.jobs)
+		}
+		if deployment_runtime.prepare_n_calls != 0 {
+			t.Errorf("got prepare called %d times, want the launch left to the workers", deployment_runtime.prepare_n_calls)
+		}
+	})
+
+	t.Run("should launch the deployment with its variables snapshot", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+
+		mock_app_with_pm := &database.FindOneApplicationWithProjectMemberRow{}
+		mock_app_with_pm.AppID.Scan(app_id)
+		mock_app_with_pm.PmProjectID.Valid = true
+		application_repository.find_one_with_project_member_return = mock_app_with_pm
+
+		dp_uuid := pgtype.UUID{}
+		dp_uuid.Scan("d1c0fd4b-5a2f-4f8e-9c0e-3a6f0f6b2c11")
+		application_repository.create_deployment_return = &database.ApplicationDeployment{
+			AppDpID: dp_uuid,
+			ArtifactsPath: "/artifacts/dp",
+			VariablesSnapshotJson: []byte(`{"PORT": 3000, "NAME": "capy"}`),
+			Status: DeploymentQueued,
+		}
+		deployment_runtime.start_return = &runtime.Instance{ProcessName: "node server.js [pid 42]"}
+
+		_, err := application_service.CreateDeployment(
+			app_id,
+			user_id,
+			dto.CreateApplicationDeploymentDto{
+				BundleName: "app.tar.gz",
+				Bundle: bytes.NewReader(new_test_bundle(t)),
+			},
+		)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		run_next_job(t, application_service)
+
+		if deployment_runtime.prepare_n_calls != 1 || deployment_runtime.start_n_calls != 1 {
+			t.Fatalf("got prepare/start called %d/%d times, want 1/1", deployment_runtime.prepare_n_calls, deployment_runtime.start_n_calls)
+		}
+
+		spec := deployment_runtime.start_call_args[0]
+		if spec.DeploymentID != dp_uuid.String() || spec.ArtifactsPath != "/artifacts/dp" {
+			t.Errorf("got spec %+v, want deployment %s at /artifacts/dp", spec, dp_uuid.String())
+		}
+		if spec.Variables["PORT"] != "20000" || spec.Variables["NAME"] != "capy" {
+			t.Errorf("got variables %v, want the leased PORT=20000 and NAME=capy", spec.Variables)
+		}
+
+		got_process := application_repository.upsert_deployment_process_call_args[0]
+		if got_process.ProcessName != "node server.js [pid 42]" || got_process.ProcessType != "web" || got_process.RuntimeID != dp_uuid.String() {
+			t.Errorf("got process %+v, want web running as the deployment with name %s", got_process, "node server.js [pid 42]")
+		}
+
+		got_statuses := []string{}
+		for _, params := range application_repository.transition_deployment_call_args {
+			got_statuses = append(got_statuses, params.ToStatus)
+		}
+		want_statuses := []string{DeploymentExtracting, DeploymentBuilding, DeploymentStarting, DeploymentRunning}
+		if strings.Join(got_statuses, ",") != strings.Join(want_statuses, ",") {
+			t.Errorf("got transitions %v, want %v", got_statuses, want_statuses)
+		}
+	})
+
+	t.Run("should build with the stack pinned by the config and store the detected one", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+
+		mock_app_with_pm := &database.FindOneApplicationWithProjectMemberRow{}
+		mock_app_with_pm.AppID.Scan(app_id)
+		mock_app_with_pm.PmProjectID.Valid = true
+		mock_app_with_pm.ApplicationConfig.AppCfgID.Valid = true
+		mock_app_with_pm.ApplicationConfig.Stack = pgtype.Text{String: runtime.StackPython, Valid: true}
+		application_repository.find_one_with_project_member_return = mock_app_with_pm
+
+		dp_uuid := pgtype.UUID{}
+		dp_uuid.Scan("d1c0fd4b-5a2f-4f8e-9c0e-3a6f0f6b2c11")
+		application_repository.create_deployment_return = &database.ApplicationDeployment{
+			AppDpID: dp_uuid,
+			Status: DeploymentQueued,
+			Stack: pgtype.Text{String: runtime.StackPython, Valid: true},
+		}
+		deployment_runtime.prepare_stack = runtime.StackPython
+
+		_, err := application_service.CreateDeployment(
+			app_id,
+			user_id,
+			dto.CreateApplicationDeploymentDto{
+				BundleName: "app.tar.gz",
+				Bundle: bytes.NewReader(new_test_bundle(t)),
+			},
+		)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		run_next_job(t, application_service)
+
+		if got := application_repository.create_deployment_call_args[0].Stack; got.String != runtime.StackPython {
+			t.Errorf("got deployment created with stack %q, want %q", got.String, runtime.StackPython)
+		}
+		if got := deployment_runtime.prepare_call_args[0].Stack; got != runtime.StackPython {
+			t.Errorf("got prepare called with stack %q, want %q", got, runtime.StackPython)
+		}
+
+		calls := application_repository.update_deployment_stack_call_args
+		if len(calls) != 1 || calls[0].AppDpID != dp_uuid || calls[0].Stack.String != runtime.StackPython {
+			t.Errorf("got stack updates %+v, want python stored on the deployment", calls)
+		}
+		if got := deployment_runtime.start_call_args[0].Stack; got != runtime.StackPython {
+			t.Errorf("got start called with stack %q, want %q", got, runtime.StackPython)
+		}
+	})
+
+	t.Run("should mark the deployment failed with the runtime error as reason", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+
+		mock_app_with_pm := &database.FindOneApplicationWithProjectMemberRow{}
+		mock_app_with_pm.AppID.Scan(app_id)
+		mock_app_with_pm.PmProjectID.Valid = true
+		application_repository.find_one_with_project_member_return = mock_app_with_pm
+		application_repository.create_deployment_return = &database.ApplicationDeployment{Status: DeploymentQueued}
+		deployment_runtime.prepare_error = errors.New("start_command_not_found")
+
+		_, err := application_service.CreateDeployment(
+			app_id,
+			user_id,
+			dto.CreateApplicationDeploymentDto{
+				BundleName: "app.tar.gz",
+				Bundle: bytes.NewReader(new_test_bundle(t)),
+			},
+		)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		deployment := run_next_job(t, application_service)
+
+		if deployment.Status != DeploymentFailed || deployment.FailureReason.String != "start_command_not_found" {
+			t.Errorf("got status %s (%s), want failed with the runtime error", deployment.Status, deployment.FailureReason.String)
+		}
+		if deployment_runtime.start_n_calls != 0 {
+			t.Errorf("got start called %d times, want 0", deployment_runtime.start_n_calls)
+		}
+		if job := application_repository.jobs[0].job; job.Status != JobFailed || job.LastError.String != "start_command_not_found" {
+			t.Errorf("got job %s (%s), want it failed without retrying", job.Status, job.LastError.String)
+		}
+	})
+
+	t.Run("should return error not_found when the deployment doesn't exist", func (t *testing.T) {
+		defer application_repository.Clear()
+
+		mock_app_with_pm := &database.FindOneApplicationWithProjectMemberRow{}
+		mock_app_with_pm.AppID.Scan(app_id)
+		mock_app_with_pm.PmProjectID.Valid = true
+		application_repository.find_one_with_project_member_return = mock_app_with_pm
+		application_repository.find_one_deployment_error = errors.New("no rows in result set")
+
+		_, err := application_service.FindOneDeployment(
+			app_id,
+			"d1c0fd4b-5a2f-4f8e-9c0e-3a6f0f6b2c11",
+			user_id,
+		)
+
+		want_error := errors.New("not_found")
+		if err == nil || err.Error() != want_error.Error() {
+			t.Errorf("got error %v, want %v", err, want_error)
+		}
+	})
+
+	t.Run("should relaunch a previous deployment with its own snapshot and supersede the running one", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+
+		rollback_uuid := pgtype.UUID{}
+		rollback_uuid.Scan("0b7d1f2e-3c4a-4e5b-8f6a-9d8c7b6a5e4f")
+		defer application_service.supervisor.unwatch(rollback_uuid.String())
+
+		mock_app_with_pm := &database.FindOneApplicationWithProjectMemberRow{}
+		mock_app_with_pm.AppID.Scan(app_id)
+		mock_app_with_pm.PmProjectID.Valid = true
+		mock_app_with_pm.ApplicationConfig.AppCfgID.Valid = true
+		mock_app_with_pm.ApplicationConfig.VariablesJson = []byte(`{"NAME": "broken"}`)
+		application_repository.find_one_with_project_member_return = mock_app_with_pm
+
+		source_path := t.TempDir()
+		os.WriteFile(filepath.Join(source_path, "bundle.tar.gz"), []byte("bundle"), 0o640)
+		os.MkdirAll(runtime.SourceDir(source_path), 0o750)
+		os.WriteFile(filepath.Join(runtime.SourceDir(source_path), "index.js"), []byte("draining"), 0o640)
+
+		source_uuid := pgtype.UUID{}
+		source_uuid.Scan("d1c0fd4b-5a2f-4f8e-9c0e-3a6f0f6b2c11")
+		current_uuid := pgtype.UUID{}
+		current_uuid.Scan("5f0c4a3e-8d9b-4c1a-9e2f-7b6a5d4c3b2a")
+
+		source_key := app_id + "/" + source_uuid.String() + "/bundle.tar.gz"
+		stored, err := artifact_store.Put(source_key, strings.NewReader("stored bundle"))
+		if err != nil {
+			t.Fatalf("got error storing the bundle %v, want nil", err)
+		}
+
+		application_repository.find_one_deployment_return = &database.ApplicationDeployment{
+			AppDpID: source_uuid,
+			AppID: mock_app_with_pm.AppID,
+			ArtifactsPath: source_path,
+			VariablesSnapshotJson: []byte(`{"NAME": "good"}`),
+			Status: DeploymentSuperseded,
+			BundleKey: pgtype.Text{String: source_key, Valid: true},
+			BundleSha256: pgtype.Text{String: stored.SHA256, Valid: true},
+			BundleSize: pgtype.Int8{Int64: stored.Size, Valid: true},
+		}
+		application_repository.find_deployment_transitions_return = []database.ApplicationDeploymentTransition{
+			{ToStatus: DeploymentQueued},
+			{ToStatus: DeploymentRunning},
+			{ToStatus: DeploymentSuperseded},
+		}
+		application_repository.find_deployments_by_status_return = []database.ApplicationDeployment{
+			{AppDpID: current_uuid, Status: DeploymentRunning},
+		}
+		application_repository.create_deployment_return = &database.ApplicationDeployment{
+			VariablesSnapshotJson: []byte(`{"NAME": "good"}`),
+			Status: DeploymentQueued,
+			BundleKey: pgtype.Text{String: source_key, Valid: true},
+			BundleSha256: pgtype.Text{String: stored.SHA256, Valid: true},
+		}
+
+		deployment, err := application_service.RollbackDeployment(app_id, source_uuid.String(), user_id)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if deployment.Status != DeploymentQueued {
+			t.Errorf("got status %s, want %s", deployment.Status, DeploymentQueued)
+		}
+
+		deployment = run_next_job(t, application_service)
+		if deployment.Status != DeploymentRunning {
+			t.Errorf("got status %s, want %s", deployment.Status, DeploymentRunning)
+		}
+
+		params := application_repository.create_deployment_call_args[0]
+		want_path := filepath.Join(artifacts_dir, app_id, params.AppDpID.String())
+		if params.ArtifactsPath != want_path || params.BundleKey.String != source_key || params.RolledBackFrom != source_uuid {
+			t.Errorf("got create params %+v, want its own artifacts path at %s, the source bundle and origin", params, want_path)
+		}
+		if got, _ := os.ReadFile(filepath.Join(want_path, "bundle.tar.gz")); string(got) != "stored bundle" {
+			t.Errorf("got bundle %q in the rollback's artifacts, want it fetched from the store", got)
+		}
+		if got, _ := os.ReadFile(filepath.Join(runtime.SourceDir(source_path), "index.js")); string(got) != "draining" {
+			t.Errorf("got source files %q, want the source deployment's directory left alone", got)
+		}
+		if string(params.VariablesSnapshotJson) != `{"NAME": "good"}` {
+			t.Errorf("got variables snapshot %s, want the source snapshot", params.VariablesSnapshotJson)
+		}
+
+		if deployment_runtime.stop_n_calls != 1 || deployment_runtime.stop_call_args[0] != current_uuid.String() {
+			t.Errorf("got stop called with %v, want the running deployment", deployment_runtime.stop_call_args)
+		}
+		transitions := application_repository.transition_deployment_call_args
+		if superseded := transitions[len(transitions)-1]; superseded.AppDpID != current_uuid || superseded.ToStatus != DeploymentSuperseded {
+			t.Errorf("got last transition %+v, want the running deployment superseded once the new one runs", superseded)
+		}
+		if got := deployment_runtime.start_call_args[0].Variables["NAME"]; got != "good" {
+			t.Errorf("got NAME=%s in runtime, want the snapshot value good", got)
+		}
+	})
+
+	t.Run("should copy an on-disk bundle into the rollback's own artifacts path", func (t *testing.T) {
+		defer application_repository.Clear()
+
+		mock_app_with_pm := &database.FindOneApplicationWithProjectMemberRow{}
+		mock_app_with_pm.AppID.Scan(app_id)
+		mock_app_with_pm.PmProjectID.Valid = true
+		application_repository.find_one_with_project_member_return = mock_app_with_pm
+
+		source_path := t.TempDir()
+		os.WriteFile(filepath.Join(source_path, "bundle.zip"), []byte("legacy bundle"), 0o640)
+
+		source_uuid := pgtype.UUID{}
+		source_uuid.Scan("d1c0fd4b-5a2f-4f8e-9c0e-3a6f0f6b2c11")
+		application_repository.find_one_deployment_return = &database.ApplicationDeployment{
+			AppDpID: source_uuid,
+			AppID: mock_app_with_pm.AppID,
+			ArtifactsPath: source_path,
+			Status: DeploymentStopped,
+		}
+		application_repository.find_deployment_transitions_return = []database.ApplicationDeploymentTransition{
+			{ToStatus: DeploymentRunning},
+			{ToStatus: DeploymentStopped},
+		}
+		application_repository.create_deployment_error = errors.New("unable to insert")
+
+		_, err := application_service.RollbackDeployment(app_id, source_uuid.String(), user_id)
+		if err == nil {
+			t.Fatalf("got error nil, want the insert error")
+		}
+
+		params := application_repository.create_deployment_call_args[0]
+		if params.ArtifactsPath == source_path {
+			t.Fatalf("got the source artifacts path, want the rollback's own")
+		}
+		if _, err := os.Stat(params.ArtifactsPath); !os.IsNotExist(err) {
+			t.Errorf("got the rollback's artifacts left behind (%v), want them removed when the insert fails", err)
+		}
+
+		application_repository.create_deployment_error = nil
+		application_repository.create_deployment_call_args = nil
+		application_repository.create_deployment_return = &database.ApplicationDeployment{Status: DeploymentQueued}
+
+		if _, err := application_service.RollbackDeployment(app_id, source_uuid.String(), user_id); err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		params = application_repository.create_deployment_call_args[0]
+		if got, _ := os.ReadFile(filepath.Join(params.ArtifactsPath, "bundle.zip")); string(got) != "legacy bundle" {
+			t.Errorf("got bundle %q in the rollback's artifacts, want the source's copied", got)
+		}
+	})
+
+	t.Run("should refuse rollback targets that never ran or are still active", func (t *testing.T) {
+		tests := []struct {
+			status string
+			transitions []database.ApplicationDeploymentTransition
+		}{
+			{DeploymentRunning, []database.ApplicationDeploymentTransition{{ToStatus: DeploymentRunning}}},
+			{DeploymentBuilding, []database.ApplicationDeploymentTransition{{ToStatus: DeploymentBuilding}}},
+			{DeploymentFailed, []database.ApplicationDeploymentTransition{{ToStatus: DeploymentBuilding}, {ToStatus: DeploymentFailed}}},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.status, func (t *testing.T) {
+				defer application_repository.Clear()
+				defer deployment_runtime.Clear()
+
+				mock_app_with_pm := &database.FindOneApplicationWithProjectMemberRow{}
+				mock_app_with_pm.AppID.Scan(app_id)
+				mock_app_with_pm.PmProjectID.Valid = true
+				application_repository.find_one_with_project_member_return = mock_app_with_pm
+
+				source_uuid := pgtype.UUID{}
+				source_uuid.Scan("d1c0fd4b-5a2f-4f8e-9c0e-3a6f0f6b2c11")
+				application_repository.find_one_deployment_return = &database.ApplicationDeployment{AppDpID: source_uuid, Status: tt.status}
+				application_repository.find_deployment_transitions_return = tt.transitions
+
+				_, err := application_service.RollbackDeployment(app_id, source_uuid.String(), user_id)
+
+				if err == nil || err.Error() != "invalid_rollback_target" {
+					t.Errorf("got error %v, want invalid_rollback_target", err)
+				}
+				if application_repository.create_deployment_n_calls != 0 || deployment_runtime.stop_n_calls != 0 {
+					t.Errorf("got a deployment created or stopped, want nothing changed")
+				}
+			})
+		}
+	})
+
+	t.Run("should persist build and run output and publish it to followers", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+
+		mock_app_with_pm := &database.FindOneApplicationWithProjectMemberRow{}
+		mock_app_with_pm.AppID.Scan(app_id)
+		mock_app_with_pm.PmProjectID.Valid = true
+		application_repository.find_one_with_project_member_return = mock_app_with_pm
+
+		dp_uuid := pgtype.UUID{}
+		dp_uuid.Scan("d1c0fd4b-5a2f-4f8e-9c0e-3a6f0f6b2c11")
+		application_repository.find_one_deployment_return = &database.ApplicationDeployment{AppDpID: dp_uuid, Status: DeploymentQueued}
+		application_repository.create_deployment_return = &database.ApplicationDeployment{AppDpID: dp_uuid, Status: DeploymentQueued}
+		deployment_runtime.prepare_log_lines = []runtime.LogLine{
+			{Phase: runtime.LogPhaseBuild, Stream: "stdout", Line: "Step 1/5"},
+		}
+		deployment_runtime.start_log_lines = []runtime.LogLine{
+			{Phase: runtime.LogPhaseRun, Stream: "stderr", Line: "listening"},
+		}
+
+		lines, unsubscribe, err := application_service.FollowDeploymentLogs(app_id, dp_uuid.String(), user_id)
+		if err != nil {
+			t.Fatalf("got error following %v, want nil", err)
+		}
+		defer unsubscribe()
+
+		_, err = application_service.CreateDeployment(
+			app_id,
+			user_id,
+			dto.CreateApplicationDeploymentDto{
+				BundleName: "app.tar.gz",
+				Bundle: bytes.NewReader(new_test_bundle(t)),
+			},
+		)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		run_next_job(t, application_service)
+
+		stored := application_repository.create_deployment_log_call_args
+		if len(stored) != 2 {
+			t.Fatalf("got %d stored lines, want 2", len(stored))
+		}
+		if stored[0].Phase != runtime.LogPhaseBuild || stored[1].Stream != "stderr" || stored[1].AppDpID != dp_uuid {
+			t.Errorf("got stored lines %+v, want the build then the run line", stored)
+		}
+
+		for want_id := int64(1); want_id <= 2; want_id++ {
+			select {
+			case line := <-lines:
+				if line.AppDpLogID != want_id {
+					t.Errorf("got followed line id %d, want %d", line.AppDpLogID, want_id)
+				}
+			default:
+				t.Fatalf("got no followed line %d, want it published", want_id)
+			}
+		}
+	})
+
+	t.Run("should end log follows once the deployment fails", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+
+		mock_app_with_pm := &database.FindOneApplicationWithProjectMemberRow{}
+		mock_app_with_pm.AppID.Scan(app_id)
+		mock_app_with_pm.PmProjectID.Valid = true
+		application_repository.find_one_with_project_member_return = mock_app_with_pm
+
+		dp_uuid := pgtype.UUID{}
+		dp_uuid.Scan("d1c0fd4b-5a2f-4f8e-9c0e-3a6f0f6b2c11")
+		application_repository.find_one_deployment_return = &database.ApplicationDeployment{AppDpID: dp_uuid, Status: DeploymentQueued}
+		application_repository.create_deployment_return = &database.ApplicationDeployment{AppDpID: dp_uuid, Status: DeploymentQueued}
+		deployment_runtime.prepare_error = errors.New("start_command_not_found")
+
+		lines, unsubscribe, _ := application_service.FollowDeploymentLogs(app_id, dp_uuid.String(), user_id)
+		defer unsubscribe()
+
+		application_service.CreateDeployment(
+			app_id,
+			user_id,
+			dto.CreateApplicationDeploymentDto{
+				BundleName: "app.tar.gz",
+				Bundle: bytes.NewReader(new_test_bundle(t)),
+			},
+		)
+		run_next_job(t, application_service)
+
+		if _, ok := <-lines; ok {
+			t.Errorf("got follow channel open, want it closed")
+		}
+	})
+
+	t.Run("should close the follow right away for finished deployments", func (t *testing.T) {
+		defer application_repository.Clear()
+
+		mock_app_with_pm := &database.FindOneApplicationWithProjectMemberRow{}
+		mock_app_with_pm.AppID.Scan(app_id)
+		mock_app_with_pm.PmProjectID.Valid = true
+		application_repository.find_one_with_project_member_return = mock_app_with_pm
+
+		dp_uuid := pgtype.UUID{}
+		dp_uuid.Scan("d1c0fd4b-5a2f-4f8e-9c0e-3a6f0f6b2c11")
+		application_repository.find_one_deployment_return = &database.ApplicationDeployment{AppDpID: dp_uuid, Status: DeploymentStopped}
+
+		lines, unsubscribe, err := application_service.FollowDeploymentLogs(app_id, dp_uuid.String(), user_id)
+		if err != nil {
+			t.Fatalf("got error following %v, want nil", err)
+		}
+		defer unsubscribe()
+
+		if _, ok := <-lines; ok {
+			t.Errorf("got follow channel open, want it closed")
+		}
+	})
+
+	t.Run("should tell a follower that fell behind once and deliver again after it reads", func (t *testing.T) {
+		broker := new_log_broker()
+		lines, unsubscribe := broker.subscribe("dp")
+		defer unsubscribe()
+
+		for id := int64(1); id <= log_subscriber_buffer+5; id++ {
+			broker.publish("dp", database.ApplicationDeploymentLog{AppDpLogID: id})
+		}
+
+		for want_id := int64(1); want_id <= log_subscriber_buffer; want_id++ {
+			if line := <-lines; line.AppDpLogID != want_id {
+				t.Fatalf("got line id %d, want %d", line.AppDpLogID, want_id)
+			}
+		}
+		if line := <-lines; line.AppDpLogID != 0 {
+			t.Fatalf("got line id %d, want the missed lines marker", line.AppDpLogID)
+		}
+		if len(lines) != 0 {
+			t.Fatalf("got %d more lines queued, want the marker sent once", len(lines))
+		}
+
+		broker.publish("dp", database.ApplicationDeploymentLog{AppDpLogID: log_subscriber_buffer+6})
+		if line := <-lines; line.AppDpLogID != log_subscriber_buffer+6 {
+			t.Errorf("got line id %d, want the next line delivered", line.AppDpLogID)
+		}
+	})
+}
+
+
+func TestApplicationControlService(t *testing.T) {
+	ctx := context.Background()
+	pgxpool := &pgxpool.Pool{}
+	application_repository := &StubApplicationRepository{}
+	project_service := tests.StubProjectService{}
+	deployment_runtime := &StubRuntime{}
+	artifacts_dir := t.TempDir()
+	artifact_store := new_test_artifact_store(t)
+
+	application_service := NewService(
+		ctx,
+		pgxpool,
+		application_repository,
+		&project_service,
+		artifacts_dir,
+		artifact_store,
+		deployment_runtime,
+		DefaultPortRange(),
+		runtime.ExtractLimits{},
+		DefaultQueueOptions(),
+	).(*service)
+	application_service.supervision.health_gate_interval = time.Millisecond
+	application_service.supervision.drain_grace = 0
+
+	app_id := "a7e4e583-471c-4b51-bcdd-7fb57291c5cb"
+	user_id := "3ad11d5d-5a7e-433d-ac51-fba7a645f3d4"
+
+	active_uuid := pgtype.UUID{}
+	active_uuid.Scan("d1c0fd4b-5a2f-4f8e-9c0e-3a6f0f6b2c11")
+
+	setup_member := func() {
+		mock_app_with_pm := &database.FindOneApplicationWithProjectMemberRow{}
+		mock_app_with_pm.AppID.Scan(app_id)
+		mock_app_with_pm.PmProjectID.Valid = true
+		mock_app_with_pm.ApplicationConfig.AppCfgID.Valid = true
+		mock_app_with_pm.ApplicationConfig.VariablesJson = []byte(`{"NAME": "latest"}`)
+		application_repository.find_one_with_project_member_return = mock_app_with_pm
+	}
+
+	t.Run("should stop the running deployment and record who wants it down", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+
+		setup_member()
+		application_repository.find_deployments_by_status_return = []database.ApplicationDeployment{
+			{AppDpID: active_uuid, Status: DeploymentRunning},
+		}
+
+		response, err := application_service.Stop(app_id, user_id)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		if deployment_runtime.stop_n_calls != 1 || deployment_runtime.stop_call_args[0] != active_uuid.String() {
+			t.Errorf("got stop called with %v, want the running deployment", deployment_runtime.stop_call_args)
+		}
+		if response.Deployment == nil || response.Deployment.Status != DeploymentStopped {
+			t.Errorf("got deployment %+v, want it stopped", response.Deployment)
+		}
+		if response.DesiredState != DesiredStateStopped || response.DesiredStateUpdatedBy != user_id {
+			t.Errorf("got desired state %s by %s, want %s by %s", response.DesiredState, response.DesiredStateUpdatedBy, DesiredStateStopped, user_id)
+		}
+
+		event := application_repository.update_desired_state_events[0]
+		if event.Kind != EventStopRequested || event.ActorUserID.String() != user_id || event.AppDpID != active_uuid {
+			t.Errorf("got event %+v, want a stop request by the user on the deployment", event)
+		}
+	})
+
+	t.Run("should start the stopped deployment without preparing it again", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+
+		setup_member()
+		application_repository.find_deployments_by_status_return = []database.ApplicationDeployment{
+			{AppDpID: active_uuid, Status: DeploymentStopped, VariablesSnapshotJson: []byte(`{"NAME": "snapshot"}`)},
+		}
+
+		response, err := application_service.Start(app_id, user_id)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		if deployment_runtime.prepare_n_calls != 0 || deployment_runtime.start_n_calls != 1 {
+			t.Errorf("got prepare %d and start %d calls, want 0 and 1", deployment_runtime.prepare_n_calls, deployment_runtime.start_n_calls)
+		}
+		if got := deployment_runtime.start_call_args[0].Variables["NAME"]; got != "snapshot" {
+			t.Errorf("got NAME=%s, want the deployment snapshot", got)
+		}
+
+		got_statuses := []string{}
+		for _, params := range application_repository.transition_deployment_call_args {
+			got_statuses = append(got_statuses, params.ToStatus)
+		}
+		if strings.Join(got_statuses, ",") != "starting,running" {
+			t.Errorf("got transitions %v, want starting then running", got_statuses)
+		}
+		if response.DesiredState != DesiredStateRunning {
+			t.Errorf("got desired state %s, want %s", response.DesiredState, DesiredStateRunning)
+		}
+	})
+
+	t.Run("should restart on a copy of the same artifacts with the latest config variables", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+
+		setup_member()
+		active_path := t.TempDir()
+		os.MkdirAll(runtime.SourceDir(active_path), 0o750)
+		os.WriteFile(filepath.Join(runtime.SourceDir(active_path), "index.js"), []byte("serving"), 0o640)
+		active_key := app_id + "/" + active_uuid.String() + "/bundle.tar.gz"
+		stored, err := artifact_store.Put(active_key, strings.NewReader("stored bundle"))
+		if err != nil {
+			t.Fatalf("got error storing the bundle %v, want nil", err)
+		}
+
+		application_repository.find_deployments_by_status_return = []database.ApplicationDeployment{
+			{
+				AppDpID: active_uuid,
+				ArtifactsPath: active_path,
+				Status: DeploymentRunning,
+				VariablesSnapshotJson: []byte(`{"NAME": "old"}`),
+				BundleKey: pgtype.Text{String: active_key, Valid: true},
+				BundleSha256: pgtype.Text{String: stored.SHA256, Valid: true},
+				BundleSize: pgtype.Int8{Int64: stored.Size, Valid: true},
+				GitUrl: pgtype.Text{String: "https://github.com/acme/web.git", Valid: true},
+				GitRef: pgtype.Text{String: "main", Valid: true},
+				GitCommitSha: pgtype.Text{String: "4b825dc642cb6eb9a060e54bf8d69288fbee4904", Valid: true},
+			},
+		}
+		application_repository.create_deployment_return = &database.ApplicationDeployment{
+			VariablesSnapshotJson: []byte(`{"NAME": "latest"}`),
+			Status: DeploymentQueued,
+			BundleKey: pgtype.Text{String: active_key, Valid: true},
+			BundleSha256: pgtype.Text{String: stored.SHA256, Valid: true},
+		}
+		restarted_uuid := pgtype.UUID{}
+		restarted_uuid.Scan("0b7d1f2e-3c4a-4e5b-8f6a-9d8c7b6a5e4f")
+		defer application_service.supervisor.unwatch(restarted_uuid.String())
+
+		response, err := application_service.Restart(app_id, user_id)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if response.Deployment == nil || response.Deployment.Status != DeploymentQueued {
+			t.Errorf("got deployment %+v, want the new one queued", response.Deployment)
+		}
+		launched := run_next_job(t, application_service)
+
+		params := application_repository.create_deployment_call_args[0]
+		want_path := filepath.Join(artifacts_dir, app_id, params.AppDpID.String())
+		if params.ArtifactsPath != want_path || params.BundleKey.String != active_key || string(params.VariablesSnapshotJson) != `{"NAME": "latest"}` {
+			t.Errorf("got create params %+v, want its own artifacts path at %s with the active bundle and the latest variables", params, want_path)
+		}
+		if params.GitUrl.String != "https://github.com/acme/web.git" || params.GitRef.String != "main" || params.GitCommitSha.String != "4b825dc642cb6eb9a060e54bf8d69288fbee4904" {
+			t.Errorf("got git fields %v %v %v, want the active deployment's commit", params.GitUrl, params.GitRef, params.GitCommitSha)
+		}
+		if got, _ := os.ReadFile(filepath.Join(want_path, "bundle.tar.gz")); string(got) != "stored bundle" {
+			t.Errorf("got bundle %q in the restart's artifacts, want it fetched from the store", got)
+		}
+		if got, _ := os.ReadFile(filepath.Join(runtime.SourceDir(active_path), "index.js")); string(got) != "serving" {
+			t.Errorf("got active files %q, want the active deployment's directory left alone", got)
+		}
+		if deployment_runtime.stop_n_calls != 1 {
+			t.Errorf("got stop called %d times, want 1", deployment_runtime.stop_n_calls)
+		}
+		transitions := application_repository.transition_deployment_call_args
+		if got := transitions[len(transitions)-1]; got.AppDpID != active_uuid || got.ToStatus != DeploymentSuperseded {
+			t.Errorf("got last transition %+v, want the active deployment superseded once the new one runs", got)
+		}
+		if got := deployment_runtime.start_call_args[0].Variables["NAME"]; got != "latest" {
+			t.Errorf("got NAME=%s, want the latest config value", got)
+		}
+		if launched.Status != DeploymentRunning {
+			t.Errorf("got status %s, want the new one running", launched.Status)
+		}
+	})
+
+	t.Run("should start deployments with the application's resource limits", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+		defer application_service.supervisor.unwatch(active_uuid.String())
+
+		setup_member()
+		application_repository.find_one_return = &database.Application{CpuMillis: 500, MemoryMb: 256}
+		application_repository.find_deployments_by_status_return = []database.ApplicationDeployment{
+			{AppDpID: active_uuid, Status: DeploymentStopped},
+		}
+
+		if _, err := application_service.Start(app_id, user_id); err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		want := runtime.Resources{CPUMillis: 500, MemoryMB: 256}
+		if got := deployment_runtime.start_call_args[0].Resources; got != want {
+			t.Errorf("got resources %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("should store the resource limits of members' applications", func (t *testing.T) {
+		defer application_repository.Clear()
+
+		setup_member()
+
+		_, err := application_service.UpdateResources(app_id, user_id, dto.UpdateApplicationResourcesDto{CPUMillis: 250, MemoryMB: 512})
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		params := application_repository.update_resources_call_args[0]
+		if params.AppID.String() != app_id || params.CpuMillis != 250 || params.MemoryMb != 512 {
+			t.Errorf("got params %+v, want the limits on the app", params)
+		}
+	})
+
+	t.Run("should return error permission_denied when updating resources of other projects' applications", func (t *testing.T) {
+		defer application_repository.Clear()
+
+		setup_member()
+		application_repository.find_one_with_project_member_return.PmProjectID.Valid = false
+
+		_, err := application_service.UpdateResources(app_id, user_id, dto.UpdateApplicationResourcesDto{MemoryMB: 512})
+		if err == nil || err.Error() != "permission_denied" {
+			t.Errorf("got error %v, want permission_denied", err)
+		}
+		if len(application_repository.update_resources_call_args) != 0 {
+			t.Errorf("got resources updated, want no update")
+		}
+	})
+
+	t.Run("should return error no_deployment when there's nothing to act on", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+
+		setup_member()
+
+		for _, action := range []func(string, string) (*dto.ApplicationControlResponse, error){
+			application_service.Start,
+			application_service.Restart,
+		} {
+			_, err := action(app_id, user_id)
+			if err == nil || err.Error() != "no_deployment" {
+				t.Errorf("got error %v, want no_deployment", err)
+			}
+		}
+		if application_repository.create_deployment_n_calls != 0 {
+			t.Errorf("got create deployment called %d times, want 0", application_repository.create_deployment_n_calls)
+		}
+	})
+}
+
+func TestDeploymentSupervisor(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	application_repository := &StubApplicationRepository{}
+	project_service := tests.StubProjectService{}
+	deployment_runtime := &StubRuntime{}
+
+	application_service := NewService(
+		ctx,
+		&pgxpool.Pool{},
+		application_repository,
+		&project_service,
+		t.TempDir(),
+		new_test_artifact_store(t),
+		deployment_runtime,
+		DefaultPortRange(),
+		runtime.ExtractLimits{},
+		DefaultQueueOptions(),
+	).(*service)
+	application_service.supervision = supervision_options{
+		restart_backoff: time.Millisecond,
+		max_restart_backoff: 4 * time.Millisecond,
+		crash_loop_threshold: 3,
+		stable_after: time.Minute,
+	}
+
+	dp_uuid := pgtype.UUID{}
+	dp_uuid.Scan("d1c0fd4b-5a2f-4f8e-9c0e-3a6f0f6b2c11")
+
+	new_watch := func(status string, restarts int32, probe *runtime.Probe) *deployment_watch {
+		return &deployment_watch{
+			deployment: &database.ApplicationDeployment{AppDpID: dp_uuid, Status: status, RestartCount: restarts},
+			app: &database.Application{HealthCheckFailureThreshold: 2},
+			probe: probe,
+			up_since: time.Now(),
+		}
+	}
+
+	t.Run("should double the restart backoff up to the cap", func (t *testing.T) {
+		got := []time.Duration{}
+		for restarts := int32(1); restarts <= 5; restarts++ {
+			got = append(got, application_service.supervision.backoff(restarts))
+		}
+
+		want := []time.Duration{time.Millisecond, 2 * time.Millisecond, 4 * time.Millisecond, 4 * time.Millisecond, 4 * time.Millisecond}
+		if !slices.Equal(got, want) {
+			t.Errorf("got backoffs %v, want %v", got, want)
+		}
+	})
+
+	t.Run("should ask for a restart when the process exited", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+
+		deployment_runtime.status_return = &runtime.Status{State: runtime.StateExited, ExitCode: 137}
+
+		reason := application_service.check_deployment(ctx, new_watch(DeploymentRunning, 0, nil))
+
+		if !strings.Contains(reason, "exited with code 137") {
+			t.Errorf("got reason %q, want the exit code", reason)
+		}
+	})
+
+	t.Run("should report OOM kills as the restart reason", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+
+		deployment_runtime.status_return = &runtime.Status{State: runtime.StateExited, ExitCode: 137, OOMKilled: true}
+		w := new_watch(DeploymentRunning, 0, nil)
+		w.app.MemoryMb = 256
+
+		reason := application_service.check_deployment(ctx, w)
+
+		if reason != "oom_killed: exceeded the 256 MB memory limit" {
+			t.Errorf("got reason %q, want the OOM kill with the limit", reason)
+		}
+	})
+
+	t.Run("should store health results and ask for a restart after consecutive failures", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+
+		// nothing listens on a port taken from a closed listener
+		listener, _ := net.Listen("tcp", "127.0.0.1:0")
+		_, port, _ := net.SplitHostPort(listener.Addr().String())
+		listener.Close()
+
+		probe := runtime.NewProbe(runtime.ProbeTCP, "/", time.Second, runtime.Spec{Variables: map[string]string{"PORT": port}})
+		w := new_watch(DeploymentRunning, 0, probe)
+
+		if reason := application_service.check_deployment(ctx, w); reason != "" {
+			t.Errorf("got reason %q after one failure, want none below the threshold", reason)
+		}
+		reason := application_service.check_deployment(ctx, w)
+		if !strings.Contains(reason, "failed 2 consecutive health checks") {
+			t.Errorf("got reason %q, want the failed checks", reason)
+		}
+
+		stored := application_repository.update_deployment_health_call_args
+		if len(stored) != 2 || stored[1].HealthStatus != HealthUnhealthy || stored[1].HealthConsecutiveFailures != 2 {
+			t.Errorf("got stored health %+v, want two unhealthy results", stored)
+		}
+	})
+
+	t.Run("should restart with backoff and mark crash looping at the threshold", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+
+		deployment_runtime.start_return = &runtime.Instance{ProcessName: "node server.js [pid 7]"}
+		w := new_watch(DeploymentRunning, 1, nil)
+
+		if ok := application_service.restart_supervised(ctx, w, "process exited with code 1"); !ok {
+			t.Fatalf("got restart aborted, want it done")
+		}
+		if w.deployment.Status != DeploymentRunning || w.deployment.RestartCount != 2 {
+			t.Errorf("got %s after %d restarts, want still running below the threshold", w.deployment.Status, w.deployment.RestartCount)
+		}
+
+		application_service.restart_supervised(ctx, w, "oom_killed: exceeded the 256 MB memory limit")
+		if w.deployment.Status != DeploymentCrashLooping {
+			t.Errorf("got status %s after %d restarts, want %s", w.deployment.Status, w.deployment.RestartCount, DeploymentCrashLooping)
+		}
+		if got := application_repository.transition_deployment_call_args[0].FailureReason; !strings.Contains(got.String, "oom_killed") {
+			t.Errorf("got failure reason %q, want the last restart's reason", got.String)
+		}
+
+		if deployment_runtime.stop_n_calls != 2 || deployment_runtime.start_n_calls != 2 {
+			t.Errorf("got stop %d and start %d calls, want 2 each", deployment_runtime.stop_n_calls, deployment_runtime.start_n_calls)
+		}
+		restarts := application_repository.update_deployment_restarts_call_args
+		if len(restarts) != 2 || restarts[1].RestartCount != 3 || !restarts[1].LastRestartedAt.Valid {
+			t.Errorf("got stored restarts %+v, want counts persisted with a time", restarts)
+		}
+		if got := application_repository.upsert_deployment_process_call_args[0].ProcessName; got != "node server.js [pid 7]" {
+			t.Errorf("got process name %s, want the restarted process", got)
+		}
+		if len(application_repository.create_event_call_args) != 2 || application_repository.create_event_call_args[0].Kind != EventDeploymentRestarted {
+			t.Errorf("got events %+v, want a restart event per restart", application_repository.create_event_call_args)
+		}
+	})
+
+	t.Run("should recover crash looping deployments that stay up", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+
+		w := new_watch(DeploymentCrashLooping, 4, nil)
+		w.up_since = time.Now().Add(-2 * time.Minute)
+
+		if reason := application_service.check_deployment(ctx, w); reason != "" {
+			t.Fatalf("got reason %q, want none", reason)
+		}
+
+		if w.deployment.Status != DeploymentRunning || w.deployment.RestartCount != 0 {
+			t.Errorf("got %s with %d restarts, want running with the count reset", w.deployment.Status, w.deployment.RestartCount)
+		}
+	})
+
+	t.Run("should stop supervising once the deployment is stopped", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+
+		deployment := &database.ApplicationDeployment{AppDpID: dp_uuid, Status: DeploymentRunning}
+		application_service.watch_deployment(deployment)
+
+		if _, err := application_service.stop_deployment(deployment, DeploymentStopped, "test"); err != nil {
+			t.Fatalf("got error stopping %v, want nil", err)
+		}
+
+		application_service.supervisor.mu.Lock()
+		defer application_service.supervisor.mu.Unlock()
+		if len(application_service.supervisor.watches) != 0 {
+			t.Errorf("got %d watches, want none", len(application_service.supervisor.watches))
+		}
+	})
+}
+
+func TestBlueGreenSwitch(t *testing.T) {
+	application_repository := &StubApplicationRepository{}
+	deployment_runtime := &StubRuntime{}
+
+	application_service := NewService(
+		context.Background(),
+		&pgxpool.Pool{},
+		application_repository,
+		&tests.StubProjectService{},
+		t.TempDir(),
+		new_test_artifact_store(t),
+		deployment_runtime,
+		DefaultPortRange(),
+		runtime.ExtractLimits{},
+		DefaultQueueOptions(),
+	).(*service)
+	application_service.supervision.health_gate_timeout = 50 * time.Millisecond
+	application_service.supervision.health_gate_interval = time.Millisecond
+	application_service.supervision.drain_grace = 0
+
+	new_uuid := pgtype.UUID{}
+	new_uuid.Scan("0b7d1f2e-3c4a-4e5b-8f6a-9d8c7b6a5e4f")
+	old_uuid := pgtype.UUID{}
+	old_uuid.Scan("d1c0fd4b-5a2f-4f8e-9c0e-3a6f0f6b2c11")
+
+	setup := func(health_check_type string, port string) (*database.ApplicationDeployment, runtime.Spec, []database.ApplicationDeployment) {
+		application_repository.find_one_return = &database.Application{HealthCheckType: health_check_type, HealthCheckTimeoutSeconds: 1}
+
+		deployment := &database.ApplicationDeployment{AppDpID: new_uuid, Status: DeploymentStarting}
+		spec := runtime.Spec{DeploymentID: new_uuid.String(), Variables: map[string]string{"PORT": port}}
+		previous := []database.ApplicationDeployment{{AppDpID: old_uuid, Status: DeploymentRunning}}
+
+		return deployment, spec, previous
+	}
+
+	t.Run("should switch to the new deployment once healthy and supersede the old one", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+		defer application_service.supervisor.unwatch(new_uuid.String())
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("got error listening %v, want nil", err)
+		}
+		defer listener.Close()
+		_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+		deployment, spec, previous := setup(runtime.ProbeTCP, port)
+
+		switched, err := application_service.switch_deployment(context.Background(), deployment, spec, previous, true)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if switched.Status != DeploymentRunning {
+			t.Errorf("got status %s, want %s", switched.Status, DeploymentRunning)
+		}
+		if !application_service.supervisor.watching(new_uuid.String()) {
+			t.Errorf("got the new deployment unwatched, want it supervised")
+		}
+
+		transitions := application_repository.transition_deployment_call_args
+		if len(transitions) != 2 || transitions[0].AppDpID != new_uuid || transitions[0].ToStatus != DeploymentRunning {
+			t.Fatalf("got transitions %+v, want the new deployment running first", transitions)
+		}
+		if transitions[1].AppDpID != old_uuid || transitions[1].ToStatus != DeploymentSuperseded {
+			t.Errorf("got transition %+v, want the old deployment superseded after", transitions[1])
+		}
+		if deployment_runtime.stop_n_calls != 1 || deployment_runtime.stop_call_args[0] != old_uuid.String() {
+			t.Errorf("got stop called with %v, want only the old deployment", deployment_runtime.stop_call_args)
+		}
+	})
+
+	t.Run("should keep the old deployment when the new one fails its health check", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+
+		listener, _ := net.Listen("tcp", "127.0.0.1:0")
+		_, port, _ := net.SplitHostPort(listener.Addr().String())
+		listener.Close()
+
+		deployment, spec, previous := setup(runtime.ProbeTCP, port)
+
+		switched, err := application_service.switch_deployment(context.Background(), deployment, spec, previous, true)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if switched.Status != DeploymentFailed || !strings.Contains(switched.FailureReason.String, "no passing health check") {
+			t.Errorf("got %s (%s), want failed without a passing health check", switched.Status, switched.FailureReason.String)
+		}
+
+		for _, params := range application_repository.transition_deployment_call_args {
+			if params.AppDpID == old_uuid {
+				t.Errorf("got transition %+v, want the old deployment left serving", params)
+			}
+		}
+		if deployment_runtime.stop_n_calls != 1 || deployment_runtime.stop_call_args[0] != new_uuid.String() {
+			t.Errorf("got stop called with %v, want only the new deployment", deployment_runtime.stop_call_args)
+		}
+		if application_service.supervisor.watching(new_uuid.String()) {
+			t.Errorf("got the failed deployment watched, want it left alone")
+		}
+	})
+
+	t.Run("should fail new deployments whose process exits before switching", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+
+		deployment, spec, previous := setup(runtime.ProbeNone, "")
+		deployment_runtime.status_return = &runtime.Status{State: runtime.StateExited, ExitCode: 1}
+
+		switched, err := application_service.switch_deployment(context.Background(), deployment, spec, previous, true)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if switched.Status != DeploymentFailed || switched.FailureReason.String != "process exited with code 1" {
+			t.Errorf("got %s (%s), want failed with the exit code", switched.Status, switched.FailureReason.String)
+		}
+		if deployment_runtime.stop_n_calls != 1 || deployment_runtime.stop_call_args[0] != new_uuid.String() {
+			t.Errorf("got stop called with %v, want only the new deployment", deployment_runtime.stop_call_args)
+		}
+	})
+}
+
+func TestCanaryRelease(t *testing.T) {
+	application_repository := &StubApplicationRepository{}
+	deployment_runtime := &StubRuntime{}
+
+	application_service := NewService(
+		context.Background(),
+		&pgxpool.Pool{},
+		application_repository,
+		&tests.StubProjectService{},
+		t.TempDir(),
+		new_test_artifact_store(t),
+		deployment_runtime,
+		DefaultPortRange(),
+		runtime.ExtractLimits{},
+		DefaultQueueOptions(),
+	).(*service)
+	application_service.supervision.health_gate_interval = time.Millisecond
+	application_service.supervision.drain_grace = 0
+
+	app_id := "a7e4e583-471c-4b51-bcdd-7fb57291c5cb"
+	user_id := "3ad11d5d-5a7e-433d-ac51-fba7a645f3d4"
+
+	canary_uuid := pgtype.UUID{}
+	canary_uuid.Scan("0b7d1f2e-3c4a-4e5b-8f6a-9d8c7b6a5e4f")
+	stable_uuid := pgtype.UUID{}
+	stable_uuid.Scan("d1c0fd4b-5a2f-4f8e-9c0e-3a6f0f6b2c11")
+
+	setup := func() (*database.ApplicationDeployment, *database.ApplicationDeployment) {
+		mock_app_with_pm := &database.FindOneApplicationWithProjectMemberRow{}
+		mock_app_with_pm.AppID.Scan(app_id)
+		mock_app_with_pm.PmProjectID.Valid = true
+		application_repository.find_one_with_project_member_return = mock_app_with_pm
+
+		canary := &database.ApplicationDeployment{
+			AppDpID: canary_uuid,
+			AppID: mock_app_with_pm.AppID,
+			Status: DeploymentRunning,
+			CanaryWeight: pgtype.Int4{Int32: 10, Valid: true},
+		}
+		stable := &database.ApplicationDeployment{AppDpID: stable_uuid, AppID: mock_app_with_pm.AppID, Status: DeploymentRunning}
+		application_repository.find_one_deployment_return = canary
+		application_repository.find_deployments_by_status_return = []database.ApplicationDeployment{*canary, *stable}
+
+		return canary, stable
+	}
+
+	t.Run("should create canaries with their weight", func (t *testing.T) {
+		defer application_repository.Clear()
+		setup()
+		application_repository.find_deployments_return = []database.ApplicationDeployment{
+			{AppDpID: canary_uuid, Status: DeploymentSuperseded, CanaryWeight: pgtype.Int4{Int32: 50, Valid: true}},
+			{AppDpID: stable_uuid, Status: DeploymentRunning},
+		}
+		application_repository.create_deployment_return = &database.ApplicationDeployment{Status: DeploymentQueued}
+
+		weight := int32(10)
+		_, err := application_service.CreateDeployment(
+			app_id,
+			user_id,
+			dto.CreateApplicationDeploymentDto{GitURL: "https://github.com/acme/web.git", CanaryWeight: &weight, CanarySticky: true},
+		)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		params := application_repository.create_deployment_call_args[0]
+		if params.CanaryWeight != (pgtype.Int4{Int32: 10, Valid: true}) || !params.CanarySticky {
+			t.Errorf("got canary %+v sticky %v, want 10%% and sticky", params.CanaryWeight, params.CanarySticky)
+		}
+		if reason := application_repository.create_deployment_reasons[0].String; !strings.Contains(reason, "canary for 10% of the traffic") {
+			t.Errorf("got reason %q, want the canary weight", reason)
+		}
+	})
+
+	t.Run("should refuse a canary while another one is active", func (t *testing.T) {
+		defer application_repository.Clear()
+		canary, stable := setup()
+		application_repository.find_deployments_return = []database.ApplicationDeployment{*canary, *stable}
+
+		weight := int32(10)
+		_, err := application_service.CreateDeployment(
+			app_id,
+			user_id,
+			dto.CreateApplicationDeploymentDto{GitURL: "https://github.com/acme/web.git", CanaryWeight: &weight},
+		)
+		if err == nil || err.Error() != "canary_in_progress" {
+			t.Errorf("got error %v, want canary_in_progress", err)
+		}
+		if application_repository.create_deployment_n_calls != 0 {
+			t.Errorf("got %d deployments created, want none", application_repository.create_deployment_n_calls)
+		}
+	})
+
+	t.Run("should keep the stable deployment serving next to a healthy canary", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+		defer application_service.supervisor.unwatch(canary_uuid.String())
+		canary, stable := setup()
+		canary.Status = DeploymentStarting
+
+		switched, err := application_service.switch_deployment(context.Background(), canary, runtime.Spec{}, []database.ApplicationDeployment{*stable}, true)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if switched.Status != DeploymentRunning {
+			t.Errorf("got status %s, want %s", switched.Status, DeploymentRunning)
+		}
+		if deployment_runtime.stop_n_calls != 0 || len(application_repository.transition_deployment_call_args) != 1 {
+			t.Errorf("got stop called with %v, want the stable deployment left serving", deployment_runtime.stop_call_args)
+		}
+		if reason := application_repository.transition_deployment_reasons[0].String; !strings.Contains(reason, "10% of the traffic as a canary") {
+			t.Errorf("got reason %q, want the canary share", reason)
+		}
+	})
+
+	t.Run("should change the weight of a serving canary", func (t *testing.T) {
+		defer application_repository.Clear()
+		canary, _ := setup()
+		application_repository.update_deployment_canary_return = canary
+
+		changes, unwatch := application_service.WatchDeploymentChanges()
+		defer unwatch()
+
+		_, err := application_service.UpdateCanary(app_id, canary_uuid.String(), user_id, dto.UpdateApplicationDeploymentCanaryDto{CanaryWeight: 50})
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		params := application_repository.update_deployment_canary_call_args[0]
+		if params.AppDpID != canary_uuid || params.CanaryWeight.Int32 != 50 {
+			t.Errorf("got params %+v, want the canary at 50%%", params)
+		}
+		select {
+		case <-changes:
+		default:
+			t.Errorf("got no change notified, want the ingress refreshed")
+		}
+		if event := application_repository.create_event_call_args[0]; event.Kind != EventCanaryUpdated || event.ActorUserID.String() != user_id {
+			t.Errorf("got event %+v, want the update recorded for the user", event)
+		}
+	})
+
+	t.Run("should promote a canary and supersede the stable deployment after the drain", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+		canary, _ := setup()
+		promoted := *canary
+		promoted.CanaryWeight = pgtype.Int4{}
+		application_repository.promote_deployment_canary_return = &promoted
+
+		deployment, err := application_service.PromoteCanary(app_id, canary_uuid.String(), user_id)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		application_service.drains.Wait()
+
+		if deployment.CanaryWeight.Valid {
+			t.Errorf("got canary weight %d, want none once promoted", deployment.CanaryWeight.Int32)
+		}
+		if deployment_runtime.stop_n_calls != 1 || deployment_runtime.stop_call_args[0] != stable_uuid.String() {
+			t.Errorf("got stop called with %v, want only the stable deployment", deployment_runtime.stop_call_args)
+		}
+		transitions := application_repository.transition_deployment_call_args
+		if len(transitions) != 1 || transitions[0].AppDpID != stable_uuid || transitions[0].ToStatus != DeploymentSuperseded {
+			t.Errorf("got transitions %+v, want the stable deployment superseded", transitions)
+		}
+		if event := application_repository.create_event_call_args[0]; event.Kind != EventCanaryPromoted {
+			t.Errorf("got event %+v, want %s", event, EventCanaryPromoted)
+		}
+	})
+
+	t.Run("should abort a canary and leave the stable deployment serving", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+		setup()
+
+		deployment, err := application_service.AbortCanary(app_id, canary_uuid.String(), user_id)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		if deployment.Status != DeploymentSuperseded {
+			t.Errorf("got status %s, want %s", deployment.Status, DeploymentSuperseded)
+		}
+		if deployment_runtime.stop_n_calls != 1 || deployment_runtime.stop_call_args[0] != canary_uuid.String() {
+			t.Errorf("got stop called with %v, want only the canary", deployment_runtime.stop_call_args)
+		}
+		if event := application_repository.create_event_call_args[0]; event.Kind != EventCanaryAborted {
+			t.Errorf("got event %+v, want %s", event, EventCanaryAborted)
+		}
+	})
+
+	t.Run("should refuse deployments that aren't serving canaries", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+		_, stable := setup()
+
+		targets := []*database.ApplicationDeployment{
+			stable,
+			{AppDpID: canary_uuid, Status: DeploymentBuilding, CanaryWeight: pgtype.Int4{Int32: 10, Valid: true}},
+			{AppDpID: canary_uuid, Status: DeploymentSuperseded, CanaryWeight: pgtype.Int4{Int32: 10, Valid: true}},
+		}
+		for _, target := range targets {
+			application_repository.find_one_deployment_return = target
+
+			_, err := application_service.PromoteCanary(app_id, target.AppDpID.String(), user_id)
+			if err == nil || err.Error() != "invalid_canary_target" {
+				t.Errorf("got error %v promoting a %s deployment, want invalid_canary_target", err, target.Status)
+			}
+			_, err = application_service.AbortCanary(app_id, target.AppDpID.String(), user_id)
+			if err == nil || err.Error() != "invalid_canary_target" {
+				t.Errorf("got error %v aborting a %s deployment, want invalid_canary_target", err, target.Status)
+			}
+		}
+		if deployment_runtime.stop_n_calls != 0 {
+			t.Errorf("got stop called with %v, want nothing stopped", deployment_runtime.stop_call_args)
+		}
+	})
+
+	t.Run("should abort canaries instead of stopping them with the application", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+		setup()
+
+		response, err := application_service.Stop(app_id, user_id)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		got := map[pgtype.UUID]string{}
+		for _, params := range application_repository.transition_deployment_call_args {
+			got[params.AppDpID] = params.ToStatus
+		}
+		if got[canary_uuid] != DeploymentSuperseded || got[stable_uuid] != DeploymentStopped {
+			t.Errorf("got transitions %v, want the canary superseded and the stable deployment stopped", got)
+		}
+		if response.Deployment == nil || response.Deployment.AppDpID != stable_uuid.String() {
+			t.Errorf("got deployment %+v, want the stable one reported stopped", response.Deployment)
+		}
+	})
+
+	t.Run("should add the counted traffic to the canaries", func (t *testing.T) {
+		defer application_repository.Clear()
+
+		err := application_service.RecordCanaryTraffic([]dto.CanaryTraffic{
+			{DeploymentID: canary_uuid.String(), CanaryRequests: 10, CanaryErrors: 2, StableRequests: 90, StableErrors: 1},
+			{DeploymentID: "not-a-uuid", CanaryRequests: 1},
+		})
+		if err == nil {
+			t.Errorf("got nil error, want the invalid deployment id reported")
+		}
+
+		want := database.AddApplicationDeploymentCanaryTrafficParams{AppDpID: canary_uuid, CanaryRequests: 10, CanaryErrors: 2, StableRequests: 90, StableErrors: 1}
+		if got := application_repository.add_deployment_canary_traffic_call_args; len(got) != 1 || got[0] != want {
+			t.Errorf("got traffic %+v, want %+v", got, want)
+		}
+	})
+}
+
+func TestFetchBundle(t *testing.T) {
+	artifact_store := new_test_artifact_store(t)
+	application_service := NewService(
+		context.Background(),
+		&pgxpool.Pool{},
+		&StubApplicationRepository{},
+		&tests.StubProjectService{},
+		t.TempDir(),
+		artifact_store,
+		&StubRuntime{},
+		DefaultPortRange(),
+		runtime.ExtractLimits{},
+		DefaultQueueOptions(),
+	).(*service)
+
+	stored, err := artifact_store.Put("app-1/dp-1/bundle.tar.gz", strings.NewReader("bundle contents"))
+	if err != nil {
+		t.Fatalf("got error storing the bundle %v, want nil", err)
+	}
+
+	new_deployment := func(key string, sha256 string) *database.ApplicationDeployment {
+		return &database.ApplicationDeployment{
+			ArtifactsPath: filepath.Join(t.TempDir(), "app-1", "dp-1"),
+			BundleKey: pgtype.Text{String: key, Valid: true},
+			BundleSha256: pgtype.Text{String: sha256, Valid: true},
+		}
+	}
+
+	t.Run("should download the bundle into the local artifacts directory", func (t *testing.T) {
+		deployment := new_deployment(stored.Key, stored.SHA256)
+
+		if err := application_service.fetch_bundle(deployment); err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		got, err := os.ReadFile(filepath.Join(deployment.ArtifactsPath, "bundle.tar.gz"))
+		if err != nil || string(got) != "bundle contents" {
+			t.Errorf("got local bundle %q (%v), want the stored one", got, err)
+		}
+		if _, err := runtime.FindBundle(deployment.ArtifactsPath); err != nil {
+			t.Errorf("got error %v finding the bundle, want the runtime to see it", err)
+		}
+	})
+
+	t.Run("should refuse bundles that don't match the recorded hash", func (t *testing.T) {
+		deployment := new_deployment(stored.Key, strings.Repeat("0", 64))
+
+		err := application_service.fetch_bundle(deployment)
+		if err == nil || err.Error() != "artifact_hash_mismatch" {
+			t.Errorf("got error %v, want artifact_hash_mismatch", err)
+		}
+		if entries, _ := os.ReadDir(deployment.ArtifactsPath); len(entries) != 0 {
+			t.Errorf("got %d files left behind, want none", len(entries))
+		}
+	})
+
+	t.Run("should return error artifacts_not_found for deleted bundles", func (t *testing.T) {
+		err := application_service.fetch_bundle(new_deployment("app-1/dp-2/bundle.tar.gz", stored.SHA256))
+
+		if err == nil || err.Error() != "artifacts_not_found" {
+			t.Errorf("got error %v, want artifacts_not_found", err)
+		}
+	})
+}
+
+// new_test_git_remote commits a package.json to a bare repository that can be
+// deployed through its file:// url for the rest of the test, it returns the
+// url and the commit.
+func new_test_git_remote(t *testing.T) (string, string) {
+	t.Helper()
+	t.Setenv("GIT_ALLOW_FILE_URLS", "true")
+
+	work := t.TempDir()
+	remote := filepath.Join(t.TempDir(), "app.git")
+
+	git := func(dir string, args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(
+			os.Environ(),
+			"GIT_AUTHOR_NAME=capy", "GIT_AUTHOR_EMAIL=capy@example.com",
+			"GIT_COMMITTER_NAME=capy", "GIT_COMMITTER_EMAIL=capy@example.com",
+			"GIT_CONFIG_GLOBAL=/dev/null",
+		)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("got error running git %v: %v\n%s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+
+	git(work, "init", "--quiet", "--initial-branch=main")
+	os.WriteFile(filepath.Join(work, "package.json"), []byte(`{"scripts":{"start":"node server.js"}}`), 0o640)
+	git(work, "add", "-A")
+	git(work, "commit", "--quiet", "-m", "initial")
+	sha := git(work, "rev-parse", "HEAD")
+	git(work, "clone", "--quiet", "--bare", work, remote)
+
+	return "file://" + remote, sha
+}
+
+func TestGitDeployment(t *testing.T) {
+	application_repository := &StubApplicationRepository{}
+	artifact_store := new_test_artifact_store(t)
+	deployment_runtime := &StubRuntime{}
+
+	application_service := NewService(
+		context.Background(),
+		&pgxpool.Pool{},
+		application_repository,
+		&tests.StubProjectService{},
+		t.TempDir(),
+		artifact_store,
+		deployment_runtime,
+		DefaultPortRange(),
+		runtime.ExtractLimits{},
+		DefaultQueueOptions(),
+	).(*service)
+
+	app_id := "a7e4e583-471c-4b51-bcdd-7fb57291c5cb"
+	user_id := "3ad11d5d-5a7e-433d-ac51-fba7a645f3d4"
+	git_url, sha := new_test_git_remote(t)
+
+	setup := func(t *testing.T, git_ref string) *database.ApplicationDeployment {
+		mock_app_with_pm := &database.FindOneApplicationWithProjectMemberRow{}
+		mock_app_with_pm.AppID.Scan(app_id)
+		mock_app_with_pm.PmProjectID.Valid = true
+		application_repository.find_one_with_project_member_return = mock_app_with_pm
+
+		created := &database.ApplicationDeployment{
+			ArtifactsPath: t.TempDir(),
+			GitUrl: pgtype.Text{String: git_url, Valid: true},
+			GitRef: pgtype.Text{String: git_ref, Valid: git_ref != ""},
+			Status: DeploymentQueued,
+		}
+		created.AppDpID.Scan("d1c0fd4b-5a2f-4f8e-9c0e-3a6f0f6b2c11")
+		created.AppID.Scan(app_id)
+		application_repository.create_deployment_return = created
+		application_repository.update_deployment_source_return = created
+		deployment_runtime.start_return = &runtime.Instance{ProcessName: "node server.js [pid 42]"}
+
+		return created
+	}
+
+	t.Run("should record the repository without storing a bundle up front", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+		created := setup(t, "main")
+		defer application_service.supervisor.unwatch(created.AppDpID.String())
+
+		_, err := application_service.CreateDeployment(
+			app_id,
+			user_id,
+			dto.CreateApplicationDeploymentDto{GitURL: git_url, GitRef: "main"},
+		)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		params := application_repository.create_deployment_call_args[0]
+		if params.GitUrl.String != git_url || params.GitRef.String != "main" {
+			t.Errorf("got git source %s@%s, want %s@main", params.GitUrl.String, params.GitRef.String, git_url)
+		}
+		if params.BundleKey.Valid {
+			t.Errorf("got bundle key %s, want none until the repository is fetched", params.BundleKey.String)
+		}
+	})
+
+	t.Run("should fetch the ref, record the commit and build from it", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+		created := setup(t, "main")
+		defer application_service.supervisor.unwatch(created.AppDpID.String())
+
+		_, err := application_service.CreateDeployment(
+			app_id,
+			user_id,
+			dto.CreateApplicationDeploymentDto{GitURL: git_url, GitRef: "main"},
+		)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		deployment := run_next_job(t, application_service)
+		if deployment.Status != DeploymentRunning {
+			t.Fatalf("got status %s (%s), want running", deployment.Status, deployment.FailureReason.String)
+		}
+
+		if len(application_repository.update_deployment_source_call_args) != 1 {
+			t.Fatalf("got source updated %d times, want 1", len(application_repository.update_deployment_source_call_args))
+		}
+		source := application_repository.update_deployment_source_call_args[0]
+		if source.GitCommitSha.String != sha {
+			t.Errorf("got commit %s, want %s", source.GitCommitSha.String, sha)
+		}
+
+		object, err := artifact_store.Stat(source.BundleKey.String)
+		if err != nil || object.SHA256 != source.BundleSha256.String {
+			t.Errorf("got stored bundle %+v (%v), want it stored with the recorded hash", object, err)
+		}
+
+		bundle_path, err := runtime.FindBundle(created.ArtifactsPath)
+		if err != nil {
+			t.Fatalf("got error %v finding the bundle, want the runtime to see it", err)
+		}
+		if got, _ := file_sha256(bundle_path); got != source.BundleSha256.String {
+			t.Errorf("got local bundle hash %s, want %s", got, source.BundleSha256.String)
+		}
+		if deployment_runtime.prepare_n_calls != 1 {
+			t.Errorf("got prepare called %d times, want 1", deployment_runtime.prepare_n_calls)
+		}
+
+		fetched := false
+		for _, line := range application_repository.create_deployment_log_call_args {
+			if strings.HasPrefix(line.Line, "$ git fetch") {
+				fetched = true
+			}
+		}
+		if !fetched {
+			t.Errorf("got logs %+v, want the fetch logged", application_repository.create_deployment_log_call_args)
+		}
+	})
+
+	t.Run("should fail the deployment when the ref doesn't exist", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+		setup(t, "nope")
+
+		_, err := application_service.CreateDeployment(
+			app_id,
+			user_id,
+			dto.CreateApplicationDeploymentDto{GitURL: git_url, GitRef: "nope"},
+		)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		deployment := run_next_job(t, application_service)
+
+		if deployment.Status != DeploymentFailed || deployment.FailureReason.String != "git_ref_not_found" {
+			t.Errorf("got status %s (%s), want failed with git_ref_not_found", deployment.Status, deployment.FailureReason.String)
+		}
+		if deployment_runtime.prepare_n_calls != 0 {
+			t.Errorf("got prepare called %d times, want 0", deployment_runtime.prepare_n_calls)
+		}
+	})
+}
+
+func TestWebhooks(t *testing.T) {
+	application_repository := &StubApplicationRepository{}
+	deployment_runtime := &StubRuntime{}
+
+	application_service := NewService(
+		context.Background(),
+		&pgxpool.Pool{},
+		application_repository,
+		&tests.StubProjectService{},
+		t.TempDir(),
+		new_test_artifact_store(t),
+		deployment_runtime,
+		DefaultPortRange(),
+		runtime.ExtractLimits{},
+		DefaultQueueOptions(),
+	).(*service)
+
+	app_id := "a7e4e583-471c-4b51-bcdd-7fb57291c5cb"
+	user_id := "3ad11d5d-5a7e-433d-ac51-fba7a645f3d4"
+	git_url, sha := new_test_git_remote(t)
+
+	setup := func(t *testing.T) string {
+		mock_app_with_pm := &database.FindOneApplicationWithProjectMemberRow{}
+		mock_app_with_pm.AppID.Scan(app_id)
+		mock_app_with_pm.PmProjectID.Valid = true
+		application_repository.find_one_with_project_member_return = mock_app_with_pm
+
+		webhook, err := application_service.UpdateWebhook(
+			app_id,
+			user_id,
+			dto.UpdateApplicationWebhookDto{GitURL: git_url, Branch: "main"},
+		)
+		if err != nil {
+			t.Fatalf("got error %v configuring the webhook, want nil", err)
+		}
+
+		return webhook.Secret
+	}
+
+	push := func(secret string, ref string) dto.ReceiveApplicationWebhookDto {
+		body := []byte(`{"ref":"` + ref + `","after":"` + sha + `"}`)
+		header := http.Header{}
+		header.Set("X-GitHub-Event", "push")
+		header.Set("X-GitHub-Delivery", "delivery-1")
+		name, value := webhooks.Sign(webhooks.ProviderGitHub, body, secret)
+		header.Set(name, value)
+
+		return dto.ReceiveApplicationWebhookDto{Header: header, Body: body}
+	}
+
+	t.Run("should generate a secret once and keep it until rotated", func (t *testing.T) {
+		defer application_repository.Clear()
+		secret := setup(t)
+		if len(secret) != 64 {
+			t.Fatalf("got secret %q, want a generated secret", secret)
+		}
+
+		updated, err := application_service.UpdateWebhook(
+			app_id,
+			user_id,
+			dto.UpdateApplicationWebhookDto{GitURL: git_url, Branch: "release"},
+		)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if updated.Secret != "" || application_repository.webhook.Secret != secret {
+			t.Errorf("got secret %q stored %q, want %q kept and not shown", updated.Secret, application_repository.webhook.Secret, secret)
+		}
+
+		rotated, err := application_service.UpdateWebhook(
+			app_id,
+			user_id,
+			dto.UpdateApplicationWebhookDto{GitURL: git_url, Branch: "main", RotateSecret: true},
+		)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if rotated.Secret == "" || rotated.Secret == secret {
+			t.Errorf("got secret %q, want a new one", rotated.Secret)
+		}
+
+		found, err := application_service.FindOneWebhook(app_id, user_id)
+		if err != nil || found.Secret != "" {
+			t.Errorf("got webhook %+v (%v), want it without the secret", found, err)
+		}
+	})
+
+	t.Run("should return not_found for apps without a webhook", func (t *testing.T) {
+		defer application_repository.Clear()
+
+		_, err := application_service.ReceiveWebhook(app_id, push("secret", "refs/heads/main"))
+		if err == nil || err.Error() != "not_found" {
+			t.Errorf("got error %v, want not_found", err)
+		}
+	})
+
+	t.Run("should record rejected deliveries without deploying", func (t *testing.T) {
+		defer application_repository.Clear()
+		setup(t)
+
+		delivery, err := application_service.ReceiveWebhook(app_id, push("wrong", "refs/heads/main"))
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		if delivery.Result != dto.WebhookDeliveryRejected || delivery.Verified || delivery.Message.String != "invalid_signature" {
+			t.Errorf("got delivery %s verified %v (%s), want rejected invalid_signature", delivery.Result, delivery.Verified, delivery.Message.String)
+		}
+		if len(application_repository.create_webhook_delivery_call_args) != 1 {
+			t.Errorf("got %d deliveries recorded, want 1", len(application_repository.create_webhook_delivery_call_args))
+		}
+		if len(application_repository.create_deployment_call_args) != 0 {
+			t.Errorf("got %d deployments created, want 0", len(application_repository.create_deployment_call_args))
+		}
+	})
+
+	t.Run("should ignore pushes to other branches", func (t *testing.T) {
+		defer application_repository.Clear()
+		secret := setup(t)
+
+		delivery, err := application_service.ReceiveWebhook(app_id, push(secret, "refs/heads/feature"))
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		if delivery.Result != dto.WebhookDeliveryIgnored || !delivery.Verified {
+			t.Errorf("got delivery %s verified %v, want a verified ignored delivery", delivery.Result, delivery.Verified)
+		}
+		if len(application_repository.create_deployment_call_args) != 0 {
+			t.Errorf("got %d deployments created, want 0", len(application_repository.create_deployment_call_args))
+		}
+	})
+
+	t.Run("should ignore pushes while the application is stopped", func (t *testing.T) {
+		defer application_repository.Clear()
+		secret := setup(t)
+		application_repository.find_one_return = &database.Application{DesiredState: DesiredStateStopped}
+
+		delivery, err := application_service.ReceiveWebhook(app_id, push(secret, "refs/heads/main"))
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		if delivery.Result != dto.WebhookDeliveryIgnored {
+			t.Errorf("got delivery %s, want ignored", delivery.Result)
+		}
+	})
+
+	t.Run("should deploy the pushed commit of the configured branch", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+		secret := setup(t)
+
+		application_repository.find_one_return = &database.Application{DesiredState: DesiredStateRunning}
+		application_repository.find_config_return = &database.ApplicationConfig{VariablesJson: []byte(`{"NODE_ENV":"production"}`)}
+
+		created := &database.ApplicationDeployment{
+			ArtifactsPath: t.TempDir(),
+			GitUrl: pgtype.Text{String: git_url, Valid: true},
+			GitRef: pgtype.Text{String: sha, Valid: true},
+			Status: DeploymentQueued,
+		}
+		created.AppDpID.Scan("d1c0fd4b-5a2f-4f8e-9c0e-3a6f0f6b2c11")
+		created.AppID.Scan(app_id)
+		application_repository.create_deployment_return = created
+		application_repository.update_deployment_source_return = created
+		deployment_runtime.start_return = &runtime.Instance{ProcessName: "node server.js [pid 42]"}
+		defer application_service.supervisor.unwatch(created.AppDpID.String())
+
+		delivery, err := application_service.ReceiveWebhook(app_id, push(secret, "refs/heads/main"))
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		run_next_job(t, application_service)
+
+		if delivery.Result != dto.WebhookDeliveryDeployed || delivery.AppDpID != created.AppDpID {
+			t.Errorf("got delivery %s for %s (%s), want deployed", delivery.Result, delivery.AppDpID.String(), delivery.Message.String)
+		}
+
+		if len(application_repository.create_deployment_call_args) != 1 {
+			t.Fatalf("got %d deployments created, want 1", len(application_repository.create_deployment_call_args))
+		}
+		params := application_repository.create_deployment_call_args[0]
+		if params.GitRef.String != sha || string(params.VariablesSnapshotJson) != `{"NODE_ENV":"production"}` {
+			t.Errorf("got ref %s variables %s, want the pushed commit with the app's variables", params.GitRef.String, params.VariablesSnapshotJson)
+		}
+
+		if len(application_repository.update_deployment_source_call_args) != 1 || application_repository.update_deployment_source_call_args[0].GitCommitSha.String != sha {
+			t.Errorf("got source updates %+v, want the pushed commit fetched", application_repository.update_deployment_source_call_args)
+		}
+
+		deliveries, err := application_service.FindWebhookDeliveries(app_id, user_id)
+		if err != nil || len(deliveries) != 1 || deliveries[0].CommitSha.String != sha {
+			t.Errorf("got deliveries %+v (%v), want the push recorded", deliveries, err)
+		}
+	})
+}
+
+func TestDeploymentJobQueue(t *testing.T) {
+	application_repository := &StubApplicationRepository{}
+	deployment_runtime := &StubRuntime{}
+
+	options := DefaultQueueOptions()
+	options.MaxAttempts = 2
+
+	application_service := NewService(
+		context.Background(),
+		&pgxpool.Pool{},
+		application_repository,
+		&tests.StubProjectService{},
+		t.TempDir(),
+		new_test_artifact_store(t),
+		deployment_runtime,
+		DefaultPortRange(),
+		runtime.ExtractLimits{},
+		options,
+	).(*service)
+
+	app_id := "a7e4e583-471c-4b51-bcdd-7fb57291c5cb"
+	user_id := "3ad11d5d-5a7e-433d-ac51-fba7a645f3d4"
+
+	enqueue := func(t *testing.T, dp_id string) *database.ApplicationDeployment {
+		mock_app_with_pm := &database.FindOneApplicationWithProjectMemberRow{}
+		mock_app_with_pm.AppID.Scan(app_id)
+		mock_app_with_pm.PmProjectID.Valid = true
+		application_repository.find_one_with_project_member_return = mock_app_with_pm
+
+		created := &database.ApplicationDeployment{
+			ArtifactsPath: t.TempDir(),
+			VariablesSnapshotJson: []byte("{}"),
+			Status: DeploymentQueued,
+		}
+		created.AppDpID.Scan(dp_id)
+		created.AppID.Scan(app_id)
+		application_repository.create_deployment_return = created
+		application_repository.CreateDeployment(
+			database.CreateApplicationDeploymentParams{AppDpID: created.AppDpID, AppID: created.AppID},
+			pgtype.Text{},
+		)
+
+		return created
+	}
+
+	got_statuses := func() string {
+		statuses := []string{}
+		for _, params := range application_repository.transition_deployment_call_args {
+			statuses = append(statuses, params.ToStatus)
+		}
+		return strings.Join(statuses, ",")
+	}
+
+	t.Run("should retry transient failures with a backoff until attempts run out", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+		enqueue(t, "d1c0fd4b-5a2f-4f8e-9c0e-3a6f0f6b2c11")
+		application_repository.lease_deployment_port_error = errors.New("no rows in result set")
+
+		deployment := run_next_job(t, application_service)
+
+		job := application_repository.jobs[0].job
+		if deployment.Status != DeploymentQueued || job.Status != JobQueued || job.LastError.String != "no_ports_available" {
+			t.Fatalf("got deployment %s and job %s (%s), want both queued again", deployment.Status, job.Status, job.LastError.String)
+		}
+		if wait := time.Until(job.RunAfter.Time); wait < options.RetryBackoff-time.Second || wait > options.RetryBackoff {
+			t.Errorf("got retry in %s, want %s", wait, options.RetryBackoff)
+		}
+		if _, err := application_service.claim_job(); err == nil {
+			t.Errorf("got the job claimed during its backoff, want it held")
+		}
+
+		application_repository.jobs[0].job.RunAfter.Time = time.Now()
+		deployment = run_next_job(t, application_service)
+
+		job = application_repository.jobs[0].job
+		if deployment.Status != DeploymentFailed || deployment.FailureReason.String != "no_ports_available" {
+			t.Errorf("got status %s (%s), want failed after the last attempt", deployment.Status, deployment.FailureReason.String)
+		}
+		if job.Status != JobFailed || job.Attempts != 2 {
+			t.Errorf("got job %s after %d attempts, want failed after 2", job.Status, job.Attempts)
+		}
+		if got := got_statuses(); got != "extracting,queued,extracting,failed" {
+			t.Errorf("got transitions %s, want a retry before failing", got)
+		}
+	})
+
+	t.Run("should take over jobs whose worker went away and requeue their deployment", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+		created := enqueue(t, "d1c0fd4b-5a2f-4f8e-9c0e-3a6f0f6b2c11")
+		defer application_service.supervisor.unwatch(created.AppDpID.String())
+
+		stale := application_repository.jobs[0]
+		stale.job.Status = JobRunning
+		stale.job.LockedBy = pgtype.Text{String: "gone", Valid: true}
+		stale.job.LockedAt = pgtype.Timestamp{Time: time.Now().Add(-2 * options.LockTimeout), Valid: true}
+		stale.deployment.Status = DeploymentBuilding
+
+		deployment := run_next_job(t, application_service)
+
+		if deployment.Status != DeploymentRunning {
+			t.Errorf("got status %s (%s), want running", deployment.Status, deployment.FailureReason.String)
+		}
+		if got := got_statuses(); got != "queued,extracting,building,starting,running" {
+			t.Errorf("got transitions %s, want the interrupted launch requeued first", got)
+		}
+		if job := application_repository.jobs[0].job; job.Status != JobSucceeded || job.LockedBy.String != application_service.jobs.worker_id {
+			t.Errorf("got job %s locked by %s, want succeeded under this worker", job.Status, job.LockedBy.String)
+		}
+	})
+
+	t.Run("should hold jobs of an application that is already launching", func (t *testing.T) {
+		defer application_repository.Clear()
+		enqueue(t, "d1c0fd4b-5a2f-4f8e-9c0e-3a6f0f6b2c11")
+		enqueue(t, "0b6c7f0e-8a43-4d2a-9a55-6f2f1c3e9d01")
+
+		if _, err := application_service.claim_job(); err != nil {
+			t.Fatalf("got error %v, want the first job claimed", err)
+		}
+		if _, err := application_service.claim_job(); err == nil || !strings.Contains(err.Error(), "no rows") {
+			t.Errorf("got error %v, want the second job held", err)
+		}
+	})
+
+	t.Run("should cancel queued deployments before they launch", func (t *testing.T) {
+		defer application_repository.Clear()
+		created := enqueue(t, "d1c0fd4b-5a2f-4f8e-9c0e-3a6f0f6b2c11")
+
+		deployment, err := application_service.CancelDeployment(app_id, created.AppDpID.String(), user_id)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		if deployment.Status != DeploymentCancelled || application_repository.jobs[0].job.Status != JobCancelled {
+			t.Errorf("got deployment %s and job %s, want both cancelled", deployment.Status, application_repository.jobs[0].job.Status)
+		}
+		if _, err := application_service.claim_job(); err == nil {
+			t.Errorf("got the cancelled job claimed, want nothing to claim")
+		}
+	})
+
+	t.Run("should stop launches in progress when cancelled", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+		created := enqueue(t, "d1c0fd4b-5a2f-4f8e-9c0e-3a6f0f6b2c11")
+		deployment_runtime.prepare_blocked = make(chan struct{})
+
+		job, err := application_service.claim_job()
+		if err != nil {
+			t.Fatalf("got error claiming %v, want nil", err)
+		}
+		done := make(chan *database.ApplicationDeployment)
+		go func() {
+			done <- application_service.run_job(context.Background(), job)
+		}()
+		<-deployment_runtime.prepare_blocked
+
+		if _, err := application_service.CancelDeployment(app_id, created.AppDpID.String(), user_id); err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		deployment := <-done
+		if deployment.Status != DeploymentCancelled {
+			t.Errorf("got status %s (%s), want cancelled", deployment.Status, deployment.FailureReason.String)
+		}
+		if job := application_repository.jobs[0].job; job.Status != JobCancelled {
+			t.Errorf("got job %s, want cancelled", job.Status)
+		}
+		if deployment_runtime.start_n_calls != 0 {
+			t.Errorf("got start called %d times, want 0", deployment_runtime.start_n_calls)
+		}
+	})
+
+	t.Run("should return error invalid_cancel_target for settled deployments", func (t *testing.T) {
+		defer application_repository.Clear()
+		created := enqueue(t, "d1c0fd4b-5a2f-4f8e-9c0e-3a6f0f6b2c11")
+		application_repository.jobs[0].job.Status = JobSucceeded
+
+		_, err := application_service.CancelDeployment(app_id, created.AppDpID.String(), user_id)
+		if err == nil || err.Error() != "invalid_cancel_target" {
+			t.Errorf("got error %v, want invalid_cancel_target", err)
+		}
+	})
+
+	t.Run("should double the backoff up to the maximum", func (t *testing.T) {
+		for attempts, want := range map[int32]time.Duration{
+			1: options.RetryBackoff,
+			2: 2 * options.RetryBackoff,
+			3: 4 * options.RetryBackoff,
+			10: options.MaxRetryBackoff,
+		} {
+			if got := application_service.jobs.backoff(attempts); got != want {
+				t.Errorf("got backoff %s after %d attempts, want %s", got, attempts, want)
+			}
+		}
+	})
+}
+
+// TestClaimDeploymentJobCaps runs against the database in TEST_POSTGRES_URI,
+// migrated with the goose schema.
+func TestClaimDeploymentJobCaps(t *testing.T) {
+	postgres_uri := os.Getenv("TEST_POSTGRES_URI")
+	if postgres_uri == "" {
+		t.Skip("TEST_POSTGRES_URI is not set")
+	}
+
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, postgres_uri)
+	if err != nil {
+		t.Fatalf("unable to connect: %v", err)
+	}
+	t.Cleanup(pool.Close)
+
+	application_repository := NewRepository(ctx, pool, database.New(pool))
+
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 10)
+	var org_id, project_id string
+	if err := pool.QueryRow(ctx, `INSERT INTO organizations (name) VALUES ($1) RETURNING org_id::text`, "claims-"+suffix).Scan(&org_id); err != nil {
+		t.Fatalf("unable to insert organization: %v", err)
+	}
+	if err := pool.QueryRow(ctx, `INSERT INTO projects (org_id, name, slug) VALUES ($1, $2, $2) RETURNING project_id::text`, org_id, "claims-"+suffix).Scan(&project_id); err != nil {
+		t.Fatalf("unable to insert project: %v", err)
+	}
+	t.Cleanup(func() {
+		pool.Exec(ctx, `DELETE FROM application_deployments WHERE app_id IN (SELECT app_id FROM applications WHERE project_id = $1)`, project_id)
+		pool.Exec(ctx, `DELETE FROM applications WHERE project_id = $1`, project_id)
+		pool.Exec(ctx, `DELETE FROM projects WHERE project_id = $1`, project_id)
+		pool.Exec(ctx, `DELETE FROM organizations WHERE org_id = $1`, org_id)
+	})
+
+	// enqueue queues one job per application in apps, the same application
+	// may be listed more than once
+	enqueue := func(t *testing.T, apps []string) {
+		for _, app_id := range apps {
+			_, err := pool.Exec(ctx, `
+				WITH dp AS (
+					INSERT INTO application_deployments (app_id, artifacts_path) VALUES ($1, '') RETURNING app_dp_id
+				)
+				INSERT INTO application_deployment_jobs (app_dp_id, app_id, org_id) SELECT app_dp_id, $1, $2 FROM dp`,
+				app_id, org_id,
+			)
+			if err != nil {
+				t.Fatalf("unable to enqueue job: %v", err)
+			}
+		}
+	}
+
+	create_app := func(t *testing.T) string {
+		var app_id string
+		if err := pool.QueryRow(ctx, `INSERT INTO applications (project_id, type, name, slug) VALUES ($1, 'web_app_container', 'claims', 'claims-' || left(md5(random()::text), 8)) RETURNING app_id::text`, project_id).Scan(&app_id); err != nil {
+			t.Fatalf("unable to insert application: %v", err)
+		}
+
+		return app_id
+	}
+
+	// claim_parallel runs claimers at once and returns how many got a job
+	claim_parallel := func(t *testing.T, claimers int, org_concurrency int32, app_concurrency int32) int {
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		start := make(chan struct{})
+		claimed := 0
+
+		for i := range claimers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+
+				_, err := application_repository.ClaimDeploymentJob(database.ClaimApplicationDeploymentJobParams{
+					LockedBy: "worker-" + strconv.Itoa(i),
+					LockTimeoutSeconds: 60,
+					OrgConcurrency: org_concurrency,
+					AppConcurrency: app_concurrency,
+				})
+				if err != nil {
+					if err.Error() != "no rows in result set" {
+						t.Errorf("unable to claim job: %v", err)
+					}
+					return
+				}
+
+				mu.Lock()
+				claimed++
+				mu.Unlock()
+			}()
+		}
+
+		close(start)
+		wg.Wait()
+
+		return claimed
+	}
+
+	reset := func(t *testing.T) {
+		if _, err := pool.Exec(ctx, `DELETE FROM application_deployment_jobs WHERE org_id = $1`, org_id); err != nil {
+			t.Fatalf("unable to reset jobs: %v", err)
+		}
+	}
+
+	t.Run("should claim one job of an application with a cap of 1 across parallel claimers", func (t *testing.T) {
+		app_id := create_app(t)
+		t.Cleanup(func() { reset(t) })
+
+		enqueue(t, []string{app_id, app_id, app_id, app_id, app_id, app_id, app_id, app_id})
+
+		if claimed := claim_parallel(t, 8, 10, 1); claimed != 1 {
+			t.Errorf("got %d jobs claimed, want 1", claimed)
+		}
+	})
+
+	t.Run("should claim one job of an organization with a cap of 1 across parallel claimers", func (t *testing.T) {
+		apps := []string{}
+		for range 8 {
+			apps = append(apps, create_app(t))
+		}
+		t.Cleanup(func() { reset(t) })
+
+		enqueue(t, apps)
+
+		if claimed := claim_parallel(t, 8, 1, 10); claimed != 1 {
+			t.Errorf("got %d jobs claimed, want 1", claimed)
+		}
+	})
+}
+
+func TestReconciler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	application_repository := &StubApplicationRepository{}
+	project_service := tests.StubProjectService{}
+	deployment_runtime := &StubRuntime{}
+
+	application_service := NewService(
+		ctx,
+		&pgxpool.Pool{},
+		application_repository,
+		&project_service,
+		t.TempDir(),
+		new_test_artifact_store(t),
+		deployment_runtime,
+		DefaultPortRange(),
+		runtime.ExtractLimits{},
+		DefaultQueueOptions(),
+	).(*service)
+
+	app_uuid := pgtype.UUID{}
+	app_uuid.Scan("a7e4e583-471c-4b51-bcdd-7fb57291c5cb")
+	dp_uuid := pgtype.UUID{}
+	dp_uuid.Scan("d1c0fd4b-5a2f-4f8e-9c0e-3a6f0f6b2c11")
+	other_uuid := pgtype.UUID{}
+	other_uuid.Scan("0b5f3e0c-7a43-4d0f-8f34-5b2d6b0c9e21")
+
+	created_at := time.Now().Add(-time.Hour)
+	new_row := func(dp_id pgtype.UUID, status string, desired_state string) database.FindApplicationDeploymentsWithDesiredStateRow {
+		return database.FindApplicationDeploymentsWithDesiredStateRow{
+			ApplicationDeployment: database.ApplicationDeployment{
+				AppDpID: dp_id,
+				AppID: app_uuid,
+				Status: status,
+				CreatedAt: pgtype.Timestamp{Time: created_at, Valid: true},
+			},
+			AppDesiredState: desired_state,
+		}
+	}
+	event_kinds := func() []string {
+		kinds := []string{}
+		for _, event := range application_repository.create_event_call_args {
+			kinds = append(kinds, event.Kind)
+		}
+		return kinds
+	}
+
+	t.Run("should start unsupervised deployments whose process is gone", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+		defer application_service.supervisor.unwatch(dp_uuid.String())
+
+		application_repository.find_deployments_with_desired_state_return = []database.FindApplicationDeploymentsWithDesiredStateRow{
+			new_row(dp_uuid, DeploymentRunning, DesiredStateRunning),
+		}
+		deployment_runtime.status_return = &runtime.Status{State: runtime.StateNotFound}
+		deployment_runtime.start_return = &runtime.Instance{ProcessName: "node server.js [pid 9]"}
+
+		if err := application_service.reconcile(); err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		if deployment_runtime.start_n_calls != 1 || deployment_runtime.start_call_args[0].DeploymentID != dp_uuid.String() {
+			t.Errorf("got start called with %+v, want the deployment started", deployment_runtime.start_call_args)
+		}
+		// whatever the previous run of the server left still holds the port
+		if deployment_runtime.stop_n_calls != 1 || deployment_runtime.stop_call_args[0] != dp_uuid.String() {
+			t.Errorf("got stop called with %v, want leftovers of the deployment killed before starting it", deployment_runtime.stop_call_args)
+		}
+		if got := application_repository.upsert_deployment_process_call_args; len(got) != 1 || got[0].ProcessName != "node server.js [pid 9]" {
+			t.Errorf("got runtime updates %+v, want the new process recorded", got)
+		}
+		if kinds := event_kinds(); !slices.Equal(kinds, []string{EventReconcileRestarted}) {
+			t.Errorf("got events %v, want %s", kinds, EventReconcileRestarted)
+		}
+		if !application_service.supervisor.watching(dp_uuid.String()) {
+			t.Errorf("got the deployment unsupervised, want it watched")
+		}
+	})
+
+	t.Run("should supervise running deployments again without touching them", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+		defer application_service.supervisor.unwatch(dp_uuid.String())
+
+		application_repository.find_deployments_with_desired_state_return = []database.FindApplicationDeploymentsWithDesiredStateRow{
+			new_row(dp_uuid, DeploymentCrashLooping, DesiredStateRunning),
+		}
+		deployment_runtime.list_return = []string{dp_uuid.String()}
+
+		if err := application_service.reconcile(); err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		if deployment_runtime.start_n_calls != 0 || deployment_runtime.stop_n_calls != 0 {
+			t.Errorf("got start %d and stop %d calls, want none", deployment_runtime.start_n_calls, deployment_runtime.stop_n_calls)
+		}
+		if kinds := event_kinds(); len(kinds) != 0 {
+			t.Errorf("got events %v, want none", kinds)
+		}
+		if !application_service.supervisor.watching(dp_uuid.String()) {
+			t.Errorf("got the deployment unsupervised, want it watched")
+		}
+	})
+
+	t.Run("should leave supervised deployments and launches alone", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+		defer application_service.supervisor.unwatch(dp_uuid.String())
+
+		application_service.supervisor.watch(dp_uuid.String(), func(ctx context.Context) {})
+		application_repository.find_deployments_with_desired_state_return = []database.FindApplicationDeploymentsWithDesiredStateRow{
+			new_row(dp_uuid, DeploymentRunning, DesiredStateRunning),
+			new_row(other_uuid, DeploymentBuilding, DesiredStateRunning),
+		}
+		deployment_runtime.status_return = &runtime.Status{State: runtime.StateExited}
+		deployment_runtime.list_return = []string{other_uuid.String()}
+
+		if err := application_service.reconcile(); err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		if deployment_runtime.start_n_calls != 0 || deployment_runtime.stop_n_calls != 0 {
+			t.Errorf("got start %d and stop %d calls, want none", deployment_runtime.start_n_calls, deployment_runtime.stop_n_calls)
+		}
+	})
+
+	t.Run("should stop deployments of applications stopped after they were made", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+		defer application_service.supervisor.unwatch(other_uuid.String())
+
+		stopped := new_row(dp_uuid, DeploymentRunning, DesiredStateStopped)
+		stopped.AppDesiredStateUpdatedAt = pgtype.Timestamp{Time: created_at.Add(time.Minute), Valid: true}
+		// deployed on purpose while the app was stopped
+		deployed := new_row(other_uuid, DeploymentRunning, DesiredStateStopped)
+		deployed.ApplicationDeployment.CreatedAt.Time = created_at.Add(2 * time.Minute)
+		deployed.AppDesiredStateUpdatedAt = stopped.AppDesiredStateUpdatedAt
+		application_repository.find_deployments_with_desired_state_return = []database.FindApplicationDeploymentsWithDesiredStateRow{deployed, stopped}
+
+		if err := application_service.reconcile(); err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		if deployment_runtime.stop_n_calls != 1 || deployment_runtime.stop_call_args[0] != dp_uuid.String() {
+			t.Errorf("got stop called with %v, want only the older deployment stopped", deployment_runtime.stop_call_args)
+		}
+		transitions := application_repository.transition_deployment_call_args
+		if len(transitions) != 1 || transitions[0].AppDpID != dp_uuid || transitions[0].ToStatus != DeploymentStopped {
+			t.Errorf("got transitions %+v, want the older deployment stopped", transitions)
+		}
+		if kinds := event_kinds(); !slices.Equal(kinds, []string{EventReconcileStopped}) {
+			t.Errorf("got events %v, want %s", kinds, EventReconcileStopped)
+		}
+	})
+
+	t.Run("should supersede deployments left serving by an interrupted switch", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+		defer application_service.supervisor.unwatch(other_uuid.String())
+
+		// rows come newest first
+		application_repository.find_deployments_with_desired_state_return = []database.FindApplicationDeploymentsWithDesiredStateRow{
+			new_row(other_uuid, DeploymentRunning, DesiredStateRunning),
+			new_row(dp_uuid, DeploymentRunning, DesiredStateRunning),
+		}
+
+		if err := application_service.reconcile(); err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		if deployment_runtime.stop_n_calls != 1 || deployment_runtime.stop_call_args[0] != dp_uuid.String() {
+			t.Errorf("got stop called with %v, want only the older deployment stopped", deployment_runtime.stop_call_args)
+		}
+		transitions := application_repository.transition_deployment_call_args
+		if len(transitions) != 1 || transitions[0].AppDpID != dp_uuid || transitions[0].ToStatus != DeploymentSuperseded {
+			t.Errorf("got transitions %+v, want the older deployment superseded", transitions)
+		}
+		if !application_service.supervisor.watching(other_uuid.String()) {
+			t.Errorf("got the newer deployment unwatched, want it supervised")
+		}
+	})
+
+	t.Run("should leave the stable deployment serving next to its canary", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+		defer application_service.supervisor.unwatch(dp_uuid.String())
+		defer application_service.supervisor.unwatch(other_uuid.String())
+
+		canary := new_row(other_uuid, DeploymentRunning, DesiredStateRunning)
+		canary.ApplicationDeployment.CanaryWeight = pgtype.Int4{Int32: 10, Valid: true}
+		application_repository.find_deployments_with_desired_state_return = []database.FindApplicationDeploymentsWithDesiredStateRow{
+			canary,
+			new_row(dp_uuid, DeploymentRunning, DesiredStateRunning),
+		}
+
+		if err := application_service.reconcile(); err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		if deployment_runtime.stop_n_calls != 0 || len(application_repository.transition_deployment_call_args) != 0 {
+			t.Errorf("got stop called with %v, want both deployments kept", deployment_runtime.stop_call_args)
+		}
+		if !application_service.supervisor.watching(dp_uuid.String()) || !application_service.supervisor.watching(other_uuid.String()) {
+			t.Errorf("got a deployment unwatched, want both supervised")
+		}
+	})
+
+	t.Run("should kill processes of deployments that aren't active", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+
+		application_repository.find_deployments_with_desired_state_return = []database.FindApplicationDeploymentsWithDesiredStateRow{
+			new_row(other_uuid, DeploymentStarting, DesiredStateRunning),
+		}
+		application_repository.find_one_deployment_by_id_return = &database.ApplicationDeployment{AppDpID: dp_uuid, AppID: app_uuid, Status: DeploymentSuperseded}
+		deployment_runtime.list_return = []string{dp_uuid.String(), other_uuid.String()}
+
+		if err := application_service.reconcile(); err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		if !slices.Equal(deployment_runtime.stop_call_args, []string{dp_uuid.String()}) {
+			t.Errorf("got stop called with %v, want only the superseded deployment", deployment_runtime.stop_call_args)
+		}
+		events := application_repository.create_event_call_args
+		if len(events) != 1 || events[0].Kind != EventReconcileKilled || events[0].AppDpID != dp_uuid || !strings.Contains(events[0].Message.String, DeploymentSuperseded) {
+			t.Errorf("got events %+v, want the kill recorded on the deployment", events)
+		}
+	})
+
+	t.Run("should fail deployments that can't be started again", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+
+		application_repository.find_deployments_with_desired_state_return = []database.FindApplicationDeploymentsWithDesiredStateRow{
+			new_row(dp_uuid, DeploymentRunning, DesiredStateRunning),
+		}
+		deployment_runtime.status_return = &runtime.Status{State: runtime.StateNotFound}
+		deployment_runtime.start_error = errors.New("start_command_not_found")
+
+		if err := application_service.reconcile(); err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		transitions := application_repository.transition_deployment_call_args
+		if len(transitions) != 1 || transitions[0].ToStatus != DeploymentFailed || transitions[0].FailureReason.String != "start_command_not_found" {
+			t.Errorf("got transitions %+v, want the deployment failed with the start error", transitions)
+		}
+		if application_service.supervisor.watching(dp_uuid.String()) {
+			t.Errorf("got the failed deployment watched, want it unsupervised")
+		}
+	})
+
+	t.Run("should return runtime errors without acting", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+
+		deployment_runtime.list_error = errors.New("docker engine returned 500: boom")
+
+		if err := application_service.reconcile(); err == nil {
+			t.Errorf("got nil error, want the runtime error")
+		}
+	})
+}
+
+func TestPortAllocator(t *testing.T) {
+	ctx := context.Background()
+	pgxpool := &pgxpool.Pool{}
+	application_repository := &StubApplicationRepository{}
+	project_service := tests.StubProjectService{}
+	deployment_runtime := &StubRuntime{}
+
+	application_service := NewService(
+		ctx,
+		pgxpool,
+		application_repository,
+		&project_service,
+		t.TempDir(),
+		new_test_artifact_store(t),
+		deployment_runtime,
+		PortRange{Start: 20000, End: 20001},
+		runtime.ExtractLimits{},
+		DefaultQueueOptions(),
+	).(*service)
+
+	first_uuid := pgtype.UUID{}
+	first_uuid.Scan("d1c0fd4b-5a2f-4f8e-9c0e-3a6f0f6b2c11")
+	second_uuid := pgtype.UUID{}
+	second_uuid.Scan("0b6c7f0e-8a43-4d2a-9a55-6f2f1c3e9d01")
+	third_uuid := pgtype.UUID{}
+	third_uuid.Scan("5e2d9c4a-1f3b-4c6e-8d7a-2b9e0f1a3c55")
+
+	t.Run("should lease free ports and keep them across starts", func (t *testing.T) {
+		defer application_repository.Clear()
+
+		first, _ := application_service.lease_port(first_uuid, 0)
+		second, _ := application_service.lease_port(second_uuid, 0)
+		again, _ := application_service.lease_port(first_uuid, 0)
+
+		if first != 20000 || second != 20001 || again != 20000 {
+			t.Errorf("got ports %d, %d, %d, want 20000, 20001, 20000", first, second, again)
+		}
+		if n := len(application_repository.lease_deployment_port_call_args); n != 2 {
+			t.Errorf("got %d leases, want 2", n)
+		}
+
+		if _, err := application_service.lease_port(third_uuid, 0); err == nil || err.Error() != "no_ports_available" {
+			t.Errorf("got error %v, want no_ports_available", err)
+		}
+	})
+
+	t.Run("should release the port once the deployment is stopped or superseded", func (t *testing.T) {
+		defer application_repository.Clear()
+
+		for _, to := range []string{DeploymentStopped, DeploymentSuperseded} {
+			application_service.lease_port(first_uuid, 0)
+
+			_, err := application_service.transition_deployment(
+				&database.ApplicationDeployment{AppDpID: first_uuid, Status: DeploymentRunning},
+				to,
+				"",
+			)
+			if err != nil {
+				t.Fatalf("got error %v, want nil", err)
+			}
+
+			if _, ok := application_repository.deployment_ports[stub_port_lease{app_dp_id: first_uuid}]; ok {
+				t.Errorf("got port still leased after %s, want it released", to)
+			}
+		}
+	})
+
+	t.Run("should keep the port while the deployment keeps serving", func (t *testing.T) {
+		defer application_repository.Clear()
+
+		application_service.lease_port(first_uuid, 0)
+		application_service.transition_deployment(
+			&database.ApplicationDeployment{AppDpID: first_uuid, Status: DeploymentRunning},
+			DeploymentCrashLooping,
+			"",
+		)
+
+		if len(application_repository.release_deployment_port_call_args) != 0 {
+			t.Errorf("got port released for a crash looping deployment, want it kept")
+		}
+	})
+}
+
+func TestIngressRoutes(t *testing.T) {
+	ctx := context.Background()
+	pgxpool := &pgxpool.Pool{}
+	application_repository := &StubApplicationRepository{}
+	project_service := tests.StubProjectService{}
+
+	application_service := NewService(
+		ctx,
+		pgxpool,
+		application_repository,
+		&project_service,
+		t.TempDir(),
+		new_test_artifact_store(t),
+		&StubRuntime{},
+		DefaultPortRange(),
+		runtime.ExtractLimits{},
+		DefaultQueueOptions(),
+	)
+
+	new_app_uuid := func(name string) pgtype.UUID {
+		ids := map[string]string{
+			"web": "a7e4e583-471c-4b51-bcdd-7fb57291c5cb",
+			"worker": "0b7d1f2e-3c4a-4e5b-8f6a-9d8c7b6a5e4f",
+			"api": "5f0c4a3e-8d9b-4c1a-9e2f-7b6a5d4c3b2a",
+		}
+		app_uuid := pgtype.UUID{}
+		app_uuid.Scan(ids[name])
+		return app_uuid
+	}
+	new_dp_uuid := func(id string) pgtype.UUID {
+		dp_uuid := pgtype.UUID{}
+		dp_uuid.Scan(id)
+		return dp_uuid
+	}
+
+	t.Run("should route serving deployments by their leased port only", func (t *testing.T) {
+		defer application_repository.Clear()
+
+		web, worker, api := new_app_uuid("web"), new_app_uuid("worker"), new_app_uuid("api")
+		application_repository.find_ingress_routes_return = []database.FindIngressRoutesRow{
+			// without a lease there is no port of ours to route to
+			{AppID: web, AppName: "web", ProjectName: "shop"},
+			{AppID: worker, AppName: "worker", ProjectName: "shop"},
+			{AppID: api, AppName: "api", ProjectName: "shop", LeasedPort: pgtype.Int4{Int32: 20004, Valid: true}},
+			// workers and cron jobs don't take requests, whatever they leased
+			{AppID: new_dp_uuid("f5fc849b-35c8-4dfb-a3d6-7af65e737e84"), AppName: "report", ProjectName: "shop", AppType: dto.AppTypeCronJob, LeasedPort: pgtype.Int4{Int32: 20005, Valid: true}},
+			{AppID: new_dp_uuid("c3d2e1f0-9a8b-4c7d-8e6f-5a4b3c2d1e0f"), AppName: "mailer", ProjectName: "shop", AppType: dto.AppTypeBackgroundWorker, LeasedPort: pgtype.Int4{Int32: 20006, Valid: true}},
+		}
+
+		routes, err := application_service.FindIngressRoutes()
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		if len(routes) != 1 || routes[0].AppName != "api" || routes[0].Port != "20004" {
+			t.Errorf("got routes %+v, want only api on its leased port", routes)
+		}
+		statuses := application_repository.find_ingress_routes_call_args[0]
+		if !slices.Equal(statuses, GetServingDeploymentStatuses()) {
+			t.Errorf("got statuses %v, want the serving ones", statuses)
+		}
+	})
+
+	t.Run("should route to the newest stable deployment and split off its canary", func (t *testing.T) {
+		defer application_repository.Clear()
+
+		web, api := new_app_uuid("web"), new_app_uuid("api")
+		canary, stable, older := new_dp_uuid("d1c0fd4b-5a2f-4f8e-9c0e-3a6f0f6b2c11"), new_dp_uuid("0b7d1f2e-3c4a-4e5b-8f6a-9d8c7b6a5e4f"), new_dp_uuid("5f0c4a3e-8d9b-4c1a-9e2f-7b6a5d4c3b2a")
+		api_canary := new_dp_uuid("f5fc849b-35c8-4dfb-a3d6-7af65e737e84")
+		application_repository.find_ingress_routes_return = []database.FindIngressRoutesRow{
+			{AppID: web, AppName: "web", ProjectName: "shop", AppDpID: canary, CanaryWeight: pgtype.Int4{Int32: 10, Valid: true}, CanarySticky: true, LeasedPort: pgtype.Int4{Int32: 20002, Valid: true}},
+			{AppID: web, AppName: "web", ProjectName: "shop", AppDpID: stable, LeasedPort: pgtype.Int4{Int32: 20001, Valid: true}},
+			{AppID: web, AppName: "web", ProjectName: "shop", AppDpID: older, LeasedPort: pgtype.Int4{Int32: 20000, Valid: true}},
+			{AppID: api, AppName: "api", ProjectName: "shop", AppDpID: api_canary, CanaryWeight: pgtype.Int4{Int32: 10, Valid: true}, LeasedPort: pgtype.Int4{Int32: 20003, Valid: true}},
+		}
+
+		routes, err := application_service.FindIngressRoutes()
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if len(routes) != 2 {
+			t.Fatalf("got routes %+v, want one per application", routes)
+		}
+
+		if routes[0].DeploymentID != stable.String() || routes[0].Port != "20001" {
+			t.Errorf("got route %+v, want the newest stable deployment", routes[0])
+		}
+		want := dto.IngressCanary{DeploymentID: canary.String(), Port: "20002", Ports: []string{"20002"}, Weight: 10, Sticky: true}
+		if routes[0].Canary == nil || !reflect.DeepEqual(*routes[0].Canary, want) {
+			t.Errorf("got canary %+v, want %+v", routes[0].Canary, want)
+		}
+		if routes[1].DeploymentID != api_canary.String() || routes[1].Canary != nil {
+			t.Errorf("got route %+v, want the canary serving alone without a stable deployment", routes[1])
+		}
+	})
+
+	t.Run("should route to every running web instance of a scaled deployment", func (t *testing.T) {
+		defer application_repository.Clear()
+
+		dp_uuid := new_dp_uuid("0b7d1f2e-3c4a-4e5b-8f6a-9d8c7b6a5e4f")
+		application_repository.find_ingress_routes_return = []database.FindIngressRoutesRow{
+			{AppID: new_app_uuid("web"), AppName: "web", ProjectName: "shop", AppDpID: dp_uuid, LeasedPort: pgtype.Int4{Int32: 20001, Valid: true}, WebInstancePorts: []int32{20005, 20006}},
+		}
+
+		routes, err := application_service.FindIngressRoutes()
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		want := []string{"20001", "20005", "20006"}
+		if len(routes) != 1 || routes[0].Port != "20001" || !slices.Equal(routes[0].Ports, want) {
+			t.Errorf("got routes %+v, want ports %v", routes, want)
+		}
+	})
+
+	t.Run("should notify watchers when a deployment changes status", func (t *testing.T) {
+		defer application_repository.Clear()
+
+		changes, unwatch := application_service.WatchDeploymentChanges()
+		defer unwatch()
+
+		dp_uuid := pgtype.UUID{}
+		dp_uuid.Scan("d1c0fd4b-5a2f-4f8e-9c0e-3a6f0f6b2c11")
+		application_repository.find_deployments_by_status_return = []database.ApplicationDeployment{
+			{AppDpID: dp_uuid, Status: DeploymentRunning},
+		}
+
+		mock_app_with_pm := &database.FindOneApplicationWithProjectMemberRow{}
+		mock_app_with_pm.AppID.Scan("a7e4e583-471c-4b51-bcdd-7fb57291c5cb")
+		mock_app_with_pm.PmProjectID.Valid = true
+		application_repository.find_one_with_project_member_return = mock_app_with_pm
+
+		if _, err := application_service.Stop("a7e4e583-471c-4b51-bcdd-7fb57291c5cb", "3ad11d5d-5a7e-433d-ac51-fba7a645f3d4"); err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		select {
+		case <-changes:
+		default:
+			t.Errorf("got no change notification, want one after stopping")
+		}
+	})
+}
+
+func TestBackgroundWorkers(t *testing.T) {
+	application_repository := &StubApplicationRepository{}
+	deployment_runtime := &StubRuntime{}
+
+	application_service := NewService(
+		context.Background(),
+		&pgxpool.Pool{},
+		application_repository,
+		&tests.StubProjectService{},
+		t.TempDir(),
+		new_test_artifact_store(t),
+		deployment_runtime,
+		DefaultPortRange(),
+		runtime.ExtractLimits{},
+		DefaultQueueOptions(),
+	).(*service)
+
+	app_id := "a7e4e583-471c-4b51-bcdd-7fb57291c5cb"
+	user_id := "3ad11d5d-5a7e-433d-ac51-fba7a645f3d4"
+	app_uuid := pgtype.UUID{}
+	app_uuid.Scan(app_id)
+	dp_uuid := pgtype.UUID{}
+	dp_uuid.Scan("d1c0fd4b-5a2f-4f8e-9c0e-3a6f0f6b2c11")
+
+	worker := &database.Application{
+		AppID: app_uuid,
+		Type: dto.AppTypeBackgroundWorker,
+		HealthCheckType: runtime.ProbeHTTP,
+		HealthCheckPath: "/healthz",
+	}
+	deployment := &database.ApplicationDeployment{
+		AppDpID: dp_uuid,
+		AppID: app_uuid,
+		Status: DeploymentRunning,
+		VariablesSnapshotJson: []byte(`{"PORT": 3000, "QUEUE": "emails"}`),
+	}
+	member_worker := func() *database.FindOneApplicationWithProjectMemberRow {
+		return &database.FindOneApplicationWithProjectMemberRow{
+			AppID: app_uuid,
+			Type: dto.AppTypeBackgroundWorker,
+			PmProjectID: pgtype.UUID{Valid: true},
+		}
+	}
+
+	t.Run("should run workers without leasing a port", func (t *testing.T) {
+		defer application_repository.Clear()
+
+		application_repository.find_one_return = worker
+
+		spec, err := application_service.runtime_spec(deployment)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		if len(application_repository.lease_deployment_port_call_args) != 0 {
+			t.Errorf("got ports leased %+v, want none", application_repository.lease_deployment_port_call_args)
+		}
+		if spec.Variables["QUEUE"] != "emails" || spec.Variables["PORT"] != "3000" {
+			t.Errorf("got variables %v, want the config ones untouched", spec.Variables)
+		}
+	})
+
+	t.Run("should only watch the process of workers", func (t *testing.T) {
+		defer application_repository.Clear()
+
+		application_repository.find_one_return = worker
+
+		w, err := application_service.new_deployment_watch(deployment)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if w.probe != nil {
+			t.Errorf("got probe %+v, want workers without one", w.probe)
+		}
+	})
+
+	t.Run("should only take the none health check for workers", func (t *testing.T) {
+		defer application_repository.Clear()
+
+		application_repository.find_one_with_project_member_return = member_worker()
+		application_repository.update_health_check_return = worker
+		health_check := dto.UpdateApplicationHealthCheckDto{Type: runtime.ProbeHTTP, Path: "/", IntervalSeconds: 10, TimeoutSeconds: 2, FailureThreshold: 3}
+
+		if _, err := application_service.UpdateHealthCheck(app_id, user_id, health_check); err == nil || err.Error() != "invalid_app_type" {
+			t.Fatalf("got error %v, want invalid_app_type", err)
+		}
+		if len(application_repository.update_health_check_call_args) != 0 {
+			t.Errorf("got the health check stored, want it rejected")
+		}
+
+		health_check.Type = runtime.ProbeNone
+		if _, err := application_service.UpdateHealthCheck(app_id, user_id, health_check); err != nil {
+			t.Errorf("got error %v, want nil", err)
+		}
+	})
+
+	t.Run("should not release workers as canaries", func (t *testing.T) {
+		defer application_repository.Clear()
+
+		application_repository.find_one_with_project_member_return = member_worker()
+		weight := int32(10)
+
+		_, err := application_service.CreateDeployment(app_id, user_id, dto.CreateApplicationDeploymentDto{CanaryWeight: &weight})
+		if err == nil || err.Error() != "invalid_app_type" {
+			t.Fatalf("got error %v, want invalid_app_type", err)
+		}
+		if application_repository.create_deployment_n_calls != 0 {
+			t.Errorf("got a deployment created, want none")
+		}
+	})
+}
+
+func TestScheduler(t *testing.T) {
+	application_repository := &StubApplicationRepository{}
+	deployment_runtime := &StubRuntime{}
+
+	application_service := NewService(
+		context.Background(),
+		&pgxpool.Pool{},
+		application_repository,
+		&tests.StubProjectService{},
+		t.TempDir(),
+		new_test_artifact_store(t),
+		deployment_runtime,
+		DefaultPortRange(),
+		runtime.ExtractLimits{},
+		DefaultQueueOptions(),
+	).(*service)
+	application_service.supervision.run_poll_interval = time.Millisecond
+
+	app_uuid := pgtype.UUID{}
+	app_uuid.Scan("a7e4e583-471c-4b51-bcdd-7fb57291c5cb")
+	dp_uuid := pgtype.UUID{}
+	dp_uuid.Scan("d1c0fd4b-5a2f-4f8e-9c0e-3a6f0f6b2c11")
+	old_uuid := pgtype.UUID{}
+	old_uuid.Scan("0b5f3e0c-7a43-4d0f-8f34-5b2d6b0c9e21")
+
+	now := time.Date(2026, 10, 17, 10, 15, 20, 0, time.UTC)
+	scheduled_at := pgtype.Timestamp{Time: time.Date(2026, 10, 17, 10, 15, 0, 0, time.UTC), Valid: true}
+	scheduled_app := database.Application{
+		AppID: app_uuid,
+		Type: dto.AppTypeCronJob,
+		DesiredState: DesiredStateRunning,
+		CronExpression: pgtype.Text{String: "*/15 * * * *", Valid: true},
+		CronCommand: pgtype.Text{String: "node report.js", Valid: true},
+		CronNextRunAt: scheduled_at,
+	}
+	setup := func() {
+		application_repository.find_due_schedules_return = []database.Application{scheduled_app}
+		claimed := scheduled_app
+		application_repository.claim_schedule_run_return = &claimed
+		application_repository.find_deployments_by_status_return = []database.ApplicationDeployment{
+			{AppDpID: dp_uuid, AppID: app_uuid, Status: DeploymentRunning, VariablesSnapshotJson: []byte(`{"NAME": "capy"}`)},
+		}
+	}
+	schedule_runs := func() {
+		t.Helper()
+
+		if err := application_service.schedule_runs(context.Background(), now); err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		application_service.runs.wg.Wait()
+	}
+
+	t.Run("should only schedule cron job applications", func (t *testing.T) {
+		defer application_repository.Clear()
+
+		application_repository.find_one_with_project_member_return = &database.FindOneApplicationWithProjectMemberRow{
+			AppID: app_uuid,
+			Type: dto.AppTypeWebAppContainer,
+			PmProjectID: pgtype.UUID{Valid: true},
+		}
+		schedule := dto.UpdateApplicationScheduleDto{CronExpression: "@hourly", Command: "node report.js"}
+
+		if _, err := application_service.UpdateSchedule(app_uuid.String(), "user", schedule); err == nil || err.Error() != "invalid_app_type" {
+			t.Fatalf("got error %v, want invalid_app_type", err)
+		}
+
+		application_repository.find_one_with_project_member_return.Type = dto.AppTypeCronJob
+		application_repository.update_schedule_return = &scheduled_app
+
+		if _, err := application_service.UpdateSchedule(app_uuid.String(), "user", schedule); err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		params := application_repository.update_schedule_call_args
+		if len(params) != 1 || params[0].CronExpression.String != "@hourly" || params[0].CronCommand.String != "node report.js" {
+			t.Fatalf("got schedule %+v, want the expression and command stored", params)
+		}
+		next_run := params[0].CronNextRunAt.Time
+		if next_run.Minute() != 0 || !next_run.After(time.Now()) || next_run.After(time.Now().Add(time.Hour)) {
+			t.Errorf("got next run at %s, want the next full hour", next_run)
+		}
+	})
+
+	t.Run("should run the command on the build of the newest running deployment once due", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+
+		setup()
+		deployment_runtime.start_log_lines = []runtime.LogLine{{Phase: runtime.LogPhaseRun, Stream: "stdout", Line: "sent 3 reports"}}
+		deployment_runtime.status_return = &runtime.Status{State: runtime.StateExited, ExitCode: 0}
+
+		schedule_runs()
+
+		claims := application_repository.claim_schedule_run_call_args
+		if len(claims) != 1 || claims[0].ScheduledAt != scheduled_at {
+			t.Fatalf("got claims %+v, want the scheduled time claimed", claims)
+		}
+		if want := time.Date(2026, 10, 17, 10, 30, 0, 0, time.UTC); !claims[0].NextRunAt.Time.Equal(want) {
+			t.Errorf("got next run at %s, want %s", claims[0].NextRunAt.Time, want)
+		}
+
+		if deployment_runtime.start_n_calls != 1 {
+			t.Fatalf("got %d starts, want 1", deployment_runtime.start_n_calls)
+		}
+		spec := deployment_runtime.start_call_args[0]
+		if spec.BuildID != dp_uuid.String() || spec.DeploymentID == dp_uuid.String() {
+			t.Errorf("got run %s on build %s, want a run of its own on %s", spec.DeploymentID, spec.BuildID, dp_uuid.String())
+		}
+		if spec.Command != "node report.js" || spec.Variables["NAME"] != "capy" {
+			t.Errorf("got command %q with variables %v, want the scheduled command with the deployment's", spec.Command, spec.Variables)
+		}
+		if _, ok := spec.Variables["PORT"]; ok {
+			t.Errorf("got PORT %s, want runs without a port", spec.Variables["PORT"])
+		}
+
+		finished := application_repository.finish_job_run_call_args
+		if len(finished) != 1 || finished[0].Status != JobRunSucceeded || !finished[0].ExitCode.Valid || finished[0].ExitCode.Int32 != 0 {
+			t.Fatalf("got finished runs %+v, want one succeeded with exit code 0", finished)
+		}
+		if finished[0].AppRunID.String() != spec.DeploymentID {
+			t.Errorf("got run %s finished, want %s", finished[0].AppRunID.String(), spec.DeploymentID)
+		}
+		if !slices.Equal(deployment_runtime.stop_call_args, []string{spec.DeploymentID}) {
+			t.Errorf("got stop called with %v, want the exited run cleared", deployment_runtime.stop_call_args)
+		}
+		logs := application_repository.create_job_run_log_call_args
+		if len(logs) != 1 || logs[0].Line != "sent 3 reports" || logs[0].AppRunID.String() != spec.DeploymentID {
+			t.Errorf("got logs %+v, want the output stored on the run", logs)
+		}
+		if application_service.runs.tracking(spec.DeploymentID) {
+			t.Errorf("got the finished run tracked, want it forgotten")
+		}
+	})
+
+	t.Run("should fail runs exiting with an error", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+
+		setup()
+		deployment_runtime.status_return = &runtime.Status{State: runtime.StateExited, ExitCode: 2}
+
+		schedule_runs()
+
+		finished := application_repository.finish_job_run_call_args
+		if len(finished) != 1 || finished[0].Status != JobRunFailed || finished[0].ExitCode.Int32 != 2 {
+			t.Fatalf("got finished runs %+v, want one failed with exit code 2", finished)
+		}
+		if finished[0].FailureReason.String != "process exited with code 2" {
+			t.Errorf("got reason %q, want the exit code", finished[0].FailureReason.String)
+		}
+	})
+
+	t.Run("should skip runs while the previous one is still running", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+
+		setup()
+		application_repository.start_job_run_error = errors.New("no rows in result set")
+
+		schedule_runs()
+
+		if deployment_runtime.start_n_calls != 0 {
+			t.Errorf("got %d starts, want none", deployment_runtime.start_n_calls)
+		}
+		created := application_repository.create_job_run_call_args
+		if len(created) != 1 || created[0].Status != JobRunSkipped || created[0].ScheduledAt != scheduled_at {
+			t.Errorf("got runs %+v, want one skipped", created)
+		}
+	})
+
+	t.Run("should fail runs of applications without a running deployment", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+
+		setup()
+		application_repository.find_deployments_by_status_return = nil
+
+		schedule_runs()
+
+		if len(application_repository.start_job_run_call_args) != 0 || deployment_runtime.start_n_calls != 0 {
+			t.Errorf("got a run started, want none")
+		}
+		created := application_repository.create_job_run_call_args
+		if len(created) != 1 || created[0].Status != JobRunFailed || created[0].FailureReason.String != "no running deployment to run" {
+			t.Errorf("got runs %+v, want one failed", created)
+		}
+	})
+
+	t.Run("should not run schedules claimed elsewhere or of stopped applications", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+
+		setup()
+		application_repository.claim_schedule_run_error = errors.New("no rows in result set")
+
+		schedule_runs()
+
+		setup()
+		application_repository.claim_schedule_run_error = nil
+		application_repository.claim_schedule_run_return.DesiredState = DesiredStateStopped
+
+		schedule_runs()
+
+		if len(application_repository.claim_schedule_run_call_args) != 2 {
+			t.Errorf("got %d claims, want 2", len(application_repository.claim_schedule_run_call_args))
+		}
+		if len(application_repository.start_job_run_call_args) != 0 || len(application_repository.create_job_run_call_args) != 0 {
+			t.Errorf("got runs recorded, want none")
+		}
+	})
+
+	t.Run("should build cron jobs without starting a process", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+
+		application_repository.find_one_return = &scheduled_app
+		application_repository.find_deployments_by_status_return = []database.ApplicationDeployment{
+			{AppDpID: old_uuid, AppID: app_uuid, Status: DeploymentRunning},
+		}
+		deployment := &database.ApplicationDeployment{
+			AppDpID: dp_uuid,
+			AppID: app_uuid,
+			Status: DeploymentQueued,
+			ArtifactsPath: t.TempDir(),
+		}
+
+		launched, err := application_service.launch_deployment(context.Background(), deployment, false)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if launched.Status != DeploymentRunning {
+			t.Fatalf("got status %s, want %s", launched.Status, DeploymentRunning)
+		}
+
+		if deployment_runtime.prepare_n_calls != 1 || deployment_runtime.prepare_call_args[0].Command != "node report.js" {
+			t.Errorf("got prepare called with %+v, want the build of the scheduled command", deployment_runtime.prepare_call_args)
+		}
+		if deployment_runtime.start_n_calls != 0 {
+			t.Errorf("got %d starts, want none", deployment_runtime.start_n_calls)
+		}
+		if len(application_repository.lease_deployment_port_call_args) != 0 {
+			t.Errorf("got ports leased %+v, want none", application_repository.lease_deployment_port_call_args)
+		}
+		if application_service.supervisor.watching(dp_uuid.String()) {
+			t.Errorf("got the deployment supervised, want nothing to supervise")
+		}
+
+		transitions := application_repository.transition_deployment_call_args
+		last := transitions[len(transitions)-1]
+		if last.AppDpID != old_uuid || last.ToStatus != DeploymentSuperseded {
+			t.Errorf("got last transition %+v, want the previous deployment superseded", last)
+		}
+	})
+
+	t.Run("should fail runs nothing awaits after a restart", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+
+		run_uuid := pgtype.UUID{}
+		run_uuid.Scan("5f0c4a3e-8d9b-4c1a-9e2f-7b6a5d4c3b2a")
+		application_repository.find_job_runs_by_status_return = []database.ApplicationJobRun{
+			{AppRunID: run_uuid, AppID: app_uuid, AppDpID: dp_uuid, Status: JobRunRunning},
+		}
+		deployment_runtime.list_return = []string{run_uuid.String()}
+
+		if err := application_service.reconcile(); err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		finished := application_repository.finish_job_run_call_args
+		if len(finished) != 1 || finished[0].AppRunID != run_uuid || finished[0].Status != JobRunFailed {
+			t.Fatalf("got finished runs %+v, want the run failed", finished)
+		}
+		if !slices.Equal(deployment_runtime.stop_call_args, []string{run_uuid.String()}) {
+			t.Errorf("got stop called with %v, want the run stopped once", deployment_runtime.stop_call_args)
+		}
+		if len(application_repository.create_event_call_args) != 0 {
+			t.Errorf("got events %+v, want the run not killed as an orphan", application_repository.create_event_call_args)
+		}
+	})
+}
+
+func TestStaticSites(t *testing.T) {
+	application_repository := &StubApplicationRepository{}
+	deployment_runtime := &StubRuntime{}
+
+	application_service := NewService(
+		context.Background(),
+		&pgxpool.Pool{},
+		application_repository,
+		&tests.StubProjectService{},
+		t.TempDir(),
+		new_test_artifact_store(t),
+		deployment_runtime,
+		DefaultPortRange(),
+		runtime.ExtractLimits{},
+		DefaultQueueOptions(),
+	).(*service)
+
+	app_uuid := pgtype.UUID{}
+	app_uuid.Scan("a7e4e583-471c-4b51-bcdd-7fb57291c5cb")
+	dp_uuid := pgtype.UUID{}
+	dp_uuid.Scan("d1c0fd4b-5a2f-4f8e-9c0e-3a6f0f6b2c11")
+	old_uuid := pgtype.UUID{}
+	old_uuid.Scan("0b5f3e0c-7a43-4d0f-8f34-5b2d6b0c9e21")
+
+	site := &database.Application{
+		AppID: app_uuid,
+		Type: dto.AppTypeStaticSite,
+		DesiredState: DesiredStateRunning,
+	}
+
+	t.Run("should build static sites without starting a process", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+
+		application_repository.find_one_return = site
+		application_repository.find_deployments_by_status_return = []database.ApplicationDeployment{
+			{AppDpID: old_uuid, AppID: app_uuid, Status: DeploymentRunning},
+		}
+		deployment := &database.ApplicationDeployment{
+			AppDpID: dp_uuid,
+			AppID: app_uuid,
+			Status: DeploymentQueued,
+			ArtifactsPath: t.TempDir(),
+		}
+
+		launched, err := application_service.launch_deployment(context.Background(), deployment, false)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if launched.Status != DeploymentRunning {
+			t.Fatalf("got status %s, want %s", launched.Status, DeploymentRunning)
+		}
+
+		if deployment_runtime.prepare_n_calls != 1 || !deployment_runtime.prepare_call_args[0].Static {
+			t.Errorf("got prepare called with %+v, want a static build", deployment_runtime.prepare_call_args)
+		}
+		if deployment_runtime.start_n_calls != 0 {
+			t.Errorf("got %d starts, want none", deployment_runtime.start_n_calls)
+		}
+		if len(application_repository.lease_deployment_port_call_args) != 0 {
+			t.Errorf("got ports leased %+v, want none", application_repository.lease_deployment_port_call_args)
+		}
+		if application_service.supervisor.watching(dp_uuid.String()) {
+			t.Errorf("got the deployment supervised, want nothing to supervise")
+		}
+
+		transitions := application_repository.transition_deployment_call_args
+		for i, transition := range transitions {
+			reason := application_repository.transition_deployment_reasons[i].String
+			if transition.AppDpID == dp_uuid && transition.ToStatus == DeploymentRunning && reason != "built, served by the ingress" {
+				t.Errorf("got running with reason %q, want it served by the ingress", reason)
+			}
+		}
+		last := transitions[len(transitions)-1]
+		if last.AppDpID != old_uuid || last.ToStatus != DeploymentSuperseded {
+			t.Errorf("got last transition %+v, want the previous deployment superseded", last)
+		}
+	})
+
+	t.Run("should route static sites to the files of their newest deployment", func (t *testing.T) {
+		defer application_repository.Clear()
+
+		artifacts_path := t.TempDir()
+		dist := filepath.Join(runtime.SourceDir(artifacts_path), "dist")
+		os.MkdirAll(dist, 0o750)
+		os.WriteFile(filepath.Join(runtime.SourceDir(artifacts_path), "package.json"), []byte("{}"), 0o640)
+		os.WriteFile(filepath.Join(dist, "index.html"), []byte("<h1>site</h1>"), 0o640)
+
+		application_repository.find_ingress_routes_return = []database.FindIngressRoutesRow{
+			{AppID: app_uuid, AppDpID: dp_uuid, AppName: "site", ProjectName: "shop", AppType: dto.AppTypeStaticSite, ArtifactsPath: artifacts_path},
+			{AppID: app_uuid, AppDpID: old_uuid, AppName: "site", ProjectName: "shop", AppType: dto.AppTypeStaticSite, ArtifactsPath: t.TempDir()},
+		}
+
+		routes, err := application_service.FindIngressRoutes()
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		want := dto.IngressRoute{AppID: app_uuid.String(), AppName: "site", ProjectName: "shop", DeploymentID: dp_uuid.String(), StaticRoot: dist}
+		if len(routes) != 1 || !reflect.DeepEqual(routes[0], want) {
+			t.Errorf("got routes %+v, want %+v", routes, want)
+		}
+	})
+
+	t.Run("should leave running static sites alone when reconciling", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+
+		application_repository.find_deployments_with_desired_state_return = []database.FindApplicationDeploymentsWithDesiredStateRow{
+			{
+				ApplicationDeployment: database.ApplicationDeployment{AppDpID: dp_uuid, AppID: app_uuid, Status: DeploymentRunning},
+				AppType: dto.AppTypeStaticSite,
+				AppDesiredState: DesiredStateRunning,
+			},
+		}
+		deployment_runtime.status_return = &runtime.Status{State: runtime.StateNotFound}
+
+		if err := application_service.reconcile(); err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		if deployment_runtime.start_n_calls != 0 || len(application_repository.transition_deployment_call_args) != 0 {
+			t.Errorf("got the site restarted, want it left running without a process")
+		}
+	})
+}
+
+func TestDeploymentProcesses(t *testing.T) {
+	application_repository := &StubApplicationRepository{}
+	deployment_runtime := &StubRuntime{}
+
+	application_service := NewService(
+		context.Background(),
+		&pgxpool.Pool{},
+		application_repository,
+		&tests.StubProjectService{},
+		t.TempDir(),
+		new_test_artifact_store(t),
+		deployment_runtime,
+		DefaultPortRange(),
+		runtime.ExtractLimits{},
+		DefaultQueueOptions(),
+	).(*service)
+	application_service.supervision = supervision_options{
+		restart_backoff: time.Millisecond,
+		max_restart_backoff: 4 * time.Millisecond,
+		crash_loop_threshold: 3,
+		stable_after: time.Minute,
+		run_poll_interval: time.Millisecond,
+	}
+
+	app_id := "a7e4e583-471c-4b51-bcdd-7fb57291c5cb"
+	user_id := "3ad11d5d-5a7e-433d-ac51-fba7a645f3d4"
+	app_uuid := pgtype.UUID{}
+	app_uuid.Scan(app_id)
+	dp_uuid := pgtype.UUID{}
+	dp_uuid.Scan("d1c0fd4b-5a2f-4f8e-9c0e-3a6f0f6b2c11")
+	dp_id := dp_uuid.String()
+
+	artifacts_path := t.TempDir()
+	if err := os.MkdirAll(runtime.SourceDir(artifacts_path), 0o755); err != nil {
+		t.Fatalf("got error %v, want nil", err)
+	}
+	procfile := "web: node index.js\nworker: node worker.js\nrelease: node migrate.js\n"
+	if err := os.WriteFile(filepath.Join(runtime.SourceDir(artifacts_path), "Procfile"), []byte(procfile), 0o644); err != nil {
+		t.Fatalf("got error %v, want nil", err)
+	}
+
+	// checks are far apart so watches started by a test don't run during it
+	app := &database.Application{
+		AppID: app_uuid,
+		Type: dto.AppTypeWebAppContainer,
+		HealthCheckIntervalSeconds: 3600,
+	}
+	deployment := &database.ApplicationDeployment{
+		AppDpID: dp_uuid,
+		AppID: app_uuid,
+		Status: DeploymentRunning,
+		ArtifactsPath: artifacts_path,
+		VariablesSnapshotJson: []byte(`{"QUEUE": "emails"}`),
+	}
+	process_row := func(process_type string, index int32, status string) database.ApplicationDeploymentProcess {
+		runtime_id := dp_id
+		if process_type != runtime.ProcessWeb || index > 0 {
+			runtime_id = process_runtime_id(dp_id, process_type, index)
+		}
+		return database.ApplicationDeploymentProcess{
+			AppDpID: dp_uuid,
+			ProcessType: process_type,
+			InstanceIndex: index,
+			RuntimeID: runtime_id,
+			Status: status,
+		}
+	}
+
+	t.Run("should run the other process types as scaled without the port", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+		defer application_service.supervisor.unwatch(dp_id + ".worker.0")
+		defer application_service.supervisor.unwatch(dp_id + ".worker.1")
+
+		application_repository.find_one_return = app
+		application_repository.find_process_scales_return = []database.ApplicationProcessScale{{AppID: app_uuid, ProcessType: "worker", Scale: 2}}
+
+		if err := application_service.scale_processes(deployment); err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		if deployment_runtime.start_n_calls != 2 {
+			t.Fatalf("got %d processes started, want 2", deployment_runtime.start_n_calls)
+		}
+		for i, spec := range deployment_runtime.start_call_args {
+			want_id := process_runtime_id(dp_id, "worker", int32(i))
+			if spec.DeploymentID != want_id || spec.BuildID != dp_id || spec.Process != "worker" || spec.Command != "node worker.js" {
+				t.Errorf("got spec %+v, want worker %d on the deployment's build", spec, i)
+			}
+			if _, ok := spec.Variables["PORT"]; ok || spec.Variables["QUEUE"] != "emails" {
+				t.Errorf("got variables %v, want the config ones without the leased PORT", spec.Variables)
+			}
+			if !application_service.supervisor.watching(want_id) {
+				t.Errorf("got %s unwatched, want it supervised", want_id)
+			}
+		}
+
+		got := application_repository.upsert_deployment_process_call_args
+		if len(got) != 2 || got[1].ProcessType != "worker" || got[1].InstanceIndex != 1 || got[1].Status != ProcessRunning {
+			t.Errorf("got processes recorded %+v, want both workers running", got)
+		}
+	})
+
+	t.Run("should scale the web process on ports of its own", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+		defer application_service.supervisor.unwatch(dp_id + ".web.1")
+		defer application_service.supervisor.unwatch(dp_id + ".web.2")
+
+		application_repository.find_one_return = app
+		application_repository.find_process_scales_return = []database.ApplicationProcessScale{
+			{AppID: app_uuid, ProcessType: runtime.ProcessWeb, Scale: 3},
+			{AppID: app_uuid, ProcessType: "worker", Scale: 0},
+		}
+
+		if err := application_service.scale_processes(deployment); err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		if deployment_runtime.start_n_calls != 2 {
+			t.Fatalf("got %d processes started, want the 2 web instances next to the deployment's own", deployment_runtime.start_n_calls)
+		}
+		ports := map[string]bool{}
+		for i, spec := range deployment_runtime.start_call_args {
+			want_id := process_runtime_id(dp_id, runtime.ProcessWeb, int32(i + 1))
+			if spec.DeploymentID != want_id || spec.BuildID != dp_id || spec.Process != runtime.ProcessWeb || spec.Command != "node index.js" {
+				t.Errorf("got spec %+v, want web %d on the deployment's build", spec, i + 1)
+			}
+			ports[spec.Variables["PORT"]] = true
+		}
+		if len(ports) != 2 || ports[""] {
+			t.Errorf("got ports %v, want a leased port per instance", ports)
+		}
+		for _, lease := range []int32{1, 2} {
+			if _, ok := application_repository.deployment_ports[stub_port_lease{app_dp_id: dp_uuid, instance_index: lease}]; !ok {
+				t.Errorf("got no port leased for web %d, want one", lease)
+			}
+		}
+	})
+
+	t.Run("should only watch running instances and stop those past the scale", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+		defer application_service.supervisor.unwatch(dp_id + ".worker.0")
+
+		application_repository.find_one_return = app
+		application_repository.find_deployment_processes_return = []database.ApplicationDeploymentProcess{
+			process_row(runtime.ProcessWeb, 0, ProcessRunning),
+			process_row("worker", 0, ProcessRunning),
+			process_row("worker", 1, ProcessCrashLooping),
+			process_row(runtime.ProcessRelease, 0, ProcessSucceeded),
+		}
+
+		if err := application_service.scale_processes(deployment); err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		if deployment_runtime.start_n_calls != 0 {
+			t.Errorf("got %d processes started, want the running worker left alone", deployment_runtime.start_n_calls)
+		}
+		if !slices.Equal(deployment_runtime.stop_call_args, []string{dp_id + ".worker.1"}) {
+			t.Errorf("got stopped %v, want only the second worker", deployment_runtime.stop_call_args)
+		}
+		got := application_repository.update_deployment_process_status_call_args
+		if len(got) != 1 || got[0].Status != ProcessStopped {
+			t.Errorf("got status updates %+v, want the second worker stopped", got)
+		}
+		if !application_service.supervisor.watching(dp_id + ".worker.0") {
+			t.Errorf("got the first worker unwatched, want it supervised")
+		}
+	})
+
+	t.Run("should run the release command before starting", func (t *testing.T) {
+		tests := []struct {
+			exit_code int
+			want_status string
+		}{
+			{0, ProcessSucceeded},
+			{1, ProcessFailed},
+		}
+
+		for _, tt := range tests {
+			application_repository.Clear()
+			deployment_runtime.Clear()
+			deployment_runtime.status_return = &runtime.Status{State: runtime.StateExited, ExitCode: tt.exit_code}
+
+			spec, err := application_service.runtime_spec(deployment)
+			if err != nil {
+				t.Fatalf("got error %v, want nil", err)
+			}
+			err = application_service.release_deployment(context.Background(), deployment, app, spec)
+			if tt.exit_code == 0 && err != nil {
+				t.Errorf("got error %v, want nil", err)
+			}
+			if tt.exit_code != 0 && (err == nil || !strings.Contains(err.Error(), "exited with code 1")) {
+				t.Errorf("got error %v, want the release failing with its exit code", err)
+			}
+
+			release := deployment_runtime.start_call_args[0]
+			if release.DeploymentID != dp_id+".release.0" || release.Command != "node migrate.js" || release.Process != runtime.ProcessRelease {
+				t.Errorf("got release %+v, want node migrate.js on the build", release)
+			}
+			if !slices.Equal(deployment_runtime.stop_call_args, []string{release.DeploymentID}) {
+				t.Errorf("got stopped %v, want the exited release cleared", deployment_runtime.stop_call_args)
+			}
+			got := application_repository.update_deployment_process_status_call_args
+			if len(got) != 1 || got[0].Status != tt.want_status || got[0].ExitCode.Int32 != int32(tt.exit_code) {
+				t.Errorf("got status updates %+v, want the release %s", got, tt.want_status)
+			}
+		}
+		application_repository.Clear()
+		deployment_runtime.Clear()
+	})
+
+	t.Run("should mark an instance restarted too often in a row crash looping", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+
+		process := process_row("worker", 0, ProcessRunning)
+		process.RestartCount = 2
+		w := &process_watch{
+			deployment: deployment,
+			app: app,
+			process: &process,
+			spec: runtime.Spec{DeploymentID: process.RuntimeID, Process: "worker"},
+		}
+
+		if !application_service.restart_process(context.Background(), w, "process exited with code 1") {
+			t.Fatalf("got the watch ended, want it to go on")
+		}
+
+		if deployment_runtime.start_n_calls != 1 || deployment_runtime.start_call_args[0].DeploymentID != process.RuntimeID {
+			t.Errorf("got start called with %+v, want the worker restarted", deployment_runtime.start_call_args)
+		}
+		got := application_repository.upsert_deployment_process_call_args
+		if len(got) != 1 || got[0].Status != ProcessCrashLooping || got[0].RestartCount != 3 {
+			t.Errorf("got processes recorded %+v, want the worker crash looping after 3 restarts", got)
+		}
+		events := application_repository.create_event_call_args
+		if len(events) != 1 || events[0].Kind != EventProcessRestarted || !strings.HasPrefix(events[0].Message.String, "worker.0 restart 3") {
+			t.Errorf("got events %+v, want a restart of worker.0", events)
+		}
+		if len(application_repository.transition_deployment_call_args) != 0 {
+			t.Errorf("got the deployment transitioned, want it to keep its status")
+		}
+	})
+
+	t.Run("should stop the other processes with the deployment", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+
+		application_repository.find_deployment_processes_return = []database.ApplicationDeploymentProcess{
+			process_row(runtime.ProcessWeb, 0, ProcessRunning),
+			process_row("worker", 0, ProcessRunning),
+			process_row(runtime.ProcessRelease, 0, ProcessSucceeded),
+		}
+
+		if _, err := application_service.stop_deployment(deployment, DeploymentSuperseded, "superseded"); err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		if !slices.Equal(deployment_runtime.stop_call_args, []string{dp_id, dp_id + ".worker.0"}) {
+			t.Errorf("got stopped %v, want the deployment and its worker", deployment_runtime.stop_call_args)
+		}
+		got := application_repository.update_deployment_process_status_call_args
+		if len(got) != 2 || got[0].Status != ProcessStopped || got[1].Status != ProcessStopped {
+			t.Errorf("got status updates %+v, want web and worker stopped", got)
+		}
+	})
+
+	t.Run("should keep instances of active deployments the runtime lists", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+
+		deployment_runtime.list_return = []string{dp_id + ".worker.0", dp_id + ".worker.1"}
+		application_repository.find_one_deployment_by_id_return = deployment
+		application_repository.find_deployment_processes_by_status_return = []database.ApplicationDeploymentProcess{
+			process_row("worker", 0, ProcessRunning),
+		}
+
+		if err := application_service.reconcile(); err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		if !slices.Equal(deployment_runtime.stop_call_args, []string{dp_id + ".worker.1"}) {
+			t.Errorf("got stopped %v, want only the worker without a running row", deployment_runtime.stop_call_args)
+		}
+		events := application_repository.create_event_call_args
+		if len(events) != 1 || events[0].Kind != EventReconcileKilled || events[0].AppDpID != dp_uuid {
+			t.Errorf("got events %+v, want the kill recorded on the deployment", events)
+		}
+	})
+
+	t.Run("should scale only applications running processes", func (t *testing.T) {
+		defer application_repository.Clear()
+		defer deployment_runtime.Clear()
+
+		application_repository.find_one_with_project_member_return = &database.FindOneApplicationWithProjectMemberRow{
+			AppID: app_uuid,
+			Type: dto.AppTypeCronJob,
+			PmProjectID: pgtype.UUID{Valid: true},
+		}
+		scale := dto.UpdateApplicationScaleDto{Processes: map[string]int32{"worker": 3, "clock": 0}}
+
+		if _, err := application_service.UpdateScale(app_id, user_id, scale); err == nil || err.Error() != "invalid_app_type" {
+			t.Fatalf("got error %v, want invalid_app_type", err)
+		}
+
+		application_repository.find_one_with_project_member_return.Type = dto.AppTypeWebAppContainer
+		application_repository.find_process_scales_return = []database.ApplicationProcessScale{{ProcessType: "clock"}, {ProcessType: "worker", Scale: 3}}
+
+		scales, err := application_service.UpdateScale(app_id, user_id, scale)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		got := application_repository.upsert_process_scale_call_args
+		if len(got) != 2 || got[0].ProcessType != "clock" || got[0].Scale != 0 || got[1].ProcessType != "worker" || got[1].Scale != 3 {
+			t.Errorf("got scales stored %+v, want clock at 0 and worker at 3", got)
+		}
+		if len(scales) != 2 {
+			t.Errorf("got scales %+v, want both process types", scales)
+		}
+	})
+}
+
+func TestCanTransitionDeployment(t *testing.T) {
+	tests := []struct{
+		from string
+		to string
+		want bool
+	}{
+		{DeploymentQueued, DeploymentExtracting, true},
+		{DeploymentExtracting, DeploymentBuilding, true},
+		{DeploymentBuilding, DeploymentStarting, true},
+		{DeploymentStarting, DeploymentRunning, true},
+		{DeploymentRunning, DeploymentSuperseded, true},
+		{DeploymentBuilding, DeploymentFailed, true},
+		{DeploymentStopped, DeploymentStarting, true},
+		{DeploymentRunning, DeploymentCrashLooping, true},
+		{DeploymentCrashLooping, DeploymentRunning, true},
+		{DeploymentStopped, DeploymentRunning, false},
+		{DeploymentQueued, DeploymentRunning, false},
+		{DeploymentFailed, DeploymentRunning, false},
+		{DeploymentSuperseded, DeploymentQueued, false},
+		{DeploymentBuilding, DeploymentQueued, true},
+		{DeploymentQueued, DeploymentCancelled, true},
+		{DeploymentStarting, DeploymentCancelled, true},
+		{DeploymentRunning, DeploymentCancelled, false},
+		{DeploymentCancelled, DeploymentQueued, false},
+		{"unknown", DeploymentQueued, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.from+"->"+tt.to, func (t *testing.T) {
+			got := CanTransitionDeployment(tt.from, tt.to)
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
 }
\ No newline at end of file
@@ -1,7 +1,15 @@
 package application
 
 import (
+	"errors"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/salmanrf/capybara-cloud/internal/database"
+	"github.com/salmanrf/capybara-cloud/internal/runtime"
 )
 
 type StubApplicationRepository struct {
@@ -21,6 +29,120 @@ type StubApplicationRepository struct {
 	create_application_call_args []database.CreateApplicationParams
 	update_one_application_n_calls int
 	update_one_application_call_args []database.UpdateOneApplicationParams
+	create_deployment_return *database.ApplicationDeployment
+	create_deployment_error error
+	create_deployment_n_calls int
+	create_deployment_call_args []database.CreateApplicationDeploymentParams
+	create_deployment_reasons []pgtype.Text
+	find_one_return *database.Application
+	find_one_error error
+	update_health_check_return *database.Application
+	update_health_check_error error
+	update_health_check_call_args []database.UpdateApplicationHealthCheckParams
+	update_resources_return *database.Application
+	update_resources_error error
+	update_resources_call_args []database.UpdateApplicationResourcesParams
+	update_deployment_health_call_args []database.UpdateApplicationDeploymentHealthParams
+	update_deployment_restarts_call_args []database.UpdateApplicationDeploymentRestartsParams
+	update_desired_state_return *database.Application
+	update_desired_state_error error
+	update_desired_state_call_args []database.UpdateApplicationDesiredStateParams
+	update_desired_state_events []database.CreateApplicationEventParams
+	create_event_call_args []database.CreateApplicationEventParams
+	find_deployments_return []database.ApplicationDeployment
+	find_deployments_error error
+	find_deployments_n_calls int
+	find_deployments_by_status_return []database.ApplicationDeployment
+	find_deployments_by_status_error error
+	find_deployments_by_status_call_args []database.FindApplicationDeploymentsByStatusParams
+	find_one_deployment_return *database.ApplicationDeployment
+	find_one_deployment_error error
+	find_one_deployment_n_calls int
+	find_one_deployment_call_args []database.FindOneApplicationDeploymentParams
+	find_one_deployment_by_id_return *database.ApplicationDeployment
+	find_one_deployment_by_id_error error
+	find_deployments_with_desired_state_return []database.FindApplicationDeploymentsWithDesiredStateRow
+	find_deployments_with_desired_state_error error
+	upsert_deployment_process_error error
+	upsert_deployment_process_call_args []database.UpsertApplicationDeploymentProcessParams
+	update_deployment_process_status_error error
+	update_deployment_process_status_call_args []database.UpdateApplicationDeploymentProcessStatusParams
+	find_deployment_processes_return []database.ApplicationDeploymentProcess
+	find_deployment_processes_error error
+	find_deployment_processes_by_status_return []database.ApplicationDeploymentProcess
+	find_deployment_processes_by_status_error error
+	upsert_process_scale_error error
+	upsert_process_scale_call_args []database.UpsertApplicationProcessScaleParams
+	find_process_scales_return []database.ApplicationProcessScale
+	find_process_scales_error error
+	update_deployment_source_return *database.ApplicationDeployment
+	update_deployment_source_error error
+	update_deployment_source_call_args []database.UpdateApplicationDeploymentSourceParams
+	update_deployment_stack_error error
+	update_deployment_stack_call_args []database.UpdateApplicationDeploymentStackParams
+	update_deployment_canary_return *database.ApplicationDeployment
+	update_deployment_canary_error error
+	update_deployment_canary_call_args []database.UpdateApplicationDeploymentCanaryParams
+	promote_deployment_canary_return *database.ApplicationDeployment
+	promote_deployment_canary_error error
+	promote_deployment_canary_call_args []pgtype.UUID
+	add_deployment_canary_traffic_error error
+	add_deployment_canary_traffic_call_args []database.AddApplicationDeploymentCanaryTrafficParams
+	transition_deployment_error error
+	transition_deployment_n_calls int
+	transition_deployment_call_args []database.UpdateApplicationDeploymentStatusParams
+	transition_deployment_reasons []pgtype.Text
+	find_deployment_transitions_return []database.ApplicationDeploymentTransition
+	find_deployment_transitions_error error
+	create_deployment_log_error error
+	create_deployment_log_call_args []database.CreateApplicationDeploymentLogParams
+	find_deployment_logs_return []database.ApplicationDeploymentLog
+	find_deployment_logs_error error
+	find_deployment_logs_call_args []database.FindApplicationDeploymentLogsParams
+	find_ingress_routes_return []database.FindIngressRoutesRow
+	find_ingress_routes_error error
+	find_ingress_routes_call_args [][]string
+	deployment_ports map[stub_port_lease]int32
+	lease_deployment_port_error error
+	lease_deployment_port_call_args []database.LeaseApplicationDeploymentPortParams
+	release_deployment_port_call_args []pgtype.UUID
+	find_config_return *database.ApplicationConfig
+	find_config_error error
+	webhook *database.ApplicationWebhook
+	upsert_webhook_call_args []database.UpsertApplicationWebhookParams
+	create_webhook_delivery_call_args []database.CreateApplicationWebhookDeliveryParams
+	jobs_mu sync.Mutex
+	jobs []*stub_deployment_job
+	claim_deployment_job_error error
+	update_schedule_return *database.Application
+	update_schedule_error error
+	update_schedule_call_args []database.UpdateApplicationScheduleParams
+	find_due_schedules_return []database.Application
+	find_due_schedules_error error
+	claim_schedule_run_return *database.Application
+	claim_schedule_run_error error
+	claim_schedule_run_call_args []database.ClaimApplicationScheduleRunParams
+	start_job_run_error error
+	start_job_run_call_args []database.StartApplicationJobRunParams
+	create_job_run_call_args []database.CreateApplicationJobRunParams
+	finish_job_run_call_args []database.FinishApplicationJobRunParams
+	find_job_runs_return []database.ApplicationJobRun
+	find_job_runs_error error
+	find_job_runs_call_args []database.FindApplicationJobRunsParams
+	find_job_runs_by_status_return []database.ApplicationJobRun
+	find_job_runs_by_status_error error
+	find_one_job_run_return *database.ApplicationJobRun
+	find_one_job_run_error error
+	create_job_run_log_call_args []database.CreateApplicationJobRunLogParams
+	find_job_run_logs_return []database.ApplicationJobRunLog
+	find_job_run_logs_error error
+	find_job_run_logs_call_args []database.FindApplicationJobRunLogsParams
+}
+
+// stub_deployment_job is a queued job with the deployment row it launches
+type stub_deployment_job struct {
+	job database.ApplicationDeploymentJob
+	deployment database.ApplicationDeployment
 }
 
 func (s *StubApplicationRepository) Clear() {
@@ -40,6 +162,113 @@ func (s *StubApplicationRepository) Clear() {
 	s.create_application_call_args = nil
 	s.update_one_application_n_calls = 0
 	s.update_one_application_call_args = nil
+	s.create_deployment_return = nil
+	s.create_deployment_error = nil
+	s.create_deployment_n_calls = 0
+	s.create_deployment_call_args = nil
+	s.create_deployment_reasons = nil
+	s.find_one_return = nil
+	s.find_one_error = nil
+	s.update_health_check_return = nil
+	s.update_health_check_error = nil
+	s.update_health_check_call_args = nil
+	s.update_resources_return = nil
+	s.update_resources_error = nil
+	s.update_resources_call_args = nil
+	s.update_deployment_health_call_args = nil
+	s.update_deployment_restarts_call_args = nil
+	s.update_desired_state_return = nil
+	s.update_desired_state_error = nil
+	s.update_desired_state_call_args = nil
+	s.update_desired_state_events = nil
+	s.create_event_call_args = nil
+	s.find_deployments_return = nil
+	s.find_deployments_error = nil
+	s.find_deployments_n_calls = 0
+	s.find_deployments_by_status_return = nil
+	s.find_deployments_by_status_error = nil
+	s.find_deployments_by_status_call_args = nil
+	s.find_one_deployment_return = nil
+	s.find_one_deployment_error = nil
+	s.find_one_deployment_n_calls = 0
+	s.find_one_deployment_call_args = nil
+	s.find_one_deployment_by_id_return = nil
+	s.find_one_deployment_by_id_error = nil
+	s.find_deployments_with_desired_state_return = nil
+	s.find_deployments_with_desired_state_error = nil
+	s.upsert_deployment_process_error = nil
+	s.upsert_deployment_process_call_args = nil
+	s.update_deployment_process_status_error = nil
+	s.update_deployment_process_status_call_args = nil
+	s.find_deployment_processes_return = nil
+	s.find_deployment_processes_error = nil
+	s.find_deployment_processes_by_status_return = nil
+	s.find_deployment_processes_by_status_error = nil
+	s.upsert_process_scale_error = nil
+	s.upsert_process_scale_call_args = nil
+	s.find_process_scales_return = nil
+	s.find_process_scales_error = nil
+	s.update_deployment_source_return = nil
+	s.update_deployment_source_error = nil
+	s.update_deployment_source_call_args = nil
+	s.update_deployment_stack_error = nil
+	s.update_deployment_stack_call_args = nil
+	s.update_deployment_canary_return = nil
+	s.update_deployment_canary_error = nil
+	s.update_deployment_canary_call_args = nil
+	s.promote_deployment_canary_return = nil
+	s.promote_deployment_canary_error = nil
+	s.promote_deployment_canary_call_args = nil
+	s.add_deployment_canary_traffic_error = nil
+	s.add_deployment_canary_traffic_call_args = nil
+	s.transition_deployment_error = nil
+	s.transition_deployment_n_calls = 0
+	s.transition_deployment_call_args = nil
+	s.transition_deployment_reasons = nil
+	s.find_deployment_transitions_return = nil
+	s.find_deployment_transitions_error = nil
+	s.create_deployment_log_error = nil
+	s.create_deployment_log_call_args = nil
+	s.find_deployment_logs_return = nil
+	s.find_deployment_logs_error = nil
+	s.find_deployment_logs_call_args = nil
+	s.find_ingress_routes_return = nil
+	s.find_ingress_routes_error = nil
+	s.find_ingress_routes_call_args = nil
+	s.deployment_ports = nil
+	s.lease_deployment_port_error = nil
+	s.lease_deployment_port_call_args = nil
+	s.release_deployment_port_call_args = nil
+	s.find_config_return = nil
+	s.find_config_error = nil
+	s.webhook = nil
+	s.upsert_webhook_call_args = nil
+	s.create_webhook_delivery_call_args = nil
+	s.jobs = nil
+	s.claim_deployment_job_error = nil
+	s.update_schedule_return = nil
+	s.update_schedule_error = nil
+	s.update_schedule_call_args = nil
+	s.find_due_schedules_return = nil
+	s.find_due_schedules_error = nil
+	s.claim_schedule_run_return = nil
+	s.claim_schedule_run_error = nil
+	s.claim_schedule_run_call_args = nil
+	s.start_job_run_error = nil
+	s.start_job_run_call_args = nil
+	s.create_job_run_call_args = nil
+	s.finish_job_run_call_args = nil
+	s.find_job_runs_return = nil
+	s.find_job_runs_error = nil
+	s.find_job_runs_call_args = nil
+	s.find_job_runs_by_status_return = nil
+	s.find_job_runs_by_status_error = nil
+	s.find_one_job_run_return = nil
+	s.find_one_job_run_error = nil
+	s.create_job_run_log_call_args = nil
+	s.find_job_run_logs_return = nil
+	s.find_job_run_logs_error = nil
+	s.find_job_run_logs_call_args = nil
 }
 
 func (s *StubApplicationRepository) FindOneWithProjectMember(params database.FindOneApplicationWithProjectMemberParams) (*database.FindOneApplicationWithProjectMemberRow, error) {
@@ -64,4 +293,682 @@ func (s *StubApplicationRepository) UpdateOneApplication(params database.UpdateO
 	s.update_one_application_n_calls += 1
 	s.update_one_application_call_args = append(s.update_one_application_call_args, params)
 	return s.update_one_application_return, s.update_one_application_error
-}
\ No newline at end of file
+}
+
+func (s *StubApplicationRepository) FindOne(app_id pgtype.UUID) (*database.Application, error) {
+	return s.find_one_return, s.find_one_error
+}
+
+func (s *StubApplicationRepository) UpdateHealthCheck(params database.UpdateApplicationHealthCheckParams) (*database.Application, error) {
+	s.update_health_check_call_args = append(s.update_health_check_call_args, params)
+	return s.update_health_check_return, s.update_health_check_error
+}
+
+func (s *StubApplicationRepository) UpdateResources(params database.UpdateApplicationResourcesParams) (*database.Application, error) {
+	s.update_resources_call_args = append(s.update_resources_call_args, params)
+	return s.update_resources_return, s.update_resources_error
+}
+
+func (s *StubApplicationRepository) UpdateDeploymentHealth(params database.UpdateApplicationDeploymentHealthParams) (*database.ApplicationDeployment, error) {
+	s.update_deployment_health_call_args = append(s.update_deployment_health_call_args, params)
+	return &database.ApplicationDeployment{
+		AppDpID: params.AppDpID,
+		HealthStatus: params.HealthStatus,
+		HealthMessage: params.HealthMessage,
+		HealthConsecutiveFailures: params.HealthConsecutiveFailures,
+	}, nil
+}
+
+func (s *StubApplicationRepository) UpdateDeploymentRestarts(params database.UpdateApplicationDeploymentRestartsParams) (*database.ApplicationDeployment, error) {
+	s.update_deployment_restarts_call_args = append(s.update_deployment_restarts_call_args, params)
+	return &database.ApplicationDeployment{
+		AppDpID: params.AppDpID,
+		RestartCount: params.RestartCount,
+	}, nil
+}
+
+func (s *StubApplicationRepository) UpdateDesiredState(params database.UpdateApplicationDesiredStateParams, event database.CreateApplicationEventParams) (*database.Application, error) {
+	s.update_desired_state_call_args = append(s.update_desired_state_call_args, params)
+	s.update_desired_state_events = append(s.update_desired_state_events, event)
+	if s.update_desired_state_error != nil || s.update_desired_state_return != nil {
+		return s.update_desired_state_return, s.update_desired_state_error
+	}
+	return &database.Application{
+		AppID: params.AppID,
+		DesiredState: params.DesiredState,
+		DesiredStateUpdatedBy: params.DesiredStateUpdatedBy,
+	}, nil
+}
+
+func (s *StubApplicationRepository) CreateEvent(params database.CreateApplicationEventParams) (*database.ApplicationEvent, error) {
+	s.create_event_call_args = append(s.create_event_call_args, params)
+	return &database.ApplicationEvent{AppID: params.AppID, Kind: params.Kind}, nil
+}
+
+func (s *StubApplicationRepository) CreateDeployment(params database.CreateApplicationDeploymentParams, reason pgtype.Text) (*database.ApplicationDeployment, error) {
+	s.create_deployment_n_calls += 1
+	s.create_deployment_call_args = append(s.create_deployment_call_args, params)
+	s.create_deployment_reasons = append(s.create_deployment_reasons, reason)
+	if s.create_deployment_error != nil || s.create_deployment_return == nil {
+		return s.create_deployment_return, s.create_deployment_error
+	}
+
+	// the job is inserted alongside the row like the transaction does
+	s.jobs_mu.Lock()
+	defer s.jobs_mu.Unlock()
+
+	deployment := *s.create_deployment_return
+	if !deployment.AppDpID.Valid {
+		deployment.AppDpID = params.AppDpID
+	}
+	if deployment.ArtifactsPath == "" {
+		deployment.ArtifactsPath = params.ArtifactsPath
+	}
+	s.jobs = append(s.jobs, &stub_deployment_job{
+		job: database.ApplicationDeploymentJob{
+			AppDpJobID: deployment.AppDpID,
+			AppDpID: deployment.AppDpID,
+			AppID: params.AppID,
+			Status: JobQueued,
+			RunAfter: pgtype.Timestamp{Time: time.Now(), Valid: true},
+		},
+		deployment: deployment,
+	})
+	return s.create_deployment_return, nil
+}
+
+func (s *StubApplicationRepository) FindDeployments(app_id pgtype.UUID) ([]database.ApplicationDeployment, error) {
+	s.find_deployments_n_calls += 1
+	return s.find_deployments_return, s.find_deployments_error
+}
+
+func (s *StubApplicationRepository) FindDeploymentsByStatus(params database.FindApplicationDeploymentsByStatusParams) ([]database.ApplicationDeployment, error) {
+	s.find_deployments_by_status_call_args = append(s.find_deployments_by_status_call_args, params)
+	if s.find_deployments_by_status_error != nil {
+		return nil, s.find_deployments_by_status_error
+	}
+
+	deployments := []database.ApplicationDeployment{}
+	for _, deployment := range s.find_deployments_by_status_return {
+		if deployment.Status == params.Status {
+			deployments = append(deployments, deployment)
+		}
+	}
+	return deployments, nil
+}
+
+func (s *StubApplicationRepository) FindOneDeployment(params database.FindOneApplicationDeploymentParams) (*database.ApplicationDeployment, error) {
+	s.find_one_deployment_n_calls += 1
+	s.find_one_deployment_call_args = append(s.find_one_deployment_call_args, params)
+
+	// deployments created through the stub are found as they were queued
+	s.jobs_mu.Lock()
+	defer s.jobs_mu.Unlock()
+
+	for _, queued := range s.jobs {
+		if queued.job.AppDpID == params.AppDpID && s.find_one_deployment_error == nil {
+			deployment := queued.deployment
+			return &deployment, nil
+		}
+	}
+	return s.find_one_deployment_return, s.find_one_deployment_error
+}
+
+func (s *StubApplicationRepository) FindOneDeploymentById(app_dp_id pgtype.UUID) (*database.ApplicationDeployment, error) {
+	return s.find_one_deployment_by_id_return, s.find_one_deployment_by_id_error
+}
+
+func (s *StubApplicationRepository) FindDeploymentsWithDesiredState(statuses []string) ([]database.FindApplicationDeploymentsWithDesiredStateRow, error) {
+	return s.find_deployments_with_desired_state_return, s.find_deployments_with_desired_state_error
+}
+
+func (s *StubApplicationRepository) UpsertDeploymentProcess(params database.UpsertApplicationDeploymentProcessParams) (*database.ApplicationDeploymentProcess, error) {
+	s.upsert_deployment_process_call_args = append(s.upsert_deployment_process_call_args, params)
+	if s.upsert_deployment_process_error != nil {
+		return nil, s.upsert_deployment_process_error
+	}
+	return &database.ApplicationDeploymentProcess{
+		AppDpID: params.AppDpID,
+		ProcessType: params.ProcessType,
+		InstanceIndex: params.InstanceIndex,
+		RuntimeID: params.RuntimeID,
+		ProcessName: params.ProcessName,
+		ContainerName: params.ContainerName,
+		Status: params.Status,
+		RestartCount: params.RestartCount,
+	}, nil
+}
+
+func (s *StubApplicationRepository) UpdateDeploymentProcessStatus(params database.UpdateApplicationDeploymentProcessStatusParams) (*database.ApplicationDeploymentProcess, error) {
+	s.update_deployment_process_status_call_args = append(s.update_deployment_process_status_call_args, params)
+	if s.update_deployment_process_status_error != nil {
+		return nil, s.update_deployment_process_status_error
+	}
+	return &database.ApplicationDeploymentProcess{
+		AppDpProcID: params.AppDpProcID,
+		Status: params.Status,
+		ExitCode: params.ExitCode,
+		RestartCount: params.RestartCount,
+	}, nil
+}
+
+func (s *StubApplicationRepository) FindDeploymentProcesses(app_dp_id pgtype.UUID) ([]database.ApplicationDeploymentProcess, error) {
+	return s.find_deployment_processes_return, s.find_deployment_processes_error
+}
+
+func (s *StubApplicationRepository) FindDeploymentProcessesByStatus(params database.FindApplicationDeploymentProcessesByStatusParams) ([]database.ApplicationDeploymentProcess, error) {
+	return s.find_deployment_processes_by_status_return, s.find_deployment_processes_by_status_error
+}
+
+func (s *StubApplicationRepository) UpsertProcessScale(params database.UpsertApplicationProcessScaleParams) (*database.ApplicationProcessScale, error) {
+	s.upsert_process_scale_call_args = append(s.upsert_process_scale_call_args, params)
+	if s.upsert_process_scale_error != nil {
+		return nil, s.upsert_process_scale_error
+	}
+	return &database.ApplicationProcessScale{AppID: params.AppID, ProcessType: params.ProcessType, Scale: params.Scale}, nil
+}
+
+func (s *StubApplicationRepository) FindProcessScales(app_id pgtype.UUID) ([]database.ApplicationProcessScale, error) {
+	return s.find_process_scales_return, s.find_process_scales_error
+}
+
+func (s *StubApplicationRepository) UpdateDeploymentSource(params database.UpdateApplicationDeploymentSourceParams) (*database.ApplicationDeployment, error) {
+	s.update_deployment_source_call_args = append(s.update_deployment_source_call_args, params)
+	return s.update_deployment_source_return, s.update_deployment_source_error
+}
+
+func (s *StubApplicationRepository) UpdateDeploymentStack(params database.UpdateApplicationDeploymentStackParams) (*database.ApplicationDeployment, error) {
+	s.update_deployment_stack_call_args = append(s.update_deployment_stack_call_args, params)
+	if s.update_deployment_stack_error != nil {
+		return nil, s.update_deployment_stack_error
+	}
+	return &database.ApplicationDeployment{AppDpID: params.AppDpID, Stack: params.Stack}, nil
+}
+
+func (s *StubApplicationRepository) UpdateDeploymentCanary(params database.UpdateApplicationDeploymentCanaryParams) (*database.ApplicationDeployment, error) {
+	s.update_deployment_canary_call_args = append(s.update_deployment_canary_call_args, params)
+	return s.update_deployment_canary_return, s.update_deployment_canary_error
+}
+
+func (s *StubApplicationRepository) PromoteDeploymentCanary(app_dp_id pgtype.UUID) (*database.ApplicationDeployment, error) {
+	s.promote_deployment_canary_call_args = append(s.promote_deployment_canary_call_args, app_dp_id)
+	return s.promote_deployment_canary_return, s.promote_deployment_canary_error
+}
+
+func (s *StubApplicationRepository) AddDeploymentCanaryTraffic(params database.AddApplicationDeploymentCanaryTrafficParams) error {
+	s.add_deployment_canary_traffic_call_args = append(s.add_deployment_canary_traffic_call_args, params)
+	return s.add_deployment_canary_traffic_error
+}
+
+// TransitionDeployment echoes the requested status back so services can be
+// driven through a whole rollout without a database.
+func (s *StubApplicationRepository) TransitionDeployment(params database.UpdateApplicationDeploymentStatusParams, reason pgtype.Text) (*database.ApplicationDeployment, error) {
+	s.transition_deployment_n_calls += 1
+	s.transition_deployment_call_args = append(s.transition_deployment_call_args, params)
+	s.transition_deployment_reasons = append(s.transition_deployment_reasons, reason)
+	if s.transition_deployment_error != nil {
+		return nil, s.transition_deployment_error
+	}
+	return &database.ApplicationDeployment{
+		AppDpID: params.AppDpID,
+		Status: params.ToStatus,
+		FailureReason: params.FailureReason,
+	}, nil
+}
+
+func (s *StubApplicationRepository) FindDeploymentTransitions(app_dp_id pgtype.UUID) ([]database.ApplicationDeploymentTransition, error) {
+	return s.find_deployment_transitions_return, s.find_deployment_transitions_error
+}
+
+// CreateDeploymentLog numbers stored lines in call order like the bigserial
+// column would.
+func (s *StubApplicationRepository) CreateDeploymentLog(params database.CreateApplicationDeploymentLogParams) (*database.ApplicationDeploymentLog, error) {
+	s.create_deployment_log_call_args = append(s.create_deployment_log_call_args, params)
+	if s.create_deployment_log_error != nil {
+		return nil, s.create_deployment_log_error
+	}
+	return &database.ApplicationDeploymentLog{
+		AppDpLogID: int64(len(s.create_deployment_log_call_args)),
+		AppDpID: params.AppDpID,
+		Phase: params.Phase,
+		Stream: params.Stream,
+		Line: params.Line,
+		LoggedAt: params.LoggedAt,
+		ProcessType: params.ProcessType,
+	}, nil
+}
+
+func (s *StubApplicationRepository) FindDeploymentLogs(params database.FindApplicationDeploymentLogsParams) ([]database.ApplicationDeploymentLog, error) {
+	s.find_deployment_logs_call_args = append(s.find_deployment_logs_call_args, params)
+	return s.find_deployment_logs_return, s.find_deployment_logs_error
+}
+
+func (s *StubApplicationRepository) FindIngressRoutes(statuses []string) ([]database.FindIngressRoutesRow, error) {
+	s.find_ingress_routes_call_args = append(s.find_ingress_routes_call_args, statuses)
+	return s.find_ingress_routes_return, s.find_ingress_routes_error
+}
+
+// stub_port_lease is the instance of a deployment a port is leased to
+type stub_port_lease struct {
+	app_dp_id pgtype.UUID
+	instance_index int32
+}
+
+func (s *StubApplicationRepository) FindDeploymentPort(params database.FindApplicationDeploymentPortParams) (*database.ApplicationDeploymentPort, error) {
+	port, ok := s.deployment_ports[stub_port_lease{params.AppDpID, params.InstanceIndex}]
+	if !ok {
+		return &database.ApplicationDeploymentPort{}, errors.New("no rows in result set")
+	}
+	return &database.ApplicationDeploymentPort{Port: port, AppDpID: params.AppDpID, InstanceIndex: params.InstanceIndex}, nil
+}
+
+// LeaseDeploymentPort hands out the lowest port of the range nobody holds
+func (s *StubApplicationRepository) LeaseDeploymentPort(params database.LeaseApplicationDeploymentPortParams) (*database.ApplicationDeploymentPort, error) {
+	s.lease_deployment_port_call_args = append(s.lease_deployment_port_call_args, params)
+	if s.lease_deployment_port_error != nil {
+		return &database.ApplicationDeploymentPort{}, s.lease_deployment_port_error
+	}
+	if s.deployment_ports == nil {
+		s.deployment_ports = make(map[stub_port_lease]int32)
+	}
+
+	for port := params.RangeStart; port <= params.RangeEnd; port++ {
+		if slices.Contains(slices.Collect(maps.Values(s.deployment_ports)), port) {
+			continue
+		}
+		s.deployment_ports[stub_port_lease{params.AppDpID, params.InstanceIndex}] = port
+		return &database.ApplicationDeploymentPort{Port: port, AppDpID: params.AppDpID, InstanceIndex: params.InstanceIndex}, nil
+	}
+	return &database.ApplicationDeploymentPort{}, errors.New("no rows in result set")
+}
+
+func (s *StubApplicationRepository) ReleaseDeploymentPort(app_dp_id pgtype.UUID) error {
+	s.release_deployment_port_call_args = append(s.release_deployment_port_call_args, app_dp_id)
+	for lease := range s.deployment_ports {
+		if lease.app_dp_id == app_dp_id {
+			delete(s.deployment_ports, lease)
+		}
+	}
+	return nil
+}
+
+func (s *StubApplicationRepository) FindConfig(app_id pgtype.UUID) (*database.ApplicationConfig, error) {
+	if s.find_config_return == nil && s.find_config_error == nil {
+		return nil, errors.New("no rows in result set")
+	}
+	return s.find_config_return, s.find_config_error
+}
+
+// UpsertWebhook keeps the webhook so it can be found again like the row would
+func (s *StubApplicationRepository) UpsertWebhook(params database.UpsertApplicationWebhookParams) (*database.ApplicationWebhook, error) {
+	s.upsert_webhook_call_args = append(s.upsert_webhook_call_args, params)
+	s.webhook = &database.ApplicationWebhook{
+		AppWhID: pgtype.UUID{Bytes: params.AppID.Bytes, Valid: true},
+		AppID: params.AppID,
+		GitUrl: params.GitUrl,
+		Branch: params.Branch,
+		Secret: params.Secret,
+	}
+	return s.webhook, nil
+}
+
+func (s *StubApplicationRepository) FindOneWebhook(app_id pgtype.UUID) (*database.ApplicationWebhook, error) {
+	if s.webhook == nil || s.webhook.AppID != app_id {
+		return nil, errors.New("no rows in result set")
+	}
+	return s.webhook, nil
+}
+
+func (s *StubApplicationRepository) CreateWebhookDelivery(params database.CreateApplicationWebhookDeliveryParams) (*database.ApplicationWebhookDelivery, error) {
+	s.create_webhook_delivery_call_args = append(s.create_webhook_delivery_call_args, params)
+	return stub_webhook_delivery(params), nil
+}
+
+func stub_webhook_delivery(params database.CreateApplicationWebhookDeliveryParams) *database.ApplicationWebhookDelivery {
+	return &database.ApplicationWebhookDelivery{
+		AppWhID: params.AppWhID,
+		Provider: params.Provider,
+		Event: params.Event,
+		DeliveryID: params.DeliveryID,
+		Ref: params.Ref,
+		CommitSha: params.CommitSha,
+		Verified: params.Verified,
+		Result: params.Result,
+		Message: params.Message,
+		AppDpID: params.AppDpID,
+	}
+}
+
+// FindWebhookDeliveries returns the recorded deliveries newest first
+func (s *StubApplicationRepository) FindWebhookDeliveries(params database.FindApplicationWebhookDeliveriesParams) ([]database.ApplicationWebhookDelivery, error) {
+	deliveries := []database.ApplicationWebhookDelivery{}
+	for _, created := range slices.Backward(s.create_webhook_delivery_call_args) {
+		if created.AppWhID != params.AppWhID || len(deliveries) >= int(params.Limit) {
+			continue
+		}
+		deliveries = append(deliveries, *stub_webhook_delivery(created))
+	}
+	return deliveries, nil
+}
+
+type StubRuntime struct {
+	prepare_error error
+	prepare_n_calls int
+	prepare_call_args []runtime.Spec
+	prepare_log_lines []runtime.LogLine
+	prepare_blocked chan struct{}
+	prepare_stack string
+	start_return *runtime.Instance
+	start_error error
+	start_n_calls int
+	start_call_args []runtime.Spec
+	start_log_lines []runtime.LogLine
+	stop_error error
+	stop_n_calls int
+	stop_call_args []string
+	status_return *runtime.Status
+	status_error error
+	logs_return []runtime.LogLine
+	logs_error error
+	list_return []string
+	list_error error
+}
+
+func (s *StubRuntime) Clear() {
+	s.prepare_error = nil
+	s.prepare_n_calls = 0
+	s.prepare_call_args = nil
+	s.prepare_log_lines = nil
+	s.prepare_blocked = nil
+	s.prepare_stack = ""
+	s.start_return = nil
+	s.start_error = nil
+	s.start_n_calls = 0
+	s.start_call_args = nil
+	s.start_log_lines = nil
+	s.stop_error = nil
+	s.stop_n_calls = 0
+	s.stop_call_args = nil
+	s.status_return = nil
+	s.status_error = nil
+	s.logs_return = nil
+	s.logs_error = nil
+	s.list_return = nil
+	s.list_error = nil
+}
+
+func (s *StubRuntime) Prepare(spec runtime.Spec) error {
+	s.prepare_n_calls += 1
+	s.prepare_call_args = append(s.prepare_call_args, spec)
+	for _, line := range s.prepare_log_lines {
+		spec.OnLog(line)
+	}
+	if s.prepare_stack != "" && spec.OnStack != nil {
+		spec.OnStack(s.prepare_stack)
+	}
+	// builds in progress signal prepare_blocked and wait to be cancelled
+	if s.prepare_blocked != nil {
+		s.prepare_blocked <- struct{}{}
+		<-spec.Context.Done()
+		return spec.Context.Err()
+	}
+	return s.prepare_error
+}
+
+func (s *StubRuntime) Start(spec runtime.Spec) (*runtime.Instance, error) {
+	s.start_n_calls += 1
+	s.start_call_args = append(s.start_call_args, spec)
+	for _, line := range s.start_log_lines {
+		spec.OnLog(line)
+	}
+	if s.start_return == nil && s.start_error == nil {
+		return &runtime.Instance{}, nil
+	}
+	return s.start_return, s.start_error
+}
+
+func (s *StubRuntime) Stop(deployment_id string) error {
+	s.stop_n_calls += 1
+	s.stop_call_args = append(s.stop_call_args, deployment_id)
+	return s.stop_error
+}
+
+func (s *StubRuntime) Status(deployment_id string) (*runtime.Status, error) {
+	if s.status_return == nil && s.status_error == nil {
+		return &runtime.Status{State: runtime.StateRunning}, nil
+	}
+	return s.status_return, s.status_error
+}
+
+func (s *StubRuntime) Logs(deployment_id string, tail int) ([]runtime.LogLine, error) {
+	return s.logs_return, s.logs_error
+}
+
+func (s *StubRuntime) List() ([]string, error) {
+	return s.list_return, s.list_error
+}
+// find_job returns the job matching, callers hold jobs_mu
+func (s *StubApplicationRepository) find_job(match func(job *database.ApplicationDeploymentJob) bool) (*database.ApplicationDeploymentJob, error) {
+	for _, queued := range s.jobs {
+		if match(&queued.job) {
+			return &queued.job, nil
+		}
+	}
+	return nil, errors.New("no rows in result set")
+}
+
+// ClaimDeploymentJob claims the oldest due job like the query would, with
+// stale running jobs taken over and the per application cap applied. Stub
+// jobs carry no organization so its cap isn't.
+func (s *StubApplicationRepository) ClaimDeploymentJob(params database.ClaimApplicationDeploymentJobParams) (*database.ApplicationDeploymentJob, error) {
+	if s.claim_deployment_job_error != nil {
+		return nil, s.claim_deployment_job_error
+	}
+
+	s.jobs_mu.Lock()
+	defer s.jobs_mu.Unlock()
+
+	now := time.Now()
+	stale_before := now.Add(-time.Duration(params.LockTimeoutSeconds) * time.Second)
+	is_stale := func(job *database.ApplicationDeploymentJob) bool {
+		return job.Status == JobRunning && job.LockedAt.Time.Before(stale_before)
+	}
+
+	job, err := s.find_job(func(job *database.ApplicationDeploymentJob) bool {
+		due := job.Status == JobQueued && !job.RunAfter.Time.After(now)
+		if !due && !is_stale(job) {
+			return false
+		}
+
+		running := int32(0)
+		for _, other := range s.jobs {
+			if other.job.AppID == job.AppID && other.job.Status == JobRunning && !is_stale(&other.job) {
+				running += 1
+			}
+		}
+		return running < params.AppConcurrency
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	job.Status = JobRunning
+	job.Attempts += 1
+	job.LockedBy = pgtype.Text{String: params.LockedBy, Valid: true}
+	job.LockedAt = pgtype.Timestamp{Time: now, Valid: true}
+	claimed := *job
+	return &claimed, nil
+}
+
+func (s *StubApplicationRepository) HeartbeatDeploymentJob(params database.HeartbeatApplicationDeploymentJobParams) (*database.ApplicationDeploymentJob, error) {
+	s.jobs_mu.Lock()
+	defer s.jobs_mu.Unlock()
+
+	job, err := s.find_job(func(job *database.ApplicationDeploymentJob) bool {
+		return job.AppDpJobID == params.AppDpJobID && job.Status == JobRunning && job.LockedBy.String == params.LockedBy
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	job.LockedAt = pgtype.Timestamp{Time: time.Now(), Valid: true}
+	current := *job
+	return &current, nil
+}
+
+func (s *StubApplicationRepository) RetryDeploymentJob(params database.RetryApplicationDeploymentJobParams) (*database.ApplicationDeploymentJob, error) {
+	s.jobs_mu.Lock()
+	defer s.jobs_mu.Unlock()
+
+	job, err := s.find_job(func(job *database.ApplicationDeploymentJob) bool {
+		return job.AppDpJobID == params.AppDpJobID && job.Status == JobRunning && job.LockedBy.String == params.LockedBy
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	job.Status = JobQueued
+	job.RunAfter = params.RunAfter
+	job.LastError = params.LastError
+	job.LockedBy = pgtype.Text{}
+	job.LockedAt = pgtype.Timestamp{}
+	current := *job
+	return &current, nil
+}
+
+func (s *StubApplicationRepository) FinishDeploymentJob(params database.FinishApplicationDeploymentJobParams) (*database.ApplicationDeploymentJob, error) {
+	s.jobs_mu.Lock()
+	defer s.jobs_mu.Unlock()
+
+	job, err := s.find_job(func(job *database.ApplicationDeploymentJob) bool {
+		return job.AppDpJobID == params.AppDpJobID && job.Status == JobRunning && job.LockedBy.String == params.LockedBy
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	job.Status = params.Status
+	job.LastError = params.LastError
+	current := *job
+	return &current, nil
+}
+
+func (s *StubApplicationRepository) CancelQueuedDeploymentJob(app_dp_id pgtype.UUID) (*database.ApplicationDeploymentJob, error) {
+	s.jobs_mu.Lock()
+	defer s.jobs_mu.Unlock()
+
+	job, err := s.find_job(func(job *database.ApplicationDeploymentJob) bool {
+		return job.AppDpID == app_dp_id && job.Status == JobQueued
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	job.Status = JobCancelled
+	job.CancelRequested = true
+	current := *job
+	return &current, nil
+}
+
+func (s *StubApplicationRepository) RequestDeploymentJobCancel(app_dp_id pgtype.UUID) (*database.ApplicationDeploymentJob, error) {
+	s.jobs_mu.Lock()
+	defer s.jobs_mu.Unlock()
+
+	job, err := s.find_job(func(job *database.ApplicationDeploymentJob) bool {
+		return job.AppDpID == app_dp_id && job.Status == JobRunning
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	job.CancelRequested = true
+	current := *job
+	return &current, nil
+}
+
+func (s *StubApplicationRepository) UpdateSchedule(params database.UpdateApplicationScheduleParams) (*database.Application, error) {
+	s.update_schedule_call_args = append(s.update_schedule_call_args, params)
+	return s.update_schedule_return, s.update_schedule_error
+}
+
+func (s *StubApplicationRepository) FindDueSchedules(now pgtype.Timestamp) ([]database.Application, error) {
+	return s.find_due_schedules_return, s.find_due_schedules_error
+}
+
+func (s *StubApplicationRepository) ClaimScheduleRun(params database.ClaimApplicationScheduleRunParams) (*database.Application, error) {
+	s.claim_schedule_run_call_args = append(s.claim_schedule_run_call_args, params)
+	return s.claim_schedule_run_return, s.claim_schedule_run_error
+}
+
+// StartJobRun records the run with an id of its own like the insert would
+func (s *StubApplicationRepository) StartJobRun(params database.StartApplicationJobRunParams) (*database.ApplicationJobRun, error) {
+	s.start_job_run_call_args = append(s.start_job_run_call_args, params)
+	if s.start_job_run_error != nil {
+		return nil, s.start_job_run_error
+	}
+
+	run_uuid, err := new_deployment_uuid()
+	if err != nil {
+		return nil, err
+	}
+	return &database.ApplicationJobRun{
+		AppRunID: run_uuid,
+		AppID: params.AppID,
+		AppDpID: params.AppDpID,
+		Status: JobRunRunning,
+		Command: params.Command,
+		ScheduledAt: params.ScheduledAt,
+		StartedAt: pgtype.Timestamp{Time: time.Now(), Valid: true},
+	}, nil
+}
+
+func (s *StubApplicationRepository) CreateJobRun(params database.CreateApplicationJobRunParams) (*database.ApplicationJobRun, error) {
+	s.create_job_run_call_args = append(s.create_job_run_call_args, params)
+	return &database.ApplicationJobRun{
+		AppID: params.AppID,
+		AppDpID: params.AppDpID,
+		Status: params.Status,
+		Command: params.Command,
+		ScheduledAt: params.ScheduledAt,
+		FailureReason: params.FailureReason,
+	}, nil
+}
+
+func (s *StubApplicationRepository) FinishJobRun(params database.FinishApplicationJobRunParams) (*database.ApplicationJobRun, error) {
+	s.finish_job_run_call_args = append(s.finish_job_run_call_args, params)
+	return &database.ApplicationJobRun{
+		AppRunID: params.AppRunID,
+		Status: params.Status,
+		ExitCode: params.ExitCode,
+		FailureReason: params.FailureReason,
+	}, nil
+}
+
+func (s *StubApplicationRepository) FindJobRuns(params database.FindApplicationJobRunsParams) ([]database.ApplicationJobRun, error) {
+	s.find_job_runs_call_args = append(s.find_job_runs_call_args, params)
+	return s.find_job_runs_return, s.find_job_runs_error
+}
+
+func (s *StubApplicationRepository) FindJobRunsByStatus(status string) ([]database.ApplicationJobRun, error) {
+	return s.find_job_runs_by_status_return, s.find_job_runs_by_status_error
+}
+
+func (s *StubApplicationRepository) FindOneJobRun(params database.FindOneApplicationJobRunParams) (*database.ApplicationJobRun, error) {
+	return s.find_one_job_run_return, s.find_one_job_run_error
+}
+
+func (s *StubApplicationRepository) CreateJobRunLog(params database.CreateApplicationJobRunLogParams) (*database.ApplicationJobRunLog, error) {
+	s.create_job_run_log_call_args = append(s.create_job_run_log_call_args, params)
+	return &database.ApplicationJobRunLog{
+		AppRunLogID: int64(len(s.create_job_run_log_call_args)),
+		AppRunID: params.AppRunID,
+		Stream: params.Stream,
+		Line: params.Line,
+		LoggedAt: params.LoggedAt,
+	}, nil
+}
+
+func (s *StubApplicationRepository) FindJobRunLogs(params database.FindApplicationJobRunLogsParams) ([]database.ApplicationJobRunLog, error) {
+	s.find_job_run_logs_call_args = append(s.find_job_run_logs_call_args, params)
+	return s.find_job_run_logs_return, s.find_job_run_logs_error
+}
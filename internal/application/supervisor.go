@@ -0,0 +1,343 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/salmanrf/capybara-cloud/internal/database"
+	"github.com/salmanrf/capybara-cloud/internal/runtime"
+)
+
+const (
+	HealthUnknown = "unknown"
+	HealthHealthy = "healthy"
+	HealthUnhealthy = "unhealthy"
+)
+
+const EventDeploymentRestarted = "deployment_restarted"
+
+type supervision_options struct {
+	// first restart waits restart_backoff, every next one twice as long
+	restart_backoff time.Duration
+	max_restart_backoff time.Duration
+	// restarts in a row before a deployment is marked crash_looping
+	crash_loop_threshold int32
+	// a deployment up this long has its restart count reset
+	stable_after time.Duration
+	// how long a deployment replacing a serving one gets to pass its health
+	// check, probing every health_gate_interval
+	health_gate_timeout time.Duration
+	health_gate_interval time.Duration
+	// how long a replaced deployment keeps running for requests in flight
+	drain_grace time.Duration
+	// how often a scheduled run is checked for having exited
+	run_poll_interval time.Duration
+}
+
+func default_supervision_options() supervision_options {
+	return supervision_options{
+		restart_backoff: time.Second,
+		max_restart_backoff: 5 * time.Minute,
+		crash_loop_threshold: 5,
+		stable_after: 10 * time.Minute,
+		health_gate_timeout: time.Minute,
+		health_gate_interval: time.Second,
+		drain_grace: 30 * time.Second,
+		run_poll_interval: time.Second,
+	}
+}
+
+func (o supervision_options) backoff(restarts int32) time.Duration {
+	backoff := o.restart_backoff
+	for i := int32(1); i < restarts && backoff < o.max_restart_backoff; i++ {
+		backoff *= 2
+	}
+
+	return min(backoff, o.max_restart_backoff)
+}
+
+// supervisor keeps one watch goroutine per serving deployment
+type supervisor struct {
+	ctx context.Context
+	mu sync.Mutex
+	watches map[string]context.CancelFunc
+}
+
+func new_supervisor(ctx context.Context) *supervisor {
+	return &supervisor{
+		ctx: ctx,
+		watches: make(map[string]context.CancelFunc),
+	}
+}
+
+func (sv *supervisor) watch(dp_id string, run func(ctx context.Context)) {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+
+	if cancel, ok := sv.watches[dp_id]; ok {
+		cancel()
+	}
+
+	ctx, cancel := context.WithCancel(sv.ctx)
+	sv.watches[dp_id] = cancel
+
+	go run(ctx)
+}
+
+func (sv *supervisor) unwatch(dp_id string) {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+
+	if cancel, ok := sv.watches[dp_id]; ok {
+		cancel()
+		delete(sv.watches, dp_id)
+	}
+}
+
+func (sv *supervisor) watching(dp_id string) bool {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+
+	_, ok := sv.watches[dp_id]
+	return ok
+}
+
+type deployment_watch struct {
+	deployment *database.ApplicationDeployment
+	app *database.Application
+	spec runtime.Spec
+	probe *runtime.Probe
+	failures int32
+	up_since time.Time
+}
+
+// watch_deployment starts supervising a deployment that just reached running
+func (s *service) watch_deployment(deployment *database.ApplicationDeployment) {
+	w, err := s.new_deployment_watch(deployment)
+	if err != nil {
+		fmt.Println("Error at application_service.watch_deployment: ", err.Error())
+		return
+	}
+	// there is no process to supervise, cron runs are awaited by the scheduler
+	if builds_only(w.app) {
+		return
+	}
+
+	s.supervisor.watch(deployment.AppDpID.String(), func(ctx context.Context) {
+		s.supervise(ctx, w)
+	})
+	s.run_processes(w.deployment, w.app, w.spec)
+}
+
+func (s *service) new_deployment_watch(deployment *database.ApplicationDeployment) (*deployment_watch, error) {
+	app, err := s.repository.FindOne(deployment.AppID)
+	if err != nil {
+		return nil, err
+	}
+	if app == nil {
+		app = &database.Application{}
+	}
+
+	spec, err := s.runtime_spec(deployment)
+	if err != nil {
+		return nil, err
+	}
+
+	// workers are only watched for their process exiting
+	var probe *runtime.Probe
+	if listens_on_port(app.Type) {
+		timeout := time.Duration(max(app.HealthCheckTimeoutSeconds, 1)) * time.Second
+		probe = runtime.NewProbe(app.HealthCheckType, app.HealthCheckPath, timeout, spec)
+	}
+	tracked := *deployment
+
+	return &deployment_watch{
+		deployment: &tracked,
+		app: app,
+		spec: spec,
+		probe: probe,
+		up_since: time.Now(),
+	}, nil
+}
+
+func (s *service) supervise(ctx context.Context, w *deployment_watch) {
+	interval := time.Duration(max(w.app.HealthCheckIntervalSeconds, 1)) * time.Second
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		reason := s.check_deployment(ctx, w)
+		if reason == "" {
+			continue
+		}
+		if !s.restart_supervised(ctx, w, reason) {
+			return
+		}
+	}
+}
+
+// check_deployment runs one liveness and health check round and returns why
+// the deployment needs a restart, or "" when it doesn't.
+func (s *service) check_deployment(ctx context.Context, w *deployment_watch) string {
+	dp_id := w.deployment.AppDpID.String()
+
+	status, err := s.runtime.Status(dp_id)
+	if err != nil {
+		return "status check failed: " + err.Error()
+	}
+	if status.State != runtime.StateRunning {
+		return exit_reason(status, w.app)
+	}
+
+	if w.probe != nil {
+		if err := w.probe.Check(ctx); err != nil {
+			w.failures += 1
+			s.record_health(w, HealthUnhealthy, err.Error())
+
+			threshold := max(w.app.HealthCheckFailureThreshold, 1)
+			if w.failures >= threshold {
+				return fmt.Sprintf("failed %d consecutive health checks: %s", w.failures, err.Error())
+			}
+			return ""
+		}
+
+		w.failures = 0
+		s.record_health(w, HealthHealthy, "")
+	}
+
+	if w.deployment.RestartCount > 0 && time.Since(w.up_since) >= s.supervision.stable_after {
+		s.settle_deployment(w)
+	}
+
+	return ""
+}
+
+// exit_reason tells why the process of a deployment of app isn't running
+func exit_reason(status *runtime.Status, app *database.Application) string {
+	if status.OOMKilled {
+		return fmt.Sprintf("oom_killed: exceeded the %d MB memory limit", app.MemoryMb)
+	}
+
+	return fmt.Sprintf("process exited with code %d", status.ExitCode)
+}
+
+func (s *service) record_health(w *deployment_watch, health string, message string) {
+	_, err := s.repository.UpdateDeploymentHealth(
+		database.UpdateApplicationDeploymentHealthParams{
+			AppDpID: w.deployment.AppDpID,
+			HealthStatus: health,
+			HealthMessage: pgtype.Text{String: message, Valid: message != ""},
+			HealthConsecutiveFailures: w.failures,
+		},
+	)
+	if err != nil {
+		fmt.Println("Error at application_service.record_health: ", err.Error())
+	}
+}
+
+// settle_deployment forgets past restarts once the deployment stayed up,
+// so a crash days later starts again from the shortest backoff.
+func (s *service) settle_deployment(w *deployment_watch) {
+	_, err := s.repository.UpdateDeploymentRestarts(
+		database.UpdateApplicationDeploymentRestartsParams{
+			AppDpID: w.deployment.AppDpID,
+			RestartCount: 0,
+		},
+	)
+	if err != nil {
+		fmt.Println("Error at application_service.settle_deployment: ", err.Error())
+		return
+	}
+	w.deployment.RestartCount = 0
+
+	if w.deployment.Status == DeploymentCrashLooping {
+		recovered, err := s.transition_deployment(w.deployment, DeploymentRunning, "stable for "+s.supervision.stable_after.String())
+		if err != nil {
+			fmt.Println("Error at application_service.settle_deployment: ", err.Error())
+			return
+		}
+		w.deployment.Status = recovered.Status
+	}
+}
+
+// restart_supervised restarts the deployment's process after a backoff that
+// grows with every restart in a row. It returns false once the watch is
+// cancelled, i.e. the deployment was stopped or replaced meanwhile.
+func (s *service) restart_supervised(ctx context.Context, w *deployment_watch, reason string) bool {
+	dp_id := w.deployment.AppDpID.String()
+	restarts := w.deployment.RestartCount + 1
+
+	if restarts >= s.supervision.crash_loop_threshold && w.deployment.Status == DeploymentRunning {
+		looping, err := s.transition_deployment(
+			w.deployment,
+			DeploymentCrashLooping,
+			fmt.Sprintf("restarted %d times, last: %s", restarts, reason),
+		)
+		if err != nil {
+			fmt.Println("Error at application_service.restart_supervised: ", err.Error())
+			return false
+		}
+		w.deployment.Status = looping.Status
+	}
+
+	s.repository.CreateEvent(
+		database.CreateApplicationEventParams{
+			AppID: w.deployment.AppID,
+			AppDpID: w.deployment.AppDpID,
+			Kind: EventDeploymentRestarted,
+			Message: pgtype.Text{String: fmt.Sprintf("restart %d: %s", restarts, reason), Valid: true},
+		},
+	)
+
+	// an unhealthy process may still be alive
+	if err := s.runtime.Stop(dp_id); err != nil && err.Error() != "not_found" {
+		fmt.Println("Error at application_service.restart_supervised - stopping: ", err.Error())
+	}
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(s.supervision.backoff(restarts)):
+	}
+
+	_, err := s.repository.UpdateDeploymentRestarts(
+		database.UpdateApplicationDeploymentRestartsParams{
+			AppDpID: w.deployment.AppDpID,
+			RestartCount: restarts,
+			LastRestartedAt: pgtype.Timestamp{Time: time.Now(), Valid: true},
+		},
+	)
+	if err != nil {
+		fmt.Println("Error at application_service.restart_supervised: ", err.Error())
+	}
+	w.deployment.RestartCount = restarts
+	w.failures = 0
+	w.up_since = time.Now()
+
+	instance, err := s.runtime.Start(w.spec)
+	if err != nil {
+		// counted as another crash on the next check
+		fmt.Println("Error at application_service.restart_supervised - starting: ", err.Error())
+		return true
+	}
+	if ctx.Err() != nil {
+		s.runtime.Stop(dp_id)
+		return false
+	}
+
+	if err := s.record_process(w.deployment, instance); err != nil {
+		fmt.Println("Error at application_service.restart_supervised: ", err.Error())
+	}
+
+	return true
+}
@@ -0,0 +1,234 @@
+package application
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/salmanrf/capybara-cloud/internal/database"
+	"github.com/salmanrf/capybara-cloud/internal/webhooks"
+	"github.com/salmanrf/capybara-cloud/pkg/dto"
+)
+
+// UpdateWebhook creates or reconfigures the application's push webhook. The
+// secret is only part of the response when it was just generated.
+func (s *service) UpdateWebhook(app_id string, user_id string, webhook_dto dto.UpdateApplicationWebhookDto) (*dto.ApplicationWebhookResponse, error) {
+	app_with_pm, err := s.find_member_app(app_id, user_id)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := ""
+	existing, err := s.repository.FindOneWebhook(app_with_pm.AppID)
+	if err != nil && !strings.Contains(err.Error(), "no rows") {
+		return nil, err
+	}
+	if err == nil && !webhook_dto.RotateSecret {
+		secret = existing.Secret
+	}
+
+	generated := secret == ""
+	if generated {
+		if secret, err = webhooks.GenerateSecret(); err != nil {
+			return nil, err
+		}
+	}
+
+	webhook, err := s.repository.UpsertWebhook(
+		database.UpsertApplicationWebhookParams{
+			AppID: app_with_pm.AppID,
+			GitUrl: webhook_dto.GitURL,
+			Branch: webhook_dto.Branch,
+			Secret: secret,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return dto.NewApplicationWebhookResponse(*webhook, generated), nil
+}
+
+func (s *service) find_member_webhook(app_id string, user_id string) (*database.ApplicationWebhook, error) {
+	app_with_pm, err := s.find_member_app(app_id, user_id)
+	if err != nil {
+		return nil, err
+	}
+
+	webhook, err := s.repository.FindOneWebhook(app_with_pm.AppID)
+	if err != nil {
+		if strings.Contains(err.Error(), "no rows") {
+			return nil, errors.New("not_found")
+		}
+		return nil, err
+	}
+
+	return webhook, nil
+}
+
+func (s *service) FindOneWebhook(app_id string, user_id string) (*dto.ApplicationWebhookResponse, error) {
+	webhook, err := s.find_member_webhook(app_id, user_id)
+	if err != nil {
+		return nil, err
+	}
+
+	return dto.NewApplicationWebhookResponse(*webhook, false), nil
+}
+
+// FindWebhookDeliveries returns the most recent deliveries, newest first
+func (s *service) FindWebhookDeliveries(app_id string, user_id string) ([]database.ApplicationWebhookDelivery, error) {
+	webhook, err := s.find_member_webhook(app_id, user_id)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries, err := s.repository.FindWebhookDeliveries(
+		database.FindApplicationWebhookDeliveriesParams{
+			AppWhID: webhook.AppWhID,
+			Limit: dto.DefaultWebhookDeliveriesLimit,
+		},
+	)
+	if err != nil && !strings.Contains(err.Error(), "no rows") {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// ReceiveWebhook verifies an inbound delivery and deploys the pushed commit
+// when it is for the configured branch. Every delivery to a configured
+// webhook is recorded with its outcome, including rejected ones. The
+// deployment is queued, git hosts don't wait for builds.
+func (s *service) ReceiveWebhook(app_id string, delivery_dto dto.ReceiveApplicationWebhookDto) (*database.ApplicationWebhookDelivery, error) {
+	app_uuid := pgtype.UUID{}
+	if err := app_uuid.Scan(app_id); err != nil {
+		return nil, errors.New("not_found")
+	}
+
+	webhook, err := s.repository.FindOneWebhook(app_uuid)
+	if err != nil {
+		if strings.Contains(err.Error(), "no rows") {
+			return nil, errors.New("not_found")
+		}
+		return nil, err
+	}
+
+	params := database.CreateApplicationWebhookDeliveryParams{
+		AppWhID: webhook.AppWhID,
+	}
+
+	delivery, err := webhooks.Parse(delivery_dto.Header, delivery_dto.Body, webhook.Secret)
+	if delivery != nil {
+		params.Provider = pgtype.Text{String: string(delivery.Provider), Valid: true}
+		params.Event = pgtype.Text{String: delivery.Event, Valid: delivery.Event != ""}
+		params.DeliveryID = pgtype.Text{String: delivery.DeliveryID, Valid: delivery.DeliveryID != ""}
+		if delivery.Push != nil {
+			params.Ref = pgtype.Text{String: delivery.Push.Ref, Valid: true}
+			params.CommitSha = pgtype.Text{String: delivery.Push.CommitSHA, Valid: !delivery.Push.Deleted}
+		}
+	}
+
+	var deployment *database.ApplicationDeployment
+
+	switch {
+	case err != nil && err.Error() == "invalid_payload":
+		params.Verified = true
+		params.Result = dto.WebhookDeliveryRejected
+		params.Message = pgtype.Text{String: err.Error(), Valid: true}
+	case err != nil:
+		params.Result = dto.WebhookDeliveryRejected
+		params.Message = pgtype.Text{String: err.Error(), Valid: true}
+	default:
+		params.Verified = true
+		deployment, err = s.deploy_push(webhook, delivery, &params)
+		if err != nil {
+			fmt.Println("Error at application_service.ReceiveWebhook - deploying: ", err.Error())
+			params.Result = dto.WebhookDeliveryFailed
+			params.Message = pgtype.Text{String: err.Error(), Valid: true}
+		}
+	}
+
+	recorded, err := s.repository.CreateWebhookDelivery(params)
+	if err != nil {
+		fmt.Println("Error at application_service.ReceiveWebhook - recording delivery: ", err.Error())
+	}
+
+	if deployment != nil {
+		s.jobs.notify()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return recorded, nil
+}
+
+// deploy_push decides what a verified delivery leads to and records it on
+// params. It returns the created deployment when the push is deployed.
+func (s *service) deploy_push(webhook *database.ApplicationWebhook, delivery *webhooks.Delivery, params *database.CreateApplicationWebhookDeliveryParams) (*database.ApplicationDeployment, error) {
+	ignore := func(message string) (*database.ApplicationDeployment, error) {
+		params.Result = dto.WebhookDeliveryIgnored
+		params.Message = pgtype.Text{String: message, Valid: true}
+		return nil, nil
+	}
+
+	push := delivery.Push
+	switch {
+	case push == nil:
+		return ignore("not a push event: " + delivery.Event)
+	case push.Branch != webhook.Branch:
+		return ignore(fmt.Sprintf("push to %s, deploying from branch %s", push.Ref, webhook.Branch))
+	case push.Deleted:
+		return ignore("branch " + push.Branch + " was deleted")
+	}
+
+	app, err := s.repository.FindOne(webhook.AppID)
+	if err != nil {
+		return nil, err
+	}
+	if app.DesiredState == DesiredStateStopped {
+		return ignore("application is stopped")
+	}
+
+	variables_snapshot := []byte("{}")
+	stack := pgtype.Text{}
+	config, err := s.repository.FindConfig(webhook.AppID)
+	if err != nil && !strings.Contains(err.Error(), "no rows") {
+		return nil, err
+	}
+	if err == nil && len(config.VariablesJson) > 0 {
+		variables_snapshot = config.VariablesJson
+	}
+	if err == nil {
+		stack = config.Stack
+	}
+
+	// deploy the pushed commit, not the branch, it may have moved on already
+	deployment, err := s.create_deployment(
+		webhook.AppID,
+		variables_snapshot,
+		stack,
+		dto.CreateApplicationDeploymentDto{GitURL: webhook.GitUrl, GitRef: push.CommitSHA},
+		fmt.Sprintf("%s push of %s to %s", delivery.Provider, short_sha(push.CommitSHA), push.Branch),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	params.Result = dto.WebhookDeliveryDeployed
+	params.Message = pgtype.Text{String: "deploying " + push.CommitSHA, Valid: true}
+	params.AppDpID = deployment.AppDpID
+
+	return deployment, nil
+}
+
+func short_sha(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+
+	return sha
+}
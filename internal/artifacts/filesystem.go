@@ -0,0 +1,153 @@
+package artifacts
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// digests are kept next to the objects so Stat doesn't reread them
+const hash_suffix = ".sha256"
+
+type filesystem_store struct {
+	dir string
+}
+
+func NewFileSystemStore(dir string) (ArtifactStore, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+
+	return &filesystem_store{
+		dir: dir,
+	}, nil
+}
+
+func (s *filesystem_store) path(key string) (string, error) {
+	if err := validate_key(key); err != nil {
+		return "", err
+	}
+
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
+
+func (s *filesystem_store) Put(key string, body io.Reader) (*Object, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return nil, err
+	}
+
+	// written aside first so readers never see a partial object
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	reader := new_hashing_reader(body)
+	if _, err := io.Copy(tmp, reader); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(tmp.Name(), 0o640); err != nil {
+		return nil, err
+	}
+
+	if err := os.WriteFile(path+hash_suffix, []byte(reader.sum()), 0o640); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return nil, err
+	}
+
+	return s.Stat(key)
+}
+
+func (s *filesystem_store) Get(key string) (io.ReadCloser, *Object, error) {
+	object, err := s.Stat(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	path, _ := s.path(key)
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return file, object, nil
+}
+
+func (s *filesystem_store) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range []string{path, path + hash_suffix} {
+		if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *filesystem_store) Stat(key string) (*Object, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, errors.New("not_found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	digest, err := os.ReadFile(path + hash_suffix)
+	if err != nil {
+		if digest, err = file_sha256(path); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Object{
+		Key: key,
+		Size: info.Size(),
+		SHA256: strings.TrimSpace(string(digest)),
+		ModifiedAt: info.ModTime(),
+	}, nil
+}
+
+func file_sha256(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return nil, err
+	}
+
+	return []byte(hex.EncodeToString(hash.Sum(nil))), nil
+}
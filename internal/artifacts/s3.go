@@ -0,0 +1,179 @@
+package artifacts
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Config points at an S3-compatible bucket. Requests use path style
+// addressing, which MinIO and most stand-ins expect.
+type S3Config struct {
+	Endpoint string
+	Region string
+	Bucket string
+	AccessKeyID string
+	SecretAccessKey string
+}
+
+// the digest is kept as object metadata since ETags aren't content hashes
+// for multipart uploads
+const s3_sha256_header = "X-Amz-Meta-Sha256"
+
+type s3_store struct {
+	config S3Config
+	endpoint *url.URL
+	client *http.Client
+}
+
+func NewS3Store(config S3Config) (ArtifactStore, error) {
+	endpoint, err := url.Parse(strings.TrimSuffix(config.Endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if endpoint.Scheme == "" || endpoint.Host == "" || config.Bucket == "" {
+		return nil, errors.New("invalid_s3_config")
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+
+	return &s3_store{
+		config: config,
+		endpoint: endpoint,
+		client: &http.Client{Timeout: 10 * time.Minute},
+	}, nil
+}
+
+func (s *s3_store) request(method string, key string, body io.Reader, size int64, payload_sha256 string) (*http.Response, error) {
+	if err := validate_key(key); err != nil {
+		return nil, err
+	}
+
+	target := *s.endpoint
+	target.Path = s.endpoint.Path + "/" + s.config.Bucket + "/" + key
+	target.RawPath = aws_escape(target.Path, true)
+
+	req, err := http.NewRequest(method, target.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set(s3_sha256_header, payload_sha256)
+	}
+	sign_v4(req, payload_sha256, s.config.AccessKeyID, s.config.SecretAccessKey, s.config.Region, time.Now())
+
+	return s.client.Do(req)
+}
+
+func s3_error(res *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+
+	return fmt.Errorf("artifact store returned %d: %s", res.StatusCode, strings.TrimSpace(string(body)))
+}
+
+func s3_object(key string, res *http.Response) *Object {
+	size, _ := strconv.ParseInt(res.Header.Get("Content-Length"), 10, 64)
+	modified_at, _ := http.ParseTime(res.Header.Get("Last-Modified"))
+
+	return &Object{
+		Key: key,
+		Size: size,
+		SHA256: res.Header.Get(s3_sha256_header),
+		ModifiedAt: modified_at,
+	}
+}
+
+// Put spools the body to a temporary file first, the signature covers the
+// payload digest and S3 needs the length up front.
+func (s *s3_store) Put(key string, body io.Reader) (*Object, error) {
+	spool, err := os.CreateTemp("", "artifact-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	reader := new_hashing_reader(body)
+	if _, err := io.Copy(spool, reader); err != nil {
+		return nil, err
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	res, err := s.request(http.MethodPut, key, spool, reader.size, reader.sum())
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, s3_error(res)
+	}
+
+	return &Object{
+		Key: key,
+		Size: reader.size,
+		SHA256: reader.sum(),
+		ModifiedAt: time.Now(),
+	}, nil
+}
+
+func (s *s3_store) Get(key string) (io.ReadCloser, *Object, error) {
+	res, err := s.request(http.MethodGet, key, nil, 0, empty_payload_sha256)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		return res.Body, s3_object(key, res), nil
+	case http.StatusNotFound:
+		res.Body.Close()
+		return nil, nil, errors.New("not_found")
+	}
+
+	defer res.Body.Close()
+	return nil, nil, s3_error(res)
+}
+
+func (s *s3_store) Delete(key string) error {
+	res, err := s.request(http.MethodDelete, key, nil, 0, empty_payload_sha256)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	}
+
+	return s3_error(res)
+}
+
+func (s *s3_store) Stat(key string) (*Object, error) {
+	res, err := s.request(http.MethodHead, key, nil, 0, empty_payload_sha256)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		return s3_object(key, res), nil
+	case http.StatusNotFound:
+		return nil, errors.New("not_found")
+	}
+
+	return nil, fmt.Errorf("artifact store returned %d", res.StatusCode)
+}
@@ -0,0 +1,140 @@
+package artifacts
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	sigv4_algorithm = "AWS4-HMAC-SHA256"
+	sigv4_service = "s3"
+	empty_payload_sha256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// aws_escape percent-encodes everything but the unreserved characters, as
+// the canonical request requires.
+func aws_escape(value string, keep_slash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(value) {
+		switch {
+		case (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9'),
+			c == '-', c == '_', c == '.', c == '~',
+			keep_slash && c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+func canonical_query(query url.Values) string {
+	pairs := []string{}
+	for key, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, aws_escape(key, false)+"="+aws_escape(value, false))
+		}
+	}
+	slices.Sort(pairs)
+
+	return strings.Join(pairs, "&")
+}
+
+func signed_header(name string) bool {
+	return name == "host" || name == "range" || name == "content-type" || name == "content-md5" || strings.HasPrefix(name, "x-amz-")
+}
+
+func canonical_headers(req *http.Request) (string, string) {
+	values := map[string]string{"host": req.Host}
+	if req.Host == "" {
+		values["host"] = req.URL.Host
+	}
+	for name, vals := range req.Header {
+		name = strings.ToLower(name)
+		if !signed_header(name) {
+			continue
+		}
+		trimmed := []string{}
+		for _, val := range vals {
+			trimmed = append(trimmed, strings.Join(strings.Fields(val), " "))
+		}
+		values[name] = strings.Join(trimmed, ",")
+	}
+
+	names := []string{}
+	for name := range values {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name + ":" + values[name] + "\n")
+	}
+
+	return b.String(), strings.Join(names, ";")
+}
+
+func hmac_sha256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+
+	return mac.Sum(nil)
+}
+
+func sha256_hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// sign_v4 adds the AWS Signature Version 4 headers to req. The request path
+// must already be escaped the way aws_escape does.
+func sign_v4(req *http.Request, payload_sha256 string, access_key string, secret_key string, region string, now time.Time) {
+	amz_date := now.UTC().Format("20060102T150405Z")
+	date := amz_date[:8]
+
+	req.Header.Set("X-Amz-Date", amz_date)
+	req.Header.Set("X-Amz-Content-Sha256", payload_sha256)
+
+	headers, signed_headers := canonical_headers(req)
+	canonical_request := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonical_query(req.URL.Query()),
+		headers,
+		signed_headers,
+		payload_sha256,
+	}, "\n")
+
+	scope := strings.Join([]string{date, region, sigv4_service, "aws4_request"}, "/")
+	string_to_sign := strings.Join([]string{
+		sigv4_algorithm,
+		amz_date,
+		scope,
+		sha256_hex(canonical_request),
+	}, "\n")
+
+	key := hmac_sha256([]byte("AWS4"+secret_key), date)
+	key = hmac_sha256(key, region)
+	key = hmac_sha256(key, sigv4_service)
+	key = hmac_sha256(key, "aws4_request")
+	signature := hex.EncodeToString(hmac_sha256(key, string_to_sign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigv4_algorithm,
+		access_key,
+		scope,
+		signed_headers,
+		signature,
+	))
+}
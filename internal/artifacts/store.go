@@ -0,0 +1,68 @@
+package artifacts
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"strings"
+	"time"
+)
+
+// Object describes a stored artifact, SHA256 is the hex digest of its content
+type Object struct {
+	Key string
+	Size int64
+	SHA256 string
+	ModifiedAt time.Time
+}
+
+// ArtifactStore keeps deployment bundles off the API host's disk. Keys are
+// slash separated, e.g. {app_id}/{dp_id}/bundle.tar.gz. Missing keys fail
+// with not_found, except on Delete which is idempotent.
+type ArtifactStore interface {
+	Put(key string, body io.Reader) (*Object, error)
+	Get(key string) (io.ReadCloser, *Object, error)
+	Delete(key string) error
+	Stat(key string) (*Object, error)
+}
+
+func validate_key(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.HasSuffix(key, hash_suffix) {
+		return errors.New("invalid_key")
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return errors.New("invalid_key")
+		}
+	}
+
+	return nil
+}
+
+// hashing_reader digests whatever is read through it
+type hashing_reader struct {
+	reader io.Reader
+	hash hash.Hash
+	size int64
+}
+
+func new_hashing_reader(reader io.Reader) *hashing_reader {
+	return &hashing_reader{
+		reader: reader,
+		hash: sha256.New(),
+	}
+}
+
+func (r *hashing_reader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.hash.Write(p[:n])
+	r.size += int64(n)
+
+	return n, err
+}
+
+func (r *hashing_reader) sum() string {
+	return hex.EncodeToString(r.hash.Sum(nil))
+}
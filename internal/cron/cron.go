@@ -0,0 +1,206 @@
+package cron
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron expression. Times are matched in UTC.
+type Schedule struct {
+	minutes uint64
+	hours uint64
+	days uint64
+	months uint64
+	weekdays uint64
+	// when both day fields are restricted a time matching either one fires,
+	// like in crontab(5)
+	any_day bool
+	any_weekday bool
+}
+
+type field struct {
+	name string
+	min int
+	max int
+	names map[string]int
+}
+
+var fields = []field{
+	{"minute", 0, 59, nil},
+	{"hour", 0, 23, nil},
+	{"day of month", 1, 31, nil},
+	{"month", 1, 12, map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}},
+	// 7 is Sunday as well
+	{"day of week", 0, 7, map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}},
+}
+
+var macros = map[string]string{
+	"@yearly": "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly": "0 0 1 * *",
+	"@weekly": "0 0 * * 0",
+	"@daily": "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly": "0 * * * *",
+}
+
+// how far Next looks ahead, far enough for the 29th of February
+const max_lookahead = 5 * 366 * 24 * time.Hour
+
+// Parse reads a five field expression, minute hour day-of-month month
+// day-of-week, or one of the @hourly, @daily, @weekly, @monthly and @yearly
+// macros. Fields take *, values, ranges, lists and /steps. Expressions that
+// never fire, like the 30th of February, are rejected.
+func Parse(expression string) (*Schedule, error) {
+	expression = strings.TrimSpace(expression)
+	if macro, ok := macros[strings.ToLower(expression)]; ok {
+		expression = macro
+	}
+
+	parts := strings.Fields(expression)
+	if len(parts) != len(fields) {
+		return nil, fmt.Errorf("expected %d fields, got %d", len(fields), len(parts))
+	}
+
+	sets := make([]uint64, len(fields))
+	for i, part := range parts {
+		set, err := parse_field(part, fields[i])
+		if err != nil {
+			return nil, err
+		}
+		sets[i] = set
+	}
+
+	// Sunday can be written as 0 or 7
+	if sets[4]&(1<<7) != 0 {
+		sets[4] = sets[4]&^(1<<7) | 1
+	}
+
+	schedule := &Schedule{
+		minutes: sets[0],
+		hours: sets[1],
+		days: sets[2],
+		months: sets[3],
+		weekdays: sets[4],
+		any_day: parts[2] == "*",
+		any_weekday: parts[4] == "*",
+	}
+
+	if schedule.Next(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)).IsZero() {
+		return nil, errors.New("expression never fires")
+	}
+
+	return schedule, nil
+}
+
+func parse_field(part string, f field) (uint64, error) {
+	var set uint64
+
+	for _, item := range strings.Split(part, ",") {
+		value_range, step_text, has_step := strings.Cut(item, "/")
+
+		step := 1
+		if has_step {
+			parsed, err := strconv.Atoi(step_text)
+			if err != nil || parsed < 1 {
+				return 0, fmt.Errorf("invalid step %q in %s", step_text, f.name)
+			}
+			step = parsed
+		}
+
+		low, high := f.min, f.max
+		if value_range != "*" {
+			low_text, high_text, is_range := strings.Cut(value_range, "-")
+
+			var err error
+			if low, err = parse_value(low_text, f); err != nil {
+				return 0, err
+			}
+			high = low
+			if is_range {
+				if high, err = parse_value(high_text, f); err != nil {
+					return 0, err
+				}
+			} else if has_step {
+				// 5/15 means from 5 to the end every 15
+				high = f.max
+			}
+			if low > high {
+				return 0, fmt.Errorf("invalid range %q in %s", value_range, f.name)
+			}
+		}
+
+		for value := low; value <= high; value += step {
+			set |= 1 << value
+		}
+	}
+
+	return set, nil
+}
+
+func parse_value(text string, f field) (int, error) {
+	if value, ok := f.names[strings.ToLower(text)]; ok {
+		return value, nil
+	}
+
+	value, err := strconv.Atoi(text)
+	if err != nil || value < f.min || value > f.max {
+		return 0, fmt.Errorf("invalid %s %q, must be between %d and %d", f.name, text, f.min, f.max)
+	}
+
+	return value, nil
+}
+
+func (s *Schedule) day_matches(t time.Time) bool {
+	day := s.days&(1<<t.Day()) != 0
+	weekday := s.weekdays&(1<<int(t.Weekday())) != 0
+
+	switch {
+	case s.any_day && s.any_weekday:
+		return true
+	case s.any_day:
+		return weekday
+	case s.any_weekday:
+		return day
+	default:
+		return day || weekday
+	}
+}
+
+// Next returns the first time after t the schedule fires at, or the zero
+// time when it doesn't fire within the next five years.
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(max_lookahead)
+
+	for t.Before(limit) {
+		if s.months&(1<<int(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !s.day_matches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if s.hours&(1<<t.Hour()) == 0 {
+			t = t.Truncate(time.Hour).Add(time.Hour)
+			continue
+		}
+		if s.minutes&(1<<t.Minute()) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t
+	}
+
+	return time.Time{}
+}
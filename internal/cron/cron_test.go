@@ -0,0 +1,88 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func utc(value string) time.Time {
+	t, err := time.Parse("2006-01-02 15:04", value)
+	if err != nil {
+		panic(err)
+	}
+
+	return t
+}
+
+func TestParse(t *testing.T) {
+	t.Run("should reject invalid expressions", func (t *testing.T) {
+		tests := []string{
+			"",
+			"* * * *",
+			"* * * * * *",
+			"60 * * * *",
+			"* 24 * * *",
+			"* * 0 * *",
+			"* * * 13 *",
+			"* * * * 8",
+			"*/0 * * * *",
+			"5-1 * * * *",
+			"1,,2 * * * *",
+			"-1 * * * *",
+			"* * * foo *",
+			"@every 5m",
+			"0 0 30 2 *",
+		}
+
+		for _, expression := range tests {
+			if _, err := Parse(expression); err == nil {
+				t.Errorf("got nil error parsing %q, want an error", expression)
+			}
+		}
+	})
+
+	t.Run("should find the next time the schedule fires", func (t *testing.T) {
+		tests := []struct {
+			expression string
+			after string
+			want string
+		}{
+			{"* * * * *", "2026-10-17 10:15", "2026-10-17 10:16"},
+			{"*/15 * * * *", "2026-10-17 10:15", "2026-10-17 10:30"},
+			{"5/20 * * * *", "2026-10-17 10:46", "2026-10-17 11:05"},
+			{"0 9-17/4 * * *", "2026-10-17 13:00", "2026-10-17 17:00"},
+			{"30 2 * * *", "2026-10-17 10:15", "2026-10-18 02:30"},
+			{"@hourly", "2026-10-17 10:15", "2026-10-17 11:00"},
+			{"@daily", "2026-12-31 23:59", "2027-01-01 00:00"},
+			{"0 0 * * mon", "2026-10-17 10:15", "2026-10-19 00:00"},
+			{"0 0 * * 7", "2026-10-17 10:15", "2026-10-18 00:00"},
+			{"0 0 1 jan,jul *", "2026-10-17 10:15", "2027-01-01 00:00"},
+			{"0 0 31 * *", "2026-11-01 00:00", "2026-12-31 00:00"},
+			{"0 0 29 2 *", "2026-10-17 10:15", "2028-02-29 00:00"},
+			// either day field matches when both are restricted
+			{"0 0 1 * fri", "2026-10-17 10:15", "2026-10-23 00:00"},
+		}
+
+		for _, tt := range tests {
+			schedule, err := Parse(tt.expression)
+			if err != nil {
+				t.Errorf("got error parsing %q %v, want nil", tt.expression, err)
+				continue
+			}
+
+			got := schedule.Next(utc(tt.after))
+			if !got.Equal(utc(tt.want)) {
+				t.Errorf("got %q firing after %s at %s, want %s", tt.expression, tt.after, got, tt.want)
+			}
+		}
+	})
+
+	t.Run("should fire after the minute it is asked at", func (t *testing.T) {
+		schedule, _ := Parse("* * * * *")
+
+		got := schedule.Next(utc("2026-10-17 10:15").Add(30 * time.Second))
+		if !got.Equal(utc("2026-10-17 10:16")) {
+			t.Errorf("got %s, want the start of the next minute", got)
+		}
+	})
+}
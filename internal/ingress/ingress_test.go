@@ -0,0 +1,501 @@
+package ingress
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/salmanrf/capybara-cloud/pkg/dto"
+)
+
+type stub_route_source struct {
+	mu sync.Mutex
+	routes []dto.IngressRoute
+	err error
+	changes chan struct{}
+	traffic []dto.CanaryTraffic
+}
+
+func (s *stub_route_source) FindIngressRoutes() ([]dto.IngressRoute, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.routes, s.err
+}
+
+func (s *stub_route_source) set_routes(routes []dto.IngressRoute) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.routes = routes
+}
+
+func (s *stub_route_source) WatchDeploymentChanges() (<-chan struct{}, func()) {
+	return s.changes, func() {}
+}
+
+func (s *stub_route_source) RecordCanaryTraffic(traffic []dto.CanaryTraffic) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.traffic = append(s.traffic, traffic...)
+	return nil
+}
+
+func TestHost(t *testing.T) {
+	cases := map[string][3]string{
+		"web.shop.apps.localhost": {"web", "shop", "apps.localhost"},
+		"my-api.acme-corp.apps.localhost": {"My API", "Acme  Corp!", "Apps.Localhost"},
+		"worker-2.p.example.com": {"worker_2", "-p-", "example.com"},
+	}
+
+	for want, args := range cases {
+		if got := Host(args[0], args[1], args[2]); got != want {
+			t.Errorf("got host %s, want %s", got, want)
+		}
+	}
+}
+
+func TestTable(t *testing.T) {
+	t.Run("should route hosts to the port of their app", func (t *testing.T) {
+		source := &stub_route_source{
+			routes: []dto.IngressRoute{
+				{AppID: "app-1", AppName: "web", ProjectName: "shop", AppSlug: "web", ProjectSlug: "shop", DeploymentID: "dp-1", Port: "3000"},
+				{AppID: "app-2", AppName: "api", ProjectName: "shop", AppSlug: "api", ProjectSlug: "shop", DeploymentID: "dp-2", Port: "3001"},
+			},
+		}
+		table := NewTable(source, "apps.localhost")
+		if err := table.Refresh(); err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		route, ok := table.Lookup("api.shop.apps.localhost")
+		if !ok || route.Port != "3001" {
+			t.Errorf("got route %+v, %v, want port 3001", route, ok)
+		}
+		if _, ok := table.Lookup("admin.shop.apps.localhost"); ok {
+			t.Errorf("got a route for an unknown host, want none")
+		}
+	})
+
+	t.Run("should keep the previous routes when refreshing fails", func (t *testing.T) {
+		source := &stub_route_source{
+			routes: []dto.IngressRoute{{AppName: "web", ProjectName: "shop", AppSlug: "web", ProjectSlug: "shop", Port: "3000"}},
+		}
+		table := NewTable(source, "apps.localhost")
+		table.Refresh()
+
+		source.err = errors.New("connection refused")
+		if err := table.Refresh(); err == nil {
+			t.Errorf("got nil error, want connection refused")
+		}
+		if _, ok := table.Lookup("web.shop.apps.localhost"); !ok {
+			t.Errorf("got route dropped, want it kept")
+		}
+	})
+
+	t.Run("should route hosts by slug and neither application sharing one", func (t *testing.T) {
+		source := &stub_route_source{
+			routes: []dto.IngressRoute{
+				{AppID: "app-1", AppName: "Web", ProjectName: "Acme Corp", AppSlug: "web", ProjectSlug: "acme-corp", Port: "3000"},
+				{AppID: "app-2", AppName: "web", ProjectName: "acme-corp", AppSlug: "web", ProjectSlug: "acme-corp", Port: "3001"},
+				{AppID: "app-3", AppName: "web", ProjectName: "Acme", AppSlug: "web", ProjectSlug: "acme-2", Port: "3002"},
+			},
+		}
+		table := NewTable(source, "apps.localhost")
+		if err := table.Refresh(); err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		if route, ok := table.Lookup("web.acme-corp.apps.localhost"); ok {
+			t.Errorf("got route %+v for a shared host, want none", route)
+		}
+		if route, ok := table.Lookup("web.acme-2.apps.localhost"); !ok || route.AppID != "app-3" {
+			t.Errorf("got route %+v, %v, want app-3 under its stored slug", route, ok)
+		}
+	})
+
+	t.Run("should refresh when deployments change", func (t *testing.T) {
+		source := &stub_route_source{changes: make(chan struct{}, 1)}
+		table := NewTable(source, "apps.localhost")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go table.Run(ctx, time.Hour)
+
+		source.set_routes([]dto.IngressRoute{{AppName: "web", ProjectName: "shop", AppSlug: "web", ProjectSlug: "shop", Port: "3000"}})
+		source.changes <- struct{}{}
+
+		deadline := time.Now().Add(time.Second)
+		for {
+			if _, ok := table.Lookup("web.shop.apps.localhost"); ok {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("got no route after a deployment change, want one")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
+
+func TestServer(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func (w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Seen-Host", r.Host)
+		w.Header().Set("X-Seen-Forwarded-Host", r.Header.Get("X-Forwarded-Host"))
+		io.WriteString(w, "hello from "+r.URL.Path)
+	}))
+	defer backend.Close()
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(backend.URL, "http://"))
+
+	source := &stub_route_source{
+		routes: []dto.IngressRoute{{AppName: "web", ProjectName: "shop", AppSlug: "web", ProjectSlug: "shop", DeploymentID: "dp-1", Port: port}},
+	}
+	table := NewTable(source, "apps.localhost")
+	table.Refresh()
+	server := NewServer(table)
+
+	t.Run("should proxy requests to the routed deployment", func (t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/orders", nil)
+		req.Host = "Web.Shop.apps.localhost:8081"
+		rr := httptest.NewRecorder()
+
+		server.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got status %d, want 200", rr.Code)
+		}
+		if body := rr.Body.String(); body != "hello from /orders" {
+			t.Errorf("got body %q, want the backend response", body)
+		}
+		if host := rr.Header().Get("X-Seen-Host"); host != "Web.Shop.apps.localhost:8081" {
+			t.Errorf("got upstream host %s, want the original host", host)
+		}
+		if host := rr.Header().Get("X-Seen-Forwarded-Host"); host != "Web.Shop.apps.localhost:8081" {
+			t.Errorf("got forwarded host %s, want the original host", host)
+		}
+	})
+
+	t.Run("should respond 404 for unknown hosts", func (t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Host = "api.shop.apps.localhost"
+		rr := httptest.NewRecorder()
+
+		server.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("got status %d, want 404", rr.Code)
+		}
+	})
+
+	t.Run("should balance requests across the web instances of the deployment", func (t *testing.T) {
+		second := httptest.NewServer(http.HandlerFunc(func (w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "second instance")
+		}))
+		defer second.Close()
+		_, second_port, _ := net.SplitHostPort(strings.TrimPrefix(second.URL, "http://"))
+
+		source := &stub_route_source{
+			routes: []dto.IngressRoute{{AppName: "web", ProjectName: "shop", AppSlug: "web", ProjectSlug: "shop", DeploymentID: "dp-1", Port: port, Ports: []string{port, second_port}}},
+		}
+		table := NewTable(source, "apps.localhost")
+		table.Refresh()
+		server := NewServer(table)
+
+		seen := map[string]bool{}
+		for range 50 {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Host = "web.shop.apps.localhost"
+			rr := httptest.NewRecorder()
+			server.ServeHTTP(rr, req)
+			seen[rr.Body.String()] = true
+		}
+
+		if !seen["hello from /"] || !seen["second instance"] {
+			t.Errorf("got responses %v, want both instances reached", seen)
+		}
+	})
+
+	t.Run("should respond 502 when the deployment is down", func (t *testing.T) {
+		listener, _ := net.Listen("tcp", "127.0.0.1:0")
+		_, closed_port, _ := net.SplitHostPort(listener.Addr().String())
+		listener.Close()
+
+		source := &stub_route_source{
+			routes: []dto.IngressRoute{{AppName: "web", ProjectName: "shop", AppSlug: "web", ProjectSlug: "shop", Port: closed_port}},
+		}
+		table := NewTable(source, "apps.localhost")
+		table.Refresh()
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Host = "web.shop.apps.localhost"
+		rr := httptest.NewRecorder()
+
+		NewServer(table).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusBadGateway {
+			t.Errorf("got status %d, want 502", rr.Code)
+		}
+	})
+}
+
+func TestCanary(t *testing.T) {
+	new_backend := func(name string, status int) string {
+		backend := httptest.NewServer(http.HandlerFunc(func (w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+			io.WriteString(w, name)
+		}))
+		t.Cleanup(backend.Close)
+		_, port, _ := net.SplitHostPort(strings.TrimPrefix(backend.URL, "http://"))
+		return port
+	}
+	stable_port := new_backend("stable", http.StatusOK)
+	canary_port := new_backend("canary", http.StatusInternalServerError)
+
+	new_server := func(weight int32, sticky bool) (*Server, *Table, *stub_route_source) {
+		source := &stub_route_source{
+			routes: []dto.IngressRoute{{
+				AppName: "web",
+				ProjectName: "shop",
+				AppSlug: "web",
+				ProjectSlug: "shop",
+				DeploymentID: "dp-stable",
+				Port: stable_port,
+				Canary: &dto.IngressCanary{DeploymentID: "dp-canary", Port: canary_port, Weight: weight, Sticky: sticky},
+			}},
+		}
+		table := NewTable(source, "apps.localhost")
+		table.Refresh()
+		return NewServer(table), table, source
+	}
+	get := func(server *Server, cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Host = "web.shop.apps.localhost"
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("should split requests by the canary weight", func (t *testing.T) {
+		all, _, _ := new_server(100, false)
+		none, _, _ := new_server(0, false)
+
+		for range 20 {
+			if body := get(all, nil).Body.String(); body != "canary" {
+				t.Fatalf("got %s at 100%%, want canary", body)
+			}
+			if body := get(none, nil).Body.String(); body != "stable" {
+				t.Fatalf("got %s at 0%%, want stable", body)
+			}
+		}
+	})
+
+	t.Run("should count requests and errors of both sides for the canary", func (t *testing.T) {
+		server, table, source := new_server(100, false)
+		get(server, nil)
+		get(server, nil)
+		table.Count("dp-canary", false, false)
+
+		if err := table.Flush(); err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		want := dto.CanaryTraffic{DeploymentID: "dp-canary", CanaryRequests: 2, CanaryErrors: 2, StableRequests: 1}
+		if len(source.traffic) != 1 || source.traffic[0] != want {
+			t.Errorf("got traffic %+v, want %+v", source.traffic, want)
+		}
+
+		table.Flush()
+		if len(source.traffic) != 1 {
+			t.Errorf("got traffic %+v reported twice, want it reset after flushing", source.traffic)
+		}
+	})
+
+	t.Run("should keep sticky clients on the deployment they got", func (t *testing.T) {
+		server, _, _ := new_server(100, true)
+
+		rr := get(server, nil)
+		cookies := rr.Result().Cookies()
+		if len(cookies) != 1 || cookies[0].Name != canary_cookie || cookies[0].Value != "dp-canary" {
+			t.Fatalf("got cookies %+v, want the canary remembered", cookies)
+		}
+
+		rr = get(server, &http.Cookie{Name: canary_cookie, Value: "dp-stable"})
+		if body := rr.Body.String(); body != "stable" {
+			t.Errorf("got %s for a client on the stable deployment, want stable", body)
+		}
+		if len(rr.Result().Cookies()) != 0 {
+			t.Errorf("got cookies %+v, want the existing one kept", rr.Result().Cookies())
+		}
+
+		// a cookie from a previous canary is rolled again
+		rr = get(server, &http.Cookie{Name: canary_cookie, Value: "dp-old-canary"})
+		if body := rr.Body.String(); body != "canary" {
+			t.Errorf("got %s for a client of a previous canary, want canary", body)
+		}
+		if cookies := rr.Result().Cookies(); len(cookies) != 1 || cookies[0].Value != "dp-canary" {
+			t.Errorf("got cookies %+v, want the current canary remembered", cookies)
+		}
+	})
+}
+
+func TestStaticSite(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"index.html": "<h1>shop</h1>",
+		"index.html.gz": "gzipped index",
+		"about/index.html": "<h1>about</h1>",
+		"assets/index-BfK3d9aZ.js": "console.log(1)",
+		"assets/index-BfK3d9aZ.js.br": "brotli js",
+		"assets/index-BfK3d9aZ.js.gz": "gzipped js",
+		"assets/app.component.css": "body {}",
+		"fonts/inter.woff2": "font",
+		".env": "SECRET=1",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		os.MkdirAll(filepath.Dir(path), 0o750)
+		if err := os.WriteFile(path, []byte(content), 0o640); err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+	}
+	outside := t.TempDir()
+	os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0o640)
+	os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(root, "leak.txt"))
+
+	source := &stub_route_source{
+		routes: []dto.IngressRoute{{AppName: "site", ProjectName: "shop", AppSlug: "site", ProjectSlug: "shop", DeploymentID: "dp-1", StaticRoot: root}},
+	}
+	table := NewTable(source, "apps.localhost")
+	table.Refresh()
+	server := NewServer(table)
+
+	get := func(method string, path string, accept_encoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Host = "site.shop.apps.localhost"
+		if accept_encoding != "" {
+			req.Header.Set("Accept-Encoding", accept_encoding)
+		}
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("should serve files with their content type and cache headers", func (t *testing.T) {
+		tests := []struct {
+			path string
+			want_body string
+			want_type string
+			want_cache string
+		}{
+			{"/", "<h1>shop</h1>", "text/html; charset=utf-8", "no-cache"},
+			{"/about/", "<h1>about</h1>", "text/html; charset=utf-8", "no-cache"},
+			{"/assets/index-BfK3d9aZ.js", "console.log(1)", "text/javascript; charset=utf-8", "public, max-age=31536000, immutable"},
+			{"/assets/app.component.css", "body {}", "text/css; charset=utf-8", "public, max-age=300"},
+			{"/fonts/inter.woff2", "font", "font/woff2", "public, max-age=300"},
+		}
+
+		for _, tt := range tests {
+			rr := get(http.MethodGet, tt.path, "")
+
+			if rr.Code != http.StatusOK {
+				t.Errorf("got status %d for %s, want 200", rr.Code, tt.path)
+				continue
+			}
+			if body := rr.Body.String(); body != tt.want_body {
+				t.Errorf("got body %q for %s, want %q", body, tt.path, tt.want_body)
+			}
+			if got := rr.Header().Get("Content-Type"); got != tt.want_type {
+				t.Errorf("got content type %s for %s, want %s", got, tt.path, tt.want_type)
+			}
+			if got := rr.Header().Get("Cache-Control"); got != tt.want_cache {
+				t.Errorf("got cache control %s for %s, want %s", got, tt.path, tt.want_cache)
+			}
+		}
+	})
+
+	t.Run("should serve the precompressed variant the client accepts", func (t *testing.T) {
+		tests := []struct {
+			accept_encoding string
+			want_encoding string
+			want_body string
+		}{
+			{"gzip, deflate, br", "br", "brotli js"},
+			{"gzip", "gzip", "gzipped js"},
+			{"br;q=0, gzip;q=0.5", "gzip", "gzipped js"},
+			{"", "", "console.log(1)"},
+		}
+
+		for _, tt := range tests {
+			rr := get(http.MethodGet, "/assets/index-BfK3d9aZ.js", tt.accept_encoding)
+
+			if got := rr.Header().Get("Content-Encoding"); got != tt.want_encoding {
+				t.Errorf("got encoding %q accepting %q, want %q", got, tt.accept_encoding, tt.want_encoding)
+			}
+			if body := rr.Body.String(); body != tt.want_body {
+				t.Errorf("got body %q accepting %q, want %q", body, tt.accept_encoding, tt.want_body)
+			}
+			if got := rr.Header().Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("got vary %q, want Accept-Encoding", got)
+			}
+			if got := rr.Header().Get("Content-Type"); got != "text/javascript; charset=utf-8" {
+				t.Errorf("got content type %s, want the one of the uncompressed file", got)
+			}
+		}
+	})
+
+	t.Run("should fall back to index.html for client side routes", func (t *testing.T) {
+		rr := get(http.MethodGet, "/orders/42", "gzip")
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got status %d, want 200", rr.Code)
+		}
+		if body := rr.Body.String(); body != "gzipped index" {
+			t.Errorf("got body %q, want the index", body)
+		}
+		if got := rr.Header().Get("Cache-Control"); got != "no-cache" {
+			t.Errorf("got cache control %s, want no-cache", got)
+		}
+	})
+
+	t.Run("should respond 404 for missing files and anything outside the site", func (t *testing.T) {
+		for _, path := range []string{"/assets/missing.js", "/.env", "/../" + filepath.Base(outside) + "/secret.txt", "/leak.txt"} {
+			rr := get(http.MethodGet, path, "")
+
+			if rr.Code != http.StatusNotFound {
+				t.Errorf("got status %d for %s, want 404", rr.Code, path)
+			}
+		}
+	})
+
+	t.Run("should revalidate with the etag", func (t *testing.T) {
+		etag := get(http.MethodGet, "/", "").Header().Get("ETag")
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Host = "site.shop.apps.localhost"
+		req.Header.Set("If-None-Match", etag)
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusNotModified {
+			t.Errorf("got status %d, want 304", rr.Code)
+		}
+	})
+
+	t.Run("should only allow reading", func (t *testing.T) {
+		rr := get(http.MethodPost, "/", "")
+
+		if rr.Code != http.StatusMethodNotAllowed {
+			t.Errorf("got status %d, want 405", rr.Code)
+		}
+	})
+}
@@ -0,0 +1,150 @@
+package ingress
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/salmanrf/capybara-cloud/pkg/dto"
+)
+
+// canary_cookie remembers the deployment a sticky canary client was sent to
+const canary_cookie = "capybara_canary"
+
+const canary_cookie_max_age = 24 * time.Hour
+
+// Server proxies requests to the deployment routed for their Host header,
+// static sites are served from their files.
+type Server struct {
+	table *Table
+}
+
+func NewServer(table *Table) *Server {
+	return &Server{
+		table: table,
+	}
+}
+
+func request_host(r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// pick_deployment splits the requests of a route with a canary by its
+// weight, sticky clients keep the deployment their cookie names while it is
+// still one of the two.
+func pick_deployment(w http.ResponseWriter, r *http.Request, route dto.IngressRoute) (string, string, bool) {
+	canary := route.Canary
+	if canary == nil {
+		return route.DeploymentID, route.Port, false
+	}
+
+	if canary.Sticky {
+		if cookie, err := r.Cookie(canary_cookie); err == nil {
+			switch cookie.Value {
+			case canary.DeploymentID:
+				return canary.DeploymentID, canary.Port, true
+			case route.DeploymentID:
+				return route.DeploymentID, route.Port, false
+			}
+		}
+	}
+
+	deployment_id, port, to_canary := route.DeploymentID, route.Port, false
+	if rand.Int32N(100) < canary.Weight {
+		deployment_id, port, to_canary = canary.DeploymentID, canary.Port, true
+	}
+
+	if canary.Sticky {
+		http.SetCookie(w, &http.Cookie{
+			Name: canary_cookie,
+			Value: deployment_id,
+			Path: "/",
+			MaxAge: int(canary_cookie_max_age / time.Second),
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+
+	return deployment_id, port, to_canary
+}
+
+// pick_instance balances the requests of a scaled deployment across its web
+// instances
+func pick_instance(port string, ports []string) string {
+	if len(ports) == 0 {
+		return port
+	}
+
+	return ports[rand.IntN(len(ports))]
+}
+
+// status_recorder keeps the status of a proxied response for the canary
+// error rates. Unwrap lets the proxy flush streamed responses.
+type status_recorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *status_recorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *status_recorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	route, ok := s.table.Lookup(request_host(r))
+	if !ok {
+		http.Error(w, "no application is served on this host", http.StatusNotFound)
+		return
+	}
+
+	if route.StaticRoot != "" {
+		serve_static(w, r, route.StaticRoot)
+		return
+	}
+
+	deployment_id, port, to_canary := pick_deployment(w, r, route)
+	if to_canary {
+		port = pick_instance(port, route.Canary.Ports)
+	} else {
+		port = pick_instance(port, route.Ports)
+	}
+	target := &url.URL{
+		Scheme: "http",
+		Host: net.JoinHostPort("127.0.0.1", port),
+	}
+
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.SetXForwarded()
+			pr.Out.Host = pr.In.Host
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			fmt.Printf("Error at ingress.ServeHTTP - deployment %s: %s\n", deployment_id, err.Error())
+			http.Error(w, "application is unavailable", http.StatusBadGateway)
+		},
+	}
+
+	if route.Canary == nil {
+		proxy.ServeHTTP(w, r)
+		return
+	}
+
+	recorder := &status_recorder{ResponseWriter: w, status: http.StatusOK}
+	proxy.ServeHTTP(recorder, r)
+	s.table.Count(route.Canary.DeploymentID, to_canary, recorder.status >= http.StatusInternalServerError)
+}
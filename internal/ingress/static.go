@@ -0,0 +1,203 @@
+package ingress
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// content types the ingress doesn't leave to the host's mime tables, which
+// are missing in slim images
+var content_types = map[string]string{
+	".html": "text/html; charset=utf-8",
+	".htm": "text/html; charset=utf-8",
+	".css": "text/css; charset=utf-8",
+	".js": "text/javascript; charset=utf-8",
+	".mjs": "text/javascript; charset=utf-8",
+	".json": "application/json",
+	".map": "application/json",
+	".webmanifest": "application/manifest+json",
+	".txt": "text/plain; charset=utf-8",
+	".xml": "application/xml",
+	".svg": "image/svg+xml",
+	".png": "image/png",
+	".jpg": "image/jpeg",
+	".jpeg": "image/jpeg",
+	".gif": "image/gif",
+	".webp": "image/webp",
+	".avif": "image/avif",
+	".ico": "image/x-icon",
+	".wasm": "application/wasm",
+	".woff": "font/woff",
+	".woff2": "font/woff2",
+	".ttf": "font/ttf",
+	".otf": "font/otf",
+	".pdf": "application/pdf",
+	".mp4": "video/mp4",
+	".webm": "video/webm",
+}
+
+// precompressed variants in the order they are preferred
+var encodings = []struct {
+	name string
+	ext string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// the dot or dash separated part before the extension that fingerprints a
+// bundled asset, like the hash in app.3f2a1b4c.js or index-BfK3d9aZ.js
+var hashed_name = regexp.MustCompile(`[.-]([A-Za-z0-9_]{8,})\.[A-Za-z0-9]+$`)
+
+const (
+	cache_immutable = "public, max-age=31536000, immutable"
+	cache_revalidate = "no-cache"
+	cache_default = "public, max-age=300"
+)
+
+func content_type(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if t, ok := content_types[ext]; ok {
+		return t
+	}
+
+	return mime.TypeByExtension(ext)
+}
+
+// fingerprinted tells hashes from words like bundle.component.js, a hash has
+// at least one digit.
+func fingerprinted(base string) bool {
+	match := hashed_name.FindStringSubmatch(base)
+	return match != nil && strings.ContainsAny(match[1], "0123456789")
+}
+
+// cache_control lets browsers keep fingerprinted assets for good, html is
+// revalidated so a new deployment shows up on the next load.
+func cache_control(name string) string {
+	base := path.Base(name)
+	switch {
+	case strings.HasSuffix(base, ".html") || strings.HasSuffix(base, ".htm"):
+		return cache_revalidate
+	case fingerprinted(base):
+		return cache_immutable
+	default:
+		return cache_default
+	}
+}
+
+// accepts_encoding tells whether an Accept-Encoding header allows encoding,
+// a q of 0 refuses it.
+func accepts_encoding(header string, encoding string) bool {
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), encoding) {
+			continue
+		}
+		q, ok := strings.CutPrefix(strings.TrimSpace(params), "q=")
+		if !ok {
+			return true
+		}
+		value, err := strconv.ParseFloat(q, 64)
+		return err == nil && value > 0
+	}
+
+	return false
+}
+
+// hidden_path refuses dotfiles like .env, except for .well-known
+func hidden_path(name string) bool {
+	for _, segment := range strings.Split(name, "/") {
+		if strings.HasPrefix(segment, ".") && segment != ".well-known" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// serve_static serves a request from the files of a static site. Directories
+// serve their index.html and paths without an extension that don't exist
+// fall back to the site's index.html for client side routing.
+func serve_static(w http.ResponseWriter, r *http.Request, route_root string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// the root keeps symlinks and .. from reaching outside the site
+	root, err := os.OpenRoot(route_root)
+	if err != nil {
+		fmt.Printf("Error at ingress.serve_static - root %s: %s\n", route_root, err.Error())
+		http.Error(w, "application is unavailable", http.StatusBadGateway)
+		return
+	}
+	defer root.Close()
+
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if hidden_path(name) {
+		http.NotFound(w, r)
+		return
+	}
+	if name == "" {
+		name = "."
+	}
+
+	info, err := root.Stat(name)
+	if err == nil && info.IsDir() {
+		name = path.Join(name, "index.html")
+		info, err = root.Stat(name)
+	}
+	if errors.Is(err, fs.ErrNotExist) && path.Ext(name) == "" {
+		name = "index.html"
+		info, err = root.Stat(name)
+	}
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	header := w.Header()
+	header.Set("Cache-Control", cache_control(name))
+	if t := content_type(name); t != "" {
+		header.Set("Content-Type", t)
+	}
+
+	served := name
+	tag := fmt.Sprintf("%x-%x", info.ModTime().UnixNano(), info.Size())
+	for _, encoding := range encodings {
+		variant, err := root.Stat(name + encoding.ext)
+		if err != nil || variant.IsDir() {
+			continue
+		}
+		header.Set("Vary", "Accept-Encoding")
+		if served == name && accepts_encoding(r.Header.Get("Accept-Encoding"), encoding.name) {
+			served = name + encoding.ext
+			header.Set("Content-Encoding", encoding.name)
+			tag += "-" + encoding.name
+		}
+	}
+	if served != name && header.Get("Content-Type") == "" {
+		// the compressed bytes can't be sniffed
+		header.Set("Content-Type", "application/octet-stream")
+	}
+	header.Set("ETag", `"`+tag+`"`)
+
+	file, err := root.Open(served)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer file.Close()
+
+	http.ServeContent(w, r, name, info.ModTime().Truncate(time.Second), file)
+}
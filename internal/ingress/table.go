@@ -0,0 +1,157 @@
+package ingress
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/salmanrf/capybara-cloud/pkg/dto"
+	"github.com/salmanrf/capybara-cloud/pkg/utils"
+)
+
+// RouteSource is what the ingress needs from the application service
+type RouteSource interface {
+	FindIngressRoutes() ([]dto.IngressRoute, error)
+	WatchDeploymentChanges() (<-chan struct{}, func())
+	RecordCanaryTraffic(traffic []dto.CanaryTraffic) error
+}
+
+// Host is where an application is reachable, {app}.{project}.{domain}
+func Host(app_name string, project_name string, domain string) string {
+	return utils.Slug(app_name) + "." + utils.Slug(project_name) + "." + strings.ToLower(domain)
+}
+
+// Table maps hosts to the route of their application and counts the
+// requests split for canaries until they are reported to the source.
+type Table struct {
+	source RouteSource
+	domain string
+	mu sync.RWMutex
+	routes map[string]dto.IngressRoute
+	traffic_mu sync.Mutex
+	traffic map[string]*dto.CanaryTraffic
+}
+
+func NewTable(source RouteSource, domain string) *Table {
+	return &Table{
+		source: source,
+		domain: domain,
+		routes: make(map[string]dto.IngressRoute),
+		traffic: make(map[string]*dto.CanaryTraffic),
+	}
+}
+
+// Refresh rebuilds the table from the source, on error the previous routes
+// stay in place.
+func (t *Table) Refresh() error {
+	found, err := t.source.FindIngressRoutes()
+	if err != nil {
+		return err
+	}
+
+	// slugs are unique, a host shared by two applications routes to neither
+	routes := make(map[string]dto.IngressRoute, len(found))
+	shared := map[string]bool{}
+	for _, route := range found {
+		host := Host(route.AppSlug, route.ProjectSlug, t.domain)
+		if existing, ok := routes[host]; ok && existing.AppID != route.AppID {
+			fmt.Printf("Ingress host %s is shared by apps %s and %s, routing neither\n", host, existing.AppID, route.AppID)
+			shared[host] = true
+			continue
+		}
+		routes[host] = route
+	}
+	for host := range shared {
+		delete(routes, host)
+	}
+
+	t.mu.Lock()
+	t.routes = routes
+	t.mu.Unlock()
+
+	return nil
+}
+
+func (t *Table) Lookup(host string) (dto.IngressRoute, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	route, ok := t.routes[host]
+	return route, ok
+}
+
+// Count adds a request of a route split for the canary canary_id, served by
+// the canary or by the stable deployment.
+func (t *Table) Count(canary_id string, canary bool, failed bool) {
+	t.traffic_mu.Lock()
+	defer t.traffic_mu.Unlock()
+
+	counted, ok := t.traffic[canary_id]
+	if !ok {
+		counted = &dto.CanaryTraffic{DeploymentID: canary_id}
+		t.traffic[canary_id] = counted
+	}
+
+	switch {
+	case canary && failed:
+		counted.CanaryRequests += 1
+		counted.CanaryErrors += 1
+	case canary:
+		counted.CanaryRequests += 1
+	case failed:
+		counted.StableRequests += 1
+		counted.StableErrors += 1
+	default:
+		counted.StableRequests += 1
+	}
+}
+
+// Flush reports the traffic counted since the last flush to the source. The
+// counts are dropped when that fails, error rates don't need every request.
+func (t *Table) Flush() error {
+	t.traffic_mu.Lock()
+	traffic := make([]dto.CanaryTraffic, 0, len(t.traffic))
+	for _, counted := range t.traffic {
+		traffic = append(traffic, *counted)
+	}
+	clear(t.traffic)
+	t.traffic_mu.Unlock()
+
+	if len(traffic) == 0 {
+		return nil
+	}
+
+	return t.source.RecordCanaryTraffic(traffic)
+}
+
+// Run refreshes the table whenever a deployment changes status, and every
+// interval in case a change was missed, until ctx is done. Canary traffic is
+// flushed along.
+func (t *Table) Run(ctx context.Context, interval time.Duration) {
+	changes, unwatch := t.source.WatchDeploymentChanges()
+	defer unwatch()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := t.Refresh(); err != nil {
+			fmt.Println("Error at ingress.Run: ", err.Error())
+		}
+		if err := t.Flush(); err != nil {
+			fmt.Println("Error at ingress.Run - flushing canary traffic: ", err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			if err := t.Flush(); err != nil {
+				fmt.Println("Error at ingress.Run - flushing canary traffic: ", err.Error())
+			}
+			return
+		case <-changes:
+		case <-ticker.C:
+		}
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/salmanrf/capybara-cloud/internal/database"
 	"github.com/salmanrf/capybara-cloud/internal/user"
+	"github.com/salmanrf/capybara-cloud/pkg/utils"
 )
 
 type Service interface {
@@ -60,9 +61,12 @@ func (s *service) Create(user_id string, org_id string, project_name  string) (*
 	org_uuid := pgtype.UUID{}
 	org_uuid.Scan(org_id)
 	
+	// the slug is the project's part of its applications' hosts, taken
+	// slugs fail on their unique key like taken names
 	project, err := q.CreateProject(s.ctx, database.CreateProjectParams{
 		OrgID: org_uuid,
 		Name: project_name,
+		Slug: utils.Slug(project_name),
 	})
 
 	if err != nil {
@@ -98,6 +102,7 @@ func (s *service) UpdateOne(dto *database.FindOneProjectByIdAndRoleRow) (*databa
 	project, err := s.queries.UpdateOneProject(s.ctx, database.UpdateOneProjectParams{
 		ProjectID: dto.ProjectID,
 		Name: dto.Name.String,
+		Slug: utils.Slug(dto.Name.String),
 		UpdatedAt: updated_at,
 	})
 
@@ -0,0 +1,190 @@
+package runtime
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	PackageManagerNpm = "npm"
+	PackageManagerYarn = "yarn"
+	PackageManagerPnpm = "pnpm"
+)
+
+// lockfiles in the order they win when a bundle ships several
+var lockfiles = []struct {
+	name string
+	package_manager string
+}{
+	{"pnpm-lock.yaml", PackageManagerPnpm},
+	{"yarn.lock", PackageManagerYarn},
+	{"package-lock.json", PackageManagerNpm},
+	{"npm-shrinkwrap.json", PackageManagerNpm},
+}
+
+// BuildPlan is what Prepare runs before a Node.js deployment can start.
+// Dev dependencies are installed too since build scripts usually need them.
+type BuildPlan struct {
+	PackageManager string
+	Lockfile string
+	InstallCommand string
+	BuildCommand string
+}
+
+func PlanBuild(project_root string, pkg *PackageJSON) BuildPlan {
+	plan := BuildPlan{PackageManager: PackageManagerNpm}
+
+	for _, lockfile := range lockfiles {
+		if _, err := os.Stat(filepath.Join(project_root, lockfile.name)); err == nil {
+			plan.PackageManager = lockfile.package_manager
+			plan.Lockfile = lockfile.name
+			break
+		}
+	}
+
+	switch {
+	case plan.PackageManager == PackageManagerPnpm:
+		plan.InstallCommand = "pnpm install --frozen-lockfile --prod=false"
+	case plan.PackageManager == PackageManagerYarn:
+		plan.InstallCommand = "yarn install --frozen-lockfile --production=false"
+	case plan.Lockfile != "":
+		plan.InstallCommand = "npm ci --include=dev --no-audit --no-fund"
+	case len(pkg.Dependencies) > 0 || len(pkg.DevDependencies) > 0:
+		plan.InstallCommand = "npm install --include=dev --no-audit --no-fund"
+	}
+
+	if script, ok := pkg.Scripts["build"]; ok && strings.TrimSpace(script) != "" {
+		plan.BuildCommand = plan.PackageManager + " run build"
+	}
+
+	return plan
+}
+
+// CacheKey hashes the lockfile, installs without one are never cached
+func (plan BuildPlan) CacheKey(project_root string) (string, error) {
+	if plan.Lockfile == "" {
+		return "", nil
+	}
+
+	lockfile, err := os.Open(filepath.Join(project_root, plan.Lockfile))
+	if err != nil {
+		return "", err
+	}
+	defer lockfile.Close()
+
+	hash := sha256.New()
+	io.WriteString(hash, plan.PackageManager+"\n")
+	if _, err := io.Copy(hash, lockfile); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func build_log(spec Spec, stream string, line string) {
+	spec.log(LogLine{Phase: LogPhaseBuild, Stream: stream, Line: line, Time: time.Now()})
+}
+
+// run_build_command runs one build step in the project root, its output is
+// reported as build logs.
+func run_build_command(ctx context.Context, spec Spec, project_root string, command string, env []string) error {
+	build_log(spec, "stdout", "$ "+command)
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Dir = project_root
+	cmd.Env = env
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	var pipes sync.WaitGroup
+	pipes.Add(2)
+	for stream, pipe := range map[string]io.Reader{"stdout": stdout, "stderr": stderr} {
+		go func() {
+			defer pipes.Done()
+
+			scanner := bufio.NewScanner(pipe)
+			scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+			for scanner.Scan() {
+				build_log(spec, stream, scanner.Text())
+			}
+		}()
+	}
+	pipes.Wait()
+
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("%s failed: %w", command, err)
+	}
+
+	return nil
+}
+
+// build_project installs dependencies and runs the build command of a plan
+// if there is one. Node.js dependencies come from the cache when the
+// lockfile was seen before.
+func build_project(ctx context.Context, spec Spec, project_root string, plan StackPlan, cache *DependencyCache) error {
+	env := process_env(project_root, spec.Variables)
+
+	if plan.InstallCommand != "" {
+		var err error
+		key := ""
+		if cache != nil && plan.Node != nil {
+			key, err = plan.Node.CacheKey(project_root)
+			if err != nil {
+				return err
+			}
+		}
+
+		restored := false
+		if key != "" {
+			restored, err = cache.Restore(spec.AppID, key, project_root)
+			if err != nil {
+				build_log(spec, "stderr", "Restoring node_modules from cache failed: "+err.Error())
+			}
+		}
+
+		if restored {
+			build_log(spec, "stdout", fmt.Sprintf("Restored node_modules from cache for %s %s", plan.Node.Lockfile, key[:12]))
+		} else {
+			if err := run_build_command(ctx, spec, project_root, plan.InstallCommand, env); err != nil {
+				return err
+			}
+			if key != "" {
+				if err := cache.Save(spec.AppID, key, project_root); err != nil {
+					build_log(spec, "stderr", "Caching node_modules failed: "+err.Error())
+				}
+			}
+		}
+	}
+
+	if plan.BuildCommand != "" {
+		return run_build_command(ctx, spec, project_root, plan.BuildCommand, env)
+	}
+
+	return nil
+}
@@ -0,0 +1,341 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func write_files(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o640); err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+	}
+}
+
+func TestPlanBuild(t *testing.T) {
+	tests := []struct {
+		name string
+		files map[string]string
+		pkg PackageJSON
+		want BuildPlan
+	}{
+		{
+			name: "npm lockfile",
+			files: map[string]string{"package-lock.json": "{}"},
+			pkg: PackageJSON{Scripts: map[string]string{"build": "tsc"}},
+			want: BuildPlan{PackageManagerNpm, "package-lock.json", "npm ci --include=dev --no-audit --no-fund", "npm run build"},
+		},
+		{
+			name: "yarn wins over npm",
+			files: map[string]string{"yarn.lock": "", "package-lock.json": "{}"},
+			want: BuildPlan{PackageManagerYarn, "yarn.lock", "yarn install --frozen-lockfile --production=false", ""},
+		},
+		{
+			name: "pnpm",
+			files: map[string]string{"pnpm-lock.yaml": ""},
+			pkg: PackageJSON{Scripts: map[string]string{"build": "vite build"}},
+			want: BuildPlan{PackageManagerPnpm, "pnpm-lock.yaml", "pnpm install --frozen-lockfile --prod=false", "pnpm run build"},
+		},
+		{
+			name: "dependencies without a lockfile",
+			pkg: PackageJSON{Dependencies: map[string]string{"express": "^4"}},
+			want: BuildPlan{PackageManagerNpm, "", "npm install --include=dev --no-audit --no-fund", ""},
+		},
+		{
+			name: "nothing to install or build",
+			pkg: PackageJSON{Scripts: map[string]string{"build": " "}},
+			want: BuildPlan{PackageManager: PackageManagerNpm},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func (t *testing.T) {
+			project_root := t.TempDir()
+			write_files(t, project_root, tt.files)
+
+			if got := PlanBuild(project_root, &tt.pkg); got != tt.want {
+				t.Errorf("got plan %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDependencyCache(t *testing.T) {
+	t.Run("should restore saved node_modules with their symlinks", func (t *testing.T) {
+		cache := NewDependencyCache(t.TempDir(), 0, 0)
+
+		project_root := t.TempDir()
+		write_files(t, project_root, map[string]string{"node_modules/left-pad/index.js": "module.exports = 1"})
+		os.MkdirAll(filepath.Join(project_root, "node_modules", ".bin"), 0o750)
+		os.Symlink("../left-pad/index.js", filepath.Join(project_root, "node_modules", ".bin", "left-pad"))
+
+		if err := cache.Save("app-1", "abc", project_root); err != nil {
+			t.Fatalf("got error saving %v, want nil", err)
+		}
+
+		next_root := t.TempDir()
+		if restored, err := cache.Restore("app-1", "other", next_root); restored || err != nil {
+			t.Errorf("got restored %v (%v) for an unknown key, want a miss", restored, err)
+		}
+		if restored, err := cache.Restore("app-2", "abc", next_root); restored || err != nil {
+			t.Errorf("got restored %v (%v) for another app, want a miss", restored, err)
+		}
+
+		restored, err := cache.Restore("app-1", "abc", next_root)
+		if !restored || err != nil {
+			t.Fatalf("got restored %v (%v), want a hit", restored, err)
+		}
+		content, err := os.ReadFile(filepath.Join(next_root, "node_modules", ".bin", "left-pad"))
+		if err != nil || string(content) != "module.exports = 1" {
+			t.Errorf("got %q (%v) through the restored symlink, want the module", content, err)
+		}
+	})
+
+	t.Run("should evict entries past max age", func (t *testing.T) {
+		dir := t.TempDir()
+		cache := NewDependencyCache(dir, 0, time.Hour)
+
+		project_root := t.TempDir()
+		write_files(t, project_root, map[string]string{"node_modules/a/index.js": "a"})
+		cache.Save("app-1", "old", project_root)
+
+		long_ago := time.Now().Add(-2 * time.Hour)
+		os.Chtimes(filepath.Join(dir, "app-1", "old"), long_ago, long_ago)
+
+		if err := cache.Evict(); err != nil {
+			t.Fatalf("got error evicting %v, want nil", err)
+		}
+		if restored, _ := cache.Restore("app-1", "old", t.TempDir()); restored {
+			t.Errorf("got a stale entry restored, want it evicted")
+		}
+	})
+
+	t.Run("should evict the least recently used entries over max size", func (t *testing.T) {
+		dir := t.TempDir()
+		cache := NewDependencyCache(dir, 25, 0)
+
+		project_root := t.TempDir()
+		write_files(t, project_root, map[string]string{"node_modules/a/index.js": strings.Repeat("a", 10)})
+
+		cache.Save("app-1", "first", project_root)
+		cache.Save("app-1", "second", project_root)
+		earlier := time.Now().Add(-time.Minute)
+		os.Chtimes(filepath.Join(dir, "app-1", "first"), earlier, earlier)
+		cache.Restore("app-1", "second", t.TempDir())
+
+		cache.Save("app-2", "third", project_root)
+
+		if restored, _ := cache.Restore("app-1", "first", t.TempDir()); restored {
+			t.Errorf("got the least recently used entry restored, want it evicted")
+		}
+		for _, entry := range [][2]string{{"app-1", "second"}, {"app-2", "third"}} {
+			if restored, _ := cache.Restore(entry[0], entry[1], t.TempDir()); !restored {
+				t.Errorf("got %s/%s evicted, want it kept", entry[0], entry[1])
+			}
+		}
+	})
+
+	t.Run("should not hold other entries up while one is being copied", func (t *testing.T) {
+		dir := t.TempDir()
+		cache := NewDependencyCache(dir, 0, 0)
+
+		project_root := t.TempDir()
+		write_files(t, project_root, map[string]string{"node_modules/a/index.js": "a"})
+		cache.Save("app-1", "abc", project_root)
+
+		unlock := cache.lock_entry(filepath.Join(dir, "app-1", "abc"), true)
+		defer unlock()
+
+		done := make(chan error, 1)
+		go func() {
+			if err := cache.Save("app-2", "abc", project_root); err != nil {
+				done <- err
+				return
+			}
+			_, err := cache.Restore("app-2", "abc", t.TempDir())
+			done <- err
+		}()
+
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("got error %v, want nil", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got app-2 waiting on the entry of app-1, want it done")
+		}
+	})
+
+	t.Run("should keep entries in use when evicting", func (t *testing.T) {
+		dir := t.TempDir()
+		cache := NewDependencyCache(dir, 0, time.Hour)
+
+		project_root := t.TempDir()
+		write_files(t, project_root, map[string]string{"node_modules/a/index.js": "a"})
+		cache.Save("app-1", "old", project_root)
+
+		entry := filepath.Join(dir, "app-1", "old")
+		long_ago := time.Now().Add(-2 * time.Hour)
+		os.Chtimes(entry, long_ago, long_ago)
+
+		unlock := cache.lock_entry(entry, false)
+		if err := cache.Evict(); err != nil {
+			t.Fatalf("got error evicting %v, want nil", err)
+		}
+		if _, err := os.Stat(entry); err != nil {
+			t.Errorf("got entry in use evicted (%v), want it kept", err)
+		}
+		unlock()
+
+		if err := cache.Evict(); err != nil {
+			t.Fatalf("got error evicting %v, want nil", err)
+		}
+		if _, err := os.Stat(entry); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("got %v, want the entry evicted once released", err)
+		}
+	})
+}
+
+// fake_npm puts an npm on the PATH that records its calls and installs a
+// single module.
+func fake_npm(t *testing.T) string {
+	t.Helper()
+
+	bin := t.TempDir()
+	calls := filepath.Join(bin, "calls")
+	script := `#!/bin/sh
+echo "$@" >> ` + calls + `
+case "$1" in
+ci)
+	mkdir -p node_modules/left-pad
+	echo "module.exports = 1" > node_modules/left-pad/index.js
+	echo "added 1 package"
+	;;
+run)
+	echo "building" && echo "built" > dist.txt
+	;;
+esac
+`
+	if err := os.WriteFile(filepath.Join(bin, "npm"), []byte(script), 0o755); err != nil {
+		t.Fatalf("got error %v, want nil", err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	return calls
+}
+
+func TestBuildProject(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should install, build and reuse cached dependencies of the same lockfile", func (t *testing.T) {
+		calls := fake_npm(t)
+		local := NewLocalRuntime(ctx, time.Second, NewDependencyCache(t.TempDir(), 0, 0), nil, "")
+
+		files := map[string]string{
+			"package.json": `{"name": "hello", "scripts": {"start": "node index.js", "build": "tsc"}}`,
+			"package-lock.json": `{"lockfileVersion": 3}`,
+		}
+
+		for _, dp_id := range []string{"dp-1", "dp-2"} {
+			artifacts_path := t.TempDir()
+			write_test_bundle(t, artifacts_path, files)
+
+			lines := []LogLine{}
+			spec := Spec{
+				DeploymentID: dp_id,
+				AppID: "app-1",
+				ArtifactsPath: artifacts_path,
+				OnLog: func(line LogLine) { lines = append(lines, line) },
+			}
+			if err := local.Prepare(spec); err != nil {
+				t.Fatalf("got error preparing %s %v, want nil", dp_id, err)
+			}
+
+			source_dir := SourceDir(artifacts_path)
+			if _, err := os.Stat(filepath.Join(source_dir, "node_modules", "left-pad", "index.js")); err != nil {
+				t.Errorf("got %v for node_modules of %s, want installed dependencies", err, dp_id)
+			}
+			if _, err := os.Stat(filepath.Join(source_dir, "dist.txt")); err != nil {
+				t.Errorf("got %v for the build output of %s, want built", err, dp_id)
+			}
+			for _, line := range lines {
+				if line.Phase != LogPhaseBuild {
+					t.Errorf("got log line %+v, want build phase", line)
+				}
+			}
+		}
+
+		content, _ := os.ReadFile(calls)
+		got_calls := strings.Split(strings.TrimSpace(string(content)), "\n")
+		want_calls := []string{"ci --include=dev --no-audit --no-fund", "run build", "run build"}
+		if strings.Join(got_calls, ",") != strings.Join(want_calls, ",") {
+			t.Errorf("got npm calls %v, want %v", got_calls, want_calls)
+		}
+	})
+
+	t.Run("should fail with the command and keep its output as build logs", func (t *testing.T) {
+		calls := fake_npm(t)
+		os.WriteFile(filepath.Join(filepath.Dir(calls), "npm"), []byte("#!/bin/sh\necho 'error TS2304' >&2\nexit 2\n"), 0o755)
+		local := NewLocalRuntime(ctx, time.Second, nil, nil, "")
+
+		artifacts_path := t.TempDir()
+		write_test_bundle(t, artifacts_path, map[string]string{
+			"package.json": `{"name": "hello", "scripts": {"start": "node index.js", "build": "tsc"}}`,
+		})
+
+		lines := []string{}
+		err := local.Prepare(Spec{
+			DeploymentID: "dp-3",
+			ArtifactsPath: artifacts_path,
+			OnLog: func(line LogLine) { lines = append(lines, line.Stream+":"+line.Line) },
+		})
+
+		if err == nil || !strings.Contains(err.Error(), "npm run build failed") {
+			t.Errorf("got error %v, want the failed build command", err)
+		}
+		if !strings.Contains(strings.Join(lines, "\n"), "stderr:error TS2304") {
+			t.Errorf("got logs %v, want the build output", lines)
+		}
+	})
+
+	t.Run("should stop the build when the spec's context is cancelled", func (t *testing.T) {
+		calls := fake_npm(t)
+		os.WriteFile(filepath.Join(filepath.Dir(calls), "npm"), []byte("#!/bin/sh\nsleep 30\n"), 0o755)
+		local := NewLocalRuntime(ctx, time.Second, nil, nil, "")
+
+		artifacts_path := t.TempDir()
+		write_test_bundle(t, artifacts_path, map[string]string{
+			"package.json": `{"name": "hello", "scripts": {"start": "node index.js", "build": "tsc"}}`,
+		})
+
+		build_ctx, cancel := context.WithCancel(ctx)
+		time.AfterFunc(100*time.Millisecond, cancel)
+
+		started := time.Now()
+		err := local.Prepare(Spec{
+			Context: build_ctx,
+			DeploymentID: "dp-4",
+			ArtifactsPath: artifacts_path,
+		})
+
+		if err == nil {
+			t.Errorf("got no error, want the cancelled build to fail")
+		}
+		if elapsed := time.Since(started); elapsed > 10*time.Second {
+			t.Errorf("got build running for %s, want it stopped", elapsed)
+		}
+	})
+}
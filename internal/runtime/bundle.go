@@ -0,0 +1,97 @@
+package runtime
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type PackageJSON struct {
+	Name string `json:"name"`
+	Main string `json:"main"`
+	Scripts map[string]string `json:"scripts"`
+	Dependencies map[string]string `json:"dependencies"`
+	DevDependencies map[string]string `json:"devDependencies"`
+}
+
+// SourceDir is where a deployment's bundle gets extracted to
+func SourceDir(artifacts_path string) string {
+	return filepath.Join(artifacts_path, "source")
+}
+
+func FindBundle(artifacts_path string) (string, error) {
+	matches, err := filepath.Glob(filepath.Join(artifacts_path, "bundle.*"))
+	if err != nil {
+		return "", err
+	}
+	if len(matches) == 0 {
+		return "", errors.New("bundle_not_found")
+	}
+
+	return matches[0], nil
+}
+
+// ProjectRoot returns the directory holding the files a stack is detected
+// by, bundles are often packed with a single top level folder.
+func ProjectRoot(source_dir string) (string, error) {
+	if _, err := DetectStack(source_dir); err == nil {
+		return source_dir, nil
+	}
+
+	nested, err := bundle_root(source_dir)
+	if err != nil {
+		return "", err
+	}
+	if _, err := DetectStack(nested); err == nil {
+		return nested, nil
+	}
+
+	return "", errors.New("stack_not_detected")
+}
+
+// bundle_root unwraps a bundle packed with a single top level folder
+func bundle_root(source_dir string) (string, error) {
+	entries, err := os.ReadDir(source_dir)
+	if err != nil {
+		return "", err
+	}
+	if len(entries) == 1 && entries[0].IsDir() {
+		return filepath.Join(source_dir, entries[0].Name()), nil
+	}
+
+	return source_dir, nil
+}
+
+func ReadPackageJSON(project_root string) (*PackageJSON, error) {
+	content, err := os.ReadFile(filepath.Join(project_root, "package.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var pkg PackageJSON
+	if err := json.Unmarshal(content, &pkg); err != nil {
+		return nil, fmt.Errorf("invalid package.json: %w", err)
+	}
+
+	return &pkg, nil
+}
+
+// StartCommand resolves the command `npm start` would run
+func (pkg *PackageJSON) StartCommand(project_root string) (string, error) {
+	if script, ok := pkg.Scripts["start"]; ok && strings.TrimSpace(script) != "" {
+		return script, nil
+	}
+
+	if pkg.Main != "" {
+		return fmt.Sprintf("node %s", pkg.Main), nil
+	}
+
+	if _, err := os.Stat(filepath.Join(project_root, "server.js")); err == nil {
+		return "node server.js", nil
+	}
+
+	return "", errors.New("start_command_not_found")
+}
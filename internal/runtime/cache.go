@@ -0,0 +1,317 @@
+package runtime
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const cache_size_file = ".size"
+
+// DependencyCache keeps the node_modules of past installs per application,
+// keyed by lockfile hash. Entries unused for max_age are dropped, then the
+// least recently used ones until the cache fits in max_size bytes.
+// Builds only wait on each other over the same entry, mu is held for eviction
+// and to look up entry locks.
+type DependencyCache struct {
+	mu sync.Mutex
+	entries map[string]*sync.RWMutex
+	dir string
+	max_size int64
+	max_age time.Duration
+}
+
+func NewDependencyCache(dir string, max_size int64, max_age time.Duration) *DependencyCache {
+	return &DependencyCache{
+		entries: make(map[string]*sync.RWMutex),
+		dir: dir,
+		max_size: max_size,
+		max_age: max_age,
+	}
+}
+
+func (c *DependencyCache) entry_dir(app_id string, key string) string {
+	return filepath.Join(c.dir, filepath.Base(app_id), key)
+}
+
+// lock_entry locks an entry for reading or for replacing it and returns the
+// unlock. Eviction drops the lock of an entry it removes, a lock taken in
+// between is retried on the one that replaced it.
+func (c *DependencyCache) lock_entry(entry string, write bool) func() {
+	for {
+		c.mu.Lock()
+		lock, ok := c.entries[entry]
+		if !ok {
+			lock = &sync.RWMutex{}
+			c.entries[entry] = lock
+		}
+		c.mu.Unlock()
+
+		unlock := lock.RUnlock
+		if write {
+			lock.Lock()
+			unlock = lock.Unlock
+		} else {
+			lock.RLock()
+		}
+
+		c.mu.Lock()
+		current := c.entries[entry]
+		c.mu.Unlock()
+		if current == lock {
+			return unlock
+		}
+		unlock()
+	}
+}
+
+// Restore copies a cached node_modules into the project, it returns false on
+// a cache miss.
+func (c *DependencyCache) Restore(app_id string, key string, project_root string) (bool, error) {
+	entry := c.entry_dir(app_id, key)
+	unlock := c.lock_entry(entry, false)
+	defer unlock()
+
+	if _, err := os.Stat(filepath.Join(entry, "node_modules")); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	target := filepath.Join(project_root, "node_modules")
+	if err := os.RemoveAll(target); err != nil {
+		return false, err
+	}
+	if err := copy_tree(filepath.Join(entry, "node_modules"), target); err != nil {
+		os.RemoveAll(target)
+		return false, err
+	}
+
+	now := time.Now()
+	os.Chtimes(entry, now, now)
+
+	return true, nil
+}
+
+// Save stores the project's node_modules under key and evicts what no
+// longer fits.
+func (c *DependencyCache) Save(app_id string, key string, project_root string) error {
+	source := filepath.Join(project_root, "node_modules")
+	if _, err := os.Stat(source); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	entry := c.entry_dir(app_id, key)
+	app_dir := filepath.Dir(entry)
+	if err := os.MkdirAll(app_dir, 0o750); err != nil {
+		return err
+	}
+
+	// copied aside first so a half written entry is never restored
+	staging, err := os.MkdirTemp(app_dir, ".staging-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging)
+
+	if err := copy_tree(source, filepath.Join(staging, "node_modules")); err != nil {
+		return err
+	}
+	size, err := tree_size(staging)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(staging, cache_size_file), []byte(strconv.FormatInt(size, 10)), 0o640); err != nil {
+		return err
+	}
+
+	unlock := c.lock_entry(entry, true)
+	if err := os.RemoveAll(entry); err != nil {
+		unlock()
+		return err
+	}
+	err = os.Rename(staging, entry)
+	unlock()
+	if err != nil {
+		return err
+	}
+
+	return c.Evict()
+}
+
+func (c *DependencyCache) Evict() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.evict()
+}
+
+type cache_entry struct {
+	path string
+	size int64
+	used_at time.Time
+}
+
+// remove_entry deletes an entry unless a build is using it. mu must be held.
+func (c *DependencyCache) remove_entry(path string) (bool, error) {
+	lock, ok := c.entries[path]
+	if ok {
+		if !lock.TryLock() {
+			return false, nil
+		}
+		defer lock.Unlock()
+	}
+
+	if err := os.RemoveAll(path); err != nil {
+		return false, err
+	}
+	delete(c.entries, path)
+
+	return true, nil
+}
+
+func (c *DependencyCache) evict() error {
+	entries, err := filepath.Glob(filepath.Join(c.dir, "*", "*"))
+	if err != nil {
+		return err
+	}
+
+	kept := []cache_entry{}
+	total := int64(0)
+	for _, path := range entries {
+		if strings.HasPrefix(filepath.Base(path), ".") {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil || !info.IsDir() {
+			continue
+		}
+
+		if c.max_age > 0 && time.Since(info.ModTime()) > c.max_age {
+			removed, err := c.remove_entry(path)
+			if err != nil {
+				return err
+			}
+			if removed {
+				continue
+			}
+		}
+
+		size := int64(0)
+		if content, err := os.ReadFile(filepath.Join(path, cache_size_file)); err == nil {
+			size, _ = strconv.ParseInt(string(content), 10, 64)
+		} else if size, err = tree_size(path); err != nil {
+			return err
+		}
+
+		kept = append(kept, cache_entry{path: path, size: size, used_at: info.ModTime()})
+		total += size
+	}
+
+	if c.max_size <= 0 || total <= c.max_size {
+		return nil
+	}
+
+	slices.SortFunc(kept, func(a cache_entry, b cache_entry) int {
+		return a.used_at.Compare(b.used_at)
+	})
+	for _, entry := range kept {
+		if total <= c.max_size {
+			break
+		}
+		removed, err := c.remove_entry(entry.path)
+		if err != nil {
+			return err
+		}
+		if removed {
+			total -= entry.size
+		}
+	}
+
+	return nil
+}
+
+// copy_tree copies regular files, directories and symlinks, node_modules/.bin
+// is made of the latter.
+func copy_tree(source string, target string) error {
+	return filepath.WalkDir(source, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+		dest := filepath.Join(target, rel)
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(dest, info.Mode().Perm()|0o700)
+		case info.Mode()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, dest)
+		case info.Mode().IsRegular():
+			return copy_file(path, dest, info.Mode().Perm())
+		}
+
+		return nil
+	})
+}
+
+func copy_file(source string, target string, mode fs.FileMode) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
+
+func tree_size(dir string) (int64, error) {
+	size := int64(0)
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.Type().IsRegular() {
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+
+	return size, err
+}
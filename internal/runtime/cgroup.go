@@ -0,0 +1,212 @@
+package runtime
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// cpu.max quotas are given per period, in microseconds
+const cgroup_cpu_period = 100000
+
+var cgroup_controllers = []string{"cpu", "memory"}
+
+// Cgroups places every locally run deployment in its own cgroup v2 under
+// root, with the cpu and memory controllers enabled. Root must be delegated
+// to the API server, e.g. with Delegate=yes on its systemd unit, and kept
+// apart from the cgroup the server itself runs in so a deployment can't
+// starve it.
+type Cgroups struct {
+	root string
+}
+
+// NewCgroups prepares root for deployment cgroups, creating it when missing
+func NewCgroups(root string) (*Cgroups, error) {
+	parent := filepath.Dir(root)
+
+	available, err := read_cgroup_list(filepath.Join(parent, "cgroup.controllers"))
+	if err != nil {
+		return nil, fmt.Errorf("cgroup v2 not available at %s: %w", parent, err)
+	}
+	for _, controller := range cgroup_controllers {
+		if !slices.Contains(available, controller) {
+			return nil, fmt.Errorf("cgroup controller %s not delegated to %s", controller, parent)
+		}
+	}
+
+	if err := enable_cgroup_controllers(parent); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, err
+	}
+	if err := enable_cgroup_controllers(root); err != nil {
+		return nil, err
+	}
+
+	return &Cgroups{root: root}, nil
+}
+
+func read_cgroup_list(path string) ([]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return strings.Fields(string(content)), nil
+}
+
+// enable_cgroup_controllers makes the cpu and memory controllers available
+// to the children of dir.
+func enable_cgroup_controllers(dir string) error {
+	enabled, err := read_cgroup_list(filepath.Join(dir, "cgroup.subtree_control"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	missing := []string{}
+	for _, controller := range cgroup_controllers {
+		if !slices.Contains(enabled, controller) {
+			missing = append(missing, "+"+controller)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "cgroup.subtree_control"), []byte(strings.Join(missing, " ")), 0o644); err != nil {
+		return fmt.Errorf("unable to enable cgroup controllers in %s: %w", dir, err)
+	}
+
+	return nil
+}
+
+func cgroup_cpu_max(cpu_millis int32) string {
+	if cpu_millis <= 0 {
+		return fmt.Sprintf("max %d", cgroup_cpu_period)
+	}
+
+	return fmt.Sprintf("%d %d", int64(cpu_millis)*cgroup_cpu_period/1000, cgroup_cpu_period)
+}
+
+func cgroup_memory_max(memory_mb int32) string {
+	if memory_mb <= 0 {
+		return "max"
+	}
+
+	return strconv.FormatInt(int64(memory_mb)*1024*1024, 10)
+}
+
+// cgroup is the cgroup of one deployment
+type cgroup struct {
+	path string
+}
+
+// group returns the deployment's cgroup, whether it exists or not
+func (c *Cgroups) group(deployment_id string) *cgroup {
+	return &cgroup{path: filepath.Join(c.root, "dp-"+filepath.Base(deployment_id))}
+}
+
+// populated returns the ids of the deployments whose cgroup holds processes,
+// including ones started by a previous run of the server.
+func (c *Cgroups) populated() ([]string, error) {
+	entries, err := os.ReadDir(c.root)
+	if err != nil {
+		return nil, err
+	}
+
+	deployment_ids := []string{}
+	for _, entry := range entries {
+		deployment_id, ok := strings.CutPrefix(entry.Name(), "dp-")
+		if !entry.IsDir() || !ok {
+			continue
+		}
+		if c.group(deployment_id).populated() {
+			deployment_ids = append(deployment_ids, deployment_id)
+		}
+	}
+
+	return deployment_ids, nil
+}
+
+// create makes a fresh cgroup for the deployment with resources applied. A
+// cgroup left by a previous run of the same deployment is removed first.
+func (c *Cgroups) create(deployment_id string, resources Resources) (*cgroup, error) {
+	group := c.group(deployment_id)
+	if _, err := os.Stat(group.path); err == nil {
+		group.kill()
+		group.remove()
+	}
+
+	if err := os.Mkdir(group.path, 0o755); err != nil {
+		return nil, err
+	}
+
+	limits := []struct {
+		file string
+		value string
+	}{
+		{"cpu.max", cgroup_cpu_max(resources.CPUMillis)},
+		{"memory.max", cgroup_memory_max(resources.MemoryMB)},
+	}
+	for _, limit := range limits {
+		if err := group.write(limit.file, limit.value); err != nil {
+			group.remove()
+			return nil, fmt.Errorf("unable to set %s: %w", limit.file, err)
+		}
+	}
+
+	// best effort, kernels without swap accounting don't have the file and
+	// older ones can't kill the whole group on OOM
+	if resources.MemoryMB > 0 {
+		group.write("memory.swap.max", "0")
+	}
+	group.write("memory.oom.group", "1")
+
+	return group, nil
+}
+
+func (g *cgroup) write(file string, value string) error {
+	return os.WriteFile(filepath.Join(g.path, file), []byte(value), 0o644)
+}
+
+// oom_killed tells whether the kernel killed a process of the group for
+// going over memory.max.
+func (g *cgroup) oom_killed() bool {
+	content, err := os.ReadFile(filepath.Join(g.path, "memory.events"))
+	if err != nil {
+		return false
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "oom_kill" {
+			count, _ := strconv.Atoi(fields[1])
+			return count > 0
+		}
+	}
+
+	return false
+}
+
+func (g *cgroup) populated() bool {
+	procs, err := read_cgroup_list(filepath.Join(g.path, "cgroup.procs"))
+	return err == nil && len(procs) > 0
+}
+
+// kill reaches the processes that left the process group as well
+func (g *cgroup) kill() {
+	g.write("cgroup.kill", "1")
+}
+
+// remove deletes the group once it is empty, a live cgroup can't be removed
+func (g *cgroup) remove() error {
+	return os.RemoveAll(g.path)
+}
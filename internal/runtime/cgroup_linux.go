@@ -0,0 +1,25 @@
+package runtime
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// attach makes cmd start inside the group, so not even the first instruction
+// of the process runs unconstrained. The returned func releases the group's
+// descriptor once the process started.
+func (g *cgroup) attach(cmd *exec.Cmd) (func(), error) {
+	dir, err := os.Open(g.path)
+	if err != nil {
+		return nil, err
+	}
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(dir.Fd())
+
+	return func() { dir.Close() }, nil
+}
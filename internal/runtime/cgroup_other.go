@@ -0,0 +1,13 @@
+//go:build !linux
+
+package runtime
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// attach needs Linux, cgroups don't exist elsewhere
+func (g *cgroup) attach(cmd *exec.Cmd) (func(), error) {
+	return nil, errors.New("cgroups_unsupported")
+}
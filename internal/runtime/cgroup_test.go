@@ -0,0 +1,209 @@
+package runtime
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func read_test_file(t *testing.T, path string) string {
+	t.Helper()
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("got error reading %s %v, want nil", path, err)
+	}
+
+	return string(content)
+}
+
+// new_test_cgroups sets up a fake cgroup v2 hierarchy in a temp dir
+func new_test_cgroups(t *testing.T) (*Cgroups, string) {
+	t.Helper()
+
+	parent := t.TempDir()
+	os.WriteFile(filepath.Join(parent, "cgroup.controllers"), []byte("cpuset cpu io memory pids\n"), 0o644)
+
+	cgroups, err := NewCgroups(filepath.Join(parent, "capybara"))
+	if err != nil {
+		t.Fatalf("got error %v, want nil", err)
+	}
+
+	return cgroups, parent
+}
+
+func TestCgroups(t *testing.T) {
+	t.Run("should refuse hierarchies without the cpu and memory controllers", func (t *testing.T) {
+		parent := t.TempDir()
+		os.WriteFile(filepath.Join(parent, "cgroup.controllers"), []byte("cpu io pids\n"), 0o644)
+
+		if _, err := NewCgroups(filepath.Join(parent, "capybara")); err == nil {
+			t.Errorf("got nil error, want the missing memory controller reported")
+		}
+		if _, err := NewCgroups(filepath.Join(t.TempDir(), "capybara")); err == nil {
+			t.Errorf("got nil error, want cgroup v2 reported unavailable")
+		}
+	})
+
+	t.Run("should enable the controllers for deployment groups", func (t *testing.T) {
+		_, parent := new_test_cgroups(t)
+
+		for _, dir := range []string{parent, filepath.Join(parent, "capybara")} {
+			if got := read_test_file(t, filepath.Join(dir, "cgroup.subtree_control")); got != "+cpu +memory" {
+				t.Errorf("got subtree control %q in %s, want +cpu +memory", got, dir)
+			}
+		}
+	})
+
+	t.Run("should write the limits into the deployment's group", func (t *testing.T) {
+		cgroups, parent := new_test_cgroups(t)
+
+		group, err := cgroups.create("dp-1", Resources{CPUMillis: 500, MemoryMB: 256})
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		if group.path != filepath.Join(parent, "capybara", "dp-dp-1") {
+			t.Errorf("got group %s, want it under the root", group.path)
+		}
+		want := map[string]string{
+			"cpu.max": "50000 100000",
+			"memory.max": "268435456",
+			"memory.swap.max": "0",
+			"memory.oom.group": "1",
+		}
+		for file, value := range want {
+			if got := read_test_file(t, filepath.Join(group.path, file)); got != value {
+				t.Errorf("got %s %q, want %q", file, got, value)
+			}
+		}
+	})
+
+	t.Run("should leave unset resources unlimited", func (t *testing.T) {
+		cgroups, _ := new_test_cgroups(t)
+
+		group, err := cgroups.create("dp-1", Resources{})
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		if got := read_test_file(t, filepath.Join(group.path, "cpu.max")); got != "max 100000" {
+			t.Errorf("got cpu.max %q, want max", got)
+		}
+		if got := read_test_file(t, filepath.Join(group.path, "memory.max")); got != "max" {
+			t.Errorf("got memory.max %q, want max", got)
+		}
+	})
+
+	t.Run("should replace the group of a previous run", func (t *testing.T) {
+		cgroups, _ := new_test_cgroups(t)
+
+		first, _ := cgroups.create("dp-1", Resources{MemoryMB: 128})
+		os.WriteFile(filepath.Join(first.path, "memory.events"), []byte("oom_kill 1\n"), 0o644)
+
+		second, err := cgroups.create("../dp-1", Resources{MemoryMB: 256})
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		if second.path != first.path {
+			t.Errorf("got group %s, want %s with the id kept inside the root", second.path, first.path)
+		}
+		if second.oom_killed() {
+			t.Errorf("got the previous run's OOM kill, want a fresh group")
+		}
+	})
+
+	t.Run("should tell OOM kills from the memory events", func (t *testing.T) {
+		cgroups, _ := new_test_cgroups(t)
+		group, _ := cgroups.create("dp-1", Resources{MemoryMB: 128})
+
+		if group.oom_killed() {
+			t.Errorf("got OOM killed without memory events, want false")
+		}
+
+		os.WriteFile(filepath.Join(group.path, "memory.events"), []byte("low 0\nhigh 0\nmax 12\noom 0\noom_kill 0\n"), 0o644)
+		if group.oom_killed() {
+			t.Errorf("got OOM killed after hitting the limit only, want false")
+		}
+
+		os.WriteFile(filepath.Join(group.path, "memory.events"), []byte("low 0\nhigh 0\nmax 40\noom 1\noom_kill 1\n"), 0o644)
+		if !group.oom_killed() {
+			t.Errorf("got not OOM killed, want true")
+		}
+	})
+
+	t.Run("should find and stop processes left by a previous run", func (t *testing.T) {
+		cgroups, _ := new_test_cgroups(t)
+		left, _ := cgroups.create("dp-1", Resources{})
+		os.WriteFile(filepath.Join(left.path, "cgroup.procs"), []byte("4242\n"), 0o644)
+		empty, _ := cgroups.create("dp-2", Resources{})
+
+		local := NewLocalRuntime(context.Background(), 100*time.Millisecond, nil, cgroups, "")
+
+		listed, err := local.List()
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if len(listed) != 1 || listed[0] != "dp-1" {
+			t.Errorf("got listed %v, want only the populated group", listed)
+		}
+
+		if err := local.Stop("dp-1"); err != nil {
+			t.Fatalf("got error stopping %v, want nil", err)
+		}
+		if _, err := os.Stat(left.path); !os.IsNotExist(err) {
+			t.Errorf("got group %s kept, want it removed", left.path)
+		}
+		if _, err := os.Stat(empty.path); err != nil {
+			t.Errorf("got group %s removed, want it left alone", empty.path)
+		}
+
+		if err := local.Stop("dp-3"); err == nil || err.Error() != "not_found" {
+			t.Errorf("got error %v, want not_found", err)
+		}
+	})
+
+	// needs a delegated cgroup v2 subtree, e.g. CGROUP_TEST_ROOT=/sys/fs/cgroup/capybara-test
+	t.Run("should report OOM kills of processes over the memory limit", func (t *testing.T) {
+		root := os.Getenv("CGROUP_TEST_ROOT")
+		if root == "" {
+			t.Skip("CGROUP_TEST_ROOT is not set")
+		}
+
+		cgroups, err := NewCgroups(root)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		artifacts_path := t.TempDir()
+		write_test_bundle(t, artifacts_path, map[string]string{
+			"package.json": `{"scripts": {"start": "tail /dev/zero"}}`,
+		})
+
+		local := NewLocalRuntime(context.Background(), time.Second, nil, cgroups, "")
+		spec := Spec{DeploymentID: "dp-oom", ArtifactsPath: artifacts_path, Resources: Resources{MemoryMB: 16}}
+		if err := local.Prepare(spec); err != nil {
+			t.Fatalf("got error preparing %v, want nil", err)
+		}
+		if _, err := local.Start(spec); err != nil {
+			t.Fatalf("got error starting %v, want nil", err)
+		}
+		defer local.Stop(spec.DeploymentID)
+
+		exited := wait_for(t, 10*time.Second, func() bool {
+			status, _ := local.Status(spec.DeploymentID)
+			return status.State == StateExited
+		})
+		if !exited {
+			t.Fatalf("got the process running, want it killed at the limit")
+		}
+
+		status, _ := local.Status(spec.DeploymentID)
+		if !status.OOMKilled {
+			t.Errorf("got status %+v, want OOM killed", status)
+		}
+	})
+}
@@ -0,0 +1,737 @@
+package runtime
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const docker_api_version = "v1.43"
+
+type docker_runtime struct {
+	ctx context.Context
+	client *http.Client
+	base_images map[string]string
+	stop_timeout time.Duration
+}
+
+// DefaultBaseImages are the images projects of every stack are built on
+func DefaultBaseImages() map[string]string {
+	return map[string]string{
+		StackNode: "node:20-alpine",
+		StackPython: "python:3.12-slim",
+		StackGo: "golang:1.24-alpine",
+		StackProcfile: "debian:bookworm-slim",
+	}
+}
+
+// NewDockerRuntime runs deployments as containers by talking to the Docker
+// Engine API on the given unix socket, e.g. /var/run/docker.sock. Base
+// images replace the default ones of their stacks.
+func NewDockerRuntime(ctx context.Context, socket_path string, base_images map[string]string, stop_timeout time.Duration) Runtime {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _ string, _ string) (net.Conn, error) {
+			dialer := net.Dialer{}
+			return dialer.DialContext(ctx, "unix", socket_path)
+		},
+	}
+
+	images := DefaultBaseImages()
+	for stack, image := range base_images {
+		images[stack] = image
+	}
+
+	return &docker_runtime{
+		ctx: ctx,
+		client: &http.Client{Transport: transport},
+		base_images: images,
+		stop_timeout: stop_timeout,
+	}
+}
+
+func image_tag(deployment_id string) string {
+	return fmt.Sprintf("capybara/%s:latest", deployment_id)
+}
+
+func container_name(deployment_id string) string {
+	return fmt.Sprintf("capybara-%s", deployment_id)
+}
+
+type docker_error struct {
+	Message string `json:"message"`
+}
+
+func (r *docker_runtime) request(method string, path string, query url.Values, content_type string, body io.Reader) (*http.Response, error) {
+	return r.request_context(r.ctx, method, path, query, content_type, body)
+}
+
+func (r *docker_runtime) request_context(ctx context.Context, method string, path string, query url.Values, content_type string, body io.Reader) (*http.Response, error) {
+	endpoint := fmt.Sprintf("http://docker/%s%s", docker_api_version, path)
+	if len(query) > 0 {
+		endpoint = endpoint + "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+	if content_type != "" {
+		req.Header.Set("Content-Type", content_type)
+	}
+
+	return r.client.Do(req)
+}
+
+// expect closes the response and turns unexpected status codes into errors
+// carrying the daemon's message.
+func expect(res *http.Response, statuses ...int) error {
+	defer res.Body.Close()
+
+	for _, status := range statuses {
+		if res.StatusCode == status {
+			io.Copy(io.Discard, res.Body)
+			return nil
+		}
+	}
+
+	var body docker_error
+	json.NewDecoder(res.Body).Decode(&body)
+
+	return fmt.Errorf("docker engine returned %d: %s", res.StatusCode, body.Message)
+}
+
+var env_name_regex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// dockerfile copies the manifests before the sources, so the install layer is
+// reused by every build of an unchanged lockfile. The stack's environment is
+// only declared, its values come in as build args and are never parsed as
+// Dockerfile syntax.
+func dockerfile(base_image string, plan StackPlan) ([]byte, error) {
+	cmd, _ := json.Marshal([]string{"sh", "-c", plan.StartCommand})
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "FROM %s\nWORKDIR /app\n", base_image)
+	// node images ship npm and yarn, pnpm comes through corepack
+	if plan.Node != nil && plan.Node.PackageManager == PackageManagerPnpm && plan.InstallCommand != "" {
+		b.WriteString("RUN corepack enable pnpm\n")
+	}
+	if plan.InstallCommand != "" && len(plan.Manifests) > 0 {
+		manifests, _ := json.Marshal(append(slices.Clone(plan.Manifests), "./"))
+		fmt.Fprintf(&b, "COPY %s\nRUN %s\n", manifests, plan.InstallCommand)
+	}
+	b.WriteString("COPY . .\n")
+	if plan.InstallCommand != "" && len(plan.Manifests) == 0 {
+		fmt.Fprintf(&b, "RUN %s\n", plan.InstallCommand)
+	}
+	if plan.BuildCommand != "" {
+		fmt.Fprintf(&b, "RUN %s\n", plan.BuildCommand)
+	}
+	for _, key := range slices.Sorted(maps.Keys(plan.Env)) {
+		if !env_name_regex.MatchString(key) {
+			return nil, fmt.Errorf("invalid environment variable name %q", key)
+		}
+		fmt.Fprintf(&b, "ARG %s\nENV %s=${%s}\n", key, key, key)
+	}
+	fmt.Fprintf(&b, "CMD %s\n", cmd)
+
+	return []byte(b.String()), nil
+}
+
+// build_context streams a tar of the project root together with a generated
+// Dockerfile, written while the engine reads it. Walking errors surface to the
+// reader, closing it stops the walk.
+func build_context(project_root string, dockerfile []byte) *io.PipeReader {
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(write_build_context(writer, project_root, dockerfile))
+	}()
+
+	return reader
+}
+
+func write_build_context(w io.Writer, project_root string, dockerfile []byte) error {
+	tw := tar.NewWriter(w)
+
+	err := filepath.WalkDir(project_root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(project_root, path)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() && !info.IsDir() {
+			return nil
+		}
+
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(tw, file)
+
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	err = tw.WriteHeader(&tar.Header{
+		Name: "Dockerfile",
+		Mode: 0o644,
+		Size: int64(len(dockerfile)),
+		Typeflag: tar.TypeReg,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := tw.Write(dockerfile); err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+type build_message struct {
+	Stream string `json:"stream"`
+	Error string `json:"error"`
+}
+
+func (r *docker_runtime) Prepare(spec Spec) error {
+	bundle_path, err := FindBundle(spec.ArtifactsPath)
+	if err != nil {
+		return err
+	}
+
+	source_dir := SourceDir(spec.ArtifactsPath)
+	if err := os.RemoveAll(source_dir); err != nil {
+		return err
+	}
+	if err := ExtractBundle(bundle_path, source_dir, spec.BundleLimits); err != nil {
+		return err
+	}
+	spec.report(PhaseBuilding)
+
+	// there is no image to run, only the files a build container writes
+	if spec.Static {
+		return prepare_static(spec, source_dir, func(root string, plan StackPlan) error {
+			return r.build_static(spec, root, plan)
+		})
+	}
+
+	project_root, plan, err := plan_project(source_dir, spec)
+	if err != nil {
+		return err
+	}
+	base_image, ok := r.base_images[plan.Stack]
+	if !ok {
+		return fmt.Errorf("no base image for the %s stack", plan.Stack)
+	}
+	spec.report_stack(plan.Stack)
+
+	return r.build_image(spec, image_tag(spec.build_id()), base_image, project_root, plan)
+}
+
+// build_image builds the project at project_root into an image tagged tag,
+// forwarding the build output to the logs of spec.
+func (r *docker_runtime) build_image(spec Spec, tag string, base_image string, project_root string, plan StackPlan) error {
+	file, err := dockerfile(base_image, plan)
+	if err != nil {
+		return err
+	}
+	build_args, err := json.Marshal(plan.Env)
+	if err != nil {
+		return err
+	}
+
+	context_tar := build_context(project_root, file)
+	defer context_tar.Close()
+
+	query := url.Values{}
+	query.Set("t", tag)
+	query.Set("rm", "1")
+	query.Set("forcerm", "1")
+	query.Set("buildargs", string(build_args))
+
+	res, err := r.request_context(spec.context_or(r.ctx), http.MethodPost, "/build", query, "application/x-tar", context_tar)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return expect(res, http.StatusOK)
+	}
+
+	// the build result is only known once the progress stream ends
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		var message build_message
+		if err := json.Unmarshal(scanner.Bytes(), &message); err != nil {
+			continue
+		}
+		if message.Error != "" {
+			spec.log(LogLine{Phase: LogPhaseBuild, Stream: "stderr", Line: message.Error, Time: time.Now()})
+			return fmt.Errorf("image build failed: %s", message.Error)
+		}
+		if line := strings.TrimRight(message.Stream, "\n"); line != "" {
+			spec.log(LogLine{Phase: LogPhaseBuild, Stream: "stdout", Line: line, Time: time.Now()})
+		}
+	}
+
+	return scanner.Err()
+}
+
+// build_static runs the build script of a static site in an image built on
+// its stack, then copies the output directories out of a container of it
+// that is never started. Only the copied files reach the host.
+func (r *docker_runtime) build_static(spec Spec, root string, plan StackPlan) error {
+	base_image, ok := r.base_images[plan.Stack]
+	if !ok {
+		return fmt.Errorf("no base image for the %s stack", plan.Stack)
+	}
+
+	tag := image_tag(spec.build_id())
+	if err := r.build_image(spec, tag, base_image, root, plan); err != nil {
+		return err
+	}
+	defer r.remove_image(tag)
+
+	payload, err := json.Marshal(create_container_request{Image: tag, Env: []string{}})
+	if err != nil {
+		return err
+	}
+	res, err := r.request(http.MethodPost, "/containers/create", nil, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusCreated {
+		return expect(res, http.StatusCreated)
+	}
+
+	var created create_container_response
+	err = json.NewDecoder(res.Body).Decode(&created)
+	res.Body.Close()
+	if err != nil {
+		return err
+	}
+	defer r.remove_build_container(created.ID)
+
+	for _, dir := range static_output_dirs {
+		if err := r.copy_out(spec, created.ID, dir, root); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// copy_out replaces dir under root with the one the build left in /app of
+// the container, when there is one.
+func (r *docker_runtime) copy_out(spec Spec, container_id string, dir string, root string) error {
+	query := url.Values{}
+	query.Set("path", "/app/"+dir)
+
+	res, err := r.request_context(spec.context_or(r.ctx), http.MethodGet, "/containers/"+container_id+"/archive", query, "", nil)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode == http.StatusNotFound {
+		res.Body.Close()
+		return nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return expect(res, http.StatusOK)
+	}
+	defer res.Body.Close()
+
+	if err := os.RemoveAll(filepath.Join(root, dir)); err != nil {
+		return err
+	}
+	build_log(spec, "stdout", "Copying "+dir+" out of the build container")
+
+	// the archive holds dir itself, not only its contents
+	return extract_tar(res.Body, root, spec.BundleLimits)
+}
+
+func (r *docker_runtime) remove_build_container(container_id string) {
+	query := url.Values{}
+	query.Set("force", "1")
+
+	res, err := r.request(http.MethodDelete, "/containers/"+container_id, query, "", nil)
+	if err == nil {
+		err = expect(res, http.StatusNoContent, http.StatusNotFound)
+	}
+	if err != nil {
+		fmt.Println("Error removing build container", container_id, err.Error())
+	}
+}
+
+func (r *docker_runtime) remove_image(tag string) {
+	query := url.Values{}
+	query.Set("force", "1")
+
+	res, err := r.request(http.MethodDelete, "/images/"+tag, query, "", nil)
+	if err == nil {
+		err = expect(res, http.StatusOK, http.StatusNotFound)
+	}
+	if err != nil {
+		fmt.Println("Error removing build image", tag, err.Error())
+	}
+}
+
+type create_container_request struct {
+	Image string `json:"Image"`
+	Cmd []string `json:"Cmd,omitempty"`
+	Env []string `json:"Env"`
+	Labels map[string]string `json:"Labels"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+	HostConfig create_container_host_config `json:"HostConfig"`
+}
+
+type create_container_host_config struct {
+	PortBindings map[string][]port_binding `json:"PortBindings,omitempty"`
+	NanoCPUs int64 `json:"NanoCpus,omitempty"`
+	Memory int64 `json:"Memory,omitempty"`
+	MemorySwap int64 `json:"MemorySwap,omitempty"`
+}
+
+type port_binding struct {
+	HostIP string `json:"HostIp"`
+	HostPort string `json:"HostPort"`
+}
+
+type create_container_response struct {
+	ID string `json:"Id"`
+}
+
+func (r *docker_runtime) remove_container(deployment_id string) error {
+	query := url.Values{}
+	query.Set("force", "1")
+
+	res, err := r.request(http.MethodDelete, "/containers/"+container_name(deployment_id), query, "", nil)
+	if err != nil {
+		return err
+	}
+
+	return expect(res, http.StatusNoContent, http.StatusNotFound)
+}
+
+func (r *docker_runtime) Start(spec Spec) (*Instance, error) {
+	// a previous run of the same deployment keeps the name taken
+	if err := r.remove_container(spec.DeploymentID); err != nil {
+		return nil, err
+	}
+
+	body := create_container_request{
+		Image: image_tag(spec.build_id()),
+		Env: []string{},
+		Labels: map[string]string{
+			"capybara.deployment_id": spec.DeploymentID,
+			"capybara.app_id": spec.AppID,
+		},
+	}
+	for key, val := range spec.Variables {
+		body.Env = append(body.Env, fmt.Sprintf("%s=%s", key, val))
+	}
+	// the image runs the command it was built with unless told otherwise
+	if spec.Command != "" {
+		body.Cmd = []string{"sh", "-c", spec.Command}
+	}
+
+	// docker puts the container in its own cgroup with these limits
+	body.HostConfig.NanoCPUs = int64(spec.Resources.CPUMillis) * 1000000
+	if spec.Resources.MemoryMB > 0 {
+		body.HostConfig.Memory = int64(spec.Resources.MemoryMB) * 1024 * 1024
+		// same as Memory so the container can't swap past the limit
+		body.HostConfig.MemorySwap = body.HostConfig.Memory
+	}
+
+	if port, ok := spec.Variables["PORT"]; ok {
+		container_port := fmt.Sprintf("%s/tcp", port)
+		body.ExposedPorts = map[string]struct{}{container_port: {}}
+		body.HostConfig.PortBindings = map[string][]port_binding{
+			container_port: {{HostIP: "127.0.0.1", HostPort: port}},
+		}
+	}
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{}
+	query.Set("name", container_name(spec.DeploymentID))
+
+	res, err := r.request(http.MethodPost, "/containers/create", query, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusCreated {
+		return nil, expect(res, http.StatusCreated)
+	}
+
+	var created create_container_response
+	err = json.NewDecoder(res.Body).Decode(&created)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	res, err = r.request(http.MethodPost, "/containers/"+created.ID+"/start", nil, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := expect(res, http.StatusNoContent, http.StatusNotModified); err != nil {
+		return nil, err
+	}
+
+	process := start_process(spec)
+	if spec.OnLog != nil {
+		go r.follow_logs(spec, process)
+	}
+
+	return &Instance{
+		Process: process,
+		ProcessName: container_name(spec.DeploymentID),
+		ContainerName: created.ID,
+	}, nil
+}
+
+func (r *docker_runtime) Stop(deployment_id string) error {
+	query := url.Values{}
+	query.Set("t", strconv.Itoa(int(r.stop_timeout.Seconds())))
+
+	res, err := r.request(http.MethodPost, "/containers/"+container_name(deployment_id)+"/stop", query, "", nil)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode == http.StatusNotFound {
+		res.Body.Close()
+		return errors.New("not_found")
+	}
+	if err := expect(res, http.StatusNoContent, http.StatusNotModified); err != nil {
+		return err
+	}
+
+	return r.remove_container(deployment_id)
+}
+
+type inspect_container_response struct {
+	State struct {
+		Running bool `json:"Running"`
+		OOMKilled bool `json:"OOMKilled"`
+		Pid int `json:"Pid"`
+		ExitCode int `json:"ExitCode"`
+		StartedAt time.Time `json:"StartedAt"`
+		FinishedAt time.Time `json:"FinishedAt"`
+	} `json:"State"`
+}
+
+func (r *docker_runtime) Status(deployment_id string) (*Status, error) {
+	res, err := r.request(http.MethodGet, "/containers/"+container_name(deployment_id)+"/json", nil, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == http.StatusNotFound {
+		res.Body.Close()
+		return &Status{State: StateNotFound}, nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, expect(res, http.StatusOK)
+	}
+	defer res.Body.Close()
+
+	var inspected inspect_container_response
+	if err := json.NewDecoder(res.Body).Decode(&inspected); err != nil {
+		return nil, err
+	}
+
+	status := &Status{
+		State: StateExited,
+		Pid: inspected.State.Pid,
+		ExitCode: inspected.State.ExitCode,
+		StartedAt: inspected.State.StartedAt,
+		ExitedAt: inspected.State.FinishedAt,
+		OOMKilled: inspected.State.OOMKilled,
+	}
+	if inspected.State.Running {
+		status.State = StateRunning
+		status.ExitedAt = time.Time{}
+	}
+
+	return status, nil
+}
+
+type list_container_entry struct {
+	Labels map[string]string `json:"Labels"`
+}
+
+// List finds running containers by the label Start sets, so containers
+// outliving the API server are found as well.
+func (r *docker_runtime) List() ([]string, error) {
+	filters, err := json.Marshal(map[string][]string{"label": {"capybara.deployment_id"}})
+	if err != nil {
+		return nil, err
+	}
+	query := url.Values{}
+	query.Set("filters", string(filters))
+
+	res, err := r.request(http.MethodGet, "/containers/json", query, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, expect(res, http.StatusOK)
+	}
+	defer res.Body.Close()
+
+	var containers []list_container_entry
+	if err := json.NewDecoder(res.Body).Decode(&containers); err != nil {
+		return nil, err
+	}
+
+	deployment_ids := []string{}
+	for _, container := range containers {
+		deployment_ids = append(deployment_ids, container.Labels["capybara.deployment_id"])
+	}
+
+	return deployment_ids, nil
+}
+
+func (r *docker_runtime) Logs(deployment_id string, tail int) ([]LogLine, error) {
+	query := url.Values{}
+	query.Set("stdout", "1")
+	query.Set("stderr", "1")
+	query.Set("timestamps", "1")
+	if tail > 0 {
+		query.Set("tail", strconv.Itoa(tail))
+	} else {
+		query.Set("tail", "all")
+	}
+
+	res, err := r.request(http.MethodGet, "/containers/"+container_name(deployment_id)+"/logs", query, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == http.StatusNotFound {
+		res.Body.Close()
+		return nil, errors.New("not_found")
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, expect(res, http.StatusOK)
+	}
+	defer res.Body.Close()
+
+	lines := []LogLine{}
+	err = decode_multiplexed_logs(res.Body, func(line LogLine) {
+		lines = append(lines, line)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return lines, nil
+}
+
+// follow_logs streams the container output to spec.OnLog until the
+// container stops and the engine closes the stream.
+func (r *docker_runtime) follow_logs(spec Spec, process string) {
+	query := url.Values{}
+	query.Set("stdout", "1")
+	query.Set("stderr", "1")
+	query.Set("timestamps", "1")
+	query.Set("follow", "1")
+
+	res, err := r.request(http.MethodGet, "/containers/"+container_name(spec.DeploymentID)+"/logs", query, "", nil)
+	if err != nil {
+		fmt.Println("Error following container logs", spec.DeploymentID, err.Error())
+		return
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return
+	}
+
+	decode_multiplexed_logs(res.Body, func(line LogLine) {
+		line.Process = process
+		spec.log(line)
+	})
+}
+
+// decode_multiplexed_logs decodes the engine's stdcopy framing, an 8 byte
+// header (stream type, 3 bytes padding, big endian size) before each chunk.
+func decode_multiplexed_logs(body io.Reader, on_line func(line LogLine)) error {
+	header := make([]byte, 8)
+
+	for {
+		if _, err := io.ReadFull(body, header); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		stream := "stdout"
+		if header[0] == 2 {
+			stream = "stderr"
+		}
+
+		frame := make([]byte, binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.ReadFull(body, frame); err != nil {
+			return err
+		}
+
+		for _, raw := range strings.Split(strings.TrimRight(string(frame), "\n"), "\n") {
+			line := LogLine{Phase: LogPhaseRun, Stream: stream, Line: raw, Time: time.Now()}
+
+			// timestamps=1 prefixes every line with an RFC3339Nano time
+			if stamp, rest, found := strings.Cut(raw, " "); found {
+				if parsed, err := time.Parse(time.RFC3339Nano, stamp); err == nil {
+					line.Time = parsed
+					line.Line = rest
+				}
+			}
+
+			on_line(line)
+		}
+	}
+}
@@ -0,0 +1,500 @@
+package runtime
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fake_engine struct {
+	mu sync.Mutex
+	calls []string
+	build_files []string
+	build_dockerfile string
+	build_args string
+	archives map[string]map[string]string
+	created create_container_request
+	running bool
+	oom_killed bool
+	list_filters string
+}
+
+func (e *fake_engine) record(r *http.Request) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.calls = append(e.calls, r.Method+" "+strings.TrimPrefix(r.URL.Path, "/"+docker_api_version))
+}
+
+func (e *fake_engine) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.record(r)
+	path := strings.TrimPrefix(r.URL.Path, "/"+docker_api_version)
+
+	switch {
+	case r.Method == http.MethodPost && path == "/build":
+		e.build_args = r.URL.Query().Get("buildargs")
+		reader := tar.NewReader(r.Body)
+		for {
+			header, err := reader.Next()
+			if err != nil {
+				break
+			}
+			e.build_files = append(e.build_files, header.Name)
+			if header.Name == "Dockerfile" {
+				content, _ := io.ReadAll(reader)
+				e.build_dockerfile = string(content)
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"stream":"Step 1/5 : FROM node"}` + "\n"))
+		w.Write([]byte(`{"stream":"Successfully built"}` + "\n"))
+
+	case r.Method == http.MethodPost && path == "/containers/create":
+		json.NewDecoder(r.Body).Decode(&e.created)
+		if e.created.Image != "capybara/dp-1:latest" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message":"No such image: ` + e.created.Image + `"}`))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"Id":"c0ffee"}`))
+
+	case r.Method == http.MethodGet && path == "/containers/c0ffee/archive":
+		files, ok := e.archives[r.URL.Query().Get("path")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message":"Could not find the file"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-tar")
+		tw := tar.NewWriter(w)
+		for name, content := range files {
+			tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+			io.WriteString(tw, content)
+		}
+		tw.Close()
+
+	case r.Method == http.MethodPost && path == "/containers/c0ffee/start":
+		e.running = true
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodPost && strings.HasSuffix(path, "/stop"):
+		e.running = false
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodDelete:
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodGet && path == "/containers/json":
+		e.list_filters = r.URL.Query().Get("filters")
+		json.NewEncoder(w).Encode([]map[string]any{
+			{"Labels": map[string]string{"capybara.deployment_id": "dp-1", "capybara.app_id": "app-1"}},
+		})
+
+	case r.Method == http.MethodGet && strings.HasSuffix(path, "/json"):
+		json.NewEncoder(w).Encode(map[string]any{
+			"State": map[string]any{
+				"Running": e.running,
+				"OOMKilled": e.oom_killed,
+				"Pid": 4242,
+				"ExitCode": 0,
+				"StartedAt": "2026-01-02T03:04:05Z",
+				"FinishedAt": "0001-01-01T00:00:00Z",
+			},
+		})
+
+	case r.Method == http.MethodGet && strings.HasSuffix(path, "/logs"):
+		write_frame := func(stream byte, payload string) {
+			header := make([]byte, 8)
+			header[0] = stream
+			binary.BigEndian.PutUint32(header[4:], uint32(len(payload)))
+			w.Write(header)
+			io.WriteString(w, payload)
+		}
+		write_frame(1, "2026-01-02T03:04:05.000000001Z listening on 3000\n")
+		write_frame(2, "2026-01-02T03:04:06Z deprecation warning\n")
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"message":"no such route"}`))
+	}
+}
+
+func start_fake_engine(t *testing.T) (*fake_engine, string) {
+	t.Helper()
+
+	socket_path := filepath.Join(t.TempDir(), "docker.sock")
+	listener, err := net.Listen("unix", socket_path)
+	if err != nil {
+		t.Fatalf("got error listening on unix socket %v, want nil", err)
+	}
+
+	engine := &fake_engine{}
+	server := &http.Server{Handler: engine}
+	go server.Serve(listener)
+	t.Cleanup(func() {
+		server.Close()
+	})
+
+	return engine, socket_path
+}
+
+func TestDockerRuntime(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should build, create and start a container in order", func (t *testing.T) {
+		engine, socket_path := start_fake_engine(t)
+
+		artifacts_path := t.TempDir()
+		write_test_bundle(t, artifacts_path, map[string]string{
+			"package.json": `{"name": "hello", "scripts": {"start": "node index.js"}}`,
+			"index.js": `console.log("hello")`,
+		})
+
+		docker := NewDockerRuntime(ctx, socket_path, map[string]string{StackNode: "node:20-alpine"}, 5*time.Second)
+		spec := Spec{
+			DeploymentID: "dp-1",
+			AppID: "app-1",
+			ArtifactsPath: artifacts_path,
+			Resources: Resources{CPUMillis: 500, MemoryMB: 256},
+			Variables: map[string]string{"PORT": "3000"},
+		}
+
+		if err := docker.Prepare(spec); err != nil {
+			t.Fatalf("got error preparing %v, want nil", err)
+		}
+
+		instance, err := docker.Start(spec)
+		if err != nil {
+			t.Fatalf("got error starting %v, want nil", err)
+		}
+		if instance.ContainerName != "c0ffee" {
+			t.Errorf("got container name %s, want the container id c0ffee", instance.ContainerName)
+		}
+
+		if err := docker.Stop(spec.DeploymentID); err != nil {
+			t.Fatalf("got error stopping %v, want nil", err)
+		}
+
+		want_calls := []string{
+			"POST /build",
+			"DELETE /containers/capybara-dp-1",
+			"POST /containers/create",
+			"POST /containers/c0ffee/start",
+			"POST /containers/capybara-dp-1/stop",
+			"DELETE /containers/capybara-dp-1",
+		}
+		if strings.Join(engine.calls, "\n") != strings.Join(want_calls, "\n") {
+			t.Errorf("got calls\n%s\nwant\n%s", strings.Join(engine.calls, "\n"), strings.Join(want_calls, "\n"))
+		}
+
+		if !strings.Contains(strings.Join(engine.build_files, ","), "Dockerfile") {
+			t.Errorf("got build context %v, want a Dockerfile", engine.build_files)
+		}
+		if engine.build_args != `{"NODE_ENV":"production"}` {
+			t.Errorf("got build args %s, want the stack's environment", engine.build_args)
+		}
+		if engine.created.Image != "capybara/dp-1:latest" {
+			t.Errorf("got image %s, want capybara/dp-1:latest", engine.created.Image)
+		}
+		if len(engine.created.Env) != 1 || engine.created.Env[0] != "PORT=3000" {
+			t.Errorf("got env %v, want only PORT=3000", engine.created.Env)
+		}
+		if bindings := engine.created.HostConfig.PortBindings["3000/tcp"]; len(bindings) != 1 || bindings[0].HostPort != "3000" {
+			t.Errorf("got port bindings %v, want 3000/tcp bound to host port 3000", engine.created.HostConfig.PortBindings)
+		}
+		if host := engine.created.HostConfig; host.NanoCPUs != 500000000 || host.Memory != 256*1024*1024 || host.MemorySwap != host.Memory {
+			t.Errorf("got limits %+v, want half a core and 256 MB without swap", host)
+		}
+	})
+
+	t.Run("should run a given command on the image of the build", func (t *testing.T) {
+		engine, socket_path := start_fake_engine(t)
+
+		docker := NewDockerRuntime(ctx, socket_path, map[string]string{StackNode: "node:20-alpine"}, 5*time.Second)
+
+		_, err := docker.Start(Spec{DeploymentID: "run-1", BuildID: "dp-1", Command: "node report.js"})
+		if err != nil {
+			t.Fatalf("got error starting %v, want nil", err)
+		}
+
+		if engine.created.Image != "capybara/dp-1:latest" {
+			t.Errorf("got image %s, want the build's capybara/dp-1:latest", engine.created.Image)
+		}
+		if strings.Join(engine.created.Cmd, " ") != "sh -c node report.js" {
+			t.Errorf("got cmd %v, want the command run through sh", engine.created.Cmd)
+		}
+		if engine.created.Labels["capybara.deployment_id"] != "run-1" {
+			t.Errorf("got labels %v, want the run's id", engine.created.Labels)
+		}
+	})
+
+	t.Run("should report containers killed for memory", func (t *testing.T) {
+		engine, socket_path := start_fake_engine(t)
+		engine.oom_killed = true
+
+		docker := NewDockerRuntime(ctx, socket_path, map[string]string{StackNode: "node:20-alpine"}, 5*time.Second)
+
+		status, err := docker.Status("dp-1")
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if status.State != StateExited || !status.OOMKilled {
+			t.Errorf("got status %+v, want exited and OOM killed", status)
+		}
+	})
+
+	t.Run("should report status and decode multiplexed logs", func (t *testing.T) {
+		engine, socket_path := start_fake_engine(t)
+		engine.running = true
+
+		docker := NewDockerRuntime(ctx, socket_path, map[string]string{StackNode: "node:20-alpine"}, 5*time.Second)
+
+		status, err := docker.Status("dp-1")
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if status.State != StateRunning || status.Pid != 4242 {
+			t.Errorf("got status %+v, want running with pid 4242", status)
+		}
+
+		lines, err := docker.Logs("dp-1", 10)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if len(lines) != 2 {
+			t.Fatalf("got %d lines, want 2", len(lines))
+		}
+		if lines[0].Stream != "stdout" || lines[0].Line != "listening on 3000" {
+			t.Errorf("got first line %+v, want stdout 'listening on 3000'", lines[0])
+		}
+		if lines[1].Stream != "stderr" || lines[1].Time.Second() != 6 {
+			t.Errorf("got second line %+v, want stderr at :06", lines[1])
+		}
+	})
+
+	t.Run("should forward build output and followed container logs", func (t *testing.T) {
+		_, socket_path := start_fake_engine(t)
+
+		artifacts_path := t.TempDir()
+		write_test_bundle(t, artifacts_path, map[string]string{
+			"package.json": `{"name": "hello", "scripts": {"start": "node index.js"}}`,
+		})
+
+		var mu sync.Mutex
+		got_lines := []LogLine{}
+
+		docker := NewDockerRuntime(ctx, socket_path, map[string]string{StackNode: "node:20-alpine"}, 5*time.Second)
+		spec := Spec{
+			DeploymentID: "dp-1",
+			ArtifactsPath: artifacts_path,
+			OnLog: func(line LogLine) {
+				mu.Lock()
+				defer mu.Unlock()
+				got_lines = append(got_lines, line)
+			},
+		}
+
+		if err := docker.Prepare(spec); err != nil {
+			t.Fatalf("got error preparing %v, want nil", err)
+		}
+		if _, err := docker.Start(spec); err != nil {
+			t.Fatalf("got error starting %v, want nil", err)
+		}
+
+		ok := wait_for(t, 2*time.Second, func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(got_lines) == 4
+		})
+		if !ok {
+			t.Fatalf("got lines %+v, want 2 build and 2 run lines", got_lines)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if got_lines[0].Phase != LogPhaseBuild || got_lines[0].Line != "Step 1/5 : FROM node" {
+			t.Errorf("got first line %+v, want the build step", got_lines[0])
+		}
+		if got_lines[3].Phase != LogPhaseRun || got_lines[3].Stream != "stderr" {
+			t.Errorf("got last line %+v, want a stderr run line", got_lines[3])
+		}
+	})
+
+	t.Run("should build static sites in a container and copy the output out", func (t *testing.T) {
+		engine, socket_path := start_fake_engine(t)
+		engine.archives = map[string]map[string]string{
+			"/app/dist": {"dist/index.html": "<h1>built</h1>"},
+		}
+
+		// a build running on the host would leave this behind
+		bin := t.TempDir()
+		marker := filepath.Join(t.TempDir(), "built-on-host")
+		if err := os.WriteFile(filepath.Join(bin, "npm"), []byte("#!/bin/sh\ntouch "+marker+"\n"), 0o755); err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+		artifacts_path := t.TempDir()
+		write_test_bundle(t, artifacts_path, map[string]string{
+			"package.json": `{"name": "site", "scripts": {"build": "vite build"}}`,
+			"index.html": "<script src=/src/main.js></script>",
+		})
+
+		docker := NewDockerRuntime(ctx, socket_path, map[string]string{StackNode: "node:20-alpine"}, 5*time.Second)
+		if err := docker.Prepare(Spec{DeploymentID: "dp-1", ArtifactsPath: artifacts_path, Static: true}); err != nil {
+			t.Fatalf("got error preparing %v, want nil", err)
+		}
+
+		if _, err := os.Stat(marker); err == nil {
+			t.Errorf("got the build script run on the host, want it run in the build container")
+		}
+		if !strings.Contains(engine.build_dockerfile, "RUN npm run build") {
+			t.Errorf("got Dockerfile %q, want it to run the build script", engine.build_dockerfile)
+		}
+		root, _ := StaticRoot(SourceDir(artifacts_path))
+		if content, _ := os.ReadFile(filepath.Join(root, "index.html")); string(content) != "<h1>built</h1>" {
+			t.Errorf("got index %q served, want the one copied out of the container", content)
+		}
+
+		calls := strings.Join(engine.calls, "\n")
+		if !strings.HasPrefix(calls, "POST /build\nPOST /containers/create\nGET /containers/c0ffee/archive") {
+			t.Errorf("got calls\n%s\nwant the build, then its output copied out of a container", calls)
+		}
+		if !strings.HasSuffix(calls, "DELETE /containers/c0ffee\nDELETE /images/capybara/dp-1:latest") || strings.Contains(calls, "/start") {
+			t.Errorf("got calls\n%s\nwant the container never started and removed with the image", calls)
+		}
+	})
+
+	t.Run("should list the deployments of labelled containers", func (t *testing.T) {
+		engine, socket_path := start_fake_engine(t)
+
+		docker := NewDockerRuntime(ctx, socket_path, map[string]string{StackNode: "node:20-alpine"}, 5*time.Second)
+
+		listed, err := docker.List()
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if len(listed) != 1 || listed[0] != "dp-1" {
+			t.Errorf("got listed %v, want dp-1", listed)
+		}
+		if engine.list_filters != `{"label":["capybara.deployment_id"]}` {
+			t.Errorf("got filters %s, want the deployment label", engine.list_filters)
+		}
+	})
+
+	t.Run("should surface daemon errors", func (t *testing.T) {
+		_, socket_path := start_fake_engine(t)
+
+		docker := NewDockerRuntime(ctx, socket_path, map[string]string{StackNode: "node:20-alpine"}, 5*time.Second)
+
+		_, err := docker.Start(Spec{DeploymentID: "dp-2"})
+		if err == nil || !strings.Contains(err.Error(), "No such image: capybara/dp-2:latest") {
+			t.Errorf("got error %v, want the engine's message", err)
+		}
+	})
+}
+
+func TestDockerfile(t *testing.T) {
+	t.Run("should install from the manifests before copying the sources", func (t *testing.T) {
+		plan := BuildPlan{PackageManagerYarn, "yarn.lock", "yarn install --frozen-lockfile --production=false", "yarn run build"}
+
+		file, err := dockerfile("node:20-alpine", node_stack_plan(plan, "node dist/index.js"))
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		got := string(file)
+		want := "FROM node:20-alpine\n" +
+			"WORKDIR /app\n" +
+			"COPY [\"package.json\",\"yarn.lock\",\"./\"]\n" +
+			"RUN yarn install --frozen-lockfile --production=false\n" +
+			"COPY . .\n" +
+			"RUN yarn run build\n" +
+			"ARG NODE_ENV\n" +
+			"ENV NODE_ENV=${NODE_ENV}\n" +
+			"CMD [\"sh\",\"-c\",\"node dist/index.js\"]\n"
+		if got != want {
+			t.Errorf("got dockerfile\n%s\nwant\n%s", got, want)
+		}
+	})
+
+	t.Run("should only copy the sources when there is nothing to install", func (t *testing.T) {
+		file, _ := dockerfile("node:20-alpine", node_stack_plan(BuildPlan{PackageManager: PackageManagerNpm}, "node index.js"))
+
+		got := string(file)
+
+		if strings.Contains(got, "RUN") || !strings.Contains(got, "COPY . .") {
+			t.Errorf("got dockerfile\n%s\nwant no install or build step", got)
+		}
+	})
+
+	t.Run("should keep environment values out of the Dockerfile", func (t *testing.T) {
+		plan := StackPlan{StartCommand: "node index.js", Env: map[string]string{"GREETING": "hi\nRUN rm -rf / $HOME"}}
+
+		file, err := dockerfile("node:20-alpine", plan)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if got := string(file); strings.Contains(got, "rm -rf") || !strings.Contains(got, "ARG GREETING\nENV GREETING=${GREETING}\n") {
+			t.Errorf("got dockerfile\n%s\nwant the variable declared and its value left to the build args", got)
+		}
+
+		plan.Env = map[string]string{"BAD NAME=x\nRUN id": "1"}
+		if _, err := dockerfile("node:20-alpine", plan); err == nil {
+			t.Errorf("got nil error, want the invalid name refused")
+		}
+	})
+}
+
+func TestBuildContext(t *testing.T) {
+	t.Run("should stream the project with the Dockerfile", func (t *testing.T) {
+		root := t.TempDir()
+		if err := os.MkdirAll(filepath.Join(root, "src"), 0o755); err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if err := os.WriteFile(filepath.Join(root, "src", "index.js"), []byte(`console.log("hello")`), 0o644); err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		context_tar := build_context(root, []byte("FROM node:20-alpine\n"))
+		defer context_tar.Close()
+
+		files := map[string]string{}
+		reader := tar.NewReader(context_tar)
+		for {
+			header, err := reader.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("got error %v, want the whole context", err)
+			}
+			content, _ := io.ReadAll(reader)
+			files[header.Name] = string(content)
+		}
+
+		if files["src/index.js"] != `console.log("hello")` || files["Dockerfile"] != "FROM node:20-alpine\n" {
+			t.Errorf("got files %v, want the sources and the Dockerfile", files)
+		}
+	})
+
+	t.Run("should surface walking errors to the reader", func (t *testing.T) {
+		context_tar := build_context(filepath.Join(t.TempDir(), "missing"), []byte("FROM node:20-alpine\n"))
+		defer context_tar.Close()
+
+		if _, err := io.ReadAll(context_tar); err == nil {
+			t.Errorf("got nil error, want the missing project root")
+		}
+	})
+}
@@ -0,0 +1,560 @@
+package runtime
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	BundleReasonUnsupportedFormat = "unsupported_format"
+	BundleReasonCorrupt = "corrupt_archive"
+	BundleReasonPathTraversal = "path_traversal"
+	BundleReasonSymlinkEscape = "symlink_escape"
+	BundleReasonUnsupportedEntry = "unsupported_entry"
+	BundleReasonTooManyFiles = "too_many_files"
+	BundleReasonTooLarge = "too_large"
+	BundleReasonCompressionRatio = "compression_ratio"
+	BundleReasonUploadTooLarge = "upload_too_large"
+)
+
+// BundleError rejects an uploaded bundle, Context() is what API clients get
+// back to fix it.
+type BundleError struct {
+	Reason string
+	Entry string
+	Limit int64
+	Message string
+}
+
+func (e *BundleError) Error() string {
+	return "invalid bundle: " + e.Message
+}
+
+func (e *BundleError) Context() map[string]any {
+	context := map[string]any{"reason": e.Reason}
+	if e.Entry != "" {
+		context["entry"] = e.Entry
+	}
+	if e.Limit > 0 {
+		context["limit"] = e.Limit
+	}
+
+	return context
+}
+
+// ExtractLimits bounds what a bundle may expand to, MaxUploadSize bounds the
+// archive itself. Zero fields fall back to DefaultExtractLimits.
+// MaxCompressionRatio only applies once the content outgrows
+// min_ratio_checked_size, small text bundles compress well too.
+type ExtractLimits struct {
+	MaxFiles int64
+	MaxTotalSize int64
+	MaxCompressionRatio int64
+	MaxUploadSize int64
+}
+
+const min_ratio_checked_size = 10 * 1024 * 1024
+
+func DefaultExtractLimits() ExtractLimits {
+	return ExtractLimits{
+		MaxFiles: 20000,
+		MaxTotalSize: 1024 * 1024 * 1024,
+		MaxCompressionRatio: 100,
+		MaxUploadSize: 256 * 1024 * 1024,
+	}
+}
+
+func (l ExtractLimits) or_default() ExtractLimits {
+	defaults := DefaultExtractLimits()
+	if l.MaxFiles <= 0 {
+		l.MaxFiles = defaults.MaxFiles
+	}
+	if l.MaxTotalSize <= 0 {
+		l.MaxTotalSize = defaults.MaxTotalSize
+	}
+	if l.MaxCompressionRatio <= 0 {
+		l.MaxCompressionRatio = defaults.MaxCompressionRatio
+	}
+	if l.MaxUploadSize <= 0 {
+		l.MaxUploadSize = defaults.MaxUploadSize
+	}
+
+	return l
+}
+
+// UploadLimit is the largest archive a bundle may be before extraction
+func (l ExtractLimits) UploadLimit() int64 {
+	return l.or_default().MaxUploadSize
+}
+
+type bundle_format string
+
+const (
+	format_zip bundle_format = "zip"
+	format_tar bundle_format = "tar"
+	format_tar_gz bundle_format = "tar.gz"
+)
+
+// sniff_format goes by content, the stored name only reflects what the
+// client called the upload.
+func sniff_format(file *os.File) (bundle_format, error) {
+	header := make([]byte, 512)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	header = header[:n]
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	switch {
+	case bytes.HasPrefix(header, []byte("PK\x03\x04")), bytes.HasPrefix(header, []byte("PK\x05\x06")):
+		return format_zip, nil
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		return format_tar_gz, nil
+	case len(header) >= 262 && string(header[257:262]) == "ustar":
+		return format_tar, nil
+	}
+
+	return "", &BundleError{
+		Reason: BundleReasonUnsupportedFormat,
+		Message: "expected a .zip, .tar or .tar.gz archive",
+	}
+}
+
+type entry_type int
+
+const (
+	entry_dir entry_type = iota
+	entry_file
+	entry_symlink
+	entry_hardlink
+)
+
+type bundle_entry struct {
+	name string
+	kind entry_type
+	mode fs.FileMode
+	link string
+	open func() (io.ReadCloser, error)
+}
+
+// extractor applies one bundle's entries under root, or only validates them
+// when root is empty.
+type extractor struct {
+	root string
+	limits ExtractLimits
+	compressed_size int64
+	files int64
+	total_size int64
+	symlinks []string
+}
+
+func (x *extractor) check_name(name string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(strings.ReplaceAll(name, "\\", "/")))
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) || filepath.VolumeName(clean) != "" {
+		return "", &BundleError{
+			Reason: BundleReasonPathTraversal,
+			Entry: name,
+			Message: fmt.Sprintf("entry %s escapes the bundle root", name),
+		}
+	}
+
+	return clean, nil
+}
+
+// inside_root reports whether a link target resolved from the entry's
+// directory stays within the bundle.
+func inside_root(entry string, target string) bool {
+	if filepath.IsAbs(target) {
+		return false
+	}
+	resolved := filepath.Join(filepath.Dir(entry), target)
+
+	return resolved != ".." && !strings.HasPrefix(resolved, ".."+string(filepath.Separator))
+}
+
+// check_parents refuses to write through a symlink an earlier entry created
+func (x *extractor) check_parents(name string, rel string) error {
+	dir := filepath.Dir(rel)
+	for dir != "." {
+		info, err := os.Lstat(filepath.Join(x.root, dir))
+		if err == nil && info.Mode()&fs.ModeSymlink != 0 {
+			return &BundleError{
+				Reason: BundleReasonSymlinkEscape,
+				Entry: name,
+				Message: fmt.Sprintf("entry %s is written through the symlink %s", name, filepath.ToSlash(dir)),
+			}
+		}
+		dir = filepath.Dir(dir)
+	}
+
+	return nil
+}
+
+func (x *extractor) count_file(name string) error {
+	x.files += 1
+	if x.files > x.limits.MaxFiles {
+		return &BundleError{
+			Reason: BundleReasonTooManyFiles,
+			Entry: name,
+			Limit: x.limits.MaxFiles,
+			Message: fmt.Sprintf("more than %d entries", x.limits.MaxFiles),
+		}
+	}
+
+	return nil
+}
+
+func (x *extractor) check_size(name string) error {
+	if x.total_size > x.limits.MaxTotalSize {
+		return &BundleError{
+			Reason: BundleReasonTooLarge,
+			Entry: name,
+			Limit: x.limits.MaxTotalSize,
+			Message: fmt.Sprintf("expands to more than %d bytes", x.limits.MaxTotalSize),
+		}
+	}
+
+	if x.total_size > min_ratio_checked_size && x.total_size > x.compressed_size*x.limits.MaxCompressionRatio {
+		return &BundleError{
+			Reason: BundleReasonCompressionRatio,
+			Entry: name,
+			Limit: x.limits.MaxCompressionRatio,
+			Message: fmt.Sprintf("expands more than %d times its size", x.limits.MaxCompressionRatio),
+		}
+	}
+
+	return nil
+}
+
+// limited_writer counts every byte actually written, entry headers may lie
+// about their size.
+type limited_writer struct {
+	x *extractor
+	name string
+	writer io.Writer
+}
+
+func (w *limited_writer) Write(p []byte) (int, error) {
+	w.x.total_size += int64(len(p))
+	if err := w.x.check_size(w.name); err != nil {
+		return 0, err
+	}
+
+	return w.writer.Write(p)
+}
+
+func (x *extractor) apply(entry bundle_entry) error {
+	rel, err := x.check_name(entry.name)
+	if err != nil {
+		return err
+	}
+	if rel == "." {
+		return nil
+	}
+	if err := x.count_file(entry.name); err != nil {
+		return err
+	}
+
+	if entry.kind == entry_symlink || entry.kind == entry_hardlink {
+		// hard link targets are named from the bundle root
+		from := "."
+		if entry.kind == entry_symlink {
+			from = rel
+		}
+		if !inside_root(from, filepath.FromSlash(entry.link)) {
+			return &BundleError{
+				Reason: BundleReasonSymlinkEscape,
+				Entry: entry.name,
+				Message: fmt.Sprintf("link %s -> %s points outside the bundle root", entry.name, entry.link),
+			}
+		}
+	}
+
+	if x.root == "" {
+		if entry.kind != entry_file {
+			return nil
+		}
+		return x.copy(entry, io.Discard)
+	}
+
+	if err := x.check_parents(entry.name, rel); err != nil {
+		return err
+	}
+	target := filepath.Join(x.root, rel)
+	if entry.kind != entry_dir {
+		if err := os.MkdirAll(filepath.Dir(target), 0o750); err != nil {
+			return err
+		}
+		// a repeated entry replaces the earlier one, never writes through it
+		if info, err := os.Lstat(target); err == nil && !info.IsDir() {
+			if err := os.Remove(target); err != nil {
+				return err
+			}
+		}
+	}
+
+	switch entry.kind {
+	case entry_dir:
+		return os.MkdirAll(target, 0o750)
+	case entry_symlink:
+		if err := os.Symlink(filepath.FromSlash(entry.link), target); err != nil {
+			return err
+		}
+		x.symlinks = append(x.symlinks, rel)
+		return nil
+	case entry_hardlink:
+		source := filepath.Join(x.root, filepath.Clean(filepath.FromSlash(entry.link)))
+		if info, err := os.Lstat(source); err != nil || !info.Mode().IsRegular() {
+			return &BundleError{
+				Reason: BundleReasonUnsupportedEntry,
+				Entry: entry.name,
+				Message: fmt.Sprintf("hard link %s must point to an earlier regular file", entry.name),
+			}
+		}
+		return os.Link(source, target)
+	}
+
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_EXCL, entry.mode&0o755|0o600)
+	if err != nil {
+		return err
+	}
+	if err := x.copy(entry, file); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+func (x *extractor) copy(entry bundle_entry, dest io.Writer) error {
+	content, err := entry.open()
+	if err != nil {
+		return corrupt(err)
+	}
+	defer content.Close()
+
+	_, err = io.Copy(&limited_writer{x: x, name: entry.name, writer: dest}, content)
+	var bundle_err *BundleError
+	if err != nil && !errors.As(err, &bundle_err) {
+		return corrupt(err)
+	}
+
+	return err
+}
+
+// verify_symlinks resolves the links once everything is written, a link is
+// only known to stay inside once the links it goes through exist.
+func (x *extractor) verify_symlinks() error {
+	root, err := filepath.EvalSymlinks(x.root)
+	if err != nil {
+		return err
+	}
+
+	for _, rel := range x.symlinks {
+		resolved, err := filepath.EvalSymlinks(filepath.Join(x.root, rel))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err == nil {
+			rel_to_root, rel_err := filepath.Rel(root, resolved)
+			if rel_err == nil && rel_to_root != ".." && !strings.HasPrefix(rel_to_root, ".."+string(filepath.Separator)) {
+				continue
+			}
+		}
+
+		return &BundleError{
+			Reason: BundleReasonSymlinkEscape,
+			Entry: filepath.ToSlash(rel),
+			Message: fmt.Sprintf("link %s resolves outside the bundle root", filepath.ToSlash(rel)),
+		}
+	}
+
+	return nil
+}
+
+func corrupt(err error) error {
+	return &BundleError{
+		Reason: BundleReasonCorrupt,
+		Message: err.Error(),
+	}
+}
+
+func (x *extractor) walk_tar(reader io.Reader) error {
+	archive := tar.NewReader(reader)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return corrupt(err)
+		}
+
+		entry := bundle_entry{
+			name: header.Name,
+			mode: fs.FileMode(header.Mode).Perm(),
+			link: header.Linkname,
+			open: func() (io.ReadCloser, error) { return io.NopCloser(archive), nil },
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			entry.kind = entry_dir
+		case tar.TypeReg:
+			entry.kind = entry_file
+		case tar.TypeSymlink:
+			entry.kind = entry_symlink
+		case tar.TypeLink:
+			entry.kind = entry_hardlink
+		case tar.TypeXGlobalHeader:
+			continue
+		default:
+			return &BundleError{
+				Reason: BundleReasonUnsupportedEntry,
+				Entry: header.Name,
+				Message: fmt.Sprintf("entry %s is not a file, directory or link", header.Name),
+			}
+		}
+
+		if err := x.apply(entry); err != nil {
+			return err
+		}
+	}
+}
+
+func (x *extractor) walk_zip(file *os.File) error {
+	archive, err := zip.NewReader(file, x.compressed_size)
+	if err != nil {
+		return corrupt(err)
+	}
+
+	for _, zipped := range archive.File {
+		entry := bundle_entry{
+			name: zipped.Name,
+			mode: zipped.Mode().Perm(),
+			open: zipped.Open,
+		}
+		switch mode := zipped.Mode(); {
+		case mode.IsDir():
+			entry.kind = entry_dir
+		case mode&fs.ModeSymlink != 0:
+			entry.kind = entry_symlink
+			link, err := read_zip_link(zipped)
+			if err != nil {
+				return corrupt(err)
+			}
+			entry.link = link
+		case mode.IsRegular():
+			entry.kind = entry_file
+		default:
+			return &BundleError{
+				Reason: BundleReasonUnsupportedEntry,
+				Entry: zipped.Name,
+				Message: fmt.Sprintf("entry %s is not a file, directory or link", zipped.Name),
+			}
+		}
+
+		if err := x.apply(entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// zip stores a symlink's target as its content
+func read_zip_link(zipped *zip.File) (string, error) {
+	content, err := zipped.Open()
+	if err != nil {
+		return "", err
+	}
+	defer content.Close()
+
+	link, err := io.ReadAll(io.LimitReader(content, 4096))
+
+	return string(link), err
+}
+
+func walk_bundle(bundle_path string, x *extractor) error {
+	file, err := os.Open(bundle_path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	x.compressed_size = info.Size()
+
+	format, err := sniff_format(file)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case format_zip:
+		return x.walk_zip(file)
+	case format_tar:
+		return x.walk_tar(bufio.NewReader(file))
+	}
+
+	gz, err := gzip.NewReader(bufio.NewReader(file))
+	if err != nil {
+		return corrupt(err)
+	}
+	defer gz.Close()
+
+	return x.walk_tar(gz)
+}
+
+// InspectBundle validates a bundle against limits without writing anything
+func InspectBundle(bundle_path string, limits ExtractLimits) error {
+	return walk_bundle(bundle_path, &extractor{limits: limits.or_default()})
+}
+
+// extract_tar unpacks an uncompressed tar stream into dest, which exists,
+// with the checks bundles get. Nothing tells how large it was compressed, so
+// only its total size is limited.
+func extract_tar(reader io.Reader, dest string, limits ExtractLimits) error {
+	x := &extractor{root: dest, limits: limits.or_default()}
+	x.compressed_size = x.limits.MaxTotalSize
+	if err := x.walk_tar(reader); err != nil {
+		return err
+	}
+
+	return x.verify_symlinks()
+}
+
+// ExtractBundle unpacks a zip, tar or tar.gz bundle into dest. Nothing is
+// left behind when the bundle is rejected.
+func ExtractBundle(bundle_path string, dest string, limits ExtractLimits) error {
+	if err := os.MkdirAll(dest, 0o750); err != nil {
+		return err
+	}
+
+	x := &extractor{root: dest, limits: limits.or_default()}
+	err := walk_bundle(bundle_path, x)
+	if err == nil {
+		err = x.verify_symlinks()
+	}
+	if err != nil {
+		os.RemoveAll(dest)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,204 @@
+package runtime
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type test_entry struct {
+	name string
+	body string
+	link string
+	kind byte
+}
+
+func file_entry(name string, body string) test_entry {
+	return test_entry{name: name, body: body, kind: tar.TypeReg}
+}
+
+func symlink_entry(name string, link string) test_entry {
+	return test_entry{name: name, link: link, kind: tar.TypeSymlink}
+}
+
+func tar_bytes(t *testing.T, entries []test_entry) []byte {
+	t.Helper()
+
+	buf := bytes.NewBuffer([]byte{})
+	tw := tar.NewWriter(buf)
+	for _, entry := range entries {
+		tw.WriteHeader(&tar.Header{
+			Name: entry.name,
+			Linkname: entry.link,
+			Mode: 0o644,
+			Size: int64(len(entry.body)),
+			Typeflag: entry.kind,
+		})
+		tw.Write([]byte(entry.body))
+	}
+	tw.Close()
+
+	return buf.Bytes()
+}
+
+func write_bundle_file(t *testing.T, format bundle_format, entries []test_entry) string {
+	t.Helper()
+
+	var content []byte
+	switch format {
+	case format_tar:
+		content = tar_bytes(t, entries)
+	case format_tar_gz:
+		buf := bytes.NewBuffer([]byte{})
+		gz := gzip.NewWriter(buf)
+		gz.Write(tar_bytes(t, entries))
+		gz.Close()
+		content = buf.Bytes()
+	case format_zip:
+		buf := bytes.NewBuffer([]byte{})
+		zw := zip.NewWriter(buf)
+		for _, entry := range entries {
+			header := &zip.FileHeader{Name: entry.name, Method: zip.Deflate}
+			body := entry.body
+			if entry.kind == tar.TypeSymlink {
+				header.SetMode(fs.ModeSymlink | 0o777)
+				body = entry.link
+			} else {
+				header.SetMode(0o644)
+			}
+			w, _ := zw.CreateHeader(header)
+			w.Write([]byte(body))
+		}
+		zw.Close()
+		content = buf.Bytes()
+	}
+
+	path := filepath.Join(t.TempDir(), "bundle."+string(format))
+	if err := os.WriteFile(path, content, 0o640); err != nil {
+		t.Fatalf("got error writing bundle %v, want nil", err)
+	}
+
+	return path
+}
+
+func want_bundle_error(t *testing.T, err error, reason string) {
+	t.Helper()
+
+	var bundle_err *BundleError
+	if !errors.As(err, &bundle_err) || bundle_err.Reason != reason {
+		t.Errorf("got error %v, want a bundle error with reason %s", err, reason)
+	}
+}
+
+func TestExtractBundle(t *testing.T) {
+	formats := []bundle_format{format_zip, format_tar, format_tar_gz}
+
+	t.Run("should extract every supported format with links inside the root", func (t *testing.T) {
+		for _, format := range formats {
+			t.Run(string(format), func (t *testing.T) {
+				bundle := write_bundle_file(t, format, []test_entry{
+					file_entry("app/package.json", `{"name": "hello"}`),
+					file_entry("app/lib/index.js", "module.exports = 1"),
+					symlink_entry("app/main.js", "lib/index.js"),
+				})
+				dest := filepath.Join(t.TempDir(), "source")
+
+				if err := InspectBundle(bundle, ExtractLimits{}); err != nil {
+					t.Fatalf("got error inspecting %v, want nil", err)
+				}
+				if err := ExtractBundle(bundle, dest, ExtractLimits{}); err != nil {
+					t.Fatalf("got error extracting %v, want nil", err)
+				}
+
+				content, err := os.ReadFile(filepath.Join(dest, "app", "main.js"))
+				if err != nil || string(content) != "module.exports = 1" {
+					t.Errorf("got %q (%v) through the link, want the linked file", content, err)
+				}
+			})
+		}
+	})
+
+	tests := []struct {
+		name string
+		entries []test_entry
+		limits ExtractLimits
+		reason string
+	}{
+		{"zip slip", []test_entry{file_entry("../../evil.js", "boom")}, ExtractLimits{}, BundleReasonPathTraversal},
+		{"absolute path", []test_entry{file_entry("/etc/cron.d/evil", "boom")}, ExtractLimits{}, BundleReasonPathTraversal},
+		{"absolute symlink", []test_entry{symlink_entry("etc", "/etc")}, ExtractLimits{}, BundleReasonSymlinkEscape},
+		{"relative symlink escape", []test_entry{symlink_entry("app/up", "../../outside")}, ExtractLimits{}, BundleReasonSymlinkEscape},
+		{"too many files", []test_entry{file_entry("a", "a"), file_entry("b", "b"), file_entry("c", "c")}, ExtractLimits{MaxFiles: 2}, BundleReasonTooManyFiles},
+		{"too large", []test_entry{file_entry("big", strings.Repeat("x", 2048))}, ExtractLimits{MaxTotalSize: 1024}, BundleReasonTooLarge},
+	}
+
+	for _, tt := range tests {
+		for _, format := range formats {
+			t.Run(tt.name+" "+string(format), func (t *testing.T) {
+				bundle := write_bundle_file(t, format, tt.entries)
+				parent := t.TempDir()
+				dest := filepath.Join(parent, "source")
+
+				want_bundle_error(t, InspectBundle(bundle, tt.limits), tt.reason)
+				want_bundle_error(t, ExtractBundle(bundle, dest, tt.limits), tt.reason)
+
+				if _, err := os.Stat(dest); !errors.Is(err, fs.ErrNotExist) {
+					t.Errorf("got %v for the destination, want it removed", err)
+				}
+				if entries, _ := os.ReadDir(parent); len(entries) != 0 {
+					t.Errorf("got %d entries next to the destination, want none", len(entries))
+				}
+			})
+		}
+	}
+
+	t.Run("should refuse links that only escape through other links", func (t *testing.T) {
+		for _, format := range []bundle_format{format_zip, format_tar_gz} {
+			bundle := write_bundle_file(t, format, []test_entry{
+				symlink_entry("here", "."),
+				symlink_entry("parent", "here/.."),
+			})
+
+			want_bundle_error(t, ExtractBundle(bundle, filepath.Join(t.TempDir(), "source"), ExtractLimits{}), BundleReasonSymlinkEscape)
+		}
+	})
+
+	t.Run("should refuse writing through an earlier symlink", func (t *testing.T) {
+		bundle := write_bundle_file(t, format_tar_gz, []test_entry{
+			symlink_entry("lib", "vendor"),
+			file_entry("lib/index.js", "boom"),
+		})
+
+		want_bundle_error(t, ExtractBundle(bundle, filepath.Join(t.TempDir(), "source"), ExtractLimits{}), BundleReasonSymlinkEscape)
+	})
+
+	t.Run("should refuse decompression bombs", func (t *testing.T) {
+		bundle := write_bundle_file(t, format_tar_gz, []test_entry{
+			file_entry("zeros", strings.Repeat("\x00", 16*1024*1024)),
+		})
+
+		want_bundle_error(t, InspectBundle(bundle, ExtractLimits{}), BundleReasonCompressionRatio)
+	})
+
+	t.Run("should reject files that aren't archives", func (t *testing.T) {
+		bundle := filepath.Join(t.TempDir(), "bundle.tar.gz")
+		os.WriteFile(bundle, []byte("#!/bin/sh\necho not an archive\n"), 0o640)
+
+		err := InspectBundle(bundle, ExtractLimits{})
+		want_bundle_error(t, err, BundleReasonUnsupportedFormat)
+	})
+
+	t.Run("should report corrupt archives", func (t *testing.T) {
+		bundle := filepath.Join(t.TempDir(), "bundle.tar.gz")
+		os.WriteFile(bundle, []byte{0x1f, 0x8b, 0x08, 0x00, 0x01, 0x02}, 0o640)
+
+		want_bundle_error(t, InspectBundle(bundle, ExtractLimits{}), BundleReasonCorrupt)
+	})
+}
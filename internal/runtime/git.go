@@ -0,0 +1,202 @@
+package runtime
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+// GitSource is a repository and the branch, tag or commit to deploy from it.
+// An empty Ref deploys the remote's default branch.
+type GitSource struct {
+	URL string
+	Ref string
+}
+
+// git_ssh_command keeps ssh from authenticating as the server: no config, no
+// key files and no agent, so private repositories need a deploy token over
+// https instead.
+const git_ssh_command = "ssh -F /dev/null -o IdentitiesOnly=yes -o IdentityFile=/dev/null -o IdentityAgent=none -o BatchMode=yes -o StrictHostKeyChecking=accept-new -o UserKnownHostsFile=/dev/null"
+
+// git_host_env lists the variables that would let git reach the server's own
+// credentials, git_env drops them.
+var git_host_env = []string{
+	"HOME",
+	"XDG_CONFIG_HOME",
+	"SSH_AUTH_SOCK",
+	"SSH_ASKPASS",
+	"GIT_ASKPASS",
+	"GIT_SSH",
+	"GIT_SSH_COMMAND",
+	"GIT_CONFIG",
+	"GIT_CONFIG_GLOBAL",
+	"GIT_CONFIG_SYSTEM",
+	"GIT_CONFIG_COUNT",
+}
+
+// git_protocols keeps git away from transports that run commands, like ext::.
+// file reads the server's disk and http and git aren't authenticated, so they
+// are only allowed with GIT_ALLOW_FILE_URLS and GIT_ALLOW_INSECURE_URLS set to
+// true. Operators can narrow it with GIT_ALLOW_PROTOCOL.
+func git_protocols() string {
+	protocols := []string{"https", "ssh"}
+	if os.Getenv("GIT_ALLOW_INSECURE_URLS") == "true" {
+		protocols = append(protocols, "http", "git")
+	}
+	if os.Getenv("GIT_ALLOW_FILE_URLS") == "true" {
+		protocols = append(protocols, "file")
+	}
+
+	return strings.Join(protocols, ":")
+}
+
+// git_env runs git on behalf of a tenant, without the ssh keys, agent,
+// credential helpers or .netrc of the server.
+func git_env() []string {
+	env := []string{}
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		if slices.Contains(git_host_env, name) {
+			continue
+		}
+		env = append(env, kv)
+	}
+
+	env = append(
+		env,
+		"HOME="+os.DevNull,
+		"GIT_TERMINAL_PROMPT=0",
+		"GIT_CONFIG_NOSYSTEM=1",
+		"GIT_CONFIG_GLOBAL="+os.DevNull,
+		"GIT_SSH_COMMAND="+git_ssh_command,
+	)
+	if os.Getenv("GIT_ALLOW_PROTOCOL") == "" {
+		env = append(env, "GIT_ALLOW_PROTOCOL="+git_protocols())
+	}
+
+	return env
+}
+
+// run_git runs git in dir, reporting its stderr as build logs. It returns the
+// last stderr line so failures can say why.
+func run_git(ctx context.Context, spec Spec, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = git_env()
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return "", err
+	}
+	var stdout strings.Builder
+	cmd.Stdout = &stdout
+
+	if err := cmd.Start(); err != nil {
+		return "", err
+	}
+
+	last_line := ""
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		last_line = line
+		build_log(spec, "stderr", line)
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return last_line, err
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// FetchGitBundle shallow-fetches source into work_dir and writes the fetched
+// commit's tree as a tar.gz bundle to bundle_path, so git deployments go
+// through the same extraction and build as uploads. work_dir is removed
+// afterwards. It returns the resolved commit SHA.
+func FetchGitBundle(ctx context.Context, spec Spec, source GitSource, work_dir string, bundle_path string) (string, error) {
+	ref := source.Ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+
+	if err := os.RemoveAll(work_dir); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(work_dir, 0o750); err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(work_dir)
+
+	build_log(spec, "stdout", fmt.Sprintf("$ git fetch --depth 1 %s %s", source.URL, ref))
+
+	if _, err := run_git(ctx, spec, work_dir, "init", "--bare", "--quiet"); err != nil {
+		return "", err
+	}
+
+	// fetching the ref directly works for branches, tags and full commit SHAs
+	// alike, and only transfers that one commit
+	last_line, err := run_git(ctx, spec, work_dir, "fetch", "--depth", "1", "--no-tags", "--end-of-options", source.URL, ref)
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", errors.New("git_fetch_timeout")
+		}
+		if strings.Contains(last_line, "couldn't find remote ref") || strings.Contains(last_line, "not our ref") {
+			return "", errors.New("git_ref_not_found")
+		}
+		return "", fmt.Errorf("git_fetch_failed: %s", last_line)
+	}
+
+	sha, err := run_git(ctx, spec, work_dir, "rev-parse", "--verify", "--end-of-options", "FETCH_HEAD^{commit}")
+	if err != nil {
+		return "", errors.New("git_ref_not_found")
+	}
+
+	if err := write_git_archive(ctx, work_dir, sha, bundle_path); err != nil {
+		return "", err
+	}
+
+	build_log(spec, "stdout", "checked out "+sha)
+
+	return sha, nil
+}
+
+// write_git_archive compresses in process, git's own tar.gz format shells out
+// to gzip on older versions.
+func write_git_archive(ctx context.Context, work_dir string, sha string, bundle_path string) error {
+	file, err := os.Create(bundle_path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	gz := gzip.NewWriter(file)
+
+	cmd := exec.CommandContext(ctx, "git", "archive", "--format=tar", sha)
+	cmd.Dir = work_dir
+	cmd.Env = git_env()
+	cmd.Stdout = gz
+
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		os.Remove(bundle_path)
+		return fmt.Errorf("git archive failed: %s", strings.TrimSpace(stderr.String()))
+	}
+	if err := gz.Close(); err != nil {
+		os.Remove(bundle_path)
+		return err
+	}
+
+	return file.Close()
+}
@@ -0,0 +1,162 @@
+package runtime
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(
+		os.Environ(),
+		"GIT_AUTHOR_NAME=capy", "GIT_AUTHOR_EMAIL=capy@example.com",
+		"GIT_COMMITTER_NAME=capy", "GIT_COMMITTER_EMAIL=capy@example.com",
+		"GIT_CONFIG_GLOBAL=/dev/null",
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("got error running git %v: %v\n%s", args, err, out)
+	}
+
+	return strings.TrimSpace(string(out))
+}
+
+// new_git_remote pushes two commits to a bare repository: v1 tagged on main,
+// then v2 on main. feature holds a third commit. Its file:// url is allowed
+// for the rest of the test.
+func new_git_remote(t *testing.T) (string, map[string]string) {
+	t.Helper()
+	t.Setenv("GIT_ALLOW_FILE_URLS", "true")
+
+	work := t.TempDir()
+	remote := filepath.Join(t.TempDir(), "app.git")
+
+	git(t, work, "init", "--quiet", "--initial-branch=main")
+	commits := map[string]string{}
+
+	commit := func(name string, version string) {
+		write_files(t, work, map[string]string{"package.json": `{"version":"` + version + `"}`})
+		git(t, work, "add", "-A")
+		git(t, work, "commit", "--quiet", "-m", version)
+		commits[name] = git(t, work, "rev-parse", "HEAD")
+	}
+
+	commit("v1", "1.0.0")
+	git(t, work, "tag", "-a", "v1", "-m", "v1")
+	commit("main", "2.0.0")
+	git(t, work, "checkout", "--quiet", "-b", "feature")
+	commit("feature", "3.0.0")
+	git(t, work, "checkout", "--quiet", "main")
+
+	git(t, work, "clone", "--quiet", "--bare", work, remote)
+
+	return "file://" + remote, commits
+}
+
+func TestFetchGitBundle(t *testing.T) {
+	url, commits := new_git_remote(t)
+
+	tests := []struct {
+		ref string
+		want_sha string
+		want_version string
+	}{
+		{"", commits["main"], "2.0.0"},
+		{"main", commits["main"], "2.0.0"},
+		{"feature", commits["feature"], "3.0.0"},
+		{"v1", commits["v1"], "1.0.0"},
+		{commits["v1"], commits["v1"], "1.0.0"},
+	}
+
+	for _, tt := range tests {
+		t.Run("ref "+tt.ref, func (t *testing.T) {
+			artifacts_path := t.TempDir()
+			bundle_path := filepath.Join(artifacts_path, "bundle.tar.gz")
+			work_dir := filepath.Join(artifacts_path, "git")
+
+			logged := []string{}
+			spec := Spec{OnLog: func(line LogLine) { logged = append(logged, line.Line) }}
+
+			sha, err := FetchGitBundle(context.Background(), spec, GitSource{URL: url, Ref: tt.ref}, work_dir, bundle_path)
+			if err != nil {
+				t.Fatalf("got error %v, want nil", err)
+			}
+			if sha != tt.want_sha {
+				t.Errorf("got sha %s, want %s", sha, tt.want_sha)
+			}
+
+			dest := filepath.Join(artifacts_path, "source")
+			if err := ExtractBundle(bundle_path, dest, ExtractLimits{}); err != nil {
+				t.Fatalf("got error extracting the bundle %v, want nil", err)
+			}
+			got, _ := os.ReadFile(filepath.Join(dest, "package.json"))
+			if !strings.Contains(string(got), tt.want_version) {
+				t.Errorf("got package.json %s, want version %s", got, tt.want_version)
+			}
+			if _, err := os.Stat(filepath.Join(dest, ".git")); err == nil {
+				t.Errorf("got .git in the bundle, want only the tree")
+			}
+
+			if _, err := os.Stat(work_dir); !os.IsNotExist(err) {
+				t.Errorf("got work dir left behind (%v), want it removed", err)
+			}
+			if len(logged) == 0 || !strings.HasPrefix(logged[0], "$ git fetch") {
+				t.Errorf("got logs %v, want the fetch logged", logged)
+			}
+		})
+	}
+
+	t.Run("should report unknown refs", func (t *testing.T) {
+		artifacts_path := t.TempDir()
+
+		_, err := FetchGitBundle(context.Background(), Spec{}, GitSource{URL: url, Ref: "nope"}, filepath.Join(artifacts_path, "git"), filepath.Join(artifacts_path, "bundle.tar.gz"))
+		if err == nil || err.Error() != "git_ref_not_found" {
+			t.Errorf("got error %v, want git_ref_not_found", err)
+		}
+	})
+
+	t.Run("should refuse file urls unless the operator allows them", func (t *testing.T) {
+		t.Setenv("GIT_ALLOW_FILE_URLS", "")
+		artifacts_path := t.TempDir()
+
+		_, err := FetchGitBundle(context.Background(), Spec{}, GitSource{URL: url}, filepath.Join(artifacts_path, "git"), filepath.Join(artifacts_path, "bundle.tar.gz"))
+		if err == nil || !strings.Contains(err.Error(), "not allowed") {
+			t.Errorf("got error %v, want the file transport not allowed", err)
+		}
+	})
+
+	t.Run("should keep the server's ssh identity and credentials away from git", func (t *testing.T) {
+		t.Setenv("SSH_AUTH_SOCK", "/tmp/agent.sock")
+		t.Setenv("GIT_SSH_COMMAND", "ssh -i /root/.ssh/id_ed25519")
+		t.Setenv("HOME", "/root")
+
+		env := git_env()
+		for _, kv := range []string{"SSH_AUTH_SOCK=/tmp/agent.sock", "GIT_SSH_COMMAND=ssh -i /root/.ssh/id_ed25519", "HOME=/root"} {
+			if slices.Contains(env, kv) {
+				t.Errorf("got %s passed to git, want it dropped", kv)
+			}
+		}
+		for _, kv := range []string{"GIT_SSH_COMMAND=" + git_ssh_command, "GIT_CONFIG_GLOBAL=" + os.DevNull, "GIT_CONFIG_NOSYSTEM=1", "HOME=" + os.DevNull} {
+			if !slices.Contains(env, kv) {
+				t.Errorf("got env %v, want %s", env, kv)
+			}
+		}
+	})
+
+	t.Run("should report unreachable repositories", func (t *testing.T) {
+		artifacts_path := t.TempDir()
+
+		_, err := FetchGitBundle(context.Background(), Spec{}, GitSource{URL: "file://" + filepath.Join(artifacts_path, "missing.git")}, filepath.Join(artifacts_path, "git"), filepath.Join(artifacts_path, "bundle.tar.gz"))
+		if err == nil || !strings.HasPrefix(err.Error(), "git_fetch_failed: ") {
+			t.Errorf("got error %v, want git_fetch_failed", err)
+		}
+	})
+}
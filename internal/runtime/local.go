@@ -0,0 +1,445 @@
+package runtime
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const max_buffered_log_lines = 1000
+
+type log_buffer struct {
+	mu sync.Mutex
+	lines []LogLine
+}
+
+func (b *log_buffer) append(line LogLine) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lines = append(b.lines, line)
+	if len(b.lines) > max_buffered_log_lines {
+		b.lines = b.lines[len(b.lines)-max_buffered_log_lines:]
+	}
+}
+
+func (b *log_buffer) tail(n int) []LogLine {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if n <= 0 || n > len(b.lines) {
+		n = len(b.lines)
+	}
+
+	tail := make([]LogLine, n)
+	copy(tail, b.lines[len(b.lines)-n:])
+
+	return tail
+}
+
+type local_process struct {
+	mu sync.Mutex
+	cmd *exec.Cmd
+	group *cgroup
+	status Status
+	logs *log_buffer
+	done chan struct{}
+}
+
+func (p *local_process) snapshot() Status {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.status
+}
+
+type local_runtime struct {
+	ctx context.Context
+	mu sync.Mutex
+	processes map[string]*local_process
+	stop_timeout time.Duration
+	cache *DependencyCache
+	cgroups *Cgroups
+	groups *process_groups
+}
+
+// NewLocalRuntime runs deployments as child processes of the API server, it
+// needs node and the package managers on the PATH but no container daemon.
+// Installs are not cached when cache is nil. Without cgroups, processes run
+// without resource limits. The process groups recorded in state_dir let a
+// restarted server kill what the previous run left behind, without a
+// state_dir only cgroups can find them.
+func NewLocalRuntime(ctx context.Context, stop_timeout time.Duration, cache *DependencyCache, cgroups *Cgroups, state_dir string) Runtime {
+	local := &local_runtime{
+		ctx: ctx,
+		processes: make(map[string]*local_process),
+		stop_timeout: stop_timeout,
+		cache: cache,
+		cgroups: cgroups,
+	}
+	if state_dir != "" {
+		local.groups = &process_groups{dir: state_dir}
+	}
+
+	return local
+}
+
+func (r *local_runtime) Prepare(spec Spec) error {
+	bundle_path, err := FindBundle(spec.ArtifactsPath)
+	if err != nil {
+		return err
+	}
+
+	source_dir := SourceDir(spec.ArtifactsPath)
+	if err := os.RemoveAll(source_dir); err != nil {
+		return err
+	}
+	if err := ExtractBundle(bundle_path, source_dir, spec.BundleLimits); err != nil {
+		return err
+	}
+	spec.report(PhaseBuilding)
+
+	if spec.Static {
+		return prepare_static(spec, source_dir, func(root string, plan StackPlan) error {
+			return build_project(spec.context_or(r.ctx), spec, root, plan, r.cache)
+		})
+	}
+
+	project_root, plan, err := plan_project(source_dir, spec)
+	if err != nil {
+		return err
+	}
+	spec.report_stack(plan.Stack)
+
+	return build_project(spec.context_or(r.ctx), spec, project_root, plan, r.cache)
+}
+
+// process_env builds the child environment from scratch so the API server's
+// own secrets never leak into user applications. It suits every stack, the
+// tools of the ones the project isn't built with are just missing.
+func process_env(project_root string, variables map[string]string) []string {
+	path := strings.Join([]string{
+		filepath.Join(project_root, "node_modules", ".bin"),
+		filepath.Join(project_root, ".venv", "bin"),
+	}, string(os.PathListSeparator))
+	if system_path := os.Getenv("PATH"); system_path != "" {
+		path = path + string(os.PathListSeparator) + system_path
+	}
+
+	env := map[string]string{
+		"PATH": path,
+		"HOME": project_root,
+		"NODE_ENV": "production",
+		"PYTHONUNBUFFERED": "1",
+		// the module cache lands in HOME, read-only it couldn't be removed
+		"GOFLAGS": "-modcacherw",
+	}
+	for key, val := range variables {
+		env[key] = val
+	}
+
+	formatted := make([]string, 0, len(env))
+	for key, val := range env {
+		formatted = append(formatted, fmt.Sprintf("%s=%s", key, val))
+	}
+
+	return formatted
+}
+
+func (r *local_runtime) Start(spec Spec) (*Instance, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if existing, ok := r.processes[spec.DeploymentID]; ok && existing.snapshot().State == StateRunning {
+		return nil, errors.New("already_running")
+	}
+
+	project_root, plan, err := plan_project(SourceDir(spec.ArtifactsPath), spec)
+	if err != nil {
+		return nil, err
+	}
+	command := plan.StartCommand
+	process := spec.Process
+	if process == "" {
+		process = plan.StartProcess
+	}
+
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Dir = project_root
+	cmd.Env = process_env(project_root, spec.Variables)
+	cmd.SysProcAttr = process_attr()
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	proc := &local_process{
+		cmd: cmd,
+		logs: &log_buffer{},
+		done: make(chan struct{}),
+	}
+
+	if r.cgroups != nil {
+		group, err := r.cgroups.create(spec.DeploymentID, spec.Resources)
+		if err != nil {
+			return nil, err
+		}
+		release, err := group.attach(cmd)
+		if err != nil {
+			group.remove()
+			return nil, err
+		}
+		defer release()
+		proc.group = group
+	} else if spec.Resources != (Resources{}) {
+		fmt.Println("Resource limits of deployment", spec.DeploymentID, "ignored, cgroups are disabled")
+	}
+
+	if err := cmd.Start(); err != nil {
+		if proc.group != nil {
+			proc.group.remove()
+		}
+		return nil, err
+	}
+
+	proc.status = Status{
+		State: StateRunning,
+		Pid: cmd.Process.Pid,
+		StartedAt: time.Now(),
+	}
+	r.processes[spec.DeploymentID] = proc
+	if r.groups != nil {
+		if err := r.groups.record(spec.DeploymentID, cmd.Process.Pid); err != nil {
+			fmt.Println("Unable to record process group of", spec.DeploymentID, err.Error())
+		}
+	}
+
+	var pipes sync.WaitGroup
+	pipes.Add(2)
+	go capture_lines(&pipes, stdout, "stdout", process, proc.logs, spec)
+	go capture_lines(&pipes, stderr, "stderr", process, proc.logs, spec)
+
+	go func() {
+		// pipes must be drained before Wait closes them
+		pipes.Wait()
+		err := cmd.Wait()
+
+		oom_killed := false
+		if proc.group != nil {
+			oom_killed = proc.group.oom_killed()
+			// leftovers of the start script must not outlive the deployment
+			proc.group.kill()
+			if err := proc.group.remove(); err != nil {
+				fmt.Println("Unable to remove cgroup of", spec.DeploymentID, err.Error())
+			}
+		}
+
+		if r.groups != nil {
+			r.groups.forget(spec.DeploymentID, cmd.Process.Pid)
+		}
+
+		proc.mu.Lock()
+		proc.status.State = StateExited
+		proc.status.ExitedAt = time.Now()
+		proc.status.ExitCode = cmd.ProcessState.ExitCode()
+		proc.status.OOMKilled = oom_killed
+		proc.mu.Unlock()
+
+		if err != nil {
+			fmt.Println("Deployment process exited", spec.DeploymentID, err.Error())
+		}
+		close(proc.done)
+	}()
+
+	process_name := fmt.Sprintf("%s [pid %d]", command, cmd.Process.Pid)
+	if len(process_name) > 255 {
+		process_name = process_name[:255]
+	}
+
+	return &Instance{
+		Process: process,
+		ProcessName: process_name,
+	}, nil
+}
+
+func capture_lines(wg *sync.WaitGroup, pipe io.Reader, stream string, process string, logs *log_buffer, spec Spec) {
+	defer wg.Done()
+
+	scanner := bufio.NewScanner(pipe)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := LogLine{
+			Phase: LogPhaseRun,
+			Process: process,
+			Stream: stream,
+			Line: scanner.Text(),
+			Time: time.Now(),
+		}
+		logs.append(line)
+		spec.log(line)
+	}
+}
+
+func (r *local_runtime) find(deployment_id string) *local_process {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.processes[deployment_id]
+}
+
+func (r *local_runtime) forget(deployment_id string, proc *local_process) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.processes[deployment_id] == proc {
+		delete(r.processes, deployment_id)
+	}
+}
+
+func (r *local_runtime) Stop(deployment_id string) error {
+	proc := r.find(deployment_id)
+	if proc == nil {
+		return r.stop_orphan(deployment_id)
+	}
+	// one that exited on its own is forgotten, so one-off runs don't pile up
+	if proc.snapshot().State != StateRunning {
+		r.forget(deployment_id, proc)
+		return nil
+	}
+
+	pgid := -proc.cmd.Process.Pid
+	syscall.Kill(pgid, syscall.SIGTERM)
+
+	select {
+	case <-proc.done:
+		return nil
+	case <-time.After(r.stop_timeout):
+		syscall.Kill(pgid, syscall.SIGKILL)
+		if proc.group != nil {
+			proc.group.kill()
+		}
+	}
+
+	<-proc.done
+
+	return nil
+}
+
+// stop_orphan kills what a previous run of the server left in the
+// deployment's cgroup, or in its recorded process group without cgroups.
+func (r *local_runtime) stop_orphan(deployment_id string) error {
+	if r.cgroups == nil {
+		return r.stop_orphan_group(deployment_id)
+	}
+	group := r.cgroups.group(deployment_id)
+	if _, err := os.Stat(group.path); err != nil {
+		return errors.New("not_found")
+	}
+
+	group.kill()
+	// the kernel kills asynchronously and a populated cgroup can't be removed
+	deadline := time.Now().Add(r.stop_timeout)
+	for group.populated() && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	return group.remove()
+}
+
+func (r *local_runtime) stop_orphan_group(deployment_id string) error {
+	if r.groups == nil {
+		return errors.New("not_found")
+	}
+	pgid, ok := r.groups.find(deployment_id)
+	if !ok {
+		return errors.New("not_found")
+	}
+
+	syscall.Kill(-pgid, syscall.SIGTERM)
+	deadline := time.Now().Add(r.stop_timeout)
+	for group_alive(pgid) && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if group_alive(pgid) {
+		syscall.Kill(-pgid, syscall.SIGKILL)
+		// the ports are only free once the processes are gone
+		deadline = time.Now().Add(r.stop_timeout)
+		for group_alive(pgid) && time.Now().Before(deadline) {
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+
+	r.groups.forget(deployment_id, pgid)
+
+	return nil
+}
+
+func (r *local_runtime) Status(deployment_id string) (*Status, error) {
+	proc := r.find(deployment_id)
+	if proc == nil {
+		return &Status{State: StateNotFound}, nil
+	}
+
+	status := proc.snapshot()
+
+	return &status, nil
+}
+
+func (r *local_runtime) Logs(deployment_id string, tail int) ([]LogLine, error) {
+	proc := r.find(deployment_id)
+	if proc == nil {
+		return nil, errors.New("not_found")
+	}
+
+	return proc.logs.tail(tail), nil
+}
+
+// List includes processes left in their cgroup or recorded process group by a
+// previous run of the server.
+func (r *local_runtime) List() ([]string, error) {
+	r.mu.Lock()
+	deployment_ids := []string{}
+	for deployment_id, proc := range r.processes {
+		if proc.snapshot().State == StateRunning {
+			deployment_ids = append(deployment_ids, deployment_id)
+		}
+	}
+	r.mu.Unlock()
+
+	left := []string{}
+	if r.cgroups != nil {
+		populated, err := r.cgroups.populated()
+		if err != nil {
+			return nil, err
+		}
+		left = populated
+	} else if r.groups != nil {
+		alive, err := r.groups.alive()
+		if err != nil {
+			return nil, err
+		}
+		left = alive
+	}
+	for _, deployment_id := range left {
+		if !slices.Contains(deployment_ids, deployment_id) {
+			deployment_ids = append(deployment_ids, deployment_id)
+		}
+	}
+
+	return deployment_ids, nil
+}
@@ -0,0 +1,339 @@
+package runtime
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func write_test_bundle(t *testing.T, artifacts_path string, files map[string]string) {
+	t.Helper()
+
+	buf := bytes.NewBuffer([]byte{})
+	gz := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gz)
+
+	for name, content := range files {
+		tw.WriteHeader(&tar.Header{
+			Name: name,
+			Mode: 0o644,
+			Size: int64(len(content)),
+			Typeflag: tar.TypeReg,
+		})
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+	gz.Close()
+
+	if err := os.WriteFile(filepath.Join(artifacts_path, "bundle.tar.gz"), buf.Bytes(), 0o640); err != nil {
+		t.Fatalf("got error writing bundle %v, want nil", err)
+	}
+}
+
+func wait_for(t *testing.T, timeout time.Duration, check func() bool) bool {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if check() {
+			return true
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	return false
+}
+
+func TestLocalRuntime(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should run the start script with config variables as environment", func (t *testing.T) {
+		t.Setenv("AUTH_JWT_SECRET", "do-not-leak")
+
+		artifacts_path := t.TempDir()
+		write_test_bundle(t, artifacts_path, map[string]string{
+			"app/package.json": `{
+				"name": "hello",
+				"scripts": {"start": "echo \"hello $GREETING\"; echo \"secret=$AUTH_JWT_SECRET\"; echo oops 1>&2; sleep 30"}
+			}`,
+		})
+
+		local := NewLocalRuntime(ctx, time.Second, nil, nil, "")
+		spec := Spec{
+			DeploymentID: "dp-1",
+			AppID: "app-1",
+			ArtifactsPath: artifacts_path,
+			Variables: map[string]string{"GREETING": "capybara"},
+		}
+
+		if err := local.Prepare(spec); err != nil {
+			t.Fatalf("got error preparing %v, want nil", err)
+		}
+
+		instance, err := local.Start(spec)
+		if err != nil {
+			t.Fatalf("got error starting %v, want nil", err)
+		}
+		if !strings.Contains(instance.ProcessName, "pid") {
+			t.Errorf("got process name %s, want it to include the pid", instance.ProcessName)
+		}
+
+		got_logs := func() string {
+			lines, _ := local.Logs(spec.DeploymentID, 0)
+			joined := []string{}
+			for _, line := range lines {
+				if line.Phase != LogPhaseRun {
+					continue
+				}
+				joined = append(joined, line.Stream+":"+line.Line)
+			}
+			return strings.Join(joined, "\n")
+		}
+
+		ok := wait_for(t, 5*time.Second, func() bool {
+			return strings.Contains(got_logs(), "stderr:oops")
+		})
+		if !ok {
+			t.Fatalf("got logs %q, want stdout and stderr lines", got_logs())
+		}
+		if !strings.Contains(got_logs(), "stdout:hello capybara") {
+			t.Errorf("got logs %q, want config variable in environment", got_logs())
+		}
+		if strings.Contains(got_logs(), "do-not-leak") {
+			t.Errorf("got logs %q, want server environment not to leak", got_logs())
+		}
+
+		status, _ := local.Status(spec.DeploymentID)
+		if status.State != StateRunning || status.Pid == 0 {
+			t.Errorf("got status %+v, want running with a pid", status)
+		}
+		if listed, _ := local.List(); len(listed) != 1 || listed[0] != spec.DeploymentID {
+			t.Errorf("got listed %v, want the running deployment", listed)
+		}
+
+		if err := local.Stop(spec.DeploymentID); err != nil {
+			t.Fatalf("got error stopping %v, want nil", err)
+		}
+
+		status, _ = local.Status(spec.DeploymentID)
+		if status.State != StateExited {
+			t.Errorf("got state %s, want %s", status.State, StateExited)
+		}
+		if listed, _ := local.List(); len(listed) != 0 {
+			t.Errorf("got listed %v, want none once stopped", listed)
+		}
+	})
+
+	t.Run("should fail to prepare bundles without a start command", func (t *testing.T) {
+		artifacts_path := t.TempDir()
+		write_test_bundle(t, artifacts_path, map[string]string{
+			"package.json": `{"name": "nothing-to-run"}`,
+		})
+
+		local := NewLocalRuntime(ctx, time.Second, nil, nil, "")
+		err := local.Prepare(Spec{DeploymentID: "dp-2", ArtifactsPath: artifacts_path})
+
+		if err == nil || err.Error() != "start_command_not_found" {
+			t.Errorf("got error %v, want start_command_not_found", err)
+		}
+	})
+
+	t.Run("should run a given command on a deployment's build and forget it once exited", func (t *testing.T) {
+		artifacts_path := t.TempDir()
+		write_test_bundle(t, artifacts_path, map[string]string{
+			"package.json": `{"name": "report"}`,
+			"report.js": "",
+		})
+
+		local := NewLocalRuntime(ctx, time.Second, nil, nil, "")
+		spec := Spec{
+			DeploymentID: "dp-4",
+			ArtifactsPath: artifacts_path,
+			Command: "node report.js",
+		}
+		if err := local.Prepare(spec); err != nil {
+			t.Fatalf("got error preparing %v, want nil", err)
+		}
+
+		run := Spec{
+			DeploymentID: "run-1",
+			BuildID: "dp-4",
+			ArtifactsPath: artifacts_path,
+			Command: "echo reported; exit 3",
+		}
+		if _, err := local.Start(run); err != nil {
+			t.Fatalf("got error starting %v, want nil", err)
+		}
+
+		exited := wait_for(t, 5*time.Second, func() bool {
+			status, _ := local.Status(run.DeploymentID)
+			return status.State == StateExited
+		})
+		if !exited {
+			t.Fatalf("got the run still running, want it exited")
+		}
+		if status, _ := local.Status(run.DeploymentID); status.ExitCode != 3 {
+			t.Errorf("got exit code %d, want 3", status.ExitCode)
+		}
+
+		if err := local.Stop(run.DeploymentID); err != nil {
+			t.Fatalf("got error stopping %v, want nil", err)
+		}
+		if status, _ := local.Status(run.DeploymentID); status.State != StateNotFound {
+			t.Errorf("got state %s after stopping an exited run, want %s", status.State, StateNotFound)
+		}
+	})
+
+	t.Run("should tag the output of a process type with its name", func (t *testing.T) {
+		artifacts_path := t.TempDir()
+		write_test_bundle(t, artifacts_path, map[string]string{
+			"package.json": `{"name": "queue"}`,
+			"Procfile": "web: node index.js\nworker: node worker.js\n",
+		})
+
+		local := NewLocalRuntime(ctx, time.Second, nil, nil, "")
+		if err := local.Prepare(Spec{DeploymentID: "dp-5", ArtifactsPath: artifacts_path}); err != nil {
+			t.Fatalf("got error preparing %v, want nil", err)
+		}
+
+		var mu sync.Mutex
+		lines := []LogLine{}
+		worker := Spec{
+			DeploymentID: "dp-5.worker.0",
+			BuildID: "dp-5",
+			ArtifactsPath: artifacts_path,
+			Command: "echo working",
+			Process: "worker",
+			OnLog: func(line LogLine) {
+				mu.Lock()
+				defer mu.Unlock()
+				lines = append(lines, line)
+			},
+		}
+		instance, err := local.Start(worker)
+		if err != nil {
+			t.Fatalf("got error starting %v, want nil", err)
+		}
+		defer local.Stop(worker.DeploymentID)
+
+		if instance.Process != "worker" {
+			t.Errorf("got process %q, want worker", instance.Process)
+		}
+		logged := wait_for(t, 5*time.Second, func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(lines) > 0
+		})
+		if !logged {
+			t.Fatalf("got no output, want the worker's line")
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if lines[0].Line != "working" || lines[0].Process != "worker" {
+			t.Errorf("got line %+v, want working tagged as worker", lines[0])
+		}
+	})
+
+	t.Run("should reject bundle entries escaping the bundle root", func (t *testing.T) {
+		artifacts_path := t.TempDir()
+		write_test_bundle(t, artifacts_path, map[string]string{
+			"../evil.js": "boom",
+		})
+
+		local := NewLocalRuntime(ctx, time.Second, nil, nil, "")
+		err := local.Prepare(Spec{DeploymentID: "dp-3", ArtifactsPath: artifacts_path})
+
+		if err == nil || !strings.Contains(err.Error(), "escapes") {
+			t.Errorf("got error %v, want bundle root escape error", err)
+		}
+	})
+
+	t.Run("should kill what a previous run of the server left behind so the port can be bound again", func (t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("got error finding a free port %v, want nil", err)
+		}
+		port := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+		listener.Close()
+
+		artifacts_path := t.TempDir()
+		write_test_bundle(t, artifacts_path, map[string]string{
+			"package.json": `{
+				"name": "listener",
+				"scripts": {"start": "node -e \"require('http').createServer((req, res) => res.end('ok')).listen(process.env.PORT, '127.0.0.1')\""}
+			}`,
+		})
+		spec := Spec{
+			DeploymentID: "dp-restart",
+			ArtifactsPath: artifacts_path,
+			Variables: map[string]string{"PORT": port},
+		}
+		listening := func() bool {
+			conn, err := net.Dial("tcp", "127.0.0.1:"+port)
+			if err != nil {
+				return false
+			}
+			conn.Close()
+			return true
+		}
+
+		state_dir := t.TempDir()
+		previous := NewLocalRuntime(ctx, time.Second, nil, nil, state_dir)
+		if err := previous.Prepare(spec); err != nil {
+			t.Fatalf("got error preparing %v, want nil", err)
+		}
+		if _, err := previous.Start(spec); err != nil {
+			t.Fatalf("got error starting %v, want nil", err)
+		}
+		if !wait_for(t, 10*time.Second, listening) {
+			t.Fatalf("got port %s closed, want the deployment listening", port)
+		}
+
+		// a restarted server only has what the previous run left on disk
+		restarted := NewLocalRuntime(ctx, time.Second, nil, nil, state_dir)
+		defer restarted.Stop(spec.DeploymentID)
+
+		if status, _ := restarted.Status(spec.DeploymentID); status.State != StateNotFound {
+			t.Errorf("got state %s, want %s after the restart", status.State, StateNotFound)
+		}
+		if listed, _ := restarted.List(); !slices.Contains(listed, spec.DeploymentID) {
+			t.Errorf("got listed %v, want the process left by the previous run", listed)
+		}
+
+		if err := restarted.Stop(spec.DeploymentID); err != nil {
+			t.Fatalf("got error stopping the leftover %v, want nil", err)
+		}
+		if listening() {
+			t.Fatalf("got port %s still bound, want the leftover killed", port)
+		}
+
+		if _, err := restarted.Start(spec); err != nil {
+			t.Fatalf("got error starting again %v, want nil", err)
+		}
+		if !wait_for(t, 10*time.Second, listening) {
+			t.Fatalf("got port %s closed, want the deployment listening again", port)
+		}
+		if status, _ := restarted.Status(spec.DeploymentID); status.State != StateRunning {
+			t.Errorf("got state %s, want %s", status.State, StateRunning)
+		}
+	})
+
+	t.Run("should report unknown deployments as not found", func (t *testing.T) {
+		local := NewLocalRuntime(ctx, time.Second, nil, nil, "")
+
+		status, err := local.Status("missing")
+		if err != nil || status.State != StateNotFound {
+			t.Errorf("got status %+v (%v), want %s", status, err, StateNotFound)
+		}
+	})
+}
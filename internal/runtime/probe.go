@@ -0,0 +1,79 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	ProbeTCP = "tcp"
+	ProbeHTTP = "http"
+	ProbeNone = "none"
+)
+
+// Probe checks that a deployment accepts traffic on the host port both
+// backends publish it on.
+type Probe struct {
+	Type string
+	Host string
+	Port string
+	Path string
+	Timeout time.Duration
+}
+
+// NewProbe builds a probe against the PORT variable of a spec, deployments
+// without one can only be checked for liveness.
+func NewProbe(probe_type string, path string, timeout time.Duration, spec Spec) *Probe {
+	port, ok := spec.Variables["PORT"]
+	if !ok || port == "" || probe_type == ProbeNone {
+		return nil
+	}
+
+	return &Probe{
+		Type: probe_type,
+		Host: "127.0.0.1",
+		Port: port,
+		Path: path,
+		Timeout: timeout,
+	}
+}
+
+func (p *Probe) Check(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+
+	address := net.JoinHostPort(p.Host, p.Port)
+
+	switch p.Type {
+	case ProbeTCP:
+		dialer := net.Dialer{}
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case ProbeHTTP:
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+address+p.Path, nil)
+		if err != nil {
+			return err
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		io.Copy(io.Discard, io.LimitReader(res.Body, 64*1024))
+
+		if res.StatusCode < 200 || res.StatusCode >= 400 {
+			return fmt.Errorf("GET %s returned %d", p.Path, res.StatusCode)
+		}
+		return nil
+	}
+
+	return errors.New("unknown probe type " + p.Type)
+}
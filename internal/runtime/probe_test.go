@@ -0,0 +1,61 @@
+package runtime
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProbe(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should pass tcp probes when the port accepts connections", func (t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("got error listening %v, want nil", err)
+		}
+		_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+		probe := NewProbe(ProbeTCP, "/", time.Second, Spec{Variables: map[string]string{"PORT": port}})
+		if err := probe.Check(ctx); err != nil {
+			t.Errorf("got error %v, want nil", err)
+		}
+
+		listener.Close()
+		if err := probe.Check(ctx); err == nil {
+			t.Errorf("got nil error after closing the port, want connection refused")
+		}
+	})
+
+	t.Run("should check the status of http probes on the configured path", func (t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func (w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/healthz" {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		}))
+		defer server.Close()
+		_, port, _ := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+
+		spec := Spec{Variables: map[string]string{"PORT": port}}
+
+		if err := NewProbe(ProbeHTTP, "/healthz", time.Second, spec).Check(ctx); err != nil {
+			t.Errorf("got error %v, want nil", err)
+		}
+		if err := NewProbe(ProbeHTTP, "/", time.Second, spec).Check(ctx); err == nil || !strings.Contains(err.Error(), "503") {
+			t.Errorf("got error %v, want the 503 status", err)
+		}
+	})
+
+	t.Run("should skip probing deployments without a port", func (t *testing.T) {
+		if probe := NewProbe(ProbeTCP, "/", time.Second, Spec{}); probe != nil {
+			t.Errorf("got probe %+v, want nil", probe)
+		}
+		if probe := NewProbe(ProbeNone, "/", time.Second, Spec{Variables: map[string]string{"PORT": "3000"}}); probe != nil {
+			t.Errorf("got probe %+v, want nil for none", probe)
+		}
+	})
+}
@@ -0,0 +1,107 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// process_groups records the process group of every locally run process in
+// dir, so what a previous run of the server left running is found and killed
+// even without cgroups. Records are tagged with the boot they were made in,
+// a group id from before a reboot may belong to anything by now.
+type process_groups struct {
+	dir string
+}
+
+func (g *process_groups) path(runtime_id string) string {
+	return filepath.Join(g.dir, filepath.Base(runtime_id)+".pgid")
+}
+
+func (g *process_groups) record(runtime_id string, pgid int) error {
+	if err := os.MkdirAll(g.dir, 0o750); err != nil {
+		return err
+	}
+
+	return os.WriteFile(g.path(runtime_id), []byte(fmt.Sprintf("%d %s", pgid, boot_id())), 0o640)
+}
+
+// forget removes the record of runtime_id unless a newer process replaced it
+func (g *process_groups) forget(runtime_id string, pgid int) {
+	if recorded, err := g.read(runtime_id); err == nil && recorded != pgid {
+		return
+	}
+
+	os.Remove(g.path(runtime_id))
+}
+
+func (g *process_groups) read(runtime_id string) (int, error) {
+	content, err := os.ReadFile(g.path(runtime_id))
+	if err != nil {
+		return 0, err
+	}
+
+	fields := strings.Fields(string(content))
+	if len(fields) == 0 {
+		return 0, errors.New("invalid_pgid_record")
+	}
+	pgid, err := strconv.Atoi(fields[0])
+	if err != nil || pgid <= 1 {
+		return 0, errors.New("invalid_pgid_record")
+	}
+	if len(fields) > 1 && fields[1] != boot_id() {
+		return 0, errors.New("stale_pgid_record")
+	}
+
+	return pgid, nil
+}
+
+// find returns the process group of runtime_id while any of its processes
+// still runs, records of groups that are gone are removed.
+func (g *process_groups) find(runtime_id string) (int, bool) {
+	pgid, err := g.read(runtime_id)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			os.Remove(g.path(runtime_id))
+		}
+		return 0, false
+	}
+	if !group_alive(pgid) {
+		os.Remove(g.path(runtime_id))
+		return 0, false
+	}
+
+	return pgid, true
+}
+
+// alive returns the ids of the recorded groups that still have processes
+func (g *process_groups) alive() ([]string, error) {
+	entries, err := os.ReadDir(g.dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+
+	runtime_ids := []string{}
+	for _, entry := range entries {
+		runtime_id, ok := strings.CutSuffix(entry.Name(), ".pgid")
+		if entry.IsDir() || !ok {
+			continue
+		}
+		if _, ok := g.find(runtime_id); ok {
+			runtime_ids = append(runtime_ids, runtime_id)
+		}
+	}
+
+	return runtime_ids, nil
+}
+
+func group_alive(pgid int) bool {
+	return syscall.Kill(-pgid, 0) == nil
+}
@@ -0,0 +1,22 @@
+package runtime
+
+import (
+	"os"
+	"strings"
+	"syscall"
+)
+
+// process_attr puts the process in its own group, so Stop also reaches
+// whatever the start script spawned, and kills it when the server dies.
+func process_attr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setpgid: true, Pdeathsig: syscall.SIGKILL}
+}
+
+func boot_id() string {
+	content, err := os.ReadFile("/proc/sys/kernel/random/boot_id")
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(content))
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package runtime
+
+import "syscall"
+
+// process_attr puts the process in its own group, so Stop also reaches
+// whatever the start script spawned.
+func process_attr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setpgid: true}
+}
+
+// boot_id needs Linux, records elsewhere aren't tagged with the boot
+func boot_id() string {
+	return ""
+}
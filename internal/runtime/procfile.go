@@ -0,0 +1,80 @@
+package runtime
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// process types with a meaning of their own, web is the one taking requests
+// and release runs once before a deployment starts
+const (
+	ProcessWeb = "web"
+	ProcessRelease = "release"
+)
+
+// ProcfileProcess is one process type of a Procfile and the command it runs
+type ProcfileProcess struct {
+	Name string
+	Command string
+}
+
+// Procfile lists the process types of a project in the order they are
+// declared, it is empty when the project has none.
+type Procfile struct {
+	Processes []ProcfileProcess
+}
+
+var procfile_process_name = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// ReadProcfile parses the Procfile in the project root, `name: command` per
+// line. Blank lines and # comments are skipped.
+func ReadProcfile(project_root string) (*Procfile, error) {
+	file, err := os.Open(filepath.Join(project_root, "Procfile"))
+	if errors.Is(err, os.ErrNotExist) {
+		return &Procfile{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	procfile := &Procfile{}
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, command, ok := strings.Cut(line, ":")
+		name, command = strings.TrimSpace(name), strings.TrimSpace(command)
+		if !ok || !procfile_process_name.MatchString(name) || command == "" {
+			return nil, fmt.Errorf("invalid Procfile line %d, must be name: command", n)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("invalid Procfile line %d, %s is declared twice", n, name)
+		}
+		seen[name] = true
+		procfile.Processes = append(procfile.Processes, ProcfileProcess{Name: name, Command: command})
+	}
+
+	return procfile, scanner.Err()
+}
+
+// Web is the web process of the Procfile, the only process type bound to the
+// port the ingress proxies to.
+func (p *Procfile) Web() (ProcfileProcess, bool) {
+	for _, process := range p.Processes {
+		if process.Name == ProcessWeb {
+			return process, true
+		}
+	}
+
+	return ProcfileProcess{}, false
+}
@@ -0,0 +1,144 @@
+package runtime
+
+import (
+	"context"
+	"time"
+)
+
+type State string
+
+const (
+	StateRunning State = "running"
+	StateExited State = "exited"
+	StateNotFound State = "not_found"
+)
+
+const (
+	PhaseBuilding = "building"
+)
+
+const (
+	LogPhaseBuild = "build"
+	LogPhaseRun = "run"
+)
+
+// Spec describes one deployment to a runtime backend.
+type Spec struct {
+	// Context, if set, aborts Prepare when it is cancelled
+	Context context.Context
+	DeploymentID string
+	// BuildID names the deployment whose Prepare built what Start runs,
+	// one-off runs of a deployment's build get their own DeploymentID. It
+	// defaults to DeploymentID.
+	BuildID string
+	AppID string
+	// ArtifactsPath is the per-deployment directory holding the uploaded
+	// bundle
+	ArtifactsPath string
+	// BundleLimits bound what the bundle may extract to
+	BundleLimits ExtractLimits
+	// Resources limit the started process
+	Resources Resources
+	Variables map[string]string
+	// Command, if set, is run instead of the project's start command
+	Command string
+	// Static makes Prepare build a static site to be served from StaticRoot,
+	// there is nothing to Start
+	Static bool
+	// Stack, if set, is what the project is built and started as instead of
+	// the detected stack
+	Stack string
+	// Process names the process type Command runs, it defaults to the type of
+	// the start command and tags the output lines of the instance
+	Process string
+	// Unbound deployments get no port, a Procfile without web may then start
+	// its only other process type
+	Unbound bool
+	// OnPhase, if set, is called when Prepare moves past extraction into
+	// building
+	OnPhase func(phase string)
+	// OnStack, if set, is told the stack Prepare builds with
+	OnStack func(stack string)
+	// OnLog, if set, receives every build and run output line as it is
+	// produced
+	OnLog func(line LogLine)
+}
+
+func (spec Spec) build_id() string {
+	if spec.BuildID != "" {
+		return spec.BuildID
+	}
+
+	return spec.DeploymentID
+}
+
+func (spec Spec) report(phase string) {
+	if spec.OnPhase != nil {
+		spec.OnPhase(phase)
+	}
+}
+
+func (spec Spec) report_stack(stack string) {
+	if spec.OnStack != nil {
+		spec.OnStack(stack)
+	}
+}
+
+// context_or returns the spec's context, or fallback when it has none
+func (spec Spec) context_or(fallback context.Context) context.Context {
+	if spec.Context != nil {
+		return spec.Context
+	}
+
+	return fallback
+}
+
+func (spec Spec) log(line LogLine) {
+	if spec.OnLog != nil {
+		spec.OnLog(line)
+	}
+}
+
+// Resources caps what a deployment's process may use, zero means unlimited.
+// CPUMillis is in thousandths of a core.
+type Resources struct {
+	CPUMillis int32
+	MemoryMB int32
+}
+
+// Instance identifies what a backend launched for a deployment, it is
+// persisted as a row of the deployment's processes.
+type Instance struct {
+	Process string
+	ProcessName string
+	ContainerName string
+}
+
+type Status struct {
+	State State `json:"state"`
+	Pid int `json:"pid"`
+	ExitCode int `json:"exit_code"`
+	StartedAt time.Time `json:"started_at"`
+	ExitedAt time.Time `json:"exited_at"`
+	OOMKilled bool `json:"oom_killed"`
+}
+
+type LogLine struct {
+	Phase string `json:"phase"`
+	Process string `json:"process,omitempty"`
+	Stream string `json:"stream"`
+	Line string `json:"line"`
+	Time time.Time `json:"time"`
+}
+
+// Runtime runs deployments on one backend. List returns the ids of the
+// deployments with a live instance, including ones left behind by a previous
+// run of the API server when the backend can find them.
+type Runtime interface {
+	Prepare(spec Spec) error
+	Start(spec Spec) (*Instance, error)
+	Stop(deployment_id string) error
+	Status(deployment_id string) (*Status, error)
+	Logs(deployment_id string, tail int) ([]LogLine, error)
+	List() ([]string, error)
+}
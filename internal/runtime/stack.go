@@ -0,0 +1,275 @@
+package runtime
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+const (
+	StackNode = "node"
+	StackPython = "python"
+	StackGo = "go"
+	// bundles with nothing but a Procfile run its web process as they are
+	StackProcfile = "procfile"
+)
+
+func GetSupportedStacks() []string {
+	return []string{
+		StackNode,
+		StackPython,
+		StackGo,
+		StackProcfile,
+	}
+}
+
+// files a stack is detected by, in the order stacks are tried
+var stack_markers = []struct {
+	stack string
+	files []string
+}{
+	{StackNode, []string{"package.json"}},
+	{StackPython, []string{"requirements.txt", "pyproject.toml"}},
+	{StackGo, []string{"go.mod"}},
+	{StackProcfile, []string{"Procfile"}},
+}
+
+// where Go builds put the binary they start, relative to the project root
+const go_binary = ".capybara/app"
+
+func file_exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// DetectStack names the stack of a project by the files in its root
+func DetectStack(project_root string) (string, error) {
+	for _, marker := range stack_markers {
+		for _, name := range marker.files {
+			if file_exists(filepath.Join(project_root, name)) {
+				return marker.stack, nil
+			}
+		}
+	}
+
+	return "", errors.New("stack_not_detected")
+}
+
+// StackPlan is how Prepare builds a project and what Start runs in it.
+// Manifests are copied into images before the sources so the install layer
+// is reused while they don't change, installs without manifests run once the
+// sources are copied. Env is set in images, local processes get theirs from
+// process_env. StartProcess is the process type StartCommand runs as,
+// Processes are the other ones a Procfile declares and ReleaseCommand runs
+// once before they start.
+type StackPlan struct {
+	Stack string
+	Manifests []string
+	InstallCommand string
+	BuildCommand string
+	StartCommand string
+	StartProcess string
+	Processes []ProcfileProcess
+	ReleaseCommand string
+	Env map[string]string
+	// Node.js installs are cached by their lockfile
+	Node *BuildPlan
+}
+
+// PlanStack plans a project as the given stack. The web process of a
+// Procfile wins over the stack's own start command, which is left empty when
+// the stack has none to offer. The other process types never take its place.
+func PlanStack(project_root string, stack string) (StackPlan, error) {
+	var plan StackPlan
+
+	switch stack {
+	case StackNode:
+		pkg, err := ReadPackageJSON(project_root)
+		if err != nil {
+			return StackPlan{}, err
+		}
+		// a Procfile or the spec's command may still provide one
+		start, _ := pkg.StartCommand(project_root)
+		plan = node_stack_plan(PlanBuild(project_root, pkg), start)
+	case StackPython:
+		plan = python_stack_plan(project_root)
+	case StackGo:
+		plan = go_stack_plan(project_root)
+	case StackProcfile:
+		plan = StackPlan{Stack: StackProcfile}
+	default:
+		return StackPlan{}, errors.New("unsupported_stack")
+	}
+
+	procfile, err := ReadProcfile(project_root)
+	if err != nil {
+		return StackPlan{}, err
+	}
+	plan.StartProcess = ProcessWeb
+	if web, ok := procfile.Web(); ok {
+		plan.StartCommand = web.Command
+	}
+	for _, process := range procfile.Processes {
+		switch process.Name {
+		case ProcessWeb:
+		case ProcessRelease:
+			plan.ReleaseCommand = process.Command
+		default:
+			plan.Processes = append(plan.Processes, process)
+		}
+	}
+
+	return plan, nil
+}
+
+func node_stack_plan(build BuildPlan, start string) StackPlan {
+	manifests := []string{"package.json"}
+	if build.Lockfile != "" {
+		manifests = append(manifests, build.Lockfile)
+	}
+
+	return StackPlan{
+		Stack: StackNode,
+		Manifests: manifests,
+		InstallCommand: build.InstallCommand,
+		BuildCommand: build.BuildCommand,
+		StartCommand: start,
+		Env: map[string]string{"NODE_ENV": "production"},
+		Node: &build,
+	}
+}
+
+// python_stack_plan installs into a virtualenv in the project, which is put
+// first on the PATH so python3 is the one with the dependencies.
+func python_stack_plan(project_root string) StackPlan {
+	plan := StackPlan{
+		Stack: StackPython,
+		Env: map[string]string{
+			"PATH": "/app/.venv/bin:$PATH",
+			"PYTHONUNBUFFERED": "1",
+		},
+	}
+
+	switch {
+	case file_exists(filepath.Join(project_root, "requirements.txt")):
+		plan.Manifests = []string{"requirements.txt"}
+		plan.InstallCommand = "python3 -m venv .venv && .venv/bin/pip install --no-cache-dir -r requirements.txt"
+	case file_exists(filepath.Join(project_root, "pyproject.toml")):
+		// the project itself gets installed, it needs the sources
+		plan.InstallCommand = "python3 -m venv .venv && .venv/bin/pip install --no-cache-dir ."
+	}
+
+	for _, entrypoint := range []string{"main.py", "app.py"} {
+		if file_exists(filepath.Join(project_root, entrypoint)) {
+			plan.StartCommand = "python3 " + entrypoint
+			break
+		}
+	}
+
+	return plan
+}
+
+func go_stack_plan(project_root string) StackPlan {
+	manifests := []string{"go.mod"}
+	if file_exists(filepath.Join(project_root, "go.sum")) {
+		manifests = append(manifests, "go.sum")
+	}
+
+	return StackPlan{
+		Stack: StackGo,
+		Manifests: manifests,
+		InstallCommand: "go mod download",
+		BuildCommand: "go build -o " + go_binary + " " + go_main_package(project_root),
+		StartCommand: "./" + go_binary,
+	}
+}
+
+// go_main_package is the root package, or the only command under cmd/ when
+// the root has no Go files.
+func go_main_package(project_root string) string {
+	if matches, _ := filepath.Glob(filepath.Join(project_root, "*.go")); len(matches) > 0 {
+		return "."
+	}
+
+	entries, err := os.ReadDir(filepath.Join(project_root, "cmd"))
+	if err != nil {
+		return "."
+	}
+	commands := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			commands = append(commands, entry.Name())
+		}
+	}
+	if len(commands) == 1 {
+		return "./cmd/" + commands[0]
+	}
+
+	return "."
+}
+
+// plan_project finds the project root of an extracted bundle and plans it as
+// the spec's stack, or the detected one. The spec's command replaces the
+// start command.
+func plan_project(source_dir string, spec Spec) (string, StackPlan, error) {
+	project_root, err := ProjectRoot(source_dir)
+	if err != nil {
+		if spec.Stack == "" {
+			return "", StackPlan{}, err
+		}
+		// a stack set by hand doesn't need the files it is detected by
+		if project_root, err = bundle_root(source_dir); err != nil {
+			return "", StackPlan{}, err
+		}
+	}
+
+	stack := spec.Stack
+	if stack == "" {
+		if stack, err = DetectStack(project_root); err != nil {
+			return "", StackPlan{}, err
+		}
+	}
+
+	plan, err := PlanStack(project_root, stack)
+	if err != nil {
+		return "", StackPlan{}, err
+	}
+	if spec.Command != "" {
+		plan.StartCommand = spec.Command
+	}
+	// nothing is routed to unbound deployments, their only process type can
+	// be started in place of web
+	if plan.StartCommand == "" && spec.Unbound && len(plan.Processes) == 1 {
+		plan.StartProcess = plan.Processes[0].Name
+		plan.StartCommand = plan.Processes[0].Command
+		plan.Processes = nil
+	}
+	if plan.StartCommand == "" {
+		if len(plan.Processes) > 0 {
+			return "", StackPlan{}, errors.New("web_process_not_found")
+		}
+		return "", StackPlan{}, errors.New("start_command_not_found")
+	}
+
+	return project_root, plan, nil
+}
+
+// PlanDeployment plans the project Prepare extracted for spec, e.g. to find
+// the process types it runs.
+func PlanDeployment(spec Spec) (StackPlan, error) {
+	_, plan, err := plan_project(SourceDir(spec.ArtifactsPath), spec)
+
+	return plan, err
+}
+
+// start_process is the process type an instance of spec runs
+func start_process(spec Spec) string {
+	if spec.Process != "" {
+		return spec.Process
+	}
+	if _, plan, err := plan_project(SourceDir(spec.ArtifactsPath), spec); err == nil {
+		return plan.StartProcess
+	}
+
+	return ProcessWeb
+}
@@ -0,0 +1,324 @@
+package runtime
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDetectStack(t *testing.T) {
+	tests := []struct {
+		name string
+		files map[string]string
+		want string
+	}{
+		{"node", map[string]string{"package.json": "{}"}, StackNode},
+		{"python requirements", map[string]string{"requirements.txt": "flask"}, StackPython},
+		{"python project", map[string]string{"pyproject.toml": "[project]"}, StackPython},
+		{"go", map[string]string{"go.mod": "module hello"}, StackGo},
+		{"procfile", map[string]string{"Procfile": "web: ./server"}, StackProcfile},
+		// assets built with npm don't make a python app a node one
+		{"node wins over python", map[string]string{"package.json": "{}", "requirements.txt": ""}, StackNode},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func (t *testing.T) {
+			project_root := t.TempDir()
+			write_files(t, project_root, tt.files)
+
+			got, err := DetectStack(project_root)
+			if err != nil || got != tt.want {
+				t.Errorf("got stack %q and error %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+
+	t.Run("should find the project in a single top level folder", func (t *testing.T) {
+		source_dir := t.TempDir()
+		write_files(t, source_dir, map[string]string{"api/go.mod": "module api"})
+
+		got, err := ProjectRoot(source_dir)
+		if err != nil || got != filepath.Join(source_dir, "api") {
+			t.Errorf("got root %s and error %v, want the nested folder", got, err)
+		}
+	})
+
+	t.Run("should fail when no stack is recognized", func (t *testing.T) {
+		source_dir := t.TempDir()
+		write_files(t, source_dir, map[string]string{"index.php": "<?php"})
+
+		if _, err := ProjectRoot(source_dir); err == nil || err.Error() != "stack_not_detected" {
+			t.Errorf("got error %v, want stack_not_detected", err)
+		}
+	})
+}
+
+func TestPlanStack(t *testing.T) {
+	tests := []struct {
+		name string
+		stack string
+		files map[string]string
+		want StackPlan
+	}{
+		{
+			name: "python requirements",
+			stack: StackPython,
+			files: map[string]string{"requirements.txt": "flask", "app.py": ""},
+			want: StackPlan{
+				Stack: StackPython,
+				Manifests: []string{"requirements.txt"},
+				InstallCommand: "python3 -m venv .venv && .venv/bin/pip install --no-cache-dir -r requirements.txt",
+				StartCommand: "python3 app.py",
+			},
+		},
+		{
+			name: "python project installed from its sources",
+			stack: StackPython,
+			files: map[string]string{"pyproject.toml": "[project]", "main.py": "", "app.py": ""},
+			want: StackPlan{
+				Stack: StackPython,
+				InstallCommand: "python3 -m venv .venv && .venv/bin/pip install --no-cache-dir .",
+				StartCommand: "python3 main.py",
+			},
+		},
+		{
+			name: "go module with its main package in the root",
+			stack: StackGo,
+			files: map[string]string{"go.mod": "module hello", "go.sum": "", "main.go": "package main"},
+			want: StackPlan{
+				Stack: StackGo,
+				Manifests: []string{"go.mod", "go.sum"},
+				InstallCommand: "go mod download",
+				BuildCommand: "go build -o .capybara/app .",
+				StartCommand: "./.capybara/app",
+			},
+		},
+		{
+			name: "go module with a single command",
+			stack: StackGo,
+			files: map[string]string{"go.mod": "module hello", "cmd/api/main.go": "package main", "internal/db/db.go": "package db"},
+			want: StackPlan{
+				Stack: StackGo,
+				Manifests: []string{"go.mod"},
+				InstallCommand: "go mod download",
+				BuildCommand: "go build -o .capybara/app ./cmd/api",
+				StartCommand: "./.capybara/app",
+			},
+		},
+		{
+			name: "procfile web process wins over the start script",
+			stack: StackNode,
+			files: map[string]string{
+				"package.json": `{"scripts": {"start": "node index.js"}}`,
+				"Procfile": "# processes\nworker: node worker.js\nweb: node --max-old-space-size=256 index.js\n",
+			},
+			want: StackPlan{
+				Stack: StackNode,
+				Manifests: []string{"package.json"},
+				StartCommand: "node --max-old-space-size=256 index.js",
+			},
+		},
+		{
+			name: "procfile alone",
+			stack: StackProcfile,
+			files: map[string]string{"Procfile": "web: ./server --port $PORT"},
+			want: StackPlan{Stack: StackProcfile, StartCommand: "./server --port $PORT"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func (t *testing.T) {
+			project_root := t.TempDir()
+			write_files(t, project_root, tt.files)
+
+			got, err := PlanStack(project_root, tt.stack)
+			if err != nil {
+				t.Fatalf("got error %v, want nil", err)
+			}
+
+			if got.Stack != tt.want.Stack ||
+				strings.Join(got.Manifests, ",") != strings.Join(tt.want.Manifests, ",") ||
+				got.InstallCommand != tt.want.InstallCommand ||
+				got.BuildCommand != tt.want.BuildCommand ||
+				got.StartCommand != tt.want.StartCommand {
+				t.Errorf("got plan %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("should split the Procfile into the web, other and release processes", func (t *testing.T) {
+		tests := []struct {
+			procfile string
+			want_start string
+			want_processes []string
+			want_release string
+		}{
+			{"web: ./server\nworker: ./worker\nclock: ./clock\nrelease: ./migrate\n", ProcessWeb, []string{"worker", "clock"}, "./migrate"},
+			{"release: ./migrate\nworker: ./worker\n", ProcessWeb, []string{"worker"}, "./migrate"},
+			{"worker: ./worker\nclock: ./clock\n", ProcessWeb, []string{"worker", "clock"}, ""},
+		}
+
+		for _, tt := range tests {
+			project_root := t.TempDir()
+			write_files(t, project_root, map[string]string{"Procfile": tt.procfile, "main.py": ""})
+
+			got, err := PlanStack(project_root, StackPython)
+			if err != nil {
+				t.Fatalf("got error %v, want nil", err)
+			}
+
+			got_processes := []string{}
+			for _, process := range got.Processes {
+				got_processes = append(got_processes, process.Name)
+			}
+			if got.StartProcess != tt.want_start || !slices.Equal(got_processes, tt.want_processes) || got.ReleaseCommand != tt.want_release {
+				t.Errorf("got plan %+v for %q, want %s started, %v next to it and release %q", got, tt.procfile, tt.want_start, tt.want_processes, tt.want_release)
+			}
+		}
+	})
+
+	t.Run("should reject unknown stacks and broken Procfiles", func (t *testing.T) {
+		project_root := t.TempDir()
+		write_files(t, project_root, map[string]string{"Procfile": "web ./server"})
+
+		if _, err := PlanStack(project_root, "ruby"); err == nil || err.Error() != "unsupported_stack" {
+			t.Errorf("got error %v, want unsupported_stack", err)
+		}
+		if _, err := PlanStack(project_root, StackProcfile); err == nil || !strings.Contains(err.Error(), "invalid Procfile line 1") {
+			t.Errorf("got error %v, want the Procfile line", err)
+		}
+	})
+}
+
+func TestPlanProject(t *testing.T) {
+	t.Run("should build as the spec's stack without its files", func (t *testing.T) {
+		source_dir := t.TempDir()
+		write_files(t, source_dir, map[string]string{"package.json": "{}", "main.py": ""})
+
+		_, plan, err := plan_project(source_dir, Spec{Stack: StackPython})
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if plan.Stack != StackPython || plan.StartCommand != "python3 main.py" {
+			t.Errorf("got plan %+v, want python started from main.py", plan)
+		}
+	})
+
+	t.Run("should run the spec's command instead of the start command", func (t *testing.T) {
+		source_dir := t.TempDir()
+		write_files(t, source_dir, map[string]string{"go.mod": "module hello"})
+
+		_, plan, err := plan_project(source_dir, Spec{Command: "./.capybara/app migrate"})
+		if err != nil || plan.StartCommand != "./.capybara/app migrate" {
+			t.Errorf("got plan %+v and error %v, want the spec's command", plan, err)
+		}
+	})
+
+	t.Run("should only bind the web process of a Procfile", func (t *testing.T) {
+		source_dir := t.TempDir()
+		write_files(t, source_dir, map[string]string{"Procfile": "release: ./migrate\nworker: ./worker\n"})
+
+		if _, _, err := plan_project(source_dir, Spec{}); err == nil || err.Error() != "web_process_not_found" {
+			t.Errorf("got error %v, want web_process_not_found", err)
+		}
+
+		_, plan, err := plan_project(source_dir, Spec{Unbound: true})
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if plan.StartProcess != "worker" || plan.StartCommand != "./worker" || len(plan.Processes) != 0 {
+			t.Errorf("got plan %+v, want the only process type started by an unbound deployment", plan)
+		}
+	})
+
+	t.Run("should run a Procfile without web next to the stack's start command", func (t *testing.T) {
+		source_dir := t.TempDir()
+		write_files(t, source_dir, map[string]string{"main.py": "", "Procfile": "worker: celery -A app worker\n"})
+
+		_, plan, err := plan_project(source_dir, Spec{Stack: StackPython})
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if plan.StartProcess != ProcessWeb || plan.StartCommand != "python3 main.py" || len(plan.Processes) != 1 || plan.Processes[0].Name != "worker" {
+			t.Errorf("got plan %+v, want main.py as web and the worker next to it", plan)
+		}
+	})
+
+	t.Run("should fail when nothing tells how to start", func (t *testing.T) {
+		source_dir := t.TempDir()
+		write_files(t, source_dir, map[string]string{"requirements.txt": "celery"})
+
+		if _, _, err := plan_project(source_dir, Spec{}); err == nil || err.Error() != "start_command_not_found" {
+			t.Errorf("got error %v, want start_command_not_found", err)
+		}
+	})
+}
+
+func TestLocalRuntimeStacks(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should build a go module and start its binary", func (t *testing.T) {
+		bin := t.TempDir()
+		calls := filepath.Join(bin, "calls")
+		script := `#!/bin/sh
+echo "$@" >> ` + calls + `
+if [ "$1" = build ]; then
+	mkdir -p .capybara
+	printf '#!/bin/sh\necho "serving on $PORT"\nsleep 30\n' > .capybara/app
+	chmod +x .capybara/app
+fi
+`
+		if err := os.WriteFile(filepath.Join(bin, "go"), []byte(script), 0o755); err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+		artifacts_path := t.TempDir()
+		write_test_bundle(t, artifacts_path, map[string]string{
+			"go.mod": "module hello",
+			"main.go": "package main",
+		})
+
+		local := NewLocalRuntime(ctx, time.Second, nil, nil, "")
+		detected := ""
+		spec := Spec{
+			DeploymentID: "dp-go",
+			ArtifactsPath: artifacts_path,
+			Variables: map[string]string{"PORT": "3000"},
+			OnStack: func(stack string) { detected = stack },
+		}
+
+		if err := local.Prepare(spec); err != nil {
+			t.Fatalf("got error preparing %v, want nil", err)
+		}
+		if detected != StackGo {
+			t.Errorf("got stack %q reported, want go", detected)
+		}
+		content, _ := os.ReadFile(calls)
+		if got := strings.TrimSpace(string(content)); got != "mod download\nbuild -o .capybara/app ." {
+			t.Errorf("got go calls %q, want download and build", got)
+		}
+
+		if _, err := local.Start(spec); err != nil {
+			t.Fatalf("got error starting %v, want nil", err)
+		}
+		defer local.Stop(spec.DeploymentID)
+
+		ok := wait_for(t, 5*time.Second, func() bool {
+			lines, _ := local.Logs(spec.DeploymentID, 0)
+			for _, line := range lines {
+				if line.Line == "serving on 3000" {
+					return true
+				}
+			}
+			return false
+		})
+		if !ok {
+			t.Errorf("got no output of the binary, want it started")
+		}
+	})
+}
@@ -0,0 +1,176 @@
+package runtime
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// directories static site generators write to, in the order they are tried
+var static_output_dirs = []string{"dist", "build", "out", "_site", "public"}
+
+// files smaller than this aren't worth a gzip variant
+const min_precompress_size = 1024
+
+var precompressed_exts = map[string]bool{
+	".html": true, ".htm": true, ".css": true, ".js": true, ".mjs": true,
+	".json": true, ".map": true, ".svg": true, ".txt": true, ".xml": true,
+	".webmanifest": true, ".wasm": true, ".ico": true, ".ttf": true, ".otf": true,
+}
+
+// site_root is the top of an uploaded static site, unwrapped from a single
+// top level folder like ProjectRoot does, and whether it has a package.json.
+func site_root(source_dir string) (string, bool, error) {
+	root, err := ProjectRoot(source_dir)
+	if err != nil {
+		if root, err = bundle_root(source_dir); err != nil {
+			return "", false, err
+		}
+	}
+
+	return root, file_exists(filepath.Join(root, "package.json")), nil
+}
+
+// StaticRoot returns the directory a static site is served from. Sites with
+// a package.json are served from the first output directory holding an
+// index.html, or existing at all, and from their root otherwise.
+func StaticRoot(source_dir string) (string, error) {
+	root, has_package, err := site_root(source_dir)
+	if err != nil {
+		return "", err
+	}
+	if !has_package {
+		return root, nil
+	}
+
+	for _, dir := range static_output_dirs {
+		if _, err := os.Stat(filepath.Join(root, dir, "index.html")); err == nil {
+			return filepath.Join(root, dir), nil
+		}
+	}
+	for _, dir := range static_output_dirs {
+		if info, err := os.Stat(filepath.Join(root, dir)); err == nil && info.IsDir() {
+			return filepath.Join(root, dir), nil
+		}
+	}
+
+	return root, nil
+}
+
+// static_build runs the build script of a static site rooted at root,
+// leaving its output in one of static_output_dirs.
+type static_build func(root string, plan StackPlan) error
+
+// prepare_static builds an extracted static site when its package.json has
+// a build script and writes gzip variants of the files it serves, brotli
+// ones are only served when they were uploaded.
+func prepare_static(spec Spec, source_dir string, build static_build) error {
+	root, has_package, err := site_root(source_dir)
+	if err != nil {
+		return err
+	}
+
+	if has_package {
+		plan, err := PlanStack(root, StackNode)
+		if err != nil {
+			return err
+		}
+		if plan.BuildCommand != "" {
+			if err := build(root, plan); err != nil {
+				return err
+			}
+		}
+	}
+
+	static_root, err := StaticRoot(source_dir)
+	if err != nil {
+		return err
+	}
+	served, err := filepath.Rel(source_dir, static_root)
+	if err != nil {
+		return err
+	}
+	build_log(spec, "stdout", "Serving files from "+filepath.ToSlash(served))
+
+	compressed, err := precompress(static_root)
+	if err != nil {
+		return err
+	}
+	if compressed > 0 {
+		build_log(spec, "stdout", fmt.Sprintf("Compressed %d files with gzip", compressed))
+	}
+
+	return nil
+}
+
+// precompress writes a .gz next to every compressible file that doesn't
+// have one yet. Symlinks are left alone, they may point out of the site.
+func precompress(dir string) (int, error) {
+	compressed := 0
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == "node_modules" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || !precompressed_exts[strings.ToLower(filepath.Ext(path))] {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.Size() < min_precompress_size {
+			return nil
+		}
+		if _, err := os.Lstat(path + ".gz"); err == nil {
+			return nil
+		}
+
+		if err := gzip_file(path); err != nil {
+			return err
+		}
+		compressed++
+
+		return nil
+	})
+
+	return compressed, err
+}
+
+func gzip_file(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o640)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	zw, err := gzip.NewWriterLevel(dst, gzip.BestCompression)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(zw, src); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	return dst.Close()
+}
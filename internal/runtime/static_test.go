@@ -0,0 +1,134 @@
+package runtime
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStaticRoot(t *testing.T) {
+	tests := []struct {
+		name string
+		files map[string]string
+		want string
+	}{
+		{
+			name: "plain upload",
+			files: map[string]string{"index.html": "", "dist/index.html": ""},
+			want: ".",
+		},
+		{
+			name: "upload packed in a folder",
+			files: map[string]string{"site/index.html": ""},
+			want: "site",
+		},
+		{
+			name: "build output wins over public sources",
+			files: map[string]string{"package.json": "{}", "public/index.html": "", "build/index.html": ""},
+			want: "build",
+		},
+		{
+			name: "output without an index",
+			files: map[string]string{"package.json": "{}", "index.html": "", "dist/app.js": ""},
+			want: "dist",
+		},
+		{
+			name: "project without output",
+			files: map[string]string{"package.json": "{}", "index.html": ""},
+			want: ".",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func (t *testing.T) {
+			source_dir := t.TempDir()
+			write_files(t, source_dir, tt.files)
+
+			got, err := StaticRoot(source_dir)
+			if err != nil {
+				t.Fatalf("got error %v, want nil", err)
+			}
+			if want := filepath.Join(source_dir, tt.want); got != want {
+				t.Errorf("got root %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestPrepareStatic(t *testing.T) {
+	ctx := context.Background()
+	local := NewLocalRuntime(ctx, time.Second, nil, nil, "")
+
+	t.Run("should serve an upload as is with gzip variants of larger text files", func (t *testing.T) {
+		artifacts_path := t.TempDir()
+		write_test_bundle(t, artifacts_path, map[string]string{
+			"index.html": "<h1>hello</h1>",
+			"app.js": strings.Repeat("console.log('hello');\n", 100),
+			"logo.png": strings.Repeat("x", 2048),
+		})
+
+		if err := local.Prepare(Spec{DeploymentID: "dp-1", ArtifactsPath: artifacts_path, Static: true}); err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		source_dir := SourceDir(artifacts_path)
+		compressed, err := os.Open(filepath.Join(source_dir, "app.js.gz"))
+		if err != nil {
+			t.Fatalf("got error %v opening the gzip variant, want it written", err)
+		}
+		defer compressed.Close()
+		zr, err := gzip.NewReader(compressed)
+		if err != nil {
+			t.Fatalf("got error %v, want a gzip stream", err)
+		}
+		content, _ := io.ReadAll(zr)
+		if original, _ := os.ReadFile(filepath.Join(source_dir, "app.js")); string(content) != string(original) {
+			t.Errorf("got the variant decompressing to %d bytes, want the %d bytes of the file", len(content), len(original))
+		}
+
+		for _, name := range []string{"index.html.gz", "logo.png.gz"} {
+			if _, err := os.Stat(filepath.Join(source_dir, name)); err == nil {
+				t.Errorf("got %s written, want small and binary files left alone", name)
+			}
+		}
+	})
+
+	t.Run("should run the build script and serve its output", func (t *testing.T) {
+		bin := t.TempDir()
+		script := "#!/bin/sh\nmkdir -p dist && echo '<h1>built</h1>' > dist/index.html\n"
+		if err := os.WriteFile(filepath.Join(bin, "npm"), []byte(script), 0o755); err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+		artifacts_path := t.TempDir()
+		write_test_bundle(t, artifacts_path, map[string]string{
+			"package.json": `{"name": "site", "scripts": {"build": "vite build"}}`,
+			"index.html": "<script src=/src/main.js></script>",
+		})
+
+		lines := []string{}
+		spec := Spec{
+			DeploymentID: "dp-2",
+			ArtifactsPath: artifacts_path,
+			Static: true,
+			OnLog: func(line LogLine) { lines = append(lines, line.Line) },
+		}
+		if err := local.Prepare(spec); err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+
+		root, _ := StaticRoot(SourceDir(artifacts_path))
+		if content, _ := os.ReadFile(filepath.Join(root, "index.html")); strings.TrimSpace(string(content)) != "<h1>built</h1>" {
+			t.Errorf("got index %q served, want the built one", content)
+		}
+		if logs := strings.Join(lines, "\n"); !strings.Contains(logs, "$ npm run build") || !strings.Contains(logs, "Serving files from dist") {
+			t.Errorf("got build logs %q, want the build and the served directory", logs)
+		}
+	})
+}
@@ -0,0 +1,188 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+type Provider string
+
+const (
+	ProviderGitHub Provider = "github"
+	ProviderGitLab Provider = "gitlab"
+	ProviderGitea Provider = "gitea"
+)
+
+// Delivery is what was learned about one inbound webhook call. Provider,
+// Event and DeliveryID are filled in even when verification fails so the
+// rejected delivery can still be recorded. Push is nil for other events.
+type Delivery struct {
+	Provider Provider
+	Event string
+	DeliveryID string
+	Push *Push
+}
+
+// Push is a push event, Branch is empty for tag pushes.
+type Push struct {
+	Ref string
+	Branch string
+	CommitSHA string
+	Deleted bool
+}
+
+// push_payload holds the fields GitHub, GitLab and Gitea push payloads share
+type push_payload struct {
+	Ref string `json:"ref"`
+	After string `json:"after"`
+	CheckoutSHA string `json:"checkout_sha"`
+	Deleted bool `json:"deleted"`
+}
+
+const zero_sha = "0000000000000000000000000000000000000000"
+
+// commit_sha_regex matches full SHA-1 and SHA-256 object names
+var commit_sha_regex = regexp.MustCompile(`^([0-9a-f]{40}|[0-9a-f]{64})$`)
+
+// GenerateSecret returns a random hex secret to share with the git host
+func GenerateSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(secret), nil
+}
+
+// detect tells providers apart by their event header. Gitea also sends
+// GitHub's headers, so it is checked first.
+func detect(header http.Header) (*Delivery, error) {
+	switch {
+	case header.Get("X-Gitea-Event") != "":
+		return &Delivery{
+			Provider: ProviderGitea,
+			Event: header.Get("X-Gitea-Event"),
+			DeliveryID: header.Get("X-Gitea-Delivery"),
+		}, nil
+	case header.Get("X-Gitlab-Event") != "":
+		return &Delivery{
+			Provider: ProviderGitLab,
+			Event: header.Get("X-Gitlab-Event"),
+			DeliveryID: header.Get("X-Gitlab-Event-UUID"),
+		}, nil
+	case header.Get("X-GitHub-Event") != "":
+		return &Delivery{
+			Provider: ProviderGitHub,
+			Event: header.Get("X-GitHub-Event"),
+			DeliveryID: header.Get("X-GitHub-Delivery"),
+		}, nil
+	}
+
+	return nil, errors.New("unknown_provider")
+}
+
+func sign(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sign returns the signature header a provider would send for body, it is
+// meant for tests and for replaying deliveries by hand.
+func Sign(provider Provider, body []byte, secret string) (string, string) {
+	switch provider {
+	case ProviderGitea:
+		return "X-Gitea-Signature", sign(body, secret)
+	case ProviderGitLab:
+		return "X-Gitlab-Token", secret
+	default:
+		return "X-Hub-Signature-256", "sha256=" + sign(body, secret)
+	}
+}
+
+// verify checks the HMAC-SHA256 of the body for GitHub and Gitea. GitLab
+// doesn't sign payloads, it sends the secret itself as a token.
+func verify(delivery *Delivery, header http.Header, body []byte, secret string) error {
+	var got, want string
+
+	switch delivery.Provider {
+	case ProviderGitHub:
+		got = strings.ToLower(header.Get("X-Hub-Signature-256"))
+		want = "sha256=" + sign(body, secret)
+	case ProviderGitea:
+		got = strings.ToLower(header.Get("X-Gitea-Signature"))
+		want = sign(body, secret)
+	case ProviderGitLab:
+		got = header.Get("X-Gitlab-Token")
+		want = secret
+	}
+
+	if got == "" {
+		return errors.New("missing_signature")
+	}
+	if subtle.ConstantTimeCompare([]byte(got), []byte(want)) != 1 {
+		return errors.New("invalid_signature")
+	}
+
+	return nil
+}
+
+func is_push_event(delivery *Delivery) bool {
+	if delivery.Provider == ProviderGitLab {
+		return delivery.Event == "Push Hook"
+	}
+
+	return delivery.Event == "push"
+}
+
+// Parse detects the provider of a delivery, verifies it against secret and
+// reads the push out of it. The delivery is returned alongside
+// verification errors.
+func Parse(header http.Header, body []byte, secret string) (*Delivery, error) {
+	delivery, err := detect(header)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := verify(delivery, header, body, secret); err != nil {
+		return delivery, err
+	}
+
+	if !is_push_event(delivery) {
+		return delivery, nil
+	}
+
+	var payload push_payload
+	if err := json.Unmarshal(body, &payload); err != nil || payload.Ref == "" {
+		return delivery, errors.New("invalid_payload")
+	}
+
+	commit_sha := payload.After
+	if payload.CheckoutSHA != "" {
+		commit_sha = payload.CheckoutSHA
+	}
+
+	delivery.Push = &Push{
+		Ref: payload.Ref,
+		Branch: strings.TrimPrefix(payload.Ref, "refs/heads/"),
+		CommitSHA: commit_sha,
+		Deleted: payload.Deleted || commit_sha == "" || commit_sha == zero_sha,
+	}
+	if !strings.HasPrefix(payload.Ref, "refs/heads/") {
+		delivery.Push.Branch = ""
+	}
+	if !delivery.Push.Deleted && !commit_sha_regex.MatchString(commit_sha) {
+		return delivery, errors.New("invalid_payload")
+	}
+
+	return delivery, nil
+}
@@ -0,0 +1,169 @@
+package webhooks
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const test_secret = "s3cret"
+const test_sha = "8f2a1c3d4e5f60718293a4b5c6d7e8f901234567"
+
+func push_body(ref string, after string) []byte {
+	return []byte(`{"ref":"` + ref + `","after":"` + after + `"}`)
+}
+
+func signed_header(provider Provider, event string, body []byte, secret string) http.Header {
+	header := http.Header{}
+	switch provider {
+	case ProviderGitea:
+		header.Set("X-Gitea-Event", event)
+		header.Set("X-Gitea-Delivery", "gitea-1")
+		// Gitea sends GitHub's headers as well
+		header.Set("X-GitHub-Event", event)
+	case ProviderGitLab:
+		header.Set("X-Gitlab-Event", event)
+		header.Set("X-Gitlab-Event-UUID", "gitlab-1")
+	default:
+		header.Set("X-GitHub-Event", event)
+		header.Set("X-GitHub-Delivery", "github-1")
+	}
+
+	name, value := Sign(provider, body, secret)
+	header.Set(name, value)
+
+	return header
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		provider Provider
+		event string
+		delivery_id string
+	}{
+		{ProviderGitHub, "push", "github-1"},
+		{ProviderGitLab, "Push Hook", "gitlab-1"},
+		{ProviderGitea, "push", "gitea-1"},
+	}
+
+	for _, tt := range tests {
+		t.Run("should parse "+string(tt.provider)+" pushes", func (t *testing.T) {
+			body := push_body("refs/heads/main", test_sha)
+
+			delivery, err := Parse(signed_header(tt.provider, tt.event, body, test_secret), body, test_secret)
+			if err != nil {
+				t.Fatalf("got error %v, want nil", err)
+			}
+			if delivery.Provider != tt.provider || delivery.DeliveryID != tt.delivery_id {
+				t.Errorf("got provider %s delivery %s, want %s %s", delivery.Provider, delivery.DeliveryID, tt.provider, tt.delivery_id)
+			}
+			if delivery.Push == nil {
+				t.Fatalf("got no push, want one")
+			}
+			if delivery.Push.Branch != "main" || delivery.Push.CommitSHA != test_sha || delivery.Push.Deleted {
+				t.Errorf("got push %+v, want main at %s", *delivery.Push, test_sha)
+			}
+		})
+
+		t.Run("should reject "+string(tt.provider)+" deliveries signed with another secret", func (t *testing.T) {
+			body := push_body("refs/heads/main", test_sha)
+
+			delivery, err := Parse(signed_header(tt.provider, tt.event, body, "other"), body, test_secret)
+			if err == nil || err.Error() != "invalid_signature" {
+				t.Errorf("got error %v, want invalid_signature", err)
+			}
+			if delivery == nil || delivery.Provider != tt.provider {
+				t.Errorf("got delivery %+v, want the provider kept for recording", delivery)
+			}
+		})
+	}
+
+	t.Run("should reject tampered bodies", func (t *testing.T) {
+		body := push_body("refs/heads/main", test_sha)
+		header := signed_header(ProviderGitHub, "push", body, test_secret)
+
+		_, err := Parse(header, push_body("refs/heads/evil", test_sha), test_secret)
+		if err == nil || err.Error() != "invalid_signature" {
+			t.Errorf("got error %v, want invalid_signature", err)
+		}
+	})
+
+	t.Run("should reject unsigned deliveries", func (t *testing.T) {
+		body := push_body("refs/heads/main", test_sha)
+		header := signed_header(ProviderGitHub, "push", body, test_secret)
+		header.Del("X-Hub-Signature-256")
+
+		_, err := Parse(header, body, test_secret)
+		if err == nil || err.Error() != "missing_signature" {
+			t.Errorf("got error %v, want missing_signature", err)
+		}
+	})
+
+	t.Run("should reject unknown providers", func (t *testing.T) {
+		_, err := Parse(http.Header{}, []byte("{}"), test_secret)
+		if err == nil || err.Error() != "unknown_provider" {
+			t.Errorf("got error %v, want unknown_provider", err)
+		}
+	})
+
+	t.Run("should not read a push out of other events", func (t *testing.T) {
+		body := []byte(`{"zen":"Keep it logically awesome."}`)
+
+		delivery, err := Parse(signed_header(ProviderGitHub, "ping", body, test_secret), body, test_secret)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if delivery.Event != "ping" || delivery.Push != nil {
+			t.Errorf("got delivery %+v, want a ping without push", delivery)
+		}
+	})
+
+	t.Run("should leave the branch empty for tag pushes", func (t *testing.T) {
+		body := push_body("refs/tags/v1", test_sha)
+
+		delivery, err := Parse(signed_header(ProviderGitHub, "push", body, test_secret), body, test_secret)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if delivery.Push.Branch != "" || delivery.Push.Ref != "refs/tags/v1" {
+			t.Errorf("got push %+v, want a tag without branch", *delivery.Push)
+		}
+	})
+
+	t.Run("should mark deleted branches", func (t *testing.T) {
+		body := push_body("refs/heads/main", strings.Repeat("0", 40))
+
+		delivery, err := Parse(signed_header(ProviderGitLab, "Push Hook", body, test_secret), body, test_secret)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if !delivery.Push.Deleted {
+			t.Errorf("got push %+v, want deleted", *delivery.Push)
+		}
+	})
+
+	t.Run("should reject pushes without a valid commit", func (t *testing.T) {
+		for _, body := range [][]byte{
+			push_body("refs/heads/main", "--upload-pack=touch"),
+			push_body("", test_sha),
+			[]byte("not json"),
+		} {
+			_, err := Parse(signed_header(ProviderGitHub, "push", body, test_secret), body, test_secret)
+			if err == nil || err.Error() != "invalid_payload" {
+				t.Errorf("got error %v for %s, want invalid_payload", err, body)
+			}
+		}
+	})
+}
+
+func TestGenerateSecret(t *testing.T) {
+	first, err := GenerateSecret()
+	if err != nil {
+		t.Fatalf("got error %v, want nil", err)
+	}
+	second, _ := GenerateSecret()
+
+	if len(first) != 64 || first == second {
+		t.Errorf("got secrets %s and %s, want distinct 64 character secrets", first, second)
+	}
+}
@@ -6,15 +6,20 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/joho/godotenv"
 	"github.com/salmanrf/capybara-cloud/api"
 	"github.com/salmanrf/capybara-cloud/internal/application"
+	"github.com/salmanrf/capybara-cloud/internal/artifacts"
 	"github.com/salmanrf/capybara-cloud/internal/auth"
 	"github.com/salmanrf/capybara-cloud/internal/database"
+	"github.com/salmanrf/capybara-cloud/internal/ingress"
 	"github.com/salmanrf/capybara-cloud/internal/organization"
 	"github.com/salmanrf/capybara-cloud/internal/project"
+	"github.com/salmanrf/capybara-cloud/internal/runtime"
 	"github.com/salmanrf/capybara-cloud/internal/user"
 	auth_utils "github.com/salmanrf/capybara-cloud/pkg/auth"
 )
@@ -54,6 +59,146 @@ func setup() (context.Context, *pgxpool.Pool, error) {
 	return ctx, dbpool, nil
 }
 
+func env_int(name string, fallback int) int {
+	if val, err := strconv.Atoi(os.Getenv(name)); err == nil {
+		return val
+	}
+
+	return fallback
+}
+
+func create_dependency_cache() *runtime.DependencyCache {
+	cache_dir := os.Getenv("BUILD_CACHE_DIR")
+	if cache_dir == "" {
+		cache_dir = "build-cache"
+	}
+	max_size := int64(env_int("BUILD_CACHE_MAX_SIZE_MB", 2048)) * 1024 * 1024
+	max_age := time.Duration(env_int("BUILD_CACHE_MAX_AGE_HOURS", 7*24)) * time.Hour
+
+	cache := runtime.NewDependencyCache(cache_dir, max_size, max_age)
+	if err := cache.Evict(); err != nil {
+		fmt.Println("Unable to evict build cache: ", err.Error())
+	}
+
+	return cache
+}
+
+// create_cgroups returns nil when CGROUP_ROOT isn't set, local deployments
+// then run without resource limits
+func create_cgroups() *runtime.Cgroups {
+	root := os.Getenv("CGROUP_ROOT")
+	if root == "" {
+		return nil
+	}
+
+	cgroups, err := runtime.NewCgroups(root)
+	if err != nil {
+		log.Fatalf("Unable to set up deployment cgroups: %v", err)
+	}
+
+	return cgroups
+}
+
+func create_artifact_store() artifacts.ArtifactStore {
+	var store artifacts.ArtifactStore
+	var err error
+
+	switch os.Getenv("ARTIFACT_STORE") {
+	case "s3":
+		store, err = artifacts.NewS3Store(artifacts.S3Config{
+			Endpoint: os.Getenv("S3_ENDPOINT"),
+			Region: os.Getenv("S3_REGION"),
+			Bucket: os.Getenv("S3_BUCKET"),
+			AccessKeyID: os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+		})
+	default:
+		store_dir := os.Getenv("ARTIFACT_STORE_DIR")
+		if store_dir == "" {
+			store_dir = "artifact-store"
+		}
+		store, err = artifacts.NewFileSystemStore(store_dir)
+	}
+	if err != nil {
+		log.Fatalf("Unable to create artifact store: %v", err)
+	}
+
+	return store
+}
+
+func create_runtime(ctx context.Context) runtime.Runtime {
+	stop_timeout := 10 * time.Second
+
+	if os.Getenv("RUNTIME_BACKEND") != "docker" {
+		state_dir := os.Getenv("RUNTIME_STATE_DIR")
+		if state_dir == "" {
+			state_dir = "runtime-state"
+		}
+		return runtime.NewLocalRuntime(ctx, stop_timeout, create_dependency_cache(), create_cgroups(), state_dir)
+	}
+
+	docker_socket := os.Getenv("DOCKER_SOCKET")
+	if docker_socket == "" {
+		docker_socket = "/var/run/docker.sock"
+	}
+	// DOCKER_BASE_IMAGE predates the other stacks, it is the Node.js image
+	base_images := map[string]string{}
+	for stack, key := range map[string]string{
+		runtime.StackNode: "DOCKER_BASE_IMAGE",
+		runtime.StackPython: "DOCKER_PYTHON_IMAGE",
+		runtime.StackGo: "DOCKER_GO_IMAGE",
+		runtime.StackProcfile: "DOCKER_PROCFILE_IMAGE",
+	} {
+		if image := os.Getenv(key); image != "" {
+			base_images[stack] = image
+		}
+	}
+
+	return runtime.NewDockerRuntime(ctx, docker_socket, base_images, stop_timeout)
+}
+
+func create_port_range() application.PortRange {
+	ports := application.DefaultPortRange()
+	ports.Start = int32(env_int("PORT_RANGE_START", int(ports.Start)))
+	ports.End = int32(env_int("PORT_RANGE_END", int(ports.End)))
+	if ports.Start < 1 || ports.End > 65535 || ports.Start > ports.End {
+		log.Fatalf("Invalid port range %d-%d", ports.Start, ports.End)
+	}
+
+	return ports
+}
+
+func create_queue_options() application.QueueOptions {
+	queue := application.DefaultQueueOptions()
+	queue.Workers = env_int("DEPLOY_WORKERS", queue.Workers)
+	queue.OrgConcurrency = int32(env_int("DEPLOY_ORG_CONCURRENCY", int(queue.OrgConcurrency)))
+	queue.AppConcurrency = int32(env_int("DEPLOY_APP_CONCURRENCY", int(queue.AppConcurrency)))
+	queue.MaxAttempts = int32(env_int("DEPLOY_MAX_ATTEMPTS", int(queue.MaxAttempts)))
+	if queue.Workers < 1 || queue.OrgConcurrency < 1 || queue.AppConcurrency < 1 || queue.MaxAttempts < 1 {
+		log.Fatalf("Invalid deployment queue options %+v", queue)
+	}
+
+	return queue
+}
+
+func create_reconcile_interval() time.Duration {
+	interval := env_int("RECONCILE_INTERVAL_SECONDS", 60)
+	if interval < 1 {
+		log.Fatalf("Invalid reconcile interval %d", interval)
+	}
+
+	return time.Duration(interval) * time.Second
+}
+
+func create_scheduler_interval() time.Duration {
+	interval := env_int("SCHEDULER_INTERVAL_SECONDS", 10)
+	if interval < 1 {
+		log.Fatalf("Invalid scheduler interval %d", interval)
+	}
+
+	return time.Duration(interval) * time.Second
+}
+
 func main() {
 	ctx, db_conn, err := setup()
 	defer db_conn.Close()
@@ -65,12 +210,54 @@ func main() {
 	}
 
 	queries := database.New(db_conn)
-	application_repository := application.NewRepository(ctx, queries)
+	application_repository := application.NewRepository(ctx, db_conn, queries)
 	user_service := user.NewService(ctx, queries)
 	auth_service := auth.NewService(ctx, user_service)
 	org_service := organization.NewService(ctx, db_conn, queries, user_service)
 	project_service := project.NewService(ctx, db_conn, queries, user_service)
-	application_service := application.NewService(ctx, db_conn, application_repository, project_service)
+	artifacts_dir := os.Getenv("ARTIFACTS_DIR")
+	if artifacts_dir == "" {
+		artifacts_dir = "artifacts"
+	}
+	deployment_runtime := create_runtime(ctx)
+	application_service := application.NewService(
+		ctx,
+		db_conn,
+		application_repository,
+		project_service,
+		artifacts_dir,
+		create_artifact_store(),
+		deployment_runtime,
+		create_port_range(),
+		runtime.ExtractLimits{
+			MaxFiles: int64(env_int("BUNDLE_MAX_FILES", 0)),
+			MaxTotalSize: int64(env_int("BUNDLE_MAX_SIZE_MB", 0)) * 1024 * 1024,
+			MaxCompressionRatio: int64(env_int("BUNDLE_MAX_COMPRESSION_RATIO", 0)),
+			MaxUploadSize: int64(env_int("BUNDLE_MAX_UPLOAD_MB", 0)) * 1024 * 1024,
+		},
+		create_queue_options(),
+	)
+	go application_service.RunDeploymentWorkers(ctx)
+	go application_service.RunReconciler(ctx, create_reconcile_interval())
+	go application_service.RunScheduler(ctx, create_scheduler_interval())
+
+	ingress_domain := os.Getenv("INGRESS_DOMAIN")
+	if ingress_domain == "" {
+		ingress_domain = "apps.localhost"
+	}
+	ingress_address := os.Getenv("INGRESS_ADDR")
+	if ingress_address == "" {
+		ingress_address = ":8081"
+	}
+	ingress_table := ingress.NewTable(application_service, ingress_domain)
+	go ingress_table.Run(ctx, 30*time.Second)
+	go func() {
+		fmt.Printf("Starting ingress on %s for *.%s\n", ingress_address, ingress_domain)
+		if err := http.ListenAndServe(ingress_address, ingress.NewServer(ingress_table)); err != nil {
+			fmt.Println("Ingress is stopped: ", err.Error())
+		}
+	}()
+
 	jwt_utils := auth_utils.NewJWTUtils(os.Getenv("AUTH_JWT_SECRET"))
 	
 	api_server := api.NewAPIServer(
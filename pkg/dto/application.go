@@ -4,12 +4,25 @@ import (
 	"errors"
 	"fmt"
 	"slices"
+	"strings"
 	"time"
+
+	"github.com/salmanrf/capybara-cloud/pkg/utils"
+)
+
+const (
+	AppTypeWebAppContainer = "web_app_container"
+	AppTypeBackgroundWorker = "background_worker"
+	AppTypeCronJob = "cron_job"
+	AppTypeStaticSite = "static_site"
 )
 
 func GetSupportedAppTypes() []string {
 	return []string{
-		"web_app_container",
+		AppTypeWebAppContainer,
+		AppTypeBackgroundWorker,
+		AppTypeCronJob,
+		AppTypeStaticSite,
 	}
 }
 
@@ -17,16 +30,52 @@ type CreateApplicationDto struct {
 	ProjectID string `json:"project_id"`
 	Type string `json:"type"`
 	Name string `json:"name"`
+	// only cron jobs take a schedule, it can also be set later
+	Schedule *UpdateApplicationScheduleDto `json:"schedule"`
 }
 
 type CreateApplicationConfigDto struct {
 	Variables map[string]any `json:"variables"`
+	// builds as this stack instead of the detected one when set
+	Stack string `json:"stack"`
 }
 
 type UpdateApplicationDto struct {
 	Name string `json:"name"`
 }
 
+func GetSupportedStacks() []string {
+	return []string{
+		"node",
+		"python",
+		"go",
+		"procfile",
+	}
+}
+
+func GetSupportedHealthCheckTypes() []string {
+	return []string{
+		"tcp",
+		"http",
+		"none",
+	}
+}
+
+type UpdateApplicationHealthCheckDto struct {
+	Type string `json:"type"`
+	Path string `json:"path"`
+	IntervalSeconds int32 `json:"interval_seconds"`
+	TimeoutSeconds int32 `json:"timeout_seconds"`
+	FailureThreshold int32 `json:"failure_threshold"`
+}
+
+// UpdateApplicationResourcesDto limits what each deployment of the
+// application may use, zero leaves a resource unlimited.
+type UpdateApplicationResourcesDto struct {
+	CPUMillis int32 `json:"cpu_millis"`
+	MemoryMB int32 `json:"memory_mb"`
+}
+
 type ListMyApplicationEntryApplication struct {
 	AppID string `json:"app_id"`
 	ProjectID string `json:"project_id"`
@@ -43,6 +92,7 @@ type ApplicationConfigResponse struct {
 	AppID string `json:"app_id"`
 	VariablesJson string `json:"variables_json"`
 	ConfigVariables map[string]any `json:"config_variables"`
+	Stack string `json:"stack,omitempty"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
@@ -61,9 +111,27 @@ func (dto *CreateApplicationDto) Validate() (bool, error) {
 		validation_errors = errors.Join(validation_errors, errors.New("app name must have 5 to 100 characters"))
 	}
 
-	if !slices.Contains(GetSupportedAppTypes(), dto.Type) {
+	if utils.Slug(dto.Name) == "" {
 		valid = false
-		validation_errors = errors.Join(validation_errors, errors.New("application type not supported"))
+		validation_errors = errors.Join(validation_errors, errors.New("app name must have a letter or digit"))
+	}
+
+	switch dto.Type {
+	case AppTypeCronJob:
+		if dto.Schedule != nil {
+			if _, err := dto.Schedule.Validate(); err != nil {
+				valid = false
+				validation_errors = errors.Join(validation_errors, err)
+			}
+		}
+	case AppTypeWebAppContainer, AppTypeBackgroundWorker, AppTypeStaticSite:
+		if dto.Schedule != nil {
+			valid = false
+			validation_errors = errors.Join(validation_errors, fmt.Errorf("only %s applications take a schedule", AppTypeCronJob))
+		}
+	default:
+		valid = false
+		validation_errors = errors.Join(validation_errors, fmt.Errorf("application type not supported, must be one of %s", strings.Join(GetSupportedAppTypes(), ", ")))
 	}
 
 	return valid, validation_errors
@@ -78,6 +146,11 @@ func (dto *UpdateApplicationDto) Validate() (bool, error) {
 		validation_errors = errors.Join(validation_errors, errors.New("project name must have 5 to 100 characters"))
 	}
 
+	if utils.Slug(dto.Name) == "" {
+		valid = false
+		validation_errors = errors.Join(validation_errors, errors.New("app name must have a letter or digit"))
+	}
+
 	return valid, validation_errors
 } 
 
@@ -98,14 +171,74 @@ func (dto *CreateApplicationConfigDto) Validate() (bool, error) {
 			valid = false
 		}
 	}
-	if keyc == 0 {
+	// a config may only pin the stack
+	if keyc == 0 && dto.Stack == "" {
 		validation_errors = errors.Join(
 			validation_errors, 
 			errors.New("config variables can't be an empty map"),
 		)
 		valid = false
 	}
+	if dto.Stack != "" && !slices.Contains(GetSupportedStacks(), dto.Stack) {
+		validation_errors = errors.Join(
+			validation_errors,
+			fmt.Errorf("stack not supported, must be one of %s", strings.Join(GetSupportedStacks(), ", ")),
+		)
+		valid = false
+	}
 	
 	return valid, validation_errors
 } 
 
+
+func (dto *UpdateApplicationHealthCheckDto) Validate() (bool, error) {
+	valid := true
+	var validation_errors error = nil
+
+	if !slices.Contains(GetSupportedHealthCheckTypes(), dto.Type) {
+		valid = false
+		validation_errors = errors.Join(validation_errors, errors.New("health check type must be tcp, http or none"))
+	}
+
+	if dto.Path == "" {
+		dto.Path = "/"
+	}
+	if !strings.HasPrefix(dto.Path, "/") || len(dto.Path) > 255 {
+		valid = false
+		validation_errors = errors.Join(validation_errors, errors.New("health check path must start with / and have at most 255 characters"))
+	}
+
+	if dto.IntervalSeconds < 1 || dto.IntervalSeconds > 3600 {
+		valid = false
+		validation_errors = errors.Join(validation_errors, errors.New("interval_seconds must be between 1 and 3600"))
+	}
+
+	if dto.TimeoutSeconds < 1 || dto.TimeoutSeconds > dto.IntervalSeconds {
+		valid = false
+		validation_errors = errors.Join(validation_errors, errors.New("timeout_seconds must be between 1 and interval_seconds"))
+	}
+
+	if dto.FailureThreshold < 1 || dto.FailureThreshold > 100 {
+		valid = false
+		validation_errors = errors.Join(validation_errors, errors.New("failure_threshold must be between 1 and 100"))
+	}
+
+	return valid, validation_errors
+}
+
+func (dto *UpdateApplicationResourcesDto) Validate() (bool, error) {
+	valid := true
+	var validation_errors error = nil
+
+	if dto.CPUMillis != 0 && (dto.CPUMillis < 10 || dto.CPUMillis > 256000) {
+		valid = false
+		validation_errors = errors.Join(validation_errors, errors.New("cpu_millis must be 0 for unlimited or between 10 and 256000"))
+	}
+
+	if dto.MemoryMB != 0 && (dto.MemoryMB < 16 || dto.MemoryMB > 1048576) {
+		valid = false
+		validation_errors = errors.Join(validation_errors, errors.New("memory_mb must be 0 for unlimited or between 16 and 1048576"))
+	}
+
+	return valid, validation_errors
+}
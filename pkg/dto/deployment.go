@@ -0,0 +1,450 @@
+package dto
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/salmanrf/capybara-cloud/internal/database"
+)
+
+func GetSupportedBundleExtensions() []string {
+	return []string{
+		".tar.gz",
+		".tgz",
+		".tar",
+		".zip",
+	}
+}
+
+// GetSupportedGitSchemes lists the schemes tenants may deploy from. file
+// reads the server's own disk and http and git aren't authenticated, operators
+// opt into them with GIT_ALLOW_FILE_URLS and GIT_ALLOW_INSECURE_URLS.
+func GetSupportedGitSchemes() []string {
+	schemes := []string{
+		"https",
+		"ssh",
+	}
+	if os.Getenv("GIT_ALLOW_INSECURE_URLS") == "true" {
+		schemes = append(schemes, "http", "git")
+	}
+	if os.Getenv("GIT_ALLOW_FILE_URLS") == "true" {
+		schemes = append(schemes, "file")
+	}
+
+	return schemes
+}
+
+// CreateApplicationDeploymentDto deploys either an uploaded bundle or a git
+// repository at GitRef, a branch, tag or full commit SHA. With CanaryWeight
+// set the deployment only gets that percentage of the traffic until it is
+// promoted.
+type CreateApplicationDeploymentDto struct {
+	BundleName string `json:"-"`
+	BundleSize int64 `json:"-"`
+	Bundle io.Reader `json:"-"`
+	GitURL string `json:"git_url"`
+	GitRef string `json:"git_ref"`
+	CanaryWeight *int32 `json:"canary_weight"`
+	CanarySticky bool `json:"canary_sticky"`
+}
+
+// UpdateApplicationDeploymentCanaryDto changes the share of traffic a canary
+// gets, zero pauses it without aborting.
+type UpdateApplicationDeploymentCanaryDto struct {
+	CanaryWeight int32 `json:"canary_weight"`
+	CanarySticky bool `json:"canary_sticky"`
+}
+
+type ApplicationDeploymentResponse struct {
+	AppDpID string `json:"app_dp_id"`
+	AppID string `json:"app_id"`
+	ArtifactsPath string `json:"artifacts_path"`
+	BundleKey string `json:"bundle_key,omitempty"`
+	BundleSha256 string `json:"bundle_sha256,omitempty"`
+	BundleSize int64 `json:"bundle_size,omitempty"`
+	GitURL string `json:"git_url,omitempty"`
+	GitRef string `json:"git_ref,omitempty"`
+	GitCommitSha string `json:"git_commit_sha,omitempty"`
+	Stack string `json:"stack,omitempty"`
+	VariablesSnapshot map[string]any `json:"variables_snapshot"`
+	Status string `json:"status"`
+	FailureReason string `json:"failure_reason,omitempty"`
+	StatusUpdatedAt time.Time `json:"status_updated_at"`
+	RolledBackFrom string `json:"rolled_back_from,omitempty"`
+	HealthStatus string `json:"health_status"`
+	HealthMessage string `json:"health_message,omitempty"`
+	HealthCheckedAt *time.Time `json:"health_checked_at"`
+	HealthConsecutiveFailures int32 `json:"health_consecutive_failures"`
+	RestartCount int32 `json:"restart_count"`
+	LastRestartedAt *time.Time `json:"last_restarted_at"`
+	CanaryWeight *int32 `json:"canary_weight"`
+	CanarySticky bool `json:"canary_sticky"`
+	CanaryTraffic *CanaryTrafficResponse `json:"canary_traffic,omitempty"`
+	Processes []ApplicationDeploymentProcessResponse `json:"processes,omitempty"`
+	Transitions []ApplicationDeploymentTransitionResponse `json:"transitions,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// CanaryTrafficResponse compares the requests served by a canary with the
+// ones the stable deployment served meanwhile.
+type CanaryTrafficResponse struct {
+	CanaryRequests int64 `json:"canary_requests"`
+	CanaryErrors int64 `json:"canary_errors"`
+	CanaryErrorRate float64 `json:"canary_error_rate"`
+	StableRequests int64 `json:"stable_requests"`
+	StableErrors int64 `json:"stable_errors"`
+	StableErrorRate float64 `json:"stable_error_rate"`
+}
+
+// ApplicationDeploymentProcessResponse is an instance of one of the process
+// types the deployment runs, or ran once like its release command.
+type ApplicationDeploymentProcessResponse struct {
+	ProcessType string `json:"process_type"`
+	InstanceIndex int32 `json:"instance_index"`
+	ProcessName string `json:"process_name"`
+	ContainerName string `json:"container_name"`
+	Status string `json:"status"`
+	ExitCode *int32 `json:"exit_code"`
+	RestartCount int32 `json:"restart_count"`
+	StartedAt *time.Time `json:"started_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type ApplicationDeploymentTransitionResponse struct {
+	FromStatus string `json:"from_status"`
+	ToStatus string `json:"to_status"`
+	Reason string `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+var git_scp_url_regex = regexp.MustCompile(`^[A-Za-z0-9._-]+@[A-Za-z0-9.-]+:[^\s]+$`)
+var git_ref_regex = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._/-]*$`)
+
+// valid_git_url accepts URLs with a supported scheme and scp-like
+// user@host:path addresses, neither can be mistaken for a git option.
+func valid_git_url(git_url string) bool {
+	if git_scp_url_regex.MatchString(git_url) {
+		return true
+	}
+
+	parsed, err := url.Parse(git_url)
+	if err != nil || parsed.Path == "" {
+		return false
+	}
+	if parsed.Scheme != "file" && parsed.Host == "" {
+		return false
+	}
+
+	return slices.Contains(GetSupportedGitSchemes(), parsed.Scheme)
+}
+
+func valid_git_ref(git_ref string) bool {
+	return git_ref_regex.MatchString(git_ref) &&
+		!strings.Contains(git_ref, "..") &&
+		!strings.HasSuffix(git_ref, "/") &&
+		!strings.HasSuffix(git_ref, ".lock")
+}
+
+func valid_canary_weight(weight int32) bool {
+	return weight >= 0 && weight <= 100
+}
+
+func (dto *CreateApplicationDeploymentDto) Validate() (bool, error) {
+	valid := true
+	var validation_errors error = nil
+
+	if dto.CanaryWeight != nil && !valid_canary_weight(*dto.CanaryWeight) {
+		valid = false
+		validation_errors = errors.Join(validation_errors, errors.New("canary_weight must be between 0 and 100"))
+	}
+
+	if dto.CanarySticky && dto.CanaryWeight == nil {
+		valid = false
+		validation_errors = errors.Join(validation_errors, errors.New("canary_sticky requires canary_weight"))
+	}
+
+	if dto.GitURL != "" || dto.GitRef != "" {
+		if dto.Bundle != nil {
+			valid = false
+			validation_errors = errors.Join(validation_errors, errors.New("deploy either a bundle or a git repository, not both"))
+		}
+
+		if !valid_git_url(dto.GitURL) {
+			valid = false
+			validation_errors = errors.Join(validation_errors, fmt.Errorf("git_url must be a %s URL or user@host:path", strings.Join(GetSupportedGitSchemes(), ", ")))
+		}
+
+		if len(dto.GitURL) > 500 {
+			valid = false
+			validation_errors = errors.Join(validation_errors, errors.New("git_url must be at most 500 characters"))
+		}
+
+		if dto.GitRef != "" && (len(dto.GitRef) > 255 || !valid_git_ref(dto.GitRef)) {
+			valid = false
+			validation_errors = errors.Join(validation_errors, errors.New("git_ref must be a branch, tag or commit SHA"))
+		}
+
+		return valid, validation_errors
+	}
+
+	if dto.Bundle == nil || dto.BundleSize <= 0 {
+		valid = false
+		validation_errors = errors.Join(validation_errors, errors.New("deployment bundle is required"))
+	}
+
+	supported := false
+	for _, ext := range GetSupportedBundleExtensions() {
+		if strings.HasSuffix(strings.ToLower(dto.BundleName), ext) {
+			supported = true
+		}
+	}
+	if !supported {
+		valid = false
+		validation_errors = errors.Join(validation_errors, errors.New("deployment bundle must be a .zip, .tar or .tar.gz archive"))
+	}
+
+	return valid, validation_errors
+}
+
+func (dto *UpdateApplicationDeploymentCanaryDto) Validate() (bool, error) {
+	valid := true
+	var validation_errors error = nil
+
+	if !valid_canary_weight(dto.CanaryWeight) {
+		valid = false
+		validation_errors = errors.Join(validation_errors, errors.New("canary_weight must be between 0 and 100"))
+	}
+
+	return valid, validation_errors
+}
+
+func optional_time(timestamp pgtype.Timestamp) *time.Time {
+	if !timestamp.Valid {
+		return nil
+	}
+
+	return &timestamp.Time
+}
+
+func error_rate(failed int64, requests int64) float64 {
+	if requests == 0 {
+		return 0
+	}
+
+	return float64(failed) / float64(requests)
+}
+
+func NewApplicationDeploymentResponse(row database.ApplicationDeployment) *ApplicationDeploymentResponse {
+	variables := make(map[string]any)
+	if len(row.VariablesSnapshotJson) > 0 {
+		json.Unmarshal(row.VariablesSnapshotJson, &variables)
+	}
+
+	rolled_back_from := ""
+	if row.RolledBackFrom.Valid {
+		rolled_back_from = row.RolledBackFrom.String()
+	}
+
+	var canary_weight *int32
+	if row.CanaryWeight.Valid {
+		canary_weight = &row.CanaryWeight.Int32
+	}
+
+	var canary_traffic *CanaryTrafficResponse
+	if row.CanaryRequests > 0 || row.StableRequests > 0 {
+		canary_traffic = &CanaryTrafficResponse{
+			CanaryRequests: row.CanaryRequests,
+			CanaryErrors: row.CanaryErrors,
+			CanaryErrorRate: error_rate(row.CanaryErrors, row.CanaryRequests),
+			StableRequests: row.StableRequests,
+			StableErrors: row.StableErrors,
+			StableErrorRate: error_rate(row.StableErrors, row.StableRequests),
+		}
+	}
+
+	return &ApplicationDeploymentResponse{
+		AppDpID: row.AppDpID.String(),
+		AppID: row.AppID.String(),
+		ArtifactsPath: row.ArtifactsPath,
+		BundleKey: row.BundleKey.String,
+		BundleSha256: row.BundleSha256.String,
+		BundleSize: row.BundleSize.Int64,
+		GitURL: row.GitUrl.String,
+		GitRef: row.GitRef.String,
+		GitCommitSha: row.GitCommitSha.String,
+		Stack: row.Stack.String,
+		VariablesSnapshot: variables,
+		Status: row.Status,
+		FailureReason: row.FailureReason.String,
+		StatusUpdatedAt: row.StatusUpdatedAt.Time,
+		RolledBackFrom: rolled_back_from,
+		HealthStatus: row.HealthStatus,
+		HealthMessage: row.HealthMessage.String,
+		HealthCheckedAt: optional_time(row.HealthCheckedAt),
+		HealthConsecutiveFailures: row.HealthConsecutiveFailures,
+		RestartCount: row.RestartCount,
+		LastRestartedAt: optional_time(row.LastRestartedAt),
+		CanaryWeight: canary_weight,
+		CanarySticky: row.CanarySticky,
+		CanaryTraffic: canary_traffic,
+		CreatedAt: row.CreatedAt.Time,
+		UpdatedAt: row.UpdatedAt.Time,
+	}
+}
+
+func NewListApplicationDeploymentResponse(rows []database.ApplicationDeployment) []ApplicationDeploymentResponse {
+	formatted := make([]ApplicationDeploymentResponse, len(rows))
+
+	for i, row := range rows {
+		formatted[i] = *NewApplicationDeploymentResponse(row)
+	}
+
+	return formatted
+}
+
+
+func NewApplicationDeploymentDetailResponse(row database.ApplicationDeployment, transitions []database.ApplicationDeploymentTransition, processes []database.ApplicationDeploymentProcess) *ApplicationDeploymentResponse {
+	response := NewApplicationDeploymentResponse(row)
+	response.Processes = make([]ApplicationDeploymentProcessResponse, len(processes))
+	response.Transitions = make([]ApplicationDeploymentTransitionResponse, len(transitions))
+
+	for i, process := range processes {
+		var exit_code *int32
+		if process.ExitCode.Valid {
+			exit_code = &process.ExitCode.Int32
+		}
+
+		response.Processes[i] = ApplicationDeploymentProcessResponse{
+			ProcessType: process.ProcessType,
+			InstanceIndex: process.InstanceIndex,
+			ProcessName: process.ProcessName,
+			ContainerName: process.ContainerName,
+			Status: process.Status,
+			ExitCode: exit_code,
+			RestartCount: process.RestartCount,
+			StartedAt: optional_time(process.StartedAt),
+			UpdatedAt: process.UpdatedAt.Time,
+		}
+	}
+
+	for i, transition := range transitions {
+		response.Transitions[i] = ApplicationDeploymentTransitionResponse{
+			FromStatus: transition.FromStatus.String,
+			ToStatus: transition.ToStatus,
+			Reason: transition.Reason.String,
+			CreatedAt: transition.CreatedAt.Time,
+		}
+	}
+
+	return response
+}
+const (
+	DefaultDeploymentLogsLimit = 200
+	MaxDeploymentLogsLimit = 1000
+)
+
+// FindApplicationDeploymentLogsDto pages through stored log lines, After is
+// the id of the last line already seen. Process only keeps the lines of one
+// process type.
+type FindApplicationDeploymentLogsDto struct {
+	After int64
+	Limit int32
+	Process string
+}
+
+type ApplicationDeploymentLogResponse struct {
+	ID int64 `json:"id"`
+	Phase string `json:"phase"`
+	Stream string `json:"stream"`
+	Process string `json:"process,omitempty"`
+	Line string `json:"line"`
+	LoggedAt time.Time `json:"logged_at"`
+}
+
+type ApplicationDeploymentLogsResponse struct {
+	Logs []ApplicationDeploymentLogResponse `json:"logs"`
+	NextAfter int64 `json:"next_after"`
+}
+
+func (dto *FindApplicationDeploymentLogsDto) Validate() (bool, error) {
+	valid := true
+	var validation_errors error = nil
+
+	if dto.After < 0 {
+		valid = false
+		validation_errors = errors.Join(validation_errors, errors.New("after must not be negative"))
+	}
+
+	if dto.Limit < 1 || dto.Limit > MaxDeploymentLogsLimit {
+		valid = false
+		validation_errors = errors.Join(validation_errors, fmt.Errorf("limit must be between 1 and %d", MaxDeploymentLogsLimit))
+	}
+
+	if dto.Process != "" && !valid_process_type(dto.Process) {
+		valid = false
+		validation_errors = errors.Join(validation_errors, errors.New("process must be a process type of letters, digits, _ and -"))
+	}
+
+	return valid, validation_errors
+}
+
+func NewApplicationDeploymentLogResponse(row database.ApplicationDeploymentLog) *ApplicationDeploymentLogResponse {
+	return &ApplicationDeploymentLogResponse{
+		ID: row.AppDpLogID,
+		Phase: row.Phase,
+		Stream: row.Stream,
+		Process: row.ProcessType.String,
+		Line: row.Line,
+		LoggedAt: row.LoggedAt.Time,
+	}
+}
+
+// NewApplicationDeploymentLogsResponse carries the cursor for the next page,
+// it stays at after when there is nothing new yet.
+func NewApplicationDeploymentLogsResponse(rows []database.ApplicationDeploymentLog, after int64) *ApplicationDeploymentLogsResponse {
+	response := &ApplicationDeploymentLogsResponse{
+		Logs: make([]ApplicationDeploymentLogResponse, len(rows)),
+		NextAfter: after,
+	}
+
+	for i, row := range rows {
+		response.Logs[i] = *NewApplicationDeploymentLogResponse(row)
+		response.NextAfter = row.AppDpLogID
+	}
+
+	return response
+}
+
+// ApplicationControlResponse answers stop, start and restart requests with
+// the recorded desired state and the deployment that was acted on, if any.
+type ApplicationControlResponse struct {
+	AppID string `json:"app_id"`
+	DesiredState string `json:"desired_state"`
+	DesiredStateUpdatedBy string `json:"desired_state_updated_by"`
+	DesiredStateUpdatedAt time.Time `json:"desired_state_updated_at"`
+	Deployment *ApplicationDeploymentResponse `json:"deployment"`
+}
+
+func NewApplicationControlResponse(app *database.Application, deployment *database.ApplicationDeployment) *ApplicationControlResponse {
+	response := &ApplicationControlResponse{
+		AppID: app.AppID.String(),
+		DesiredState: app.DesiredState,
+		DesiredStateUpdatedBy: app.DesiredStateUpdatedBy.String(),
+		DesiredStateUpdatedAt: app.DesiredStateUpdatedAt.Time,
+	}
+	if deployment != nil {
+		response.Deployment = NewApplicationDeploymentResponse(*deployment)
+	}
+
+	return response
+}
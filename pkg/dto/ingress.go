@@ -0,0 +1,41 @@
+package dto
+
+// IngressRoute points an application at the host port its serving
+// deployment listens on. It is reachable under the slugs of the application
+// and its project, which no other application shares. Ports lists Port and those of the deployment's other
+// running web instances when it is scaled, requests are balanced across them.
+// Static sites have no port, the ingress serves the files under StaticRoot
+// itself.
+type IngressRoute struct {
+	AppID string
+	AppName string
+	ProjectName string
+	AppSlug string
+	ProjectSlug string
+	DeploymentID string
+	Port string
+	Ports []string
+	StaticRoot string
+	Canary *IngressCanary
+}
+
+// IngressCanary is a deployment receiving Weight percent of the requests of
+// its application next to the stable one. Sticky clients keep the deployment
+// they got first.
+type IngressCanary struct {
+	DeploymentID string
+	Port string
+	Ports []string
+	Weight int32
+	Sticky bool
+}
+
+// CanaryTraffic counts the requests the ingress split between a canary and
+// the stable deployment since it last reported, errors are 5xx responses.
+type CanaryTraffic struct {
+	DeploymentID string
+	CanaryRequests int64
+	CanaryErrors int64
+	StableRequests int64
+	StableErrors int64
+}
@@ -0,0 +1,131 @@
+package dto
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/salmanrf/capybara-cloud/internal/cron"
+	"github.com/salmanrf/capybara-cloud/internal/database"
+)
+
+const (
+	DefaultJobRunsLimit = 50
+	MaxJobRunCommandLength = 4096
+)
+
+// UpdateApplicationScheduleDto configures when a cron_job application runs
+// and what. Runs starting while the previous one still runs are skipped
+// unless AllowOverlap is set.
+type UpdateApplicationScheduleDto struct {
+	CronExpression string `json:"cron_expression"`
+	Command string `json:"command"`
+	AllowOverlap bool `json:"allow_overlap"`
+}
+
+type ApplicationScheduleResponse struct {
+	AppID string `json:"app_id"`
+	CronExpression string `json:"cron_expression"`
+	Command string `json:"command"`
+	AllowOverlap bool `json:"allow_overlap"`
+	NextRunAt *time.Time `json:"next_run_at"`
+}
+
+type ApplicationJobRunResponse struct {
+	ID string `json:"id"`
+	AppID string `json:"app_id"`
+	AppDpID string `json:"app_dp_id,omitempty"`
+	Status string `json:"status"`
+	Command string `json:"command"`
+	ScheduledAt time.Time `json:"scheduled_at"`
+	StartedAt *time.Time `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+	DurationMs *int64 `json:"duration_ms"`
+	ExitCode *int32 `json:"exit_code"`
+	FailureReason string `json:"failure_reason,omitempty"`
+}
+
+func (dto *UpdateApplicationScheduleDto) Validate() (bool, error) {
+	valid := true
+	var validation_errors error = nil
+
+	if _, err := cron.Parse(dto.CronExpression); err != nil {
+		valid = false
+		validation_errors = errors.Join(validation_errors, fmt.Errorf("invalid cron_expression: %w", err))
+	}
+
+	dto.Command = strings.TrimSpace(dto.Command)
+	if dto.Command == "" || len(dto.Command) > MaxJobRunCommandLength {
+		valid = false
+		validation_errors = errors.Join(validation_errors, fmt.Errorf("command must have 1 to %d characters", MaxJobRunCommandLength))
+	}
+
+	return valid, validation_errors
+}
+
+func NewApplicationScheduleResponse(app database.Application) *ApplicationScheduleResponse {
+	return &ApplicationScheduleResponse{
+		AppID: app.AppID.String(),
+		CronExpression: app.CronExpression.String,
+		Command: app.CronCommand.String,
+		AllowOverlap: app.CronAllowOverlap,
+		NextRunAt: optional_time(app.CronNextRunAt),
+	}
+}
+
+func NewApplicationJobRunResponse(row database.ApplicationJobRun) *ApplicationJobRunResponse {
+	response := &ApplicationJobRunResponse{
+		ID: row.AppRunID.String(),
+		AppID: row.AppID.String(),
+		Status: row.Status,
+		Command: row.Command,
+		ScheduledAt: row.ScheduledAt.Time,
+		StartedAt: optional_time(row.StartedAt),
+		FinishedAt: optional_time(row.FinishedAt),
+		FailureReason: row.FailureReason.String,
+	}
+	if row.AppDpID.Valid {
+		response.AppDpID = row.AppDpID.String()
+	}
+	if row.DurationMs.Valid {
+		response.DurationMs = &row.DurationMs.Int64
+	}
+	if row.ExitCode.Valid {
+		response.ExitCode = &row.ExitCode.Int32
+	}
+
+	return response
+}
+
+func NewListApplicationJobRunResponse(rows []database.ApplicationJobRun) []ApplicationJobRunResponse {
+	formatted := make([]ApplicationJobRunResponse, len(rows))
+
+	for i, row := range rows {
+		formatted[i] = *NewApplicationJobRunResponse(row)
+	}
+
+	return formatted
+}
+
+// NewApplicationJobRunLogsResponse pages run output like deployment logs,
+// every line of a run is from the run phase.
+func NewApplicationJobRunLogsResponse(rows []database.ApplicationJobRunLog, after int64) *ApplicationDeploymentLogsResponse {
+	response := &ApplicationDeploymentLogsResponse{
+		Logs: make([]ApplicationDeploymentLogResponse, len(rows)),
+		NextAfter: after,
+	}
+
+	for i, row := range rows {
+		response.Logs[i] = ApplicationDeploymentLogResponse{
+			ID: row.AppRunLogID,
+			Phase: "run",
+			Stream: row.Stream,
+			Line: row.Line,
+			LoggedAt: row.LoggedAt.Time,
+		}
+		response.NextAfter = row.AppRunLogID
+	}
+
+	return response
+}
@@ -0,0 +1,82 @@
+package dto
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+
+	"github.com/salmanrf/capybara-cloud/internal/database"
+)
+
+const MaxProcessScale = 10
+
+var process_type_regex = regexp.MustCompile(`^[A-Za-z0-9_-]{1,63}$`)
+
+func valid_process_type(process_type string) bool {
+	return process_type_regex.MatchString(process_type)
+}
+
+// UpdateApplicationScaleDto sets how many instances of its Procfile process
+// types the application runs. The web process runs at least one, the ingress
+// balances requests across its instances, and release runs once per
+// deployment.
+type UpdateApplicationScaleDto struct {
+	Processes map[string]int32 `json:"processes"`
+}
+
+type ApplicationProcessScaleResponse struct {
+	ProcessType string `json:"process_type"`
+	Scale int32 `json:"scale"`
+}
+
+func (dto *UpdateApplicationScaleDto) Validate() (bool, error) {
+	valid := true
+	var validation_errors error = nil
+
+	if len(dto.Processes) == 0 {
+		valid = false
+		validation_errors = errors.Join(validation_errors, errors.New("processes must scale at least one process type"))
+	}
+
+	for _, process_type := range slices.Sorted(maps.Keys(dto.Processes)) {
+		if !valid_process_type(process_type) {
+			valid = false
+			validation_errors = errors.Join(validation_errors, fmt.Errorf("process type %q must have up to 63 letters, digits, _ and -", process_type))
+			continue
+		}
+
+		if process_type == "release" {
+			valid = false
+			validation_errors = errors.Join(validation_errors, fmt.Errorf("process type %q can't be scaled", process_type))
+			continue
+		}
+
+		if scale := dto.Processes[process_type]; process_type == "web" && (scale < 1 || scale > MaxProcessScale) {
+			valid = false
+			validation_errors = errors.Join(validation_errors, fmt.Errorf("scale of %q must be between 1 and %d", process_type, MaxProcessScale))
+			continue
+		}
+
+		if scale := dto.Processes[process_type]; scale < 0 || scale > MaxProcessScale {
+			valid = false
+			validation_errors = errors.Join(validation_errors, fmt.Errorf("scale of %q must be between 0 and %d", process_type, MaxProcessScale))
+		}
+	}
+
+	return valid, validation_errors
+}
+
+func NewListApplicationProcessScaleResponse(rows []database.ApplicationProcessScale) []ApplicationProcessScaleResponse {
+	formatted := make([]ApplicationProcessScaleResponse, len(rows))
+
+	for i, row := range rows {
+		formatted[i] = ApplicationProcessScaleResponse{
+			ProcessType: row.ProcessType,
+			Scale: row.Scale,
+		}
+	}
+
+	return formatted
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 
 	"github.com/salmanrf/capybara-cloud/internal/database"
+	"github.com/salmanrf/capybara-cloud/pkg/utils"
 )
 
 type CreateProjectDto struct {
@@ -44,6 +45,11 @@ func (dto *CreateProjectDto) Validate() (bool, error) {
 		validation_errors = errors.Join(validation_errors, errors.New("project name must have 5 to 100 characters"))
 	}
 
+	if utils.Slug(dto.Name) == "" {
+		valid = false
+		validation_errors = errors.Join(validation_errors, errors.New("project name must have a letter or digit"))
+	}
+
 	return valid, validation_errors
 }
 
@@ -56,6 +62,11 @@ func (dto *UpdateProjectDto) Validate() (bool, error) {
 		validation_errors = errors.Join(validation_errors, errors.New("project name must have 5 to 100 characters"))
 	}
 
+	if utils.Slug(dto.Name) == "" {
+		valid = false
+		validation_errors = errors.Join(validation_errors, errors.New("project name must have a letter or digit"))
+	}
+
 	return valid, validation_errors
 } 
 
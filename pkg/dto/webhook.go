@@ -0,0 +1,125 @@
+package dto
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/salmanrf/capybara-cloud/internal/database"
+)
+
+const (
+	WebhookDeliveryDeployed = "deployed"
+	WebhookDeliveryIgnored = "ignored"
+	WebhookDeliveryRejected = "rejected"
+	WebhookDeliveryFailed = "failed"
+)
+
+const (
+	DefaultWebhookDeliveriesLimit = 50
+	MaxWebhookPayloadSize = 25 << 20
+)
+
+// UpdateApplicationWebhookDto configures which repository and branch pushes
+// deploy from. The secret is generated on creation and only replaced when
+// RotateSecret is set.
+type UpdateApplicationWebhookDto struct {
+	GitURL string `json:"git_url"`
+	Branch string `json:"branch"`
+	RotateSecret bool `json:"rotate_secret"`
+}
+
+// ReceiveApplicationWebhookDto is an inbound delivery as the git host sent
+// it, the body is kept raw for signature verification.
+type ReceiveApplicationWebhookDto struct {
+	Header http.Header
+	Body []byte
+}
+
+type ApplicationWebhookResponse struct {
+	AppID string `json:"app_id"`
+	GitURL string `json:"git_url"`
+	Branch string `json:"branch"`
+	Secret string `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type ApplicationWebhookDeliveryResponse struct {
+	ID string `json:"id"`
+	Provider string `json:"provider"`
+	Event string `json:"event"`
+	DeliveryID string `json:"delivery_id"`
+	Ref string `json:"ref"`
+	CommitSha string `json:"commit_sha"`
+	Verified bool `json:"verified"`
+	Result string `json:"result"`
+	Message string `json:"message"`
+	AppDpID string `json:"app_dp_id,omitempty"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+func (dto *UpdateApplicationWebhookDto) Validate() (bool, error) {
+	valid := true
+	var validation_errors error = nil
+
+	if !valid_git_url(dto.GitURL) || len(dto.GitURL) > 500 {
+		valid = false
+		validation_errors = errors.Join(validation_errors, errors.New("git_url must be a valid repository URL of at most 500 characters"))
+	}
+
+	if len(dto.Branch) > 255 || !valid_git_ref(dto.Branch) {
+		valid = false
+		validation_errors = errors.Join(validation_errors, errors.New("branch must be a valid branch name"))
+	}
+
+	return valid, validation_errors
+}
+
+// NewApplicationWebhookResponse leaves the secret out unless it was just
+// generated, it is shown once.
+func NewApplicationWebhookResponse(row database.ApplicationWebhook, with_secret bool) *ApplicationWebhookResponse {
+	response := &ApplicationWebhookResponse{
+		AppID: row.AppID.String(),
+		GitURL: row.GitUrl,
+		Branch: row.Branch,
+		CreatedAt: row.CreatedAt.Time,
+		UpdatedAt: row.UpdatedAt.Time,
+	}
+	if with_secret {
+		response.Secret = row.Secret
+	}
+
+	return response
+}
+
+func NewApplicationWebhookDeliveryResponse(row database.ApplicationWebhookDelivery) *ApplicationWebhookDeliveryResponse {
+	app_dp_id := ""
+	if row.AppDpID.Valid {
+		app_dp_id = row.AppDpID.String()
+	}
+
+	return &ApplicationWebhookDeliveryResponse{
+		ID: row.AppWhDlID.String(),
+		Provider: row.Provider.String,
+		Event: row.Event.String,
+		DeliveryID: row.DeliveryID.String,
+		Ref: row.Ref.String,
+		CommitSha: row.CommitSha.String,
+		Verified: row.Verified,
+		Result: row.Result,
+		Message: row.Message.String,
+		AppDpID: app_dp_id,
+		ReceivedAt: row.ReceivedAt.Time,
+	}
+}
+
+func NewListApplicationWebhookDeliveryResponse(rows []database.ApplicationWebhookDelivery) []ApplicationWebhookDeliveryResponse {
+	formatted := make([]ApplicationWebhookDeliveryResponse, len(rows))
+
+	for i, row := range rows {
+		formatted[i] = *NewApplicationWebhookDeliveryResponse(row)
+	}
+
+	return formatted
+}
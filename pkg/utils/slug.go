@@ -0,0 +1,26 @@
+package utils
+
+import (
+	"strings"
+)
+
+// Slug reduces a name to a single dns label, the part of the hosts of
+// applications their project or application name is known by
+func Slug(name string) string {
+	var b strings.Builder
+	dash := false
+
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+
+	return strings.TrimSuffix(b.String(), "-")
+}
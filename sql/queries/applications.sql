@@ -2,19 +2,101 @@
 INSERT INTO "applications" (
   project_id,
   type,
-  name
+  name,
+  slug
 ) 
-VALUES ($1, $2, $3) RETURNING *;
+VALUES ($1, $2, $3, $4) RETURNING *;
 
 -- name: UpdateOneApplication :one
 UPDATE "applications"
 SET 
   name = $2,
-  updated_at = $3
+  updated_at = $3,
+  slug = $4
 WHERE 
   app_id = $1
 RETURNING *;
 
+-- name: UpdateApplicationDesiredState :one
+UPDATE "applications"
+SET
+  desired_state = $2,
+  desired_state_updated_by = $3,
+  desired_state_updated_at = NOW(),
+  updated_at = NOW()
+WHERE
+  app_id = $1
+RETURNING *;
+
+-- name: UpdateApplicationHealthCheck :one
+UPDATE "applications"
+SET
+  health_check_type = $2,
+  health_check_path = $3,
+  health_check_interval_seconds = $4,
+  health_check_timeout_seconds = $5,
+  health_check_failure_threshold = $6,
+  updated_at = NOW()
+WHERE
+  app_id = $1
+RETURNING *;
+
+-- name: UpdateApplicationResources :one
+UPDATE "applications"
+SET
+  cpu_millis = $2,
+  memory_mb = $3,
+  updated_at = NOW()
+WHERE
+  app_id = $1
+RETURNING *;
+
+-- name: UpdateApplicationSchedule :one
+UPDATE "applications"
+SET
+  cron_expression = $2,
+  cron_command = $3,
+  cron_allow_overlap = $4,
+  cron_next_run_at = $5,
+  updated_at = NOW()
+WHERE
+  app_id = $1
+RETURNING *;
+
+-- name: FindDueApplicationSchedules :many
+SELECT *
+FROM
+  "applications"
+WHERE
+  cron_expression IS NOT NULL AND cron_next_run_at <= @now::timestamp
+ORDER BY cron_next_run_at;
+
+-- name: ClaimApplicationScheduleRun :one
+UPDATE "applications"
+SET
+  cron_next_run_at = @next_run_at::timestamp
+WHERE
+  app_id = @app_id AND cron_next_run_at = @scheduled_at::timestamp
+RETURNING *;
+
+-- name: FindOneApplication :one
+SELECT *
+FROM
+  "applications"
+WHERE
+  app_id = $1;
+
+-- name: CreateApplicationEvent :one
+INSERT INTO "application_events" (
+  app_id,
+  app_dp_id,
+  kind,
+  actor_user_id,
+  message
+)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING *;
+
 -- name: FindOneApplicationWithProjectMember :one
 SELECT "app".*, sqlc.embed(config), "pm".project_id pm_project_id, "pm".role role
 FROM 
@@ -34,11 +116,599 @@ WHERE
 LIMIT 1;
 
 -- name: CreateApplicationConfig :one
+-- fields left NULL keep their current value
 INSERT INTO "application_configs" (
   app_id,
-  variables_json
+  variables_json,
+  stack
 )
-VALUES ($1, $2) 
+VALUES (@app_id, sqlc.narg(variables_json), sqlc.narg(stack)) 
 ON CONFLICT (app_id)
-DO UPDATE SET variables_json = $2, updated_at = NOW()
-RETURNING *;
\ No newline at end of file
+DO UPDATE SET
+  variables_json = COALESCE(sqlc.narg(variables_json), "application_configs".variables_json),
+  stack = COALESCE(sqlc.narg(stack), "application_configs".stack),
+  updated_at = NOW()
+RETURNING *;
+
+-- name: CreateApplicationDeployment :one
+INSERT INTO "application_deployments" (
+  app_dp_id,
+  app_id,
+  artifacts_path,
+  variables_snapshot_json,
+  rolled_back_from,
+  bundle_key,
+  bundle_sha256,
+  bundle_size,
+  git_url,
+  git_ref,
+  git_commit_sha,
+  canary_weight,
+  canary_sticky,
+  stack
+)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+RETURNING *;
+
+-- name: FindApplicationDeploymentsByAppId :many
+SELECT *
+FROM
+  "application_deployments"
+WHERE
+  app_id = $1
+ORDER BY created_at DESC;
+
+-- name: FindApplicationDeploymentsByStatus :many
+SELECT *
+FROM
+  "application_deployments"
+WHERE
+  app_id = $1 AND status = $2
+ORDER BY created_at DESC;
+
+-- name: FindOneApplicationDeployment :one
+SELECT *
+FROM
+  "application_deployments"
+WHERE
+  app_dp_id = $1 AND app_id = $2
+LIMIT 1;
+
+-- name: FindOneApplicationDeploymentById :one
+SELECT *
+FROM
+  "application_deployments"
+WHERE
+  app_dp_id = $1;
+
+-- name: FindApplicationDeploymentsWithDesiredState :many
+SELECT sqlc.embed(dp), "app".type app_type, "app".desired_state app_desired_state, "app".desired_state_updated_at app_desired_state_updated_at
+FROM
+  "application_deployments" AS "dp"
+JOIN
+  "applications" AS "app" ON "app".app_id = "dp".app_id
+WHERE
+  "dp".status = ANY(@statuses::varchar[])
+ORDER BY "dp".created_at DESC;
+
+-- name: UpdateApplicationDeploymentSource :one
+UPDATE "application_deployments"
+SET
+  git_commit_sha = $2,
+  bundle_key = $3,
+  bundle_sha256 = $4,
+  bundle_size = $5,
+  updated_at = NOW()
+WHERE
+  app_dp_id = $1
+RETURNING *;
+
+-- name: UpsertApplicationDeploymentProcess :one
+INSERT INTO "application_deployment_processes" (
+  app_dp_id,
+  process_type,
+  instance_index,
+  runtime_id,
+  process_name,
+  container_name,
+  status,
+  restart_count
+)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+ON CONFLICT (app_dp_id, process_type, instance_index)
+DO UPDATE SET
+  runtime_id = $4,
+  process_name = $5,
+  container_name = $6,
+  status = $7,
+  restart_count = $8,
+  exit_code = NULL,
+  started_at = NOW(),
+  updated_at = NOW()
+RETURNING *;
+
+-- name: UpdateApplicationDeploymentProcessStatus :one
+UPDATE "application_deployment_processes"
+SET
+  status = @status,
+  exit_code = sqlc.narg(exit_code),
+  restart_count = @restart_count,
+  updated_at = NOW()
+WHERE
+  app_dp_proc_id = @app_dp_proc_id
+RETURNING *;
+
+-- name: FindApplicationDeploymentProcesses :many
+SELECT *
+FROM
+  "application_deployment_processes"
+WHERE
+  app_dp_id = $1
+ORDER BY process_type, instance_index;
+
+-- name: FindApplicationDeploymentProcessesByStatus :many
+SELECT "proc".*
+FROM
+  "application_deployment_processes" AS "proc"
+JOIN
+  "application_deployments" AS "dp" ON "dp".app_dp_id = "proc".app_dp_id
+WHERE
+  "proc".status = ANY(@statuses::varchar[]) AND "dp".status = ANY(@deployment_statuses::varchar[]);
+
+-- name: UpsertApplicationProcessScale :one
+INSERT INTO "application_process_scales" (
+  app_id,
+  process_type,
+  scale
+)
+VALUES ($1, $2, $3)
+ON CONFLICT (app_id, process_type)
+DO UPDATE SET scale = $3, updated_at = NOW()
+RETURNING *;
+
+-- name: FindApplicationProcessScales :many
+SELECT *
+FROM
+  "application_process_scales"
+WHERE
+  app_id = $1
+ORDER BY process_type;
+
+-- name: UpdateApplicationDeploymentStack :one
+UPDATE "application_deployments"
+SET
+  stack = $2,
+  updated_at = NOW()
+WHERE
+  app_dp_id = $1
+RETURNING *;
+
+-- name: UpdateApplicationDeploymentStatus :one
+UPDATE "application_deployments"
+SET
+  status = @to_status,
+  failure_reason = sqlc.narg(failure_reason),
+  status_updated_at = NOW(),
+  updated_at = NOW()
+WHERE
+  app_dp_id = @app_dp_id AND status = @from_status
+RETURNING *;
+
+-- name: CreateApplicationDeploymentTransition :one
+INSERT INTO "application_deployment_transitions" (
+  app_dp_id,
+  from_status,
+  to_status,
+  reason
+)
+VALUES ($1, $2, $3, $4)
+RETURNING *;
+
+-- name: FindApplicationDeploymentTransitions :many
+SELECT *
+FROM
+  "application_deployment_transitions"
+WHERE
+  app_dp_id = $1
+ORDER BY created_at ASC;
+
+-- name: CreateApplicationDeploymentLog :one
+INSERT INTO "application_deployment_logs" (
+  app_dp_id,
+  phase,
+  stream,
+  line,
+  logged_at,
+  process_type
+)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING *;
+
+-- name: FindApplicationDeploymentLogs :many
+SELECT *
+FROM
+  "application_deployment_logs"
+WHERE
+  app_dp_id = @app_dp_id
+  AND app_dp_log_id > @app_dp_log_id
+  AND (sqlc.narg(process_type)::varchar IS NULL OR process_type = sqlc.narg(process_type))
+ORDER BY app_dp_log_id ASC
+LIMIT @limit_count::integer;
+
+-- name: UpdateApplicationDeploymentHealth :one
+UPDATE "application_deployments"
+SET
+  health_status = $2,
+  health_message = $3,
+  health_consecutive_failures = $4,
+  health_checked_at = NOW()
+WHERE
+  app_dp_id = $1
+RETURNING *;
+
+-- name: UpdateApplicationDeploymentRestarts :one
+UPDATE "application_deployments"
+SET
+  restart_count = @restart_count,
+  last_restarted_at = COALESCE(sqlc.narg(last_restarted_at), last_restarted_at),
+  updated_at = NOW()
+WHERE
+  app_dp_id = @app_dp_id
+RETURNING *;
+
+-- name: FindIngressRoutes :many
+SELECT
+  "app".app_id,
+  "app".type app_type,
+  "app".name app_name,
+  "proj".name project_name,
+  "app".slug app_slug,
+  "proj".slug project_slug,
+  "dp".app_dp_id,
+  "dp".canary_weight,
+  "dp".canary_sticky,
+  "port".port leased_port,
+  ARRAY(
+    SELECT "web_port".port
+    FROM
+      "application_deployment_ports" AS "web_port"
+    JOIN
+      "application_deployment_processes" AS "web" ON "web".app_dp_id = "web_port".app_dp_id
+        AND "web".process_type = 'web'
+        AND "web".instance_index = "web_port".instance_index
+    WHERE
+      "web_port".app_dp_id = "dp".app_dp_id
+      AND "web_port".instance_index > 0
+      AND "web".status = 'running'
+    ORDER BY "web_port".instance_index
+  )::integer[] web_instance_ports,
+  "dp".artifacts_path
+FROM
+  "application_deployments" AS "dp"
+JOIN
+  "applications" AS "app" ON "app".app_id = "dp".app_id
+JOIN
+  "projects" AS "proj" ON "proj".project_id = "app".project_id
+LEFT JOIN
+  "application_deployment_ports" AS "port" ON "port".app_dp_id = "dp".app_dp_id AND "port".instance_index = 0
+WHERE
+  "dp".status = ANY(@statuses::varchar[])
+ORDER BY "app".app_id, "dp".created_at DESC;
+
+-- name: FindApplicationDeploymentPort :one
+SELECT *
+FROM
+  "application_deployment_ports"
+WHERE
+  app_dp_id = $1
+  AND instance_index = $2;
+
+-- name: LeaseApplicationDeploymentPort :one
+INSERT INTO "application_deployment_ports" (
+  port,
+  app_dp_id,
+  instance_index
+)
+SELECT "candidate", @app_dp_id, @instance_index::integer
+FROM
+  generate_series(@range_start::integer, @range_end::integer) AS "candidate"
+WHERE
+  "candidate" NOT IN (SELECT port FROM "application_deployment_ports")
+ORDER BY "candidate"
+LIMIT 1
+ON CONFLICT DO NOTHING
+RETURNING *;
+
+-- name: ReleaseApplicationDeploymentPort :exec
+DELETE FROM "application_deployment_ports"
+WHERE
+  app_dp_id = $1;
+
+-- name: UpsertApplicationWebhook :one
+INSERT INTO "application_webhooks" (
+  app_id,
+  git_url,
+  branch,
+  secret
+)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (app_id) DO UPDATE
+SET
+  git_url = EXCLUDED.git_url,
+  branch = EXCLUDED.branch,
+  secret = EXCLUDED.secret,
+  updated_at = NOW()
+RETURNING *;
+
+-- name: FindOneApplicationWebhook :one
+SELECT *
+FROM
+  "application_webhooks"
+WHERE
+  app_id = $1;
+
+-- name: CreateApplicationWebhookDelivery :one
+INSERT INTO "application_webhook_deliveries" (
+  app_wh_id,
+  provider,
+  event,
+  delivery_id,
+  ref,
+  commit_sha,
+  verified,
+  result,
+  message,
+  app_dp_id
+)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+RETURNING *;
+
+-- name: FindApplicationWebhookDeliveries :many
+SELECT *
+FROM
+  "application_webhook_deliveries"
+WHERE
+  app_wh_id = $1
+ORDER BY received_at DESC
+LIMIT $2;
+
+-- name: FindOneApplicationConfig :one
+SELECT *
+FROM
+  "application_configs"
+WHERE
+  app_id = $1;
+
+-- name: CreateApplicationDeploymentJob :one
+INSERT INTO "application_deployment_jobs" (
+  app_dp_id,
+  app_id,
+  org_id
+)
+SELECT @app_dp_id::uuid, "app".app_id, "proj".org_id
+FROM
+  "applications" AS "app"
+JOIN
+  "projects" AS "proj" ON "proj".project_id = "app".project_id
+WHERE
+  "app".app_id = @app_id
+RETURNING *;
+
+-- name: LockApplicationDeploymentJobClaims :exec
+SELECT pg_advisory_xact_lock(@lock_key::bigint);
+
+-- The caps are counted under READ COMMITTED, callers take
+-- LockApplicationDeploymentJobClaims in an earlier statement of the same
+-- transaction so this snapshot sees every claim committed before theirs.
+-- name: ClaimApplicationDeploymentJob :one
+UPDATE "application_deployment_jobs"
+SET
+  status = 'running',
+  attempts = attempts + 1,
+  locked_by = @locked_by::varchar,
+  locked_at = NOW(),
+  updated_at = NOW()
+WHERE
+  app_dp_job_id = (
+    SELECT "job".app_dp_job_id
+    FROM
+      "application_deployment_jobs" AS "job"
+    WHERE
+      (
+        ("job".status = 'queued' AND "job".run_after <= NOW())
+        OR ("job".status = 'running' AND "job".locked_at < NOW() - make_interval(secs => @lock_timeout_seconds::integer))
+      )
+      AND (
+        SELECT COUNT(*)
+        FROM
+          "application_deployment_jobs" AS "org_job"
+        WHERE
+          "org_job".org_id = "job".org_id
+          AND "org_job".status = 'running'
+          AND "org_job".locked_at >= NOW() - make_interval(secs => @lock_timeout_seconds::integer)
+      ) < @org_concurrency::integer
+      AND (
+        SELECT COUNT(*)
+        FROM
+          "application_deployment_jobs" AS "app_job"
+        WHERE
+          "app_job".app_id = "job".app_id
+          AND "app_job".status = 'running'
+          AND "app_job".locked_at >= NOW() - make_interval(secs => @lock_timeout_seconds::integer)
+      ) < @app_concurrency::integer
+    ORDER BY "job".run_after, "job".created_at
+    LIMIT 1
+    FOR UPDATE SKIP LOCKED
+  )
+RETURNING *;
+
+-- name: HeartbeatApplicationDeploymentJob :one
+UPDATE "application_deployment_jobs"
+SET
+  locked_at = NOW(),
+  updated_at = NOW()
+WHERE
+  app_dp_job_id = @app_dp_job_id AND status = 'running' AND locked_by = @locked_by::varchar
+RETURNING *;
+
+-- name: RetryApplicationDeploymentJob :one
+UPDATE "application_deployment_jobs"
+SET
+  status = 'queued',
+  run_after = @run_after,
+  last_error = @last_error,
+  locked_by = NULL,
+  locked_at = NULL,
+  updated_at = NOW()
+WHERE
+  app_dp_job_id = @app_dp_job_id AND status = 'running' AND locked_by = @locked_by::varchar
+RETURNING *;
+
+-- name: FinishApplicationDeploymentJob :one
+UPDATE "application_deployment_jobs"
+SET
+  status = @status,
+  last_error = sqlc.narg(last_error),
+  locked_by = NULL,
+  locked_at = NULL,
+  updated_at = NOW()
+WHERE
+  app_dp_job_id = @app_dp_job_id AND status = 'running' AND locked_by = @locked_by::varchar
+RETURNING *;
+
+-- name: CancelQueuedApplicationDeploymentJob :one
+UPDATE "application_deployment_jobs"
+SET
+  status = 'cancelled',
+  cancel_requested = true,
+  updated_at = NOW()
+WHERE
+  app_dp_id = $1 AND status = 'queued'
+RETURNING *;
+
+-- name: RequestApplicationDeploymentJobCancel :one
+UPDATE "application_deployment_jobs"
+SET
+  cancel_requested = true,
+  updated_at = NOW()
+WHERE
+  app_dp_id = $1 AND status = 'running'
+RETURNING *;
+
+-- name: UpdateApplicationDeploymentCanary :one
+UPDATE "application_deployments"
+SET
+  canary_weight = $2,
+  canary_sticky = $3,
+  updated_at = NOW()
+WHERE
+  app_dp_id = $1 AND canary_weight IS NOT NULL
+RETURNING *;
+
+-- name: PromoteApplicationDeploymentCanary :one
+UPDATE "application_deployments"
+SET
+  canary_weight = NULL,
+  canary_sticky = false,
+  updated_at = NOW()
+WHERE
+  app_dp_id = $1 AND canary_weight IS NOT NULL
+RETURNING *;
+
+-- name: AddApplicationDeploymentCanaryTraffic :exec
+UPDATE "application_deployments"
+SET
+  canary_requests = canary_requests + @canary_requests,
+  canary_errors = canary_errors + @canary_errors,
+  stable_requests = stable_requests + @stable_requests,
+  stable_errors = stable_errors + @stable_errors
+WHERE
+  app_dp_id = @app_dp_id;
+
+
+-- name: StartApplicationJobRun :one
+INSERT INTO "application_job_runs" (
+  app_id,
+  app_dp_id,
+  status,
+  command,
+  scheduled_at,
+  started_at
+)
+SELECT @app_id::uuid, @app_dp_id::uuid, 'running', @command::text, @scheduled_at::timestamp, NOW()
+WHERE
+  @allow_overlap::boolean OR NOT EXISTS (
+    SELECT 1
+    FROM
+      "application_job_runs"
+    WHERE
+      app_id = @app_id::uuid AND status = 'running'
+  )
+RETURNING *;
+
+-- name: CreateApplicationJobRun :one
+INSERT INTO "application_job_runs" (
+  app_id,
+  app_dp_id,
+  status,
+  command,
+  scheduled_at,
+  failure_reason
+)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING *;
+
+-- name: FinishApplicationJobRun :one
+UPDATE "application_job_runs"
+SET
+  status = @status,
+  exit_code = sqlc.narg(exit_code),
+  failure_reason = sqlc.narg(failure_reason),
+  finished_at = NOW(),
+  duration_ms = (EXTRACT(EPOCH FROM NOW() - started_at) * 1000)::bigint
+WHERE
+  app_run_id = @app_run_id AND status = 'running'
+RETURNING *;
+
+-- name: FindApplicationJobRuns :many
+SELECT *
+FROM
+  "application_job_runs"
+WHERE
+  app_id = $1
+ORDER BY created_at DESC
+LIMIT $2;
+
+-- name: FindApplicationJobRunsByStatus :many
+SELECT *
+FROM
+  "application_job_runs"
+WHERE
+  status = $1
+ORDER BY created_at DESC;
+
+-- name: FindOneApplicationJobRun :one
+SELECT *
+FROM
+  "application_job_runs"
+WHERE
+  app_run_id = $1 AND app_id = $2;
+
+-- name: CreateApplicationJobRunLog :one
+INSERT INTO "application_job_run_logs" (
+  app_run_id,
+  stream,
+  line,
+  logged_at
+)
+VALUES ($1, $2, $3, $4)
+RETURNING *;
+
+-- name: FindApplicationJobRunLogs :many
+SELECT *
+FROM
+  "application_job_run_logs"
+WHERE
+  app_run_id = $1 AND app_run_log_id > $2
+ORDER BY app_run_log_id ASC
+LIMIT $3;
@@ -1,5 +1,5 @@
 -- name: CreateProject :one
-INSERT INTO "projects" (org_id, name) VALUES ($1, $2) RETURNING *;
+INSERT INTO "projects" (org_id, name, slug) VALUES ($1, $2, $3) RETURNING *;
 
 -- name: CreateProjectMember :one
 INSERT INTO "project_members" 
@@ -39,7 +39,8 @@ WHERE
 UPDATE "projects" 
 SET 
   name = $1, 
-  updated_at = $2 
+  updated_at = $2,
+  slug = $4
 WHERE 
   project_id = $3
 RETURNING *;
@@ -0,0 +1,30 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE "application_deployments"
+ADD COLUMN "status" varchar(25) NOT NULL DEFAULT 'queued',
+ADD COLUMN "failure_reason" text,
+ADD COLUMN "status_updated_at" timestamp DEFAULT NOW();
+
+CREATE TABLE IF NOT EXISTS "application_deployment_transitions" (
+  "app_dp_tr_id" uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+  "app_dp_id" uuid NOT NULL,
+  "from_status" varchar(25),
+  "to_status" varchar(25) NOT NULL,
+  "reason" text,
+  "created_at" timestamp DEFAULT NOW(),
+  FOREIGN KEY(app_dp_id) REFERENCES "application_deployments"(app_dp_id)
+);
+
+CREATE INDEX IF NOT EXISTS app_dp_tr_app_dp_id
+ON application_deployment_transitions (app_dp_id, created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE "application_deployment_transitions";
+
+ALTER TABLE "application_deployments"
+DROP COLUMN "status",
+DROP COLUMN "failure_reason",
+DROP COLUMN "status_updated_at";
+-- +goose StatementEnd
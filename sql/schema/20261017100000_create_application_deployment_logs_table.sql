@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS "application_deployment_logs" (
+  "app_dp_log_id" bigserial PRIMARY KEY,
+  "app_dp_id" uuid NOT NULL,
+  "phase" varchar(25) NOT NULL,
+  "stream" varchar(10) NOT NULL,
+  "line" text NOT NULL,
+  "logged_at" timestamp NOT NULL DEFAULT NOW(),
+  FOREIGN KEY(app_dp_id) REFERENCES "application_deployments"(app_dp_id)
+);
+
+CREATE INDEX IF NOT EXISTS app_dp_log_app_dp_id
+ON application_deployment_logs (app_dp_id, app_dp_log_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE "application_deployment_logs";
+-- +goose StatementEnd
@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE "application_deployments"
+ADD COLUMN "rolled_back_from" uuid,
+ADD FOREIGN KEY(rolled_back_from) REFERENCES "application_deployments"(app_dp_id);
+
+CREATE INDEX IF NOT EXISTS app_dp_app_id_status
+ON application_deployments (app_id, status);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS app_dp_app_id_status;
+
+ALTER TABLE "application_deployments"
+DROP COLUMN "rolled_back_from";
+-- +goose StatementEnd
@@ -0,0 +1,34 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE "applications"
+ADD COLUMN "desired_state" varchar(25) NOT NULL DEFAULT 'running',
+ADD COLUMN "desired_state_updated_by" uuid,
+ADD COLUMN "desired_state_updated_at" timestamp,
+ADD FOREIGN KEY(desired_state_updated_by) REFERENCES "users"(user_id);
+
+CREATE TABLE IF NOT EXISTS "application_events" (
+  "app_ev_id" uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+  "app_id" uuid NOT NULL,
+  "app_dp_id" uuid,
+  "kind" varchar(50) NOT NULL,
+  "actor_user_id" uuid,
+  "message" text,
+  "created_at" timestamp DEFAULT NOW(),
+  FOREIGN KEY(app_id) REFERENCES "applications"(app_id),
+  FOREIGN KEY(app_dp_id) REFERENCES "application_deployments"(app_dp_id),
+  FOREIGN KEY(actor_user_id) REFERENCES "users"(user_id)
+);
+
+CREATE INDEX IF NOT EXISTS app_ev_app_id
+ON application_events (app_id, created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE "application_events";
+
+ALTER TABLE "applications"
+DROP COLUMN "desired_state",
+DROP COLUMN "desired_state_updated_by",
+DROP COLUMN "desired_state_updated_at";
+-- +goose StatementEnd
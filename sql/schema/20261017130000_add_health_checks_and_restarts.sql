@@ -0,0 +1,35 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE "applications"
+ADD COLUMN "health_check_type" varchar(10) NOT NULL DEFAULT 'tcp',
+ADD COLUMN "health_check_path" varchar(255) NOT NULL DEFAULT '/',
+ADD COLUMN "health_check_interval_seconds" integer NOT NULL DEFAULT 10,
+ADD COLUMN "health_check_timeout_seconds" integer NOT NULL DEFAULT 2,
+ADD COLUMN "health_check_failure_threshold" integer NOT NULL DEFAULT 3;
+
+ALTER TABLE "application_deployments"
+ADD COLUMN "health_status" varchar(25) NOT NULL DEFAULT 'unknown',
+ADD COLUMN "health_message" text,
+ADD COLUMN "health_checked_at" timestamp,
+ADD COLUMN "health_consecutive_failures" integer NOT NULL DEFAULT 0,
+ADD COLUMN "restart_count" integer NOT NULL DEFAULT 0,
+ADD COLUMN "last_restarted_at" timestamp;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE "application_deployments"
+DROP COLUMN "health_status",
+DROP COLUMN "health_message",
+DROP COLUMN "health_checked_at",
+DROP COLUMN "health_consecutive_failures",
+DROP COLUMN "restart_count",
+DROP COLUMN "last_restarted_at";
+
+ALTER TABLE "applications"
+DROP COLUMN "health_check_type",
+DROP COLUMN "health_check_path",
+DROP COLUMN "health_check_interval_seconds",
+DROP COLUMN "health_check_timeout_seconds",
+DROP COLUMN "health_check_failure_threshold";
+-- +goose StatementEnd
@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS "application_deployment_ports" (
+  "port" integer PRIMARY KEY,
+  "app_dp_id" uuid NOT NULL UNIQUE,
+  "leased_at" timestamp NOT NULL DEFAULT NOW(),
+  FOREIGN KEY(app_dp_id) REFERENCES "application_deployments"(app_dp_id) ON DELETE CASCADE
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE "application_deployment_ports";
+-- +goose StatementEnd
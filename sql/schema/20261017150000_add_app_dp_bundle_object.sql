@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE "application_deployments"
+ADD COLUMN "bundle_key" varchar(500),
+ADD COLUMN "bundle_sha256" varchar(64),
+ADD COLUMN "bundle_size" bigint;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE "application_deployments"
+DROP COLUMN "bundle_key",
+DROP COLUMN "bundle_sha256",
+DROP COLUMN "bundle_size";
+-- +goose StatementEnd
@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE "application_deployments"
+ADD COLUMN "git_url" varchar(500),
+ADD COLUMN "git_ref" varchar(255),
+ADD COLUMN "git_commit_sha" varchar(40);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE "application_deployments"
+DROP COLUMN "git_url",
+DROP COLUMN "git_ref",
+DROP COLUMN "git_commit_sha";
+-- +goose StatementEnd
@@ -0,0 +1,39 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS "application_webhooks" (
+  "app_wh_id" uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+  "app_id" uuid NOT NULL UNIQUE,
+  "git_url" varchar(500) NOT NULL,
+  "branch" varchar(255) NOT NULL,
+  "secret" varchar(64) NOT NULL,
+  "created_at" timestamp DEFAULT NOW(),
+  "updated_at" timestamp DEFAULT NOW(),
+  FOREIGN KEY(app_id) REFERENCES "applications"(app_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS "application_webhook_deliveries" (
+  "app_wh_dl_id" uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+  "app_wh_id" uuid NOT NULL,
+  "provider" varchar(20),
+  "event" varchar(100),
+  "delivery_id" varchar(255),
+  "ref" varchar(255),
+  "commit_sha" varchar(40),
+  "verified" boolean NOT NULL DEFAULT false,
+  "result" varchar(25) NOT NULL,
+  "message" text,
+  "app_dp_id" uuid,
+  "received_at" timestamp DEFAULT NOW(),
+  FOREIGN KEY(app_wh_id) REFERENCES "application_webhooks"(app_wh_id) ON DELETE CASCADE,
+  FOREIGN KEY(app_dp_id) REFERENCES "application_deployments"(app_dp_id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS app_wh_dl_app_wh_id
+ON application_webhook_deliveries (app_wh_id, received_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE "application_webhook_deliveries";
+DROP TABLE "application_webhooks";
+-- +goose StatementEnd
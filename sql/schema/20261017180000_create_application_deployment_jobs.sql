@@ -0,0 +1,35 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS "application_deployment_jobs" (
+  "app_dp_job_id" uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+  "app_dp_id" uuid NOT NULL UNIQUE,
+  "app_id" uuid NOT NULL,
+  "org_id" uuid NOT NULL,
+  "status" varchar(25) NOT NULL DEFAULT 'queued',
+  "attempts" integer NOT NULL DEFAULT 0,
+  "run_after" timestamp NOT NULL DEFAULT NOW(),
+  "locked_by" varchar(255),
+  "locked_at" timestamp,
+  "cancel_requested" boolean NOT NULL DEFAULT false,
+  "last_error" text,
+  "created_at" timestamp DEFAULT NOW(),
+  "updated_at" timestamp DEFAULT NOW(),
+  FOREIGN KEY(app_dp_id) REFERENCES "application_deployments"(app_dp_id) ON DELETE CASCADE,
+  FOREIGN KEY(app_id) REFERENCES "applications"(app_id) ON DELETE CASCADE,
+  FOREIGN KEY(org_id) REFERENCES "organizations"(org_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS app_dp_job_status_run_after
+ON application_deployment_jobs (status, run_after);
+
+CREATE INDEX IF NOT EXISTS app_dp_job_org_id_status
+ON application_deployment_jobs (org_id, status);
+
+CREATE INDEX IF NOT EXISTS app_dp_job_app_id_status
+ON application_deployment_jobs (app_id, status);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE "application_deployment_jobs";
+-- +goose StatementEnd
@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE "applications"
+ADD COLUMN "cpu_millis" integer NOT NULL DEFAULT 0,
+ADD COLUMN "memory_mb" integer NOT NULL DEFAULT 0;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE "applications"
+DROP COLUMN "cpu_millis",
+DROP COLUMN "memory_mb";
+-- +goose StatementEnd
@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE "application_deployments"
+ADD COLUMN "canary_weight" integer,
+ADD COLUMN "canary_sticky" boolean NOT NULL DEFAULT false,
+ADD COLUMN "canary_requests" bigint NOT NULL DEFAULT 0,
+ADD COLUMN "canary_errors" bigint NOT NULL DEFAULT 0,
+ADD COLUMN "stable_requests" bigint NOT NULL DEFAULT 0,
+ADD COLUMN "stable_errors" bigint NOT NULL DEFAULT 0;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE "application_deployments"
+DROP COLUMN "canary_weight",
+DROP COLUMN "canary_sticky",
+DROP COLUMN "canary_requests",
+DROP COLUMN "canary_errors",
+DROP COLUMN "stable_requests",
+DROP COLUMN "stable_errors";
+-- +goose StatementEnd
@@ -0,0 +1,59 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE "applications"
+ADD COLUMN "cron_expression" varchar(255),
+ADD COLUMN "cron_command" text,
+ADD COLUMN "cron_allow_overlap" boolean NOT NULL DEFAULT false,
+ADD COLUMN "cron_next_run_at" timestamp;
+
+CREATE INDEX IF NOT EXISTS app_cron_next_run_at
+ON applications (cron_next_run_at)
+WHERE cron_expression IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS "application_job_runs" (
+  "app_run_id" uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+  "app_id" uuid NOT NULL,
+  "app_dp_id" uuid,
+  "status" varchar(25) NOT NULL,
+  "command" text NOT NULL,
+  "scheduled_at" timestamp NOT NULL,
+  "started_at" timestamp,
+  "finished_at" timestamp,
+  "duration_ms" bigint,
+  "exit_code" integer,
+  "failure_reason" text,
+  "created_at" timestamp DEFAULT NOW(),
+  FOREIGN KEY(app_id) REFERENCES "applications"(app_id) ON DELETE CASCADE,
+  FOREIGN KEY(app_dp_id) REFERENCES "application_deployments"(app_dp_id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS app_run_app_id_created_at
+ON application_job_runs (app_id, created_at);
+
+CREATE INDEX IF NOT EXISTS app_run_status
+ON application_job_runs (status, app_id);
+
+CREATE TABLE IF NOT EXISTS "application_job_run_logs" (
+  "app_run_log_id" bigserial PRIMARY KEY,
+  "app_run_id" uuid NOT NULL,
+  "stream" varchar(10) NOT NULL,
+  "line" text NOT NULL,
+  "logged_at" timestamp NOT NULL DEFAULT NOW(),
+  FOREIGN KEY(app_run_id) REFERENCES "application_job_runs"(app_run_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS app_run_log_app_run_id
+ON application_job_run_logs (app_run_id, app_run_log_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE "application_job_run_logs";
+DROP TABLE "application_job_runs";
+
+ALTER TABLE "applications"
+DROP COLUMN "cron_expression",
+DROP COLUMN "cron_command",
+DROP COLUMN "cron_allow_overlap",
+DROP COLUMN "cron_next_run_at";
+-- +goose StatementEnd
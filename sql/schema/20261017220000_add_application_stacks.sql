@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE "application_configs"
+ADD COLUMN "stack" varchar(25);
+
+ALTER TABLE "application_deployments"
+ADD COLUMN "stack" varchar(25);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE "application_deployments"
+DROP COLUMN "stack";
+
+ALTER TABLE "application_configs"
+DROP COLUMN "stack";
+-- +goose StatementEnd
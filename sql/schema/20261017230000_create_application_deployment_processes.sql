@@ -0,0 +1,87 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS "application_deployment_processes" (
+  "app_dp_proc_id" uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+  "app_dp_id" uuid NOT NULL,
+  "process_type" varchar(63) NOT NULL,
+  "instance_index" integer NOT NULL,
+  "runtime_id" varchar(255) NOT NULL,
+  "process_name" varchar(255) NOT NULL,
+  "container_name" varchar(255) NOT NULL,
+  "status" varchar(25) NOT NULL,
+  "exit_code" integer,
+  "restart_count" integer NOT NULL DEFAULT 0,
+  "started_at" timestamp NOT NULL DEFAULT NOW(),
+  "created_at" timestamp DEFAULT NOW(),
+  "updated_at" timestamp DEFAULT NOW(),
+  UNIQUE(app_dp_id, process_type, instance_index),
+  FOREIGN KEY(app_dp_id) REFERENCES "application_deployments"(app_dp_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS app_dp_proc_status
+ON application_deployment_processes (status, app_dp_id);
+
+-- what deployments ran so far was their start command, run as web
+INSERT INTO "application_deployment_processes" (
+  app_dp_id,
+  process_type,
+  instance_index,
+  runtime_id,
+  process_name,
+  container_name,
+  status,
+  restart_count
+)
+SELECT
+  app_dp_id,
+  'web',
+  0,
+  app_dp_id::text,
+  process_name,
+  container_name,
+  CASE WHEN status IN ('running', 'crash_looping') THEN status ELSE 'stopped' END,
+  restart_count
+FROM "application_deployments"
+WHERE process_name <> '' OR container_name <> '';
+
+ALTER TABLE "application_deployments"
+DROP COLUMN "process_name",
+DROP COLUMN "container_name";
+
+CREATE TABLE IF NOT EXISTS "application_process_scales" (
+  "app_id" uuid NOT NULL,
+  "process_type" varchar(63) NOT NULL,
+  "scale" integer NOT NULL,
+  "updated_at" timestamp DEFAULT NOW(),
+  PRIMARY KEY(app_id, process_type),
+  FOREIGN KEY(app_id) REFERENCES "applications"(app_id) ON DELETE CASCADE
+);
+
+ALTER TABLE "application_deployment_logs"
+ADD COLUMN "process_type" varchar(63);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE "application_deployment_logs"
+DROP COLUMN "process_type";
+
+DROP TABLE "application_process_scales";
+
+ALTER TABLE "application_deployments"
+ADD COLUMN "process_name" varchar(255) NOT NULL DEFAULT '',
+ADD COLUMN "container_name" varchar(255) NOT NULL DEFAULT '';
+
+UPDATE "application_deployments" AS "dp"
+SET
+  process_name = "proc".process_name,
+  container_name = "proc".container_name
+FROM "application_deployment_processes" AS "proc"
+WHERE "proc".app_dp_id = "dp".app_dp_id AND "proc".runtime_id = "dp".app_dp_id::text;
+
+ALTER TABLE "application_deployments"
+ALTER COLUMN "process_name" DROP DEFAULT,
+ALTER COLUMN "container_name" DROP DEFAULT;
+
+DROP TABLE "application_deployment_processes";
+-- +goose StatementEnd
@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE "application_deployment_ports"
+ADD COLUMN "instance_index" integer NOT NULL DEFAULT 0;
+
+ALTER TABLE "application_deployment_ports"
+DROP CONSTRAINT "application_deployment_ports_app_dp_id_key",
+ADD CONSTRAINT "application_deployment_ports_app_dp_id_instance_index_key" UNIQUE(app_dp_id, instance_index);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM "application_deployment_ports"
+WHERE instance_index > 0;
+
+ALTER TABLE "application_deployment_ports"
+DROP CONSTRAINT "application_deployment_ports_app_dp_id_instance_index_key",
+ADD CONSTRAINT "application_deployment_ports_app_dp_id_key" UNIQUE(app_dp_id);
+
+ALTER TABLE "application_deployment_ports"
+DROP COLUMN "instance_index";
+-- +goose StatementEnd
@@ -0,0 +1,63 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE "projects"
+ADD COLUMN "slug" varchar(260);
+
+ALTER TABLE "applications"
+ADD COLUMN "slug" varchar(110);
+
+-- same reduction as utils.Slug, names without a letter or digit fall back to their id
+UPDATE "projects"
+SET slug = trim(both '-' from regexp_replace(lower(name), '[^a-z0-9]+', '-', 'g'));
+
+UPDATE "projects"
+SET slug = left(project_id::text, 8)
+WHERE slug = '';
+
+UPDATE "applications"
+SET slug = trim(both '-' from regexp_replace(lower(name), '[^a-z0-9]+', '-', 'g'));
+
+UPDATE "applications"
+SET slug = left(app_id::text, 8)
+WHERE slug = '';
+
+-- the oldest of the names sharing a slug keeps it, the others get their id appended
+UPDATE "projects"
+SET slug = slug || '-' || left(project_id::text, 8)
+WHERE project_id IN (
+  SELECT project_id
+  FROM (
+    SELECT project_id, row_number() OVER (PARTITION BY slug ORDER BY created_at, project_id) AS "rank"
+    FROM "projects"
+  ) AS "ranked"
+  WHERE "rank" > 1
+);
+
+UPDATE "applications"
+SET slug = slug || '-' || left(app_id::text, 8)
+WHERE app_id IN (
+  SELECT app_id
+  FROM (
+    SELECT app_id, row_number() OVER (PARTITION BY project_id, slug ORDER BY created_at, app_id) AS "rank"
+    FROM "applications"
+  ) AS "ranked"
+  WHERE "rank" > 1
+);
+
+ALTER TABLE "projects"
+ALTER COLUMN "slug" SET NOT NULL,
+ADD CONSTRAINT "projects_slug_key" UNIQUE(slug);
+
+ALTER TABLE "applications"
+ALTER COLUMN "slug" SET NOT NULL,
+ADD CONSTRAINT "applications_project_id_slug_key" UNIQUE(project_id, slug);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE "applications"
+DROP COLUMN "slug";
+
+ALTER TABLE "projects"
+DROP COLUMN "slug";
+-- +goose StatementEnd
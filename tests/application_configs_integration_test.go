@@ -113,6 +113,17 @@ func TestCreateApplicationConfig(t *testing.T) {
 				}
 				`,
 			},
+			{
+				"unsupported stack",
+				`
+				{
+					"variables": {
+						"foo": "bar"
+					},
+					"stack": "ruby"
+				}
+				`,
+			},
 			{
 				"invalid variables (non primitive data type)",
 				`
@@ -469,6 +480,44 @@ func TestCreateApplicationConfig(t *testing.T) {
 			})
 		}
 	})
+
+	t.Run("should take a config that only pins the stack", func (t *testing.T) {
+		defer func() {
+			application_service.Clear()
+		}()
+
+		jwt_validator.validate_return = mock_user_id
+		application_service.create_config_return = &database.ApplicationConfig{
+			Stack: pgtype.Text{String: "python", Valid: true},
+		}
+
+		req, _ := http.NewRequest(
+			http.MethodPost,
+			"/api/applications/7aaa1bf8-437f-4f3c-8691-8316fc6fbe50/configs",
+			strings.NewReader(`{"stack": "python"}`),
+		)
+		req.AddCookie(sid_cookie)
+
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+
+		got_status := res.Result().StatusCode
+		if got_status != http.StatusOK {
+			t.Fatalf("got status code %d, want %d", got_status, http.StatusOK)
+		}
+
+		if got := application_service.create_config_calls_arg3[0].Stack; got != "python" {
+			t.Errorf("got service called with stack %q, want python", got)
+		}
+
+		var got_body utils.BaseResponse[map[string]any]
+		json.NewDecoder(res.Result().Body).Decode(&got_body)
+
+		got_data, _ := got_body.Data.(map[string]any)
+		if got_data["stack"] != "python" {
+			t.Errorf("got data %v, want the pinned stack", got_data)
+		}
+	})
 }
 
 func TestFindOneApplicationConfig(t *testing.T) {
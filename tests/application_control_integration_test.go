@@ -0,0 +1,369 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/salmanrf/capybara-cloud/api/routes"
+	"github.com/salmanrf/capybara-cloud/internal/database"
+	"github.com/salmanrf/capybara-cloud/pkg/dto"
+	"github.com/salmanrf/capybara-cloud/pkg/utils"
+)
+
+func TestApplicationControls(t *testing.T) {
+	application_service := &StubApplicationService{}
+	jwt_validator := &StubJwtValidator{}
+
+	mux := chi.NewRouter()
+	mux.Mount("/api/applications", routes.SetupApplicationRouter(application_service, jwt_validator))
+
+	type api_server struct {
+		http.Handler
+	}
+
+	api := api_server{
+		mux,
+	}
+
+	sid_cookie := &http.Cookie{
+		Name: "sid",
+		Value: "123",
+		Path: "/",
+		SameSite: http.SameSiteStrictMode,
+		MaxAge: 3600 * 24,
+		HttpOnly: true,
+		Secure: os.Getenv("STAGE") != "local",
+	}
+
+	mock_user_id := "9ae9a0b2-d09e-4dcf-a0b1-18316fcef6cc"
+	expected_app_id := "7aaa1bf8-437f-4f3c-8691-8316fc6fbe50"
+
+	t.Run("should return status code 401 if not logged in", func (t *testing.T) {
+		defer func() {
+			application_service.Clear()
+		}()
+
+		req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("/api/applications/%s/stop", expected_app_id), nil)
+		res := httptest.NewRecorder()
+
+		api.ServeHTTP(res, req)
+
+		got_status := res.Result().StatusCode
+		if got_status != http.StatusUnauthorized {
+			t.Errorf("got status code %d, want %d", got_status, http.StatusUnauthorized)
+		}
+		if len(application_service.control_calls) != 0 {
+			t.Errorf("got service called %d times, want 0", len(application_service.control_calls))
+		}
+	})
+
+	jwt_validator.validate_return = mock_user_id
+
+	for _, action := range []string{"stop", "start", "restart"} {
+		t.Run(fmt.Sprintf("should %s the application as the logged in user", action), func (t *testing.T) {
+			defer func() {
+				application_service.Clear()
+			}()
+
+			desired_state := "running"
+			if action == "stop" {
+				desired_state = "stopped"
+			}
+			application_service.control_return = &dto.ApplicationControlResponse{
+				AppID: expected_app_id,
+				DesiredState: desired_state,
+				DesiredStateUpdatedBy: mock_user_id,
+			}
+
+			req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("/api/applications/%s/%s", expected_app_id, action), nil)
+			req.AddCookie(sid_cookie)
+
+			res := httptest.NewRecorder()
+			api.ServeHTTP(res, req)
+
+			got_status := res.Result().StatusCode
+			if got_status != http.StatusOK {
+				t.Fatalf("got status code %d, want %d", got_status, http.StatusOK)
+			}
+			if len(application_service.control_calls) != 1 || application_service.control_calls[0] != action {
+				t.Fatalf("got service calls %v, want [%s]", application_service.control_calls, action)
+			}
+			if got := application_service.control_calls_user_id[0]; got != mock_user_id {
+				t.Errorf("got service called with user id %s, want %s", got, mock_user_id)
+			}
+
+			var got_body utils.BaseResponse[any]
+			json.NewDecoder(res.Result().Body).Decode(&got_body)
+
+			got_data, _ := got_body.Data.(map[string]any)
+			if got_data["desired_state"] != desired_state || got_data["desired_state_updated_by"] != mock_user_id {
+				t.Errorf("got data %v, want desired state %s set by the user", got_data, desired_state)
+			}
+		})
+	}
+
+	t.Run("should map service errors to status codes", func (t *testing.T) {
+		tests := []struct {
+			err error
+			status int
+		}{
+			{errors.New("permission_denied"), http.StatusForbidden},
+			{errors.New("not_found"), http.StatusNotFound},
+			{errors.New("no_deployment"), http.StatusConflict},
+			{errors.New("transition_conflict"), http.StatusConflict},
+			{errors.New("boom"), http.StatusInternalServerError},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.err.Error(), func (t *testing.T) {
+				defer func() {
+					application_service.Clear()
+				}()
+
+				application_service.control_err = tt.err
+
+				req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("/api/applications/%s/restart", expected_app_id), nil)
+				req.AddCookie(sid_cookie)
+
+				res := httptest.NewRecorder()
+				api.ServeHTTP(res, req)
+
+				got_status := res.Result().StatusCode
+				if got_status != tt.status {
+					t.Errorf("got status code %d, want %d", got_status, tt.status)
+				}
+			})
+		}
+	})
+}
+
+func TestUpdateApplicationHealthCheck(t *testing.T) {
+	application_service := &StubApplicationService{}
+	jwt_validator := &StubJwtValidator{}
+
+	mux := chi.NewRouter()
+	mux.Mount("/api/applications", routes.SetupApplicationRouter(application_service, jwt_validator))
+
+	type api_server struct {
+		http.Handler
+	}
+
+	api := api_server{
+		mux,
+	}
+
+	sid_cookie := &http.Cookie{
+		Name: "sid",
+		Value: "123",
+		Path: "/",
+		SameSite: http.SameSiteStrictMode,
+		MaxAge: 3600 * 24,
+		HttpOnly: true,
+		Secure: os.Getenv("STAGE") != "local",
+	}
+
+	expected_app_id := "7aaa1bf8-437f-4f3c-8691-8316fc6fbe50"
+	url := fmt.Sprintf("/api/applications/%s/health-check", expected_app_id)
+	jwt_validator.validate_return = "9ae9a0b2-d09e-4dcf-a0b1-18316fcef6cc"
+
+	t.Run("should return status code 400 when validation failed", func (t *testing.T) {
+		tests := []struct {
+			desc string
+			body string
+		}{
+			{"unknown type", `{"type": "grpc", "interval_seconds": 10, "timeout_seconds": 2, "failure_threshold": 3}`},
+			{"relative path", `{"type": "http", "path": "healthz", "interval_seconds": 10, "timeout_seconds": 2, "failure_threshold": 3}`},
+			{"zero interval", `{"type": "tcp", "interval_seconds": 0, "timeout_seconds": 2, "failure_threshold": 3}`},
+			{"timeout above interval", `{"type": "tcp", "interval_seconds": 5, "timeout_seconds": 10, "failure_threshold": 3}`},
+			{"zero threshold", `{"type": "tcp", "interval_seconds": 10, "timeout_seconds": 2, "failure_threshold": 0}`},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.desc, func (t *testing.T) {
+				defer func() {
+					application_service.Clear()
+				}()
+
+				req, _ := http.NewRequest(http.MethodPut, url, strings.NewReader(tt.body))
+				req.AddCookie(sid_cookie)
+
+				res := httptest.NewRecorder()
+				api.ServeHTTP(res, req)
+
+				got_status := res.Result().StatusCode
+				if got_status != http.StatusBadRequest {
+					t.Errorf("got status code %d, want %d", got_status, http.StatusBadRequest)
+				}
+				if len(application_service.update_health_check_calls_arg3) != 0 {
+					t.Errorf("got service called, want no call")
+				}
+			})
+		}
+	})
+
+	t.Run("should pass the health check settings to the service", func (t *testing.T) {
+		defer func() {
+			application_service.Clear()
+		}()
+
+		application_service.update_health_check_return = &database.Application{HealthCheckType: "http"}
+
+		req, _ := http.NewRequest(
+			http.MethodPut,
+			url,
+			strings.NewReader(`{"type": "http", "path": "/healthz", "interval_seconds": 15, "timeout_seconds": 3, "failure_threshold": 4}`),
+		)
+		req.AddCookie(sid_cookie)
+
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+
+		got_status := res.Result().StatusCode
+		if got_status != http.StatusOK {
+			t.Fatalf("got status code %d, want %d", got_status, http.StatusOK)
+		}
+
+		want := dto.UpdateApplicationHealthCheckDto{Type: "http", Path: "/healthz", IntervalSeconds: 15, TimeoutSeconds: 3, FailureThreshold: 4}
+		if got := application_service.update_health_check_calls_arg3[0]; got != want {
+			t.Errorf("got service called with %+v, want %+v", got, want)
+		}
+	})
+}
+
+func TestUpdateApplicationResources(t *testing.T) {
+	application_service := &StubApplicationService{}
+	jwt_validator := &StubJwtValidator{}
+
+	mux := chi.NewRouter()
+	mux.Mount("/api/applications", routes.SetupApplicationRouter(application_service, jwt_validator))
+
+	type api_server struct {
+		http.Handler
+	}
+
+	api := api_server{
+		mux,
+	}
+
+	sid_cookie := &http.Cookie{
+		Name: "sid",
+		Value: "123",
+		Path: "/",
+		SameSite: http.SameSiteStrictMode,
+		MaxAge: 3600 * 24,
+		HttpOnly: true,
+		Secure: os.Getenv("STAGE") != "local",
+	}
+
+	expected_app_id := "7aaa1bf8-437f-4f3c-8691-8316fc6fbe50"
+	url := fmt.Sprintf("/api/applications/%s/resources", expected_app_id)
+	jwt_validator.validate_return = "9ae9a0b2-d09e-4dcf-a0b1-18316fcef6cc"
+
+	t.Run("should return status code 400 when validation failed", func (t *testing.T) {
+		tests := []struct {
+			desc string
+			body string
+		}{
+			{"negative cpu", `{"cpu_millis": -1, "memory_mb": 256}`},
+			{"cpu below a hundredth of a core", `{"cpu_millis": 5, "memory_mb": 256}`},
+			{"memory below 16 MB", `{"cpu_millis": 500, "memory_mb": 8}`},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.desc, func (t *testing.T) {
+				defer func() {
+					application_service.Clear()
+				}()
+
+				req, _ := http.NewRequest(http.MethodPut, url, strings.NewReader(tt.body))
+				req.AddCookie(sid_cookie)
+
+				res := httptest.NewRecorder()
+				api.ServeHTTP(res, req)
+
+				got_status := res.Result().StatusCode
+				if got_status != http.StatusBadRequest {
+					t.Errorf("got status code %d, want %d", got_status, http.StatusBadRequest)
+				}
+				if len(application_service.update_resources_calls_arg3) != 0 {
+					t.Errorf("got service called, want no call")
+				}
+			})
+		}
+	})
+
+	t.Run("should pass the limits to the service", func (t *testing.T) {
+		defer func() {
+			application_service.Clear()
+		}()
+
+		application_service.update_resources_return = &database.Application{CpuMillis: 500, MemoryMb: 256}
+
+		req, _ := http.NewRequest(http.MethodPut, url, strings.NewReader(`{"cpu_millis": 500, "memory_mb": 256}`))
+		req.AddCookie(sid_cookie)
+
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+
+		got_status := res.Result().StatusCode
+		if got_status != http.StatusOK {
+			t.Fatalf("got status code %d, want %d", got_status, http.StatusOK)
+		}
+
+		want := dto.UpdateApplicationResourcesDto{CPUMillis: 500, MemoryMB: 256}
+		if got := application_service.update_resources_calls_arg3[0]; got != want {
+			t.Errorf("got service called with %+v, want %+v", got, want)
+		}
+
+		var got_body utils.BaseResponse[any]
+		json.NewDecoder(res.Result().Body).Decode(&got_body)
+
+		got_data, _ := got_body.Data.(map[string]any)
+		if got_data["memory_mb"] != float64(256) {
+			t.Errorf("got data %v, want the stored limits", got_data)
+		}
+	})
+
+	t.Run("should allow lifting the limits", func (t *testing.T) {
+		defer func() {
+			application_service.Clear()
+		}()
+
+		req, _ := http.NewRequest(http.MethodPut, url, strings.NewReader(`{"cpu_millis": 0, "memory_mb": 0}`))
+		req.AddCookie(sid_cookie)
+
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+
+		got_status := res.Result().StatusCode
+		if got_status != http.StatusOK {
+			t.Errorf("got status code %d, want %d", got_status, http.StatusOK)
+		}
+	})
+
+	t.Run("should return status code 403 for other projects' applications", func (t *testing.T) {
+		defer func() {
+			application_service.Clear()
+		}()
+
+		application_service.update_resources_err = errors.New("permission_denied")
+
+		req, _ := http.NewRequest(http.MethodPut, url, strings.NewReader(`{"cpu_millis": 500, "memory_mb": 256}`))
+		req.AddCookie(sid_cookie)
+
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+
+		got_status := res.Result().StatusCode
+		if got_status != http.StatusForbidden {
+			t.Errorf("got status code %d, want %d", got_status, http.StatusForbidden)
+		}
+	})
+}
@@ -0,0 +1,286 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/salmanrf/capybara-cloud/api/routes"
+	"github.com/salmanrf/capybara-cloud/internal/database"
+	"github.com/salmanrf/capybara-cloud/pkg/utils"
+)
+
+func TestFindApplicationDeploymentLogs(t *testing.T) {
+	application_service := &StubApplicationService{}
+	jwt_validator := &StubJwtValidator{}
+
+	mux := chi.NewRouter()
+	mux.Mount("/api/applications", routes.SetupApplicationRouter(application_service, jwt_validator))
+
+	type api_server struct {
+		http.Handler
+	}
+
+	api := api_server{
+		mux,
+	}
+
+	sid_cookie := &http.Cookie{
+		Name: "sid",
+		Value: "123",
+		Path: "/",
+		SameSite: http.SameSiteStrictMode,
+		MaxAge: 3600 * 24,
+		HttpOnly: true,
+		Secure: os.Getenv("STAGE") != "local",
+	}
+
+	expected_app_id := "7aaa1bf8-437f-4f3c-8691-8316fc6fbe50"
+	expected_dp_id := "d1c0fd4b-5a2f-4f8e-9c0e-3a6f0f6b2c11"
+	url := fmt.Sprintf("/api/applications/%s/deployments/%s/logs", expected_app_id, expected_dp_id)
+	jwt_validator.validate_return = "9ae9a0b2-d09e-4dcf-a0b1-18316fcef6cc"
+
+	stored_logs := []database.ApplicationDeploymentLog{
+		{AppDpLogID: 1, Phase: "build", Stream: "stdout", Line: "installing"},
+		{AppDpLogID: 2, Phase: "run", Stream: "stdout", Line: "listening on 3000"},
+		{AppDpLogID: 3, Phase: "run", Stream: "stderr", Line: "oops"},
+	}
+
+	t.Run("should return a page of logs with the next cursor", func (t *testing.T) {
+		defer func() {
+			application_service.Clear()
+		}()
+
+		application_service.find_deployment_logs_return = stored_logs
+
+		req, _ := http.NewRequest(http.MethodGet, url+"?after=1&limit=1", nil)
+		req.AddCookie(sid_cookie)
+
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+
+		got_status := res.Result().StatusCode
+		if got_status != http.StatusOK {
+			t.Fatalf("got status code %d, want %d", got_status, http.StatusOK)
+		}
+
+		got_args := application_service.find_deployment_logs_calls_arg4[0]
+		if got_args.After != 1 || got_args.Limit != 1 {
+			t.Errorf("got service called with %+v, want after 1 and limit 1", got_args)
+		}
+
+		var got_body utils.BaseResponse[any]
+		json.NewDecoder(res.Result().Body).Decode(&got_body)
+
+		got_data, _ := got_body.Data.(map[string]any)
+		got_logs, _ := got_data["logs"].([]any)
+		if len(got_logs) != 1 {
+			t.Fatalf("got %d logs, want 1", len(got_logs))
+		}
+		if got_line := got_logs[0].(map[string]any)["line"]; got_line != "listening on 3000" {
+			t.Errorf("got line %v, want the line after the cursor", got_line)
+		}
+		if got_data["next_after"] != float64(2) {
+			t.Errorf("got next_after %v, want 2", got_data["next_after"])
+		}
+	})
+
+	t.Run("should return status code 400 on invalid pagination", func (t *testing.T) {
+		tests := []string{
+			"?limit=abc",
+			"?limit=0",
+			"?limit=5000",
+			"?after=-1",
+			"?after=abc",
+			"?process=web%20app",
+		}
+
+		for _, query := range tests {
+			t.Run(query, func (t *testing.T) {
+				defer func() {
+					application_service.Clear()
+				}()
+
+				req, _ := http.NewRequest(http.MethodGet, url+query, nil)
+				req.AddCookie(sid_cookie)
+
+				res := httptest.NewRecorder()
+				api.ServeHTTP(res, req)
+
+				got_status := res.Result().StatusCode
+				if got_status != http.StatusBadRequest {
+					t.Errorf("got status code %d, want %d", got_status, http.StatusBadRequest)
+				}
+				if application_service.find_deployment_logs_n_calls != 0 {
+					t.Errorf("got service called %d times, want 0", application_service.find_deployment_logs_n_calls)
+				}
+			})
+		}
+	})
+
+	t.Run("should map service errors to status codes", func (t *testing.T) {
+		tests := []struct {
+			query string
+			err error
+			status int
+		}{
+			{"", errors.New("permission_denied"), http.StatusForbidden},
+			{"", errors.New("not_found"), http.StatusNotFound},
+			{"?follow=true", errors.New("permission_denied"), http.StatusForbidden},
+			{"?follow=true", errors.New("not_found"), http.StatusNotFound},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.query+" "+tt.err.Error(), func (t *testing.T) {
+				defer func() {
+					application_service.Clear()
+				}()
+
+				application_service.find_deployment_logs_err = tt.err
+				application_service.follow_deployment_logs_err = tt.err
+
+				req, _ := http.NewRequest(http.MethodGet, url+tt.query, nil)
+				req.AddCookie(sid_cookie)
+
+				res := httptest.NewRecorder()
+				api.ServeHTTP(res, req)
+
+				got_status := res.Result().StatusCode
+				if got_status != tt.status {
+					t.Errorf("got status code %d, want %d", got_status, tt.status)
+				}
+			})
+		}
+	})
+
+	t.Run("should stream the backlog then live lines as server-sent events", func (t *testing.T) {
+		defer func() {
+			application_service.Clear()
+		}()
+
+		application_service.find_deployment_logs_return = stored_logs[:2]
+		live := make(chan database.ApplicationDeploymentLog, 2)
+		// line 2 was persisted while the backlog was read, it must not repeat
+		live <- stored_logs[1]
+		live <- stored_logs[2]
+		close(live)
+		application_service.follow_deployment_logs_return = live
+
+		req, _ := http.NewRequest(http.MethodGet, url+"?follow=true", nil)
+		req.AddCookie(sid_cookie)
+
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+
+		if got := res.Result().Header.Get("Content-Type"); got != "text/event-stream" {
+			t.Errorf("got content type %s, want text/event-stream", got)
+		}
+
+		got_body := res.Body.String()
+		for _, want := range []string{"id: 1\n", "id: 2\n", "id: 3\n", "event: end"} {
+			if !strings.Contains(got_body, want) {
+				t.Errorf("got body %q, want it to contain %q", got_body, want)
+			}
+		}
+		if strings.Count(got_body, "id: 2\n") != 1 {
+			t.Errorf("got body %q, want line 2 sent once", got_body)
+		}
+		if strings.Index(got_body, "id: 1\n") > strings.Index(got_body, "id: 3\n") {
+			t.Errorf("got body %q, want lines in id order", got_body)
+		}
+		if !application_service.follow_deployment_logs_unsubscribed {
+			t.Errorf("got follower left subscribed, want unsubscribed")
+		}
+	})
+
+	t.Run("should read back the lines a follower fell behind on", func (t *testing.T) {
+		defer func() {
+			application_service.Clear()
+		}()
+
+		application_service.find_deployment_logs_return = stored_logs[:1]
+		live := make(chan database.ApplicationDeploymentLog, 1)
+		// a line without an id stands for the ones the follower missed
+		live <- database.ApplicationDeploymentLog{}
+		close(live)
+		application_service.follow_deployment_logs_return = live
+
+		req, _ := http.NewRequest(http.MethodGet, url+"?follow=true", nil)
+		req.AddCookie(sid_cookie)
+
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+
+		// the backlog, the missed lines, then whatever is left at the end
+		got_reads := application_service.find_deployment_logs_calls_arg4
+		if len(got_reads) != 3 || got_reads[1].After != 1 || got_reads[2].After != 1 {
+			t.Errorf("got reads %+v, want the history read again after line 1", got_reads)
+		}
+		got_body := res.Body.String()
+		if strings.Contains(got_body, "id: 0\n") || strings.Count(got_body, "id: 1\n") != 1 {
+			t.Errorf("got body %q, want line 1 once and the marker kept off the stream", got_body)
+		}
+	})
+
+	t.Run("should only follow the lines of the requested process", func (t *testing.T) {
+		defer func() {
+			application_service.Clear()
+		}()
+
+		live := make(chan database.ApplicationDeploymentLog, 2)
+		live <- database.ApplicationDeploymentLog{AppDpLogID: 4, Phase: "run", Stream: "stdout", Line: "GET /", ProcessType: pgtype.Text{String: "web", Valid: true}}
+		live <- database.ApplicationDeploymentLog{AppDpLogID: 5, Phase: "run", Stream: "stdout", Line: "sent", ProcessType: pgtype.Text{String: "worker", Valid: true}}
+		close(live)
+		application_service.follow_deployment_logs_return = live
+
+		req, _ := http.NewRequest(http.MethodGet, url+"?follow=true&process=worker", nil)
+		req.AddCookie(sid_cookie)
+
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+
+		if got := application_service.find_deployment_logs_calls_arg4[0].Process; got != "worker" {
+			t.Errorf("got backlog read for process %q, want worker", got)
+		}
+		got_body := res.Body.String()
+		if strings.Contains(got_body, "id: 4\n") || !strings.Contains(got_body, "id: 5\n") {
+			t.Errorf("got body %q, want only the worker line", got_body)
+		}
+		if !strings.Contains(got_body, `"process":"worker"`) {
+			t.Errorf("got body %q, want lines tagged with their process", got_body)
+		}
+	})
+
+	t.Run("should resume from Last-Event-ID", func (t *testing.T) {
+		defer func() {
+			application_service.Clear()
+		}()
+
+		application_service.find_deployment_logs_return = stored_logs
+		live := make(chan database.ApplicationDeploymentLog)
+		close(live)
+		application_service.follow_deployment_logs_return = live
+
+		req, _ := http.NewRequest(http.MethodGet, url+"?follow=true", nil)
+		req.Header.Set("Last-Event-ID", "2")
+		req.AddCookie(sid_cookie)
+
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+
+		got_body := res.Body.String()
+		if strings.Contains(got_body, "id: 1\n") || strings.Contains(got_body, "id: 2\n") {
+			t.Errorf("got body %q, want lines up to 2 skipped", got_body)
+		}
+		if !strings.Contains(got_body, "id: 3\n") {
+			t.Errorf("got body %q, want line 3", got_body)
+		}
+	})
+}
@@ -0,0 +1,1018 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/salmanrf/capybara-cloud/api/routes"
+	"github.com/salmanrf/capybara-cloud/internal/database"
+	"github.com/salmanrf/capybara-cloud/internal/runtime"
+	"github.com/salmanrf/capybara-cloud/pkg/dto"
+	"github.com/salmanrf/capybara-cloud/pkg/utils"
+)
+
+func new_bundle_request(t *testing.T, url string, field string, file_name string, content []byte) *http.Request {
+	t.Helper()
+
+	body := bytes.NewBuffer([]byte{})
+	writer := multipart.NewWriter(body)
+
+	part, err := writer.CreateFormFile(field, file_name)
+	if err != nil {
+		t.Fatalf("got error creating multipart file %v, want nil", err)
+	}
+	part.Write(content)
+	writer.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, url, body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	return req
+}
+
+func new_git_request(url string, body string) *http.Request {
+	req, _ := http.NewRequest(http.MethodPost, url, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	return req
+}
+
+func TestCreateApplicationDeployment(t *testing.T) {
+	application_service := &StubApplicationService{}
+	jwt_validator := &StubJwtValidator{}
+
+	mux := chi.NewRouter()
+	mux.Mount("/api/applications", routes.SetupApplicationRouter(application_service, jwt_validator))
+
+	type api_server struct {
+		http.Handler
+	}
+
+	api := api_server{
+		mux,
+	}
+
+	sid_cookie := &http.Cookie{
+		Name: "sid",
+		Value: "123",
+		Path: "/",
+		SameSite: http.SameSiteStrictMode,
+		MaxAge: 3600 * 24,
+		HttpOnly: true,
+		Secure: os.Getenv("STAGE") != "local",
+	}
+
+	mock_user_id := "9ae9a0b2-d09e-4dcf-a0b1-18316fcef6cc"
+	expected_app_id := "7aaa1bf8-437f-4f3c-8691-8316fc6fbe50"
+	url := fmt.Sprintf("/api/applications/%s/deployments", expected_app_id)
+
+	t.Run("should return status code 401 if not logged in", func (t *testing.T) {
+		defer func() {
+			application_service.Clear()
+		}()
+
+		req := new_bundle_request(t, url, "bundle", "app.tar.gz", []byte("bundle"))
+		res := httptest.NewRecorder()
+
+		api.ServeHTTP(res, req)
+
+		got_status := res.Result().StatusCode
+		want_status := http.StatusUnauthorized
+
+		if got_status != want_status {
+			t.Errorf("got status code %d, want %d\n", got_status, want_status)
+		}
+	})
+
+	t.Run("should return status code 400/422 when validation failed", func (t *testing.T) {
+		jwt_validator.validate_return = mock_user_id
+
+		tests := []struct{
+			desc string
+			req func() *http.Request
+		}{
+			{
+				"non multipart body",
+				func() *http.Request {
+					req, _ := http.NewRequest(http.MethodPost, url, bytes.NewBuffer([]byte(`{"bundle": "foo"}`)))
+					req.Header.Set("Content-Type", "application/json")
+					return req
+				},
+			},
+			{
+				"missing bundle field",
+				func() *http.Request {
+					return new_bundle_request(t, url, "file", "app.tar.gz", []byte("bundle"))
+				},
+			},
+			{
+				"empty bundle",
+				func() *http.Request {
+					return new_bundle_request(t, url, "bundle", "app.tar.gz", []byte{})
+				},
+			},
+			{
+				"unsupported bundle extension",
+				func() *http.Request {
+					return new_bundle_request(t, url, "bundle", "app.rar", []byte("bundle"))
+				},
+			},
+			{
+				"command transport git url",
+				func() *http.Request {
+					return new_git_request(url, `{"git_url": "ext::sh -c touch% /tmp/pwned"}`)
+				},
+			},
+			{
+				"file git url",
+				func() *http.Request {
+					return new_git_request(url, `{"git_url": "file:///var/lib/capybara/artifacts/other-app.git"}`)
+				},
+			},
+			{
+				"unauthenticated git url",
+				func() *http.Request {
+					return new_git_request(url, `{"git_url": "http://example.com/app.git"}`)
+				},
+			},
+			{
+				"option-like git url",
+				func() *http.Request {
+					return new_git_request(url, `{"git_url": "--upload-pack=touch /tmp/pwned"}`)
+				},
+			},
+			{
+				"option-like git ref",
+				func() *http.Request {
+					return new_git_request(url, `{"git_url": "https://example.com/app.git", "git_ref": "--upload-pack=x"}`)
+				},
+			},
+			{
+				"git ref without a url",
+				func() *http.Request {
+					return new_git_request(url, `{"git_ref": "main"}`)
+				},
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(fmt.Sprintf("returns 400 on %s", tt.desc), func (t *testing.T) {
+				defer func() {
+					application_service.Clear()
+				}()
+
+				req := tt.req()
+				req.AddCookie(sid_cookie)
+
+				res := httptest.NewRecorder()
+				api.ServeHTTP(res, req)
+
+				got_status := res.Result().StatusCode
+				want_status := []int{http.StatusBadRequest, http.StatusUnprocessableEntity}
+				if slices.Index(want_status, got_status) == -1 {
+					t.Errorf("got status code %d, want %d\n", got_status, want_status)
+				}
+
+				got_service_called := application_service.create_deployment_n_calls
+				if got_service_called != 0 {
+					t.Errorf("got service called %d times, want 0", got_service_called)
+				}
+			})
+		}
+	})
+
+	t.Run("should map service errors to status codes", func (t *testing.T) {
+		tests := []struct{
+			err error
+			want_status int
+		}{
+			{errors.New("not_found"), http.StatusNotFound},
+			{errors.New("permission_denied"), http.StatusForbidden},
+			{errors.New("canary_in_progress"), http.StatusConflict},
+			{errors.New("disk full"), http.StatusInternalServerError},
+		}
+
+		jwt_validator.validate_return = mock_user_id
+
+		for _, tt := range tests {
+			t.Run(tt.err.Error(), func (t *testing.T) {
+				defer func() {
+					application_service.Clear()
+				}()
+
+				application_service.create_deployment_err = tt.err
+
+				req := new_bundle_request(t, url, "bundle", "app.tar.gz", []byte("bundle"))
+				req.AddCookie(sid_cookie)
+
+				res := httptest.NewRecorder()
+				api.ServeHTTP(res, req)
+
+				got_status := res.Result().StatusCode
+				if got_status != tt.want_status {
+					t.Errorf("got status code %d, want %d", got_status, tt.want_status)
+				}
+			})
+		}
+	})
+
+	t.Run("should return status code 400 with the reason on unsafe bundles", func (t *testing.T) {
+		defer func() {
+			application_service.Clear()
+		}()
+
+		jwt_validator.validate_return = mock_user_id
+		application_service.create_deployment_err = &runtime.BundleError{
+			Reason: runtime.BundleReasonPathTraversal,
+			Entry: "../evil.sh",
+			Message: "entry ../evil.sh escapes the bundle root",
+		}
+
+		req := new_bundle_request(t, url, "bundle", "app.tar.gz", []byte("bundle"))
+		req.AddCookie(sid_cookie)
+
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+
+		got_status := res.Result().StatusCode
+		if got_status != http.StatusBadRequest {
+			t.Fatalf("got status code %d, want %d", got_status, http.StatusBadRequest)
+		}
+
+		var got_body utils.BaseResponse[any]
+		if err := json.NewDecoder(res.Result().Body).Decode(&got_body); err != nil {
+			t.Fatalf("got error parsing response body %v, want nil", err)
+		}
+
+		if got_body.ErrorDetails == nil {
+			t.Fatalf("got no error details, want the bundle error")
+		}
+		got_context := got_body.ErrorDetails.Context
+		if got_context["reason"] != runtime.BundleReasonPathTraversal || got_context["entry"] != "../evil.sh" {
+			t.Errorf("got error context %v, want reason %s for ../evil.sh", got_context, runtime.BundleReasonPathTraversal)
+		}
+	})
+
+	t.Run("should return status code 413 on bundles past the upload limit", func (t *testing.T) {
+		jwt_validator.validate_return = mock_user_id
+
+		tests := []struct{
+			desc string
+			req func() *http.Request
+		}{
+			{
+				"multipart body",
+				func() *http.Request {
+					return new_bundle_request(t, url, "bundle", "app.tar.gz", bytes.Repeat([]byte("a"), 3 << 20))
+				},
+			},
+			{
+				"json body",
+				func() *http.Request {
+					return new_git_request(url, `{"git_url": "`+strings.Repeat("a", 3 << 20)+`"}`)
+				},
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.desc, func (t *testing.T) {
+				defer func() {
+					application_service.Clear()
+				}()
+
+				application_service.max_upload_size_return = 1 << 20
+
+				req := tt.req()
+				req.AddCookie(sid_cookie)
+
+				res := httptest.NewRecorder()
+				api.ServeHTTP(res, req)
+
+				got_status := res.Result().StatusCode
+				if got_status != http.StatusRequestEntityTooLarge {
+					t.Errorf("got status code %d, want %d", got_status, http.StatusRequestEntityTooLarge)
+				}
+
+				if application_service.create_deployment_n_calls != 0 {
+					t.Errorf("got service called %d times, want 0", application_service.create_deployment_n_calls)
+				}
+			})
+		}
+	})
+
+	t.Run("should return status code 413 when the service finds the bundle too large", func (t *testing.T) {
+		defer func() {
+			application_service.Clear()
+		}()
+
+		jwt_validator.validate_return = mock_user_id
+		application_service.create_deployment_err = &runtime.BundleError{
+			Reason: runtime.BundleReasonUploadTooLarge,
+			Limit: 1 << 20,
+			Message: "larger than 1048576 bytes",
+		}
+
+		req := new_bundle_request(t, url, "bundle", "app.tar.gz", []byte("bundle"))
+		req.AddCookie(sid_cookie)
+
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+
+		got_status := res.Result().StatusCode
+		if got_status != http.StatusRequestEntityTooLarge {
+			t.Errorf("got status code %d, want %d", got_status, http.StatusRequestEntityTooLarge)
+		}
+	})
+
+	t.Run("should pass the git repository to the service and return 201", func (t *testing.T) {
+		defer func() {
+			application_service.Clear()
+		}()
+
+		jwt_validator.validate_return = mock_user_id
+		application_service.create_deployment_return = &database.ApplicationDeployment{
+			GitUrl: pgtype.Text{String: "https://example.com/capy/app.git", Valid: true},
+			GitRef: pgtype.Text{String: "v1.2.0", Valid: true},
+			GitCommitSha: pgtype.Text{String: "c3d41ac3afc106bef0cd4f3f78f05f465ef97caf", Valid: true},
+		}
+
+		req := new_git_request(url, `{"git_url": "https://example.com/capy/app.git", "git_ref": "v1.2.0"}`)
+		req.AddCookie(sid_cookie)
+
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+
+		got_status := res.Result().StatusCode
+		if got_status != http.StatusCreated {
+			t.Fatalf("got status code %d, want %d", got_status, http.StatusCreated)
+		}
+
+		if application_service.create_deployment_n_calls != 1 {
+			t.Fatalf("got service called %d times, want 1", application_service.create_deployment_n_calls)
+		}
+		got_dto := application_service.create_deployment_calls_arg3[0]
+		if got_dto.GitURL != "https://example.com/capy/app.git" || got_dto.GitRef != "v1.2.0" || got_dto.Bundle != nil {
+			t.Errorf("got dto %+v, want the git repository at v1.2.0 and no bundle", got_dto)
+		}
+
+		var got_body utils.BaseResponse[any]
+		if err := json.NewDecoder(res.Result().Body).Decode(&got_body); err != nil {
+			t.Fatalf("got error parsing response body %v, want nil", err)
+		}
+		got_data, _ := got_body.Data.(map[string]any)
+		if got_data["git_commit_sha"] != "c3d41ac3afc106bef0cd4f3f78f05f465ef97caf" {
+			t.Errorf("got git_commit_sha %v, want the resolved commit", got_data["git_commit_sha"])
+		}
+	})
+
+	t.Run("should accept file git urls when the operator allows them", func (t *testing.T) {
+		defer func() {
+			application_service.Clear()
+		}()
+		t.Setenv("GIT_ALLOW_FILE_URLS", "true")
+
+		jwt_validator.validate_return = mock_user_id
+		application_service.create_deployment_return = &database.ApplicationDeployment{}
+
+		req := new_git_request(url, `{"git_url": "file:///srv/git/app.git"}`)
+		req.AddCookie(sid_cookie)
+
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+
+		if got_status := res.Result().StatusCode; got_status != http.StatusCreated {
+			t.Fatalf("got status code %d, want %d", got_status, http.StatusCreated)
+		}
+	})
+
+	t.Run("should pass the uploaded bundle to the service and return 201", func (t *testing.T) {
+		defer func() {
+			application_service.Clear()
+		}()
+
+		jwt_validator.validate_return = mock_user_id
+
+		expected_dp_id := "d1c0fd4b-5a2f-4f8e-9c0e-3a6f0f6b2c11"
+		expected_dp_uuid := pgtype.UUID{}
+		expected_dp_uuid.Scan(expected_dp_id)
+		expected_bundle := []byte("not really a tarball")
+
+		application_service.create_deployment_return = &database.ApplicationDeployment{
+			AppDpID: expected_dp_uuid,
+			VariablesSnapshotJson: []byte(`{"foo": "bar"}`),
+		}
+
+		req := new_bundle_request(t, url, "bundle", "app.tar.gz", expected_bundle)
+		req.AddCookie(sid_cookie)
+
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+
+		got_status := res.Result().StatusCode
+		want_status := http.StatusCreated
+		if got_status != want_status {
+			t.Fatalf("got status code %d, want %d", got_status, want_status)
+		}
+
+		if application_service.create_deployment_n_calls != 1 {
+			t.Fatalf("got service called %d times, want 1", application_service.create_deployment_n_calls)
+		}
+
+		if got_app_id := application_service.create_deployment_calls_arg1[0]; got_app_id != expected_app_id {
+			t.Errorf("got service called with app id %s, want %s", got_app_id, expected_app_id)
+		}
+		if got_user_id := application_service.create_deployment_calls_arg2[0]; got_user_id != mock_user_id {
+			t.Errorf("got service called with user id %s, want %s", got_user_id, mock_user_id)
+		}
+
+		got_dto := application_service.create_deployment_calls_arg3[0]
+		if got_dto.BundleName != "app.tar.gz" {
+			t.Errorf("got bundle name %s, want app.tar.gz", got_dto.BundleName)
+		}
+
+		decoder := json.NewDecoder(res.Result().Body)
+		var got_body utils.BaseResponse[any]
+		if err := decoder.Decode(&got_body); err != nil {
+			t.Fatalf("got error parsing response body %v, want nil", err)
+		}
+
+		got_data, _ := got_body.Data.(map[string]any)
+		if got_data["app_dp_id"] != expected_dp_id {
+			t.Errorf("got app_dp_id %v, want %s", got_data["app_dp_id"], expected_dp_id)
+		}
+
+		got_snapshot, _ := got_data["variables_snapshot"].(map[string]any)
+		if got_snapshot["foo"] != "bar" {
+			t.Errorf("got variables_snapshot %v, want foo=bar", got_snapshot)
+		}
+	})
+}
+
+func TestFindApplicationDeployments(t *testing.T) {
+	application_service := &StubApplicationService{}
+	jwt_validator := &StubJwtValidator{}
+
+	mux := chi.NewRouter()
+	mux.Mount("/api/applications", routes.SetupApplicationRouter(application_service, jwt_validator))
+
+	type api_server struct {
+		http.Handler
+	}
+
+	api := api_server{
+		mux,
+	}
+
+	sid_cookie := &http.Cookie{
+		Name: "sid",
+		Value: "123",
+		Path: "/",
+		SameSite: http.SameSiteStrictMode,
+		MaxAge: 3600 * 24,
+		HttpOnly: true,
+		Secure: os.Getenv("STAGE") != "local",
+	}
+
+	expected_app_id := "7aaa1bf8-437f-4f3c-8691-8316fc6fbe50"
+	expected_dp_id := "d1c0fd4b-5a2f-4f8e-9c0e-3a6f0f6b2c11"
+	jwt_validator.validate_return = "9ae9a0b2-d09e-4dcf-a0b1-18316fcef6cc"
+
+	t.Run("should return the list of deployments", func (t *testing.T) {
+		defer func() {
+			application_service.Clear()
+		}()
+
+		dp_uuid := pgtype.UUID{}
+		dp_uuid.Scan(expected_dp_id)
+		application_service.find_deployments_return = []database.ApplicationDeployment{
+			{AppDpID: dp_uuid},
+		}
+
+		req, _ := http.NewRequest(
+			http.MethodGet,
+			fmt.Sprintf("/api/applications/%s/deployments", expected_app_id),
+			nil,
+		)
+		req.AddCookie(sid_cookie)
+
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+
+		got_status := res.Result().StatusCode
+		if got_status != http.StatusOK {
+			t.Fatalf("got status code %d, want %d", got_status, http.StatusOK)
+		}
+
+		body, _ := io.ReadAll(res.Result().Body)
+		var got_body utils.BaseResponse[any]
+		json.Unmarshal(body, &got_body)
+
+		got_data, _ := got_body.Data.([]any)
+		if len(got_data) != 1 {
+			t.Fatalf("got %d deployments, want 1", len(got_data))
+		}
+	})
+
+	t.Run("should return status code 403 on permission_denied errors", func (t *testing.T) {
+		defer func() {
+			application_service.Clear()
+		}()
+
+		application_service.find_deployments_err = errors.New("permission_denied")
+
+		req, _ := http.NewRequest(
+			http.MethodGet,
+			fmt.Sprintf("/api/applications/%s/deployments", expected_app_id),
+			nil,
+		)
+		req.AddCookie(sid_cookie)
+
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+
+		got_status := res.Result().StatusCode
+		if got_status != http.StatusForbidden {
+			t.Errorf("got status code %d, want %d", got_status, http.StatusForbidden)
+		}
+	})
+
+	t.Run("should return status code 404 when the deployment is not found", func (t *testing.T) {
+		defer func() {
+			application_service.Clear()
+		}()
+
+		application_service.find_one_deployment_err = errors.New("not_found")
+
+		req, _ := http.NewRequest(
+			http.MethodGet,
+			fmt.Sprintf("/api/applications/%s/deployments/%s", expected_app_id, expected_dp_id),
+			nil,
+		)
+		req.AddCookie(sid_cookie)
+
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+
+		got_status := res.Result().StatusCode
+		if got_status != http.StatusNotFound {
+			t.Errorf("got status code %d, want %d", got_status, http.StatusNotFound)
+		}
+	})
+
+	t.Run("should call service with app id and deployment id", func (t *testing.T) {
+		defer func() {
+			application_service.Clear()
+		}()
+
+		application_service.find_one_deployment_return = &dto.ApplicationDeploymentResponse{
+			AppDpID: expected_dp_id,
+			Status: "failed",
+			FailureReason: "start_command_not_found",
+			Transitions: []dto.ApplicationDeploymentTransitionResponse{
+				{ToStatus: "queued"},
+				{FromStatus: "queued", ToStatus: "extracting"},
+				{FromStatus: "extracting", ToStatus: "failed", Reason: "start_command_not_found"},
+			},
+		}
+
+		req, _ := http.NewRequest(
+			http.MethodGet,
+			fmt.Sprintf("/api/applications/%s/deployments/%s", expected_app_id, expected_dp_id),
+			nil,
+		)
+		req.AddCookie(sid_cookie)
+
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+
+		got_status := res.Result().StatusCode
+		if got_status != http.StatusOK {
+			t.Fatalf("got status code %d, want %d", got_status, http.StatusOK)
+		}
+
+		if got := application_service.find_one_deployment_calls_arg1[0]; got != expected_app_id {
+			t.Errorf("got service called with app id %s, want %s", got, expected_app_id)
+		}
+		if got := application_service.find_one_deployment_calls_arg2[0]; got != expected_dp_id {
+			t.Errorf("got service called with deployment id %s, want %s", got, expected_dp_id)
+		}
+
+		var got_body utils.BaseResponse[any]
+		json.NewDecoder(res.Result().Body).Decode(&got_body)
+
+		got_data, _ := got_body.Data.(map[string]any)
+		if got_data["status"] != "failed" || got_data["failure_reason"] != "start_command_not_found" {
+			t.Errorf("got status %v (%v), want failed with its reason", got_data["status"], got_data["failure_reason"])
+		}
+
+		got_transitions, _ := got_data["transitions"].([]any)
+		if len(got_transitions) != 3 {
+			t.Errorf("got %d transitions, want 3", len(got_transitions))
+		}
+	})
+}
+
+func TestRollbackApplicationDeployment(t *testing.T) {
+	application_service := &StubApplicationService{}
+	jwt_validator := &StubJwtValidator{}
+
+	mux := chi.NewRouter()
+	mux.Mount("/api/applications", routes.SetupApplicationRouter(application_service, jwt_validator))
+
+	type api_server struct {
+		http.Handler
+	}
+
+	api := api_server{
+		mux,
+	}
+
+	sid_cookie := &http.Cookie{
+		Name: "sid",
+		Value: "123",
+		Path: "/",
+		SameSite: http.SameSiteStrictMode,
+		MaxAge: 3600 * 24,
+		HttpOnly: true,
+		Secure: os.Getenv("STAGE") != "local",
+	}
+
+	expected_app_id := "7aaa1bf8-437f-4f3c-8691-8316fc6fbe50"
+	expected_dp_id := "d1c0fd4b-5a2f-4f8e-9c0e-3a6f0f6b2c11"
+	url := fmt.Sprintf("/api/applications/%s/deployments/%s/rollback", expected_app_id, expected_dp_id)
+	jwt_validator.validate_return = "9ae9a0b2-d09e-4dcf-a0b1-18316fcef6cc"
+
+	t.Run("should map service errors to status codes", func (t *testing.T) {
+		tests := []struct {
+			err error
+			status int
+		}{
+			{errors.New("permission_denied"), http.StatusForbidden},
+			{errors.New("not_found"), http.StatusNotFound},
+			{errors.New("invalid_rollback_target"), http.StatusConflict},
+			{errors.New("artifacts_not_found"), http.StatusConflict},
+			{errors.New("transition_conflict"), http.StatusConflict},
+			{errors.New("boom"), http.StatusInternalServerError},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.err.Error(), func (t *testing.T) {
+				defer func() {
+					application_service.Clear()
+				}()
+
+				application_service.rollback_deployment_err = tt.err
+
+				req, _ := http.NewRequest(http.MethodPost, url, nil)
+				req.AddCookie(sid_cookie)
+
+				res := httptest.NewRecorder()
+				api.ServeHTTP(res, req)
+
+				got_status := res.Result().StatusCode
+				if got_status != tt.status {
+					t.Errorf("got status code %d, want %d", got_status, tt.status)
+				}
+			})
+		}
+	})
+
+	t.Run("should return 201 with the new deployment", func (t *testing.T) {
+		defer func() {
+			application_service.Clear()
+		}()
+
+		new_dp_uuid := pgtype.UUID{}
+		new_dp_uuid.Scan("5f0c4a3e-8d9b-4c1a-9e2f-7b6a5d4c3b2a")
+		source_dp_uuid := pgtype.UUID{}
+		source_dp_uuid.Scan(expected_dp_id)
+		application_service.rollback_deployment_return = &database.ApplicationDeployment{
+			AppDpID: new_dp_uuid,
+			Status: "running",
+			RolledBackFrom: source_dp_uuid,
+		}
+
+		req, _ := http.NewRequest(http.MethodPost, url, nil)
+		req.AddCookie(sid_cookie)
+
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+
+		got_status := res.Result().StatusCode
+		if got_status != http.StatusCreated {
+			t.Fatalf("got status code %d, want %d", got_status, http.StatusCreated)
+		}
+		if got := application_service.rollback_deployment_calls_arg2[0]; got != expected_dp_id {
+			t.Errorf("got service called with deployment id %s, want %s", got, expected_dp_id)
+		}
+
+		var got_body utils.BaseResponse[any]
+		json.NewDecoder(res.Result().Body).Decode(&got_body)
+
+		got_data, _ := got_body.Data.(map[string]any)
+		if got_data["rolled_back_from"] != expected_dp_id {
+			t.Errorf("got rolled_back_from %v, want %s", got_data["rolled_back_from"], expected_dp_id)
+		}
+	})
+}
+
+func TestCancelApplicationDeployment(t *testing.T) {
+	application_service := &StubApplicationService{}
+	jwt_validator := &StubJwtValidator{}
+
+	mux := chi.NewRouter()
+	mux.Mount("/api/applications", routes.SetupApplicationRouter(application_service, jwt_validator))
+
+	type api_server struct {
+		http.Handler
+	}
+
+	api := api_server{
+		mux,
+	}
+
+	sid_cookie := &http.Cookie{
+		Name: "sid",
+		Value: "123",
+		Path: "/",
+		SameSite: http.SameSiteStrictMode,
+		MaxAge: 3600 * 24,
+		HttpOnly: true,
+		Secure: os.Getenv("STAGE") != "local",
+	}
+
+	expected_app_id := "7aaa1bf8-437f-4f3c-8691-8316fc6fbe50"
+	expected_dp_id := "d1c0fd4b-5a2f-4f8e-9c0e-3a6f0f6b2c11"
+	url := fmt.Sprintf("/api/applications/%s/deployments/%s/cancel", expected_app_id, expected_dp_id)
+
+	t.Run("should return status code 401 if not logged in", func (t *testing.T) {
+		defer func() {
+			application_service.Clear()
+		}()
+
+		req, _ := http.NewRequest(http.MethodPost, url, nil)
+		res := httptest.NewRecorder()
+
+		api.ServeHTTP(res, req)
+
+		got_status := res.Result().StatusCode
+		if got_status != http.StatusUnauthorized {
+			t.Errorf("got status code %d, want %d", got_status, http.StatusUnauthorized)
+		}
+		if len(application_service.cancel_deployment_calls_arg2) != 0 {
+			t.Errorf("got service called, want no call")
+		}
+	})
+
+	jwt_validator.validate_return = "9ae9a0b2-d09e-4dcf-a0b1-18316fcef6cc"
+
+	t.Run("should map service errors to status codes", func (t *testing.T) {
+		tests := []struct {
+			err error
+			status int
+		}{
+			{errors.New("permission_denied"), http.StatusForbidden},
+			{errors.New("not_found"), http.StatusNotFound},
+			{errors.New("invalid_cancel_target"), http.StatusConflict},
+			{errors.New("transition_conflict"), http.StatusConflict},
+			{errors.New("boom"), http.StatusInternalServerError},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.err.Error(), func (t *testing.T) {
+				defer func() {
+					application_service.Clear()
+				}()
+
+				application_service.cancel_deployment_err = tt.err
+
+				req, _ := http.NewRequest(http.MethodPost, url, nil)
+				req.AddCookie(sid_cookie)
+
+				res := httptest.NewRecorder()
+				api.ServeHTTP(res, req)
+
+				got_status := res.Result().StatusCode
+				if got_status != tt.status {
+					t.Errorf("got status code %d, want %d", got_status, tt.status)
+				}
+			})
+		}
+	})
+
+	t.Run("should return 202 with the deployment", func (t *testing.T) {
+		defer func() {
+			application_service.Clear()
+		}()
+
+		dp_uuid := pgtype.UUID{}
+		dp_uuid.Scan(expected_dp_id)
+		application_service.cancel_deployment_return = &database.ApplicationDeployment{
+			AppDpID: dp_uuid,
+			Status: "cancelled",
+		}
+
+		req, _ := http.NewRequest(http.MethodPost, url, nil)
+		req.AddCookie(sid_cookie)
+
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+
+		got_status := res.Result().StatusCode
+		if got_status != http.StatusAccepted {
+			t.Fatalf("got status code %d, want %d", got_status, http.StatusAccepted)
+		}
+		if got := application_service.cancel_deployment_calls_arg2[0]; got != expected_dp_id {
+			t.Errorf("got service called with deployment id %s, want %s", got, expected_dp_id)
+		}
+
+		var got_body utils.BaseResponse[any]
+		json.NewDecoder(res.Result().Body).Decode(&got_body)
+
+		got_data, _ := got_body.Data.(map[string]any)
+		if got_data["status"] != "cancelled" {
+			t.Errorf("got status %v, want cancelled", got_data["status"])
+		}
+	})
+}
+
+func TestApplicationDeploymentCanary(t *testing.T) {
+	application_service := &StubApplicationService{}
+	jwt_validator := &StubJwtValidator{}
+
+	mux := chi.NewRouter()
+	mux.Mount("/api/applications", routes.SetupApplicationRouter(application_service, jwt_validator))
+
+	type api_server struct {
+		http.Handler
+	}
+
+	api := api_server{
+		mux,
+	}
+
+	sid_cookie := &http.Cookie{
+		Name: "sid",
+		Value: "123",
+		Path: "/",
+		SameSite: http.SameSiteStrictMode,
+		MaxAge: 3600 * 24,
+		HttpOnly: true,
+		Secure: os.Getenv("STAGE") != "local",
+	}
+
+	expected_app_id := "7aaa1bf8-437f-4f3c-8691-8316fc6fbe50"
+	expected_dp_id := "d1c0fd4b-5a2f-4f8e-9c0e-3a6f0f6b2c11"
+	url := fmt.Sprintf("/api/applications/%s/deployments/%s/canary", expected_app_id, expected_dp_id)
+
+	new_request := func(action string) *http.Request {
+		if action == "update" {
+			req, _ := http.NewRequest(http.MethodPut, url, bytes.NewBufferString(`{"canary_weight": 25, "canary_sticky": true}`))
+			req.Header.Set("Content-Type", "application/json")
+			return req
+		}
+
+		req, _ := http.NewRequest(http.MethodPost, url+"/"+action, nil)
+		return req
+	}
+
+	actions := []string{"update", "promote", "abort"}
+
+	t.Run("should return status code 401 if not logged in", func (t *testing.T) {
+		for _, action := range actions {
+			application_service.Clear()
+
+			res := httptest.NewRecorder()
+			api.ServeHTTP(res, new_request(action))
+
+			got_status := res.Result().StatusCode
+			if got_status != http.StatusUnauthorized {
+				t.Errorf("got status code %d on %s, want %d", got_status, action, http.StatusUnauthorized)
+			}
+			if len(application_service.canary_calls) != 0 {
+				t.Errorf("got service called on %s, want no call", action)
+			}
+		}
+	})
+
+	jwt_validator.validate_return = "9ae9a0b2-d09e-4dcf-a0b1-18316fcef6cc"
+
+	t.Run("should return status code 400 when the weight is out of range", func (t *testing.T) {
+		defer func() {
+			application_service.Clear()
+		}()
+
+		req, _ := http.NewRequest(http.MethodPut, url, bytes.NewBufferString(`{"canary_weight": 101}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.AddCookie(sid_cookie)
+
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+
+		got_status := res.Result().StatusCode
+		if got_status != http.StatusBadRequest {
+			t.Errorf("got status code %d, want %d", got_status, http.StatusBadRequest)
+		}
+		if len(application_service.canary_calls) != 0 {
+			t.Errorf("got service called, want no call")
+		}
+	})
+
+	t.Run("should map service errors to status codes", func (t *testing.T) {
+		tests := []struct {
+			err error
+			status int
+		}{
+			{errors.New("permission_denied"), http.StatusForbidden},
+			{errors.New("not_found"), http.StatusNotFound},
+			{errors.New("invalid_canary_target"), http.StatusConflict},
+			{errors.New("transition_conflict"), http.StatusConflict},
+			{errors.New("boom"), http.StatusInternalServerError},
+		}
+
+		for _, action := range actions {
+			for _, tt := range tests {
+				t.Run(action+" "+tt.err.Error(), func (t *testing.T) {
+					defer func() {
+						application_service.Clear()
+					}()
+
+					application_service.canary_err = tt.err
+
+					req := new_request(action)
+					req.AddCookie(sid_cookie)
+
+					res := httptest.NewRecorder()
+					api.ServeHTTP(res, req)
+
+					got_status := res.Result().StatusCode
+					if got_status != tt.status {
+						t.Errorf("got status code %d, want %d", got_status, tt.status)
+					}
+				})
+			}
+		}
+	})
+
+	t.Run("should return 200 with the deployment", func (t *testing.T) {
+		dp_uuid := pgtype.UUID{}
+		dp_uuid.Scan(expected_dp_id)
+
+		for _, action := range actions {
+			t.Run(action, func (t *testing.T) {
+				defer func() {
+					application_service.Clear()
+				}()
+
+				application_service.canary_return = &database.ApplicationDeployment{
+					AppDpID: dp_uuid,
+					Status: "running",
+					CanaryWeight: pgtype.Int4{Int32: 25, Valid: true},
+				}
+
+				req := new_request(action)
+				req.AddCookie(sid_cookie)
+
+				res := httptest.NewRecorder()
+				api.ServeHTTP(res, req)
+
+				got_status := res.Result().StatusCode
+				if got_status != http.StatusOK {
+					t.Fatalf("got status code %d, want %d", got_status, http.StatusOK)
+				}
+				if !slices.Equal(application_service.canary_calls, []string{action}) {
+					t.Fatalf("got service calls %v, want %s", application_service.canary_calls, action)
+				}
+				if got := application_service.canary_calls_arg2[0]; got != expected_dp_id {
+					t.Errorf("got service called with deployment id %s, want %s", got, expected_dp_id)
+				}
+				if action == "update" {
+					got_dto := application_service.update_canary_calls_arg4[0]
+					if got_dto.CanaryWeight != 25 || !got_dto.CanarySticky {
+						t.Errorf("got dto %+v, want a sticky 25%% canary", got_dto)
+					}
+				}
+
+				var got_body utils.BaseResponse[any]
+				json.NewDecoder(res.Result().Body).Decode(&got_body)
+
+				got_data, _ := got_body.Data.(map[string]any)
+				if got_data["canary_weight"] != float64(25) {
+					t.Errorf("got canary_weight %v, want 25", got_data["canary_weight"])
+				}
+			})
+		}
+	})
+}
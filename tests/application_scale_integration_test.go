@@ -0,0 +1,177 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/salmanrf/capybara-cloud/api/routes"
+	"github.com/salmanrf/capybara-cloud/internal/database"
+	"github.com/salmanrf/capybara-cloud/pkg/utils"
+)
+
+func TestApplicationScale(t *testing.T) {
+	application_service := &StubApplicationService{}
+	jwt_validator := &StubJwtValidator{}
+
+	mux := chi.NewRouter()
+	mux.Mount("/api/applications", routes.SetupApplicationRouter(application_service, jwt_validator))
+
+	type api_server struct {
+		http.Handler
+	}
+
+	api := api_server{
+		mux,
+	}
+
+	sid_cookie := &http.Cookie{
+		Name: "sid",
+		Value: "123",
+		Path: "/",
+		SameSite: http.SameSiteStrictMode,
+		MaxAge: 3600 * 24,
+		HttpOnly: true,
+		Secure: os.Getenv("STAGE") != "local",
+	}
+
+	mock_user_id := "9ae9a0b2-d09e-4dcf-a0b1-18316fcef6cc"
+	expected_app_id := "7aaa1bf8-437f-4f3c-8691-8316fc6fbe50"
+	url := fmt.Sprintf("/api/applications/%s/scale", expected_app_id)
+
+	t.Run("should return status code 401 when scaling without logging in", func (t *testing.T) {
+		defer func() {
+			application_service.Clear()
+		}()
+
+		req, _ := http.NewRequest(http.MethodPut, url, strings.NewReader(`{"processes": {"worker": 2}}`))
+		res := httptest.NewRecorder()
+
+		api.ServeHTTP(res, req)
+
+		got_status := res.Result().StatusCode
+		if got_status != http.StatusUnauthorized {
+			t.Errorf("got status code %d, want %d", got_status, http.StatusUnauthorized)
+		}
+		if len(application_service.update_scale_calls_arg3) != 0 {
+			t.Errorf("got service called, want no call")
+		}
+	})
+
+	jwt_validator.validate_return = mock_user_id
+
+	t.Run("should return status code 400 when validation failed", func (t *testing.T) {
+		tests := []struct {
+			desc string
+			body string
+		}{
+			{"no process types", `{"processes": {}}`},
+			{"invalid name", `{"processes": {"queue worker": 1}}`},
+			{"web stopped", `{"processes": {"web": 0}}`},
+			{"too many web instances", `{"processes": {"web": 11}}`},
+			{"release", `{"processes": {"release": 1}}`},
+			{"negative scale", `{"processes": {"worker": -1}}`},
+			{"too many instances", `{"processes": {"worker": 11}}`},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.desc, func (t *testing.T) {
+				defer func() {
+					application_service.Clear()
+				}()
+
+				req, _ := http.NewRequest(http.MethodPut, url, strings.NewReader(tt.body))
+				req.AddCookie(sid_cookie)
+
+				res := httptest.NewRecorder()
+				api.ServeHTTP(res, req)
+
+				got_status := res.Result().StatusCode
+				if got_status != http.StatusBadRequest {
+					t.Errorf("got status code %d, want %d", got_status, http.StatusBadRequest)
+				}
+				if len(application_service.update_scale_calls_arg3) != 0 {
+					t.Errorf("got service called, want no call")
+				}
+			})
+		}
+	})
+
+	t.Run("should map service errors to status codes", func (t *testing.T) {
+		tests := []struct {
+			err string
+			want_status int
+		}{
+			{"permission_denied", http.StatusForbidden},
+			{"not_found", http.StatusNotFound},
+			{"invalid_app_type", http.StatusConflict},
+			{"unexpected", http.StatusInternalServerError},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.err, func (t *testing.T) {
+				defer func() {
+					application_service.Clear()
+				}()
+
+				application_service.update_scale_err = errors.New(tt.err)
+
+				req, _ := http.NewRequest(http.MethodPut, url, strings.NewReader(`{"processes": {"worker": 2}}`))
+				req.AddCookie(sid_cookie)
+
+				res := httptest.NewRecorder()
+				api.ServeHTTP(res, req)
+
+				got_status := res.Result().StatusCode
+				if got_status != tt.want_status {
+					t.Errorf("got status code %d, want %d", got_status, tt.want_status)
+				}
+			})
+		}
+	})
+
+	t.Run("should scale the process types and list the scales", func (t *testing.T) {
+		defer func() {
+			application_service.Clear()
+		}()
+
+		application_service.update_scale_return = []database.ApplicationProcessScale{
+			{ProcessType: "clock", Scale: 0},
+			{ProcessType: "worker", Scale: 2},
+		}
+
+		req, _ := http.NewRequest(http.MethodPut, url, strings.NewReader(`{"processes": {"web": 3, "worker": 2, "clock": 0}}`))
+		req.AddCookie(sid_cookie)
+
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+
+		got_status := res.Result().StatusCode
+		if got_status != http.StatusOK {
+			t.Fatalf("got status code %d, want %d", got_status, http.StatusOK)
+		}
+
+		want := map[string]int32{"web": 3, "worker": 2, "clock": 0}
+		if got := application_service.update_scale_calls_arg3[0].Processes; !maps.Equal(got, want) {
+			t.Errorf("got service called with %v, want %v", got, want)
+		}
+
+		var got_body utils.BaseResponse[any]
+		json.NewDecoder(res.Result().Body).Decode(&got_body)
+
+		got_data, _ := got_body.Data.([]any)
+		if len(got_data) != 2 {
+			t.Fatalf("got data %v, want both scales", got_body.Data)
+		}
+		if got, _ := got_data[1].(map[string]any); got["process_type"] != "worker" || got["scale"] != float64(2) {
+			t.Errorf("got scale %v, want worker at 2", got)
+		}
+	})
+}
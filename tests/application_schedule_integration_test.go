@@ -0,0 +1,269 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/salmanrf/capybara-cloud/api/routes"
+	"github.com/salmanrf/capybara-cloud/internal/database"
+	"github.com/salmanrf/capybara-cloud/pkg/dto"
+	"github.com/salmanrf/capybara-cloud/pkg/utils"
+)
+
+func TestApplicationSchedule(t *testing.T) {
+	application_service := &StubApplicationService{}
+	jwt_validator := &StubJwtValidator{}
+
+	mux := chi.NewRouter()
+	mux.Mount("/api/applications", routes.SetupApplicationRouter(application_service, jwt_validator))
+
+	type api_server struct {
+		http.Handler
+	}
+
+	api := api_server{
+		mux,
+	}
+
+	sid_cookie := &http.Cookie{
+		Name: "sid",
+		Value: "123",
+		Path: "/",
+		SameSite: http.SameSiteStrictMode,
+		MaxAge: 3600 * 24,
+		HttpOnly: true,
+		Secure: os.Getenv("STAGE") != "local",
+	}
+
+	mock_user_id := "9ae9a0b2-d09e-4dcf-a0b1-18316fcef6cc"
+	expected_app_id := "7aaa1bf8-437f-4f3c-8691-8316fc6fbe50"
+	expected_run_id := "5f0c4a3e-8d9b-4c1a-9e2f-7b6a5d4c3b2a"
+	url := fmt.Sprintf("/api/applications/%s", expected_app_id)
+
+	t.Run("should return status code 401 when scheduling without logging in", func (t *testing.T) {
+		defer func() {
+			application_service.Clear()
+		}()
+
+		req, _ := http.NewRequest(http.MethodPut, url+"/schedule", strings.NewReader(`{"cron_expression": "@daily", "command": "node report.js"}`))
+		res := httptest.NewRecorder()
+
+		api.ServeHTTP(res, req)
+
+		got_status := res.Result().StatusCode
+		if got_status != http.StatusUnauthorized {
+			t.Errorf("got status code %d, want %d", got_status, http.StatusUnauthorized)
+		}
+		if len(application_service.update_schedule_calls_arg3) != 0 {
+			t.Errorf("got service called, want no call")
+		}
+	})
+
+	jwt_validator.validate_return = mock_user_id
+
+	t.Run("should return status code 400 when validation failed", func (t *testing.T) {
+		tests := []struct {
+			desc string
+			body string
+		}{
+			{"missing expression", `{"command": "node report.js"}`},
+			{"out of range minute", `{"cron_expression": "61 * * * *", "command": "node report.js"}`},
+			{"never firing", `{"cron_expression": "0 0 31 2 *", "command": "node report.js"}`},
+			{"blank command", `{"cron_expression": "@daily", "command": "  "}`},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.desc, func (t *testing.T) {
+				defer func() {
+					application_service.Clear()
+				}()
+
+				req, _ := http.NewRequest(http.MethodPut, url+"/schedule", strings.NewReader(tt.body))
+				req.AddCookie(sid_cookie)
+
+				res := httptest.NewRecorder()
+				api.ServeHTTP(res, req)
+
+				got_status := res.Result().StatusCode
+				if got_status != http.StatusBadRequest {
+					t.Errorf("got status code %d, want %d", got_status, http.StatusBadRequest)
+				}
+				if len(application_service.update_schedule_calls_arg3) != 0 {
+					t.Errorf("got service called, want no call")
+				}
+			})
+		}
+	})
+
+	t.Run("should map service errors to status codes", func (t *testing.T) {
+		tests := []struct {
+			err string
+			want_status int
+		}{
+			{"permission_denied", http.StatusForbidden},
+			{"not_found", http.StatusNotFound},
+			{"invalid_app_type", http.StatusConflict},
+			{"unexpected", http.StatusInternalServerError},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.err, func (t *testing.T) {
+				defer func() {
+					application_service.Clear()
+				}()
+
+				application_service.update_schedule_err = errors.New(tt.err)
+
+				req, _ := http.NewRequest(http.MethodPut, url+"/schedule", strings.NewReader(`{"cron_expression": "@daily", "command": "node report.js"}`))
+				req.AddCookie(sid_cookie)
+
+				res := httptest.NewRecorder()
+				api.ServeHTTP(res, req)
+
+				got_status := res.Result().StatusCode
+				if got_status != tt.want_status {
+					t.Errorf("got status code %d, want %d", got_status, tt.want_status)
+				}
+			})
+		}
+	})
+
+	t.Run("should update the schedule and show the next run", func (t *testing.T) {
+		defer func() {
+			application_service.Clear()
+		}()
+
+		next_run := time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)
+		application_service.update_schedule_return = &database.Application{
+			CronExpression: pgtype.Text{String: "@daily", Valid: true},
+			CronCommand: pgtype.Text{String: "node report.js", Valid: true},
+			CronNextRunAt: pgtype.Timestamp{Time: next_run, Valid: true},
+		}
+
+		req, _ := http.NewRequest(http.MethodPut, url+"/schedule", strings.NewReader(`{"cron_expression": "@daily", "command": " node report.js ", "allow_overlap": true}`))
+		req.AddCookie(sid_cookie)
+
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+
+		got_status := res.Result().StatusCode
+		if got_status != http.StatusOK {
+			t.Fatalf("got status code %d, want %d", got_status, http.StatusOK)
+		}
+
+		want := dto.UpdateApplicationScheduleDto{CronExpression: "@daily", Command: "node report.js", AllowOverlap: true}
+		if got := application_service.update_schedule_calls_arg3[0]; got != want {
+			t.Errorf("got service called with %+v, want %+v", got, want)
+		}
+
+		var got_body utils.BaseResponse[any]
+		json.NewDecoder(res.Result().Body).Decode(&got_body)
+
+		got_data, _ := got_body.Data.(map[string]any)
+		if got_data["next_run_at"] != next_run.Format(time.RFC3339) {
+			t.Errorf("got data %v, want the next run at %s", got_data, next_run.Format(time.RFC3339))
+		}
+	})
+
+	t.Run("should list recorded runs", func (t *testing.T) {
+		defer func() {
+			application_service.Clear()
+		}()
+
+		application_service.find_job_runs_return = []database.ApplicationJobRun{
+			{Status: "succeeded", Command: "node report.js", ExitCode: pgtype.Int4{Int32: 0, Valid: true}},
+			{Status: "skipped", Command: "node report.js", FailureReason: pgtype.Text{String: "previous run still running", Valid: true}},
+		}
+
+		req, _ := http.NewRequest(http.MethodGet, url+"/runs", nil)
+		req.AddCookie(sid_cookie)
+
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+
+		got_status := res.Result().StatusCode
+		if got_status != http.StatusOK {
+			t.Fatalf("got status code %d, want %d", got_status, http.StatusOK)
+		}
+
+		var got_body utils.BaseResponse[any]
+		json.NewDecoder(res.Result().Body).Decode(&got_body)
+
+		got_data, _ := got_body.Data.([]any)
+		if len(got_data) != 2 {
+			t.Fatalf("got data %v, want both runs", got_body.Data)
+		}
+		if got, _ := got_data[0].(map[string]any); got["exit_code"] != float64(0) {
+			t.Errorf("got run %v, want exit code 0", got)
+		}
+		if got, _ := got_data[1].(map[string]any); got["exit_code"] != nil || got["status"] != "skipped" {
+			t.Errorf("got run %v, want the skipped one without an exit code", got)
+		}
+	})
+
+	t.Run("should page the logs of a run", func (t *testing.T) {
+		defer func() {
+			application_service.Clear()
+		}()
+
+		application_service.find_job_run_logs_return = []database.ApplicationJobRunLog{
+			{AppRunLogID: 8, Stream: "stdout", Line: "sent 3 reports"},
+		}
+
+		req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/runs/%s/logs?after=7&limit=10", url, expected_run_id), nil)
+		req.AddCookie(sid_cookie)
+
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+
+		got_status := res.Result().StatusCode
+		if got_status != http.StatusOK {
+			t.Fatalf("got status code %d, want %d", got_status, http.StatusOK)
+		}
+
+		if got := application_service.find_job_run_logs_calls_arg2[0]; got != expected_run_id {
+			t.Errorf("got run %s, want %s", got, expected_run_id)
+		}
+		want := dto.FindApplicationDeploymentLogsDto{After: 7, Limit: 10}
+		if got := application_service.find_job_run_logs_calls_arg4[0]; got != want {
+			t.Errorf("got service called with %+v, want %+v", got, want)
+		}
+
+		var got_body utils.BaseResponse[any]
+		json.NewDecoder(res.Result().Body).Decode(&got_body)
+
+		got_data, _ := got_body.Data.(map[string]any)
+		if got_data["next_after"] != float64(8) {
+			t.Errorf("got data %v, want the id of the last line as next_after", got_data)
+		}
+	})
+
+	t.Run("should return status code 400 when the log cursor isn't a number", func (t *testing.T) {
+		defer func() {
+			application_service.Clear()
+		}()
+
+		req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/runs/%s/logs?after=last", url, expected_run_id), nil)
+		req.AddCookie(sid_cookie)
+
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+
+		got_status := res.Result().StatusCode
+		if got_status != http.StatusBadRequest {
+			t.Errorf("got status code %d, want %d", got_status, http.StatusBadRequest)
+		}
+		if len(application_service.find_job_run_logs_calls_arg4) != 0 {
+			t.Errorf("got service called, want no call")
+		}
+	})
+}
@@ -0,0 +1,257 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/salmanrf/capybara-cloud/api/routes"
+	"github.com/salmanrf/capybara-cloud/internal/database"
+	"github.com/salmanrf/capybara-cloud/pkg/dto"
+	"github.com/salmanrf/capybara-cloud/pkg/utils"
+)
+
+func TestApplicationWebhooks(t *testing.T) {
+	application_service := &StubApplicationService{}
+	jwt_validator := &StubJwtValidator{}
+
+	mux := chi.NewRouter()
+	mux.Mount("/api/applications", routes.SetupApplicationRouter(application_service, jwt_validator))
+
+	type api_server struct {
+		http.Handler
+	}
+
+	api := api_server{
+		mux,
+	}
+
+	sid_cookie := &http.Cookie{
+		Name: "sid",
+		Value: "123",
+		Path: "/",
+		SameSite: http.SameSiteStrictMode,
+		MaxAge: 3600 * 24,
+		HttpOnly: true,
+		Secure: os.Getenv("STAGE") != "local",
+	}
+
+	mock_user_id := "9ae9a0b2-d09e-4dcf-a0b1-18316fcef6cc"
+	expected_app_id := "7aaa1bf8-437f-4f3c-8691-8316fc6fbe50"
+	url := fmt.Sprintf("/api/applications/%s/webhook", expected_app_id)
+
+	t.Run("should return status code 401 when configuring without logging in", func (t *testing.T) {
+		defer func() {
+			application_service.Clear()
+		}()
+
+		req, _ := http.NewRequest(http.MethodPut, url, strings.NewReader(`{"git_url": "https://github.com/acme/shop.git", "branch": "main"}`))
+		res := httptest.NewRecorder()
+
+		api.ServeHTTP(res, req)
+
+		got_status := res.Result().StatusCode
+		if got_status != http.StatusUnauthorized {
+			t.Errorf("got status code %d, want %d", got_status, http.StatusUnauthorized)
+		}
+		if len(application_service.update_webhook_calls_arg3) != 0 {
+			t.Errorf("got service called, want no call")
+		}
+	})
+
+	jwt_validator.validate_return = mock_user_id
+
+	t.Run("should return status code 400 when validation failed", func (t *testing.T) {
+		tests := []struct {
+			desc string
+			body string
+		}{
+			{"missing url", `{"branch": "main"}`},
+			{"unsupported scheme", `{"git_url": "ext::sh -c touch% /tmp/pwned", "branch": "main"}`},
+			{"option branch", `{"git_url": "https://github.com/acme/shop.git", "branch": "-main"}`},
+			{"range branch", `{"git_url": "https://github.com/acme/shop.git", "branch": "main..dev"}`},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.desc, func (t *testing.T) {
+				defer func() {
+					application_service.Clear()
+				}()
+
+				req, _ := http.NewRequest(http.MethodPut, url, strings.NewReader(tt.body))
+				req.AddCookie(sid_cookie)
+
+				res := httptest.NewRecorder()
+				api.ServeHTTP(res, req)
+
+				got_status := res.Result().StatusCode
+				if got_status != http.StatusBadRequest {
+					t.Errorf("got status code %d, want %d", got_status, http.StatusBadRequest)
+				}
+				if len(application_service.update_webhook_calls_arg3) != 0 {
+					t.Errorf("got service called, want no call")
+				}
+			})
+		}
+	})
+
+	t.Run("should configure the webhook and show the generated secret", func (t *testing.T) {
+		defer func() {
+			application_service.Clear()
+		}()
+
+		application_service.update_webhook_return = &dto.ApplicationWebhookResponse{
+			AppID: expected_app_id,
+			GitURL: "https://github.com/acme/shop.git",
+			Branch: "main",
+			Secret: "generated",
+		}
+
+		req, _ := http.NewRequest(http.MethodPut, url, strings.NewReader(`{"git_url": "https://github.com/acme/shop.git", "branch": "main"}`))
+		req.AddCookie(sid_cookie)
+
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+
+		got_status := res.Result().StatusCode
+		if got_status != http.StatusOK {
+			t.Fatalf("got status code %d, want %d", got_status, http.StatusOK)
+		}
+
+		want := dto.UpdateApplicationWebhookDto{GitURL: "https://github.com/acme/shop.git", Branch: "main"}
+		if got := application_service.update_webhook_calls_arg3[0]; got != want {
+			t.Errorf("got service called with %+v, want %+v", got, want)
+		}
+
+		var got_body utils.BaseResponse[any]
+		json.NewDecoder(res.Result().Body).Decode(&got_body)
+
+		got_data, _ := got_body.Data.(map[string]any)
+		if got_data["secret"] != "generated" {
+			t.Errorf("got data %v, want the generated secret", got_data)
+		}
+	})
+
+	t.Run("should return status code 404 when no webhook is configured", func (t *testing.T) {
+		defer func() {
+			application_service.Clear()
+		}()
+
+		application_service.find_one_webhook_err = errors.New("not_found")
+
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		req.AddCookie(sid_cookie)
+
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+
+		got_status := res.Result().StatusCode
+		if got_status != http.StatusNotFound {
+			t.Errorf("got status code %d, want %d", got_status, http.StatusNotFound)
+		}
+	})
+
+	t.Run("should list recorded deliveries", func (t *testing.T) {
+		defer func() {
+			application_service.Clear()
+		}()
+
+		application_service.find_webhook_deliveries_return = []database.ApplicationWebhookDelivery{
+			{Provider: pgtype.Text{String: "github", Valid: true}, Result: dto.WebhookDeliveryDeployed, Verified: true},
+			{Provider: pgtype.Text{String: "github", Valid: true}, Result: dto.WebhookDeliveryRejected},
+		}
+
+		req, _ := http.NewRequest(http.MethodGet, url+"/deliveries", nil)
+		req.AddCookie(sid_cookie)
+
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+
+		got_status := res.Result().StatusCode
+		if got_status != http.StatusOK {
+			t.Fatalf("got status code %d, want %d", got_status, http.StatusOK)
+		}
+
+		var got_body utils.BaseResponse[any]
+		json.NewDecoder(res.Result().Body).Decode(&got_body)
+
+		got_data, _ := got_body.Data.([]any)
+		if len(got_data) != 2 {
+			t.Fatalf("got data %v, want both deliveries", got_body.Data)
+		}
+		if got, _ := got_data[1].(map[string]any); got["result"] != dto.WebhookDeliveryRejected {
+			t.Errorf("got delivery %v, want the rejected one second", got)
+		}
+	})
+
+	// git hosts deliver without a session
+	t.Run("should accept deliveries without logging in", func (t *testing.T) {
+		tests := []struct {
+			result string
+			want_status int
+		}{
+			{dto.WebhookDeliveryDeployed, http.StatusAccepted},
+			{dto.WebhookDeliveryIgnored, http.StatusOK},
+			{dto.WebhookDeliveryRejected, http.StatusUnauthorized},
+			{dto.WebhookDeliveryFailed, http.StatusInternalServerError},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.result, func (t *testing.T) {
+				defer func() {
+					application_service.Clear()
+				}()
+
+				application_service.receive_webhook_return = &database.ApplicationWebhookDelivery{Result: tt.result}
+
+				req, _ := http.NewRequest(http.MethodPost, url, strings.NewReader(`{"ref": "refs/heads/main"}`))
+				req.Header.Set("X-GitHub-Event", "push")
+				req.Header.Set("X-Hub-Signature-256", "sha256=abc")
+
+				res := httptest.NewRecorder()
+				api.ServeHTTP(res, req)
+
+				got_status := res.Result().StatusCode
+				if got_status != tt.want_status {
+					t.Errorf("got status code %d, want %d", got_status, tt.want_status)
+				}
+
+				if len(application_service.receive_webhook_calls_arg2) != 1 {
+					t.Fatalf("got service called %d times, want 1", len(application_service.receive_webhook_calls_arg2))
+				}
+				if got := application_service.receive_webhook_calls_arg1[0]; got != expected_app_id {
+					t.Errorf("got app id %s, want %s", got, expected_app_id)
+				}
+				got := application_service.receive_webhook_calls_arg2[0]
+				if string(got.Body) != `{"ref": "refs/heads/main"}` || got.Header.Get("X-Hub-Signature-256") != "sha256=abc" {
+					t.Errorf("got delivery %s %v, want the raw body and headers", got.Body, got.Header)
+				}
+			})
+		}
+	})
+
+	t.Run("should return status code 404 for apps without a webhook", func (t *testing.T) {
+		defer func() {
+			application_service.Clear()
+		}()
+
+		application_service.receive_webhook_err = errors.New("not_found")
+
+		req, _ := http.NewRequest(http.MethodPost, url, strings.NewReader(`{}`))
+		res := httptest.NewRecorder()
+
+		api.ServeHTTP(res, req)
+
+		got_status := res.Result().StatusCode
+		if got_status != http.StatusNotFound {
+			t.Errorf("got status code %d, want %d", got_status, http.StatusNotFound)
+		}
+	})
+}
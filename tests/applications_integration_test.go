@@ -209,6 +209,45 @@ func TestCreateApplication(t *testing.T) {
 		}
 	})
 
+	t.Run("should returns 409 error if the project has an application by this name", func (t *testing.T) {
+		defer func () {
+			application_service.Clear()
+		}()
+
+		application_service.create_err = errors.New("name_taken")
+
+		expected_project_uuid := pgtype.UUID{}
+		expected_project_uuid.Scan("28451bd5-0113-4ec6-9540-6646ae72a957")
+
+		jwt_validator.validate_return = "123"
+
+		req_body := bytes.NewBuffer([]byte(
+			fmt.Sprintf(
+				`
+					{
+						"project_id": "%s",
+						"type": "%s",
+						"name": "Sophia School"
+					}
+				`,
+				expected_project_uuid.String(),
+				dto.GetSupportedAppTypes()[0],
+			),
+		))
+		req, _ := http.NewRequest(http.MethodPost, "/api/applications", req_body)
+		res := httptest.NewRecorder()
+		req.AddCookie(sid_cookie)
+
+		api.ServeHTTP(res, req)
+
+		got_status_code := res.Result().StatusCode
+		want_status_code := http.StatusConflict
+
+		if got_status_code != want_status_code {
+			t.Errorf("got status code %d, want %d\n", got_status_code, want_status_code)
+		}
+	})
+
 	t.Run("should return status code 401 if not logged in", func (t *testing.T) {
 		req, _ := http.NewRequest(http.MethodPost, "/api/applications", nil)
 		res := httptest.NewRecorder()
@@ -311,6 +350,28 @@ func TestCreateApplication(t *testing.T) {
 				}
 				`,
 			},
+			{
+				"schedule of a worker",
+				`
+				{
+					"project_id": "28451bd5-0113-4ec6-9540-6646ae72a957",
+					"name": "Ada Computer Hardwares",
+					"type": "background_worker",
+					"schedule": {"cron_expression": "@daily", "command": "node report.js"}
+				}
+				`,
+			},
+			{
+				"invalid schedule of a cron job",
+				`
+				{
+					"project_id": "28451bd5-0113-4ec6-9540-6646ae72a957",
+					"name": "Ada Computer Hardwares",
+					"type": "cron_job",
+					"schedule": {"cron_expression": "@every 5m", "command": "node report.js"}
+				}
+				`,
+			},
 		}
 
 		for _, tt := range tests {
@@ -591,6 +652,34 @@ func TestUpdateApplication(t *testing.T) {
 			t.Errorf("got status code %d, want %d\n", got_status, want_status)
 		}
 	})
+
+	t.Run("should return status code 409 if the project has another application by the new name", func (t *testing.T) {
+		defer func() {
+			application_service.Clear()
+		}()
+
+		req_body := bytes.NewBuffer([]byte(`{"name": "Ada Hardware"}`))
+
+		application_service.update_err = errors.New("name_taken")
+
+		req, _ := http.NewRequest(
+			http.MethodPut, 
+			"/api/applications/7aaa1bf8-437f-4f3c-8691-8316fc6fbe50", 
+			req_body,
+		)
+		res := httptest.NewRecorder()
+
+		req.AddCookie(sid_cookie)
+
+		api.ServeHTTP(res, req)
+
+		got_status := res.Result().StatusCode
+		want_status := http.StatusConflict
+
+		if got_status != want_status {
+			t.Errorf("got status code %d, want %d\n", got_status, want_status)
+		}
+	})
 }
 
 func TestFindOneApplication(t *testing.T) {
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -418,6 +419,39 @@ func TestProjectUpdateOne(t *testing.T) {
 		}
 	})
 
+	t.Run("it should return status 400 if another project goes by the new name", func (t *testing.T) {
+		defer func() {
+			project_service.update_one_err = nil
+		}()
+
+		mock_project_id := "28451bd5-0113-4ec6-9540-6646ae72a957"
+		mock_project_uuid := pgtype.UUID{}
+		mock_project_uuid.Scan(mock_project_id)
+
+		project_service.find_by_id_and_role_return = &database.FindOneProjectByIdAndRoleRow{
+			ProjectID: mock_project_uuid,
+			Name: pgtype.Text{String: "Capybara", Valid: true},
+			Role: "owner",
+		}
+		project_service.update_one_err = errors.New(`ERROR: duplicate key value violates unique constraint "projects_slug_key" (SQLSTATE 23505)`)
+
+		payload := `{"name": "Tai Lung"}`
+		body := bytes.NewReader([]byte(payload))
+		req, _ := http.NewRequest(http.MethodPut, fmt.Sprintf("/api/projects/%s", mock_project_id), body)
+		res := httptest.NewRecorder()
+
+		req.AddCookie(sid_cookie)
+
+		server.ServeHTTP(res, req)
+
+		got_status := res.Result().StatusCode
+		want_status := http.StatusBadRequest
+		
+		if got_status != want_status {
+			t.Errorf("got status %d, want %d", got_status, want_status)
+		}
+	})
+
 	t.Run("it should return status 404 if project id not provided", func (t *testing.T) {
 		req, _ := http.NewRequest(http.MethodPut, "/api/projects/", nil)
 		res := httptest.NewRecorder()
@@ -1,10 +1,12 @@
 package tests
 
 import (
+	"context"
 	"time"
 
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/salmanrf/capybara-cloud/internal/database"
+	"github.com/salmanrf/capybara-cloud/internal/runtime"
 	"github.com/salmanrf/capybara-cloud/pkg/dto"
 )
 
@@ -157,6 +159,77 @@ type StubApplicationService struct {
 	find_one_config_n_calls int
 	find_one_config_return *dto.ApplicationConfigResponse
 	find_one_config_error error
+	create_deployment_n_calls int
+	create_deployment_calls_arg1 []string
+	create_deployment_calls_arg2 []string
+	create_deployment_calls_arg3 []dto.CreateApplicationDeploymentDto
+	create_deployment_return *database.ApplicationDeployment
+	create_deployment_err error
+	max_upload_size_return int64
+	find_deployments_n_calls int
+	find_deployments_return []database.ApplicationDeployment
+	find_deployments_err error
+	find_one_deployment_n_calls int
+	find_one_deployment_calls_arg1 []string
+	find_one_deployment_calls_arg2 []string
+	find_one_deployment_return *dto.ApplicationDeploymentResponse
+	find_one_deployment_err error
+	rollback_deployment_n_calls int
+	rollback_deployment_calls_arg2 []string
+	rollback_deployment_return *database.ApplicationDeployment
+	rollback_deployment_err error
+	update_health_check_calls_arg3 []dto.UpdateApplicationHealthCheckDto
+	update_health_check_return *database.Application
+	update_health_check_err error
+	update_resources_calls_arg3 []dto.UpdateApplicationResourcesDto
+	update_resources_return *database.Application
+	update_resources_err error
+	update_scale_calls_arg3 []dto.UpdateApplicationScaleDto
+	update_scale_return []database.ApplicationProcessScale
+	update_scale_err error
+	control_calls []string
+	control_calls_user_id []string
+	control_return *dto.ApplicationControlResponse
+	control_err error
+	find_deployment_logs_n_calls int
+	find_deployment_logs_calls_arg4 []dto.FindApplicationDeploymentLogsDto
+	find_deployment_logs_return []database.ApplicationDeploymentLog
+	find_deployment_logs_err error
+	follow_deployment_logs_n_calls int
+	follow_deployment_logs_return chan database.ApplicationDeploymentLog
+	follow_deployment_logs_err error
+	follow_deployment_logs_unsubscribed bool
+	find_ingress_routes_return []dto.IngressRoute
+	find_ingress_routes_err error
+	update_webhook_calls_arg3 []dto.UpdateApplicationWebhookDto
+	update_webhook_return *dto.ApplicationWebhookResponse
+	update_webhook_err error
+	find_one_webhook_return *dto.ApplicationWebhookResponse
+	find_one_webhook_err error
+	find_webhook_deliveries_return []database.ApplicationWebhookDelivery
+	find_webhook_deliveries_err error
+	receive_webhook_calls_arg1 []string
+	receive_webhook_calls_arg2 []dto.ReceiveApplicationWebhookDto
+	receive_webhook_return *database.ApplicationWebhookDelivery
+	receive_webhook_err error
+	cancel_deployment_calls_arg2 []string
+	cancel_deployment_return *database.ApplicationDeployment
+	cancel_deployment_err error
+	canary_calls []string
+	canary_calls_arg2 []string
+	update_canary_calls_arg4 []dto.UpdateApplicationDeploymentCanaryDto
+	canary_return *database.ApplicationDeployment
+	canary_err error
+	record_canary_traffic_calls_arg1 [][]dto.CanaryTraffic
+	update_schedule_calls_arg3 []dto.UpdateApplicationScheduleDto
+	update_schedule_return *database.Application
+	update_schedule_err error
+	find_job_runs_return []database.ApplicationJobRun
+	find_job_runs_err error
+	find_job_run_logs_calls_arg2 []string
+	find_job_run_logs_calls_arg4 []dto.FindApplicationDeploymentLogsDto
+	find_job_run_logs_return []database.ApplicationJobRunLog
+	find_job_run_logs_err error
 }
 
 func (s *StubApplicationService) Clear() {
@@ -186,6 +259,77 @@ func (s *StubApplicationService) Clear() {
 	s.find_one_config_calls_arg2 = []string{}
 	s.find_one_config_return = nil
 	s.find_one_config_error = nil
+	s.create_deployment_n_calls = 0
+	s.create_deployment_calls_arg1 = []string{}
+	s.create_deployment_calls_arg2 = []string{}
+	s.create_deployment_calls_arg3 = []dto.CreateApplicationDeploymentDto{}
+	s.create_deployment_return = nil
+	s.create_deployment_err = nil
+	s.max_upload_size_return = 0
+	s.find_deployments_n_calls = 0
+	s.find_deployments_return = nil
+	s.find_deployments_err = nil
+	s.find_one_deployment_n_calls = 0
+	s.find_one_deployment_calls_arg1 = []string{}
+	s.find_one_deployment_calls_arg2 = []string{}
+	s.find_one_deployment_return = nil
+	s.find_one_deployment_err = nil
+	s.rollback_deployment_n_calls = 0
+	s.rollback_deployment_calls_arg2 = []string{}
+	s.rollback_deployment_return = nil
+	s.rollback_deployment_err = nil
+	s.update_health_check_calls_arg3 = []dto.UpdateApplicationHealthCheckDto{}
+	s.update_health_check_return = nil
+	s.update_health_check_err = nil
+	s.update_resources_calls_arg3 = []dto.UpdateApplicationResourcesDto{}
+	s.update_resources_return = nil
+	s.update_resources_err = nil
+	s.update_scale_calls_arg3 = []dto.UpdateApplicationScaleDto{}
+	s.update_scale_return = nil
+	s.update_scale_err = nil
+	s.control_calls = []string{}
+	s.control_calls_user_id = []string{}
+	s.control_return = nil
+	s.control_err = nil
+	s.find_deployment_logs_n_calls = 0
+	s.find_deployment_logs_calls_arg4 = []dto.FindApplicationDeploymentLogsDto{}
+	s.find_deployment_logs_return = nil
+	s.find_deployment_logs_err = nil
+	s.follow_deployment_logs_n_calls = 0
+	s.follow_deployment_logs_return = nil
+	s.follow_deployment_logs_err = nil
+	s.follow_deployment_logs_unsubscribed = false
+	s.find_ingress_routes_return = nil
+	s.find_ingress_routes_err = nil
+	s.update_webhook_calls_arg3 = []dto.UpdateApplicationWebhookDto{}
+	s.update_webhook_return = nil
+	s.update_webhook_err = nil
+	s.find_one_webhook_return = nil
+	s.find_one_webhook_err = nil
+	s.find_webhook_deliveries_return = nil
+	s.find_webhook_deliveries_err = nil
+	s.receive_webhook_calls_arg1 = []string{}
+	s.receive_webhook_calls_arg2 = []dto.ReceiveApplicationWebhookDto{}
+	s.receive_webhook_return = nil
+	s.receive_webhook_err = nil
+	s.cancel_deployment_calls_arg2 = []string{}
+	s.cancel_deployment_return = nil
+	s.cancel_deployment_err = nil
+	s.canary_calls = []string{}
+	s.canary_calls_arg2 = []string{}
+	s.update_canary_calls_arg4 = []dto.UpdateApplicationDeploymentCanaryDto{}
+	s.canary_return = nil
+	s.canary_err = nil
+	s.record_canary_traffic_calls_arg1 = [][]dto.CanaryTraffic{}
+	s.update_schedule_calls_arg3 = []dto.UpdateApplicationScheduleDto{}
+	s.update_schedule_return = nil
+	s.update_schedule_err = nil
+	s.find_job_runs_return = nil
+	s.find_job_runs_err = nil
+	s.find_job_run_logs_calls_arg2 = []string{}
+	s.find_job_run_logs_calls_arg4 = []dto.FindApplicationDeploymentLogsDto{}
+	s.find_job_run_logs_return = nil
+	s.find_job_run_logs_err = nil
 }
 
 func (s *StubApplicationService) Create(user_id string, dto dto.CreateApplicationDto) (*database.Application, error) {
@@ -224,6 +368,175 @@ func (s *StubApplicationService) FindOneConfig(app_id string, user_id string) (*
 	return s.find_one_config_return, s.find_one_config_error
 }
 
+func (s *StubApplicationService) CreateDeployment(app_id string, user_id string, dto dto.CreateApplicationDeploymentDto) (*database.ApplicationDeployment, error) {
+	s.create_deployment_n_calls += 1
+	s.create_deployment_calls_arg1 = append(s.create_deployment_calls_arg1, app_id)
+	s.create_deployment_calls_arg2 = append(s.create_deployment_calls_arg2, user_id)
+	s.create_deployment_calls_arg3 = append(s.create_deployment_calls_arg3, dto)
+	return s.create_deployment_return, s.create_deployment_err
+}
+
+func (s *StubApplicationService) FindDeployments(app_id string, user_id string) ([]database.ApplicationDeployment, error) {
+	s.find_deployments_n_calls += 1
+	return s.find_deployments_return, s.find_deployments_err
+}
+
+func (s *StubApplicationService) FindOneDeployment(app_id string, dp_id string, user_id string) (*dto.ApplicationDeploymentResponse, error) {
+	s.find_one_deployment_n_calls += 1
+	s.find_one_deployment_calls_arg1 = append(s.find_one_deployment_calls_arg1, app_id)
+	s.find_one_deployment_calls_arg2 = append(s.find_one_deployment_calls_arg2, dp_id)
+	return s.find_one_deployment_return, s.find_one_deployment_err
+}
+
+func (s *StubApplicationService) RollbackDeployment(app_id string, dp_id string, user_id string) (*database.ApplicationDeployment, error) {
+	s.rollback_deployment_n_calls += 1
+	s.rollback_deployment_calls_arg2 = append(s.rollback_deployment_calls_arg2, dp_id)
+	return s.rollback_deployment_return, s.rollback_deployment_err
+}
+
+func (s *StubApplicationService) UpdateHealthCheck(app_id string, user_id string, dto dto.UpdateApplicationHealthCheckDto) (*database.Application, error) {
+	s.update_health_check_calls_arg3 = append(s.update_health_check_calls_arg3, dto)
+	return s.update_health_check_return, s.update_health_check_err
+}
+
+func (s *StubApplicationService) UpdateResources(app_id string, user_id string, dto dto.UpdateApplicationResourcesDto) (*database.Application, error) {
+	s.update_resources_calls_arg3 = append(s.update_resources_calls_arg3, dto)
+	return s.update_resources_return, s.update_resources_err
+}
+
+func (s *StubApplicationService) UpdateScale(app_id string, user_id string, dto dto.UpdateApplicationScaleDto) ([]database.ApplicationProcessScale, error) {
+	s.update_scale_calls_arg3 = append(s.update_scale_calls_arg3, dto)
+	return s.update_scale_return, s.update_scale_err
+}
+
+func (s *StubApplicationService) control(action string, user_id string) (*dto.ApplicationControlResponse, error) {
+	s.control_calls = append(s.control_calls, action)
+	s.control_calls_user_id = append(s.control_calls_user_id, user_id)
+	return s.control_return, s.control_err
+}
+
+func (s *StubApplicationService) Stop(app_id string, user_id string) (*dto.ApplicationControlResponse, error) {
+	return s.control("stop", user_id)
+}
+
+func (s *StubApplicationService) Start(app_id string, user_id string) (*dto.ApplicationControlResponse, error) {
+	return s.control("start", user_id)
+}
+
+func (s *StubApplicationService) Restart(app_id string, user_id string) (*dto.ApplicationControlResponse, error) {
+	return s.control("restart", user_id)
+}
+
+// FindDeploymentLogs only returns stored lines newer than dto.After, the way
+// the paginated query does.
+func (s *StubApplicationService) FindDeploymentLogs(app_id string, dp_id string, user_id string, dto dto.FindApplicationDeploymentLogsDto) ([]database.ApplicationDeploymentLog, error) {
+	s.find_deployment_logs_n_calls += 1
+	s.find_deployment_logs_calls_arg4 = append(s.find_deployment_logs_calls_arg4, dto)
+	if s.find_deployment_logs_err != nil {
+		return nil, s.find_deployment_logs_err
+	}
+
+	logs := []database.ApplicationDeploymentLog{}
+	for _, log := range s.find_deployment_logs_return {
+		if log.AppDpLogID > dto.After && len(logs) < int(dto.Limit) {
+			logs = append(logs, log)
+		}
+	}
+	return logs, nil
+}
+
+func (s *StubApplicationService) FollowDeploymentLogs(app_id string, dp_id string, user_id string) (<-chan database.ApplicationDeploymentLog, func(), error) {
+	s.follow_deployment_logs_n_calls += 1
+	if s.follow_deployment_logs_err != nil {
+		return nil, nil, s.follow_deployment_logs_err
+	}
+	return s.follow_deployment_logs_return, func() { s.follow_deployment_logs_unsubscribed = true }, nil
+}
+
+func (s *StubApplicationService) FindIngressRoutes() ([]dto.IngressRoute, error) {
+	return s.find_ingress_routes_return, s.find_ingress_routes_err
+}
+
+func (s *StubApplicationService) UpdateWebhook(app_id string, user_id string, dto dto.UpdateApplicationWebhookDto) (*dto.ApplicationWebhookResponse, error) {
+	s.update_webhook_calls_arg3 = append(s.update_webhook_calls_arg3, dto)
+	return s.update_webhook_return, s.update_webhook_err
+}
+
+func (s *StubApplicationService) FindOneWebhook(app_id string, user_id string) (*dto.ApplicationWebhookResponse, error) {
+	return s.find_one_webhook_return, s.find_one_webhook_err
+}
+
+func (s *StubApplicationService) FindWebhookDeliveries(app_id string, user_id string) ([]database.ApplicationWebhookDelivery, error) {
+	return s.find_webhook_deliveries_return, s.find_webhook_deliveries_err
+}
+
+func (s *StubApplicationService) ReceiveWebhook(app_id string, dto dto.ReceiveApplicationWebhookDto) (*database.ApplicationWebhookDelivery, error) {
+	s.receive_webhook_calls_arg1 = append(s.receive_webhook_calls_arg1, app_id)
+	s.receive_webhook_calls_arg2 = append(s.receive_webhook_calls_arg2, dto)
+	return s.receive_webhook_return, s.receive_webhook_err
+}
+
+func (s *StubApplicationService) MaxUploadSize() int64 {
+	if s.max_upload_size_return > 0 {
+		return s.max_upload_size_return
+	}
+	return runtime.DefaultExtractLimits().MaxUploadSize
+}
+
+func (s *StubApplicationService) WatchDeploymentChanges() (<-chan struct{}, func()) {
+	return make(chan struct{}), func() {}
+}
+
+func (s *StubApplicationService) CancelDeployment(app_id string, dp_id string, user_id string) (*database.ApplicationDeployment, error) {
+	s.cancel_deployment_calls_arg2 = append(s.cancel_deployment_calls_arg2, dp_id)
+	return s.cancel_deployment_return, s.cancel_deployment_err
+}
+
+func (s *StubApplicationService) UpdateCanary(app_id string, dp_id string, user_id string, dto dto.UpdateApplicationDeploymentCanaryDto) (*database.ApplicationDeployment, error) {
+	s.canary_calls = append(s.canary_calls, "update")
+	s.canary_calls_arg2 = append(s.canary_calls_arg2, dp_id)
+	s.update_canary_calls_arg4 = append(s.update_canary_calls_arg4, dto)
+	return s.canary_return, s.canary_err
+}
+
+func (s *StubApplicationService) PromoteCanary(app_id string, dp_id string, user_id string) (*database.ApplicationDeployment, error) {
+	s.canary_calls = append(s.canary_calls, "promote")
+	s.canary_calls_arg2 = append(s.canary_calls_arg2, dp_id)
+	return s.canary_return, s.canary_err
+}
+
+func (s *StubApplicationService) AbortCanary(app_id string, dp_id string, user_id string) (*database.ApplicationDeployment, error) {
+	s.canary_calls = append(s.canary_calls, "abort")
+	s.canary_calls_arg2 = append(s.canary_calls_arg2, dp_id)
+	return s.canary_return, s.canary_err
+}
+
+func (s *StubApplicationService) RecordCanaryTraffic(traffic []dto.CanaryTraffic) error {
+	s.record_canary_traffic_calls_arg1 = append(s.record_canary_traffic_calls_arg1, traffic)
+	return nil
+}
+
+func (s *StubApplicationService) RunDeploymentWorkers(ctx context.Context) {}
+
+func (s *StubApplicationService) RunReconciler(ctx context.Context, interval time.Duration) {}
+
+func (s *StubApplicationService) UpdateSchedule(app_id string, user_id string, dto dto.UpdateApplicationScheduleDto) (*database.Application, error) {
+	s.update_schedule_calls_arg3 = append(s.update_schedule_calls_arg3, dto)
+	return s.update_schedule_return, s.update_schedule_err
+}
+
+func (s *StubApplicationService) FindJobRuns(app_id string, user_id string) ([]database.ApplicationJobRun, error) {
+	return s.find_job_runs_return, s.find_job_runs_err
+}
+
+func (s *StubApplicationService) FindJobRunLogs(app_id string, run_id string, user_id string, dto dto.FindApplicationDeploymentLogsDto) ([]database.ApplicationJobRunLog, error) {
+	s.find_job_run_logs_calls_arg2 = append(s.find_job_run_logs_calls_arg2, run_id)
+	s.find_job_run_logs_calls_arg4 = append(s.find_job_run_logs_calls_arg4, dto)
+	return s.find_job_run_logs_return, s.find_job_run_logs_err
+}
+
+func (s *StubApplicationService) RunScheduler(ctx context.Context, interval time.Duration) {}
+
 type StubJwtValidator struct {
 	validate_return string
 	validate_error error